@@ -0,0 +1,122 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
+)
+
+// query parameters logged as sent, every other value is redacted. Bodies and
+// headers are never logged, they carry transactions, signatures and secrets.
+var accessLogQueryParams = []string{"approvalId", "status", "tenant", "types"}
+
+// AccessLogEntry is everything an access log line can hold. Only these
+// fields are ever written, so adding a handler can't leak a body into it.
+type AccessLogEntry struct {
+	Method  string
+	Path    string
+	Query   string
+	Status  int
+	Bytes   int
+	Latency time.Duration
+	Remote  string
+
+	// who the request authenticated as, e.g. operator:alice,token:tok_1
+	Caller string
+}
+
+// Attrs are the entry's log fields
+func (e AccessLogEntry) Attrs() []slog.Attr {
+	caller := e.Caller
+	if caller == "" {
+		caller = "-"
+	}
+	return []slog.Attr{logHTTP,
+		slog.String("method", e.Method),
+		slog.String("path", e.Path),
+		slog.String("query", e.Query),
+		slog.Int("status", e.Status),
+		slog.Int("bytes", e.Bytes),
+		slog.Duration("latency", e.Latency.Round(time.Microsecond)),
+		slog.String("remote", e.Remote),
+		slog.String("caller", caller),
+	}
+}
+
+// redactQuery keeps the allowlisted parameters and blanks the rest
+func redactQuery(values url.Values) string {
+	if len(values) == 0 {
+		return ""
+	}
+	redacted := url.Values{}
+	for name, vs := range values {
+		if !slices.Contains(accessLogQueryParams, name) {
+			vs = []string{"REDACTED"}
+		}
+		redacted[name] = vs
+	}
+	return redacted.Encode()
+}
+
+type accessCallerCtxKey struct{}
+
+// withAccessLog logs one line per request once it's done. The caller is
+// filled in by recordCaller after authentication, requests turned away
+// before it log w/o one.
+func (s *APIServer) withAccessLog(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		caller := new(string)
+		rec := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(rec, r.WithContext(context.WithValue(r.Context(), accessCallerCtxKey{}, caller)))
+
+		entry := AccessLogEntry{
+			Method:  r.Method,
+			Path:    r.URL.Path,
+			Query:   redactQuery(r.URL.Query()),
+			Status:  rec.status,
+			Bytes:   rec.bytes,
+			Latency: time.Since(start),
+			Remote:  clientIP(r).String(),
+			Caller:  *caller,
+		}
+		slog.LogAttrs(r.Context(), slog.LevelInfo, "ACCESS", entry.Attrs()...)
+	})
+}
+
+// recordCaller notes who the request authenticated as for the access log,
+// it must run after the auth middleware
+func (s *APIServer) recordCaller(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if caller, ok := r.Context().Value(accessCallerCtxKey{}).(*string); ok {
+			*caller = s.callerIdentity(r)
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// callerIdentity names every identity the request proved
+func (s *APIServer) callerIdentity(r *http.Request) string {
+	var ids []string
+	if op := OperatorFromContext(r.Context()); op.Name != "" {
+		ids = append(ids, "operator:"+op.Name)
+	}
+	if principal := PrincipalFromContext(r.Context()); principal != "" {
+		ids = append(ids, "mtls:"+principal)
+	}
+	// withHMAC refused the request already if the signature didn't check out
+	if client := r.Header.Get("X-STS-Client"); client != "" && s.HMAC != nil {
+		ids = append(ids, "hmac:"+client)
+	}
+	if token := APITokenFromContext(r.Context()); token != "" {
+		ids = append(ids, "token:"+token)
+	}
+	if tenant := TenantFromContext(r.Context()); tenant != defaultTenant {
+		ids = append(ids, "tenant:"+tenant)
+	}
+	return strings.Join(ids, ",")
+}
@@ -0,0 +1,155 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+)
+
+type operatorCtxKey struct{}
+
+// Operator is an authenticated admin, roles scope which approvals they may decide
+type Operator struct {
+	Name  string   `json:"name"`
+	Roles []string `json:"roles,omitempty"`
+}
+
+func (o Operator) HasRole(role string) bool {
+	return slices.Contains(o.Roles, role)
+}
+
+// WithOperator attaches the authenticated admin operator to the context
+func WithOperator(ctx context.Context, operator Operator) context.Context {
+	return context.WithValue(ctx, operatorCtxKey{}, operator)
+}
+
+// OperatorFromContext returns the operator, zero for unauthenticated calls
+func OperatorFromContext(ctx context.Context) Operator {
+	operator, _ := ctx.Value(operatorCtxKey{}).(Operator)
+	return operator
+}
+
+type operatorToken struct {
+	operator Operator
+	digest   [sha256.Size]byte
+}
+
+// OperatorRegistry authenticates admin operators by bearer token. Only token
+// digests are kept so the comparison is constant time regardless of length.
+type OperatorRegistry struct {
+	tokens []operatorToken
+}
+
+// ParseOperators reads comma separated name:token entries, optionally
+// followed by :role1|role2
+func ParseOperators(raw string) (*OperatorRegistry, error) {
+	reg := &OperatorRegistry{}
+	seen := make(map[string]bool)
+
+	for i, pair := range strings.Split(raw, ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+		name, rest, ok := strings.Cut(pair, ":")
+		token, roles, _ := strings.Cut(rest, ":")
+		if !ok || name == "" || token == "" {
+			// don't echo the entry, it may hold a token
+			return nil, fmt.Errorf("invalid operator entry %d, expected name:token[:roles]", i+1)
+		}
+		if seen[name] {
+			return nil, fmt.Errorf("duplicate operator %q", name)
+		}
+		seen[name] = true
+
+		op := Operator{Name: name}
+		if roles != "" {
+			op.Roles = strings.Split(roles, "|")
+		}
+		reg.tokens = append(reg.tokens, operatorToken{operator: op, digest: sha256.Sum256([]byte(token))})
+	}
+	return reg, nil
+}
+
+// adminEnabled reports whether anyone could authenticate as an operator
+func (s *APIServer) adminEnabled() bool {
+	return s.Operators.Len() > 0 || s.OIDC != nil
+}
+
+func (o *OperatorRegistry) Len() int {
+	if o == nil {
+		return 0
+	}
+	return len(o.tokens)
+}
+
+// Authenticate returns the operator for the request's bearer token
+func (o *OperatorRegistry) Authenticate(r *http.Request) (Operator, bool) {
+	token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if o == nil || !ok || token == "" {
+		return Operator{}, false
+	}
+
+	digest := sha256.Sum256([]byte(token))
+	var found Operator
+	for _, t := range o.tokens {
+		if subtle.ConstantTimeCompare(digest[:], t.digest[:]) == 1 {
+			found = t.operator
+		}
+	}
+	return found, found.Name != ""
+}
+
+// withOperator tags requests carrying a valid operator token. It only rejects
+// callers locked out after too many bad tokens.
+func (s *APIServer) withOperator(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !strings.HasPrefix(r.Header.Get("Authorization"), "Bearer ") {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		// the token names nobody until it checks out, so failures count per address
+		identity := "bearer@" + clientIP(r).String()
+		if wait := s.Lockout.Locked(identity, time.Now()); wait > 0 {
+			tooManyAttempts(w, r, wait)
+			return
+		}
+
+		operator, ok := s.Operators.Authenticate(r)
+		if token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer "); !ok && s.OIDC != nil && looksLikeJWT(token) {
+			var err error
+			if operator, err = s.OIDC.Verify(r.Context(), token, time.Now()); err != nil {
+				audit(r.Context(), logAdmin, "Rejected OIDC token", "remote", clientIP(r).String(), "err", err)
+			}
+			ok = err == nil
+		}
+
+		if ok {
+			s.Lockout.Success(identity)
+			r = r.WithContext(WithOperator(r.Context(), operator))
+		} else {
+			s.Lockout.Failure(identity, time.Now())
+			s.Compliance.AuthFailed("bearer")
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// requireOperator guards admin endpoints
+func requireOperator(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if OperatorFromContext(r.Context()).Name == "" {
+			audit(r.Context(), logAdmin, "Unauthenticated admin request", "method", r.Method, "path", r.URL.Path, "remote", clientIP(r).String())
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			writeError(w, r, http.StatusUnauthorized, errOperatorRequired)
+			return
+		}
+		next(w, r)
+	}
+}
@@ -0,0 +1,168 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+)
+
+func (s *APIServer) handleKillSwitch(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	var req KillRequest
+	if err := decodeJSON(r.Body, &req); err != nil {
+		writeError(w, r, http.StatusBadRequest, invalidBody(err))
+		return
+	}
+
+	report, err := s.KillSwitch.Trigger(r.Context(), req)
+	if err != nil {
+		writeError(w, r, http.StatusBadRequest, err)
+		return
+	}
+
+	json.NewEncoder(w).Encode(report)
+}
+
+func (s *APIServer) handleSealStatus(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	json.NewEncoder(w).Encode(s.KillSwitch.seal.Status())
+}
+
+// handleSeal seals for maintenance and wipes the keys in scope
+func (s *APIServer) handleSeal(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	var req KillRequest
+	if err := decodeJSON(r.Body, &req); err != nil {
+		writeError(w, r, http.StatusBadRequest, invalidBody(err))
+		return
+	}
+
+	report, err := s.KillSwitch.Seal(r.Context(), req)
+	if err != nil {
+		writeError(w, r, http.StatusBadRequest, err)
+		return
+	}
+
+	json.NewEncoder(w).Encode(report)
+}
+
+type UnsealRequest struct {
+	// empty unseals the service, sealed namespaces stay sealed
+	Namespace string `json:"namespace,omitempty"`
+}
+
+func (s *APIServer) handleUnseal(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	var req UnsealRequest
+	if err := decodeJSON(r.Body, &req); err != nil && err != io.EOF {
+		writeError(w, r, http.StatusBadRequest, invalidBody(err))
+		return
+	}
+
+	if _, err := s.KillSwitch.Unseal(r.Context(), req.Namespace); err != nil {
+		writeError(w, r, http.StatusConflict, err)
+		return
+	}
+
+	json.NewEncoder(w).Encode(s.KillSwitch.seal.Status())
+}
+
+type FreezeRequest struct {
+	Reason string `json:"reason"`
+}
+
+func (s *APIServer) handleKeyFreeze(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	var req FreezeRequest
+	if err := decodeJSON(r.Body, &req); err != nil {
+		writeError(w, r, http.StatusBadRequest, invalidBody(err))
+		return
+	}
+	if req.Reason == "" {
+		writeError(w, r, http.StatusBadRequest, errors.New("reason cannot be empty"))
+		return
+	}
+
+	info, err := s.Store.Freeze(r.Context(), r.PathValue("id"), req.Reason, OperatorFromContext(r.Context()).Name)
+	if err != nil {
+		writeError(w, r, http.StatusNotFound, err)
+		return
+	}
+
+	json.NewEncoder(w).Encode(info)
+}
+
+func (s *APIServer) handleKeyUnfreeze(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	info, err := s.Store.Unfreeze(r.Context(), r.PathValue("id"), OperatorFromContext(r.Context()).Name)
+	if err != nil {
+		writeError(w, r, http.StatusNotFound, err)
+		return
+	}
+
+	json.NewEncoder(w).Encode(info)
+}
+
+// handleKeyDelete zeroizes a key, 409 while approvals for it may still sign
+func (s *APIServer) handleKeyDelete(w http.ResponseWriter, r *http.Request) {
+	if err := s.Service.DestroyKey(r.Context(), r.PathValue("id")); err != nil {
+		status := http.StatusInternalServerError
+		switch {
+		case errors.Is(err, errKeyNotFound):
+			status = http.StatusNotFound
+		case errors.Is(err, errKeyInUse):
+			status = http.StatusConflict
+		}
+		writeError(w, r, status, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *APIServer) handleHeartbeat(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	json.NewEncoder(w).Encode(s.DeadMan.CheckIn(OperatorFromContext(r.Context()).Name))
+}
+
+func (s *APIServer) handleHeartbeatStatus(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	json.NewEncoder(w).Encode(s.DeadMan.Status())
+}
+
+func (s *APIServer) handleGrantMint(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	var req GrantRequest
+	if err := decodeJSON(r.Body, &req); err != nil {
+		writeError(w, r, http.StatusBadRequest, invalidBody(err))
+		return
+	}
+
+	grant, err := s.Grants.Mint(req, OperatorFromContext(r.Context()).Name)
+	if err != nil {
+		writeError(w, r, http.StatusBadRequest, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(grant)
+}
+
+func (s *APIServer) handleGrantRevoke(w http.ResponseWriter, r *http.Request) {
+	if err := s.Grants.Revoke(r.PathValue("id"), OperatorFromContext(r.Context()).Name); err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		writeError(w, r, http.StatusNotFound, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
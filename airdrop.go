@@ -0,0 +1,205 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// clusters, told apart by their genesis hash
+const (
+	ClusterMainnet = "mainnet-beta"
+	ClusterDevnet  = "devnet"
+	ClusterTestnet = "testnet"
+	ClusterCustom  = "custom"
+)
+
+var clusterGenesis = map[string]string{
+	"5eykt4UsFv8P8NJdTREpY1vzqKqZKvdpKuc147dw2N9d": ClusterMainnet,
+	"EtWTRABZaYq6iMfeYKouRu166VU2xqa1wcaWoxPkrZBG": ClusterDevnet,
+	"4uhcVJyU9pJkvQyS88uRDiswHXSCkY3zQawwpjk2NsNY": ClusterTestnet,
+}
+
+// clusterOf names the cluster w/ the genesis hash, a local validator or
+// private cluster is custom
+func clusterOf(genesis string) string {
+	if name, ok := clusterGenesis[genesis]; ok {
+		return name
+	}
+	return ClusterCustom
+}
+
+const (
+	// 1 SOL unless asked otherwise
+	defaultAirdropLamports = 1_000_000_000
+
+	// public faucets refuse more than a few SOL at once
+	maxAirdropLamports = 5_000_000_000
+
+	// how long an airdrop request waits for the faucet's transaction
+	airdropConfirmTimeout = 30 * time.Second
+)
+
+var errAirdropMainnet = errors.New("airdrops are only for devnet, testnet and local clusters")
+
+type AirdropRequest struct {
+	// defaults to 1 SOL
+	Lamports uint64 `json:"lamports,omitempty"`
+}
+
+// AirdropResult is the faucet's transaction, Status stays broadcast when it
+// didn't confirm in time
+type AirdropResult struct {
+	KeyID     string `json:"keyId"`
+	Address   string `json:"address"`
+	Lamports  uint64 `json:"lamports"`
+	Signature string `json:"signature"`
+	Status    string `json:"status"`
+	Slot      uint64 `json:"slot,omitempty"`
+}
+
+// Airdropper funds stored keys from the cluster's faucet for integration
+// tests, never on mainnet
+type Airdropper struct {
+	store *SecureKeyStore
+	chain ChainClient
+
+	// looked up once, an endpoint doesn't change clusters
+	cluster string
+
+	mu sync.Mutex
+}
+
+// constructor
+func NewAirdropper(store *SecureKeyStore, chain ChainClient) *Airdropper {
+	return &Airdropper{store: store, chain: chain}
+}
+
+// Cluster is the name of the cluster the rpc serves
+func (a *Airdropper) Cluster(ctx context.Context) (string, error) {
+	a.mu.Lock()
+	cluster := a.cluster
+	a.mu.Unlock()
+	if cluster != "" {
+		return cluster, nil
+	}
+
+	genesis, err := a.chain.GenesisHash(ctx)
+	if err != nil {
+		return "", fmt.Errorf("%w: %v", errRPCUnavailable, err)
+	}
+	cluster = clusterOf(genesis)
+	a.mu.Lock()
+	a.cluster = cluster
+	a.mu.Unlock()
+	return cluster, nil
+}
+
+// Airdrop requests lamports for the key and waits for the transaction to
+// confirm, up to airdropConfirmTimeout
+func (a *Airdropper) Airdrop(ctx context.Context, keyID string, lamports uint64) (AirdropResult, error) {
+	if lamports == 0 {
+		lamports = defaultAirdropLamports
+	}
+	if lamports > maxAirdropLamports {
+		return AirdropResult{}, fmt.Errorf("at most %d lamports per airdrop", uint64(maxAirdropLamports))
+	}
+	addr, err := keyAddress(a.store, keyID)
+	if err != nil {
+		return AirdropResult{}, err
+	}
+	cluster, err := a.Cluster(ctx)
+	if err != nil {
+		return AirdropResult{}, err
+	}
+	if cluster == ClusterMainnet {
+		return AirdropResult{}, errAirdropMainnet
+	}
+
+	result := AirdropResult{KeyID: keyID, Address: addr.String(), Lamports: lamports, Status: TxStatusBroadcast}
+	if result.Signature, err = a.chain.RequestAirdrop(ctx, result.Address, lamports); err != nil {
+		return AirdropResult{}, fmt.Errorf("%w: airdrop refused: %v", errRPCUnavailable, err)
+	}
+	audit(ctx, logSigner, "Airdrop requested", "key_id", keyID, "lamports", lamports, "cluster", cluster, "signature", result.Signature)
+
+	waitCtx, cancel := context.WithTimeout(ctx, airdropConfirmTimeout)
+	defer cancel()
+
+	status, err := waitForCommitment(waitCtx, a.chain, result.Signature, TxStatusConfirmed)
+	if err != nil {
+		return result, err
+	}
+	if status != nil {
+		result.Slot = status.Slot
+		result.Status = TxStatusConfirmed
+		if status.Failed() {
+			result.Status = TxStatusFailed
+		}
+	}
+	return result, nil
+}
+
+// waitForCommitment polls the cluster until the transaction reaches level
+// or fails, nil when ctx is done first
+func waitForCommitment(ctx context.Context, chain ChainClient, sig, level string) (*SignatureStatus, error) {
+	ticker := time.NewTicker(500 * time.Millisecond)
+	defer ticker.Stop()
+
+	for {
+		statuses, err := chain.SignatureStatuses(ctx, []string{sig})
+		if err != nil && ctx.Err() == nil {
+			return nil, err
+		}
+		if err == nil {
+			if s := statuses[0]; s != nil && (s.Failed() || txStatusRank[s.ConfirmationStatus] >= txStatusRank[level]) {
+				return s, nil
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil, nil
+		case <-ticker.C:
+		}
+	}
+}
+
+// handleAirdrop funds a key off mainnet, for operators and API tokens
+// scoped to sign w/ the key
+func (s *APIServer) handleAirdrop(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+	var req AirdropRequest
+	// the body is optional, the amount defaults
+	if err := decodeJSON(r.Body, &req); err != nil && !errors.Is(err, io.EOF) {
+		writeError(w, r, http.StatusBadRequest, invalidBody(err))
+		return
+	}
+	if OperatorFromContext(r.Context()).Name == "" {
+		if err := s.checkAPIToken(r, TokenOpSign, id); err != nil {
+			refuseAPIToken(w, r, err)
+			return
+		}
+	}
+
+	result, err := s.Airdrops.Airdrop(r.Context(), id, req.Lamports)
+	if err != nil {
+		status := http.StatusBadRequest
+		switch {
+		case errors.Is(err, errKeyNotFound):
+			status = http.StatusNotFound
+		case errors.Is(err, errAirdropMainnet):
+			status = http.StatusForbidden
+		case errors.Is(err, errRPCUnavailable):
+			status = http.StatusBadGateway
+		}
+		writeError(w, r, status, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(result)
+}
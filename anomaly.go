@@ -0,0 +1,225 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// what to do w/ an anomalous sign request
+const (
+	AnomalyAlert = "alert"
+	AnomalyBlock = "block"
+)
+
+var errAnomalous = errors.New("sign request is anomalous for this key")
+
+type AnomalyConfig struct {
+	// alert signs and notifies, block refuses and notifies
+	Action string
+
+	// standard deviations from the baseline before a request is flagged
+	ZScore float64
+
+	// observations needed before a key's baseline is trusted
+	MinSamples int
+
+	// signatures per minute that are never flagged as a burst
+	MinBurst int
+}
+
+func DefaultAnomalyConfig() AnomalyConfig {
+	return AnomalyConfig{Action: AnomalyAlert, ZScore: 4, MinSamples: 20, MinBurst: 10}
+}
+
+// AnomalyConfigFromEnv reads STS_ANOMALY_ACTION, _ZSCORE, _MIN_SAMPLES and
+// _MIN_BURST on top of the defaults
+func AnomalyConfigFromEnv(getenv func(string) string) (AnomalyConfig, error) {
+	cfg := DefaultAnomalyConfig()
+
+	if action := getenv("STS_ANOMALY_ACTION"); action != "" {
+		if action != AnomalyAlert && action != AnomalyBlock {
+			return cfg, fmt.Errorf("unknown anomaly action %q", action)
+		}
+		cfg.Action = action
+	}
+	if raw := getenv("STS_ANOMALY_ZSCORE"); raw != "" {
+		z, err := strconv.ParseFloat(raw, 64)
+		if err != nil || z <= 0 {
+			return cfg, fmt.Errorf("invalid STS_ANOMALY_ZSCORE %q", raw)
+		}
+		cfg.ZScore = z
+	}
+	for name, dst := range map[string]*int{"STS_ANOMALY_MIN_SAMPLES": &cfg.MinSamples, "STS_ANOMALY_MIN_BURST": &cfg.MinBurst} {
+		if raw := getenv(name); raw != "" {
+			n, err := strconv.Atoi(raw)
+			if err != nil || n < 0 {
+				return cfg, fmt.Errorf("invalid %s %q", name, raw)
+			}
+			*dst = n
+		}
+	}
+	return cfg, nil
+}
+
+// Validate refuses thresholds that would flag everything or nothing
+func (c AnomalyConfig) Validate() error {
+	if c.Action != AnomalyAlert && c.Action != AnomalyBlock {
+		return fmt.Errorf("unknown anomaly action %q", c.Action)
+	}
+	if c.ZScore <= 0 {
+		return fmt.Errorf("anomaly z-score must be positive, got %v", c.ZScore)
+	}
+	if c.MinSamples < 0 || c.MinBurst < 0 {
+		return errors.New("anomaly min samples and min burst can't be negative")
+	}
+	return nil
+}
+
+// runningStats is Welford's online mean and variance
+type runningStats struct {
+	n    int
+	mean float64
+	m2   float64
+}
+
+func (r *runningStats) add(x float64) {
+	r.n++
+	delta := x - r.mean
+	r.mean += delta / float64(r.n)
+	r.m2 += delta * (x - r.mean)
+}
+
+// zscore of x, the deviation is floored so a perfectly regular key doesn't
+// flag every tiny change
+func (r *runningStats) zscore(x, minStd float64) float64 {
+	std := minStd
+	if r.n > 1 {
+		std = math.Max(minStd, math.Sqrt(r.m2/float64(r.n-1)))
+	}
+	return (x - r.mean) / std
+}
+
+type keyBaseline struct {
+	// log of lamports per transaction
+	amounts runningStats
+
+	// signatures per active minute
+	rates runningStats
+
+	minute      time.Time
+	minuteCount int
+}
+
+type AnomalyFinding struct {
+	KeyID  string  `json:"keyId"`
+	Kind   string  `json:"kind"`
+	Value  float64 `json:"value"`
+	ZScore float64 `json:"zScore"`
+}
+
+func (f AnomalyFinding) String() string {
+	return fmt.Sprintf("%s of %.0f is %.1f standard deviations above the key's baseline", f.Kind, f.Value, f.ZScore)
+}
+
+// AnomalyDetector learns per key signing rates and amounts and flags
+// requests far outside them
+type AnomalyDetector struct {
+	config    AnomalyConfig
+	baselines map[string]*keyBaseline
+
+	mu sync.Mutex
+}
+
+// constructor
+func NewAnomalyDetector(config AnomalyConfig) *AnomalyDetector {
+	return &AnomalyDetector{config: config, baselines: make(map[string]*keyBaseline)}
+}
+
+// Evaluate checks a request of lamports about to be signed at now
+func (d *AnomalyDetector) Evaluate(keyID string, lamports uint64, now time.Time) *AnomalyFinding {
+	d.mu.Lock()
+
+	defer d.mu.Unlock()
+
+	b, ok := d.baselines[keyID]
+	if !ok {
+		return nil
+	}
+
+	if count := b.countAt(now) + 1; count > d.config.MinBurst && b.rates.n >= d.config.MinSamples {
+		if z := b.rates.zscore(float64(count), 1); z > d.config.ZScore {
+			return &AnomalyFinding{KeyID: keyID, Kind: "signatures per minute", Value: float64(count), ZScore: z}
+		}
+	}
+
+	if lamports > 0 && b.amounts.n >= d.config.MinSamples {
+		if z := b.amounts.zscore(math.Log1p(float64(lamports)), 0.5); z > d.config.ZScore {
+			return &AnomalyFinding{KeyID: keyID, Kind: "lamports per transaction", Value: float64(lamports), ZScore: z}
+		}
+	}
+	return nil
+}
+
+// Record adds a produced signature to the key's baseline
+func (d *AnomalyDetector) Record(keyID string, lamports uint64, now time.Time) {
+	d.mu.Lock()
+
+	defer d.mu.Unlock()
+
+	b, ok := d.baselines[keyID]
+	if !ok {
+		b = &keyBaseline{}
+		d.baselines[keyID] = b
+	}
+
+	minute := now.Truncate(time.Minute)
+	if !minute.Equal(b.minute) {
+		// only active minutes count, idle time says nothing about bursts
+		if b.minuteCount > 0 {
+			b.rates.add(float64(b.minuteCount))
+		}
+		b.minute, b.minuteCount = minute, 0
+	}
+	b.minuteCount++
+
+	if lamports > 0 {
+		b.amounts.add(math.Log1p(float64(lamports)))
+	}
+}
+
+func (b *keyBaseline) countAt(now time.Time) int {
+	if now.Truncate(time.Minute).Equal(b.minute) {
+		return b.minuteCount
+	}
+	return 0
+}
+
+// Forget drops the baseline of a destroyed key
+func (d *AnomalyDetector) Forget(keyID string) {
+	d.mu.Lock()
+
+	defer d.mu.Unlock()
+
+	delete(d.baselines, keyID)
+}
+
+func (d *AnomalyDetector) blocks() bool {
+	d.mu.Lock()
+
+	defer d.mu.Unlock()
+
+	return d.config.Action == AnomalyBlock
+}
+
+// SetConfig swaps the thresholds, baselines are kept
+func (d *AnomalyDetector) SetConfig(config AnomalyConfig) {
+	d.mu.Lock()
+
+	defer d.mu.Unlock()
+
+	d.config = config
+}
@@ -0,0 +1,201 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+)
+
+// ErrorResponse is the body of every API error. Code is stable and safe to
+// branch on, Message is for humans and may change.
+type ErrorResponse struct {
+	Code      string            `json:"code"`
+	Message   string            `json:"message"`
+	Details   map[string]string `json:"details,omitempty"`
+	RequestID string            `json:"request_id,omitempty"`
+}
+
+// errors raised by the HTTP layer itself
+var (
+	errInvalidBody        = errors.New("invalid request body")
+	errOperatorRequired   = errors.New("operator authentication required")
+	errTooManyAttempts    = errors.New("too many failed authentication attempts")
+	errRequestRateLimited = errors.New("rate limit exceeded")
+	errSignTimeout        = errors.New("transaction signing timed out")
+)
+
+// errorCodes maps sentinel errors to their codes, first match wins
+var errorCodes = []struct {
+	err  error
+	code string
+}{
+	{errBodyTooLarge, "body_too_large"},
+	{errRequestTimeout, "request_timeout"},
+	{errUnknownField, "unknown_field"},
+	{errFieldType, "invalid_field_type"},
+	{errFieldMissing, "missing_field"},
+	{errInvalidBody, "invalid_body"},
+	{errNoRoute, "route_not_found"},
+	{errMethodNotAllowed, "method_not_allowed"},
+	{errNotAcceptable, "not_acceptable"},
+	{errOperatorRequired, "operator_required"},
+	{errTooManyAttempts, "auth_locked_out"},
+	{errRequestRateLimited, "rate_limited"},
+	{errSignTimeout, "sign_timeout"},
+	{errCORSOrigin, "cors_origin_not_allowed"},
+	{errKeyTypeRequired, "key_type_required"},
+	{errPolicyRequired, "policy_required"},
+	{errSealed, "service_sealed"},
+	{errNotSealed, "not_sealed"},
+	{errKeyFrozen, "key_frozen"},
+	{errKeyInUse, "key_in_use"},
+	{errKeyUsesExhausted, "key_uses_exhausted"},
+	{errNotFIPSApproved, "not_fips_approved"},
+	{errFeatureDisabled, "feature_disabled"},
+	{errRateLimited, "key_rate_limited"},
+	{errReplay, "replayed_request"},
+	{errIdempotencyConflict, "idempotency_key_reused"},
+	{errAnomalous, "anomalous_request"},
+	{errSigningWindow, "outside_signing_window"},
+	{errSpendingLimit, "spending_limit_exceeded"},
+	{errPolicyViolation, "policy_violation"},
+	{errCallerNotAllowed, "caller_not_allowed"},
+	{errGrantRequired, "grant_required"},
+	{errGrantInvalid, "grant_invalid"},
+	{errCapabilityInvalid, "capability_invalid"},
+	{errCapabilityDenied, "capability_denied"},
+	{errAPITokenInvalid, "api_token_invalid"},
+	{errAPITokenDenied, "api_token_denied"},
+	{errBadRequestSignature, "request_signature_invalid"},
+	{errApprovalsDisabled, "approvals_disabled"},
+	{errApprovalNotFound, "approval_not_found"},
+	{errApprovalState, "approval_not_pending"},
+	{errApprovalSelf, "approval_self"},
+	{errApprovalNotEligible, "approver_not_eligible"},
+	{errApprovalDuplicate, "approval_duplicate"},
+	{errCeremonyNotFound, "ceremony_not_found"},
+	{errCeremonyState, "ceremony_not_open"},
+	{errCeremonyAbsent, "ceremony_absent"},
+	{errCeremonyQuorum, "ceremony_requirements_unmet"},
+	{errDeployNotFound, "deploy_not_found"},
+	{errDeployNotAuthorized, "deploy_not_authorized"},
+	{errDeployPayer, "deploy_payer_not_authorized"},
+	{errDeployState, "deploy_bad_state"},
+	{errSolanaMalformed, "malformed_transaction"},
+	{errNoRPC, "rpc_not_configured"},
+	{errRPCUnavailable, "rpc_unavailable"},
+	{errBlockhashExpired, "blockhash_expired"},
+	{errTxNotFound, "tx_not_found"},
+	{errNoTxWebhook, "tx_webhook_not_configured"},
+	{errTxAlreadySigned, "tx_already_signed"},
+	{errDurableNonce, "durable_nonce"},
+	{errNoChainAddress, "no_chain_address"},
+	{errAirdropMainnet, "airdrop_mainnet"},
+	{errUnknownCluster, "unknown_cluster"},
+	{errNotAMint, "not_a_mint"},
+	{errTokenDecimals, "decimals_mismatch"},
+	{errNotANonce, "not_a_nonce_account"},
+	{errNonceAuthority, "nonce_authority_mismatch"},
+	{errQueueDisabled, "queue_not_configured"},
+	{errQueueEntryNotFound, "queue_entry_not_found"},
+	{errQueueEntryState, "queue_entry_state"},
+	{errNoFeePayer, "fee_payer_not_configured"},
+	{errFeePayerMismatch, "fee_payer_mismatch"},
+}
+
+// fallback codes by status for errors w/o a sentinel
+var statusCodes = map[int]string{
+	http.StatusBadRequest:            "invalid_request",
+	http.StatusUnauthorized:          "unauthenticated",
+	http.StatusForbidden:             "forbidden",
+	http.StatusNotFound:              "not_found",
+	http.StatusRequestTimeout:        "request_timeout",
+	http.StatusRequestEntityTooLarge: "body_too_large",
+	http.StatusMethodNotAllowed:      "method_not_allowed",
+	http.StatusNotAcceptable:         "not_acceptable",
+	http.StatusConflict:              "conflict",
+	http.StatusUnprocessableEntity:   "unprocessable",
+	http.StatusLocked:                "locked",
+	http.StatusTooManyRequests:       "rate_limited",
+	http.StatusBadGateway:            "bad_gateway",
+	http.StatusServiceUnavailable:    "unavailable",
+	http.StatusGatewayTimeout:        "timeout",
+	http.StatusInternalServerError:   "internal",
+}
+
+func errorCode(err error, status int) string {
+	for _, c := range errorCodes {
+		if errors.Is(err, c.err) {
+			return c.code
+		}
+	}
+	if code, ok := statusCodes[status]; ok {
+		return code
+	}
+	return "error"
+}
+
+// detailedError carries extra fields for the response's details
+type detailedError struct {
+	err     error
+	details map[string]string
+}
+
+func (e *detailedError) Error() string { return e.err.Error() }
+func (e *detailedError) Unwrap() error { return e.err }
+
+func withDetails(err error, details map[string]string) error {
+	return &detailedError{err: err, details: details}
+}
+
+// writeError answers w/ an ErrorResponse, encoding it properly whatever the message holds
+func writeError(w http.ResponseWriter, r *http.Request, status int, err error) {
+	// handlers answer 400 for any decode failure, an oversized body included
+	if errors.Is(err, errBodyTooLarge) {
+		status = http.StatusRequestEntityTooLarge
+	}
+	res := ErrorResponse{
+		Code:      errorCode(err, status),
+		Message:   err.Error(),
+		RequestID: RequestIDFromContext(r.Context()),
+	}
+
+	var detailed *detailedError
+	if errors.As(err, &detailed) {
+		res.Details = detailed.details
+	}
+	var spend *SpendLimitError
+	if errors.As(err, &spend) {
+		res.Details = map[string]string{"limit": strconv.FormatUint(spend.Limit.Max, 10), "total": strconv.FormatUint(spend.Total, 10)}
+	}
+
+	// v1 clients can ask for the v2 error model
+	if apiVersion(r) == APIv2 || acceptsExplicitly(r, "application/problem+json") {
+		writeProblem(w, status, res)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(res)
+}
+
+// invalidBody wraps a JSON decode failure
+func invalidBody(err error) error {
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		return bodyTooLarge(tooLarge.Limit)
+	}
+	var field *FieldError
+	if errors.As(err, &field) {
+		details := map[string]string{"field": field.Field}
+		if field.Expected != "" {
+			details["expected"] = field.Expected
+		}
+		return withDetails(fmt.Errorf("%w: %w", errInvalidBody, field), details)
+	}
+	return fmt.Errorf("%w: %v", errInvalidBody, err)
+}
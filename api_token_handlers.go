@@ -0,0 +1,152 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"time"
+)
+
+type apiTokenCtxKey struct{}
+
+// WithAPIToken attaches the ID of the API token the request authenticated w/
+func WithAPIToken(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, apiTokenCtxKey{}, id)
+}
+
+// APITokenFromContext returns the token ID, empty w/o a valid X-API-Key
+func APITokenFromContext(ctx context.Context) string {
+	id, _ := ctx.Value(apiTokenCtxKey{}).(string)
+	return id
+}
+
+// withAPIToken tags requests carrying a valid X-API-Key w/ its token ID and
+// tenant so usage is metered and billed per token. Scopes are enforced by
+// checkAPIToken.
+func (s *APIServer) withAPIToken(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if token := r.Header.Get("X-API-Key"); token != "" && s.Tokens != nil {
+			// the token names nobody until it checks out, so failures count per address
+			identity := "apikey@" + clientIP(r).String()
+			if wait := s.Lockout.Locked(identity, time.Now()); wait > 0 {
+				tooManyAttempts(w, r, wait)
+				return
+			}
+
+			if t, err := s.Tokens.Authenticate(token, time.Now()); err == nil {
+				s.Lockout.Success(identity)
+				r = r.WithContext(WithTenant(WithAPIToken(r.Context(), t.ID), t.Tenant))
+			} else {
+				s.Lockout.Failure(identity, time.Now())
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// checkAPIToken enforces the request's X-API-Key scope for op on keyID, and
+// the key's network and client identity rules on whoever is calling
+func (s *APIServer) checkAPIToken(r *http.Request, op, keyID string) error {
+	if err := s.checkTokenScope(r, op, keyID); err != nil {
+		return err
+	}
+	if keyID == "" {
+		return nil
+	}
+	if info, err := s.Service.KeyInfo(r.Context(), keyID); err == nil {
+		if err := info.Policy.CheckCaller(clientIP(r), clientIdentities(r)); err != nil {
+			slog.WarnContext(r.Context(), "Refusing caller", logAuth, "key_id", keyID, "remote", clientIP(r), "err", err)
+			return err
+		}
+	}
+	return nil
+}
+
+// checkRead is checkAPIToken for reads, operators read w/o a token
+func (s *APIServer) checkRead(r *http.Request, keyID string) error {
+	if OperatorFromContext(r.Context()).Name != "" {
+		return nil
+	}
+	return s.checkAPIToken(r, TokenOpRead, keyID)
+}
+
+func (s *APIServer) checkTokenScope(r *http.Request, op, keyID string) error {
+	token := r.Header.Get("X-API-Key")
+	if token == "" {
+		if s.RequireAPIToken {
+			return fmt.Errorf("%w: X-API-Key header is required", errAPITokenInvalid)
+		}
+		return nil
+	}
+	if s.Tokens == nil {
+		return fmt.Errorf("%w: API tokens are not enabled", errAPITokenInvalid)
+	}
+
+	t, err := s.Tokens.Authenticate(token, time.Now())
+	if err != nil {
+		s.Compliance.AuthFailed("api_token")
+		return err
+	}
+	if !t.Allows(op, keyID) {
+		audit(r.Context(), logAuth, "API token denied", "token_id", t.ID, "token_name", t.Name, "operation", op, "key_id", keyID)
+		return fmt.Errorf("%w: %s", errAPITokenDenied, op)
+	}
+	return nil
+}
+
+// apiTokenErrorStatus is 401 for bad tokens and 403 for scope
+func apiTokenErrorStatus(err error) int {
+	if errors.Is(err, errAPITokenInvalid) {
+		return http.StatusUnauthorized
+	}
+	return http.StatusForbidden
+}
+
+// refuseAPIToken writes the check's error
+func refuseAPIToken(w http.ResponseWriter, r *http.Request, err error) {
+	writeError(w, r, apiTokenErrorStatus(err), err)
+}
+
+func (s *APIServer) handleTokenMint(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+
+	var req APITokenRequest
+	if err := decodeJSON(r.Body, &req); err != nil {
+		writeError(w, r, http.StatusBadRequest, invalidBody(err))
+		return
+	}
+
+	t, secret, err := s.Tokens.Mint(req, OperatorFromContext(r.Context()).Name, time.Now())
+	if err != nil {
+		writeError(w, r, http.StatusBadRequest, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(struct {
+		APIToken
+		Token string `json:"token"`
+	}{t, secret})
+}
+
+func (s *APIServer) handleTokenList(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	json.NewEncoder(w).Encode(s.Tokens.List())
+}
+
+func (s *APIServer) handleTokenRevoke(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	t, err := s.Tokens.Revoke(r.PathValue("id"), OperatorFromContext(r.Context()).Name, time.Now())
+	if err != nil {
+		writeError(w, r, http.StatusNotFound, err)
+		return
+	}
+
+	json.NewEncoder(w).Encode(t)
+}
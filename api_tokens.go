@@ -0,0 +1,192 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// operations an API token can be scoped to
+const (
+	TokenOpSign     = "sign"
+	TokenOpGenerate = "generate"
+	TokenOpVerify   = "verify"
+	TokenOpRead     = "read"
+)
+
+var tokenOps = []string{TokenOpSign, TokenOpGenerate, TokenOpVerify, TokenOpRead}
+
+// prefix so leaked tokens are easy to spot in logs and secret scanners
+const apiTokenPrefix = "sts_"
+
+var (
+	errAPITokenInvalid = errors.New("invalid, expired or revoked API token")
+	errAPITokenDenied  = errors.New("API token does not allow this request")
+)
+
+type APITokenRequest struct {
+	Name string `json:"name"`
+
+	// key IDs the token may use, empty allows any key
+	KeyIDs []string `json:"keyIds,omitempty"`
+
+	Operations []string `json:"operations"`
+
+	// tenant the token's requests are billed to and its keys are created
+	// in, empty is the default tenant
+	Tenant string `json:"tenant,omitempty"`
+
+	// Go duration, empty never expires
+	ExpiresIn string `json:"expiresIn,omitempty"`
+}
+
+// APIToken is a token's metadata, the secret itself is only kept as a digest
+type APIToken struct {
+	ID         string     `json:"id"`
+	Name       string     `json:"name"`
+	KeyIDs     []string   `json:"keyIds,omitempty"`
+	Operations []string   `json:"operations"`
+	Tenant     string     `json:"tenant,omitempty"`
+	CreatedBy  string     `json:"createdBy"`
+	CreatedAt  time.Time  `json:"createdAt"`
+	ExpiresAt  *time.Time `json:"expiresAt,omitempty"`
+	LastUsedAt *time.Time `json:"lastUsedAt,omitempty"`
+	RevokedAt  *time.Time `json:"revokedAt,omitempty"`
+	RevokedBy  string     `json:"revokedBy,omitempty"`
+
+	digest [sha256.Size]byte
+}
+
+// Allows reports whether the token covers op on keyID, keyID is empty for
+// operations that aren't tied to a key
+func (t APIToken) Allows(op, keyID string) bool {
+	if !slices.Contains(t.Operations, op) {
+		return false
+	}
+	return keyID == "" || len(t.KeyIDs) == 0 || slices.Contains(t.KeyIDs, keyID)
+}
+
+type APITokenStore struct {
+	tokens map[string]*APIToken
+
+	mu sync.Mutex
+}
+
+// constructor
+func NewAPITokenStore() *APITokenStore {
+	return &APITokenStore{tokens: make(map[string]*APIToken)}
+}
+
+// Mint returns the token's metadata and its secret, the secret is never shown again
+func (s *APITokenStore) Mint(req APITokenRequest, createdBy string, now time.Time) (APIToken, string, error) {
+	if req.Name == "" || len(req.Operations) == 0 {
+		return APIToken{}, "", errors.New("name and operations are required")
+	}
+	for _, op := range req.Operations {
+		if !slices.Contains(tokenOps, op) {
+			return APIToken{}, "", fmt.Errorf("unknown operation %q, expected one of %v", op, tokenOps)
+		}
+	}
+
+	t := &APIToken{
+		Name:       req.Name,
+		KeyIDs:     slices.Clone(req.KeyIDs),
+		Operations: slices.Clone(req.Operations),
+		Tenant:     req.Tenant,
+		CreatedBy:  createdBy,
+		CreatedAt:  now,
+	}
+	if req.ExpiresIn != "" {
+		ttl, err := time.ParseDuration(req.ExpiresIn)
+		if err != nil || ttl <= 0 {
+			return APIToken{}, "", errors.New("expiresIn must be a positive duration")
+		}
+		expires := now.Add(ttl)
+		t.ExpiresAt = &expires
+	}
+
+	var id [8]byte
+	var secret [32]byte
+	if _, err := rand.Read(id[:]); err != nil {
+		return APIToken{}, "", fmt.Errorf("failed to create token: %w", err)
+	}
+	if _, err := rand.Read(secret[:]); err != nil {
+		return APIToken{}, "", fmt.Errorf("failed to create token: %w", err)
+	}
+	t.ID = hex.EncodeToString(id[:])
+	t.digest = sha256.Sum256(secret[:])
+
+	s.mu.Lock()
+
+	defer s.mu.Unlock()
+
+	s.tokens[t.ID] = t
+
+	audit(context.Background(), logAuth, "API token minted", "operator", createdBy, "token_id", t.ID, "token_name", t.Name, "operations", t.Operations, "key_ids", t.KeyIDs)
+	return *t, apiTokenPrefix + t.ID + "_" + hex.EncodeToString(secret[:]), nil
+}
+
+// Authenticate looks the token up on every call, so a revoke takes effect at once
+func (s *APITokenStore) Authenticate(token string, now time.Time) (APIToken, error) {
+	rest, ok := strings.CutPrefix(token, apiTokenPrefix)
+	id, secretHex, _ := strings.Cut(rest, "_")
+	secret, err := hex.DecodeString(secretHex)
+	if !ok || err != nil {
+		return APIToken{}, errAPITokenInvalid
+	}
+	digest := sha256.Sum256(secret)
+
+	s.mu.Lock()
+
+	defer s.mu.Unlock()
+
+	t, found := s.tokens[id]
+	if !found || subtle.ConstantTimeCompare(digest[:], t.digest[:]) != 1 {
+		return APIToken{}, errAPITokenInvalid
+	}
+	if t.RevokedAt != nil || (t.ExpiresAt != nil && !now.Before(*t.ExpiresAt)) {
+		return APIToken{}, errAPITokenInvalid
+	}
+	t.LastUsedAt = &now
+	return *t, nil
+}
+
+func (s *APITokenStore) Revoke(id, by string, now time.Time) (APIToken, error) {
+	s.mu.Lock()
+
+	defer s.mu.Unlock()
+
+	t, ok := s.tokens[id]
+	if !ok {
+		return APIToken{}, errors.New("token not found")
+	}
+	if t.RevokedAt == nil {
+		t.RevokedAt = &now
+		t.RevokedBy = by
+		audit(context.Background(), logAuth, "API token revoked", "operator", by, "token_id", t.ID, "token_name", t.Name)
+	}
+	return *t, nil
+}
+
+// List returns every token, revoked ones included, oldest first
+func (s *APITokenStore) List() []APIToken {
+	s.mu.Lock()
+
+	defer s.mu.Unlock()
+
+	out := []APIToken{}
+	for _, t := range s.tokens {
+		out = append(out, *t)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].CreatedAt.Before(out[j].CreatedAt) })
+	return out
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+)
+
+func approvalErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, errApprovalNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, errApprovalSelf), errors.Is(err, errApprovalNotEligible):
+		return http.StatusForbidden
+	case errors.Is(err, errApprovalState), errors.Is(err, errApprovalDuplicate):
+		return http.StatusConflict
+	default:
+		return http.StatusBadRequest
+	}
+}
+
+func (s *APIServer) handleApprovalList(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	json.NewEncoder(w).Encode(s.Approvals.List(r.URL.Query().Get("status")))
+}
+
+// approval ids are unguessable, requesters poll here for their signature
+func (s *APIServer) handleApprovalGet(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	pa, err := s.Approvals.Get(r.PathValue("id"))
+	if err != nil {
+		writeError(w, r, approvalErrorStatus(err), err)
+		return
+	}
+	// the caller polling its parked request may only hold a sign token
+	if err := s.checkRead(r, pa.KeyID); err != nil {
+		if s.checkAPIToken(r, TokenOpSign, pa.KeyID) != nil {
+			refuseAPIToken(w, r, err)
+			return
+		}
+	}
+
+	json.NewEncoder(w).Encode(pa)
+}
+
+func (s *APIServer) handleApprovalApprove(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	pa, req, ready, err := s.Approvals.Approve(r.PathValue("id"), OperatorFromContext(r.Context()))
+	if err != nil {
+		writeError(w, r, approvalErrorStatus(err), err)
+		return
+	}
+
+	// quorum not met yet
+	if !ready {
+		json.NewEncoder(w).Encode(pa)
+		return
+	}
+
+	// the request is signed under the original tenant, not the approver's
+	ctx, cancel := context.WithDeadline(WithRequestID(WithTenant(context.Background(), pa.Tenant), RequestIDFromContext(r.Context())), signDeadline(r.Context()))
+	defer cancel()
+
+	res, err := s.Service.SignTransaction(withApproval(ctx, pa.ID), req)
+	pa = s.Approvals.Complete(pa.ID, res, err)
+
+	json.NewEncoder(w).Encode(pa)
+}
+
+func (s *APIServer) handleApprovalReject(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	pa, err := s.Approvals.Reject(r.PathValue("id"), OperatorFromContext(r.Context()))
+	if err != nil {
+		writeError(w, r, approvalErrorStatus(err), err)
+		return
+	}
+
+	json.NewEncoder(w).Encode(pa)
+}
+
+func (s *APIServer) handleApprovalAudit(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	json.NewEncoder(w).Encode(s.Approvals.Audit(r.URL.Query().Get("approvalId")))
+}
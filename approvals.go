@@ -0,0 +1,473 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"slices"
+	"sort"
+	"sync"
+	"time"
+)
+
+// approval states
+const (
+	ApprovalPending  = "pending"
+	ApprovalApproved = "approved"
+	ApprovalRejected = "rejected"
+	ApprovalExpired  = "expired"
+	ApprovalExecuted = "executed"
+	ApprovalFailed   = "failed"
+)
+
+// decisions recorded in the audit trail
+const (
+	DecisionRequested = "requested"
+	DecisionApprove   = "approve"
+	DecisionReject    = "reject"
+	DecisionRefused   = "refused"
+	DecisionExpired   = "expired"
+	DecisionExecuted  = "executed"
+	DecisionFailed    = "failed"
+)
+
+// pending requests expire after this unless the key's quorum says otherwise
+const defaultApprovalTTL = 24 * time.Hour
+
+// finished approvals stay this long so callers can poll their outcome
+const approvalRetention = time.Hour
+
+// decisions the queue keeps for the audit route, older ones are in the audit
+// log and compliance reports
+const maxApprovalAudit = 10000
+
+var (
+	errApprovalNotFound    = errors.New("approval not found")
+	errApprovalState       = errors.New("approval is no longer pending")
+	errApprovalSelf        = errors.New("approver must be a different person than the requester")
+	errApprovalNotEligible = errors.New("operator is not an approver for this key")
+	errApprovalDuplicate   = errors.New("operator has already decided this approval")
+	errApprovalsDisabled   = errors.New("transaction needs approval but no approvers are configured")
+)
+
+// ApprovalQuorum is the M-of-N rule for a key's approvals. Eligible approvers
+// are the named operators plus anyone holding one of the roles, when both are
+// empty any operator may approve. OIDC operators are named oidc:ISSUER/SUB.
+type ApprovalQuorum struct {
+	Required  int      `json:"required"`
+	Approvers []string `json:"approvers,omitempty"`
+	Roles     []string `json:"roles,omitempty"`
+
+	// how long a request may wait, as a Go duration, default 24h
+	TTL string `json:"ttl,omitempty"`
+}
+
+// defaultApprovalQuorum is the two-person rule, one approver besides the requester
+func defaultApprovalQuorum() ApprovalQuorum {
+	return ApprovalQuorum{Required: 1}
+}
+
+func (q ApprovalQuorum) Validate() error {
+	if q.Required <= 0 {
+		return errors.New("quorum required must be greater than zero")
+	}
+	if len(q.Approvers) > 0 && len(q.Roles) == 0 && q.Required > len(q.Approvers) {
+		return fmt.Errorf("quorum needs %d approvals but only names %d approvers", q.Required, len(q.Approvers))
+	}
+	if q.TTL != "" {
+		if ttl, err := time.ParseDuration(q.TTL); err != nil || ttl <= 0 {
+			return errors.New("quorum ttl must be a positive duration")
+		}
+	}
+	return nil
+}
+
+func (q ApprovalQuorum) ttl() time.Duration {
+	if ttl, err := time.ParseDuration(q.TTL); err == nil && ttl > 0 {
+		return ttl
+	}
+	return defaultApprovalTTL
+}
+
+func (q ApprovalQuorum) eligible(op Operator) bool {
+	if len(q.Approvers) == 0 && len(q.Roles) == 0 {
+		return true
+	}
+	if slices.Contains(q.Approvers, op.Name) {
+		return true
+	}
+	return slices.ContainsFunc(q.Roles, op.HasRole)
+}
+
+// ApprovalThreshold sends transactions moving at least Min of the asset to
+// a second operator before they are signed
+type ApprovalThreshold struct {
+	// base58 mint, empty for lamports
+	Mint string `json:"mint,omitempty"`
+	Min  uint64 `json:"min"`
+}
+
+func (a ApprovalThreshold) asset() string {
+	if a.Mint == "" {
+		return assetLamports
+	}
+	return a.Mint
+}
+
+func (a ApprovalThreshold) Validate() error {
+	if a.Min == 0 {
+		return errors.New("approval threshold min must be greater than zero")
+	}
+	if a.Mint != "" {
+		if _, err := ParseSolanaPubkey(a.Mint); err != nil {
+			return fmt.Errorf("invalid approval threshold mint: %w", err)
+		}
+	}
+	return nil
+}
+
+// approvalReason returns why spends need a second operator, empty if they don't
+func (p KeyPolicy) approvalReason(spends map[string]uint64) string {
+	for _, a := range p.ApprovalThresholds {
+		if amount := spends[a.asset()]; amount >= a.Min {
+			return fmt.Sprintf("moves %d %s, approval threshold is %d", amount, a.asset(), a.Min)
+		}
+	}
+	return ""
+}
+
+// ApprovalDecision is one entry in the audit trail
+type ApprovalDecision struct {
+	ApprovalID string    `json:"approvalId"`
+	KeyID      string    `json:"keyId"`
+	Operator   string    `json:"operator"`
+	Decision   string    `json:"decision"`
+	Detail     string    `json:"detail,omitempty"`
+	At         time.Time `json:"at"`
+}
+
+type PendingApproval struct {
+	ID          string         `json:"id"`
+	KeyID       string         `json:"keyId"`
+	Reason      string         `json:"reason"`
+	Status      string         `json:"status"`
+	RequestedBy string         `json:"requestedBy"`
+	Tenant      string         `json:"tenant"`
+	Quorum      ApprovalQuorum `json:"quorum"`
+	CreatedAt   time.Time      `json:"createdAt"`
+	ExpiresAt   time.Time      `json:"expiresAt"`
+
+	// operators who approved, in order
+	Approvals []string `json:"approvals"`
+
+	DecidedBy string     `json:"decidedBy,omitempty"`
+	DecidedAt *time.Time `json:"decidedAt,omitempty"`
+
+	// signing outcome once approved
+	Result *TransactionResult `json:"result,omitempty"`
+
+	// replayed once approved, idempotency key cleared so the cached pending
+	// result isn't returned
+	request TransactionRequest
+
+	// when it was rejected, expired or signed, zero while it may still sign
+	finishedAt time.Time
+}
+
+type approvedCtxKey struct{}
+
+// withApproval marks a sign call as already approved, only the approvals
+// handler sets it
+func withApproval(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, approvedCtxKey{}, id)
+}
+
+func approvalFromContext(ctx context.Context) string {
+	id, _ := ctx.Value(approvedCtxKey{}).(string)
+	return id
+}
+
+// ApprovalQueue holds transactions waiting on their quorum
+type ApprovalQueue struct {
+	approvals map[string]*PendingApproval
+
+	// the latest requests and decisions, a ring of up to auditCap entries
+	// w/ the oldest at auditNext once full
+	audit     []ApprovalDecision
+	auditNext int
+	auditCap  int
+
+	// every decision is kept for compliance reports, nil records nothing
+	compliance *ComplianceRecorder
+
+	// new requests are announced here
+	notifier *NotificationDispatcher
+
+	// decisions are published here
+	events *EventBus
+
+	mu sync.Mutex
+}
+
+// constructor
+func NewApprovalQueue() *ApprovalQueue {
+	return &ApprovalQueue{approvals: make(map[string]*PendingApproval), auditCap: maxApprovalAudit}
+}
+
+func (q *ApprovalQueue) Submit(ctx context.Context, req TransactionRequest, reason string, quorum ApprovalQuorum) (PendingApproval, error) {
+	var id [16]byte
+	if _, err := rand.Read(id[:]); err != nil {
+		return PendingApproval{}, fmt.Errorf("failed to create approval id: %w", err)
+	}
+
+	// non operators are identified by client certificate or tenant so an
+	// operator can't approve what they asked for themselves
+	requestedBy := OperatorFromContext(ctx).Name
+	if principal := PrincipalFromContext(ctx); requestedBy == "" && principal != "" {
+		requestedBy = "client:" + principal
+	}
+	if requestedBy == "" {
+		requestedBy = "tenant:" + TenantFromContext(ctx)
+	}
+
+	now := time.Now()
+	req.IdempotencyKey = ""
+	pa := &PendingApproval{
+		ID:          hex.EncodeToString(id[:]),
+		KeyID:       req.KeyID,
+		Reason:      reason,
+		Status:      ApprovalPending,
+		RequestedBy: requestedBy,
+		Tenant:      TenantFromContext(ctx),
+		Quorum:      quorum,
+		CreatedAt:   now,
+		ExpiresAt:   now.Add(quorum.ttl()),
+		Approvals:   []string{},
+		request:     req,
+	}
+
+	q.mu.Lock()
+	q.prune(now)
+	q.approvals[pa.ID] = pa
+	q.record(pa, requestedBy, DecisionRequested, reason)
+	q.mu.Unlock()
+
+	q.notifier.Dispatch(Notification{
+		Kind:     NotifyApprovalRequest,
+		Severity: SeverityWarning,
+		Title:    "Signature awaiting approval",
+		Message:  fmt.Sprintf("A transaction %s and needs %d approvals", reason, quorum.Required),
+		KeyID:    req.KeyID,
+		Details:  map[string]string{"approvalId": pa.ID, "requestedBy": requestedBy},
+	})
+
+	return *pa, nil
+}
+
+// Approve adds the operator's approval. Once the quorum is met the approval
+// moves to approved, ready is set and the request to sign is returned, this
+// happens exactly once per approval.
+func (q *ApprovalQueue) Approve(id string, op Operator) (pa PendingApproval, req TransactionRequest, ready bool, err error) {
+	q.mu.Lock()
+
+	defer q.mu.Unlock()
+
+	p, err := q.decidable(id, op)
+	if err != nil {
+		return PendingApproval{}, TransactionRequest{}, false, err
+	}
+
+	p.Approvals = append(p.Approvals, op.Name)
+	q.record(p, op.Name, DecisionApprove, fmt.Sprintf("%d of %d", len(p.Approvals), p.Quorum.Required))
+
+	if len(p.Approvals) < p.Quorum.Required {
+		return *p, TransactionRequest{}, false, nil
+	}
+
+	now := time.Now()
+	p.Status = ApprovalApproved
+	p.DecidedBy = op.Name
+	p.DecidedAt = &now
+	q.events.Publish(context.Background(), Event{Type: EventSignApproved, KeyID: p.KeyID, ApprovalID: p.ID, Detail: op.Name})
+	return *p, p.request, true, nil
+}
+
+// Reject vetoes the request, a single eligible operator is enough
+func (q *ApprovalQueue) Reject(id string, op Operator) (PendingApproval, error) {
+	q.mu.Lock()
+
+	defer q.mu.Unlock()
+
+	p, err := q.decidable(id, op)
+	if err != nil {
+		return PendingApproval{}, err
+	}
+
+	now := time.Now()
+	p.Status = ApprovalRejected
+	p.DecidedBy = op.Name
+	p.DecidedAt = &now
+	p.finishedAt = now
+	q.record(p, op.Name, DecisionReject, "")
+	q.events.Publish(context.Background(), Event{Type: EventSignRejected, KeyID: p.KeyID, ApprovalID: p.ID, Detail: op.Name})
+
+	return *p, nil
+}
+
+// decidable checks op may still decide the approval, must be called w/ mu held
+func (q *ApprovalQueue) decidable(id string, op Operator) (*PendingApproval, error) {
+	p, ok := q.approvals[id]
+	if !ok {
+		return nil, errApprovalNotFound
+	}
+	q.expire(p, time.Now())
+	if p.Status != ApprovalPending {
+		return nil, errApprovalState
+	}
+
+	var err error
+	switch {
+	case op.Name == p.RequestedBy:
+		err = errApprovalSelf
+	case !p.Quorum.eligible(op):
+		err = errApprovalNotEligible
+	case slices.Contains(p.Approvals, op.Name):
+		err = errApprovalDuplicate
+	}
+	if err != nil {
+		q.record(p, op.Name, DecisionRefused, err.Error())
+		return nil, err
+	}
+	return p, nil
+}
+
+// Complete records the signing outcome of an approved request
+func (q *ApprovalQueue) Complete(id string, result TransactionResult, err error) PendingApproval {
+	q.mu.Lock()
+
+	defer q.mu.Unlock()
+
+	pa := q.approvals[id]
+	pa.Status = ApprovalExecuted
+	if err != nil {
+		pa.Status = ApprovalFailed
+		result.Error = err.Error()
+	}
+	pa.Result = &result
+	pa.finishedAt = time.Now()
+	q.record(pa, pa.DecidedBy, pa.Status, result.Error)
+
+	return *pa
+}
+
+func (q *ApprovalQueue) Get(id string) (PendingApproval, error) {
+	q.mu.Lock()
+
+	defer q.mu.Unlock()
+
+	pa, ok := q.approvals[id]
+	if !ok {
+		return PendingApproval{}, errApprovalNotFound
+	}
+	q.expire(pa, time.Now())
+	return *pa, nil
+}
+
+// InFlight returns the IDs of approvals for keyID that may still sign, nil safe
+func (q *ApprovalQueue) InFlight(keyID string) []string {
+	if q == nil {
+		return nil
+	}
+	q.mu.Lock()
+
+	defer q.mu.Unlock()
+
+	var ids []string
+	now := time.Now()
+	for id, pa := range q.approvals {
+		q.expire(pa, now)
+		if pa.KeyID == keyID && (pa.Status == ApprovalPending || pa.Status == ApprovalApproved) {
+			ids = append(ids, id)
+		}
+	}
+	sort.Strings(ids)
+	return ids
+}
+
+// List returns approvals in the given status, all when empty, oldest first
+func (q *ApprovalQueue) List(status string) []PendingApproval {
+	q.mu.Lock()
+
+	defer q.mu.Unlock()
+
+	now := time.Now()
+	out := []PendingApproval{}
+	for _, pa := range q.approvals {
+		q.expire(pa, now)
+		if status == "" || pa.Status == status {
+			out = append(out, *pa)
+		}
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].CreatedAt.Before(out[j].CreatedAt) })
+	return out
+}
+
+// Audit returns the decision trail, optionally for one approval
+func (q *ApprovalQueue) Audit(id string) []ApprovalDecision {
+	q.mu.Lock()
+
+	defer q.mu.Unlock()
+
+	out := []ApprovalDecision{}
+	for i := range q.audit {
+		if d := q.audit[(q.auditNext+i)%len(q.audit)]; id == "" || d.ApprovalID == id {
+			out = append(out, d)
+		}
+	}
+	return out
+}
+
+// expire times out a pending approval, must be called w/ mu held
+func (q *ApprovalQueue) expire(pa *PendingApproval, now time.Time) {
+	if pa.Status != ApprovalPending || now.Before(pa.ExpiresAt) {
+		return
+	}
+	pa.Status = ApprovalExpired
+	pa.finishedAt = now
+	q.record(pa, "", DecisionExpired, "")
+}
+
+// prune drops approvals finished longer than approvalRetention ago, the
+// audit trail keeps their history. Must be called w/ mu held.
+func (q *ApprovalQueue) prune(now time.Time) {
+	for id, pa := range q.approvals {
+		q.expire(pa, now)
+		if !pa.finishedAt.IsZero() && now.Sub(pa.finishedAt) > approvalRetention {
+			delete(q.approvals, id)
+		}
+	}
+}
+
+// record adds to the audit trail, overwriting the oldest entry once it
+// holds auditCap. Must be called w/ mu held.
+func (q *ApprovalQueue) record(pa *PendingApproval, operator, decision, detail string) {
+	audit(context.Background(), logAdmin, "Approval "+decision, "approval_id", pa.ID, "operator", operator, "key_id", pa.KeyID, "detail", detail)
+	d := ApprovalDecision{
+		ApprovalID: pa.ID,
+		KeyID:      pa.KeyID,
+		Operator:   operator,
+		Decision:   decision,
+		Detail:     detail,
+		At:         time.Now(),
+	}
+	q.compliance.ApprovalDecided(d)
+
+	if len(q.audit) < q.auditCap {
+		q.audit = append(q.audit, d)
+		return
+	}
+	q.audit[q.auditNext] = d
+	q.auditNext = (q.auditNext + 1) % len(q.audit)
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"time"
+)
+
+// where generated keys live, reported in attestations
+const keyBackend = "memory"
+
+var errBadAttestation = errors.New("attestation does not verify")
+
+// AttestationStatement is what the service vouches for about a generated key
+type AttestationStatement struct {
+	KeyID       string    `json:"keyId"`
+	KeyType     string    `json:"keyType"`
+	Namespace   string    `json:"namespace,omitempty"`
+	GeneratedAt time.Time `json:"generatedAt"`
+	Backend     string    `json:"backend"`
+	Version     string    `json:"version"`
+}
+
+// KeyAttestation is a statement signed w/ the service's attestation key.
+// Signature is base64 ed25519 over the statement's JSON encoding.
+type KeyAttestation struct {
+	Statement AttestationStatement `json:"statement"`
+	Signature string               `json:"signature"`
+	SignerKey string               `json:"signerKey"`
+}
+
+// Attester signs attestation statements
+type Attester struct {
+	key ed25519.PrivateKey
+}
+
+// constructor, seed is a hex 32 byte ed25519 seed. An empty seed gets a random
+// key so attestations can't be checked across restarts.
+func NewAttester(seed string) (*Attester, error) {
+	if seed == "" {
+		_, key, err := ed25519.GenerateKey(rand.Reader)
+		if err != nil {
+			return nil, fmt.Errorf("failed to generate attestation key: %w", err)
+		}
+		slog.Warn("No attestation seed set, attestations will not verify after a restart", logSigner)
+		return &Attester{key: key}, nil
+	}
+
+	raw, err := hex.DecodeString(seed)
+	if err != nil || len(raw) != ed25519.SeedSize {
+		return nil, fmt.Errorf("attestation seed must be %d hex encoded bytes", ed25519.SeedSize)
+	}
+	return &Attester{key: ed25519.NewKeyFromSeed(raw)}, nil
+}
+
+func (a *Attester) PublicKey() string {
+	return hex.EncodeToString(a.key.Public().(ed25519.PublicKey))
+}
+
+func (a *Attester) Attest(statement AttestationStatement) (*KeyAttestation, error) {
+	payload, err := json.Marshal(statement)
+	if err != nil {
+		return nil, err
+	}
+	return &KeyAttestation{
+		Statement: statement,
+		Signature: base64.StdEncoding.EncodeToString(ed25519.Sign(a.key, payload)),
+		SignerKey: a.PublicKey(),
+	}, nil
+}
+
+// Zeroize clears the signing key on shutdown, nil safe
+func (a *Attester) Zeroize() {
+	if a == nil {
+		return
+	}
+	for i := range a.key {
+		a.key[i] = 0
+	}
+}
+
+// VerifyAttestation checks an attestation against a trusted signer key, auditors
+// should pin the key rather than trust the SignerKey in the attestation
+func VerifyAttestation(att KeyAttestation, signerKey string) error {
+	pub, err := hex.DecodeString(signerKey)
+	if err != nil || len(pub) != ed25519.PublicKeySize || att.SignerKey != signerKey {
+		return errBadAttestation
+	}
+	sig, err := base64.StdEncoding.DecodeString(att.Signature)
+	if err != nil {
+		return errBadAttestation
+	}
+	payload, err := json.Marshal(att.Statement)
+	if err != nil || !ed25519.Verify(ed25519.PublicKey(pub), payload, sig) {
+		return errBadAttestation
+	}
+	return nil
+}
+
+func (s *APIServer) handleAttestationKey(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := s.checkRead(r, ""); err != nil {
+		refuseAPIToken(w, r, err)
+		return
+	}
+
+	json.NewEncoder(w).Encode(map[string]string{
+		"signerKey": s.Attester.PublicKey(),
+		"backend":   keyBackend,
+		"version":   version,
+	})
+}
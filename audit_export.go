@@ -0,0 +1,454 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"go.opentelemetry.io/otel/trace"
+)
+
+// AuditRecord is the schema audit lines are exported in, one JSON object
+// per event. Fields went through the same redaction as the log.
+type AuditRecord struct {
+	// numbered from 1 per process, a gap means events were dropped
+	Seq uint64 `json:"seq"`
+
+	Time      time.Time      `json:"time"`
+	Host      string         `json:"host"`
+	Service   string         `json:"service"`
+	Version   string         `json:"version"`
+	Component string         `json:"component"`
+	Action    string         `json:"action"`
+	RequestID string         `json:"requestId,omitempty"`
+	TraceID   string         `json:"traceId,omitempty"`
+	Fields    map[string]any `json:"fields,omitempty"`
+}
+
+// AuditSink ships a batch of records to one destination
+type AuditSink interface {
+	Name() string
+	Export(ctx context.Context, records []AuditRecord) error
+}
+
+// how audit delivery is retried, a batch still failing after the last attempt is dropped
+const (
+	auditQueueSize   = 4096
+	auditBatchSize   = 100
+	auditAttempts    = 5
+	auditSendTimeout = 10 * time.Second
+)
+
+// AuditExporter sends every audit line to the configured sinks in the
+// background. Each sink has its own queue, so a collector that is down
+// doesn't hold up the others, and failed batches are retried w/ backoff.
+type AuditExporter struct {
+	shippers []*auditShipper
+	host     string
+	seq      atomic.Uint64
+
+	// first retry waits this long, doubling each time
+	retryBase time.Duration
+
+	// records after Close are dropped, the queues are closed
+	closed bool
+	wg     sync.WaitGroup
+	mu     sync.RWMutex
+}
+
+type auditShipper struct {
+	sink    AuditSink
+	queue   chan AuditRecord
+	dropped atomic.Uint64
+}
+
+// constructor, Start it to begin shipping
+func NewAuditExporter(sinks ...AuditSink) *AuditExporter {
+	host, _ := os.Hostname()
+	e := &AuditExporter{host: host, retryBase: time.Second}
+	for _, sink := range sinks {
+		e.shippers = append(e.shippers, &auditShipper{sink: sink, queue: make(chan AuditRecord, auditQueueSize)})
+	}
+	return e
+}
+
+// AuditExporterFromEnv reads STS_AUDIT_SINKS, a comma separated list of
+//
+//	syslog://host:514, syslog+tcp://host:601, syslog+tls://host:6514, syslog:///dev/log
+//	file:///var/log/sts/audit.jsonl
+//	https://collector.example.com/ingest (w/ STS_AUDIT_HTTP_TOKEN as a bearer token)
+//
+// extra sinks are always fed, e.g. key analytics. It returns nil when there
+// are none.
+func AuditExporterFromEnv(getenv func(string) string, extra ...AuditSink) (*AuditExporter, error) {
+	raw := getenv("STS_AUDIT_SINKS")
+	if raw == "" && len(extra) == 0 {
+		return nil, nil
+	}
+	sinks := append([]AuditSink(nil), extra...)
+	if raw == "" {
+		return NewAuditExporter(sinks...), nil
+	}
+	for _, entry := range strings.Split(raw, ",") {
+		u, err := url.Parse(strings.TrimSpace(entry))
+		if err != nil {
+			return nil, fmt.Errorf("audit sink %q: %w", entry, err)
+		}
+		switch u.Scheme {
+		case "syslog", "syslog+udp", "syslog+tcp", "syslog+tls":
+			sink := &SyslogAuditSink{Network: strings.TrimPrefix(strings.TrimPrefix(u.Scheme, "syslog"), "+"), Addr: u.Host}
+			if u.Host == "" {
+				sink.Network, sink.Addr = "unixgram", u.Path
+			} else if sink.Network == "" {
+				sink.Network = "udp"
+			}
+			if sink.Addr == "" {
+				return nil, fmt.Errorf("audit sink %q has no address", entry)
+			}
+			sinks = append(sinks, sink)
+		case "file":
+			if u.Path == "" {
+				return nil, fmt.Errorf("audit sink %q has no path", entry)
+			}
+			sinks = append(sinks, &FileAuditSink{Path: u.Path})
+		case "http", "https":
+			sinks = append(sinks, &HTTPAuditSink{URL: u.String(), Token: getenv("STS_AUDIT_HTTP_TOKEN")})
+		default:
+			return nil, fmt.Errorf("audit sink %q, use syslog, file, http or https", entry)
+		}
+	}
+	return NewAuditExporter(sinks...), nil
+}
+
+// Start ships queued records until Close, nil safe
+func (e *AuditExporter) Start() {
+	if e == nil {
+		return
+	}
+	for _, s := range e.shippers {
+		e.wg.Add(1)
+		go func() {
+			defer e.wg.Done()
+			e.ship(s)
+		}()
+	}
+}
+
+// Close stops taking records and waits for the queues to drain, or for ctx
+func (e *AuditExporter) Close(ctx context.Context) error {
+	if e == nil {
+		return nil
+	}
+	e.mu.Lock()
+	if !e.closed {
+		e.closed = true
+		for _, s := range e.shippers {
+			close(s.queue)
+		}
+	}
+	e.mu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		e.wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return fmt.Errorf("audit records still queued: %w", ctx.Err())
+	}
+}
+
+// Dropped counts the records each sink lost to a full queue or failed delivery
+func (e *AuditExporter) Dropped() map[string]uint64 {
+	dropped := map[string]uint64{}
+	if e == nil {
+		return dropped
+	}
+	for _, s := range e.shippers {
+		dropped[s.sink.Name()] += s.dropped.Load()
+	}
+	return dropped
+}
+
+func (e *AuditExporter) enqueue(rec AuditRecord) {
+	e.mu.RLock()
+
+	defer e.mu.RUnlock()
+
+	if e.closed {
+		return
+	}
+	rec.Seq = e.seq.Add(1)
+	for _, s := range e.shippers {
+		select {
+		case s.queue <- rec:
+		default:
+			// never block the request that is being audited
+			s.dropped.Add(1)
+		}
+	}
+}
+
+// ship sends what's queued in batches until the queue is closed and empty
+func (e *AuditExporter) ship(s *auditShipper) {
+	for rec := range s.queue {
+		batch := []AuditRecord{rec}
+	fill:
+		for len(batch) < auditBatchSize {
+			select {
+			case rec, ok := <-s.queue:
+				if !ok {
+					break fill
+				}
+				batch = append(batch, rec)
+			default:
+				break fill
+			}
+		}
+		if err := e.deliver(s.sink, batch); err != nil {
+			s.dropped.Add(uint64(len(batch)))
+			slog.Error("Audit records dropped", logAudit, "sink", s.sink.Name(), "count", len(batch), "first_seq", batch[0].Seq, "err", err)
+		}
+	}
+}
+
+// deliver tries the batch auditAttempts times w/ exponential backoff
+func (e *AuditExporter) deliver(sink AuditSink, batch []AuditRecord) error {
+	wait := e.retryBase
+	var err error
+	for attempt := 1; ; attempt++ {
+		ctx, cancel := context.WithTimeout(context.Background(), auditSendTimeout)
+		err = sink.Export(ctx, batch)
+		cancel()
+		if err == nil || attempt == auditAttempts {
+			return err
+		}
+		slog.Warn("Audit export failed, retrying", logAudit, "sink", sink.Name(), "attempt", attempt, "err", err)
+		time.Sleep(wait)
+		wait *= 2
+	}
+}
+
+// Handler passes every line to next and exports the audit ones, the logger
+// set up in main is wrapped w/ it
+func (e *AuditExporter) Handler(next slog.Handler) slog.Handler {
+	if e == nil {
+		return next
+	}
+	return auditHandler{Handler: next, exporter: e}
+}
+
+type auditHandler struct {
+	slog.Handler
+	exporter *AuditExporter
+	attrs    []slog.Attr
+}
+
+func (h auditHandler) Handle(ctx context.Context, r slog.Record) error {
+	if r.Level == LevelAudit {
+		h.exporter.enqueue(h.record(ctx, r))
+	}
+	return h.Handler.Handle(ctx, r)
+}
+
+func (h auditHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return auditHandler{Handler: h.Handler.WithAttrs(attrs), exporter: h.exporter, attrs: append(h.attrs[:len(h.attrs):len(h.attrs)], attrs...)}
+}
+
+func (h auditHandler) WithGroup(name string) slog.Handler {
+	return auditHandler{Handler: h.Handler.WithGroup(name), exporter: h.exporter, attrs: h.attrs}
+}
+
+// record turns an audit line into the export schema
+func (h auditHandler) record(ctx context.Context, r slog.Record) AuditRecord {
+	rec := AuditRecord{
+		Time:      r.Time.UTC(),
+		Host:      h.exporter.host,
+		Service:   "sts-svc",
+		Version:   version,
+		Action:    r.Message,
+		RequestID: RequestIDFromContext(ctx),
+		Fields:    map[string]any{},
+	}
+	if sc := trace.SpanContextFromContext(ctx); sc.IsValid() {
+		rec.TraceID = sc.TraceID().String()
+	}
+	add := func(a slog.Attr) bool {
+		a = redactLogAttr(nil, a)
+		if a.Key == "component" {
+			rec.Component = a.Value.String()
+		} else {
+			rec.Fields[a.Key] = auditValue(a.Value)
+		}
+		return true
+	}
+	for _, a := range h.attrs {
+		add(a)
+	}
+	r.Attrs(add)
+	return rec
+}
+
+// auditValue is v as it should appear in JSON, errors and durations as text
+func auditValue(v slog.Value) any {
+	v = v.Resolve()
+	switch v.Kind() {
+	case slog.KindDuration:
+		return v.Duration().String()
+	case slog.KindTime:
+		return v.Time().UTC()
+	case slog.KindAny:
+		switch x := v.Any().(type) {
+		case error:
+			return x.Error()
+		case fmt.Stringer:
+			return x.String()
+		}
+	}
+	return v.Any()
+}
+
+// FileAuditSink appends JSON lines to a file, reopened for every batch so
+// logrotate can move it w/o a signal
+type FileAuditSink struct {
+	Path string
+}
+
+func (f *FileAuditSink) Name() string { return "file" }
+
+func (f *FileAuditSink) Export(ctx context.Context, records []AuditRecord) error {
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	for _, rec := range records {
+		if err := enc.Encode(rec); err != nil {
+			return err
+		}
+	}
+
+	file, err := os.OpenFile(f.Path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o600)
+	if err != nil {
+		return err
+	}
+	if _, err := file.Write(buf.Bytes()); err != nil {
+		file.Close()
+		return err
+	}
+	// an audit trail that vanishes w/ the page cache isn't one
+	if err := file.Sync(); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
+
+// SyslogAuditSink sends RFC 5424 messages w/ the record as JSON, over UDP,
+// TCP, TLS or a local unix socket. Stream transports use octet counting
+// framing (RFC 6587).
+type SyslogAuditSink struct {
+	Network string
+	Addr    string
+
+	// for syslog+tls, system roots when nil
+	TLS *tls.Config
+}
+
+// facility authpriv (10), severity notice (5)
+const syslogPriority = 10*8 + 5
+
+func (s *SyslogAuditSink) Name() string { return "syslog" }
+
+func (s *SyslogAuditSink) Export(ctx context.Context, records []AuditRecord) error {
+	conn, err := s.dial(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+
+	stream := s.Network == "tcp" || s.Network == "tls"
+	for _, rec := range records {
+		msg, err := syslogMessage(rec)
+		if err != nil {
+			return err
+		}
+		if stream {
+			msg = append([]byte(fmt.Sprintf("%d ", len(msg))), msg...)
+		}
+		if _, err := conn.Write(msg); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *SyslogAuditSink) dial(ctx context.Context) (net.Conn, error) {
+	switch s.Network {
+	case "tls":
+		d := &tls.Dialer{Config: s.TLS}
+		return d.DialContext(ctx, "tcp", s.Addr)
+	case "udp", "tcp", "unixgram":
+		var d net.Dialer
+		return d.DialContext(ctx, s.Network, s.Addr)
+	default:
+		return nil, errors.New("unknown syslog transport " + s.Network)
+	}
+}
+
+// syslogMessage formats rec as <PRI>1 TIMESTAMP HOST APP PROCID MSGID - MSG
+func syslogMessage(rec AuditRecord) ([]byte, error) {
+	body, err := json.Marshal(rec)
+	if err != nil {
+		return nil, err
+	}
+	host := rec.Host
+	if host == "" {
+		host = "-"
+	}
+	header := fmt.Sprintf("<%d>1 %s %s %s %d audit - ", syslogPriority,
+		rec.Time.Format("2006-01-02T15:04:05.000000Z07:00"), host, rec.Service, os.Getpid())
+	return append([]byte(header), body...), nil
+}
+
+// HTTPAuditSink POSTs each batch as a JSON array, w/ a bearer token when set
+type HTTPAuditSink struct {
+	URL    string
+	Token  string
+	Client *http.Client
+}
+
+func (h *HTTPAuditSink) Name() string { return "http" }
+
+func (h *HTTPAuditSink) Export(ctx context.Context, records []AuditRecord) error {
+	body, err := json.Marshal(records)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, h.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if h.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+h.Token)
+	}
+
+	return doNotifyRequest(h.Client, req)
+}
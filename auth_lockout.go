@@ -0,0 +1,110 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+type authFailures struct {
+	count       int
+	lockedUntil time.Time
+	lastFailure time.Time
+}
+
+// AuthLockout tracks failed authentications per identity. After threshold
+// failures each further one doubles the lockout, from base up to max.
+type AuthLockout struct {
+	threshold int
+	base      time.Duration
+	max       time.Duration
+
+	failures map[string]*authFailures
+
+	mu sync.Mutex
+}
+
+// constructor
+func NewAuthLockout(threshold int, base, max time.Duration) *AuthLockout {
+	return &AuthLockout{threshold: threshold, base: base, max: max, failures: make(map[string]*authFailures)}
+}
+
+// DefaultAuthLockout locks after 5 failures, starting at 1s and capped at 15m
+func DefaultAuthLockout() *AuthLockout {
+	return NewAuthLockout(5, time.Second, 15*time.Minute)
+}
+
+// Locked returns how long identity must wait, zero when it may try
+func (l *AuthLockout) Locked(identity string, now time.Time) time.Duration {
+	if l == nil {
+		return 0
+	}
+
+	l.mu.Lock()
+
+	defer l.mu.Unlock()
+
+	f, ok := l.failures[identity]
+	if !ok || !now.Before(f.lockedUntil) {
+		return 0
+	}
+	return f.lockedUntil.Sub(now)
+}
+
+func (l *AuthLockout) Failure(identity string, now time.Time) {
+	if l == nil {
+		return
+	}
+
+	l.mu.Lock()
+
+	defer l.mu.Unlock()
+
+	// forgotten once the longest lockout has passed quietly
+	for id, f := range l.failures {
+		if now.Sub(f.lastFailure) > 2*l.max {
+			delete(l.failures, id)
+		}
+	}
+
+	f, ok := l.failures[identity]
+	if !ok {
+		f = &authFailures{}
+		l.failures[identity] = f
+	}
+	f.count++
+	f.lastFailure = now
+
+	if over := f.count - l.threshold; over >= 0 {
+		lock := l.max
+		if over < 32 {
+			lock = time.Duration(math.Min(float64(l.base)*math.Pow(2, float64(over)), float64(l.max)))
+		}
+		f.lockedUntil = now.Add(lock)
+		audit(context.Background(), logAuth, "Locked out", "identity", identity, "for", lock, "failures", f.count)
+	}
+}
+
+func (l *AuthLockout) Success(identity string) {
+	if l == nil {
+		return
+	}
+
+	l.mu.Lock()
+
+	defer l.mu.Unlock()
+
+	delete(l.failures, identity)
+}
+
+// tooManyAttempts answers a locked out caller
+func tooManyAttempts(w http.ResponseWriter, r *http.Request, wait time.Duration) {
+	retry := strconv.Itoa(int(math.Ceil(wait.Seconds())))
+	w.Header().Set("Retry-After", retry)
+	err := fmt.Errorf("%w, retry in %s", errTooManyAttempts, wait.Round(time.Second))
+	writeError(w, r, http.StatusTooManyRequests, withDetails(err, map[string]string{"retry_after": retry}))
+}
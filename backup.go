@@ -0,0 +1,420 @@
+package main
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// backups are named w/ the time they were taken, so names sort oldest first
+const (
+	backupPrefix     = "sts-backup-"
+	backupSuffix     = ".json"
+	backupNameFormat = "20060102T150405.000000000Z"
+	backupVersion    = 1
+)
+
+var errBackupKey = errors.New("backup key must be 32 bytes, base64 encoded")
+
+// backupEntry is one key as it is kept in a snapshot, material included
+type backupEntry struct {
+	KeyID      string     `json:"keyId"`
+	KeyType    string     `json:"keyType"`
+	Key        []byte     `json:"key"`
+	Namespace  string     `json:"namespace,omitempty"`
+	Policy     KeyPolicy  `json:"policy"`
+	Uses       int        `json:"uses"`
+	CreatedAt  time.Time  `json:"createdAt"`
+	LastUsedAt time.Time  `json:"lastUsedAt,omitempty"`
+	Frozen     *KeyFreeze `json:"frozen,omitempty"`
+}
+
+// backupFile is what a destination holds. Only the header is readable, the
+// keys are sealed w/ AES-256-GCM and the header is authenticated w/ them.
+type backupFile struct {
+	Version   int       `json:"version"`
+	CreatedAt time.Time `json:"createdAt"`
+	Keys      int       `json:"keys"`
+
+	Nonce      []byte `json:"nonce"`
+	Ciphertext []byte `json:"ciphertext"`
+}
+
+func (b backupFile) additionalData() []byte {
+	return []byte(fmt.Sprintf("sts-backup/%d/%s/%d", b.Version, b.CreatedAt.Format(time.RFC3339Nano), b.Keys))
+}
+
+// snapshot copies every key out of the store, material included
+func (s *SecureKeyStore) snapshot() []backupEntry {
+	s.mu.RLock()
+
+	defer s.mu.RUnlock()
+
+	entries := make([]backupEntry, 0, len(s.keys))
+	for id, e := range s.keys {
+		entries = append(entries, backupEntry{
+			KeyID:      id,
+			KeyType:    e.keyType,
+			Key:        append([]byte(nil), e.key...),
+			Namespace:  e.namespace,
+			Policy:     e.policy,
+			Uses:       e.uses,
+			CreatedAt:  e.createdAt,
+			LastUsedAt: e.lastUsedAt,
+			Frozen:     e.frozen,
+		})
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].KeyID < entries[j].KeyID })
+	return entries
+}
+
+// restore puts snapshot keys back, keys already held are left alone. It
+// returns how many were restored.
+func (s *SecureKeyStore) restore(entries []backupEntry) int {
+	s.mu.Lock()
+
+	defer s.mu.Unlock()
+
+	restored := 0
+	for _, e := range entries {
+		if _, ok := s.keys[e.KeyID]; ok {
+			continue
+		}
+		s.keys[e.KeyID] = &keyEntry{
+			keyType:    e.KeyType,
+			key:        e.Key,
+			policy:     e.Policy,
+			namespace:  e.Namespace,
+			frozen:     e.Frozen,
+			uses:       e.Uses,
+			createdAt:  e.CreatedAt,
+			lastUsedAt: e.LastUsedAt,
+		}
+		restored++
+	}
+	return restored
+}
+
+// ParseBackupKey decodes the base64 AES-256 key snapshots are sealed w/
+func ParseBackupKey(raw string) ([]byte, error) {
+	key, err := base64.StdEncoding.DecodeString(strings.TrimSpace(raw))
+	if err != nil || len(key) != 32 {
+		return nil, errBackupKey
+	}
+	return key, nil
+}
+
+func backupAEAD(key []byte) (cipher.AEAD, error) {
+	if len(key) != 32 {
+		return nil, errBackupKey
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// EncryptBackup seals a snapshot of the store
+func EncryptBackup(store *SecureKeyStore, key []byte, now time.Time) ([]byte, int, error) {
+	aead, err := backupAEAD(key)
+	if err != nil {
+		return nil, 0, err
+	}
+	entries := store.snapshot()
+	plain, err := json.Marshal(entries)
+	for _, e := range entries {
+		for i := range e.Key {
+			e.Key[i] = 0
+		}
+	}
+	if err != nil {
+		return nil, 0, err
+	}
+	defer func() {
+		for i := range plain {
+			plain[i] = 0
+		}
+	}()
+
+	file := backupFile{Version: backupVersion, CreatedAt: now.UTC(), Keys: len(entries), Nonce: make([]byte, aead.NonceSize())}
+	if _, err := rand.Read(file.Nonce); err != nil {
+		return nil, 0, err
+	}
+	file.Ciphertext = aead.Seal(nil, file.Nonce, plain, file.additionalData())
+	raw, err := json.Marshal(file)
+	return raw, len(entries), err
+}
+
+// RestoreBackup opens a snapshot and puts its keys into the store, keys
+// already held are kept as they are
+func RestoreBackup(store *SecureKeyStore, raw, key []byte) (int, error) {
+	aead, err := backupAEAD(key)
+	if err != nil {
+		return 0, err
+	}
+	var file backupFile
+	if err := json.Unmarshal(raw, &file); err != nil {
+		return 0, fmt.Errorf("not a backup: %w", err)
+	}
+	if file.Version != backupVersion {
+		return 0, fmt.Errorf("unsupported backup version %d", file.Version)
+	}
+	if len(file.Nonce) != aead.NonceSize() {
+		return 0, errors.New("backup is corrupt")
+	}
+	plain, err := aead.Open(nil, file.Nonce, file.Ciphertext, file.additionalData())
+	if err != nil {
+		return 0, errors.New("backup is corrupt or sealed w/ another key")
+	}
+	defer func() {
+		for i := range plain {
+			plain[i] = 0
+		}
+	}()
+	var entries []backupEntry
+	if err := json.Unmarshal(plain, &entries); err != nil {
+		return 0, fmt.Errorf("backup is corrupt: %w", err)
+	}
+	return store.restore(entries), nil
+}
+
+// BackupDestination is where snapshots are kept
+type BackupDestination interface {
+	Name() string
+	Put(ctx context.Context, name string, data []byte) error
+
+	// List returns the names of the snapshots held
+	List(ctx context.Context) ([]string, error)
+	Delete(ctx context.Context, name string) error
+}
+
+// DirBackupDestination keeps snapshots as files in a directory, e.g. a
+// mounted volume that is itself replicated off the host
+type DirBackupDestination struct {
+	Dir string
+}
+
+func (d *DirBackupDestination) Name() string { return "dir:" + d.Dir }
+
+// Put writes via a temp file, so a crash never leaves half a snapshot
+func (d *DirBackupDestination) Put(ctx context.Context, name string, data []byte) error {
+	if err := os.MkdirAll(d.Dir, 0o700); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(d.Dir, ".tmp-"+name)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), filepath.Join(d.Dir, name))
+}
+
+func (d *DirBackupDestination) List(ctx context.Context) ([]string, error) {
+	files, err := os.ReadDir(d.Dir)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var names []string
+	for _, f := range files {
+		if !f.IsDir() && strings.HasPrefix(f.Name(), backupPrefix) && strings.HasSuffix(f.Name(), backupSuffix) {
+			names = append(names, f.Name())
+		}
+	}
+	return names, nil
+}
+
+func (d *DirBackupDestination) Delete(ctx context.Context, name string) error {
+	return os.Remove(filepath.Join(d.Dir, name))
+}
+
+// BackupRetention is which snapshots are kept, the newest always is. Zero
+// fields don't limit.
+type BackupRetention struct {
+	KeepLast int
+	MaxAge   time.Duration
+}
+
+// BackupResult is the outcome of one backup
+type BackupResult struct {
+	Name    string    `json:"name"`
+	Keys    int       `json:"keys"`
+	Bytes   int       `json:"bytes"`
+	At      time.Time `json:"at"`
+	Deleted []string  `json:"deleted"`
+}
+
+// BackupScheduler seals a snapshot of the key store every interval, puts it
+// at the destination and prunes old ones by the retention policy. Outcomes
+// are counted in metrics.
+type BackupScheduler struct {
+	store     *SecureKeyStore
+	dest      BackupDestination
+	key       []byte
+	interval  time.Duration
+	retention BackupRetention
+
+	// success and failure counts, nil counts nothing
+	metrics *Metrics
+
+	// one backup at a time, a manual one may race the schedule
+	mu sync.Mutex
+}
+
+// constructor
+func NewBackupScheduler(store *SecureKeyStore, dest BackupDestination, key []byte, interval time.Duration, retention BackupRetention) (*BackupScheduler, error) {
+	if _, err := backupAEAD(key); err != nil {
+		return nil, err
+	}
+	if interval <= 0 {
+		return nil, errors.New("backup interval must be positive")
+	}
+	return &BackupScheduler{store: store, dest: dest, key: key, interval: interval, retention: retention}, nil
+}
+
+// BackupSchedulerFromEnv reads STS_BACKUP_DIR, the key (see
+// MasterKeyFromEnv), STS_BACKUP_INTERVAL (default 1h), STS_BACKUP_KEEP
+// (default 24) and STS_BACKUP_MAX_AGE. It returns nil when no directory is
+// set.
+func BackupSchedulerFromEnv(store *SecureKeyStore, getenv func(string) string) (*BackupScheduler, error) {
+	dir := getenv("STS_BACKUP_DIR")
+	if dir == "" {
+		return nil, nil
+	}
+	key, err := MasterKeyFromEnv(getenv)
+	if err != nil {
+		return nil, err
+	}
+	interval := time.Hour
+	if raw := getenv("STS_BACKUP_INTERVAL"); raw != "" {
+		if interval, err = time.ParseDuration(raw); err != nil {
+			return nil, fmt.Errorf("STS_BACKUP_INTERVAL: %w", err)
+		}
+	}
+	retention := BackupRetention{KeepLast: 24}
+	if raw := getenv("STS_BACKUP_KEEP"); raw != "" {
+		if retention.KeepLast, err = strconv.Atoi(raw); err != nil || retention.KeepLast < 0 {
+			return nil, fmt.Errorf("STS_BACKUP_KEEP must be a count, got %q", raw)
+		}
+	}
+	if raw := getenv("STS_BACKUP_MAX_AGE"); raw != "" {
+		if retention.MaxAge, err = time.ParseDuration(raw); err != nil {
+			return nil, fmt.Errorf("STS_BACKUP_MAX_AGE: %w", err)
+		}
+	}
+	return NewBackupScheduler(store, &DirBackupDestination{Dir: dir}, key, interval, retention)
+}
+
+// Backup takes one snapshot now and prunes. A failed prune is logged, the
+// snapshot still counts.
+func (b *BackupScheduler) Backup(ctx context.Context) (BackupResult, error) {
+	b.mu.Lock()
+
+	defer b.mu.Unlock()
+
+	now := time.Now().UTC()
+	raw, keys, err := EncryptBackup(b.store, b.key, now)
+	if err == nil {
+		name := backupPrefix + now.Format(backupNameFormat) + backupSuffix
+		err = b.dest.Put(ctx, name, raw)
+		if err == nil {
+			b.metrics.BackupFinished(now, len(raw), nil)
+			result := BackupResult{Name: name, Keys: keys, Bytes: len(raw), At: now, Deleted: []string{}}
+			if result.Deleted, err = b.prune(ctx, now); err != nil {
+				slog.Error("Failed to prune old backups", logKeystore, "destination", b.dest.Name(), "err", err)
+			}
+			slog.Info("Key store backed up", logKeystore, "destination", b.dest.Name(), "name", name, "keys", keys, "pruned", len(result.Deleted))
+			return result, nil
+		}
+	}
+	b.metrics.BackupFinished(now, 0, err)
+	slog.Error("Key store backup failed", logKeystore, "destination", b.dest.Name(), "err", err)
+	return BackupResult{}, err
+}
+
+// prune deletes snapshots past the retention policy, must be called w/ mu held
+func (b *BackupScheduler) prune(ctx context.Context, now time.Time) ([]string, error) {
+	names, err := b.dest.List(ctx)
+	if err != nil {
+		return []string{}, err
+	}
+	// newest first
+	sort.Sort(sort.Reverse(sort.StringSlice(names)))
+
+	deleted := []string{}
+	for i, name := range names {
+		if i == 0 {
+			continue
+		}
+		expired := b.retention.KeepLast > 0 && i >= b.retention.KeepLast
+		if b.retention.MaxAge > 0 {
+			at, err := time.Parse(backupNameFormat, strings.TrimSuffix(strings.TrimPrefix(name, backupPrefix), backupSuffix))
+			expired = expired || err == nil && now.Sub(at) > b.retention.MaxAge
+		}
+		if !expired {
+			continue
+		}
+		if err := b.dest.Delete(ctx, name); err != nil {
+			return deleted, err
+		}
+		deleted = append(deleted, name)
+	}
+	return deleted, nil
+}
+
+// Run backs up every interval until ctx is done
+func (b *BackupScheduler) Run(ctx context.Context) {
+	ticker := time.NewTicker(b.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			b.Backup(ctx)
+		}
+	}
+}
+
+// handleBackup takes a backup now, e.g. before maintenance
+func (s *APIServer) handleBackup(w http.ResponseWriter, r *http.Request) {
+	result, err := s.Backups.Backup(r.Context())
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, errors.New("backup failed"))
+		return
+	}
+	audit(r.Context(), logAdmin, "Key store backed up", "operator", OperatorFromContext(r.Context()).Name, "name", result.Name, "keys", result.Keys)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(result)
+}
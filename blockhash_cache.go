@@ -0,0 +1,127 @@
+package main
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+)
+
+// a cached blockhash older than this is fetched again before use, well
+// inside the ~60s its 150 blocks take
+const blockhashMaxAge = 20 * time.Second
+
+// system program AdvanceNonceAccount, first in a durable nonce transaction
+const systemAdvanceNonce = 4
+
+var errDurableNonce = errors.New("transaction uses a durable nonce, its blockhash is the nonce")
+
+// BlockhashCache keeps a recent blockhash at hand, refreshed in the
+// background, so sign requests don't each wait on the cluster for one
+type BlockhashCache struct {
+	chain ChainClient
+
+	latest    Blockhash
+	fetchedAt time.Time
+
+	mu sync.Mutex
+}
+
+// constructor
+func NewBlockhashCache(chain ChainClient) *BlockhashCache {
+	return &BlockhashCache{chain: chain}
+}
+
+// Latest is the cached blockhash, fetched first when it's too old. Nil
+// safe, w/o a cache there is no rpc.
+func (c *BlockhashCache) Latest(ctx context.Context) (Blockhash, error) {
+	if c == nil {
+		return Blockhash{}, errNoRPC
+	}
+	c.mu.Lock()
+	latest, fresh := c.latest, time.Since(c.fetchedAt) < blockhashMaxAge
+	c.mu.Unlock()
+	if fresh {
+		return latest, nil
+	}
+	return c.Refresh(ctx)
+}
+
+// Refresh fetches a new blockhash from the cluster
+func (c *BlockhashCache) Refresh(ctx context.Context) (Blockhash, error) {
+	latest, err := c.chain.LatestBlockhash(ctx)
+	if err != nil {
+		return Blockhash{}, err
+	}
+	c.mu.Lock()
+
+	defer c.mu.Unlock()
+
+	// concurrent refreshes can finish out of order, keep the newest
+	if latest.Slot >= c.latest.Slot {
+		c.latest, c.fetchedAt = latest, time.Now()
+	}
+	return c.latest, nil
+}
+
+// Run refreshes every interval until ctx is done
+func (c *BlockhashCache) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if _, err := c.Refresh(ctx); err != nil {
+				slog.Warn("Blockhash refresh failed", logSigner, "err", err)
+			}
+		}
+	}
+}
+
+// usesDurableNonce says whether the message advances a nonce account first,
+// its blockhash is then the nonce and must stay
+func usesDurableNonce(msg *SolanaMessage) bool {
+	if len(msg.Instructions) == 0 {
+		return false
+	}
+	ix := msg.Instructions[0]
+	return msg.AccountKeys[ix.ProgramIDIndex] == SystemProgramID && len(ix.Data) >= 4 &&
+		binary.LittleEndian.Uint32(ix.Data) == systemAdvanceNonce
+}
+
+// SetRecentBlockhash swaps in a new blockhash. The message changes, so
+// nobody may have signed it yet.
+func (p *SolanaPayload) SetRecentBlockhash(blockhash SolanaPubkey) error {
+	if p.AnySigned() {
+		return fmt.Errorf("%w, its blockhash can't change", errTxAlreadySigned)
+	}
+	if usesDurableNonce(p.Message) {
+		return errDurableNonce
+	}
+	p.Message.RecentBlockhash = blockhash
+	p.MsgBytes = p.Message.Marshal()
+	return nil
+}
+
+// refreshBlockhash patches the cached blockhash into the transaction
+func refreshBlockhash(ctx context.Context, blockhashes *BlockhashCache, tx *SolanaPayload, result *TransactionResult) error {
+	latest, err := blockhashes.Latest(ctx)
+	if err != nil {
+		return fmt.Errorf("recent blockhash unavailable: %w", err)
+	}
+	hash, err := ParseSolanaPubkey(latest.Hash)
+	if err != nil {
+		return fmt.Errorf("recent blockhash unavailable: %w", err)
+	}
+	if err := tx.SetRecentBlockhash(hash); err != nil {
+		return err
+	}
+	result.Blockhash, result.LastValidBlockHeight = latest.Hash, latest.LastValidBlockHeight
+	return nil
+}
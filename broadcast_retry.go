@@ -0,0 +1,127 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"math/rand/v2"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	errBlockhashExpired = errors.New("blockhash expired, sign the transaction again w/ a recent one")
+	errRPCUnavailable   = errors.New("cluster rpc unavailable")
+)
+
+// BroadcastAttempt is one sendTransaction call, reported in the sign result
+type BroadcastAttempt struct {
+	Attempt int       `json:"attempt"`
+	At      time.Time `json:"at"`
+	Error   string    `json:"error,omitempty"`
+
+	// the failure was transient and the broadcast went on
+	Retryable bool `json:"retryable,omitempty"`
+}
+
+// BroadcastRetry is how transient broadcast failures are retried. The wait
+// doubles from Base up to Max, each w/ jitter.
+type BroadcastRetry struct {
+	Attempts int
+	Base     time.Duration
+	Max      time.Duration
+}
+
+var defaultBroadcastRetry = BroadcastRetry{Attempts: 5, Base: 250 * time.Millisecond, Max: 4 * time.Second}
+
+// delay is the wait after the attempt'th failure, between half and all of
+// the backoff so retries from many requests don't line up
+func (r BroadcastRetry) delay(attempt int) time.Duration {
+	d := r.Base << (attempt - 1)
+	if d > r.Max || d <= 0 {
+		d = r.Max
+	}
+	return d/2 + rand.N(d/2+1)
+}
+
+// transientRPCError says whether sending again may work: the endpoint was
+// unreachable, overloaded or behind. A rejected transaction isn't.
+func transientRPCError(err error) bool {
+	if errors.Is(err, context.Canceled) {
+		return false
+	}
+	var status *rpcStatusError
+	if errors.As(err, &status) {
+		return status.Status == http.StatusTooManyRequests || status.Status >= 500
+	}
+	var rpcErr *rpcError
+	if errors.As(err, &rpcErr) {
+		// node unhealthy or behind, block not available, internal error
+		return rpcErr.Code == -32005 || rpcErr.Code == -32004 || rpcErr.Code == -32603
+	}
+	var netErr net.Error
+	return errors.As(err, &netErr) || errors.Is(err, io.ErrUnexpectedEOF) || errors.Is(err, io.EOF)
+}
+
+// blockhashExpired says whether preflight refused the transaction for a
+// blockhash the cluster no longer knows
+func blockhashExpired(err error) bool {
+	var rpcErr *rpcError
+	if !errors.As(err, &rpcErr) {
+		return false
+	}
+	return strings.Contains(rpcErr.Message, "Blockhash not found") || strings.Contains(string(rpcErr.Data), "BlockhashNotFound")
+}
+
+// sendWithRetry broadcasts wire, retrying transient failures until the
+// attempts run out, ctx is done or the blockhash expires. Sending a signed
+// transaction again is safe, the cluster only ever lands it once.
+func (s *signerService) sendWithRetry(ctx context.Context, chain ChainClient, tx *SolanaPayload, wire []byte, result *TransactionResult) (string, error) {
+	retry := s.broadcastRetry
+	if retry.Attempts <= 0 {
+		retry = defaultBroadcastRetry
+	}
+	blockhash := tx.Message.RecentBlockhash.String()
+
+	for attempt := 1; ; attempt++ {
+		txSig, err := chain.SendTransaction(ctx, wire)
+		a := BroadcastAttempt{Attempt: attempt, At: time.Now().UTC()}
+		if err == nil {
+			result.BroadcastAttempts = append(result.BroadcastAttempts, a)
+			return txSig, nil
+		}
+		a.Error = err.Error()
+		a.Retryable = transientRPCError(err) && attempt < retry.Attempts
+		result.BroadcastAttempts = append(result.BroadcastAttempts, a)
+
+		details := map[string]string{"attempts": strconv.Itoa(attempt)}
+		if blockhashExpired(err) {
+			return "", withDetails(fmt.Errorf("%w: %v", errBlockhashExpired, err), details)
+		}
+		if !a.Retryable {
+			if transientRPCError(err) {
+				err = fmt.Errorf("%w after %d attempts: %v", errRPCUnavailable, attempt, err)
+			}
+			return "", withDetails(err, details)
+		}
+
+		wait := retry.delay(attempt)
+		slog.WarnContext(ctx, "Broadcast failed, retrying", logSigner, "key_id", result.KeyID, "attempt", attempt, "wait", wait, "err", err)
+		select {
+		case <-ctx.Done():
+			return "", withDetails(fmt.Errorf("%w after %d attempts: %v", errRPCUnavailable, attempt, ctx.Err()), details)
+		case <-time.After(wait):
+		}
+
+		// a transaction w/ an expired blockhash can never land, stop here
+		// rather than retry into the void
+		if valid, err := chain.BlockhashValid(ctx, blockhash); err == nil && !valid {
+			return "", withDetails(errBlockhashExpired, details)
+		}
+	}
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"runtime"
+	"runtime/debug"
+)
+
+// set at build time:
+//
+//	go build -ldflags "-X main.version=1.4.0 -X main.commit=$(git rev-parse HEAD) -X main.buildDate=$(date -u +%FT%TZ)"
+//
+// commit and buildDate fall back to the VCS stamp go build embeds
+var (
+	version   = "dev"
+	commit    = ""
+	buildDate = ""
+)
+
+// BuildInfo is what's running, for fleet inventory
+type BuildInfo struct {
+	Version   string `json:"version"`
+	Commit    string `json:"commit,omitempty"`
+	BuildDate string `json:"buildDate,omitempty"`
+	GoVersion string `json:"goVersion"`
+
+	// key store backends this build supports and the one in use
+	Backends []string `json:"backends"`
+	Backend  string   `json:"backend"`
+
+	FIPS FIPSStatus `json:"fips"`
+}
+
+// CurrentBuildInfo describes this binary running w/ backend
+func CurrentBuildInfo(backend string) BuildInfo {
+	info := BuildInfo{
+		Version:   version,
+		Commit:    commit,
+		BuildDate: buildDate,
+		GoVersion: runtime.Version(),
+		Backends:  backends,
+		Backend:   backend,
+		FIPS:      CurrentFIPSStatus(),
+	}
+	if bi, ok := debug.ReadBuildInfo(); ok {
+		for _, setting := range bi.Settings {
+			switch {
+			case setting.Key == "vcs.revision" && info.Commit == "":
+				info.Commit = setting.Value
+			case setting.Key == "vcs.time" && info.BuildDate == "":
+				info.BuildDate = setting.Value
+			}
+		}
+	}
+	return info
+}
+
+func (s *APIServer) handleVersion(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := s.checkRead(r, ""); err != nil {
+		refuseAPIToken(w, r, err)
+		return
+	}
+
+	json.NewEncoder(w).Encode(CurrentBuildInfo(s.Config.orDefaults().Backend))
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/netip"
+	"slices"
+)
+
+var errCallerNotAllowed = errors.New("caller is not allowed to use this key")
+
+// CheckCaller enforces the key's network and client identity rules. ip is the
+// invalid zero value when the caller's address is unknown.
+func (p KeyPolicy) CheckCaller(ip netip.Addr, identities []string) error {
+	if len(p.AllowedCIDRs) > 0 {
+		allowed := false
+		for _, cidr := range p.AllowedCIDRs {
+			prefix, _ := netip.ParsePrefix(cidr)
+			allowed = allowed || (ip.IsValid() && prefix.Contains(ip.Unmap()))
+		}
+		if !allowed {
+			return fmt.Errorf("%w: address %s is outside the allowed networks", errCallerNotAllowed, ip)
+		}
+	}
+
+	if len(p.AllowedClients) > 0 {
+		allowed := false
+		for _, id := range identities {
+			allowed = allowed || slices.Contains(p.AllowedClients, id)
+		}
+		if !allowed {
+			return fmt.Errorf("%w: client certificate identity is not allowed", errCallerNotAllowed)
+		}
+	}
+	return nil
+}
+
+// clientIdentities are the names on a verified client certificate, empty
+// w/o mTLS
+func clientIdentities(r *http.Request) []string {
+	if r.TLS == nil || len(r.TLS.VerifiedChains) == 0 {
+		return nil
+	}
+
+	cert := r.TLS.VerifiedChains[0][0]
+	ids := []string{}
+	if cert.Subject.CommonName != "" {
+		ids = append(ids, cert.Subject.CommonName)
+	}
+	ids = append(ids, cert.DNSNames...)
+	ids = append(ids, cert.EmailAddresses...)
+	for _, uri := range cert.URIs {
+		ids = append(ids, uri.String())
+	}
+	return ids
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"context"
+	"crypto/ed25519"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"log/slog"
+)
+
+// canarySign answers a sign request for a canary key. Nothing is signed w/
+// the key, the response carries a signature from a throwaway key of the same
+// type so it looks real to whoever is holding a stolen credential.
+func (s *signerService) canarySign(ctx context.Context, req TransactionRequest, keyType string, rawTxData []byte, tx *SolanaPayload, result TransactionResult) (TransactionResult, error) {
+	tenant := TenantFromContext(ctx)
+	slog.WarnContext(ctx, "Sign attempted w/ canary key", logSigner, "key_id", req.KeyID, "tenant", tenant)
+	s.notifier.Dispatch(Notification{
+		Kind:     NotifySecurityAlert,
+		Severity: SeverityCritical,
+		Title:    "Canary key used",
+		Message:  "A sign request was made w/ a canary key, the credential that made it is likely compromised",
+		KeyID:    req.KeyID,
+		Details:  map[string]string{"tenant": tenant, "context": req.Context},
+	})
+
+	_, decoy, err := generateKeyPair(keyType)
+	if err != nil {
+		return result, fmt.Errorf("signing failed w/ error: %w", err)
+	}
+	defer clear(decoy)
+
+	var sig []byte
+	mode := SigningModeEd25519
+	if tx != nil {
+		sig = ed25519.Sign(ed25519.PrivateKey(decoy), tx.MsgBytes)
+		pub, _ := hex.DecodeString(req.KeyID)
+		wire, err := tx.WireTransaction(SolanaAddress(ed25519.PublicKey(pub)), sig)
+		if err != nil {
+			return result, fmt.Errorf("signing failed w/ error: %w", err)
+		}
+		result.Transaction = base64.StdEncoding.EncodeToString(wire)
+		if len(tx.Signatures[0]) > 0 {
+			result.TxSignature = base58Encode(tx.Signatures[0])
+		}
+	} else if sig, mode, err = signWithKey(keyType, decoy, rawTxData, req.SigningMode, req.Context); err != nil {
+		return result, fmt.Errorf("signing failed w/ error: %w", err)
+	}
+
+	result.Signature = base64.StdEncoding.EncodeToString(sig)
+	result.SigningMode = mode
+	result.Context = req.Context
+	result.BroadcastStatus = "Signed and Ready"
+	return result, nil
+}
@@ -0,0 +1,311 @@
+package main
+
+import (
+	"crypto/ed25519"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// caveat names, each caveat is "name = value"
+const (
+	CaveatKeys        = "keys"
+	CaveatPrograms    = "programs"
+	CaveatContext     = "context"
+	CaveatMaxLamports = "max_lamports"
+	CaveatMaxToken    = "max_token"
+	CaveatExpires     = "expires"
+)
+
+var (
+	errCapabilityInvalid = errors.New("invalid capability token")
+	errCapabilityDenied  = errors.New("capability does not allow this request")
+)
+
+// Capability is a macaroon-style bearer token. Each caveat narrows what it
+// allows and the signature chains HMACs over all of them, so a holder can
+// add caveats without the root secret but can never remove one.
+type Capability struct {
+	ID        string   `json:"id"`
+	Caveats   []string `json:"caveats"`
+	Signature string   `json:"sig"`
+}
+
+// CapabilityIssuer mints and verifies capabilities w/ a root secret
+type CapabilityIssuer struct {
+	root []byte
+}
+
+// constructor, an empty secret gets a random one and tokens die on restart
+func NewCapabilityIssuer(secret string) (*CapabilityIssuer, error) {
+	root := []byte(secret)
+	if secret == "" {
+		root = make([]byte, 32)
+		if _, err := rand.Read(root); err != nil {
+			return nil, fmt.Errorf("failed to generate capability secret: %w", err)
+		}
+	}
+	return &CapabilityIssuer{root: root}, nil
+}
+
+func chainCaveat(sig []byte, caveat string) []byte {
+	mac := hmac.New(sha256.New, sig)
+	mac.Write([]byte(caveat))
+	return mac.Sum(nil)
+}
+
+func (c Capability) encode() string {
+	raw, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(raw)
+}
+
+func decodeCapability(token string) (Capability, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(token)
+	if err != nil {
+		return Capability{}, errCapabilityInvalid
+	}
+	var c Capability
+	if err := json.Unmarshal(raw, &c); err != nil {
+		return Capability{}, errCapabilityInvalid
+	}
+	return c, nil
+}
+
+// Mint issues a token w/ the given caveats
+func (i *CapabilityIssuer) Mint(caveats []string) (string, error) {
+	for _, caveat := range caveats {
+		if err := validateCaveat(caveat); err != nil {
+			return "", err
+		}
+	}
+
+	var id [16]byte
+	if _, err := rand.Read(id[:]); err != nil {
+		return "", fmt.Errorf("failed to create capability id: %w", err)
+	}
+
+	c := Capability{ID: hex.EncodeToString(id[:]), Caveats: caveats}
+	sig := chainCaveat(i.root, c.ID)
+	for _, caveat := range caveats {
+		sig = chainCaveat(sig, caveat)
+	}
+	c.Signature = hex.EncodeToString(sig)
+	return c.encode(), nil
+}
+
+// Attenuate adds a caveat to a token, no secret is needed
+func Attenuate(token, caveat string) (string, error) {
+	if err := validateCaveat(caveat); err != nil {
+		return "", err
+	}
+	c, err := decodeCapability(token)
+	if err != nil {
+		return "", err
+	}
+	sig, err := hex.DecodeString(c.Signature)
+	if err != nil {
+		return "", errCapabilityInvalid
+	}
+
+	c.Caveats = append(c.Caveats, caveat)
+	c.Signature = hex.EncodeToString(chainCaveat(sig, caveat))
+	return c.encode(), nil
+}
+
+// Verify checks the token's signature chain, caveats are checked by Authorize
+func (i *CapabilityIssuer) Verify(token string) (Capability, error) {
+	c, err := decodeCapability(token)
+	if err != nil {
+		return Capability{}, err
+	}
+	got, err := hex.DecodeString(c.Signature)
+	if err != nil {
+		return Capability{}, errCapabilityInvalid
+	}
+
+	sig := chainCaveat(i.root, c.ID)
+	for _, caveat := range c.Caveats {
+		sig = chainCaveat(sig, caveat)
+	}
+	if !hmac.Equal(sig, got) {
+		return Capability{}, errCapabilityInvalid
+	}
+	return c, nil
+}
+
+func splitCaveat(caveat string) (string, string, bool) {
+	name, value, ok := strings.Cut(caveat, "=")
+	return strings.TrimSpace(name), strings.TrimSpace(value), ok
+}
+
+func validateCaveat(caveat string) error {
+	name, value, ok := splitCaveat(caveat)
+	if !ok || value == "" {
+		return fmt.Errorf("caveat %q must be name = value", caveat)
+	}
+
+	switch name {
+	case CaveatKeys, CaveatContext:
+		return nil
+	case CaveatPrograms:
+		for _, program := range strings.Split(value, ",") {
+			if _, err := ParseSolanaPubkey(strings.TrimSpace(program)); err != nil {
+				return fmt.Errorf("invalid program in caveat: %w", err)
+			}
+		}
+	case CaveatMaxLamports:
+		if _, err := strconv.ParseUint(value, 10, 64); err != nil {
+			return fmt.Errorf("invalid %s caveat: %w", name, err)
+		}
+	case CaveatMaxToken:
+		mint, amount, ok := strings.Cut(value, ":")
+		if _, err := ParseSolanaPubkey(mint); !ok || err != nil {
+			return fmt.Errorf("%s caveat must be mint:amount", name)
+		}
+		if _, err := strconv.ParseUint(amount, 10, 64); err != nil {
+			return fmt.Errorf("invalid %s caveat: %w", name, err)
+		}
+	case CaveatExpires:
+		if _, err := time.Parse(time.RFC3339, value); err != nil {
+			return fmt.Errorf("invalid %s caveat: %w", name, err)
+		}
+	default:
+		return fmt.Errorf("unknown caveat %q", name)
+	}
+	return nil
+}
+
+// Authorize checks every caveat against the request. tx is nil for non
+// Solana payloads, which fail any program or amount caveat.
+func (c Capability) Authorize(req TransactionRequest, tx *SolanaPayload, now time.Time) error {
+	var spends map[string]uint64
+
+	for _, caveat := range c.Caveats {
+		name, value, _ := splitCaveat(caveat)
+		list := strings.Split(value, ",")
+		for i := range list {
+			list[i] = strings.TrimSpace(list[i])
+		}
+
+		var ok bool
+		switch name {
+		case CaveatKeys:
+			ok = slices.Contains(list, req.KeyID)
+		case CaveatContext:
+			ok = slices.Contains(list, req.Context)
+		case CaveatExpires:
+			expires, err := time.Parse(time.RFC3339, value)
+			ok = err == nil && now.Before(expires)
+		case CaveatPrograms:
+			ok = tx != nil && capabilityPrograms(tx.Message, list)
+		case CaveatMaxLamports, CaveatMaxToken:
+			if tx == nil {
+				break
+			}
+			if spends == nil {
+				var err error
+				if spends, err = capabilitySpends(req.KeyID, tx); err != nil {
+					break
+				}
+			}
+			asset, limit := assetLamports, value
+			if name == CaveatMaxToken {
+				asset, limit, _ = strings.Cut(value, ":")
+			}
+			max, err := strconv.ParseUint(limit, 10, 64)
+			ok = err == nil && spends[asset] <= max
+		}
+
+		if !ok {
+			return fmt.Errorf("%w: %s", errCapabilityDenied, caveat)
+		}
+	}
+	return nil
+}
+
+func capabilityPrograms(msg *SolanaMessage, allowed []string) bool {
+	for _, ix := range msg.Instructions {
+		if !slices.Contains(allowed, msg.AccountKeys[ix.ProgramIDIndex].String()) {
+			return false
+		}
+	}
+	return true
+}
+
+func capabilitySpends(keyID string, tx *SolanaPayload) (map[string]uint64, error) {
+	pub, err := hex.DecodeString(keyID)
+	if err != nil || len(pub) != ed25519.PublicKeySize {
+		return nil, errCapabilityDenied
+	}
+	// a token cap is meaningless if the mint isn't named
+	return SpendsFor(tx.Message, SolanaAddress(ed25519.PublicKey(pub)), true)
+}
+
+type CapabilityRequest struct {
+	KeyIDs   []string `json:"keyIds"`
+	Programs []string `json:"programs,omitempty"`
+	Contexts []string `json:"contexts,omitempty"`
+
+	MaxLamports *uint64 `json:"maxLamports,omitempty"`
+
+	// mint to max base units per transaction
+	MaxTokens map[string]uint64 `json:"maxTokens,omitempty"`
+
+	// Go duration, required so no token lives forever
+	ExpiresIn string `json:"expiresIn"`
+}
+
+type CapabilityGrant struct {
+	Token     string    `json:"token"`
+	Caveats   []string  `json:"caveats"`
+	ExpiresAt time.Time `json:"expiresAt"`
+}
+
+// MintFor turns a request into caveats and mints the token
+func (i *CapabilityIssuer) MintFor(req CapabilityRequest, now time.Time) (CapabilityGrant, error) {
+	if len(req.KeyIDs) == 0 {
+		return CapabilityGrant{}, errors.New("keyIds cannot be empty")
+	}
+	ttl, err := time.ParseDuration(req.ExpiresIn)
+	if err != nil || ttl <= 0 {
+		return CapabilityGrant{}, errors.New("expiresIn must be a positive duration")
+	}
+	expires := now.Add(ttl).UTC().Truncate(time.Second)
+
+	caveats := []string{
+		CaveatKeys + " = " + strings.Join(req.KeyIDs, ","),
+		CaveatExpires + " = " + expires.Format(time.RFC3339),
+	}
+	if len(req.Programs) > 0 {
+		caveats = append(caveats, CaveatPrograms+" = "+strings.Join(req.Programs, ","))
+	}
+	if len(req.Contexts) > 0 {
+		caveats = append(caveats, CaveatContext+" = "+strings.Join(req.Contexts, ","))
+	}
+	if req.MaxLamports != nil {
+		caveats = append(caveats, fmt.Sprintf("%s = %d", CaveatMaxLamports, *req.MaxLamports))
+	}
+	mints := make([]string, 0, len(req.MaxTokens))
+	for mint := range req.MaxTokens {
+		mints = append(mints, mint)
+	}
+	slices.Sort(mints)
+	for _, mint := range mints {
+		caveats = append(caveats, fmt.Sprintf("%s = %s:%d", CaveatMaxToken, mint, req.MaxTokens[mint]))
+	}
+
+	token, err := i.Mint(caveats)
+	if err != nil {
+		return CapabilityGrant{}, err
+	}
+	return CapabilityGrant{Token: token, Caveats: caveats, ExpiresAt: expires}, nil
+}
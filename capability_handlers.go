@@ -0,0 +1,58 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// checkCapability verifies the request's capability token w/o calling the signer
+func (s *APIServer) checkCapability(r *http.Request, req TransactionRequest) error {
+	token := r.Header.Get("X-STS-Capability")
+	if token == "" {
+		if s.RequireCapability {
+			return fmt.Errorf("%w: X-STS-Capability header is required", errCapabilityInvalid)
+		}
+		return nil
+	}
+	if s.Capabilities == nil {
+		return fmt.Errorf("%w: capabilities are not enabled", errCapabilityInvalid)
+	}
+
+	c, err := s.Capabilities.Verify(token)
+	if err != nil {
+		return err
+	}
+
+	// program and amount caveats need the transaction, bad payloads are left
+	// for the signer to report
+	var tx *SolanaPayload
+	if req.Context == SolanaTxContext {
+		if raw, err := base64.StdEncoding.DecodeString(req.UnsignedTxData); err == nil {
+			tx, _ = ParseSolanaPayload(raw)
+		}
+	}
+	return c.Authorize(req, tx, time.Now())
+}
+
+func (s *APIServer) handleCapabilityMint(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	var req CapabilityRequest
+	if err := decodeJSON(r.Body, &req); err != nil {
+		writeError(w, r, http.StatusBadRequest, invalidBody(err))
+		return
+	}
+
+	grant, err := s.Capabilities.MintFor(req, time.Now())
+	if err != nil {
+		writeError(w, r, http.StatusBadRequest, err)
+		return
+	}
+
+	audit(r.Context(), logAdmin, "Capability token minted", "operator", OperatorFromContext(r.Context()).Name, "key_ids", req.KeyIDs, "expires", grant.ExpiresAt)
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(grant)
+}
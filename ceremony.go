@@ -0,0 +1,346 @@
+package main
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ceremony statuses
+const (
+	CeremonyOpen      = "open"
+	CeremonyCompleted = "completed"
+	CeremonyAborted   = "aborted"
+)
+
+// smallest offline entropy contribution we accept
+const minCeremonyEntropy = 32
+
+var (
+	errCeremonyNotFound = errors.New("ceremony not found")
+	errCeremonyState    = errors.New("ceremony is not open")
+	errCeremonyAbsent   = errors.New("operator has not signed the attendance log")
+	errCeremonyQuorum   = errors.New("ceremony requirements not met")
+)
+
+type CeremonyRequest struct {
+	Purpose string `json:"purpose"`
+
+	// Shamir split, any Threshold of Shares rebuild the root key
+	Shares    int `json:"shares"`
+	Threshold int `json:"threshold"`
+
+	// operators who must attend before the key is made, defaults to Threshold
+	MinAttendees int `json:"minAttendees,omitempty"`
+}
+
+type CeremonyEvent struct {
+	At       time.Time `json:"at"`
+	Operator string    `json:"operator,omitempty"`
+	Event    string    `json:"event"`
+}
+
+// Ceremony is a guided root key generation. The key is only ever held long
+// enough to be split, the service never stores it.
+type Ceremony struct {
+	ID           string `json:"id"`
+	Purpose      string `json:"purpose"`
+	Status       string `json:"status"`
+	Shares       int    `json:"shares"`
+	Threshold    int    `json:"threshold"`
+	MinAttendees int    `json:"minAttendees"`
+
+	Attendees    []string `json:"attendees"`
+	Contributors []string `json:"contributors"`
+
+	PublicKey string `json:"publicKey,omitempty"`
+
+	// sha256 of each share so custodians can check theirs against the transcript
+	ShareFingerprints []string `json:"shareFingerprints,omitempty"`
+
+	Events []CeremonyEvent `json:"events"`
+
+	CreatedAt time.Time `json:"createdAt"`
+
+	// running hash of system and operator entropy
+	pool hash.Hash
+}
+
+// CeremonyResult is returned once on completion, the shares are not kept
+type CeremonyResult struct {
+	Ceremony
+	Shares []string `json:"shareValues"`
+}
+
+type CeremonyManager struct {
+	ceremonies map[string]*Ceremony
+
+	mu sync.Mutex
+}
+
+// constructor
+func NewCeremonyManager() *CeremonyManager {
+	return &CeremonyManager{ceremonies: make(map[string]*Ceremony)}
+}
+
+func (m *CeremonyManager) Start(req CeremonyRequest, op Operator) (Ceremony, error) {
+	if req.Purpose == "" {
+		return Ceremony{}, errors.New("purpose cannot be empty")
+	}
+	if req.Threshold < 2 || req.Threshold > req.Shares || req.Shares > 255 {
+		return Ceremony{}, fmt.Errorf("need 2 <= threshold <= shares <= 255, got %d of %d", req.Threshold, req.Shares)
+	}
+	if req.MinAttendees == 0 {
+		req.MinAttendees = req.Threshold
+	}
+	if req.MinAttendees < 2 {
+		return Ceremony{}, errors.New("minAttendees must be at least 2")
+	}
+
+	var id [16]byte
+	if _, err := rand.Read(id[:]); err != nil {
+		return Ceremony{}, fmt.Errorf("failed to create ceremony id: %w", err)
+	}
+
+	// seeded w/ system entropy so operators alone can't choose the key
+	seed := make([]byte, 64)
+	if _, err := rand.Read(seed); err != nil {
+		return Ceremony{}, fmt.Errorf("failed to seed ceremony entropy: %w", err)
+	}
+	pool := sha512.New()
+	pool.Write(seed)
+	clear(seed)
+
+	c := &Ceremony{
+		ID:           hex.EncodeToString(id[:]),
+		Purpose:      req.Purpose,
+		Status:       CeremonyOpen,
+		Shares:       req.Shares,
+		Threshold:    req.Threshold,
+		MinAttendees: req.MinAttendees,
+		Attendees:    []string{},
+		Contributors: []string{},
+		CreatedAt:    time.Now(),
+		pool:         pool,
+	}
+
+	m.mu.Lock()
+
+	defer m.mu.Unlock()
+
+	m.ceremonies[c.ID] = c
+	m.record(c, op.Name, fmt.Sprintf("started for %q, %d of %d shares, %d attendees required", req.Purpose, req.Threshold, req.Shares, req.MinAttendees))
+	return c.view(), nil
+}
+
+// Attend signs the operator into the attendance log
+func (m *CeremonyManager) Attend(id string, op Operator) (Ceremony, error) {
+	m.mu.Lock()
+
+	defer m.mu.Unlock()
+
+	c, err := m.open(id)
+	if err != nil {
+		return Ceremony{}, err
+	}
+	if !slices.Contains(c.Attendees, op.Name) {
+		c.Attendees = append(c.Attendees, op.Name)
+		m.record(c, op.Name, "signed attendance log")
+	}
+	return c.view(), nil
+}
+
+// Contribute mixes offline entropy, e.g. dice rolls, into the pool. Only a
+// fingerprint of the contribution is logged.
+func (m *CeremonyManager) Contribute(id string, op Operator, entropy []byte) (Ceremony, error) {
+	defer clear(entropy)
+
+	if len(entropy) < minCeremonyEntropy {
+		return Ceremony{}, fmt.Errorf("entropy must be at least %d bytes", minCeremonyEntropy)
+	}
+
+	m.mu.Lock()
+
+	defer m.mu.Unlock()
+
+	c, err := m.open(id)
+	if err != nil {
+		return Ceremony{}, err
+	}
+	if !slices.Contains(c.Attendees, op.Name) {
+		return Ceremony{}, errCeremonyAbsent
+	}
+
+	// length prefixed so contributions can't be shifted between operators
+	var size [8]byte
+	binary.BigEndian.PutUint64(size[:], uint64(len(entropy)))
+	c.pool.Write(size[:])
+	c.pool.Write(entropy)
+
+	fingerprint := sha256.Sum256(entropy)
+	c.Contributors = append(c.Contributors, op.Name)
+	m.record(c, op.Name, fmt.Sprintf("mixed %d bytes of entropy, fingerprint %x", len(entropy), fingerprint[:8]))
+	return c.view(), nil
+}
+
+// Complete generates the root key and splits it at once
+func (m *CeremonyManager) Complete(id string, op Operator) (CeremonyResult, error) {
+	m.mu.Lock()
+
+	defer m.mu.Unlock()
+
+	c, err := m.open(id)
+	if err != nil {
+		return CeremonyResult{}, err
+	}
+	if !slices.Contains(c.Attendees, op.Name) {
+		return CeremonyResult{}, errCeremonyAbsent
+	}
+	if len(c.Attendees) < c.MinAttendees {
+		return CeremonyResult{}, fmt.Errorf("%w: %d of %d attendees present", errCeremonyQuorum, len(c.Attendees), c.MinAttendees)
+	}
+	if len(c.Contributors) == 0 {
+		return CeremonyResult{}, fmt.Errorf("%w: no offline entropy was mixed in", errCeremonyQuorum)
+	}
+
+	fresh := make([]byte, 32)
+	if _, err := rand.Read(fresh); err != nil {
+		return CeremonyResult{}, fmt.Errorf("failed to read entropy: %w", err)
+	}
+	c.pool.Write(fresh)
+	clear(fresh)
+
+	digest := c.pool.Sum(nil)
+	defer clear(digest)
+	seed := digest[:ed25519.SeedSize]
+
+	key := ed25519.NewKeyFromSeed(seed)
+	defer clear(key)
+
+	shares, err := SplitSecret(seed, c.Shares, c.Threshold)
+	if err != nil {
+		return CeremonyResult{}, err
+	}
+
+	result := CeremonyResult{Shares: make([]string, len(shares))}
+	c.PublicKey = hex.EncodeToString(key.Public().(ed25519.PublicKey))
+	for i, share := range shares {
+		fingerprint := sha256.Sum256(share)
+		c.ShareFingerprints = append(c.ShareFingerprints, hex.EncodeToString(fingerprint[:]))
+		result.Shares[i] = hex.EncodeToString(share)
+		clear(share)
+	}
+
+	c.Status = CeremonyCompleted
+	c.pool = nil
+	m.record(c, op.Name, fmt.Sprintf("generated root key %s and split it into %d shares", c.PublicKey, c.Shares))
+
+	result.Ceremony = c.view()
+	return result, nil
+}
+
+func (m *CeremonyManager) Abort(id string, op Operator, reason string) (Ceremony, error) {
+	m.mu.Lock()
+
+	defer m.mu.Unlock()
+
+	c, err := m.open(id)
+	if err != nil {
+		return Ceremony{}, err
+	}
+	c.Status = CeremonyAborted
+	c.pool = nil
+	m.record(c, op.Name, "aborted: "+reason)
+	return c.view(), nil
+}
+
+func (m *CeremonyManager) Get(id string) (Ceremony, error) {
+	m.mu.Lock()
+
+	defer m.mu.Unlock()
+
+	c, ok := m.ceremonies[id]
+	if !ok {
+		return Ceremony{}, errCeremonyNotFound
+	}
+	return c.view(), nil
+}
+
+// Transcript renders a printable record of the ceremony
+func (m *CeremonyManager) Transcript(id string) (string, error) {
+	c, err := m.Get(id)
+	if err != nil {
+		return "", err
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "KEY CEREMONY TRANSCRIPT\n\n")
+	fmt.Fprintf(&b, "Ceremony:    %s\n", c.ID)
+	fmt.Fprintf(&b, "Purpose:     %s\n", c.Purpose)
+	fmt.Fprintf(&b, "Status:      %s\n", c.Status)
+	fmt.Fprintf(&b, "Scheme:      Shamir %d of %d, ed25519\n", c.Threshold, c.Shares)
+	fmt.Fprintf(&b, "Attendees:   %s\n", strings.Join(c.Attendees, ", "))
+	if c.PublicKey != "" {
+		fmt.Fprintf(&b, "Public key:  %s\n", c.PublicKey)
+		pub, _ := hex.DecodeString(c.PublicKey)
+		fmt.Fprintf(&b, "Solana addr: %s\n", SolanaAddress(ed25519.PublicKey(pub)))
+	}
+
+	fmt.Fprintf(&b, "\nEvents\n")
+	for _, e := range c.Events {
+		fmt.Fprintf(&b, "  %s  %-12s %s\n", e.At.UTC().Format(time.RFC3339), e.Operator, e.Event)
+	}
+
+	if len(c.ShareFingerprints) > 0 {
+		fmt.Fprintf(&b, "\nShare fingerprints (sha256)\n")
+		for i, fp := range c.ShareFingerprints {
+			fmt.Fprintf(&b, "  share %d  %s  custodian: ______________  signature: ______________\n", i+1, fp)
+		}
+	}
+
+	fmt.Fprintf(&b, "\nWitness signatures\n")
+	for _, name := range c.Attendees {
+		fmt.Fprintf(&b, "  %-12s ______________________________\n", name)
+	}
+	return b.String(), nil
+}
+
+// open returns a ceremony that can still change, must be called w/ mu held
+func (m *CeremonyManager) open(id string) (*Ceremony, error) {
+	c, ok := m.ceremonies[id]
+	if !ok {
+		return nil, errCeremonyNotFound
+	}
+	if c.Status != CeremonyOpen {
+		return nil, fmt.Errorf("%w: %s", errCeremonyState, c.Status)
+	}
+	return c, nil
+}
+
+// view copies the ceremony so callers can't race later events
+func (c *Ceremony) view() Ceremony {
+	out := *c
+	out.Attendees = slices.Clone(c.Attendees)
+	out.Contributors = slices.Clone(c.Contributors)
+	out.ShareFingerprints = slices.Clone(c.ShareFingerprints)
+	out.Events = slices.Clone(c.Events)
+	out.pool = nil
+	return out
+}
+
+// record appends to the ceremony's event log, must be called w/ mu held
+func (m *CeremonyManager) record(c *Ceremony, operator, event string) {
+	audit(context.Background(), logAdmin, "Ceremony event", "ceremony_id", c.ID, "operator", operator, "event", event)
+	c.Events = append(c.Events, CeremonyEvent{At: time.Now(), Operator: operator, Event: event})
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"net/http"
+)
+
+func ceremonyErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, errCeremonyNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, errCeremonyAbsent):
+		return http.StatusForbidden
+	case errors.Is(err, errCeremonyState), errors.Is(err, errCeremonyQuorum):
+		return http.StatusConflict
+	default:
+		return http.StatusBadRequest
+	}
+}
+
+func (s *APIServer) handleCeremonyStart(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	var req CeremonyRequest
+	if err := decodeJSON(r.Body, &req); err != nil {
+		writeError(w, r, http.StatusBadRequest, invalidBody(err))
+		return
+	}
+
+	c, err := s.Ceremonies.Start(req, OperatorFromContext(r.Context()))
+	if err != nil {
+		writeError(w, r, ceremonyErrorStatus(err), err)
+		return
+	}
+
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(c)
+}
+
+func (s *APIServer) handleCeremonyGet(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	c, err := s.Ceremonies.Get(r.PathValue("id"))
+	if err != nil {
+		writeError(w, r, ceremonyErrorStatus(err), err)
+		return
+	}
+
+	json.NewEncoder(w).Encode(c)
+}
+
+func (s *APIServer) handleCeremonyAttend(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	c, err := s.Ceremonies.Attend(r.PathValue("id"), OperatorFromContext(r.Context()))
+	if err != nil {
+		writeError(w, r, ceremonyErrorStatus(err), err)
+		return
+	}
+
+	json.NewEncoder(w).Encode(c)
+}
+
+type EntropyRequest struct {
+	// base64, e.g. hashed dice rolls typed in at the ceremony
+	Entropy string `json:"entropy"`
+}
+
+func (s *APIServer) handleCeremonyEntropy(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	var req EntropyRequest
+	if err := decodeJSON(r.Body, &req); err != nil {
+		writeError(w, r, http.StatusBadRequest, invalidBody(err))
+		return
+	}
+	entropy, err := base64.StdEncoding.DecodeString(req.Entropy)
+	if err != nil {
+		writeError(w, r, http.StatusBadRequest, errors.New("entropy must be base64"))
+		return
+	}
+
+	c, err := s.Ceremonies.Contribute(r.PathValue("id"), OperatorFromContext(r.Context()), entropy)
+	if err != nil {
+		writeError(w, r, ceremonyErrorStatus(err), err)
+		return
+	}
+
+	json.NewEncoder(w).Encode(c)
+}
+
+// the shares are in this response and nowhere else
+func (s *APIServer) handleCeremonyComplete(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+
+	res, err := s.Ceremonies.Complete(r.PathValue("id"), OperatorFromContext(r.Context()))
+	if err != nil {
+		writeError(w, r, ceremonyErrorStatus(err), err)
+		return
+	}
+
+	json.NewEncoder(w).Encode(res)
+}
+
+func (s *APIServer) handleCeremonyAbort(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	var req struct {
+		Reason string `json:"reason"`
+	}
+	if err := decodeJSON(r.Body, &req); err != nil {
+		writeError(w, r, http.StatusBadRequest, invalidBody(err))
+		return
+	}
+	if req.Reason == "" {
+		writeError(w, r, http.StatusBadRequest, errors.New("a reason is required"))
+		return
+	}
+
+	c, err := s.Ceremonies.Abort(r.PathValue("id"), OperatorFromContext(r.Context()), req.Reason)
+	if err != nil {
+		writeError(w, r, ceremonyErrorStatus(err), err)
+		return
+	}
+
+	json.NewEncoder(w).Encode(c)
+}
+
+func (s *APIServer) handleCeremonyTranscript(w http.ResponseWriter, r *http.Request) {
+	transcript, err := s.Ceremonies.Transcript(r.PathValue("id"))
+	if err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		writeError(w, r, ceremonyErrorStatus(err), err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.Write([]byte(transcript))
+}
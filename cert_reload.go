@@ -0,0 +1,113 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"log/slog"
+	"os"
+	"sync"
+	"time"
+)
+
+// CertReloader serves the server certificate from CertFile and KeyFile and
+// picks up a rotated pair w/o a restart, so short-lived certificates (e.g.
+// SPIFFE SVIDs written by spiffe-helper or cert-manager secrets) can be used.
+// Only new handshakes see the new certificate, open connections are left
+// alone. The client CA bundle is read once at startup.
+type CertReloader struct {
+	certFile string
+	keyFile  string
+
+	cert *tls.Certificate
+
+	// mod times and sizes of the pair when it was last loaded
+	stamp string
+
+	mu sync.RWMutex
+}
+
+// ReloadingConfig is Config w/ the certificate served by a CertReloader,
+// Run the reloader to watch the files
+func (t *TLSSettings) ReloadingConfig() (*tls.Config, *CertReloader, error) {
+	cfg, err := t.Config()
+	if err != nil {
+		return nil, nil, err
+	}
+	stamp, err := certStamp(t.CertFile, t.KeyFile)
+	if err != nil {
+		return nil, nil, err
+	}
+	c := &CertReloader{certFile: t.CertFile, keyFile: t.KeyFile, cert: &cfg.Certificates[0], stamp: stamp}
+	cfg.Certificates = nil
+	cfg.GetCertificate = c.GetCertificate
+	return cfg, c, nil
+}
+
+// GetCertificate is the tls.Config hook, it returns the current pair
+func (c *CertReloader) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	c.mu.RLock()
+
+	defer c.mu.RUnlock()
+
+	return c.cert, nil
+}
+
+// Reload loads the pair if either file changed. A pair that doesn't load,
+// e.g. the cert was replaced but not the key yet, keeps the old one in use
+// and is tried again next time.
+func (c *CertReloader) Reload() (bool, error) {
+	stamp, err := certStamp(c.certFile, c.keyFile)
+	if err != nil {
+		return false, err
+	}
+	c.mu.RLock()
+	unchanged := stamp == c.stamp
+	c.mu.RUnlock()
+	if unchanged {
+		return false, nil
+	}
+
+	cert, err := tls.LoadX509KeyPair(c.certFile, c.keyFile)
+	if err != nil {
+		return false, fmt.Errorf("failed to load TLS certificate: %w", err)
+	}
+
+	c.mu.Lock()
+	c.cert, c.stamp = &cert, stamp
+	c.mu.Unlock()
+
+	slog.Info("TLS certificate reloaded", logServer, "file", c.certFile, "expires", cert.Leaf.NotAfter)
+	return true, nil
+}
+
+// Run checks the files on every tick until ctx is done
+func (c *CertReloader) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if _, err := c.Reload(); err != nil {
+				slog.Error("TLS certificate not reloaded, keeping the current one", logServer, "err", err)
+			}
+		}
+	}
+}
+
+// certStamp identifies the files' contents w/o reading them. Stat follows
+// symlinks, so a swapped Kubernetes secret mount counts as a change.
+func certStamp(files ...string) (string, error) {
+	var stamp string
+	for _, file := range files {
+		info, err := os.Stat(file)
+		if err != nil {
+			return "", err
+		}
+		stamp += fmt.Sprintf("%s:%d:%d;", file, info.ModTime().UnixNano(), info.Size())
+	}
+	return stamp, nil
+}
@@ -0,0 +1,160 @@
+package main
+
+import (
+	"cmp"
+	"errors"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var errUnknownCluster = errors.New("unknown cluster")
+
+// clusters a key or request can name
+var clusterNames = []string{ClusterMainnet, ClusterDevnet, ClusterTestnet, ClusterCustom}
+
+// Cluster is one Solana cluster's endpoints and what the signer keeps per
+// cluster
+type Cluster struct {
+	Name        string
+	Chain       ChainClient
+	Fees        *FeeAdvisor
+	Blockhashes *BlockhashCache
+}
+
+// Clusters are the clusters the service has endpoints for. A request
+// targets the one it names, else its key's, else Default.
+type Clusters struct {
+	Default string
+
+	// mainnet only for keys whose policy lists it in allowedClusters
+	MainnetNeedsPolicy bool
+
+	byName map[string]*Cluster
+}
+
+// constructor
+func NewClusters(defaultName string) *Clusters {
+	return &Clusters{Default: defaultName, byName: make(map[string]*Cluster)}
+}
+
+func (c *Clusters) Add(cluster *Cluster) {
+	c.byName[cluster.Name] = cluster
+}
+
+// Get is the named cluster, empty is the default
+func (c *Clusters) Get(name string) (*Cluster, error) {
+	if name == "" {
+		name = c.Default
+	}
+	cluster, ok := c.byName[name]
+	if !ok {
+		return nil, fmt.Errorf("%w: no endpoints for %q", errUnknownCluster, name)
+	}
+	return cluster, nil
+}
+
+// All is every configured cluster, the default first
+func (c *Clusters) All() []*Cluster {
+	var all []*Cluster
+	for _, name := range clusterNames {
+		if cluster, ok := c.byName[name]; ok {
+			if name == c.Default {
+				all = append([]*Cluster{cluster}, all...)
+			} else {
+				all = append(all, cluster)
+			}
+		}
+	}
+	return all
+}
+
+func validClusterName(name string) error {
+	if !slices.Contains(clusterNames, name) {
+		return fmt.Errorf("%w: %q, want one of %s", errUnknownCluster, name, strings.Join(clusterNames, ", "))
+	}
+	return nil
+}
+
+// clusterEnv is the variable holding a cluster's endpoints, e.g.
+// STS_SOLANA_RPC_URL_MAINNET_BETA
+func clusterEnv(name string) string {
+	return "STS_SOLANA_RPC_URL_" + strings.ToUpper(strings.ReplaceAll(name, "-", "_"))
+}
+
+// ClustersFromEnv reads STS_SOLANA_CLUSTER, the default cluster (custom
+// when unset), whose endpoints are STS_SOLANA_RPC_URL, and one more
+// STS_SOLANA_RPC_URL_<CLUSTER> per other cluster. Endpoint lists are comma
+// separated and fail over. Nil when no endpoints are configured.
+func ClustersFromEnv(getenv func(string) string) (*Clusters, []*RPCPool, error) {
+	defaultName := getenv("STS_SOLANA_CLUSTER")
+	if defaultName == "" {
+		defaultName = ClusterCustom
+	}
+	if err := validClusterName(defaultName); err != nil {
+		return nil, nil, err
+	}
+	// zero or unset takes the default
+	timeout, _ := time.ParseDuration(getenv("STS_SOLANA_RPC_TIMEOUT"))
+	// unset or out of range takes the 75th percentile
+	percentile, _ := strconv.Atoi(getenv("STS_PRIORITY_FEE_PERCENTILE"))
+
+	clusters := NewClusters(defaultName)
+	clusters.MainnetNeedsPolicy = getenv("STS_MAINNET_REQUIRES_POLICY") == "true"
+	var pools []*RPCPool
+	for _, name := range clusterNames {
+		urls := getenv(clusterEnv(name))
+		if name == defaultName {
+			urls = cmp.Or(getenv("STS_SOLANA_RPC_URL"), urls)
+		}
+		if urls == "" {
+			continue
+		}
+		pool, err := NewRPCPool(strings.Split(urls, ","), timeout)
+		if err != nil {
+			return nil, nil, fmt.Errorf("%s: %w", name, err)
+		}
+		pools = append(pools, pool)
+		clusters.Add(&Cluster{Name: name, Chain: pool, Fees: NewFeeAdvisor(pool, percentile), Blockhashes: NewBlockhashCache(pool)})
+	}
+	if len(pools) == 0 {
+		return nil, nil, nil
+	}
+	if _, err := clusters.Get(""); err != nil {
+		return nil, nil, fmt.Errorf("default cluster %s needs endpoints in STS_SOLANA_RPC_URL", defaultName)
+	}
+	return clusters, pools, nil
+}
+
+// checkCluster says whether the key may be used on the cluster
+func (p KeyPolicy) checkCluster(name string, mainnetNeedsPolicy bool) error {
+	if len(p.AllowedClusters) > 0 && !slices.Contains(p.AllowedClusters, name) {
+		return fmt.Errorf("%w: key may not be used on %s", errPolicyViolation, name)
+	}
+	if name == ClusterMainnet && mainnetNeedsPolicy && !slices.Contains(p.AllowedClusters, ClusterMainnet) {
+		return fmt.Errorf("%w: key's policy doesn't allow %s", errPolicyViolation, ClusterMainnet)
+	}
+	return nil
+}
+
+// clusterFor is the cluster a request targets: the one it names, else the
+// key's, else the default. W/o configured clusters it is the signer's own
+// rpc, which may be nil, and only a request naming a cluster is refused.
+func (s *signerService) clusterFor(requested string, policy KeyPolicy) (*Cluster, error) {
+	if s.clusters == nil {
+		if requested != "" {
+			return nil, fmt.Errorf("%w: no endpoints for %q", errUnknownCluster, requested)
+		}
+		return &Cluster{Chain: s.rpc, Fees: s.fees, Blockhashes: s.blockhashes}, nil
+	}
+	cluster, err := s.clusters.Get(cmp.Or(requested, policy.Cluster))
+	if err != nil {
+		return nil, err
+	}
+	if err := policy.checkCluster(cluster.Name, s.clusters.MainnetNeedsPolicy); err != nil {
+		return nil, err
+	}
+	return cluster, nil
+}
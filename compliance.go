@@ -0,0 +1,352 @@
+package main
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// compliance data is kept this long, a SOC 2 type II period is a year
+const complianceRetention = 400 * 24 * time.Hour
+
+// dates in report queries and file names
+const complianceDate = "2006-01-02"
+
+// KeyLifecycleEntry is a key created or destroyed
+type KeyLifecycleEntry struct {
+	KeyID     string    `json:"keyId"`
+	KeyType   string    `json:"keyType,omitempty"`
+	Policy    string    `json:"policy,omitempty"`
+	Namespace string    `json:"namespace,omitempty"`
+	At        time.Time `json:"at"`
+}
+
+type SignatureCount struct {
+	KeyID  string `json:"keyId"`
+	Policy string `json:"policy"`
+	Count  int    `json:"count"`
+}
+
+type AuthFailureCount struct {
+	Method string `json:"method"`
+	Count  int    `json:"count"`
+}
+
+// ComplianceReport is the evidence for one period, From inclusive and To
+// exclusive
+type ComplianceReport struct {
+	From        time.Time `json:"from"`
+	To          time.Time `json:"to"`
+	GeneratedAt time.Time `json:"generatedAt"`
+
+	KeysCreated       []KeyLifecycleEntry `json:"keysCreated"`
+	KeysDestroyed     []KeyLifecycleEntry `json:"keysDestroyed"`
+	Signatures        []SignatureCount    `json:"signatures"`
+	ApprovalDecisions []ApprovalDecision  `json:"approvalDecisions"`
+	FailedAuth        []AuthFailureCount  `json:"failedAuth"`
+}
+
+// ComplianceRecorder keeps what SOC 2 evidence asks for: key lifecycle,
+// signatures per key and policy, approval decisions and failed
+// authentication. Signatures and auth failures are counted per day, so
+// reports cover whole days. Methods are nil safe.
+type ComplianceRecorder struct {
+	created   []KeyLifecycleEntry
+	destroyed []KeyLifecycleEntry
+
+	// by day, then key and policy or auth method
+	signatures   map[time.Time]map[SignatureCount]int
+	authFailures map[time.Time]map[string]int
+
+	// as the approval queue records them
+	decisions []ApprovalDecision
+
+	mu sync.Mutex
+}
+
+// constructor
+func NewComplianceRecorder() *ComplianceRecorder {
+	return &ComplianceRecorder{
+		signatures:   make(map[time.Time]map[SignatureCount]int),
+		authFailures: make(map[time.Time]map[string]int),
+	}
+}
+
+func complianceDay(t time.Time) time.Time {
+	return t.UTC().Truncate(24 * time.Hour)
+}
+
+func (c *ComplianceRecorder) KeyCreated(keyID, keyType, policy, namespace string) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+
+	defer c.mu.Unlock()
+
+	c.created = append(c.created, KeyLifecycleEntry{KeyID: keyID, KeyType: keyType, Policy: policy, Namespace: namespace, At: time.Now().UTC()})
+	c.prune(time.Now())
+}
+
+func (c *ComplianceRecorder) KeyDestroyed(keyID string) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+
+	defer c.mu.Unlock()
+
+	c.destroyed = append(c.destroyed, KeyLifecycleEntry{KeyID: keyID, At: time.Now().UTC()})
+}
+
+func (c *ComplianceRecorder) Signed(keyID, policy string) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+
+	defer c.mu.Unlock()
+
+	day := complianceDay(time.Now())
+	if c.signatures[day] == nil {
+		c.signatures[day] = make(map[SignatureCount]int)
+	}
+	c.signatures[day][SignatureCount{KeyID: keyID, Policy: policy}]++
+}
+
+func (c *ComplianceRecorder) ApprovalDecided(d ApprovalDecision) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+
+	defer c.mu.Unlock()
+
+	d.At = d.At.UTC()
+	c.decisions = append(c.decisions, d)
+}
+
+// AuthFailed counts a credential that didn't check out, method is e.g.
+// bearer, hmac or api_token
+func (c *ComplianceRecorder) AuthFailed(method string) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+
+	defer c.mu.Unlock()
+
+	day := complianceDay(time.Now())
+	if c.authFailures[day] == nil {
+		c.authFailures[day] = make(map[string]int)
+	}
+	c.authFailures[day][method]++
+}
+
+// prune drops what is past retention, must be called w/ mu held
+func (c *ComplianceRecorder) prune(now time.Time) {
+	cutoff := now.Add(-complianceRetention)
+	for len(c.created) > 0 && c.created[0].At.Before(cutoff) {
+		c.created = c.created[1:]
+	}
+	for len(c.destroyed) > 0 && c.destroyed[0].At.Before(cutoff) {
+		c.destroyed = c.destroyed[1:]
+	}
+	for len(c.decisions) > 0 && c.decisions[0].At.Before(cutoff) {
+		c.decisions = c.decisions[1:]
+	}
+	for day := range c.signatures {
+		if day.Before(cutoff) {
+			delete(c.signatures, day)
+		}
+	}
+	for day := range c.authFailures {
+		if day.Before(cutoff) {
+			delete(c.authFailures, day)
+		}
+	}
+}
+
+// Report covers the days from from up to, not including, to
+func (c *ComplianceRecorder) Report(from, to time.Time) ComplianceReport {
+	from, to = complianceDay(from), complianceDay(to)
+	report := ComplianceReport{
+		From:              from,
+		To:                to,
+		GeneratedAt:       time.Now().UTC(),
+		KeysCreated:       []KeyLifecycleEntry{},
+		KeysDestroyed:     []KeyLifecycleEntry{},
+		Signatures:        []SignatureCount{},
+		ApprovalDecisions: []ApprovalDecision{},
+		FailedAuth:        []AuthFailureCount{},
+	}
+	if c == nil {
+		return report
+	}
+	within := func(t time.Time) bool { return !t.Before(from) && t.Before(to) }
+
+	c.mu.Lock()
+	for _, e := range c.created {
+		if within(e.At) {
+			report.KeysCreated = append(report.KeysCreated, e)
+		}
+	}
+	for _, e := range c.destroyed {
+		if within(e.At) {
+			report.KeysDestroyed = append(report.KeysDestroyed, e)
+		}
+	}
+	for _, d := range c.decisions {
+		if within(d.At) {
+			report.ApprovalDecisions = append(report.ApprovalDecisions, d)
+		}
+	}
+	signatures := map[SignatureCount]int{}
+	for day, counts := range c.signatures {
+		if within(day) {
+			for k, n := range counts {
+				signatures[k] += n
+			}
+		}
+	}
+	failures := map[string]int{}
+	for day, counts := range c.authFailures {
+		if within(day) {
+			for method, n := range counts {
+				failures[method] += n
+			}
+		}
+	}
+	c.mu.Unlock()
+
+	for k, n := range signatures {
+		k.Count = n
+		report.Signatures = append(report.Signatures, k)
+	}
+	sort.Slice(report.Signatures, func(i, j int) bool {
+		a, b := report.Signatures[i], report.Signatures[j]
+		return a.KeyID < b.KeyID || a.KeyID == b.KeyID && a.Policy < b.Policy
+	})
+	for method, n := range failures {
+		report.FailedAuth = append(report.FailedAuth, AuthFailureCount{Method: method, Count: n})
+	}
+	sort.Slice(report.FailedAuth, func(i, j int) bool { return report.FailedAuth[i].Method < report.FailedAuth[j].Method })
+	return report
+}
+
+// WriteCSV writes the report as one table, the record column says which
+// section a row belongs to
+func (r ComplianceReport) WriteCSV(w io.Writer) error {
+	out := csv.NewWriter(w)
+	out.Write([]string{"record", "time", "key_id", "policy", "actor", "detail", "count"})
+	at := func(t time.Time) string { return t.Format(time.RFC3339) }
+	for _, e := range r.KeysCreated {
+		out.Write([]string{"key_created", at(e.At), e.KeyID, e.Policy, "", e.KeyType, "1"})
+	}
+	for _, e := range r.KeysDestroyed {
+		out.Write([]string{"key_destroyed", at(e.At), e.KeyID, "", "", "", "1"})
+	}
+	for _, s := range r.Signatures {
+		out.Write([]string{"signatures", "", s.KeyID, s.Policy, "", "", strconv.Itoa(s.Count)})
+	}
+	for _, d := range r.ApprovalDecisions {
+		out.Write([]string{"approval_" + d.Decision, at(d.At), d.KeyID, "", d.Operator, d.ApprovalID, "1"})
+	}
+	for _, f := range r.FailedAuth {
+		out.Write([]string{"failed_auth", "", "", "", "", f.Method, strconv.Itoa(f.Count)})
+	}
+	out.Flush()
+	return out.Error()
+}
+
+// WriteFiles saves the report for the day before day as JSON and CSV in dir
+func (c *ComplianceRecorder) WriteFiles(dir string, day time.Time) error {
+	to := complianceDay(day)
+	from := to.Add(-24 * time.Hour)
+	report := c.Report(from, to)
+
+	base := filepath.Join(dir, "compliance-"+from.Format(complianceDate))
+	raw, err := json.MarshalIndent(report, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(base+".json", raw, 0o600); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(base+".csv", os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0o600)
+	if err != nil {
+		return err
+	}
+	if err := report.WriteCSV(f); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// Run writes the previous day's report to dir just after every UTC midnight
+// until ctx is done
+func (c *ComplianceRecorder) Run(ctx context.Context, dir string) {
+	for {
+		next := complianceDay(time.Now()).Add(24 * time.Hour)
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(time.Until(next)):
+		}
+		if err := c.WriteFiles(dir, next); err != nil {
+			slog.Error("Failed to write the compliance report", logAdmin, "dir", dir, "err", err)
+			continue
+		}
+		slog.Info("Compliance report written", logAdmin, "dir", dir, "day", next.Add(-24*time.Hour).Format(complianceDate))
+	}
+}
+
+// handleComplianceReport serves ?from=&to= (dates, to exclusive, default the
+// last 30 days) as JSON, or CSV w/ ?format=csv
+func (s *APIServer) handleComplianceReport(w http.ResponseWriter, r *http.Request) {
+	to := complianceDay(time.Now()).Add(24 * time.Hour)
+	from := to.Add(-30 * 24 * time.Hour)
+	for name, dst := range map[string]*time.Time{"from": &from, "to": &to} {
+		raw := r.URL.Query().Get(name)
+		if raw == "" {
+			continue
+		}
+		t, err := time.Parse(complianceDate, raw)
+		if err != nil {
+			writeError(w, r, http.StatusBadRequest, fmt.Errorf("%s must be a date like 2006-01-02", name))
+			return
+		}
+		*dst = t
+	}
+	if !from.Before(to) {
+		writeError(w, r, http.StatusBadRequest, errors.New("from must be before to"))
+		return
+	}
+
+	report := s.Compliance.Report(from, to)
+	audit(r.Context(), logAdmin, "Compliance report generated", "operator", OperatorFromContext(r.Context()).Name, "from", from.Format(complianceDate), "to", to.Format(complianceDate))
+
+	switch r.URL.Query().Get("format") {
+	case "", "json":
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(report)
+	case "csv":
+		w.Header().Set("Content-Type", "text/csv")
+		w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="compliance-%s-%s.csv"`, from.Format(complianceDate), to.Format(complianceDate)))
+		report.WriteCSV(w)
+	default:
+		writeError(w, r, http.StatusBadRequest, errors.New("format must be json or csv"))
+	}
+}
@@ -0,0 +1,309 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"strconv"
+	"time"
+)
+
+// Addr value that turns the TCP listener off, for socket only sidecars
+const AddrOff = "off"
+
+// key store backends the service can run on
+const BackendMemory = "memory"
+
+var backends = []string{BackendMemory}
+
+// Config is the listener and runtime settings. Zero fields take the defaults.
+type Config struct {
+	// HTTP API listen address, AddrOff serves on Socket only
+	Addr string
+
+	// Unix socket path served as well as Addr, empty leaves it off
+	Socket     string
+	SocketMode os.FileMode
+
+	ReadTimeout  time.Duration
+	WriteTimeout time.Duration
+	IdleTimeout  time.Duration
+
+	// how long in-flight requests get to finish on shutdown
+	ShutdownTimeout time.Duration
+
+	// HTTP/2 w/o TLS (prior knowledge h2c) for meshes that terminate TLS in
+	// a sidecar. HTTP/2 over TLS is always offered.
+	H2C bool
+
+	// SO_REUSEPORT on the TCP listeners, so a new process can bind the same
+	// address while the old one drains
+	ReusePort bool
+
+	// streams one HTTP/2 connection may have open at once
+	HTTP2MaxStreams int
+
+	// JSON request bodies over this are refused w/ 413, routes w/ a limit in
+	// Routes or defaultRouteLimits keep their own
+	MaxBodyBytes int64
+
+	// per route body limits and handler timeouts, keyed like "POST /txs/sign"
+	Routes map[string]RouteLimit
+
+	// where keys are held
+	Backend string
+
+	// YAML config file, empty when there is none
+	File string
+}
+
+func DefaultConfig() Config {
+	return Config{
+		Addr:            ":8080",
+		SocketMode:      0o600,
+		ReadTimeout:     5 * time.Second,
+		WriteTimeout:    10 * time.Second,
+		IdleTimeout:     30 * time.Second,
+		ShutdownTimeout: 30 * time.Second,
+		HTTP2MaxStreams: 250,
+		MaxBodyBytes:    4096,
+		Backend:         BackendMemory,
+	}
+}
+
+// orDefaults fills zero fields from DefaultConfig
+func (c Config) orDefaults() Config {
+	d := DefaultConfig()
+	if c.Addr == "" {
+		c.Addr = d.Addr
+	}
+	if c.SocketMode == 0 {
+		c.SocketMode = d.SocketMode
+	}
+	if c.ReadTimeout == 0 {
+		c.ReadTimeout = d.ReadTimeout
+	}
+	if c.WriteTimeout == 0 {
+		c.WriteTimeout = d.WriteTimeout
+	}
+	if c.IdleTimeout == 0 {
+		c.IdleTimeout = d.IdleTimeout
+	}
+	if c.ShutdownTimeout == 0 {
+		c.ShutdownTimeout = d.ShutdownTimeout
+	}
+	if c.HTTP2MaxStreams == 0 {
+		c.HTTP2MaxStreams = d.HTTP2MaxStreams
+	}
+	if c.MaxBodyBytes == 0 {
+		c.MaxBodyBytes = d.MaxBodyBytes
+	}
+	if c.Backend == "" {
+		c.Backend = d.Backend
+	}
+	return c
+}
+
+// Validate refuses settings the service can't run with
+func (c Config) Validate() error {
+	if c.Addr == "" {
+		return errors.New("listen address is required")
+	}
+	if c.Addr == AddrOff && c.Socket == "" {
+		return errors.New("a Unix socket is required when the TCP listener is off")
+	}
+	if c.SocketMode&^os.ModePerm != 0 || c.SocketMode&0o600 != 0o600 {
+		return fmt.Errorf("socket mode %o must be permission bits that let the service's user read and write", c.SocketMode)
+	}
+	for _, d := range []struct {
+		name string
+		v    time.Duration
+	}{
+		{"read timeout", c.ReadTimeout},
+		{"write timeout", c.WriteTimeout},
+		{"idle timeout", c.IdleTimeout},
+		{"shutdown timeout", c.ShutdownTimeout},
+	} {
+		if d.v <= 0 {
+			return fmt.Errorf("%s must be positive, got %s", d.name, d.v)
+		}
+	}
+	if c.HTTP2MaxStreams < 1 || c.HTTP2MaxStreams > 10000 {
+		return fmt.Errorf("HTTP/2 max streams must be between 1 and 10000, got %d", c.HTTP2MaxStreams)
+	}
+	// a sign request carries a base64 transaction, anything smaller can't fit one
+	if c.MaxBodyBytes < 1024 || c.MaxBodyBytes > 10<<20 {
+		return fmt.Errorf("max body size must be between 1KiB and 10MiB, got %d", c.MaxBodyBytes)
+	}
+	if err := c.validateRoutes(); err != nil {
+		return err
+	}
+	for _, b := range backends {
+		if c.Backend == b {
+			return nil
+		}
+	}
+	return fmt.Errorf("unknown key store backend %q, supported: %v", c.Backend, backends)
+}
+
+// ConfigFromFlags parses args. Flags override env vars, which override the
+// config file, which overrides DefaultConfig:
+//
+//	-config            STS_CONFIG_FILE
+//	-addr              STS_ADDR, off to serve on the socket only
+//	-socket            STS_SOCKET
+//	-socket-mode       STS_SOCKET_MODE, octal
+//	-read-timeout      STS_READ_TIMEOUT
+//	-write-timeout     STS_WRITE_TIMEOUT
+//	-idle-timeout      STS_IDLE_TIMEOUT
+//	-shutdown-timeout  STS_SHUTDOWN_TIMEOUT
+//	-h2c               STS_H2C
+//	-reuse-port        STS_REUSE_PORT
+//	-http2-max-streams STS_HTTP2_MAX_STREAMS
+//	-max-body-bytes    STS_MAX_BODY_BYTES
+//	-backend           STS_BACKEND
+func ConfigFromFlags(args []string, getenv func(string) string) (Config, error) {
+	c := DefaultConfig()
+
+	// flags land in f and are copied over once the file and env are applied
+	f := c
+	fs := flag.NewFlagSet("sts-svc", flag.ContinueOnError)
+	fs.StringVar(&f.File, "config", "", "YAML config file, reloaded on SIGHUP")
+	fs.StringVar(&f.Addr, "addr", f.Addr, "HTTP API listen address, off to serve on the socket only")
+	fs.StringVar(&f.Socket, "socket", f.Socket, "also serve on this Unix socket")
+	socketMode := fs.String("socket-mode", "0600", "Unix socket permissions, octal")
+	fs.DurationVar(&f.ReadTimeout, "read-timeout", f.ReadTimeout, "time allowed to read a request")
+	fs.DurationVar(&f.WriteTimeout, "write-timeout", f.WriteTimeout, "time allowed to write a response")
+	fs.DurationVar(&f.IdleTimeout, "idle-timeout", f.IdleTimeout, "keep-alive connections are closed after this long idle")
+	fs.DurationVar(&f.ShutdownTimeout, "shutdown-timeout", f.ShutdownTimeout, "time in-flight requests get to finish on shutdown")
+	fs.BoolVar(&f.H2C, "h2c", f.H2C, "accept HTTP/2 w/o TLS, for meshes that terminate TLS in a sidecar")
+	fs.BoolVar(&f.ReusePort, "reuse-port", f.ReusePort, "bind the TCP listeners w/ SO_REUSEPORT so a new process can take over the address")
+	fs.IntVar(&f.HTTP2MaxStreams, "http2-max-streams", f.HTTP2MaxStreams, "concurrent streams per HTTP/2 connection")
+	fs.Int64Var(&f.MaxBodyBytes, "max-body-bytes", f.MaxBodyBytes, "largest JSON request body accepted")
+	fs.StringVar(&f.Backend, "backend", f.Backend, fmt.Sprintf("key store backend, one of %v", backends))
+	if err := fs.Parse(args); err != nil {
+		return Config{}, err
+	}
+
+	c.File = getenv("STS_CONFIG_FILE")
+	if f.File != "" {
+		c.File = f.File
+	}
+	if c.File != "" {
+		file, err := LoadConfigFile(c.File)
+		if err != nil {
+			return Config{}, err
+		}
+		if err := file.apply(&c); err != nil {
+			return Config{}, fmt.Errorf("%s: %w", c.File, err)
+		}
+	}
+
+	if v := getenv("STS_ADDR"); v != "" {
+		c.Addr = v
+	}
+	if v := getenv("STS_SOCKET"); v != "" {
+		c.Socket = v
+	}
+	if raw := getenv("STS_SOCKET_MODE"); raw != "" {
+		mode, err := parseFileMode(raw)
+		if err != nil {
+			return Config{}, fmt.Errorf("STS_SOCKET_MODE: %w", err)
+		}
+		c.SocketMode = mode
+	}
+	for _, e := range []struct {
+		name string
+		d    *time.Duration
+	}{
+		{"STS_READ_TIMEOUT", &c.ReadTimeout},
+		{"STS_WRITE_TIMEOUT", &c.WriteTimeout},
+		{"STS_IDLE_TIMEOUT", &c.IdleTimeout},
+		{"STS_SHUTDOWN_TIMEOUT", &c.ShutdownTimeout},
+	} {
+		if raw := getenv(e.name); raw != "" {
+			v, err := time.ParseDuration(raw)
+			if err != nil {
+				return Config{}, fmt.Errorf("%s must be a duration like 10s: %w", e.name, err)
+			}
+			*e.d = v
+		}
+	}
+	if raw := getenv("STS_H2C"); raw != "" {
+		v, err := strconv.ParseBool(raw)
+		if err != nil {
+			return Config{}, fmt.Errorf("STS_H2C must be true or false: %w", err)
+		}
+		c.H2C = v
+	}
+	if raw := getenv("STS_REUSE_PORT"); raw != "" {
+		v, err := strconv.ParseBool(raw)
+		if err != nil {
+			return Config{}, fmt.Errorf("STS_REUSE_PORT must be true or false: %w", err)
+		}
+		c.ReusePort = v
+	}
+	if raw := getenv("STS_HTTP2_MAX_STREAMS"); raw != "" {
+		v, err := strconv.Atoi(raw)
+		if err != nil {
+			return Config{}, fmt.Errorf("STS_HTTP2_MAX_STREAMS must be an integer: %w", err)
+		}
+		c.HTTP2MaxStreams = v
+	}
+	if raw := getenv("STS_MAX_BODY_BYTES"); raw != "" {
+		v, err := strconv.ParseInt(raw, 10, 64)
+		if err != nil {
+			return Config{}, fmt.Errorf("STS_MAX_BODY_BYTES must be an integer: %w", err)
+		}
+		c.MaxBodyBytes = v
+	}
+	if v := getenv("STS_BACKEND"); v != "" {
+		c.Backend = v
+	}
+
+	// only flags given on the command line override
+	var err error
+	fs.Visit(func(fl *flag.Flag) {
+		switch fl.Name {
+		case "addr":
+			c.Addr = f.Addr
+		case "socket":
+			c.Socket = f.Socket
+		case "socket-mode":
+			c.SocketMode, err = parseFileMode(*socketMode)
+		case "read-timeout":
+			c.ReadTimeout = f.ReadTimeout
+		case "write-timeout":
+			c.WriteTimeout = f.WriteTimeout
+		case "idle-timeout":
+			c.IdleTimeout = f.IdleTimeout
+		case "shutdown-timeout":
+			c.ShutdownTimeout = f.ShutdownTimeout
+		case "h2c":
+			c.H2C = f.H2C
+		case "reuse-port":
+			c.ReusePort = f.ReusePort
+		case "http2-max-streams":
+			c.HTTP2MaxStreams = f.HTTP2MaxStreams
+		case "max-body-bytes":
+			c.MaxBodyBytes = f.MaxBodyBytes
+		case "backend":
+			c.Backend = f.Backend
+		}
+	})
+	if err != nil {
+		return Config{}, fmt.Errorf("-socket-mode: %w", err)
+	}
+
+	return c, c.Validate()
+}
+
+// parseFileMode reads octal permissions like 0660
+func parseFileMode(raw string) (os.FileMode, error) {
+	mode, err := strconv.ParseUint(raw, 8, 32)
+	if err != nil {
+		return 0, fmt.Errorf("%q is not an octal file mode", raw)
+	}
+	return os.FileMode(mode), nil
+}
@@ -0,0 +1,278 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"maps"
+	"os"
+	"reflect"
+	"sync"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// ConfigFile is the YAML config file, every setting is optional. Server and
+// store settings are read at startup, env vars and flags override them.
+// Logging, rate limits and policies are reloaded on SIGHUP and override env
+// vars, so the file stays the source of truth for them.
+type ConfigFile struct {
+	Server     ServerFileConfig    `yaml:"server"`
+	Store      StoreFileConfig     `yaml:"store"`
+	Logging    LoggingFileConfig   `yaml:"logging"`
+	RateLimits RateLimitFileConfig `yaml:"rateLimits"`
+	Policies   PolicyFileConfig    `yaml:"policies"`
+
+	// feature flags by name, flags left out take their defaults
+	Features map[string]bool `yaml:"features"`
+}
+
+type ServerFileConfig struct {
+	Addr            string        `yaml:"addr"`
+	Socket          string        `yaml:"socket"`
+	SocketMode      string        `yaml:"socketMode"`
+	ReadTimeout     time.Duration `yaml:"readTimeout"`
+	WriteTimeout    time.Duration `yaml:"writeTimeout"`
+	IdleTimeout     time.Duration `yaml:"idleTimeout"`
+	ShutdownTimeout time.Duration `yaml:"shutdownTimeout"`
+	MaxBodyBytes    int64         `yaml:"maxBodyBytes"`
+	H2C             bool          `yaml:"h2c"`
+	HTTP2MaxStreams int           `yaml:"http2MaxStreams"`
+	ReusePort       bool          `yaml:"reusePort"`
+
+	// keyed like "POST /txs/sign", applies under every API version
+	Routes map[string]RouteLimit `yaml:"routes"`
+}
+
+type StoreFileConfig struct {
+	Backend string `yaml:"backend"`
+}
+
+type LoggingFileConfig struct {
+	// debug, info, warn or error
+	Level string `yaml:"level"`
+}
+
+// RateLimitFileConfig overrides RequestLimits, a rate w/o a burst gets
+// twice the rate
+type RateLimitFileConfig struct {
+	GlobalRPS   float64 `yaml:"globalRps"`
+	GlobalBurst int     `yaml:"globalBurst"`
+	ClientRPS   float64 `yaml:"clientRps"`
+	ClientBurst int     `yaml:"clientBurst"`
+}
+
+type PolicyFileConfig struct {
+	Anomaly AnomalyFileConfig `yaml:"anomaly"`
+}
+
+type AnomalyFileConfig struct {
+	Action     string  `yaml:"action"`
+	ZScore     float64 `yaml:"zScore"`
+	MinSamples *int    `yaml:"minSamples"`
+	MinBurst   *int    `yaml:"minBurst"`
+}
+
+// LoadConfigFile reads path, unknown keys are refused so typos don't go unnoticed
+func LoadConfigFile(path string) (ConfigFile, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return ConfigFile{}, err
+	}
+	defer f.Close()
+
+	var file ConfigFile
+	dec := yaml.NewDecoder(f)
+	dec.KnownFields(true)
+	if err := dec.Decode(&file); err != nil && !errors.Is(err, io.EOF) {
+		return ConfigFile{}, fmt.Errorf("%s: %w", path, err)
+	}
+	if file.Logging.Level != "" {
+		if _, ok := logLevels[file.Logging.Level]; !ok {
+			return ConfigFile{}, fmt.Errorf("%s: unknown log level %q", path, file.Logging.Level)
+		}
+	}
+	if err := checkFeatureNames(file.Features); err != nil {
+		return ConfigFile{}, fmt.Errorf("%s: %w", path, err)
+	}
+	return file, nil
+}
+
+// apply sets the server and store settings the file has on c
+func (f ConfigFile) apply(c *Config) error {
+	s := f.Server
+	if s.Addr != "" {
+		c.Addr = s.Addr
+	}
+	if s.Socket != "" {
+		c.Socket = s.Socket
+	}
+	if s.SocketMode != "" {
+		mode, err := parseFileMode(s.SocketMode)
+		if err != nil {
+			return fmt.Errorf("server.socketMode: %w", err)
+		}
+		c.SocketMode = mode
+	}
+	if s.ReadTimeout != 0 {
+		c.ReadTimeout = s.ReadTimeout
+	}
+	if s.WriteTimeout != 0 {
+		c.WriteTimeout = s.WriteTimeout
+	}
+	if s.IdleTimeout != 0 {
+		c.IdleTimeout = s.IdleTimeout
+	}
+	if s.ShutdownTimeout != 0 {
+		c.ShutdownTimeout = s.ShutdownTimeout
+	}
+	if s.MaxBodyBytes != 0 {
+		c.MaxBodyBytes = s.MaxBodyBytes
+	}
+	if s.H2C {
+		c.H2C = true
+	}
+	if s.ReusePort {
+		c.ReusePort = true
+	}
+	if s.HTTP2MaxStreams != 0 {
+		c.HTTP2MaxStreams = s.HTTP2MaxStreams
+	}
+	if len(s.Routes) > 0 {
+		c.Routes = s.Routes
+	}
+	if f.Store.Backend != "" {
+		c.Backend = f.Store.Backend
+	}
+	return nil
+}
+
+// RequestLimits returns base w/ the file's rate limits on top
+func (f ConfigFile) RequestLimits(base RequestLimits) (RequestLimits, error) {
+	r := f.RateLimits
+	if r.GlobalRPS != 0 {
+		base.GlobalRPS, base.GlobalBurst = r.GlobalRPS, int(2*r.GlobalRPS)
+	}
+	if r.GlobalBurst != 0 {
+		base.GlobalBurst = r.GlobalBurst
+	}
+	if r.ClientRPS != 0 {
+		base.ClientRPS, base.ClientBurst = r.ClientRPS, int(2*r.ClientRPS)
+	}
+	if r.ClientBurst != 0 {
+		base.ClientBurst = r.ClientBurst
+	}
+	return base, base.Validate()
+}
+
+// AnomalyConfig returns base w/ the file's anomaly policy on top
+func (f ConfigFile) AnomalyConfig(base AnomalyConfig) (AnomalyConfig, error) {
+	a := f.Policies.Anomaly
+	if a.Action != "" {
+		base.Action = a.Action
+	}
+	if a.ZScore != 0 {
+		base.ZScore = a.ZScore
+	}
+	if a.MinSamples != nil {
+		base.MinSamples = *a.MinSamples
+	}
+	if a.MinBurst != nil {
+		base.MinBurst = *a.MinBurst
+	}
+	return base, base.Validate()
+}
+
+// ConfigReloader applies the config file's reloadable settings to the
+// running service. Limiter, Anomalies and Features may be nil.
+type ConfigReloader struct {
+	Path string
+
+	Limiter   *RequestLimiter
+	Anomalies *AnomalyDetector
+	Features  *FeatureFlags
+
+	// settings from env vars and defaults, a setting dropped from the file
+	// goes back to these
+	BaseLimits   RequestLimits
+	BaseAnomaly  AnomalyConfig
+	BaseLevel    string
+	BaseFeatures map[string]bool
+
+	// server and store settings as started, changing them needs a restart
+	started ConfigFile
+	loaded  bool
+
+	mu sync.Mutex
+}
+
+// Reload reads the file and applies it, nothing is applied if any of it is invalid
+func (r *ConfigReloader) Reload() error {
+	r.mu.Lock()
+
+	defer r.mu.Unlock()
+
+	file, err := LoadConfigFile(r.Path)
+	if err != nil {
+		return err
+	}
+	limits, err := file.RequestLimits(r.BaseLimits)
+	if err != nil {
+		return fmt.Errorf("%s: %w", r.Path, err)
+	}
+	anomaly, err := file.AnomalyConfig(r.BaseAnomaly)
+	if err != nil {
+		return fmt.Errorf("%s: %w", r.Path, err)
+	}
+	level := r.BaseLevel
+	if file.Logging.Level != "" {
+		level = file.Logging.Level
+	}
+	if level == "" {
+		level = "info"
+	}
+	if err := SetLogLevel(level); err != nil {
+		return err
+	}
+
+	if r.Limiter != nil {
+		r.Limiter.SetLimits(limits)
+	}
+	if r.Anomalies != nil {
+		r.Anomalies.SetConfig(anomaly)
+	}
+	features := maps.Clone(r.BaseFeatures)
+	if features == nil {
+		features = map[string]bool{}
+	}
+	maps.Copy(features, file.Features)
+	if r.Features != nil {
+		r.Features.Set(features)
+	}
+
+	if !r.loaded {
+		r.started, r.loaded = file, true
+	} else if !reflect.DeepEqual(file.Server, r.started.Server) || file.Store != r.started.Store {
+		slog.Warn("Server and store settings changed, they apply after a restart", logConfig, "file", r.Path)
+	}
+	slog.Info("Config reloaded", logConfig, "file", r.Path, "level", level, "rate_limits", limits, "anomaly_policy", anomaly, "features", r.Features.All())
+	return nil
+}
+
+// Watch reloads whenever reload fires until ctx is done, a bad file is
+// logged and the running settings kept
+func (r *ConfigReloader) Watch(ctx context.Context, reload <-chan os.Signal) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-reload:
+			if err := r.Reload(); err != nil {
+				slog.ErrorContext(ctx, "Config reload failed, keeping the running settings", logConfig, "err", err)
+			}
+		}
+	}
+}
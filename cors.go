@@ -0,0 +1,128 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var errCORSOrigin = errors.New("origin is not allowed")
+
+// headers browsers may send by default, the API reads all of them
+var defaultCORSHeaders = []string{
+	"Authorization", "Content-Type", "Idempotency-Key", "X-API-Key", "X-Request-ID", "X-Tenant-ID",
+	"X-STS-Capability", "X-STS-Client", "X-STS-Grant", "X-STS-Signature", "X-STS-Timestamp",
+}
+
+// response headers scripts get to read
+const corsExposedHeaders = "X-Request-ID, Retry-After, Location"
+
+// CORSPolicy lets browser dashboards and dApps on the allowed origins call
+// the API. Credentials travel in headers, never cookies, so none are allowed.
+type CORSPolicy struct {
+	// exact origins like https://admin.example.com, "*" allows any
+	Origins []string
+
+	Headers []string
+
+	// how long browsers cache a preflight
+	MaxAge time.Duration
+}
+
+// CORSPolicyFromEnv reads STS_CORS_ORIGINS (comma separated), STS_CORS_HEADERS
+// (comma separated, replaces the defaults) and STS_CORS_MAX_AGE (default 10m).
+// It returns nil when no origins are configured.
+func CORSPolicyFromEnv(getenv func(string) string) (*CORSPolicy, error) {
+	raw := getenv("STS_CORS_ORIGINS")
+	if raw == "" {
+		return nil, nil
+	}
+
+	p := &CORSPolicy{Headers: defaultCORSHeaders, MaxAge: 10 * time.Minute}
+	for _, origin := range strings.Split(raw, ",") {
+		origin = strings.TrimSpace(origin)
+		if origin != "*" {
+			u, err := url.Parse(origin)
+			if err != nil || (u.Scheme != "https" && u.Scheme != "http") || u.Host == "" || (u.Path != "" && u.Path != "/") {
+				return nil, fmt.Errorf("invalid CORS origin %q, use scheme://host[:port]", origin)
+			}
+			origin = u.Scheme + "://" + u.Host
+		}
+		p.Origins = append(p.Origins, origin)
+	}
+
+	if raw := getenv("STS_CORS_HEADERS"); raw != "" {
+		p.Headers = nil
+		for _, h := range strings.Split(raw, ",") {
+			p.Headers = append(p.Headers, http.CanonicalHeaderKey(strings.TrimSpace(h)))
+		}
+	}
+
+	if raw := getenv("STS_CORS_MAX_AGE"); raw != "" {
+		d, err := time.ParseDuration(raw)
+		if err != nil || d < 0 {
+			return nil, fmt.Errorf("invalid STS_CORS_MAX_AGE %q", raw)
+		}
+		p.MaxAge = d
+	}
+	return p, nil
+}
+
+func (p *CORSPolicy) allows(origin string) bool {
+	return slices.Contains(p.Origins, "*") || slices.Contains(p.Origins, origin)
+}
+
+// allowOrigin is the Access-Control-Allow-Origin value for origin
+func (p *CORSPolicy) allowOrigin(origin string) string {
+	if slices.Contains(p.Origins, "*") {
+		return "*"
+	}
+	return origin
+}
+
+// withCORS answers preflights and marks responses readable by allowed
+// origins. It runs ahead of the auth middleware, browsers send preflights
+// w/o credentials.
+func (s *APIServer) withCORS(next http.Handler) http.Handler {
+	if s.CORS == nil {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		origin := r.Header.Get("Origin")
+		if origin == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		w.Header().Add("Vary", "Origin")
+
+		preflight := r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != ""
+		if !s.CORS.allows(origin) {
+			if preflight {
+				writeError(w, r, http.StatusForbidden, fmt.Errorf("%w: %s", errCORSOrigin, origin))
+				return
+			}
+			// no CORS headers, the browser keeps the response from the page
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		w.Header().Set("Access-Control-Allow-Origin", s.CORS.allowOrigin(origin))
+		if !preflight {
+			w.Header().Set("Access-Control-Expose-Headers", corsExposedHeaders)
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		w.Header().Add("Vary", "Access-Control-Request-Method")
+		w.Header().Add("Vary", "Access-Control-Request-Headers")
+		w.Header().Set("Access-Control-Allow-Methods", "GET, POST, DELETE")
+		w.Header().Set("Access-Control-Allow-Headers", strings.Join(s.CORS.Headers, ", "))
+		w.Header().Set("Access-Control-Max-Age", strconv.Itoa(int(s.CORS.MaxAge.Seconds())))
+		w.WriteHeader(http.StatusNoContent)
+	})
+}
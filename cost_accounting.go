@@ -0,0 +1,142 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// backend operations attributed to the calling tenant
+const (
+	CostKeystoreRead   = "keystore.read"
+	CostKeystoreWrite  = "keystore.write"
+	CostKeystoreDelete = "keystore.delete"
+	CostSign           = "signer.sign"
+	CostKeyGen         = "signer.keygen"
+)
+
+const defaultTenant = "default"
+
+type tenantCtxKey struct{}
+
+// WithTenant attaches the tenant/API key that backend costs are billed to
+func WithTenant(ctx context.Context, tenant string) context.Context {
+	return context.WithValue(ctx, tenantCtxKey{}, tenant)
+}
+
+func TenantFromContext(ctx context.Context) string {
+	if tenant, ok := ctx.Value(tenantCtxKey{}).(string); ok && tenant != "" {
+		return tenant
+	}
+	return defaultTenant
+}
+
+type OperationCost struct {
+	Operation string  `json:"operation"`
+	Count     int64   `json:"count"`
+	UnitCost  float64 `json:"unitCost"`
+	TotalCost float64 `json:"totalCost"`
+}
+
+type TenantCosts struct {
+	Tenant     string          `json:"tenant"`
+	Operations []OperationCost `json:"operations"`
+	TotalCost  float64         `json:"totalCost"`
+}
+
+// CostLedger counts backend operations per tenant so custody costs can be
+// charged back instead of split evenly
+type CostLedger struct {
+	// tenant to operation to count
+	counts map[string]map[string]int64
+
+	// price per operation, missing ops cost nothing
+	rates map[string]float64
+
+	mu sync.Mutex
+}
+
+// constructor
+func NewCostLedger(rates map[string]float64) *CostLedger {
+	if rates == nil {
+		rates = make(map[string]float64)
+	}
+	return &CostLedger{
+		counts: make(map[string]map[string]int64),
+		rates:  rates,
+	}
+}
+
+func (l *CostLedger) Record(ctx context.Context, op string) {
+	l.mu.Lock()
+
+	defer l.mu.Unlock()
+
+	tenant := TenantFromContext(ctx)
+	ops, ok := l.counts[tenant]
+	if !ok {
+		ops = make(map[string]int64)
+		l.counts[tenant] = ops
+	}
+	ops[op]++
+}
+
+// Report returns costs for one tenant, or all tenants if tenant is empty
+func (l *CostLedger) Report(tenant string) []TenantCosts {
+	l.mu.Lock()
+
+	defer l.mu.Unlock()
+
+	report := []TenantCosts{}
+	for name, ops := range l.counts {
+		if tenant != "" && name != tenant {
+			continue
+		}
+
+		tc := TenantCosts{Tenant: name, Operations: []OperationCost{}}
+		for op, count := range ops {
+			rate := l.rates[op]
+			tc.Operations = append(tc.Operations, OperationCost{
+				Operation: op,
+				Count:     count,
+				UnitCost:  rate,
+				TotalCost: rate * float64(count),
+			})
+			tc.TotalCost += rate * float64(count)
+		}
+		sort.Slice(tc.Operations, func(i, j int) bool { return tc.Operations[i].Operation < tc.Operations[j].Operation })
+
+		report = append(report, tc)
+	}
+	sort.Slice(report, func(i, j int) bool { return report[i].Tenant < report[j].Tenant })
+
+	return report
+}
+
+// ParseCostRates reads "op=price,op=price", bad entries are logged and skipped
+func ParseCostRates(raw string) map[string]float64 {
+	rates := make(map[string]float64)
+	for _, entry := range strings.Split(raw, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+
+		op, price, ok := strings.Cut(entry, "=")
+		if !ok {
+			slog.Warn("Ignoring malformed cost rate", logConfig, "rate", entry)
+			continue
+		}
+
+		rate, err := strconv.ParseFloat(strings.TrimSpace(price), 64)
+		if err != nil {
+			slog.Warn("Ignoring malformed cost rate", logConfig, "rate", entry, "err", err)
+			continue
+		}
+		rates[strings.TrimSpace(op)] = rate
+	}
+	return rates
+}
@@ -0,0 +1,177 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"runtime/debug"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"go.opentelemetry.io/otel/trace"
+)
+
+const (
+	// crash reports kept in the directory, the oldest go first
+	maxCrashReports = 100
+
+	// a panic w/ the same stack alerts at most this often
+	crashAlertInterval = 10 * time.Minute
+)
+
+// CrashReport is what a recovered panic leaves behind. Context fields went
+// through the same redaction as the log.
+type CrashReport struct {
+	Time      time.Time         `json:"time"`
+	Component string            `json:"component"`
+	Panic     string            `json:"panic"`
+	Stack     string            `json:"stack"`
+	Signature string            `json:"signature"`
+	Version   string            `json:"version"`
+	RequestID string            `json:"requestId,omitempty"`
+	TraceID   string            `json:"traceId,omitempty"`
+	Tenant    string            `json:"tenant,omitempty"`
+	Context   map[string]string `json:"context,omitempty"`
+
+	// times this signature panicked since the process started
+	Occurrences int `json:"occurrences"`
+}
+
+// CrashReporter makes recovered panics visible: the stack is logged, the
+// panic counted in metrics, a report written to Dir when set and an alert
+// sent when Alerts is on. Methods are nil safe.
+type CrashReporter struct {
+	// crash report files go here, empty writes none
+	Dir string
+
+	// page on a new panic, repeats of the same stack are held back for
+	// crashAlertInterval
+	Alerts   bool
+	notifier *NotificationDispatcher
+
+	metrics *Metrics
+
+	// by stack signature
+	seen      map[string]int
+	alertedAt map[string]time.Time
+
+	mu sync.Mutex
+}
+
+// constructor, notifier and metrics may be nil
+func NewCrashReporter(dir string, notifier *NotificationDispatcher, metrics *Metrics) *CrashReporter {
+	return &CrashReporter{Dir: dir, notifier: notifier, metrics: metrics, seen: map[string]int{}, alertedAt: map[string]time.Time{}}
+}
+
+// Report records a panic recovered in component, kv are context pairs like
+// a log line's. Call it from the deferred recover so the stack is the
+// panicking goroutine's.
+func (c *CrashReporter) Report(ctx context.Context, component string, p any, kv ...any) CrashReport {
+	stack := debug.Stack()
+	report := CrashReport{
+		Time:      time.Now().UTC(),
+		Component: component,
+		Panic:     fmt.Sprint(p),
+		Stack:     string(stack),
+		Signature: crashSignature(stack),
+		Version:   version,
+		RequestID: RequestIDFromContext(ctx),
+		Tenant:    TenantFromContext(ctx),
+		Context:   map[string]string{},
+	}
+	if sc := trace.SpanContextFromContext(ctx); sc.IsValid() {
+		report.TraceID = sc.TraceID().String()
+	}
+	r := slog.NewRecord(report.Time, slog.LevelError, "", 0)
+	r.Add(kv...)
+	r.Attrs(func(a slog.Attr) bool {
+		report.Context[a.Key] = redactLogAttr(nil, a).Value.String()
+		return true
+	})
+
+	slog.ErrorContext(ctx, "Recovered from a panic", slog.String("component", component), "panic", report.Panic, "signature", report.Signature, "stack", report.Stack)
+	if c == nil {
+		return report
+	}
+	c.metrics.Panicked(component)
+
+	c.mu.Lock()
+	c.seen[report.Signature]++
+	report.Occurrences = c.seen[report.Signature]
+	alert := c.Alerts && time.Since(c.alertedAt[report.Signature]) >= crashAlertInterval
+	if alert {
+		c.alertedAt[report.Signature] = report.Time
+	}
+	c.mu.Unlock()
+
+	if c.Dir != "" {
+		if err := c.write(report); err != nil {
+			slog.Error("Failed to write the crash report", logServer, "dir", c.Dir, "err", err)
+		}
+	}
+	if alert {
+		c.notifier.Dispatch(Notification{
+			Kind:     NotifyCrash,
+			Severity: SeverityCritical,
+			Title:    "Panic in " + component,
+			Message:  report.Panic,
+			KeyID:    report.Context["key_id"],
+			Details:  map[string]string{"signature": report.Signature, "occurrences": fmt.Sprint(report.Occurrences), "request_id": report.RequestID, "version": version},
+		})
+	}
+	return report
+}
+
+// crashSignature identifies where a panic came from, the frames w/o
+// goroutine ids, arguments or the recovery frames above the panic
+func crashSignature(stack []byte) string {
+	lines := strings.Split(string(stack), "\n")
+	for i, line := range lines {
+		if strings.HasPrefix(line, "panic(") {
+			lines = lines[i+1:]
+			break
+		}
+	}
+	h := sha256.New()
+	for _, line := range lines {
+		if strings.HasPrefix(line, "\t") {
+			if at := strings.LastIndex(line, " +0x"); at > 0 {
+				line = line[:at]
+			}
+			h.Write([]byte(line + "\n"))
+		}
+	}
+	return hex.EncodeToString(h.Sum(nil))[:16]
+}
+
+// write saves the report and keeps the directory to maxCrashReports
+func (c *CrashReporter) write(report CrashReport) error {
+	if err := os.MkdirAll(c.Dir, 0o700); err != nil {
+		return err
+	}
+	raw, err := json.MarshalIndent(report, "", "  ")
+	if err != nil {
+		return err
+	}
+	name := fmt.Sprintf("crash-%s-%s.json", report.Time.Format("20060102T150405.000000000Z"), report.Signature)
+	if err := os.WriteFile(filepath.Join(c.Dir, name), raw, 0o600); err != nil {
+		return err
+	}
+
+	old, err := filepath.Glob(filepath.Join(c.Dir, "crash-*.json"))
+	if err != nil || len(old) <= maxCrashReports {
+		return err
+	}
+	sort.Strings(old)
+	for _, path := range old[:len(old)-maxCrashReports] {
+		os.Remove(path)
+	}
+	return nil
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+)
+
+type DeadManStatus struct {
+	Timeout     string    `json:"timeout"`
+	LastCheckIn time.Time `json:"lastCheckIn"`
+	CheckedInBy string    `json:"checkedInBy,omitempty"`
+	Deadline    time.Time `json:"deadline"`
+	Tripped     bool      `json:"tripped"`
+}
+
+// DeadManSwitch seals the service and zeroizes high risk keys when no
+// operator has checked in for the timeout, so keys don't outlive the team
+// that guards them
+type DeadManSwitch struct {
+	store   *SecureKeyStore
+	seal    *SealState
+	timeout time.Duration
+
+	// warned once the deadline is close
+	notifier *NotificationDispatcher
+
+	lastCheckIn time.Time
+	checkedInBy string
+	warned      bool
+	tripped     bool
+
+	mu sync.Mutex
+}
+
+// constructor, the clock starts at startup
+func NewDeadManSwitch(store *SecureKeyStore, seal *SealState, timeout time.Duration) *DeadManSwitch {
+	return &DeadManSwitch{
+		store:       store,
+		seal:        seal,
+		timeout:     timeout,
+		lastCheckIn: time.Now(),
+	}
+}
+
+// CheckIn resets the timer, it does not undo a trip
+func (d *DeadManSwitch) CheckIn(operator string) DeadManStatus {
+	d.mu.Lock()
+
+	defer d.mu.Unlock()
+
+	d.lastCheckIn = time.Now()
+	d.checkedInBy = operator
+	d.warned = false
+
+	audit(context.Background(), logAdmin, "Dead man check-in", "operator", operator)
+	return d.status()
+}
+
+func (d *DeadManSwitch) Status() DeadManStatus {
+	d.mu.Lock()
+
+	defer d.mu.Unlock()
+
+	return d.status()
+}
+
+// status must be called w/ mu held
+func (d *DeadManSwitch) status() DeadManStatus {
+	return DeadManStatus{
+		Timeout:     d.timeout.String(),
+		LastCheckIn: d.lastCheckIn,
+		CheckedInBy: d.checkedInBy,
+		Deadline:    d.lastCheckIn.Add(d.timeout),
+		Tripped:     d.tripped,
+	}
+}
+
+// Check trips the switch once the deadline has passed and warns when 80% of
+// the timeout is gone
+func (d *DeadManSwitch) Check(now time.Time) {
+	d.mu.Lock()
+
+	defer d.mu.Unlock()
+
+	if d.tripped {
+		return
+	}
+	idle := now.Sub(d.lastCheckIn)
+
+	if idle >= d.timeout {
+		d.tripped = true
+		d.seal.Seal("", SealCauseDeadMan, "dead man's switch: no operator check-in for "+d.timeout.String(), "dead-man-switch")
+		cleared := d.store.ZerorizeMatching(func(k KeyUsage) bool { return k.Policy.HighRisk })
+
+		audit(context.Background(), logAdmin, "Dead man switch tripped", "idle", idle.Round(time.Second), "zeroized", len(cleared))
+		d.notifier.Dispatch(Notification{
+			Kind:     NotifySecurityAlert,
+			Severity: SeverityCritical,
+			Title:    "Dead man's switch tripped",
+			Message:  fmt.Sprintf("No operator checked in for %s, the service is sealed and %d high risk keys were zeroized", d.timeout, len(cleared)),
+		})
+		return
+	}
+
+	if !d.warned && idle >= d.timeout*4/5 {
+		d.warned = true
+		d.notifier.Dispatch(Notification{
+			Kind:     NotifySecurityAlert,
+			Severity: SeverityWarning,
+			Title:    "Dead man's switch check-in due",
+			Message:  fmt.Sprintf("No operator has checked in since %s, the switch trips at %s", d.lastCheckIn.UTC().Format(time.RFC3339), d.lastCheckIn.Add(d.timeout).UTC().Format(time.RFC3339)),
+		})
+	}
+}
+
+// Run checks on every interval until ctx is done
+func (d *DeadManSwitch) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			d.Check(now)
+		}
+	}
+}
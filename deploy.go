@@ -0,0 +1,654 @@
+package main
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"strings"
+	"sync"
+	"time"
+)
+
+// upgradeable loader account layouts
+const (
+	loaderBufferMetadataSize = 37
+	loaderProgramAccountSize = 36
+
+	// leaves room for two signatures and the write instruction header
+	maxDeployChunkSize = 900
+
+	// cluster's MAX_PERMITTED_DATA_LENGTH
+	maxProgramSize = 10 * 1024 * 1024
+)
+
+// deploy session states
+const (
+	DeployWriting   = "writing"
+	DeployFinalized = "finalized"
+	DeployClosed    = "closed"
+)
+
+var (
+	errDeployNotFound      = errors.New("deploy session not found")
+	errDeployNotAuthorized = errors.New("key is not an approved deploy authority")
+	errDeployPayer         = errors.New("key is not an approved deploy payer")
+	errDeployState         = errors.New("deploy session is not in a state that allows this step")
+)
+
+// DeployPolicy is the high-scrutiny policy for program deploys, deploys are
+// disabled unless at least one authority and one payer key are approved
+type DeployPolicy struct {
+	// key IDs allowed to act as upgrade authority
+	Authorities map[string]bool
+
+	// key IDs allowed to pay buffer and program rent
+	Payers map[string]bool
+
+	MaxProgramSize int
+	SessionTTL     time.Duration
+}
+
+// ParseDeployPolicy reads comma separated lists of authority and payer key IDs
+func ParseDeployPolicy(authorities, payers string) DeployPolicy {
+	return DeployPolicy{
+		Authorities:    parseKeyIDSet(authorities),
+		Payers:         parseKeyIDSet(payers),
+		MaxProgramSize: maxProgramSize,
+		SessionTTL:     24 * time.Hour,
+	}
+}
+
+func parseKeyIDSet(list string) map[string]bool {
+	set := make(map[string]bool)
+	for _, id := range strings.Split(list, ",") {
+		if id = strings.TrimSpace(id); id != "" {
+			set[id] = true
+		}
+	}
+	return set
+}
+
+type DeployStartRequest struct {
+	PayerKeyID     string `json:"payerKeyId"`
+	AuthorityKeyID string `json:"authorityKeyId"`
+
+	// set to upgrade an existing program, empty deploys a new one
+	ProgramID string `json:"programId,omitempty"`
+
+	ProgramSize   int    `json:"programSize"`
+	ProgramSHA256 string `json:"programSha256"`
+
+	RecentBlockhash string `json:"recentBlockhash"`
+}
+
+type DeployWriteRequest struct {
+	Offset          int    `json:"offset"`
+	Data            string `json:"data"`
+	RecentBlockhash string `json:"recentBlockhash"`
+}
+
+type DeployStepRequest struct {
+	RecentBlockhash string `json:"recentBlockhash"`
+
+	// only for new programs, defaults to twice the program size
+	MaxDataLen int `json:"maxDataLen,omitempty"`
+}
+
+type DeploySession struct {
+	ID             string    `json:"id"`
+	Status         string    `json:"status"`
+	PayerKeyID     string    `json:"payerKeyId"`
+	AuthorityKeyID string    `json:"authorityKeyId"`
+	BufferAddress  string    `json:"bufferAddress"`
+	ProgramID      string    `json:"programId,omitempty"`
+	ProgramSize    int       `json:"programSize"`
+	ProgramSHA256  string    `json:"programSha256"`
+	BytesWritten   int       `json:"bytesWritten"`
+	CreatedAt      time.Time `json:"createdAt"`
+
+	// existing program being upgraded rather than a fresh deploy
+	upgrade bool
+	hasher  hash.Hash
+
+	// offset to chunk digest, lets a chunk be re-signed w/ a fresh blockhash
+	chunks map[int][32]byte
+}
+
+type DeployStep struct {
+	DeployID    string `json:"deployId"`
+	Step        string `json:"step"`
+	Transaction string `json:"transaction"`
+	Signature   string `json:"signature"`
+}
+
+// DeployManager walks a program deploy through buffer creation, chunked
+// writes and finalize/upgrade, signing each step w/ managed keys so the
+// keys never leave the service. Each signature takes a use of the key like
+// a sign request, its rate limit and costs included.
+type DeployManager struct {
+	signer *signerService
+	policy DeployPolicy
+
+	sessions map[string]*DeploySession
+
+	// policy violations are raised as security alerts
+	notifier *NotificationDispatcher
+
+	// every step is refused while the keys' namespace is sealed, nil checks nothing
+	seal *SealState
+
+	mu sync.Mutex
+}
+
+// constructor
+func NewDeployManager(signer *signerService, policy DeployPolicy) *DeployManager {
+	return &DeployManager{
+		signer:   signer,
+		policy:   policy,
+		sessions: make(map[string]*DeploySession),
+	}
+}
+
+func (m *DeployManager) Start(ctx context.Context, req DeployStartRequest) (DeploySession, DeployStep, error) {
+	if req.PayerKeyID == "" || req.AuthorityKeyID == "" {
+		return DeploySession{}, DeployStep{}, errors.New("payerKeyId and authorityKeyId cannot be empty")
+	}
+	if !m.policy.Authorities[req.AuthorityKeyID] {
+		audit(ctx, logDeploy, "Deploy rejected, unapproved authority", "key_id", req.AuthorityKeyID)
+		m.notifier.Dispatch(Notification{
+			Kind:     NotifySecurityAlert,
+			Severity: SeverityCritical,
+			Title:    "Program deploy attempted w/ unapproved authority",
+			Message:  "A deploy was requested w/ a key that is not an approved deploy authority",
+			KeyID:    req.AuthorityKeyID,
+		})
+		return DeploySession{}, DeployStep{}, errDeployNotAuthorized
+	}
+	// the payer funds up to MaxProgramSize of rent, only approved keys may
+	if !m.policy.Payers[req.PayerKeyID] {
+		audit(ctx, logDeploy, "Deploy rejected, unapproved payer", "key_id", req.PayerKeyID)
+		m.notifier.Dispatch(Notification{
+			Kind:     NotifySecurityAlert,
+			Severity: SeverityCritical,
+			Title:    "Program deploy attempted w/ unapproved payer",
+			Message:  "A deploy was requested w/ a payer key that is not an approved deploy payer",
+			KeyID:    req.PayerKeyID,
+		})
+		return DeploySession{}, DeployStep{}, errDeployPayer
+	}
+	if req.ProgramSize <= 0 || req.ProgramSize > m.policy.MaxProgramSize {
+		return DeploySession{}, DeployStep{}, fmt.Errorf("programSize must be between 1 and %d bytes", m.policy.MaxProgramSize)
+	}
+	if digest, err := hex.DecodeString(req.ProgramSHA256); err != nil || len(digest) != sha256.Size {
+		return DeploySession{}, DeployStep{}, errors.New("programSha256 must be a hex SHA-256 digest")
+	}
+
+	// a deploy spans many signatures, keys w/ a use limit would be destroyed midway
+	for _, keyID := range []string{req.PayerKeyID, req.AuthorityKeyID} {
+		if policy, err := m.signer.store.Policy(keyID); err == nil && policy.Usage != UsagePersistent {
+			return DeploySession{}, DeployStep{}, fmt.Errorf("deploy key %s must have the %s usage policy", keyID, UsagePersistent)
+		}
+	}
+
+	payer, payerKey, err := m.solanaKey(ctx, req.PayerKeyID)
+	if err != nil {
+		return DeploySession{}, DeployStep{}, err
+	}
+	defer clear(payerKey)
+	authority, authorityKey, err := m.solanaKey(ctx, req.AuthorityKeyID)
+	if err != nil {
+		return DeploySession{}, DeployStep{}, err
+	}
+	defer clear(authorityKey)
+	blockhash, err := ParseSolanaPubkey(req.RecentBlockhash)
+	if err != nil {
+		return DeploySession{}, DeployStep{}, fmt.Errorf("invalid recentBlockhash: %w", err)
+	}
+
+	session := &DeploySession{
+		Status:         DeployWriting,
+		PayerKeyID:     req.PayerKeyID,
+		AuthorityKeyID: req.AuthorityKeyID,
+		ProgramSize:    req.ProgramSize,
+		ProgramSHA256:  strings.ToLower(req.ProgramSHA256),
+		CreatedAt:      time.Now(),
+		hasher:         sha256.New(),
+		chunks:         make(map[int][32]byte),
+	}
+	if req.ProgramID != "" {
+		if _, err := ParseSolanaPubkey(req.ProgramID); err != nil {
+			return DeploySession{}, DeployStep{}, err
+		}
+		session.ProgramID = req.ProgramID
+		session.upgrade = true
+	}
+
+	// the buffer keypair only signs its own creation, later steps are
+	// signed by the authority
+	bufferPub, bufferKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return DeploySession{}, DeployStep{}, fmt.Errorf("failed to generate buffer key: %w", err)
+	}
+	defer clear(bufferKey)
+
+	buffer := SolanaAddress(bufferPub)
+	session.BufferAddress = buffer.String()
+
+	bufferSize := uint64(loaderBufferMetadataSize + req.ProgramSize)
+	ixs := []SolanaInstruction{
+		SystemCreateAccountIx(payer, buffer, solanaRentExemptMinimum(bufferSize), bufferSize, BPFLoaderUpgradeableID),
+		loaderInitializeBufferIx(buffer, authority),
+	}
+
+	step, err := m.sign(ctx, session, "create-buffer", payer, blockhash, ixs, payerKey, bufferKey, authorityKey)
+	if err != nil {
+		return DeploySession{}, DeployStep{}, err
+	}
+
+	m.mu.Lock()
+	m.sessions[session.ID] = session
+	m.mu.Unlock()
+
+	audit(ctx, logDeploy, "Deploy started", "session_id", session.ID, "key_id", req.AuthorityKeyID, "buffer", session.BufferAddress, "bytes", req.ProgramSize)
+	return *session, step, nil
+}
+
+func (m *DeployManager) Write(ctx context.Context, id string, req DeployWriteRequest) (DeployStep, error) {
+	m.mu.Lock()
+
+	defer m.mu.Unlock()
+
+	session, err := m.session(id)
+	if err != nil {
+		return DeployStep{}, err
+	}
+	if session.Status != DeployWriting {
+		return DeployStep{}, errDeployState
+	}
+
+	chunk, err := base64.StdEncoding.DecodeString(req.Data)
+	if err != nil {
+		return DeployStep{}, fmt.Errorf("Invalid base64 encoding of chunk: %w", err)
+	}
+	if len(chunk) == 0 || len(chunk) > maxDeployChunkSize {
+		return DeployStep{}, fmt.Errorf("chunk must be between 1 and %d bytes", maxDeployChunkSize)
+	}
+	if req.Offset < 0 || req.Offset+len(chunk) > session.ProgramSize {
+		return DeployStep{}, errors.New("chunk falls outside the declared program size")
+	}
+
+	digest := sha256.Sum256(chunk)
+	prev, seen := session.chunks[req.Offset]
+	switch {
+	case seen && prev != digest:
+		audit(ctx, logDeploy, "Deploy rewrite w/ different bytes rejected", "session_id", id, "offset", req.Offset)
+		return DeployStep{}, errors.New("chunk at this offset was already signed w/ different bytes")
+	case !seen && req.Offset != session.BytesWritten:
+		return DeployStep{}, fmt.Errorf("chunks must be written in order, next offset is %d", session.BytesWritten)
+	}
+
+	payer, payerKey, err := m.solanaKey(ctx, session.PayerKeyID)
+	if err != nil {
+		return DeployStep{}, err
+	}
+	defer clear(payerKey)
+	authority, authorityKey, err := m.solanaKey(ctx, session.AuthorityKeyID)
+	if err != nil {
+		return DeployStep{}, err
+	}
+	defer clear(authorityKey)
+	blockhash, err := ParseSolanaPubkey(req.RecentBlockhash)
+	if err != nil {
+		return DeployStep{}, fmt.Errorf("invalid recentBlockhash: %w", err)
+	}
+	buffer, err := ParseSolanaPubkey(session.BufferAddress)
+	if err != nil {
+		return DeployStep{}, err
+	}
+
+	ixs := []SolanaInstruction{loaderWriteIx(buffer, authority, uint32(req.Offset), chunk)}
+	step, err := m.sign(ctx, session, "write", payer, blockhash, ixs, payerKey, authorityKey)
+	if err != nil {
+		return DeployStep{}, err
+	}
+
+	if !seen {
+		session.chunks[req.Offset] = digest
+		session.hasher.Write(chunk)
+		session.BytesWritten += len(chunk)
+	}
+	return step, nil
+}
+
+func (m *DeployManager) Finalize(ctx context.Context, id string, req DeployStepRequest) (DeployStep, error) {
+	m.mu.Lock()
+
+	defer m.mu.Unlock()
+
+	session, err := m.session(id)
+	if err != nil {
+		return DeployStep{}, err
+	}
+	if session.Status != DeployWriting {
+		return DeployStep{}, errDeployState
+	}
+
+	// the buffer must hold exactly the program that was declared up front
+	if session.BytesWritten != session.ProgramSize {
+		return DeployStep{}, fmt.Errorf("buffer incomplete: %d of %d bytes written", session.BytesWritten, session.ProgramSize)
+	}
+	if got := hex.EncodeToString(session.hasher.Sum(nil)); got != session.ProgramSHA256 {
+		audit(ctx, logDeploy, "Deploy finalize refused, digest mismatch", "session_id", id, "digest", got, "declared", session.ProgramSHA256)
+		m.notifier.Dispatch(Notification{
+			Kind:     NotifySecurityAlert,
+			Severity: SeverityCritical,
+			Title:    "Program deploy digest mismatch",
+			Message:  "Written program bytes do not match the digest declared when the deploy started",
+			KeyID:    session.AuthorityKeyID,
+			Details:  map[string]string{"deployId": id, "declared": session.ProgramSHA256, "written": got},
+		})
+		return DeployStep{}, errors.New("written program does not match declared programSha256")
+	}
+
+	payer, payerKey, err := m.solanaKey(ctx, session.PayerKeyID)
+	if err != nil {
+		return DeployStep{}, err
+	}
+	defer clear(payerKey)
+	authority, authorityKey, err := m.solanaKey(ctx, session.AuthorityKeyID)
+	if err != nil {
+		return DeployStep{}, err
+	}
+	defer clear(authorityKey)
+	blockhash, err := ParseSolanaPubkey(req.RecentBlockhash)
+	if err != nil {
+		return DeployStep{}, fmt.Errorf("invalid recentBlockhash: %w", err)
+	}
+	buffer, err := ParseSolanaPubkey(session.BufferAddress)
+	if err != nil {
+		return DeployStep{}, err
+	}
+
+	var step DeployStep
+	if session.upgrade {
+		program, _ := ParseSolanaPubkey(session.ProgramID)
+		ix, err := loaderUpgradeIx(program, buffer, authority, payer)
+		if err != nil {
+			return DeployStep{}, err
+		}
+		step, err = m.sign(ctx, session, "upgrade", payer, blockhash, []SolanaInstruction{ix}, payerKey, authorityKey)
+		if err != nil {
+			return DeployStep{}, err
+		}
+	} else {
+		maxDataLen := req.MaxDataLen
+		if maxDataLen == 0 {
+			maxDataLen = 2 * session.ProgramSize
+		}
+		if maxDataLen < session.ProgramSize || maxDataLen > m.policy.MaxProgramSize {
+			return DeployStep{}, fmt.Errorf("maxDataLen must be between %d and %d bytes", session.ProgramSize, m.policy.MaxProgramSize)
+		}
+
+		// fresh program keypair, it only signs the program account's creation,
+		// upgrades are signed by the authority
+		programPub, programKey, err := ed25519.GenerateKey(rand.Reader)
+		if err != nil {
+			return DeployStep{}, fmt.Errorf("failed to generate program key: %w", err)
+		}
+		defer clear(programKey)
+		program := SolanaAddress(programPub)
+
+		deployIx, err := loaderDeployWithMaxDataLenIx(payer, program, buffer, authority, uint64(maxDataLen))
+		if err != nil {
+			return DeployStep{}, err
+		}
+		ixs := []SolanaInstruction{
+			SystemCreateAccountIx(payer, program, solanaRentExemptMinimum(loaderProgramAccountSize), loaderProgramAccountSize, BPFLoaderUpgradeableID),
+			deployIx,
+		}
+		step, err = m.sign(ctx, session, "deploy", payer, blockhash, ixs, payerKey, programKey, authorityKey)
+		if err != nil {
+			return DeployStep{}, err
+		}
+
+		session.ProgramID = program.String()
+	}
+
+	// buffer is consumed by the loader
+	session.Status = DeployFinalized
+	audit(ctx, logDeploy, "Deploy finalized", "session_id", id, "program", session.ProgramID, "sha256", session.ProgramSHA256, "key_id", session.AuthorityKeyID)
+	return step, nil
+}
+
+// Close abandons a deploy and returns the buffer's lamports to the payer
+func (m *DeployManager) Close(ctx context.Context, id string, req DeployStepRequest) (DeployStep, error) {
+	m.mu.Lock()
+
+	defer m.mu.Unlock()
+
+	session, err := m.session(id)
+	if err != nil {
+		return DeployStep{}, err
+	}
+	if session.Status != DeployWriting {
+		return DeployStep{}, errDeployState
+	}
+
+	payer, payerKey, err := m.solanaKey(ctx, session.PayerKeyID)
+	if err != nil {
+		return DeployStep{}, err
+	}
+	defer clear(payerKey)
+	authority, authorityKey, err := m.solanaKey(ctx, session.AuthorityKeyID)
+	if err != nil {
+		return DeployStep{}, err
+	}
+	defer clear(authorityKey)
+	blockhash, err := ParseSolanaPubkey(req.RecentBlockhash)
+	if err != nil {
+		return DeployStep{}, fmt.Errorf("invalid recentBlockhash: %w", err)
+	}
+	buffer, err := ParseSolanaPubkey(session.BufferAddress)
+	if err != nil {
+		return DeployStep{}, err
+	}
+
+	ixs := []SolanaInstruction{loaderCloseIx(buffer, payer, authority)}
+	step, err := m.sign(ctx, session, "close", payer, blockhash, ixs, payerKey, authorityKey)
+	if err != nil {
+		return DeployStep{}, err
+	}
+
+	session.Status = DeployClosed
+	audit(ctx, logDeploy, "Deploy closed, buffer reclaimed to payer", "session_id", id, "buffer", session.BufferAddress)
+	return step, nil
+}
+
+func (m *DeployManager) Get(id string) (DeploySession, error) {
+	m.mu.Lock()
+
+	defer m.mu.Unlock()
+
+	session, err := m.session(id)
+	if err != nil {
+		return DeploySession{}, err
+	}
+	return *session, nil
+}
+
+// session must be called w/ mu held, expired sessions are dropped lazily
+func (m *DeployManager) session(id string) (*DeploySession, error) {
+	session, ok := m.sessions[id]
+	if !ok {
+		return nil, errDeployNotFound
+	}
+	if time.Since(session.CreatedAt) > m.policy.SessionTTL {
+		delete(m.sessions, id)
+		return nil, errDeployNotFound
+	}
+	return session, nil
+}
+
+// solanaKey takes a use of a deploy key, refused while it is frozen or its
+// namespace sealed like any other signature. The caller clears it.
+func (m *DeployManager) solanaKey(ctx context.Context, keyID string) (SolanaPubkey, ed25519.PrivateKey, error) {
+	info, err := m.signer.store.Info(keyID)
+	if err != nil {
+		return SolanaPubkey{}, nil, fmt.Errorf("key retrieval failed for %s w/ error: %w", keyID, err)
+	}
+	if m.seal != nil {
+		if err := m.seal.Check(info.Namespace); err != nil {
+			return SolanaPubkey{}, nil, err
+		}
+	}
+	privKey, err := m.signer.deployKey(ctx, keyID)
+	if err != nil {
+		return SolanaPubkey{}, nil, fmt.Errorf("key retrieval failed for %s w/ error: %w", keyID, err)
+	}
+	return SolanaAddress(privKey.Public().(ed25519.PublicKey)), privKey, nil
+}
+
+func (m *DeployManager) sign(ctx context.Context, session *DeploySession, stepName string, payer, blockhash SolanaPubkey, ixs []SolanaInstruction, keys ...ed25519.PrivateKey) (DeployStep, error) {
+	byAddress := make(map[SolanaPubkey]ed25519.PrivateKey, len(keys))
+	for _, key := range keys {
+		byAddress[SolanaAddress(key.Public().(ed25519.PublicKey))] = key
+	}
+
+	msg, signers := CompileSolanaMessage(payer, blockhash, ixs)
+	tx, sigs, err := SignSolanaMessage(msg, signers, byAddress)
+	if err != nil {
+		return DeployStep{}, fmt.Errorf("signing %s step failed w/ error: %w", stepName, err)
+	}
+	m.signer.costs.Record(ctx, CostSign)
+	m.signer.meter.Record(ctx, MeterSign)
+
+	// first signature doubles as the transaction id and the session id
+	txID := base58Encode(sigs[0])
+	if session.ID == "" {
+		session.ID = txID
+	}
+
+	return DeployStep{
+		DeployID:    session.ID,
+		Step:        stepName,
+		Transaction: base64.StdEncoding.EncodeToString(tx),
+		Signature:   txID,
+	}, nil
+}
+
+// deployKey takes one use of a deploy key the way a sign request does, its
+// rate limit and keystore read count. The caller clears it.
+func (s *signerService) deployKey(ctx context.Context, keyID string) (ed25519.PrivateKey, error) {
+	policy, err := s.store.Policy(keyID)
+	if err != nil {
+		return nil, err
+	}
+	if policy.RateLimit != nil {
+		if err := s.limiter.Take(keyID, *policy.RateLimit, time.Now()); err != nil {
+			return nil, err
+		}
+	}
+
+	keyType, privKey, _, err := s.store.Acquire(keyID)
+	s.costs.Record(ctx, CostKeystoreRead)
+	if err != nil {
+		return nil, err
+	}
+	if keyType != KeyTypeEd25519 {
+		clear(privKey)
+		return nil, errors.New("key is not an ed25519 key")
+	}
+	return ed25519.PrivateKey(privKey), nil
+}
+
+func loaderInitializeBufferIx(buffer, authority SolanaPubkey) SolanaInstruction {
+	return SolanaInstruction{
+		ProgramID: BPFLoaderUpgradeableID,
+		Accounts: []SolanaAccountMeta{
+			{Pubkey: buffer, IsWritable: true},
+			{Pubkey: authority},
+		},
+		Data: binary.LittleEndian.AppendUint32(nil, 0),
+	}
+}
+
+func loaderWriteIx(buffer, authority SolanaPubkey, offset uint32, chunk []byte) SolanaInstruction {
+	data := binary.LittleEndian.AppendUint32(nil, 1)
+	data = binary.LittleEndian.AppendUint32(data, offset)
+	data = binary.LittleEndian.AppendUint64(data, uint64(len(chunk)))
+	data = append(data, chunk...)
+
+	return SolanaInstruction{
+		ProgramID: BPFLoaderUpgradeableID,
+		Accounts: []SolanaAccountMeta{
+			{Pubkey: buffer, IsWritable: true},
+			{Pubkey: authority, IsSigner: true},
+		},
+		Data: data,
+	}
+}
+
+func loaderDeployWithMaxDataLenIx(payer, program, buffer, authority SolanaPubkey, maxDataLen uint64) (SolanaInstruction, error) {
+	programData, _, err := FindProgramAddress([][]byte{program[:]}, BPFLoaderUpgradeableID)
+	if err != nil {
+		return SolanaInstruction{}, err
+	}
+
+	data := binary.LittleEndian.AppendUint32(nil, 2)
+	data = binary.LittleEndian.AppendUint64(data, maxDataLen)
+
+	return SolanaInstruction{
+		ProgramID: BPFLoaderUpgradeableID,
+		Accounts: []SolanaAccountMeta{
+			{Pubkey: payer, IsSigner: true, IsWritable: true},
+			{Pubkey: programData, IsWritable: true},
+			{Pubkey: program, IsWritable: true},
+			{Pubkey: buffer, IsWritable: true},
+			{Pubkey: SysvarRentID},
+			{Pubkey: SysvarClockID},
+			{Pubkey: SystemProgramID},
+			{Pubkey: authority, IsSigner: true},
+		},
+		Data: data,
+	}, nil
+}
+
+func loaderUpgradeIx(program, buffer, authority, spill SolanaPubkey) (SolanaInstruction, error) {
+	programData, _, err := FindProgramAddress([][]byte{program[:]}, BPFLoaderUpgradeableID)
+	if err != nil {
+		return SolanaInstruction{}, err
+	}
+
+	return SolanaInstruction{
+		ProgramID: BPFLoaderUpgradeableID,
+		Accounts: []SolanaAccountMeta{
+			{Pubkey: programData, IsWritable: true},
+			{Pubkey: program, IsWritable: true},
+			{Pubkey: buffer, IsWritable: true},
+			{Pubkey: spill, IsWritable: true},
+			{Pubkey: SysvarRentID},
+			{Pubkey: SysvarClockID},
+			{Pubkey: authority, IsSigner: true},
+		},
+		Data: binary.LittleEndian.AppendUint32(nil, 3),
+	}, nil
+}
+
+func loaderCloseIx(buffer, recipient, authority SolanaPubkey) SolanaInstruction {
+	return SolanaInstruction{
+		ProgramID: BPFLoaderUpgradeableID,
+		Accounts: []SolanaAccountMeta{
+			{Pubkey: buffer, IsWritable: true},
+			{Pubkey: recipient, IsWritable: true},
+			{Pubkey: authority, IsSigner: true},
+		},
+		Data: binary.LittleEndian.AppendUint32(nil, 5),
+	}
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+)
+
+// chunk writes carry up to maxDeployChunkSize bytes of base64 program data
+const deployMaxBodySize = 8192
+
+func deployErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, errDeployNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, errDeployNotAuthorized), errors.Is(err, errDeployPayer):
+		return http.StatusForbidden
+	case errors.Is(err, errDeployState):
+		return http.StatusConflict
+	default:
+		return signErrorStatus(err)
+	}
+}
+
+func (s *APIServer) handleDeployStart(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	var req DeployStartRequest
+	if err := decodeJSON(r.Body, &req); err != nil {
+		writeError(w, r, http.StatusBadRequest, invalidBody(err))
+		return
+	}
+
+	for _, keyID := range []string{req.AuthorityKeyID, req.PayerKeyID} {
+		if err := s.checkAPIToken(r, TokenOpSign, keyID); err != nil {
+			refuseAPIToken(w, r, err)
+			return
+		}
+	}
+
+	session, step, err := s.Deploys.Start(r.Context(), req)
+	if err != nil {
+		writeError(w, r, deployErrorStatus(err), err)
+		return
+	}
+
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(struct {
+		Session DeploySession `json:"session"`
+		Step    DeployStep    `json:"step"`
+	}{session, step})
+}
+
+func (s *APIServer) handleDeployGet(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	session, ok := s.deploySession(w, r, TokenOpRead)
+	if !ok {
+		return
+	}
+
+	json.NewEncoder(w).Encode(session)
+}
+
+// deploySession looks up the path's deploy, the API token must allow op on
+// its authority and payer keys
+func (s *APIServer) deploySession(w http.ResponseWriter, r *http.Request, op string) (DeploySession, bool) {
+	session, err := s.Deploys.Get(r.PathValue("id"))
+	if err != nil {
+		writeError(w, r, deployErrorStatus(err), err)
+		return DeploySession{}, false
+	}
+	for _, keyID := range []string{session.AuthorityKeyID, session.PayerKeyID} {
+		if err := s.checkAPIToken(r, op, keyID); err != nil {
+			refuseAPIToken(w, r, err)
+			return DeploySession{}, false
+		}
+	}
+	return session, true
+}
+
+func (s *APIServer) handleDeployWrite(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	var req DeployWriteRequest
+	if err := decodeJSON(r.Body, &req); err != nil {
+		writeError(w, r, http.StatusBadRequest, invalidBody(err))
+		return
+	}
+
+	if _, ok := s.deploySession(w, r, TokenOpSign); !ok {
+		return
+	}
+
+	step, err := s.Deploys.Write(r.Context(), r.PathValue("id"), req)
+	if err != nil {
+		writeError(w, r, deployErrorStatus(err), err)
+		return
+	}
+
+	json.NewEncoder(w).Encode(step)
+}
+
+func (s *APIServer) handleDeployFinalize(w http.ResponseWriter, r *http.Request) {
+	s.handleDeployStep(w, r, s.Deploys.Finalize)
+}
+
+func (s *APIServer) handleDeployClose(w http.ResponseWriter, r *http.Request) {
+	s.handleDeployStep(w, r, s.Deploys.Close)
+}
+
+func (s *APIServer) handleDeployStep(w http.ResponseWriter, r *http.Request, step func(context.Context, string, DeployStepRequest) (DeployStep, error)) {
+	w.Header().Set("Content-Type", "application/json")
+
+	var req DeployStepRequest
+	if err := decodeJSON(r.Body, &req); err != nil {
+		writeError(w, r, http.StatusBadRequest, invalidBody(err))
+		return
+	}
+
+	if _, ok := s.deploySession(w, r, TokenOpSign); !ok {
+		return
+	}
+
+	res, err := step(r.Context(), r.PathValue("id"), req)
+	if err != nil {
+		writeError(w, r, deployErrorStatus(err), err)
+		return
+	}
+
+	json.NewEncoder(w).Encode(res)
+}
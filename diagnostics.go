@@ -0,0 +1,100 @@
+package main
+
+import (
+	"encoding/json"
+	"expvar"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/pprof"
+	"runtime"
+	"time"
+)
+
+// RuntimeStats is a snapshot of the Go runtime for /debug/runtime
+type RuntimeStats struct {
+	Version    string `json:"version"`
+	GoVersion  string `json:"goVersion"`
+	Uptime     string `json:"uptime"`
+	Goroutines int    `json:"goroutines"`
+	NumCPU     int    `json:"numCpu"`
+	GOMAXPROCS int    `json:"gomaxprocs"`
+
+	HeapAllocBytes uint64    `json:"heapAllocBytes"`
+	HeapObjects    uint64    `json:"heapObjects"`
+	SysBytes       uint64    `json:"sysBytes"`
+	NumGC          uint32    `json:"numGc"`
+	GCPauseTotal   string    `json:"gcPauseTotal"`
+	LastGC         time.Time `json:"lastGc"`
+}
+
+func CurrentRuntimeStats() RuntimeStats {
+	var mem runtime.MemStats
+	runtime.ReadMemStats(&mem)
+
+	return RuntimeStats{
+		Version:        version,
+		GoVersion:      runtime.Version(),
+		Uptime:         time.Since(startedAt).Round(time.Second).String(),
+		Goroutines:     runtime.NumGoroutine(),
+		NumCPU:         runtime.NumCPU(),
+		GOMAXPROCS:     runtime.GOMAXPROCS(0),
+		HeapAllocBytes: mem.HeapAlloc,
+		HeapObjects:    mem.HeapObjects,
+		SysBytes:       mem.Sys,
+		NumGC:          mem.NumGC,
+		GCPauseTotal:   time.Duration(mem.PauseTotalNs).String(),
+		LastGC:         time.Unix(0, int64(mem.LastGC)).UTC(),
+	}
+}
+
+// DiagnosticsHandler serves pprof, expvar and runtime stats. It has no auth
+// and must only be reachable from the host.
+func DiagnosticsHandler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/debug/pprof/", pprof.Index)
+	mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+	mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+	mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+	mux.Handle("/debug/vars", expvar.Handler())
+	mux.HandleFunc("GET /debug/runtime", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(CurrentRuntimeStats())
+	})
+	return mux
+}
+
+// checkLoopback refuses listen addresses reachable from off the host
+func checkLoopback(addr string) error {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return err
+	}
+	if host == "localhost" {
+		return nil
+	}
+	if ip := net.ParseIP(host); ip == nil || !ip.IsLoopback() {
+		return fmt.Errorf("diagnostics address %s is not loopback, use 127.0.0.1 or [::1]", addr)
+	}
+	return nil
+}
+
+// diagnosticsServer builds the diagnostics listener's server for addr
+func diagnosticsServer(addr string) (*http.Server, error) {
+	if err := checkLoopback(addr); err != nil {
+		return nil, err
+	}
+
+	// lock contention and blocking show up in the mutex and block profiles
+	runtime.SetMutexProfileFraction(10)
+	runtime.SetBlockProfileRate(int(10 * time.Microsecond))
+
+	// pprof's profile and trace stream for as long as asked, so no write timeout
+	server := &http.Server{
+		Addr:              addr,
+		Handler:           DiagnosticsHandler(),
+		ReadHeaderTimeout: 5 * time.Second,
+	}
+	return server, nil
+}
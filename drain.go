@@ -0,0 +1,128 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"sync"
+	"time"
+)
+
+// GoAway is sent to long-lived streams (WebSocket/SSE/gRPC) before they are
+// closed, so clients can fail over to another replica instead of erroring out
+type GoAway struct {
+	Reason       string `json:"reason"`
+	ReconnectTo  string `json:"reconnectTo,omitempty"`
+	RetryAfterMs int64  `json:"retryAfterMs"`
+}
+
+// StreamDrainer tracks open streams and fans out a going-away notice when
+// shutdown or maintenance begins
+type StreamDrainer struct {
+	// stream id to notice channel
+	streams map[uint64]chan GoAway
+	nextID  uint64
+
+	// set once draining starts, new streams get the notice right away
+	notice *GoAway
+
+	// signalled when a stream deregisters
+	changed chan struct{}
+
+	mu sync.Mutex
+}
+
+// constructor
+func NewStreamDrainer() *StreamDrainer {
+	return &StreamDrainer{
+		streams: make(map[uint64]chan GoAway),
+		changed: make(chan struct{}, 1),
+	}
+}
+
+// Register adds a stream. The returned channel receives at most one notice,
+// and done must be called once the stream has been closed.
+func (d *StreamDrainer) Register() (<-chan GoAway, func()) {
+	d.mu.Lock()
+
+	defer d.mu.Unlock()
+
+	ch := make(chan GoAway, 1)
+
+	// already draining, tell the new stream to go elsewhere immediately
+	if d.notice != nil {
+		ch <- *d.notice
+		return ch, func() {}
+	}
+
+	id := d.nextID
+	d.nextID++
+	d.streams[id] = ch
+
+	var once sync.Once
+	done := func() {
+		once.Do(func() {
+			d.mu.Lock()
+			delete(d.streams, id)
+			d.mu.Unlock()
+
+			select {
+			case d.changed <- struct{}{}:
+			default:
+			}
+		})
+	}
+
+	return ch, done
+}
+
+// Drain sends the notice to every open stream. Safe to call more than once,
+// only the first notice is delivered.
+func (d *StreamDrainer) Drain(notice GoAway) {
+	d.mu.Lock()
+
+	defer d.mu.Unlock()
+
+	if d.notice != nil {
+		return
+	}
+	d.notice = &notice
+
+	for _, ch := range d.streams {
+		ch <- notice
+	}
+
+	slog.Info("Draining open streams", logServer, "streams", len(d.streams), "reason", notice.Reason, "reconnect_to", notice.ReconnectTo)
+}
+
+func (d *StreamDrainer) Draining() bool {
+	d.mu.Lock()
+
+	defer d.mu.Unlock()
+
+	return d.notice != nil
+}
+
+// Open returns the number of streams that have not finished yet
+func (d *StreamDrainer) Open() int {
+	d.mu.Lock()
+
+	defer d.mu.Unlock()
+
+	return len(d.streams)
+}
+
+// Wait blocks until all streams have closed or ctx is done
+func (d *StreamDrainer) Wait(ctx context.Context) error {
+	ticker := time.NewTicker(100 * time.Millisecond)
+	defer ticker.Stop()
+
+	for d.Open() > 0 {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-d.changed:
+		case <-ticker.C:
+		}
+	}
+	return nil
+}
@@ -0,0 +1,184 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/net/websocket"
+)
+
+// event types streamed on /api/v1/events
+const (
+	EventKeyCreated    = "key.created"
+	EventKeyDestroyed  = "key.destroyed"
+	EventSignPending   = "sign.pending_approval"
+	EventSignApproved  = "sign.approved"
+	EventSignRejected  = "sign.rejected"
+	EventSignSigned    = "sign.signed"
+	EventSignBroadcast = "sign.broadcast"
+	EventSignFailed    = "sign.failed"
+	eventGoAway        = "goaway"
+)
+
+const (
+	// events held per subscriber before new ones are dropped
+	eventsBuffer = 64
+
+	// a client that can't take an event this fast is disconnected
+	eventsWriteDeadline = 10 * time.Second
+)
+
+// Event is a key lifecycle or signing job change, never carries key material
+type Event struct {
+	Type       string    `json:"type"`
+	KeyID      string    `json:"keyId,omitempty"`
+	ApprovalID string    `json:"approvalId,omitempty"`
+	Namespace  string    `json:"namespace,omitempty"`
+	RequestID  string    `json:"requestId,omitempty"`
+	Detail     string    `json:"detail,omitempty"`
+	Time       time.Time `json:"time"`
+
+	// set on goaway, the stream is closed right after
+	GoAway *GoAway `json:"goAway,omitempty"`
+}
+
+// EventBus fans events out to subscribers, a subscriber that falls behind
+// misses events rather than slowing the signer down
+type EventBus struct {
+	subs map[uint64]chan Event
+	next uint64
+
+	mu sync.Mutex
+}
+
+// constructor
+func NewEventBus() *EventBus {
+	return &EventBus{subs: make(map[uint64]chan Event)}
+}
+
+// Publish stamps and sends e to every subscriber, nil safe
+func (b *EventBus) Publish(ctx context.Context, e Event) {
+	if b == nil {
+		return
+	}
+	e.Time = time.Now().UTC()
+	e.RequestID = RequestIDFromContext(ctx)
+
+	b.mu.Lock()
+
+	defer b.mu.Unlock()
+
+	for id, ch := range b.subs {
+		select {
+		case ch <- e:
+		default:
+			slog.WarnContext(ctx, "Event subscriber is behind, dropped an event", logEvents, "subscriber", id, "type", e.Type)
+		}
+	}
+}
+
+// Subscribe returns the event channel and a func to stop receiving
+func (b *EventBus) Subscribe() (<-chan Event, func()) {
+	b.mu.Lock()
+
+	defer b.mu.Unlock()
+
+	id := b.next
+	b.next++
+	ch := make(chan Event, eventsBuffer)
+	b.subs[id] = ch
+
+	var once sync.Once
+	return ch, func() {
+		once.Do(func() {
+			b.mu.Lock()
+			delete(b.subs, id)
+			b.mu.Unlock()
+		})
+	}
+}
+
+// publishSignResult reports how a sign request ended
+func (s *signerService) publishSignResult(ctx context.Context, result TransactionResult, err error) {
+	e := Event{KeyID: result.KeyID, ApprovalID: approvalFromContext(ctx)}
+	switch {
+	case err != nil:
+		e.Type = EventSignFailed
+		e.Detail = err.Error()
+	case result.ApprovalID != "" && result.Signature == "":
+		e.Type = EventSignPending
+		e.ApprovalID = result.ApprovalID
+	default:
+		e.Type = EventSignSigned
+	}
+	s.events.Publish(ctx, e)
+
+	if result.TxSignature != "" {
+		s.events.Publish(ctx, Event{Type: EventSignBroadcast, KeyID: result.KeyID, ApprovalID: e.ApprovalID, Detail: result.TxSignature})
+	}
+	if result.KeyDestroyed {
+		s.events.Publish(ctx, Event{Type: EventKeyDestroyed, KeyID: result.KeyID, Detail: "usage policy used up"})
+	}
+}
+
+// handleEvents streams events over a WebSocket, ?types= takes a comma
+// separated list to filter on
+func (s *APIServer) handleEvents(w http.ResponseWriter, r *http.Request) {
+	var types []string
+	if raw := r.URL.Query().Get("types"); raw != "" {
+		types = strings.Split(raw, ",")
+	}
+	operator := OperatorFromContext(r.Context()).Name
+
+	// operators authenticated w/ a bearer token, so no origin check
+	websocket.Server{Handler: func(ws *websocket.Conn) {
+		defer ws.Close()
+
+		// the server's read and write timeouts don't apply to a stream
+		ws.SetDeadline(time.Time{})
+
+		events, unsubscribe := s.Events.Subscribe()
+		defer unsubscribe()
+		notices, done := s.Drainer.Register()
+		defer done()
+
+		audit(r.Context(), logAdmin, "Event stream opened", "operator", operator)
+
+		// nothing is expected from the client, reading spots the close
+		closed := make(chan struct{})
+		go func() {
+			defer close(closed)
+
+			var discard []byte
+			for websocket.Message.Receive(ws, &discard) == nil {
+			}
+		}()
+
+		send := func(e Event) bool {
+			ws.SetWriteDeadline(time.Now().Add(eventsWriteDeadline))
+			return websocket.JSON.Send(ws, e) == nil
+		}
+
+		for {
+			select {
+			case e := <-events:
+				if types != nil && !slices.Contains(types, e.Type) {
+					continue
+				}
+				if !send(e) {
+					return
+				}
+			case notice := <-notices:
+				send(Event{Type: eventGoAway, Time: time.Now().UTC(), GoAway: &notice})
+				return
+			case <-closed:
+				return
+			}
+		}
+	}}.ServeHTTP(w, r)
+}
@@ -0,0 +1,155 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"maps"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+var errFeatureDisabled = errors.New("feature is disabled")
+
+// feature flags, named after what they gate
+const (
+	FeatureBroadcast        = "broadcast"
+	FeatureSimulate         = "simulate"
+	FeatureKeyTypeSecp256k1 = "keytype.secp256k1"
+	FeatureKeyTypeP256      = "keytype.p256"
+)
+
+// every flag and whether it's on w/o config. Capabilities that shipped
+// before flags existed default on, new risky ones should default off.
+var defaultFeatures = map[string]bool{
+	FeatureBroadcast:        true,
+	FeatureSimulate:         true,
+	FeatureKeyTypeSecp256k1: true,
+	FeatureKeyTypeP256:      true,
+}
+
+// FeatureFlags switches capabilities on and off at runtime, from
+// STS_FEATURES and the config file's features section. A nil *FeatureFlags
+// has every flag at its default.
+type FeatureFlags struct {
+	flags map[string]bool
+
+	mu sync.RWMutex
+}
+
+// constructor, overrides go on top of the defaults
+func NewFeatureFlags(overrides map[string]bool) (*FeatureFlags, error) {
+	f := &FeatureFlags{}
+	if err := f.Set(overrides); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+// FeatureFlagsFromEnv reads STS_FEATURES, e.g. "broadcast=false,keytype.p256=true"
+func FeatureFlagsFromEnv(getenv func(string) string) (map[string]bool, error) {
+	raw := getenv("STS_FEATURES")
+	if raw == "" {
+		return nil, nil
+	}
+
+	overrides := map[string]bool{}
+	for _, entry := range strings.Split(raw, ",") {
+		name, value, ok := strings.Cut(strings.TrimSpace(entry), "=")
+		on, err := strconv.ParseBool(value)
+		if !ok || err != nil {
+			return nil, fmt.Errorf("invalid feature %q, use name=true or name=false", entry)
+		}
+		overrides[name] = on
+	}
+	return overrides, checkFeatureNames(overrides)
+}
+
+func checkFeatureNames(overrides map[string]bool) error {
+	for name := range overrides {
+		if _, ok := defaultFeatures[name]; !ok {
+			return fmt.Errorf("unknown feature %q, known: %v", name, slices.Sorted(maps.Keys(defaultFeatures)))
+		}
+	}
+	return nil
+}
+
+// Set replaces the overrides, flags left out go back to their defaults
+func (f *FeatureFlags) Set(overrides map[string]bool) error {
+	if err := checkFeatureNames(overrides); err != nil {
+		return err
+	}
+	flags := maps.Clone(defaultFeatures)
+	maps.Copy(flags, overrides)
+
+	f.mu.Lock()
+
+	defer f.mu.Unlock()
+
+	for name, on := range flags {
+		if was, ok := f.flags[name]; ok && was != on {
+			slog.Info("Feature turned "+onOff(on), logConfig, "feature", name)
+		}
+	}
+	f.flags = flags
+	return nil
+}
+
+func (f *FeatureFlags) Enabled(name string) bool {
+	if f == nil {
+		return defaultFeatures[name]
+	}
+	f.mu.RLock()
+
+	defer f.mu.RUnlock()
+
+	return f.flags[name]
+}
+
+// Check returns errFeatureDisabled when name is off
+func (f *FeatureFlags) Check(name string) error {
+	if !f.Enabled(name) {
+		return fmt.Errorf("%w: %s", errFeatureDisabled, name)
+	}
+	return nil
+}
+
+// All is every flag and its current state
+func (f *FeatureFlags) All() map[string]bool {
+	if f == nil {
+		return maps.Clone(defaultFeatures)
+	}
+	f.mu.RLock()
+
+	defer f.mu.RUnlock()
+
+	return maps.Clone(f.flags)
+}
+
+// keyTypeFeature is the flag gating keyType, ed25519 is always on
+func keyTypeFeature(keyType string) string {
+	switch keyType {
+	case KeyTypeSecp256k1:
+		return FeatureKeyTypeSecp256k1
+	case KeyTypeP256:
+		return FeatureKeyTypeP256
+	}
+	return ""
+}
+
+func onOff(on bool) string {
+	if on {
+		return "on"
+	}
+	return "off"
+}
+
+func (s *APIServer) handleFeatures(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	json.NewEncoder(w).Encode(s.Features.All())
+}
@@ -0,0 +1,231 @@
+package main
+
+import (
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+)
+
+var ComputeBudgetProgramID = MustSolanaPubkey("ComputeBudget111111111111111111111111111111")
+
+// ComputeBudget instruction tags
+const (
+	computeBudgetSetUnitLimit = 2
+	computeBudgetSetUnitPrice = 3
+)
+
+const (
+	// most compute units a transaction may ask for
+	maxComputeUnitLimit = 1_400_000
+
+	// accounts getRecentPrioritizationFees takes at once
+	maxFeeAccounts = 128
+
+	// fees at this percentile of recent slots are recommended by default
+	defaultFeePercentile = 75
+)
+
+// PriorityFeePolicy lets the service set the compute unit price on a key's
+// transactions, never above MaxMicroLamports
+type PriorityFeePolicy struct {
+	MaxMicroLamports uint64 `json:"maxMicroLamportsPerCu"`
+
+	// compute unit limit added when the transaction sets none, bounding the
+	// total fee to limit * price. Zero leaves the cluster default.
+	ComputeUnitLimit uint32 `json:"computeUnitLimit,omitempty"`
+}
+
+func (p PriorityFeePolicy) Validate() error {
+	if p.MaxMicroLamports == 0 {
+		return errors.New("priorityFees needs a maxMicroLamportsPerCu")
+	}
+	if p.ComputeUnitLimit > maxComputeUnitLimit {
+		return fmt.Errorf("computeUnitLimit can't exceed %d", maxComputeUnitLimit)
+	}
+	return nil
+}
+
+// PriorityFeeAdvice is what recent slots paid per compute unit, in
+// micro-lamports, for transactions locking the same accounts
+type PriorityFeeAdvice struct {
+	Slots       int    `json:"slots"`
+	Min         uint64 `json:"min"`
+	Median      uint64 `json:"median"`
+	Max         uint64 `json:"max"`
+	Recommended uint64 `json:"recommended"`
+	Percentile  int    `json:"percentile"`
+}
+
+// FeeAdvisor recommends compute unit prices from the cluster's recent
+// prioritization fees
+type FeeAdvisor struct {
+	chain ChainClient
+
+	// of recent slot fees, the recommendation
+	Percentile int
+}
+
+// constructor, a percentile outside 1-100 takes defaultFeePercentile
+func NewFeeAdvisor(chain ChainClient, percentile int) *FeeAdvisor {
+	if percentile < 1 || percentile > 100 {
+		percentile = defaultFeePercentile
+	}
+	return &FeeAdvisor{chain: chain, Percentile: percentile}
+}
+
+// Advise looks at recent fees for transactions writing accounts, none
+// looks at the cluster as a whole
+func (a *FeeAdvisor) Advise(ctx context.Context, accounts []SolanaPubkey) (PriorityFeeAdvice, error) {
+	if len(accounts) > maxFeeAccounts {
+		accounts = accounts[:maxFeeAccounts]
+	}
+	addrs := make([]string, len(accounts))
+	for i, pk := range accounts {
+		addrs[i] = pk.String()
+	}
+	fees, err := a.chain.RecentPrioritizationFees(ctx, addrs)
+	if err != nil {
+		return PriorityFeeAdvice{}, err
+	}
+
+	advice := PriorityFeeAdvice{Slots: len(fees), Percentile: a.Percentile}
+	if len(fees) == 0 {
+		return advice, nil
+	}
+	slices.Sort(fees)
+	at := func(p int) uint64 { return fees[(len(fees)-1)*p/100] }
+	advice.Min, advice.Median, advice.Max = fees[0], at(50), fees[len(fees)-1]
+	advice.Recommended = at(a.Percentile)
+	return advice, nil
+}
+
+// writableAccounts are the message's static writable accounts, the ones
+// whose locks decide the fee a transaction competes on
+func writableAccounts(msg *SolanaMessage) []SolanaPubkey {
+	var accounts []SolanaPubkey
+	for i, pk := range msg.AccountKeys {
+		if msg.IsWritable(i) {
+			accounts = append(accounts, pk)
+		}
+	}
+	return accounts
+}
+
+// SetPriorityFee sets the compute unit price, replacing one the message
+// already has, and adds a compute unit limit when unitLimit is set and the
+// message has none. The message changes, so nobody may have signed it yet.
+func (p *SolanaPayload) SetPriorityFee(microLamports uint64, unitLimit uint32) error {
+	if p.AnySigned() {
+		return fmt.Errorf("%w, its priority fee can't change", errTxAlreadySigned)
+	}
+	msg := p.Message
+
+	program := slices.Index(msg.AccountKeys, ComputeBudgetProgramID)
+	hasLimit, hasPrice := false, false
+	for i, ix := range msg.Instructions {
+		if ix.ProgramIDIndex != program || len(ix.Data) == 0 {
+			continue
+		}
+		switch ix.Data[0] {
+		case computeBudgetSetUnitLimit:
+			hasLimit = true
+		case computeBudgetSetUnitPrice:
+			hasPrice = true
+			msg.Instructions[i].Data = computeUnitPriceData(microLamports)
+		}
+	}
+	if hasPrice && (hasLimit || unitLimit == 0) {
+		p.MsgBytes = msg.Marshal()
+		return nil
+	}
+
+	if program < 0 {
+		var err error
+		if program, err = msg.addReadonlyKey(ComputeBudgetProgramID); err != nil {
+			return fmt.Errorf("%w: no room for the compute budget program", err)
+		}
+	}
+
+	var budget []SolanaCompiledInstruction
+	if unitLimit > 0 && !hasLimit {
+		data := binary.LittleEndian.AppendUint32([]byte{computeBudgetSetUnitLimit}, unitLimit)
+		budget = append(budget, SolanaCompiledInstruction{ProgramIDIndex: program, Data: data})
+	}
+	if !hasPrice {
+		budget = append(budget, SolanaCompiledInstruction{ProgramIDIndex: program, Data: computeUnitPriceData(microLamports)})
+	}
+	// a durable nonce transaction must advance its nonce first
+	at := 0
+	if usesDurableNonce(msg) {
+		at = 1
+	}
+	msg.Instructions = slices.Insert(msg.Instructions, at, budget...)
+
+	p.MsgBytes = msg.Marshal()
+	if size := len(p.UnsignedWire()); size > solanaMaxTxSize {
+		return fmt.Errorf("%w w/ the priority fee: %d > %d bytes", errSolanaTxTooLarge, size, solanaMaxTxSize)
+	}
+	return nil
+}
+
+func computeUnitPriceData(microLamports uint64) []byte {
+	return binary.LittleEndian.AppendUint64([]byte{computeBudgetSetUnitPrice}, microLamports)
+}
+
+// applyPriorityFee prices the transaction at the advised fee, capped by
+// the key's policy, and returns the price set
+func applyPriorityFee(ctx context.Context, fees *FeeAdvisor, tx *SolanaPayload, policy *PriorityFeePolicy) (uint64, error) {
+	if policy == nil {
+		return 0, fmt.Errorf("%w: key's policy doesn't allow setting a priority fee", errPolicyViolation)
+	}
+	if fees == nil {
+		return 0, errNoRPC
+	}
+	advice, err := fees.Advise(ctx, writableAccounts(tx.Message))
+	if err != nil {
+		return 0, fmt.Errorf("priority fee unavailable: %w", err)
+	}
+	price := min(advice.Recommended, policy.MaxMicroLamports)
+	if err := tx.SetPriorityFee(price, policy.ComputeUnitLimit); err != nil {
+		return 0, err
+	}
+	return price, nil
+}
+
+// handlePriorityFees serves fee advice for ?accounts= (comma separated
+// addresses the transaction writes), none advises for the whole cluster
+func (s *APIServer) handlePriorityFees(w http.ResponseWriter, r *http.Request) {
+	if err := s.checkRead(r, ""); err != nil {
+		refuseAPIToken(w, r, err)
+		return
+	}
+
+	var accounts []SolanaPubkey
+	if raw := r.URL.Query().Get("accounts"); raw != "" {
+		for _, addr := range strings.Split(raw, ",") {
+			pk, err := ParseSolanaPubkey(strings.TrimSpace(addr))
+			if err != nil {
+				writeError(w, r, http.StatusBadRequest, err)
+				return
+			}
+			accounts = append(accounts, pk)
+		}
+	}
+	if len(accounts) > maxFeeAccounts {
+		writeError(w, r, http.StatusBadRequest, fmt.Errorf("at most %d accounts", maxFeeAccounts))
+		return
+	}
+
+	advice, err := s.Fees.Advise(r.Context(), accounts)
+	if err != nil {
+		writeError(w, r, http.StatusBadGateway, fmt.Errorf("%w: %v", errRPCUnavailable, err))
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(advice)
+}
@@ -0,0 +1,205 @@
+package main
+
+import (
+	"context"
+	"crypto/ed25519"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math"
+	"net/http"
+	"slices"
+	"time"
+)
+
+const (
+	// base fee the cluster charges per signature
+	lamportsPerSignature = 5000
+
+	// compute units each instruction gets when the transaction sets no limit
+	defaultInstructionUnits = 200_000
+)
+
+var (
+	errNoFeePayer       = errors.New("fee payer not configured")
+	errFeePayerMismatch = errors.New("transaction's fee payer isn't the service's")
+)
+
+// FeePayerInfo is the address feePayer requests must name as fee payer
+type FeePayerInfo struct {
+	Address string `json:"address"`
+}
+
+// TransactionFee is the most the fee payer is charged for the message, the
+// base fee per signature and the priority fee at its compute unit limit
+func TransactionFee(msg *SolanaMessage) uint64 {
+	program := slices.Index(msg.AccountKeys, ComputeBudgetProgramID)
+	var price, limit uint64
+	hasLimit, others := false, uint64(0)
+	for _, ix := range msg.Instructions {
+		if ix.ProgramIDIndex != program {
+			others++
+			continue
+		}
+		switch {
+		case len(ix.Data) == 5 && ix.Data[0] == computeBudgetSetUnitLimit:
+			limit, hasLimit = uint64(binary.LittleEndian.Uint32(ix.Data[1:])), true
+		case len(ix.Data) == 9 && ix.Data[0] == computeBudgetSetUnitPrice:
+			price = binary.LittleEndian.Uint64(ix.Data[1:])
+		}
+	}
+	if !hasLimit {
+		limit = min(others*defaultInstructionUnits, maxComputeUnitLimit)
+	}
+
+	base := lamportsPerSignature * uint64(msg.NumRequiredSignatures)
+	// micro-lamports per unit, rounded up like the cluster does
+	if limit > 0 && price > (math.MaxUint64-999_999)/limit {
+		return math.MaxUint64
+	}
+	return base + (price*limit+999_999)/1_000_000
+}
+
+// SetFeePayer designates the stored key that pays fees for feePayer
+// requests. It must be a persistent ed25519 key w/ a lamport spending limit,
+// which the fees it pays count against.
+func (s *signerService) SetFeePayer(keyID string) (SolanaPubkey, error) {
+	info, err := s.store.Info(keyID)
+	if err != nil {
+		return SolanaPubkey{}, err
+	}
+	if err := checkFeePayerPolicy(info); err != nil {
+		return SolanaPubkey{}, err
+	}
+	address, err := keyAddress(s.store, keyID)
+	if err != nil {
+		return SolanaPubkey{}, err
+	}
+	s.feePayer = keyID
+	return address, nil
+}
+
+// checkFeePayerPolicy refuses a key that can't bound what it pays. Every
+// request's fee is counted against its lamport limit, a canary key never
+// signs.
+func checkFeePayerPolicy(info KeyUsage) error {
+	if info.KeyType != KeyTypeEd25519 {
+		return fmt.Errorf("fee payer must be an ed25519 key, not %s", info.KeyType)
+	}
+	if info.Policy.Usage != UsagePersistent {
+		return fmt.Errorf("fee payer needs the %s usage policy", UsagePersistent)
+	}
+	if info.Policy.Canary {
+		return errors.New("a canary key can't be the fee payer")
+	}
+	if !slices.ContainsFunc(info.Policy.SpendingLimits, func(l SpendingLimit) bool { return l.asset() == assetLamports }) {
+		return errors.New("fee payer needs a lamport spending limit, it bounds the fees it pays")
+	}
+	return nil
+}
+
+// feePayerKey is the fee payer's key info for a request signed by keyID,
+// refused when the fee payer couldn't sign right now
+func (s *signerService) feePayerKey(keyID string) (KeyUsage, error) {
+	if s.feePayer == "" {
+		return KeyUsage{}, errNoFeePayer
+	}
+	if keyID == s.feePayer {
+		return KeyUsage{}, errors.New("the fee payer doesn't sponsor its own transactions")
+	}
+	info, err := s.store.Info(s.feePayer)
+	if err != nil {
+		return KeyUsage{}, fmt.Errorf("%w: %w", errNoFeePayer, err)
+	}
+	if err := s.seal.Check(info.Namespace); err != nil {
+		return KeyUsage{}, err
+	}
+	if info.Frozen != nil {
+		return KeyUsage{}, fmt.Errorf("%w: fee payer: %s", errKeyFrozen, info.Frozen.Reason)
+	}
+	if err := checkFeePayerPolicy(info); err != nil {
+		return KeyUsage{}, err
+	}
+	if err := info.Policy.CheckSchedule(time.Now(), info.CreatedAt); err != nil {
+		return KeyUsage{}, fmt.Errorf("fee payer: %w", err)
+	}
+	return info, nil
+}
+
+// checkFeePayerTx refuses a message that doesn't name the fee payer as its
+// fee payer or uses it in an instruction, where it could be debited. A
+// lookup table can't load it again, the runtime refuses an account twice.
+// A compute unit price, the client's own or the priority fee's, must fit
+// the fee payer's fee policy, w/o one it pays no priority fee at all.
+// It returns the fee the fee payer is charged at most.
+func checkFeePayerTx(msg *SolanaMessage, payer SolanaPubkey, fees *PriorityFeePolicy) (uint64, error) {
+	if len(msg.AccountKeys) == 0 || msg.AccountKeys[0] != payer {
+		return 0, fmt.Errorf("%w, set it to %s", errFeePayerMismatch, payer)
+	}
+	budget := slices.Index(msg.AccountKeys, ComputeBudgetProgramID)
+	for i, ix := range msg.Instructions {
+		if slices.Contains(ix.Accounts, 0) {
+			return 0, fmt.Errorf("%w: instruction %d uses the fee payer, it only pays fees", errPolicyViolation, i)
+		}
+		if ix.ProgramIDIndex != budget || len(ix.Data) != 9 || ix.Data[0] != computeBudgetSetUnitPrice {
+			continue
+		}
+		price := binary.LittleEndian.Uint64(ix.Data[1:])
+		switch {
+		case price > 0 && fees == nil:
+			return 0, fmt.Errorf("%w: instruction %d sets a compute unit price, the fee payer pays no priority fee", errPolicyViolation, i)
+		case fees != nil && price > fees.MaxMicroLamports:
+			return 0, fmt.Errorf("%w: instruction %d sets a compute unit price of %d, the fee payer pays at most %d", errPolicyViolation, i, price, fees.MaxMicroLamports)
+		}
+	}
+	return TransactionFee(msg), nil
+}
+
+// reserveFee counts the fee against the fee payer's spending limits and
+// rate limit, the returned func gives the spend back
+func (s *signerService) reserveFee(payer KeyUsage, fee uint64) (func(), error) {
+	release, err := s.spending.Reserve(s.feePayer, payer.Policy.SpendingLimits, map[string]uint64{assetLamports: fee}, false)
+	if err != nil {
+		return nil, fmt.Errorf("fee payer: %w", err)
+	}
+	if payer.Policy.RateLimit != nil {
+		if err := s.limiter.Take(s.feePayer, *payer.Policy.RateLimit, time.Now()); err != nil {
+			release()
+			return nil, fmt.Errorf("fee payer: %w", err)
+		}
+	}
+	return release, nil
+}
+
+// coSignFeePayer puts the fee payer's signature in its slot, the first, so
+// it is the transaction id
+func (s *signerService) coSignFeePayer(ctx context.Context, tx *SolanaPayload) error {
+	keyType, privKey, _, err := s.store.Acquire(s.feePayer)
+	s.costs.Record(ctx, CostKeystoreRead)
+	if err != nil {
+		return fmt.Errorf("fee payer: %w", err)
+	}
+	defer clear(privKey)
+	if keyType != KeyTypeEd25519 {
+		return fmt.Errorf("fee payer must be an ed25519 key, not %s", keyType)
+	}
+
+	key := ed25519.PrivateKey(privKey)
+	if _, err := tx.WireTransaction(SolanaAddress(key.Public().(ed25519.PublicKey)), ed25519.Sign(key, tx.MsgBytes)); err != nil {
+		return fmt.Errorf("fee payer: %w", err)
+	}
+	slog.InfoContext(ctx, "Fee payer co-signed", logSigner, "fee_payer", s.feePayer)
+	return nil
+}
+
+// handleFeePayer serves the address feePayer requests name as fee payer
+func (s *APIServer) handleFeePayer(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := s.checkRead(r, ""); err != nil {
+		refuseAPIToken(w, r, err)
+		return
+	}
+	json.NewEncoder(w).Encode(FeePayerInfo{Address: s.FeePayer})
+}
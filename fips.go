@@ -0,0 +1,59 @@
+package main
+
+import (
+	"crypto/fips140"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"runtime/debug"
+	"slices"
+)
+
+// key types backed by FIPS 186-5 approved algorithms, secp256k1 isn't one
+var fipsKeyTypes = []string{KeyTypeEd25519, KeyTypeP256}
+
+var errNotFIPSApproved = errors.New("not allowed in FIPS mode")
+
+// FIPS mode comes from the Go crypto module, build w/ GOFIPS140 or run w/
+// GODEBUG=fips140=on to turn it on
+type FIPSStatus struct {
+	Enabled bool `json:"enabled"`
+
+	// GOFIPS140 the binary was built w/, empty for the default module
+	Module string `json:"module,omitempty"`
+
+	ApprovedKeyTypes []string `json:"approvedKeyTypes,omitempty"`
+}
+
+func CurrentFIPSStatus() FIPSStatus {
+	status := FIPSStatus{Enabled: fips140.Enabled()}
+	if info, ok := debug.ReadBuildInfo(); ok {
+		for _, setting := range info.Settings {
+			if setting.Key == "GOFIPS140" {
+				status.Module = setting.Value
+			}
+		}
+	}
+	if status.Enabled {
+		status.ApprovedKeyTypes = fipsKeyTypes
+	}
+	return status
+}
+
+func checkFIPSKeyType(keyType string) error {
+	if !slices.Contains(fipsKeyTypes, keyType) {
+		return fmt.Errorf("%w: %s keys", errNotFIPSApproved, keyType)
+	}
+	return nil
+}
+
+func (s *APIServer) handleFIPSStatus(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := s.checkRead(r, ""); err != nil {
+		refuseAPIToken(w, r, err)
+		return
+	}
+
+	json.NewEncoder(w).Encode(CurrentFIPSStatus())
+}
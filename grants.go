@@ -0,0 +1,137 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// longest a grant may live
+const maxGrantTTL = 24 * time.Hour
+
+var (
+	errGrantInvalid  = errors.New("signing grant is invalid, expired or used up")
+	errGrantRequired = errors.New("key can only sign under a signing grant")
+)
+
+type GrantRequest struct {
+	KeyID      string `json:"keyId"`
+	Uses       int    `json:"uses"`
+	TTLMinutes int    `json:"ttlMinutes"`
+}
+
+// SigningGrant lets a batch job sign Uses times w/ one key until ExpiresAt
+type SigningGrant struct {
+	// bearer secret, only returned when the grant is minted
+	Token string `json:"token,omitempty"`
+
+	ID        string    `json:"id"`
+	KeyID     string    `json:"keyId"`
+	Uses      int       `json:"uses"`
+	Used      int       `json:"used"`
+	ExpiresAt time.Time `json:"expiresAt"`
+	CreatedBy string    `json:"createdBy"`
+}
+
+// GrantStore tracks grants by the SHA-256 of their token, so lookup time
+// says nothing about the token itself
+type GrantStore struct {
+	grants map[[sha256.Size]byte]*SigningGrant
+
+	mu sync.Mutex
+}
+
+// constructor
+func NewGrantStore() *GrantStore {
+	return &GrantStore{grants: make(map[[sha256.Size]byte]*SigningGrant)}
+}
+
+func (g *GrantStore) Mint(req GrantRequest, createdBy string) (SigningGrant, error) {
+	if req.KeyID == "" || req.Uses <= 0 {
+		return SigningGrant{}, errors.New("keyId and a positive uses are required")
+	}
+	ttl := time.Duration(req.TTLMinutes) * time.Minute
+	if ttl <= 0 || ttl > maxGrantTTL {
+		return SigningGrant{}, fmt.Errorf("ttlMinutes must be between 1 and %d", int(maxGrantTTL.Minutes()))
+	}
+
+	var secret [32]byte
+	if _, err := rand.Read(secret[:]); err != nil {
+		return SigningGrant{}, fmt.Errorf("failed to create grant: %w", err)
+	}
+	token := hex.EncodeToString(secret[:])
+
+	grant := &SigningGrant{
+		// the id is safe to log, the token is not
+		ID:        token[:16],
+		KeyID:     req.KeyID,
+		Uses:      req.Uses,
+		ExpiresAt: time.Now().Add(ttl),
+		CreatedBy: createdBy,
+	}
+
+	g.mu.Lock()
+
+	defer g.mu.Unlock()
+
+	g.prune(time.Now())
+	g.grants[sha256.Sum256([]byte(token))] = grant
+
+	audit(context.Background(), logAdmin, "Grant minted", "operator", createdBy, "grant_id", grant.ID, "key_id", req.KeyID, "uses", req.Uses, "expires", grant.ExpiresAt)
+	out := *grant
+	out.Token = token
+	return out, nil
+}
+
+// Consume takes one use of the grant for keyID. release gives it back if
+// no signature comes out.
+func (g *GrantStore) Consume(token, keyID string) (release func(), err error) {
+	g.mu.Lock()
+
+	defer g.mu.Unlock()
+
+	grant, ok := g.grants[sha256.Sum256([]byte(token))]
+	if !ok || grant.KeyID != keyID || grant.Used >= grant.Uses || !time.Now().Before(grant.ExpiresAt) {
+		return nil, errGrantInvalid
+	}
+	grant.Used++
+
+	release = func() {
+		g.mu.Lock()
+
+		defer g.mu.Unlock()
+
+		grant.Used--
+	}
+	return release, nil
+}
+
+// Revoke ends a grant early by id
+func (g *GrantStore) Revoke(id, by string) error {
+	g.mu.Lock()
+
+	defer g.mu.Unlock()
+
+	for digest, grant := range g.grants {
+		if grant.ID == id {
+			delete(g.grants, digest)
+			audit(context.Background(), logAdmin, "Grant revoked", "operator", by, "grant_id", id)
+			return nil
+		}
+	}
+	return errGrantInvalid
+}
+
+// prune drops expired grants, must be called w/ mu held
+func (g *GrantStore) prune(now time.Time) {
+	for digest, grant := range g.grants {
+		if !now.Before(grant.ExpiresAt) {
+			delete(g.grants, digest)
+		}
+	}
+}
@@ -0,0 +1,447 @@
+package main
+
+//go:generate protoc --go_out=. --go_opt=paths=source_relative --go-grpc_out=. --go-grpc_opt=paths=source_relative signerpb/signer.proto
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"log/slog"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/yourusername/sts-svc/signerpb"
+	"go.opentelemetry.io/contrib/instrumentation/google.golang.org/grpc/otelgrpc"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+)
+
+// grpcSigner serves the SignerService over gRPC, calls pass the same
+// middleware and checks as the HTTP API
+type grpcSigner struct {
+	signerpb.UnimplementedSignerServer
+
+	api *APIServer
+}
+
+// NewGRPCServer builds the gRPC server, w/ the HTTP API's TLS config when set
+func (s *APIServer) NewGRPCServer() *grpc.Server {
+	opts := []grpc.ServerOption{
+		grpc.UnaryInterceptor(s.grpcUnary),
+		grpc.StreamInterceptor(s.grpcStream),
+		// a server span per call, continuing the caller's trace
+		grpc.StatsHandler(otelgrpc.NewServerHandler()),
+	}
+	if s.TLS != nil {
+		opts = append(opts, grpc.Creds(credentials.NewTLS(s.TLS)))
+	}
+
+	server := grpc.NewServer(opts...)
+	signerpb.RegisterSignerServer(server, &grpcSigner{api: s})
+	return server
+}
+
+// grpcRequest mirrors a call as an HTTP request, metadata becomes headers
+func grpcRequest(ctx context.Context, method string) *http.Request {
+	r, _ := http.NewRequestWithContext(ctx, http.MethodPost, method, http.NoBody)
+
+	md, _ := metadata.FromIncomingContext(ctx)
+	for k, vs := range md {
+		for _, v := range vs {
+			r.Header.Add(k, v)
+		}
+	}
+	if p, ok := peer.FromContext(ctx); ok {
+		r.RemoteAddr = p.Addr.String()
+		if info, ok := p.AuthInfo.(credentials.TLSInfo); ok {
+			r.TLS = &info.State
+		}
+	}
+	return r
+}
+
+// grpcUnary runs the HTTP middleware over the call, so request IDs, rate
+// limits, HMAC, tenants, principals and operators work the same on both APIs
+func (s *APIServer) grpcUnary(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+	admitted, err := s.grpcAdmit(ctx, info.FullMethod)
+	if err != nil {
+		return nil, err
+	}
+	return handler(admitted, req)
+}
+
+// grpcStream admits a stream once when it opens
+func (s *APIServer) grpcStream(srv any, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	admitted, err := s.grpcAdmit(ss.Context(), info.FullMethod)
+	if err != nil {
+		return err
+	}
+	return handler(srv, &admittedStream{ServerStream: ss, ctx: admitted})
+}
+
+// grpcAdmit returns the context the middleware tagged, or its refusal
+func (s *APIServer) grpcAdmit(ctx context.Context, method string) (context.Context, error) {
+	var admitted *http.Request
+	rec := &grpcRecorder{header: http.Header{}, status: http.StatusOK}
+	s.middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		admitted = r
+	})).ServeHTTP(rec, grpcRequest(ctx, method))
+
+	if id := rec.header.Get("X-Request-ID"); id != "" {
+		grpc.SetHeader(ctx, metadata.Pairs("x-request-id", id))
+	}
+	if admitted == nil {
+		return nil, rec.err()
+	}
+	return admitted.Context(), nil
+}
+
+type admittedStream struct {
+	grpc.ServerStream
+
+	ctx context.Context
+}
+
+func (a *admittedStream) Context() context.Context { return a.ctx }
+
+// grpcRecorder collects what the middleware wrote when it refused a call
+type grpcRecorder struct {
+	header http.Header
+	status int
+	body   bytes.Buffer
+}
+
+func (g *grpcRecorder) Header() http.Header         { return g.header }
+func (g *grpcRecorder) Write(b []byte) (int, error) { return g.body.Write(b) }
+func (g *grpcRecorder) WriteHeader(status int)      { g.status = status }
+
+func (g *grpcRecorder) err() error {
+	var res ErrorResponse
+	if err := json.Unmarshal(g.body.Bytes(), &res); err != nil {
+		res = ErrorResponse{Code: errorCode(nil, g.status), Message: http.StatusText(g.status)}
+	}
+	return grpcStatus(g.status, res)
+}
+
+// grpcCodes maps the HTTP API's statuses to gRPC codes
+var grpcCodes = map[int]codes.Code{
+	http.StatusBadRequest:          codes.InvalidArgument,
+	http.StatusUnauthorized:        codes.Unauthenticated,
+	http.StatusForbidden:           codes.PermissionDenied,
+	http.StatusNotFound:            codes.NotFound,
+	http.StatusConflict:            codes.Aborted,
+	http.StatusUnprocessableEntity: codes.FailedPrecondition,
+	http.StatusLocked:              codes.FailedPrecondition,
+	http.StatusTooManyRequests:     codes.ResourceExhausted,
+	http.StatusBadGateway:          codes.Unavailable,
+	http.StatusServiceUnavailable:  codes.Unavailable,
+	http.StatusGatewayTimeout:      codes.DeadlineExceeded,
+}
+
+// grpcError is writeError for gRPC, the stable code travels as ErrorInfo
+func grpcError(ctx context.Context, httpStatus int, err error) error {
+	return grpcStatus(httpStatus, ErrorResponse{
+		Code:      errorCode(err, httpStatus),
+		Message:   err.Error(),
+		RequestID: RequestIDFromContext(ctx),
+	})
+}
+
+func grpcStatus(httpStatus int, res ErrorResponse) error {
+	code, ok := grpcCodes[httpStatus]
+	if !ok {
+		code = codes.Internal
+	}
+
+	info := &errdetails.ErrorInfo{Reason: res.Code, Domain: "sts-svc", Metadata: res.Details}
+	if res.RequestID != "" {
+		if info.Metadata == nil {
+			info.Metadata = map[string]string{}
+		}
+		info.Metadata["request_id"] = res.RequestID
+	}
+	st, err := status.New(code, res.Message).WithDetails(info)
+	if err != nil {
+		return status.Error(code, res.Message)
+	}
+	return st.Err()
+}
+
+func (g *grpcSigner) GenerateKey(ctx context.Context, in *signerpb.GenerateKeyRequest) (*signerpb.Account, error) {
+	req := KeyGenRequest{KeyType: in.KeyType, Namespace: in.Namespace}
+	if len(in.PolicyJson) > 0 {
+		req.Policy = &KeyPolicy{}
+		// unknown and mistyped fields are refused as over HTTP
+		dec := json.NewDecoder(bytes.NewReader(in.PolicyJson))
+		dec.DisallowUnknownFields()
+		if err := dec.Decode(req.Policy); err != nil {
+			return nil, grpcError(ctx, http.StatusBadRequest, invalidBody(fieldError(err)))
+		}
+	}
+
+	r := grpcRequest(ctx, "")
+	if err := g.api.checkAPIToken(r, TokenOpGenerate, ""); err != nil {
+		return nil, grpcError(ctx, apiTokenErrorStatus(err), err)
+	}
+	// the message has no field for it, metadata carries it as it does over HTTP
+	req.IdempotencyKey = r.Header.Get("Idempotency-Key")
+
+	acc, err := g.api.Service.GenerateKey(ctx, req)
+	if err != nil {
+		return nil, grpcError(ctx, genKeyErrorStatus(err), err)
+	}
+
+	policy, _ := json.Marshal(acc.Policy)
+	out := &signerpb.Account{PublicKey: acc.PublicKey, KeyType: acc.KeyType, Namespace: acc.Namespace, PolicyJson: policy}
+	if att := acc.Attestation; att != nil {
+		statement, _ := json.Marshal(att.Statement)
+		out.Attestation = &signerpb.Attestation{StatementJson: statement, Signature: att.Signature, SignerKey: att.SignerKey}
+	}
+	return out, nil
+}
+
+func (g *grpcSigner) SignTransaction(ctx context.Context, in *signerpb.SignRequest) (*signerpb.SignResponse, error) {
+	req := TransactionRequest{
+		KeyID:          in.KeyId,
+		UnsignedTxData: in.UnsignedTxData,
+		SigningMode:    in.SigningMode,
+		Context:        in.Context,
+		IdempotencyKey: in.IdempotencyKey,
+		Broadcast:      in.Broadcast,
+		Simulate:       in.Simulate,
+		Grant:          in.Grant,
+		Nonce:          in.Nonce,
+		Timestamp:      in.Timestamp,
+
+		Queue:            in.Queue,
+		NotifyCommitment: in.NotifyCommitment,
+		Cluster:          in.Cluster,
+		RefreshBlockhash: in.RefreshBlockhash,
+		NonceAccount:     in.NonceAccount,
+		PriorityFee:      in.PriorityFee,
+		FeePayer:         in.FeePayer,
+	}
+
+	if httpStatus, err := g.api.authorizeSign(grpcRequest(ctx, ""), req); err != nil {
+		return nil, grpcError(ctx, httpStatus, err)
+	}
+
+	res, err := g.api.signWithTimeout(ctx, req)
+	if err != nil {
+		httpStatus := signErrorStatus(err)
+		if errors.Is(err, errSignTimeout) {
+			httpStatus = http.StatusGatewayTimeout
+		}
+		return nil, grpcError(ctx, httpStatus, err)
+	}
+
+	return &signerpb.SignResponse{
+		KeyId:           res.KeyID,
+		Signature:       res.Signature,
+		SigningMode:     res.SigningMode,
+		Context:         res.Context,
+		BroadcastStatus: res.BroadcastStatus,
+		KeyDestroyed:    res.KeyDestroyed,
+		Transaction:     res.Transaction,
+		TxSignature:     res.TxSignature,
+		Slot:            res.Slot,
+		SimulationLogs:  res.SimulationLogs,
+		ApprovalId:      res.ApprovalID,
+
+		Cluster:                  res.Cluster,
+		Blockhash:                res.Blockhash,
+		LastValidBlockHeight:     res.LastValidBlockHeight,
+		PriorityFeeMicroLamports: res.PriorityFeeMicroLamports,
+		QueueId:                  res.QueueID,
+	}, nil
+}
+
+// signs in flight per stream, further requests wait to be read
+const maxStreamInFlight = 32
+
+// SignStream signs requests as they arrive and answers each as it completes.
+// Every request counts against the caller's rate limit like an HTTP request.
+func (g *grpcSigner) SignStream(stream grpc.BidiStreamingServer[signerpb.SignStreamRequest, signerpb.SignStreamResponse]) error {
+	ctx := stream.Context()
+
+	notices, done := g.api.Drainer.Register()
+	defer done()
+
+	var sendMu sync.Mutex
+	send := func(res *signerpb.SignStreamResponse) {
+		sendMu.Lock()
+
+		defer sendMu.Unlock()
+
+		if err := stream.Send(res); err != nil {
+			slog.WarnContext(ctx, "Sign stream send failed", logServer, "ref", res.Ref, "err", err)
+		}
+	}
+
+	// in flight signs finish and are answered before the stream closes
+	var wg sync.WaitGroup
+	defer wg.Wait()
+	inFlight := make(chan struct{}, maxStreamInFlight)
+
+	requests := make(chan *signerpb.SignStreamRequest)
+	recvErr := make(chan error, 1)
+	go func() {
+		for {
+			in, err := stream.Recv()
+			if err != nil {
+				recvErr <- err
+				return
+			}
+			select {
+			case requests <- in:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	client := g.api.requestClient(grpcRequest(ctx, ""))
+	for {
+		select {
+		case notice := <-notices:
+			send(&signerpb.SignStreamResponse{Result: &signerpb.SignStreamResponse_GoAway{GoAway: &signerpb.GoAway{
+				Reason:       notice.Reason,
+				ReconnectTo:  notice.ReconnectTo,
+				RetryAfterMs: notice.RetryAfterMs,
+			}}})
+			return nil
+
+		case err := <-recvErr:
+			if errors.Is(err, io.EOF) {
+				return nil
+			}
+			return err
+
+		case in := <-requests:
+			if in.Request == nil {
+				send(&signerpb.SignStreamResponse{Ref: in.Ref, Result: streamError(grpcError(ctx, http.StatusBadRequest, errors.New("request cannot be empty")))})
+				continue
+			}
+			if g.api.Limiter != nil && g.api.Limiter.Allow(client, time.Now()) > 0 {
+				send(&signerpb.SignStreamResponse{Ref: in.Ref, Result: streamError(grpcError(ctx, http.StatusTooManyRequests, errRequestRateLimited))})
+				continue
+			}
+
+			select {
+			case inFlight <- struct{}{}:
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				defer func() { <-inFlight }()
+
+				res := &signerpb.SignStreamResponse{Ref: in.Ref}
+				if signed, err := g.SignTransaction(ctx, in.Request); err != nil {
+					res.Result = streamError(err)
+				} else {
+					res.Result = &signerpb.SignStreamResponse_Signed{Signed: signed}
+				}
+				send(res)
+			}()
+		}
+	}
+}
+
+// streamError carries a gRPC error inside a stream response
+func streamError(err error) *signerpb.SignStreamResponse_Error {
+	st := status.Convert(err)
+	out := &signerpb.StreamError{Status: int32(st.Code()), Message: st.Message()}
+	for _, d := range st.Details() {
+		if info, ok := d.(*errdetails.ErrorInfo); ok {
+			out.Code = info.Reason
+		}
+	}
+	return &signerpb.SignStreamResponse_Error{Error: out}
+}
+
+func (g *grpcSigner) VerifySignature(ctx context.Context, in *signerpb.VerifyRequest) (*signerpb.VerifyResponse, error) {
+	if err := g.api.checkAPIToken(grpcRequest(ctx, ""), TokenOpVerify, ""); err != nil {
+		return nil, grpcError(ctx, apiTokenErrorStatus(err), err)
+	}
+
+	res, err := g.api.Service.VerifySignature(ctx, VerifyRequest{
+		KeyType:     in.KeyType,
+		PublicKey:   in.PublicKey,
+		Message:     in.Message,
+		Signature:   in.Signature,
+		SigningMode: in.SigningMode,
+		Context:     in.Context,
+	})
+	if err != nil {
+		return nil, grpcError(ctx, http.StatusBadRequest, err)
+	}
+	return &signerpb.VerifyResponse{KeyType: res.KeyType, Valid: res.Valid}, nil
+}
+
+// keyAdmin mirrors the HTTP key management routes, mounted w/ a store and operators
+func (g *grpcSigner) keyAdmin(ctx context.Context) error {
+	if g.api.Store == nil || !g.api.adminEnabled() {
+		return status.Error(codes.Unimplemented, "key management is not enabled")
+	}
+	if OperatorFromContext(ctx).Name == "" {
+		audit(ctx, logAdmin, "Unauthenticated gRPC key management", "remote", grpcRequest(ctx, "").RemoteAddr)
+		return grpcError(ctx, http.StatusUnauthorized, errOperatorRequired)
+	}
+	return nil
+}
+
+func (g *grpcSigner) FreezeKey(ctx context.Context, in *signerpb.FreezeKeyRequest) (*signerpb.KeyStatus, error) {
+	if err := g.keyAdmin(ctx); err != nil {
+		return nil, err
+	}
+	if in.Reason == "" {
+		return nil, grpcError(ctx, http.StatusBadRequest, errors.New("reason cannot be empty"))
+	}
+
+	info, err := g.api.Store.Freeze(ctx, in.KeyId, in.Reason, OperatorFromContext(ctx).Name)
+	if err != nil {
+		return nil, grpcError(ctx, http.StatusNotFound, err)
+	}
+	return keyStatus(info), nil
+}
+
+func (g *grpcSigner) UnfreezeKey(ctx context.Context, in *signerpb.UnfreezeKeyRequest) (*signerpb.KeyStatus, error) {
+	if err := g.keyAdmin(ctx); err != nil {
+		return nil, err
+	}
+
+	info, err := g.api.Store.Unfreeze(ctx, in.KeyId, OperatorFromContext(ctx).Name)
+	if err != nil {
+		return nil, grpcError(ctx, http.StatusNotFound, err)
+	}
+	return keyStatus(info), nil
+}
+
+func keyStatus(info KeyUsage) *signerpb.KeyStatus {
+	out := &signerpb.KeyStatus{
+		KeyId:         info.KeyID,
+		KeyType:       info.KeyType,
+		Namespace:     info.Namespace,
+		Uses:          int64(info.Uses),
+		CreatedAtUnix: info.CreatedAt.Unix(),
+	}
+	if !info.LastUsedAt.IsZero() {
+		out.LastUsedAtUnix = info.LastUsedAt.Unix()
+	}
+	if f := info.Frozen; f != nil {
+		out.Frozen = true
+		out.FrozenReason = f.Reason
+		out.FrozenBy = f.FrozenBy
+	}
+	return out
+}
@@ -0,0 +1,298 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// names the fds a parent handed down, in order from fd 3
+const inheritFDsEnv = "STS_INHERIT_FDS"
+
+// names of handed off sockets, systemd units can use them as FileDescriptorName
+const (
+	socketAPI   = "api"
+	socketUnix  = "socket"
+	socketGRPC  = "grpc"
+	socketQUIC  = "quic"
+	socketDiag  = "diag"
+	socketReady = "ready"
+)
+
+// how long a new process gets to start serving before the handoff is abandoned
+const handoffTimeout = 30 * time.Second
+
+// socketSet opens the process's listening sockets, taking over the ones a
+// previous process or systemd handed down, and keeps them so they can be
+// handed to the next process. A nil *socketSet just opens new ones.
+type socketSet struct {
+	inherited map[string]*os.File
+	reusePort bool
+
+	// in the order they were opened
+	names []string
+	open  []io.Closer
+
+	// written once this process serves, the parent drains when it is
+	ready *os.File
+
+	mu sync.Mutex
+}
+
+// inheritedSockets takes the fds passed by a parent (STS_INHERIT_FDS) or by
+// systemd socket activation (LISTEN_FDS), and clears the env vars so they
+// aren't passed on. Unnamed systemd sockets are named by type.
+func inheritedSockets(reusePort bool) *socketSet {
+	ss := &socketSet{inherited: map[string]*os.File{}, reusePort: reusePort}
+	names := inheritedNames(os.Getenv, os.Getpid())
+	for _, env := range []string{inheritFDsEnv, "LISTEN_FDS", "LISTEN_PID", "LISTEN_FDNAMES"} {
+		os.Unsetenv(env)
+	}
+	for i, name := range names {
+		f := os.NewFile(uintptr(3+i), name)
+		if name == socketReady {
+			ss.ready = f
+			continue
+		}
+		if name == "" || name == "unknown" {
+			name = socketAPI
+			if lis, err := net.FileListener(f); err == nil {
+				if lis.Addr().Network() == "unix" {
+					name = socketUnix
+				}
+				lis.Close()
+			}
+		}
+		ss.inherited[name] = f
+	}
+	return ss
+}
+
+// inheritedNames reads the names of the fds handed to process pid, systemd's
+// are only for the process they name
+func inheritedNames(getenv func(string) string, pid int) []string {
+	if raw := getenv(inheritFDsEnv); raw != "" {
+		return strings.Split(raw, ",")
+	}
+	n, err := strconv.Atoi(getenv("LISTEN_FDS"))
+	if err != nil || n <= 0 || getenv("LISTEN_PID") != strconv.Itoa(pid) {
+		return nil
+	}
+	names := make([]string, n)
+	copy(names, strings.Split(getenv("LISTEN_FDNAMES"), ":"))
+	return names
+}
+
+// listen returns the inherited listener called name, or listens on addr
+func (ss *socketSet) listen(name, network, addr string) (net.Listener, error) {
+	if ss == nil {
+		return net.Listen(network, addr)
+	}
+	ss.mu.Lock()
+
+	defer ss.mu.Unlock()
+
+	var lis net.Listener
+	var err error
+	if f, ok := ss.inherited[name]; ok {
+		delete(ss.inherited, name)
+		lis, err = net.FileListener(f)
+		f.Close()
+		if err != nil {
+			return nil, fmt.Errorf("inherited %s socket: %w", name, err)
+		}
+		slog.Info("Took over an inherited listener", logServer, "socket", name, "addr", lis.Addr().String())
+	} else {
+		lis, err = ss.listenConfig(network).Listen(context.Background(), network, addr)
+		if err != nil {
+			return nil, err
+		}
+	}
+	ss.names, ss.open = append(ss.names, name), append(ss.open, lis)
+	return lis, nil
+}
+
+// listenPacket is listen for UDP
+func (ss *socketSet) listenPacket(name, addr string) (net.PacketConn, error) {
+	if ss == nil {
+		return net.ListenPacket("udp", addr)
+	}
+	ss.mu.Lock()
+
+	defer ss.mu.Unlock()
+
+	var conn net.PacketConn
+	var err error
+	if f, ok := ss.inherited[name]; ok {
+		delete(ss.inherited, name)
+		conn, err = net.FilePacketConn(f)
+		f.Close()
+		if err != nil {
+			return nil, fmt.Errorf("inherited %s socket: %w", name, err)
+		}
+		slog.Info("Took over an inherited socket", logServer, "socket", name, "addr", conn.LocalAddr().String())
+	} else {
+		conn, err = ss.listenConfig("udp").ListenPacket(context.Background(), "udp", addr)
+		if err != nil {
+			return nil, err
+		}
+	}
+	ss.names, ss.open = append(ss.names, name), append(ss.open, conn)
+	return conn, nil
+}
+
+func (ss *socketSet) listenConfig(network string) *net.ListenConfig {
+	lc := &net.ListenConfig{}
+	if ss.reusePort && network != "unix" {
+		lc.Control = reusePort
+	}
+	return lc
+}
+
+// listenUnix is listen for the Unix socket, a new one gets mode
+func (ss *socketSet) listenUnix(path string, mode os.FileMode) (net.Listener, error) {
+	if ss == nil {
+		return listenUnix(path, mode)
+	}
+	ss.mu.Lock()
+	_, ok := ss.inherited[socketUnix]
+	ss.mu.Unlock()
+	if ok {
+		return ss.listen(socketUnix, "unix", path)
+	}
+
+	lis, err := listenUnix(path, mode)
+	if err != nil {
+		return nil, err
+	}
+	ss.mu.Lock()
+	ss.names, ss.open = append(ss.names, socketUnix), append(ss.open, lis)
+	ss.mu.Unlock()
+	return lis, nil
+}
+
+// serving tells the parent this process has taken over and closes inherited
+// sockets nothing asked for, e.g. a listener turned off in the new config
+func (ss *socketSet) serving() {
+	if ss == nil {
+		return
+	}
+	ss.mu.Lock()
+
+	defer ss.mu.Unlock()
+
+	for name, f := range ss.inherited {
+		slog.Info("Closing an inherited socket, nothing listens on it", logServer, "socket", name)
+		f.Close()
+	}
+	ss.inherited = map[string]*os.File{}
+	if ss.ready != nil {
+		ss.ready.Write([]byte{1})
+		ss.ready.Close()
+		ss.ready = nil
+	}
+}
+
+// handoff starts a new process of the same binary w/ the same arguments on
+// these sockets and waits for it to serve. On success the caller drains and
+// exits, on failure it keeps serving.
+func (ss *socketSet) handoff() (int, error) {
+	ss.mu.Lock()
+
+	defer ss.mu.Unlock()
+
+	var files []*os.File
+	defer func() {
+		for _, f := range files {
+			f.Close()
+		}
+	}()
+	for i, sock := range ss.open {
+		filer, ok := sock.(interface{ File() (*os.File, error) })
+		if !ok {
+			return 0, fmt.Errorf("the %s socket can't be handed off", ss.names[i])
+		}
+		f, err := filer.File()
+		if err != nil {
+			return 0, fmt.Errorf("%s socket: %w", ss.names[i], err)
+		}
+		files = append(files, f)
+	}
+
+	ready, readyW, err := os.Pipe()
+	if err != nil {
+		return 0, err
+	}
+	defer ready.Close()
+	files = append(files, readyW)
+
+	exe, err := os.Executable()
+	if err != nil {
+		return 0, err
+	}
+	cmd := exec.Command(exe, os.Args[1:]...)
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = os.Stdin, os.Stdout, os.Stderr
+	cmd.Env = append(os.Environ(), inheritFDsEnv+"="+strings.Join(append(ss.names, socketReady), ","))
+	cmd.ExtraFiles = files
+	if err := cmd.Start(); err != nil {
+		return 0, fmt.Errorf("failed to start the new process: %w", err)
+	}
+	readyW.Close()
+	exited := make(chan error, 1)
+	go func() { exited <- cmd.Wait() }()
+
+	// the pipe closes w/o a byte if the new process exits first
+	served := make(chan error, 1)
+	go func() {
+		_, err := ready.Read(make([]byte, 1))
+		served <- err
+	}()
+	select {
+	case err := <-served:
+		if err != nil {
+			return 0, fmt.Errorf("new process exited before serving: %v", <-exited)
+		}
+	case <-time.After(handoffTimeout):
+		cmd.Process.Kill()
+		return 0, fmt.Errorf("new process didn't serve w/in %s", handoffTimeout)
+	}
+
+	// the socket file belongs to the new process now
+	for _, sock := range ss.open {
+		if lis, ok := sock.(*net.UnixListener); ok {
+			lis.SetUnlinkOnClose(false)
+		}
+	}
+	return cmd.Process.Pid, nil
+}
+
+// watchUpgrades hands the sockets to a new process on every signal until one
+// takes over, then calls drain
+func (s *APIServer) watchUpgrades(ctx context.Context, upgrades <-chan os.Signal, drain func()) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-upgrades:
+		}
+		if s.Config.orDefaults().Backend == BackendMemory {
+			slog.Warn("Handing off w/ the memory backend, the new process starts w/o the keys held here", logServer)
+		}
+		pid, err := s.sockets.handoff()
+		if err != nil {
+			slog.Error("Handoff failed, still serving", logServer, "err", err)
+			continue
+		}
+		slog.Info("New process took over the listeners, draining", logServer, "pid", pid)
+		drain()
+		return
+	}
+}
@@ -0,0 +1,18 @@
+//go:build !unix || solaris
+
+package main
+
+import (
+	"errors"
+	"os"
+	"syscall"
+)
+
+// no upgrade signal on this platform
+func upgradeSignals() []os.Signal {
+	return nil
+}
+
+func reusePort(network, address string, c syscall.RawConn) error {
+	return errors.New("SO_REUSEPORT isn't supported on this platform")
+}
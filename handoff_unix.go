@@ -0,0 +1,28 @@
+//go:build unix && !solaris
+
+package main
+
+import (
+	"os"
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+// SIGUSR2 hands the listeners to a new process
+func upgradeSignals() []os.Signal {
+	return []os.Signal{syscall.SIGUSR2}
+}
+
+// reusePort sets SO_REUSEPORT before the socket binds, so several processes
+// can listen on the same address and the kernel spreads connections over them
+func reusePort(network, address string, c syscall.RawConn) error {
+	var sockErr error
+	err := c.Control(func(fd uintptr) {
+		sockErr = unix.SetsockoptInt(int(fd), unix.SOL_SOCKET, unix.SO_REUSEPORT, 1)
+	})
+	if err != nil {
+		return err
+	}
+	return sockErr
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// how long /readyz waits on all checks together
+const readinessTimeout = 2 * time.Second
+
+var startedAt = time.Now()
+
+// ReadinessCheck is one dependency /readyz reports on
+type ReadinessCheck struct {
+	Name  string
+	Check func(ctx context.Context) error
+
+	// optional dependencies are reported, a failure only degrades readiness
+	Optional bool
+}
+
+type CheckResult struct {
+	Name      string `json:"name"`
+	Status    string `json:"status"`
+	Error     string `json:"error,omitempty"`
+	LatencyMs int64  `json:"latencyMs"`
+}
+
+// HealthReport is the body of /healthz and /readyz
+type HealthReport struct {
+	// ok, degraded or unavailable
+	Status  string        `json:"status"`
+	Version string        `json:"version"`
+	Uptime  string        `json:"uptime"`
+	Checks  []CheckResult `json:"checks,omitempty"`
+}
+
+// SealCheck fails while the whole service is sealed, sealed namespaces
+// leave the rest servable
+func SealCheck(seal *SealState) ReadinessCheck {
+	return ReadinessCheck{Name: "seal", Check: func(ctx context.Context) error {
+		if status := seal.Status(); status.Sealed {
+			return fmt.Errorf("%w: %s", errSealed, status.Service.Reason)
+		}
+		return nil
+	}}
+}
+
+// StoreCheck fails when the key store can't be reached in time
+func StoreCheck(store *SecureKeyStore) ReadinessCheck {
+	return ReadinessCheck{Name: "keystore", Check: store.Ping}
+}
+
+// RPCCheck reports the Solana RPC, signing works w/o it so it's optional
+func RPCCheck(rpc ChainClient) ReadinessCheck {
+	return ReadinessCheck{Name: "solana-rpc", Check: rpc.Health, Optional: true}
+}
+
+// probes serves the probe and metrics routes ahead of the middleware,
+// kubelets and scrapers don't sign their requests and must not be rate limited
+func (s *APIServer) probes(next http.Handler) http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /healthz", s.handleHealthz)
+	mux.HandleFunc("GET /livez", s.handleHealthz)
+	mux.HandleFunc("GET /readyz", s.handleReadyz)
+	if s.Metrics != nil {
+		mux.Handle("GET /metrics", s.Metrics.Handler())
+	}
+	mux.Handle("/", next)
+	return mux
+}
+
+// handleHealthz is liveness, the process is up and serving
+func (s *APIServer) handleHealthz(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	json.NewEncoder(w).Encode(HealthReport{Status: "ok", Version: version, Uptime: time.Since(startedAt).Round(time.Second).String()})
+}
+
+// handleReadyz runs the readiness checks, 503 when a required one fails or
+// the server is draining
+func (s *APIServer) handleReadyz(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	report := s.Ready(r.Context())
+	if report.Status == "unavailable" {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	json.NewEncoder(w).Encode(report)
+}
+
+// Ready runs the readiness checks concurrently
+func (s *APIServer) Ready(ctx context.Context) HealthReport {
+	ctx, cancel := context.WithTimeout(ctx, readinessTimeout)
+	defer cancel()
+
+	results := make([]CheckResult, len(s.Readiness))
+	var wg sync.WaitGroup
+	for i, c := range s.Readiness {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			start := time.Now()
+			results[i] = CheckResult{Name: c.Name, Status: "ok"}
+			if err := c.Check(ctx); err != nil {
+				results[i].Status = "fail"
+				results[i].Error = err.Error()
+			}
+			results[i].LatencyMs = time.Since(start).Milliseconds()
+		}()
+	}
+	wg.Wait()
+
+	report := HealthReport{Status: "ok", Version: version, Uptime: time.Since(startedAt).Round(time.Second).String(), Checks: results}
+	for i, res := range results {
+		if res.Status == "ok" {
+			continue
+		}
+		if !s.Readiness[i].Optional {
+			report.Status = "unavailable"
+		} else if report.Status == "ok" {
+			report.Status = "degraded"
+		}
+	}
+	// a draining replica stops taking traffic before its streams close
+	if s.Drainer.Draining() {
+		report.Status = "unavailable"
+		report.Checks = append(report.Checks, CheckResult{Name: "drain", Status: "fail", Error: "server is draining"})
+	}
+	return report
+}
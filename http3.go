@@ -0,0 +1,39 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+
+	"github.com/quic-go/quic-go/http3"
+)
+
+// quicServer serves handler over HTTP/3 on s.QUICAddr. QUIC always runs
+// TLS, so it takes the same certificates and client auth as the TCP listener.
+func (s *APIServer) quicServer(handler http.Handler, cfg Config) (*http3.Server, net.PacketConn, error) {
+	if s.TLS == nil {
+		return nil, nil, errors.New("HTTP/3 needs TLS, set STS_TLS_CERT and STS_TLS_KEY")
+	}
+	conn, err := s.sockets.listenPacket(socketQUIC, s.QUICAddr)
+	if err != nil {
+		return nil, nil, fmt.Errorf("QUIC listen on %s: %w", s.QUICAddr, err)
+	}
+	return &http3.Server{
+		Addr:        s.QUICAddr,
+		Handler:     handler,
+		TLSConfig:   http3.ConfigureTLSConfig(s.TLS),
+		IdleTimeout: cfg.IdleTimeout,
+	}, conn, nil
+}
+
+// withAltSvc advertises the QUIC listener on TLS responses so clients that
+// speak HTTP/3 move over
+func withAltSvc(quic *http3.Server, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.TLS != nil && r.ProtoMajor < 3 {
+			quic.SetQUICHeaders(w.Header())
+		}
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"sync"
+	"time"
+)
+
+var errIdempotencyConflict = errors.New("idempotency key was already used w/ a different request")
+
+type idempotencyEntry[T any] struct {
+	result  T
+	expires time.Time
+
+	// hash of the request that claimed the key, a retry has to match it
+	fingerprint string
+
+	// closed once the first request finishes, duplicates wait on it
+	done chan struct{}
+	ok   bool
+}
+
+// IdempotencyCache remembers completed results so a retried request gets the
+// original signature or key back instead of a second one
+type IdempotencyCache[T any] struct {
+	window  time.Duration
+	entries map[string]*idempotencyEntry[T]
+
+	mu sync.Mutex
+}
+
+// constructor
+func NewIdempotencyCache[T any](window time.Duration) *IdempotencyCache[T] {
+	return &IdempotencyCache[T]{
+		window:  window,
+		entries: make(map[string]*idempotencyEntry[T]),
+	}
+}
+
+// Begin claims the key for the request w/ fingerprint. If another request
+// already owns it, Begin waits for that request and returns its result w/
+// found set, or errIdempotencyConflict right away when the requests differ.
+// Otherwise the caller must call Complete or Abandon.
+func (c *IdempotencyCache[T]) Begin(key, fingerprint string) (result T, found bool, err error) {
+	for {
+		c.mu.Lock()
+
+		now := time.Now()
+		entry, ok := c.entries[key]
+		if ok && entry.ok && now.After(entry.expires) {
+			delete(c.entries, key)
+			ok = false
+		}
+
+		if !ok {
+			c.sweep(now)
+			c.entries[key] = &idempotencyEntry[T]{fingerprint: fingerprint, done: make(chan struct{})}
+			c.mu.Unlock()
+			return result, false, nil
+		}
+		c.mu.Unlock()
+
+		if entry.fingerprint != fingerprint {
+			return result, false, errIdempotencyConflict
+		}
+		<-entry.done
+		if entry.ok {
+			return entry.result, true, nil
+		}
+		// first attempt failed, race to claim the key again
+	}
+}
+
+func (c *IdempotencyCache[T]) Complete(key string, result T) {
+	c.mu.Lock()
+
+	defer c.mu.Unlock()
+
+	if entry, ok := c.entries[key]; ok {
+		entry.result = result
+		entry.ok = true
+		entry.expires = time.Now().Add(c.window)
+		close(entry.done)
+	}
+}
+
+// Abandon releases the key after a failed attempt so it can be retried
+func (c *IdempotencyCache[T]) Abandon(key string) {
+	c.mu.Lock()
+
+	defer c.mu.Unlock()
+
+	if entry, ok := c.entries[key]; ok {
+		delete(c.entries, key)
+		close(entry.done)
+	}
+}
+
+// sweep drops expired results, must be called w/ mu held
+func (c *IdempotencyCache[T]) sweep(now time.Time) {
+	for key, entry := range c.entries {
+		if entry.ok && now.After(entry.expires) {
+			delete(c.entries, key)
+		}
+	}
+}
+
+// requestFingerprint hashes a request w/ its idempotency key cleared, so the
+// key moving between header and body doesn't count as a different request
+func requestFingerprint(req any) string {
+	raw, _ := json.Marshal(req)
+	sum := sha256.Sum256(raw)
+	return hex.EncodeToString(sum[:])
+}
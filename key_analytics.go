@@ -0,0 +1,202 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+)
+
+// the audit action every sign request ends w/, key analytics are built from it
+const auditSignAction = "Sign request"
+
+// per key analytics are kept this long
+const analyticsRetention = 90 * 24 * time.Hour
+
+// KeyAnalyticsDay is one key's sign requests on one UTC day
+type KeyAnalyticsDay struct {
+	Day        time.Time `json:"day"`
+	Signatures int       `json:"signatures"`
+	Pending    int       `json:"pendingApproval"`
+
+	// failed requests by error code, e.g. policy_violation
+	Failures map[string]int `json:"failures"`
+
+	// over every request that reached the signer, failed ones included
+	AverageLatencyMs float64 `json:"averageLatencyMs"`
+}
+
+// KeyAnalyticsReport is the series for one key, a day w/o requests is left out
+type KeyAnalyticsReport struct {
+	KeyID string            `json:"keyId"`
+	From  time.Time         `json:"from"`
+	To    time.Time         `json:"to"`
+	Days  []KeyAnalyticsDay `json:"days"`
+}
+
+type keyDayStats struct {
+	signatures, pending int
+	failures            map[string]int
+	requests            int
+	latencyMs           float64
+}
+
+// KeyAnalytics aggregates sign audit records per key and day. It is an
+// AuditSink, so it sees exactly what the audit trail says happened, and is
+// fed by the audit exporter in the background. Methods are nil safe.
+type KeyAnalytics struct {
+	keys map[string]map[time.Time]*keyDayStats
+
+	mu sync.Mutex
+}
+
+// constructor
+func NewKeyAnalytics() *KeyAnalytics {
+	return &KeyAnalytics{keys: make(map[string]map[time.Time]*keyDayStats)}
+}
+
+func (a *KeyAnalytics) Name() string { return "key_analytics" }
+
+// Export counts the sign records in the batch, others are ignored
+func (a *KeyAnalytics) Export(ctx context.Context, records []AuditRecord) error {
+	if a == nil {
+		return nil
+	}
+	a.mu.Lock()
+
+	defer a.mu.Unlock()
+
+	for _, rec := range records {
+		if rec.Action != auditSignAction {
+			continue
+		}
+		keyID, _ := rec.Fields["key_id"].(string)
+		outcome, _ := rec.Fields["outcome"].(string)
+		if keyID == "" || outcome == "" {
+			continue
+		}
+		days, ok := a.keys[keyID]
+		if !ok {
+			days = make(map[time.Time]*keyDayStats)
+			a.keys[keyID] = days
+		}
+		day := complianceDay(rec.Time)
+		stats, ok := days[day]
+		if !ok {
+			stats = &keyDayStats{failures: map[string]int{}}
+			days[day] = stats
+
+			// a new day is a good time to forget old ones
+			a.prune(time.Now())
+		}
+
+		switch outcome {
+		case "signed":
+			stats.signatures++
+		case "pending_approval":
+			stats.pending++
+		default:
+			stats.failures[outcome]++
+		}
+		if took, ok := auditNumber(rec.Fields["took_ms"]); ok {
+			stats.requests++
+			stats.latencyMs += took
+		}
+	}
+	return nil
+}
+
+// prune drops days past retention, must be called w/ mu held
+func (a *KeyAnalytics) prune(now time.Time) {
+	cutoff := now.Add(-analyticsRetention)
+	for keyID, days := range a.keys {
+		for day := range days {
+			if day.Before(cutoff) {
+				delete(days, day)
+			}
+		}
+		if len(days) == 0 {
+			delete(a.keys, keyID)
+		}
+	}
+}
+
+// auditNumber reads a number field, in process records carry Go types and
+// decoded ones float64
+func auditNumber(v any) (float64, bool) {
+	switch n := v.(type) {
+	case float64:
+		return n, true
+	case int64:
+		return float64(n), true
+	case int:
+		return float64(n), true
+	}
+	return 0, false
+}
+
+// Report is the key's days from from up to, not including, to, oldest first
+func (a *KeyAnalytics) Report(keyID string, from, to time.Time) KeyAnalyticsReport {
+	from, to = complianceDay(from), complianceDay(to)
+	report := KeyAnalyticsReport{KeyID: keyID, From: from, To: to, Days: []KeyAnalyticsDay{}}
+	if a == nil {
+		return report
+	}
+	a.mu.Lock()
+
+	defer a.mu.Unlock()
+
+	for day, stats := range a.keys[keyID] {
+		if day.Before(from) || !day.Before(to) {
+			continue
+		}
+		d := KeyAnalyticsDay{Day: day, Signatures: stats.signatures, Pending: stats.pending, Failures: map[string]int{}}
+		for code, n := range stats.failures {
+			d.Failures[code] = n
+		}
+		if stats.requests > 0 {
+			d.AverageLatencyMs = stats.latencyMs / float64(stats.requests)
+		}
+		report.Days = append(report.Days, d)
+	}
+	sort.Slice(report.Days, func(i, j int) bool { return report.Days[i].Day.Before(report.Days[j].Day) })
+	return report
+}
+
+// handleKeyAnalytics serves ?from=&to= (dates, to exclusive, default the
+// last 30 days) to operators and to API tokens scoped to read the key
+func (s *APIServer) handleKeyAnalytics(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+	if OperatorFromContext(r.Context()).Name == "" {
+		if err := s.checkAPIToken(r, TokenOpRead, id); err != nil {
+			refuseAPIToken(w, r, err)
+			return
+		}
+	}
+
+	to := complianceDay(time.Now()).Add(24 * time.Hour)
+	from := to.Add(-30 * 24 * time.Hour)
+	for name, dst := range map[string]*time.Time{"from": &from, "to": &to} {
+		raw := r.URL.Query().Get(name)
+		if raw == "" {
+			continue
+		}
+		t, err := time.Parse(complianceDate, raw)
+		if err != nil {
+			writeError(w, r, http.StatusBadRequest, fmt.Errorf("%s must be a date like 2006-01-02", name))
+			return
+		}
+		*dst = t
+	}
+	if !from.Before(to) {
+		writeError(w, r, http.StatusBadRequest, errors.New("from must be before to"))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(s.Analytics.Report(id, from, to))
+}
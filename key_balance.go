@@ -0,0 +1,101 @@
+package main
+
+import (
+	"context"
+	"crypto/ed25519"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+var errNoChainAddress = errors.New("key has no solana address, only ed25519 keys do")
+
+// KeyBalance is what a key's address holds on chain
+type KeyBalance struct {
+	KeyID     string         `json:"keyId"`
+	Address   string         `json:"address"`
+	Lamports  uint64         `json:"lamports"`
+	Tokens    []TokenBalance `json:"tokens"`
+	CheckedAt time.Time      `json:"checkedAt"`
+}
+
+// KeyBalances looks up stored keys' balances on the cluster, it is the
+// stale key analyzer's BalanceChecker too
+type KeyBalances struct {
+	store *SecureKeyStore
+	chain ChainClient
+}
+
+// constructor
+func NewKeyBalances(store *SecureKeyStore, chain ChainClient) *KeyBalances {
+	return &KeyBalances{store: store, chain: chain}
+}
+
+// keyAddress is a stored key's solana address
+func keyAddress(store *SecureKeyStore, keyID string) (SolanaPubkey, error) {
+	info, err := store.Info(keyID)
+	if err != nil {
+		return SolanaPubkey{}, err
+	}
+	pub, err := hex.DecodeString(keyID)
+	if info.KeyType != KeyTypeEd25519 || err != nil || len(pub) != ed25519.PublicKeySize {
+		return SolanaPubkey{}, errNoChainAddress
+	}
+	return SolanaAddress(pub), nil
+}
+
+// Balance is the key's lamports
+func (b *KeyBalances) Balance(ctx context.Context, keyID string) (uint64, error) {
+	addr, err := keyAddress(b.store, keyID)
+	if err != nil {
+		return 0, err
+	}
+	return b.chain.Balance(ctx, addr.String())
+}
+
+// Report is the key's lamports and token accounts
+func (b *KeyBalances) Report(ctx context.Context, keyID string) (KeyBalance, error) {
+	addr, err := keyAddress(b.store, keyID)
+	if err != nil {
+		return KeyBalance{}, err
+	}
+	balance := KeyBalance{KeyID: keyID, Address: addr.String()}
+	if balance.Lamports, err = b.chain.Balance(ctx, balance.Address); err != nil {
+		return KeyBalance{}, fmt.Errorf("%w: %v", errRPCUnavailable, err)
+	}
+	if balance.Tokens, err = b.chain.TokenBalances(ctx, balance.Address); err != nil {
+		return KeyBalance{}, fmt.Errorf("%w: %v", errRPCUnavailable, err)
+	}
+	balance.CheckedAt = time.Now().UTC()
+	return balance, nil
+}
+
+// handleKeyBalance serves a key's on-chain balance to operators and to API
+// tokens scoped to read the key
+func (s *APIServer) handleKeyBalance(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+	if OperatorFromContext(r.Context()).Name == "" {
+		if err := s.checkAPIToken(r, TokenOpRead, id); err != nil {
+			refuseAPIToken(w, r, err)
+			return
+		}
+	}
+
+	balance, err := s.Balances.Report(r.Context(), id)
+	if err != nil {
+		status := http.StatusBadRequest
+		switch {
+		case errors.Is(err, errKeyNotFound):
+			status = http.StatusNotFound
+		case errors.Is(err, errRPCUnavailable):
+			status = http.StatusBadGateway
+		}
+		writeError(w, r, status, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(balance)
+}
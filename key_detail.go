@@ -0,0 +1,152 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"time"
+)
+
+// key statuses reported by GET /api/v1/keys/{id}
+const (
+	KeyStatusActive  = "active"
+	KeyStatusFrozen  = "frozen"
+	KeyStatusCooling = "cooling-down"
+)
+
+// KeyDetail introspects one key, never carries key material
+type KeyDetail struct {
+	KeyID     string `json:"keyId"`
+	KeyType   string `json:"keyType"`
+	Namespace string `json:"namespace,omitempty"`
+	Status    string `json:"status"`
+
+	PolicySummary KeyPolicySummary `json:"policySummary"`
+	Usage         KeyUsageStats    `json:"usage"`
+
+	Frozen *KeyFreeze `json:"frozen,omitempty"`
+
+	// the full policy, only for operators
+	Policy *KeyPolicy `json:"policy,omitempty"`
+}
+
+// KeyPolicySummary says which controls apply w/o listing allowed addresses
+// or networks. It leaves out canary, a canary must look like any other key.
+type KeyPolicySummary struct {
+	Usage               string `json:"usage"`
+	MaxUses             int    `json:"maxUses,omitempty"`
+	AllowedPrograms     int    `json:"allowedPrograms"`
+	AllowedDestinations int    `json:"allowedDestinations"`
+	SpendingLimits      int    `json:"spendingLimits"`
+	ApprovalThresholds  int    `json:"approvalThresholds"`
+	Scheduled           bool   `json:"scheduled"`
+	RateLimited         bool   `json:"rateLimited"`
+	CallerRestricted    bool   `json:"callerRestricted"`
+	RequireGrant        bool   `json:"requireGrant"`
+	HighRisk            bool   `json:"highRisk"`
+}
+
+type KeyUsageStats struct {
+	Uses int `json:"uses"`
+
+	// set for max-uses and single-use keys
+	RemainingUses *int `json:"remainingUses,omitempty"`
+
+	CreatedAt  time.Time  `json:"createdAt"`
+	LastUsedAt *time.Time `json:"lastUsedAt,omitempty"`
+
+	// approvals for the key that may still sign
+	PendingApprovals int `json:"pendingApprovals"`
+}
+
+func (p KeyPolicy) Summary() KeyPolicySummary {
+	return KeyPolicySummary{
+		Usage:               p.Usage,
+		MaxUses:             p.MaxUses,
+		AllowedPrograms:     len(p.AllowedPrograms),
+		AllowedDestinations: len(p.AllowedDestinations),
+		SpendingLimits:      len(p.SpendingLimits),
+		ApprovalThresholds:  len(p.ApprovalThresholds),
+		Scheduled:           p.Schedule != nil,
+		RateLimited:         p.RateLimit != nil,
+		CallerRestricted:    len(p.AllowedCIDRs) > 0 || len(p.AllowedClients) > 0,
+		RequireGrant:        p.RequireGrant,
+		HighRisk:            p.HighRisk,
+	}
+}
+
+// KeyDetail describes a key, the full policy is left to the handler to add
+func (s *signerService) KeyDetail(ctx context.Context, keyID string) (KeyDetail, error) {
+	info, err := s.store.Info(keyID)
+	if err != nil {
+		return KeyDetail{}, err
+	}
+
+	detail := KeyDetail{
+		KeyID:         info.KeyID,
+		KeyType:       info.KeyType,
+		Namespace:     info.Namespace,
+		Status:        KeyStatusActive,
+		PolicySummary: info.Policy.Summary(),
+		Frozen:        info.Frozen,
+		Usage: KeyUsageStats{
+			Uses:             info.Uses,
+			CreatedAt:        info.CreatedAt,
+			PendingApprovals: len(s.approvals.InFlight(keyID)),
+		},
+	}
+	switch {
+	case info.Frozen != nil:
+		detail.Status = KeyStatusFrozen
+	case time.Since(info.CreatedAt) < time.Duration(info.Policy.CooldownSeconds)*time.Second:
+		detail.Status = KeyStatusCooling
+	}
+	if !info.LastUsedAt.IsZero() {
+		detail.Usage.LastUsedAt = &info.LastUsedAt
+	}
+
+	remaining := -1
+	switch info.Policy.Usage {
+	case UsageSingleUse:
+		remaining = 1 - info.Uses
+	case UsageMaxUses:
+		remaining = info.Policy.MaxUses - info.Uses
+	}
+	if remaining >= 0 {
+		detail.Usage.RemainingUses = &remaining
+	}
+	return detail, nil
+}
+
+// handleKeyDetail serves one key to operators and to API tokens scoped to read it
+func (s *APIServer) handleKeyDetail(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	id := r.PathValue("id")
+	operator := OperatorFromContext(r.Context()).Name != ""
+	if !operator {
+		if err := s.checkAPIToken(r, TokenOpRead, id); err != nil {
+			refuseAPIToken(w, r, err)
+			return
+		}
+	}
+
+	detail, err := s.Service.KeyDetail(r.Context(), id)
+	if err != nil {
+		status := http.StatusInternalServerError
+		if errors.Is(err, errKeyNotFound) {
+			status = http.StatusNotFound
+		}
+		writeError(w, r, status, err)
+		return
+	}
+	if operator {
+		info, err := s.Service.KeyInfo(r.Context(), id)
+		if err == nil {
+			detail.Policy = &info.Policy
+		}
+	}
+
+	json.NewEncoder(w).Encode(detail)
+}
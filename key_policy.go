@@ -0,0 +1,208 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/netip"
+	"slices"
+)
+
+// key destruction policies, chosen when the key is generated
+const (
+	UsageSingleUse  = "single-use"
+	UsageMaxUses    = "max-uses"
+	UsagePersistent = "persistent"
+)
+
+var errKeyUsesExhausted = errors.New("key has no signing uses left")
+
+type KeyPolicy struct {
+	Usage string `json:"usage"`
+
+	// only for max-uses, key is zeroized after this many signatures
+	MaxUses int `json:"maxUses,omitempty"`
+
+	// base58 program IDs the key may sign for, empty allows any payload
+	AllowedPrograms []string `json:"allowedPrograms,omitempty"`
+
+	// base58 addresses system/SPL transfers may send to. A wallet address
+	// also permits its associated token accounts when the mint is known.
+	AllowedDestinations []string `json:"allowedDestinations,omitempty"`
+
+	// velocity caps on lamports/tokens the key moves, over sliding windows
+	SpendingLimits []SpendingLimit `json:"spendingLimits,omitempty"`
+
+	// transactions at or above a threshold wait for a second operator
+	ApprovalThresholds []ApprovalThreshold `json:"approvalThresholds,omitempty"`
+
+	// who approves and how many, defaults to one operator besides the requester
+	Quorum *ApprovalQuorum `json:"quorum,omitempty"`
+
+	// days/hours signing is allowed, nil allows any time
+	Schedule *SigningSchedule `json:"schedule,omitempty"`
+
+	// no signatures until this long after the key is generated
+	CooldownSeconds int `json:"cooldownSeconds,omitempty"`
+
+	// caps how fast the key signs so a leaked credential can't drain it at once
+	RateLimit *KeyRateLimit `json:"rateLimit,omitempty"`
+
+	// zeroized by the dead man's switch when operators stop checking in
+	HighRisk bool `json:"highRisk,omitempty"`
+
+	// client networks allowed to request signatures, empty allows any
+	AllowedCIDRs []string `json:"allowedCidrs,omitempty"`
+
+	// mTLS client certificate names (CN, DNS, email or URI SAN), empty allows any
+	AllowedClients []string `json:"allowedClients,omitempty"`
+
+	// never signs, every attempt raises a critical alert and gets a decoy response
+	Canary bool `json:"canary,omitempty"`
+
+	// refuse sign requests that don't carry a signing grant for the key
+	RequireGrant bool `json:"requireGrant,omitempty"`
+
+	// lets sign requests have the service set a priority fee, nil never
+	// touches the compute budget
+	PriorityFees *PriorityFeePolicy `json:"priorityFees,omitempty"`
+
+	// cluster the key's requests target unless they name one, empty takes
+	// the service's default
+	Cluster string `json:"cluster,omitempty"`
+
+	// clusters the key may be used on, empty allows any. Mainnet must be
+	// listed when the service requires it.
+	AllowedClusters []string `json:"allowedClusters,omitempty"`
+
+	// lets the stake builders run for the key, nil refuses them
+	Staking *StakingPolicy `json:"staking,omitempty"`
+}
+
+// DefaultKeyPolicy keeps the original behaviour of destroying a key after
+// its first signature
+func DefaultKeyPolicy() KeyPolicy {
+	return KeyPolicy{Usage: UsageSingleUse}
+}
+
+func (p KeyPolicy) Validate() error {
+	switch p.Usage {
+	case UsageSingleUse, UsagePersistent:
+		if p.MaxUses != 0 {
+			return fmt.Errorf("maxUses is only valid w/ the %s policy", UsageMaxUses)
+		}
+	case UsageMaxUses:
+		if p.MaxUses <= 0 {
+			return errors.New("maxUses must be greater than zero")
+		}
+	default:
+		return fmt.Errorf("unknown key usage policy: %q", p.Usage)
+	}
+
+	for _, program := range p.AllowedPrograms {
+		if _, err := ParseSolanaPubkey(program); err != nil {
+			return fmt.Errorf("invalid allowed program: %w", err)
+		}
+	}
+	for _, dest := range p.AllowedDestinations {
+		if _, err := ParseSolanaPubkey(dest); err != nil {
+			return fmt.Errorf("invalid allowed destination: %w", err)
+		}
+	}
+	for _, limit := range p.SpendingLimits {
+		if err := limit.Validate(); err != nil {
+			return err
+		}
+	}
+	for _, threshold := range p.ApprovalThresholds {
+		if err := threshold.Validate(); err != nil {
+			return err
+		}
+	}
+	if p.Quorum != nil {
+		if err := p.Quorum.Validate(); err != nil {
+			return err
+		}
+	}
+	if p.Schedule != nil {
+		if err := p.Schedule.Validate(); err != nil {
+			return err
+		}
+	}
+	if p.CooldownSeconds < 0 {
+		return errors.New("cooldownSeconds cannot be negative")
+	}
+	for _, cidr := range p.AllowedCIDRs {
+		if _, err := netip.ParsePrefix(cidr); err != nil {
+			return fmt.Errorf("invalid allowed cidr: %w", err)
+		}
+	}
+	if p.RateLimit != nil {
+		if err := p.RateLimit.Validate(); err != nil {
+			return err
+		}
+	}
+	if p.PriorityFees != nil {
+		if err := p.PriorityFees.Validate(); err != nil {
+			return err
+		}
+	}
+	if p.Staking != nil {
+		if err := p.Staking.Validate(); err != nil {
+			return err
+		}
+	}
+	for _, name := range p.AllowedClusters {
+		if err := validClusterName(name); err != nil {
+			return err
+		}
+	}
+	if p.Cluster != "" {
+		if err := validClusterName(p.Cluster); err != nil {
+			return err
+		}
+		if len(p.AllowedClusters) > 0 && !slices.Contains(p.AllowedClusters, p.Cluster) {
+			return fmt.Errorf("cluster %s isn't in allowedClusters", p.Cluster)
+		}
+	}
+	return nil
+}
+
+// allowedUses returns the number of signatures the key may produce, zero
+// means unlimited
+func (p KeyPolicy) allowedUses() int {
+	switch p.Usage {
+	case UsageSingleUse:
+		return 1
+	case UsageMaxUses:
+		return p.MaxUses
+	default:
+		return 0
+	}
+}
+
+// tracksSpend reports whether signing needs the transaction's spend totals
+func (p KeyPolicy) tracksSpend() bool {
+	return len(p.SpendingLimits) > 0 || len(p.ApprovalThresholds) > 0
+}
+
+// needsMints is set when any spend rule is about a token
+func (p KeyPolicy) needsMints() bool {
+	for _, l := range p.SpendingLimits {
+		if l.Mint != "" {
+			return true
+		}
+	}
+	for _, a := range p.ApprovalThresholds {
+		if a.Mint != "" {
+			return true
+		}
+	}
+	return false
+}
+
+func (p KeyPolicy) approvalQuorum() ApprovalQuorum {
+	if p.Quorum == nil {
+		return defaultApprovalQuorum()
+	}
+	return *p.Quorum
+}
@@ -0,0 +1,161 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+const (
+	// the kernel's resource manager, it lets several clients share the TPM
+	defaultTPMDevice = "/dev/tpmrm0"
+
+	// secure boot state, a different bootloader or kernel signer changes it
+	defaultTPMPCRs = "7"
+
+	maxPCR = 23
+)
+
+var errTPMUnavailable = errors.New("TPM sealing needs a build w/ -tags tpm")
+
+// KeySealer binds a master key to something a copied disk image doesn't
+// carry, only the same host in the same boot state can unseal it
+type KeySealer interface {
+	Seal(key []byte) ([]byte, error)
+	Unseal(blob []byte) ([]byte, error)
+}
+
+// parsePCRs reads a comma separated list of PCR indexes, empty is the default
+func parsePCRs(raw string) ([]int, error) {
+	if strings.TrimSpace(raw) == "" {
+		raw = defaultTPMPCRs
+	}
+	var pcrs []int
+	for _, field := range strings.Split(raw, ",") {
+		pcr, err := strconv.Atoi(strings.TrimSpace(field))
+		if err != nil || pcr < 0 || pcr > maxPCR {
+			return nil, fmt.Errorf("invalid PCR %q, expected 0 to %d", field, maxPCR)
+		}
+		if !slices.Contains(pcrs, pcr) {
+			pcrs = append(pcrs, pcr)
+		}
+	}
+	slices.Sort(pcrs)
+	return pcrs, nil
+}
+
+// MasterKeyFromEnv reads the key store's master key, what its snapshots are
+// encrypted w/, from STS_BACKUP_KEY. W/ STS_MASTER_KEY_SEALED it is kept at
+// that path sealed to the host's TPM (STS_TPM_DEVICE) and the PCRs in
+// STS_TPM_PCRS. The first start seals STS_BACKUP_KEY there, or w/o one a
+// new master key that never exists outside the sealed blob, later starts
+// unseal it so STS_BACKUP_KEY can go.
+func MasterKeyFromEnv(getenv func(string) string) ([]byte, error) {
+	path := getenv("STS_MASTER_KEY_SEALED")
+	if path == "" {
+		key, err := ParseBackupKey(getenv("STS_BACKUP_KEY"))
+		if err != nil {
+			return nil, fmt.Errorf("STS_BACKUP_KEY: %w", err)
+		}
+		return key, nil
+	}
+
+	sealer, err := NewTPMSealer(getenv("STS_TPM_DEVICE"), getenv("STS_TPM_PCRS"))
+	if err != nil {
+		return nil, err
+	}
+	return sealedMasterKey(sealer, path, getenv("STS_BACKUP_KEY"))
+}
+
+// sealedMasterKey unseals the key at path, or seals plain there when
+// nothing is sealed yet
+func sealedMasterKey(sealer KeySealer, path, plain string) ([]byte, error) {
+	blob, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return sealMasterKey(sealer, path, plain)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read sealed master key: %w", err)
+	}
+
+	key, err := sealer.Unseal(blob)
+	if err != nil {
+		return nil, fmt.Errorf("failed to unseal the master key, the host or its boot state changed: %w", err)
+	}
+	if len(key) != 32 {
+		clear(key)
+		return nil, errBackupKey
+	}
+
+	// the plain key left next to the sealed one must at least be the same key
+	if plain != "" {
+		if given, err := ParseBackupKey(plain); err != nil || subtle.ConstantTimeCompare(given, key) != 1 {
+			clear(key)
+			return nil, errors.New("STS_BACKUP_KEY doesn't match the sealed master key")
+		}
+		slog.Warn("Master key is sealed, STS_BACKUP_KEY can be unset", logKeystore, "path", path)
+	}
+	return key, nil
+}
+
+// sealMasterKey seals plain there, or a new master key when plain is empty
+func sealMasterKey(sealer KeySealer, path, plain string) ([]byte, error) {
+	key := make([]byte, 32)
+	if plain == "" {
+		if _, err := rand.Read(key); err != nil {
+			return nil, fmt.Errorf("failed to generate master key: %w", err)
+		}
+	} else {
+		var err error
+		if key, err = ParseBackupKey(plain); err != nil {
+			return nil, fmt.Errorf("STS_BACKUP_KEY: %w", err)
+		}
+	}
+	if err := writeSealed(sealer, path, key); err != nil {
+		clear(key)
+		return nil, err
+	}
+
+	audit(context.Background(), logKeystore, "Master key sealed to the TPM", "path", path, "generated", plain == "")
+	if plain == "" {
+		// nothing else holds it, snapshots only restore on this host
+		slog.Warn("New master key sealed, snapshots can only be restored on this host", logKeystore, "path", path)
+	} else {
+		slog.Warn("Master key sealed, unset STS_BACKUP_KEY before the next start", logKeystore, "path", path)
+	}
+	return key, nil
+}
+
+func writeSealed(sealer KeySealer, path string, key []byte) error {
+	blob, err := sealer.Seal(key)
+	if err != nil {
+		return fmt.Errorf("failed to seal the master key: %w", err)
+	}
+
+	// written whole or not at all, a torn blob would lose the key
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".sealed-*")
+	if err != nil {
+		return fmt.Errorf("failed to write sealed master key: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(blob); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to write sealed master key: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to write sealed master key: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return fmt.Errorf("failed to write sealed master key: %w", err)
+	}
+	return nil
+}
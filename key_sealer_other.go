@@ -0,0 +1,11 @@
+//go:build !tpm
+
+package main
+
+// NewTPMSealer needs the go-tpm client, built in w/ -tags tpm
+func NewTPMSealer(device, pcrs string) (KeySealer, error) {
+	if _, err := parsePCRs(pcrs); err != nil {
+		return nil, err
+	}
+	return nil, errTPMUnavailable
+}
@@ -0,0 +1,143 @@
+//go:build tpm
+
+package main
+
+import (
+	"fmt"
+	"io"
+
+	"github.com/google/go-tpm/legacy/tpm2"
+	"github.com/google/go-tpm/tpmutil"
+)
+
+// srkTemplate is the storage key sealed objects hang off. It is derived from
+// the owner hierarchy's seed, so every start creates the same key.
+var srkTemplate = tpm2.Public{
+	Type:       tpm2.AlgRSA,
+	NameAlg:    tpm2.AlgSHA256,
+	Attributes: tpm2.FlagFixedTPM | tpm2.FlagFixedParent | tpm2.FlagSensitiveDataOrigin | tpm2.FlagUserWithAuth | tpm2.FlagRestricted | tpm2.FlagDecrypt | tpm2.FlagNoDA,
+	RSAParameters: &tpm2.RSAParams{
+		Symmetric: &tpm2.SymScheme{Alg: tpm2.AlgAES, KeyBits: 128, Mode: tpm2.AlgCFB},
+		KeyBits:   2048,
+	},
+}
+
+// tpmSealer seals to the host TPM under a policy on the PCRs' values at
+// sealing time
+type tpmSealer struct {
+	// a connection to the TPM, one per seal or unseal
+	dial func() (io.ReadWriteCloser, error)
+
+	pcrs tpm2.PCRSelection
+}
+
+// constructor, empty device and pcrs are the defaults
+func NewTPMSealer(device, pcrs string) (KeySealer, error) {
+	selected, err := parsePCRs(pcrs)
+	if err != nil {
+		return nil, err
+	}
+	if device == "" {
+		device = defaultTPMDevice
+	}
+	return newTPMSealer(func() (io.ReadWriteCloser, error) {
+		rw, err := tpm2.OpenTPM(device)
+		if err != nil {
+			return nil, fmt.Errorf("failed to open TPM %s: %w", device, err)
+		}
+		return rw, nil
+	}, selected), nil
+}
+
+func newTPMSealer(dial func() (io.ReadWriteCloser, error), pcrs []int) *tpmSealer {
+	return &tpmSealer{dial: dial, pcrs: tpm2.PCRSelection{Hash: tpm2.AlgSHA256, PCRs: pcrs}}
+}
+
+func (t *tpmSealer) open() (io.ReadWriteCloser, tpmutil.Handle, error) {
+	rw, err := t.dial()
+	if err != nil {
+		return nil, 0, err
+	}
+	srk, _, err := tpm2.CreatePrimary(rw, tpm2.HandleOwner, tpm2.PCRSelection{}, "", "", srkTemplate)
+	if err != nil {
+		rw.Close()
+		return nil, 0, fmt.Errorf("failed to create TPM storage key: %w", err)
+	}
+	return rw, srk, nil
+}
+
+// pcrSession starts a session bound to the PCRs' current values, a trial
+// session only computes the policy's digest
+func (t *tpmSealer) pcrSession(rw io.ReadWriter, kind tpm2.SessionType) (tpmutil.Handle, []byte, error) {
+	session, _, err := tpm2.StartAuthSession(rw, tpm2.HandleNull, tpm2.HandleNull, make([]byte, 16), nil, kind, tpm2.AlgNull, tpm2.AlgSHA256)
+	if err != nil {
+		return 0, nil, fmt.Errorf("failed to start TPM session: %w", err)
+	}
+	if err := tpm2.PolicyPCR(rw, session, nil, t.pcrs); err != nil {
+		tpm2.FlushContext(rw, session)
+		return 0, nil, fmt.Errorf("failed to bind TPM session to PCRs %v: %w", t.pcrs.PCRs, err)
+	}
+	digest, err := tpm2.PolicyGetDigest(rw, session)
+	if err != nil {
+		tpm2.FlushContext(rw, session)
+		return 0, nil, fmt.Errorf("failed to read TPM policy digest: %w", err)
+	}
+	return session, digest, nil
+}
+
+// Seal returns the sealed object's public and private parts, each u16
+// length prefixed
+func (t *tpmSealer) Seal(key []byte) ([]byte, error) {
+	rw, srk, err := t.open()
+	if err != nil {
+		return nil, err
+	}
+	defer rw.Close()
+	defer tpm2.FlushContext(rw, srk)
+
+	session, policy, err := t.pcrSession(rw, tpm2.SessionTrial)
+	if err != nil {
+		return nil, err
+	}
+	tpm2.FlushContext(rw, session)
+
+	private, public, err := tpm2.Seal(rw, srk, "", "", policy, key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to seal w/ the TPM: %w", err)
+	}
+	return tpmutil.Pack(tpmutil.U16Bytes(public), tpmutil.U16Bytes(private))
+}
+
+// Unseal only succeeds on the TPM that sealed blob while its PCRs still
+// hold the values they had then
+func (t *tpmSealer) Unseal(blob []byte) ([]byte, error) {
+	var public, private tpmutil.U16Bytes
+	if _, err := tpmutil.Unpack(blob, &public, &private); err != nil {
+		return nil, fmt.Errorf("invalid sealed key: %w", err)
+	}
+
+	rw, srk, err := t.open()
+	if err != nil {
+		return nil, err
+	}
+	defer rw.Close()
+	defer tpm2.FlushContext(rw, srk)
+
+	object, _, err := tpm2.Load(rw, srk, "", public, private)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load sealed key, sealed by another TPM? %w", err)
+	}
+	defer tpm2.FlushContext(rw, object)
+
+	session, _, err := t.pcrSession(rw, tpm2.SessionPolicy)
+	if err != nil {
+		return nil, err
+	}
+	defer tpm2.FlushContext(rw, session)
+
+	key, err := tpm2.UnsealWithSession(rw, session, object, "")
+	if err != nil {
+		return nil, fmt.Errorf("TPM refused to unseal, PCRs changed since sealing? %w", err)
+	}
+	return key, nil
+}
@@ -0,0 +1,116 @@
+//go:build tpm
+
+package main
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/google/go-tpm/legacy/tpm2"
+	"github.com/google/go-tpm/tpmutil"
+)
+
+// PCR 16 is the debug PCR, a test can extend and reset it
+const testPCR = 16
+
+// simulatorConn hands go-tpm whole responses, it reads each in one call
+type simulatorConn struct {
+	net.Conn
+}
+
+func (c simulatorConn) Read(p []byte) (int, error) {
+	header := make([]byte, 10)
+	if _, err := io.ReadFull(c.Conn, header); err != nil {
+		return 0, err
+	}
+	size := int(binary.BigEndian.Uint32(header[2:6]))
+	if size < len(header) || size > len(p) {
+		return 0, io.ErrShortBuffer
+	}
+	copy(p, header)
+	if _, err := io.ReadFull(c.Conn, p[len(header):size]); err != nil {
+		return 0, err
+	}
+	return size, nil
+}
+
+// simulator dials the TPM simulator at STS_TPM_SIMULATOR, e.g. swtpm run w/
+// swtpm socket --tpm2 --tpmstate dir=/tmp/swtpm --server type=tcp,port=2321
+// --ctrl type=tcp,port=2322 --flags not-need-init,startup-clear
+func simulator(t *testing.T) func() (io.ReadWriteCloser, error) {
+	addr := os.Getenv("STS_TPM_SIMULATOR")
+	if addr == "" {
+		t.Skip("STS_TPM_SIMULATOR isn't set")
+	}
+	return func() (io.ReadWriteCloser, error) {
+		conn, err := net.Dial("tcp", addr)
+		if err != nil {
+			return nil, err
+		}
+		return simulatorConn{conn}, nil
+	}
+}
+
+func extendTestPCR(t *testing.T, dial func() (io.ReadWriteCloser, error), reset bool) {
+	rw, err := dial()
+	if err != nil {
+		t.Fatalf("Failed to reach the simulator: %v", err)
+	}
+	defer rw.Close()
+
+	if reset {
+		err = tpm2.PCRReset(rw, tpmutil.Handle(testPCR))
+	} else {
+		digest := sha256.Sum256([]byte("another bootloader"))
+		err = tpm2.PCRExtend(rw, tpmutil.Handle(testPCR), tpm2.AlgSHA256, digest[:], "")
+	}
+	if err != nil {
+		t.Fatalf("Failed to change PCR %d: %v", testPCR, err)
+	}
+}
+
+func TestTPMSealer_Simulator(t *testing.T) {
+	dial := simulator(t)
+	extendTestPCR(t, dial, true)
+	sealer := newTPMSealer(dial, []int{testPCR})
+
+	key := make([]byte, 32)
+	rand.Read(key)
+	blob, err := sealer.Seal(key)
+	if err != nil {
+		t.Fatalf("Seal failed: %v", err)
+	}
+	if bytes.Contains(blob, key) {
+		t.Fatal("Expected the key not to be readable in the blob")
+	}
+	if got, err := sealer.Unseal(blob); err != nil || !bytes.Equal(got, key) {
+		t.Fatalf("Expected the key unsealed, got %x %v", got, err)
+	}
+
+	// the master key goes through the same sealer on start
+	path := filepath.Join(t.TempDir(), "master.sealed")
+	master, err := sealedMasterKey(sealer, path, "")
+	if err != nil {
+		t.Fatalf("Failed to seal a new master key: %v", err)
+	}
+	if got, err := sealedMasterKey(sealer, path, ""); err != nil || !bytes.Equal(got, master) {
+		t.Errorf("Expected the master key unsealed on the next start, got %x %v", got, err)
+	}
+
+	// a changed boot state can't unseal
+	extendTestPCR(t, dial, false)
+	if _, err := sealer.Unseal(blob); err == nil {
+		t.Error("Expected the TPM to refuse once the PCR changed")
+	}
+	if _, err := sealedMasterKey(sealer, path, ""); err == nil {
+		t.Error("Expected the master key to stay sealed once the PCR changed")
+	}
+	extendTestPCR(t, dial, true)
+}
@@ -1,15 +1,65 @@
 package main
 
 import (
+	"bytes"
+	"context"
 	"crypto/ed25519"
 	"errors"
-	"log"
+	"fmt"
+	"log/slog"
 	"sync"
+	"time"
 )
 
+var (
+	errKeyNotFound = errors.New("key not found")
+	errKeyInUse    = errors.New("key is in use by pending jobs")
+)
+
+type keyEntry struct {
+	keyType string
+	key     []byte
+	policy  KeyPolicy
+
+	// tenant scope the key belongs to, empty for service keys
+	namespace string
+
+	// set while an admin has the key frozen
+	frozen *KeyFreeze
+
+	// signatures produced so far
+	uses int
+
+	createdAt  time.Time
+	lastUsedAt time.Time
+}
+
+// KeyUsage is a key's metadata w/o any key material
+type KeyUsage struct {
+	KeyID      string     `json:"keyId"`
+	KeyType    string     `json:"keyType"`
+	Namespace  string     `json:"namespace,omitempty"`
+	Policy     KeyPolicy  `json:"policy"`
+	Uses       int        `json:"uses"`
+	CreatedAt  time.Time  `json:"createdAt"`
+	LastUsedAt time.Time  `json:"lastUsedAt,omitempty"`
+	Frozen     *KeyFreeze `json:"frozen,omitempty"`
+}
+
+type KeyFreeze struct {
+	Reason   string    `json:"reason"`
+	FrozenBy string    `json:"frozenBy"`
+	FrozenAt time.Time `json:"frozenAt"`
+}
+
+var errKeyFrozen = errors.New("key is frozen")
+
 type SecureKeyStore struct {
 	// public to private key map
-	keys map[string]ed25519.PrivateKey
+	keys map[string]*keyEntry
+
+	// keys created and destroyed are recorded here, nil records nothing
+	compliance *ComplianceRecorder
 
 	// mutex to prevent concurrent access
 	mu sync.RWMutex
@@ -18,28 +68,184 @@ type SecureKeyStore struct {
 // constructor
 func NewSecureKeyStore() *SecureKeyStore {
 	return &SecureKeyStore{
-		keys: make(map[string]ed25519.PrivateKey),
+		keys: make(map[string]*keyEntry),
 	}
 }
 
+// Store keeps a key w/ no usage limit, used for service-managed keys
 func (s *SecureKeyStore) Store(id string, key ed25519.PrivateKey) {
+	s.StoreWithPolicy(id, key, KeyPolicy{Usage: UsagePersistent})
+}
+
+func (s *SecureKeyStore) StoreWithPolicy(id string, key ed25519.PrivateKey, policy KeyPolicy) {
+	s.StoreKey(id, KeyTypeEd25519, key, policy, "")
+}
+
+// StoreKey keeps raw private key material of any supported key type
+func (s *SecureKeyStore) StoreKey(id, keyType string, material []byte, policy KeyPolicy, namespace string) {
 	s.mu.Lock()
 
 	defer s.mu.Unlock()
 
-	s.keys[id] = key
+	s.keys[id] = &keyEntry{keyType: keyType, key: material, policy: policy, namespace: namespace, createdAt: time.Now()}
+	s.compliance.KeyCreated(id, keyType, policy.Usage, namespace)
 }
 
+// Get returns a copy of an ed25519 key, other key types are only reachable
+// via Acquire. The caller clears it when done.
 func (s *SecureKeyStore) Get(id string) (ed25519.PrivateKey, error) {
 	s.mu.RLock()
 
 	defer s.mu.RUnlock()
 
-	pk, ok := s.keys[id]
+	entry, ok := s.keys[id]
 	if !ok {
-		return nil, errors.New("key not found")
+		return nil, errKeyNotFound
+	}
+	if entry.keyType != KeyTypeEd25519 {
+		return nil, errors.New("key is not an ed25519 key")
 	}
-	return pk, nil
+	if entry.frozen != nil {
+		return nil, errKeyFrozen
+	}
+	return bytes.Clone(entry.key), nil
+}
+
+func (s *SecureKeyStore) Policy(id string) (KeyPolicy, error) {
+	s.mu.RLock()
+
+	defer s.mu.RUnlock()
+
+	entry, ok := s.keys[id]
+	if !ok {
+		return KeyPolicy{}, errKeyNotFound
+	}
+	return entry.policy, nil
+}
+
+// Len is the number of keys held
+func (s *SecureKeyStore) Len() int {
+	s.mu.RLock()
+
+	defer s.mu.RUnlock()
+
+	return len(s.keys)
+}
+
+// Ping fails if the store stays locked past ctx, e.g. a wedged writer
+func (s *SecureKeyStore) Ping(ctx context.Context) error {
+	locked := make(chan struct{})
+	go func() {
+		s.mu.RLock()
+		s.mu.RUnlock()
+		close(locked)
+	}()
+
+	select {
+	case <-locked:
+		return nil
+	case <-ctx.Done():
+		return fmt.Errorf("key store unavailable: %w", ctx.Err())
+	}
+}
+
+// Info returns a key's metadata w/o its material
+func (s *SecureKeyStore) Info(id string) (KeyUsage, error) {
+	s.mu.RLock()
+
+	defer s.mu.RUnlock()
+
+	entry, ok := s.keys[id]
+	if !ok {
+		return KeyUsage{}, errKeyNotFound
+	}
+	return entry.usage(id), nil
+}
+
+// Acquire reserves one signing use of the key under its policy. key is the
+// caller's own copy, a zeroize while it signs can't pull the bytes out from
+// under it, and the caller clears it once signed. last is true when this was
+// the final allowed use and the caller must zeroize after signing.
+func (s *SecureKeyStore) Acquire(id string) (keyType string, key []byte, last bool, err error) {
+	s.mu.Lock()
+
+	defer s.mu.Unlock()
+
+	entry, ok := s.keys[id]
+	if !ok {
+		return "", nil, false, errKeyNotFound
+	}
+
+	// checked again here so a freeze can't race a sign in progress
+	if entry.frozen != nil {
+		return "", nil, false, errKeyFrozen
+	}
+
+	allowed := entry.policy.allowedUses()
+	if allowed > 0 && entry.uses >= allowed {
+		return "", nil, false, errKeyUsesExhausted
+	}
+	entry.uses++
+	entry.lastUsedAt = time.Now()
+
+	return entry.keyType, bytes.Clone(entry.key), allowed > 0 && entry.uses >= allowed, nil
+}
+
+// Usage lists metadata for every stored key
+func (s *SecureKeyStore) Usage() []KeyUsage {
+	s.mu.RLock()
+
+	defer s.mu.RUnlock()
+
+	usage := make([]KeyUsage, 0, len(s.keys))
+	for id, entry := range s.keys {
+		usage = append(usage, entry.usage(id))
+	}
+	return usage
+}
+
+func (e *keyEntry) usage(id string) KeyUsage {
+	return KeyUsage{
+		KeyID:      id,
+		KeyType:    e.keyType,
+		Namespace:  e.namespace,
+		Policy:     e.policy,
+		Uses:       e.uses,
+		CreatedAt:  e.createdAt,
+		LastUsedAt: e.lastUsedAt,
+		Frozen:     e.frozen,
+	}
+}
+
+// Freeze blocks all signing w/ the key, the material is kept
+func (s *SecureKeyStore) Freeze(ctx context.Context, id, reason, by string) (KeyUsage, error) {
+	s.mu.Lock()
+
+	defer s.mu.Unlock()
+
+	entry, ok := s.keys[id]
+	if !ok {
+		return KeyUsage{}, errKeyNotFound
+	}
+	entry.frozen = &KeyFreeze{Reason: reason, FrozenBy: by, FrozenAt: time.Now()}
+
+	audit(ctx, logKeystore, "Key frozen", "key_id", id, "operator", by, "reason", reason)
+	return entry.usage(id), nil
+}
+
+func (s *SecureKeyStore) Unfreeze(ctx context.Context, id, by string) (KeyUsage, error) {
+	s.mu.Lock()
+
+	defer s.mu.Unlock()
+
+	entry, ok := s.keys[id]
+	if !ok {
+		return KeyUsage{}, errKeyNotFound
+	}
+	entry.frozen = nil
+
+	audit(ctx, logKeystore, "Key unfrozen", "key_id", id, "operator", by)
+	return entry.usage(id), nil
 }
 
 // Clears private key from mem, and removes from store
@@ -48,18 +254,51 @@ func (s *SecureKeyStore) Zerorize(id string) error {
 
 	defer s.mu.Unlock()
 
-	pk, ok := s.keys[id]
+	entry, ok := s.keys[id]
 	if !ok {
-		return errors.New("Key not found")
+		return errKeyNotFound
 	}
 
 	// loop over each byte and zerorize it
+	pk := entry.key
 	for i := range pk {
 		pk[i] = 0
 	}
 
 	delete(s.keys, id)
+	s.compliance.KeyDestroyed(id)
 
-	log.Printf("Key ID %s zeroized and removed from memory store.", id)
+	slog.Info("Key zeroized and removed from memory store", logKeystore, "key_id", id)
 	return nil
 }
+
+// ZerorizeAll clears every key in the namespace, or every key at all when
+// namespace is empty, and returns the cleared key IDs
+func (s *SecureKeyStore) ZerorizeAll(namespace string) []string {
+	return s.ZerorizeMatching(func(k KeyUsage) bool {
+		return namespace == "" || k.Namespace == namespace
+	})
+}
+
+// ZerorizeMatching clears every key match selects and returns their IDs
+func (s *SecureKeyStore) ZerorizeMatching(match func(KeyUsage) bool) []string {
+	s.mu.Lock()
+
+	defer s.mu.Unlock()
+
+	cleared := []string{}
+	for id, entry := range s.keys {
+		if !match(entry.usage(id)) {
+			continue
+		}
+		for i := range entry.key {
+			entry.key[i] = 0
+		}
+		delete(s.keys, id)
+		s.compliance.KeyDestroyed(id)
+		cleared = append(cleared, id)
+	}
+
+	slog.Info("Keys zeroized and removed from memory store", logKeystore, "count", len(cleared))
+	return cleared
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+)
+
+// key types the service can generate
+const (
+	KeyTypeEd25519   = "ed25519"
+	KeyTypeSecp256k1 = "secp256k1"
+	KeyTypeP256      = "p256"
+)
+
+// generateKeyPair returns the key ID (hex public key) and private material
+func generateKeyPair(keyType string) (string, []byte, error) {
+	switch keyType {
+	case KeyTypeEd25519:
+		pubKey, privKey, err := ed25519.GenerateKey(rand.Reader)
+		if err != nil {
+			return "", nil, err
+		}
+		return hex.EncodeToString(pubKey), privKey, nil
+	case KeyTypeSecp256k1:
+		pubKey, privKey, err := generateSecp256k1()
+		if err != nil {
+			return "", nil, err
+		}
+		return hex.EncodeToString(pubKey), privKey, nil
+	case KeyTypeP256:
+		pubKey, privKey, err := generateP256()
+		if err != nil {
+			return "", nil, err
+		}
+		return hex.EncodeToString(pubKey), privKey, nil
+	default:
+		return "", nil, fmt.Errorf("unsupported key type: %s", keyType)
+	}
+}
+
+// signWithKey dispatches on key type and returns the signature and the
+// signing mode actually used
+func signWithKey(keyType string, privKey, msg []byte, mode, signingContext string) ([]byte, string, error) {
+	switch keyType {
+	case KeyTypeEd25519:
+		return signEd25519(ed25519.PrivateKey(privKey), msg, mode, signingContext)
+	case KeyTypeSecp256k1:
+		if mode != "" && mode != SigningModeBIP340 {
+			return nil, "", fmt.Errorf("signing mode %s is not supported for %s keys", mode, keyType)
+		}
+		sig, err := signBIP340(privKey, msg)
+		return sig, SigningModeBIP340, err
+	case KeyTypeP256:
+		return signP256(privKey, msg, mode)
+	default:
+		return nil, "", fmt.Errorf("unsupported key type: %s", keyType)
+	}
+}
+
+func verifyWithKey(keyType string, pubKey, msg, sig []byte, mode, signingContext string) (bool, error) {
+	switch keyType {
+	case KeyTypeEd25519:
+		if len(pubKey) != ed25519.PublicKeySize {
+			return false, fmt.Errorf("ed25519 public key must be %d bytes", ed25519.PublicKeySize)
+		}
+		return verifyEd25519(ed25519.PublicKey(pubKey), msg, sig, mode, signingContext)
+	case KeyTypeSecp256k1:
+		return verifyBIP340(pubKey, msg, sig)
+	case KeyTypeP256:
+		return verifyP256(pubKey, msg, sig, mode)
+	default:
+		return false, fmt.Errorf("unsupported key type: %s", keyType)
+	}
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+)
+
+type KillRequest struct {
+	// only keys in this namespace, empty kills every key and seals the service
+	Namespace string `json:"namespace,omitempty"`
+	Reason    string `json:"reason"`
+}
+
+type KillReport struct {
+	Namespace string   `json:"namespace,omitempty"`
+	Zeroized  int      `json:"zeroized"`
+	KeyIDs    []string `json:"keyIds"`
+	Seal      SealInfo `json:"seal"`
+}
+
+// KillSwitch is the incident response lever, it seals first so no new
+// signature can start and then zeroizes the keys
+type KillSwitch struct {
+	store *SecureKeyStore
+	seal  *SealState
+
+	// every pull pages on-call
+	notifier *NotificationDispatcher
+}
+
+// constructor
+func NewKillSwitch(store *SecureKeyStore, seal *SealState) *KillSwitch {
+	return &KillSwitch{store: store, seal: seal}
+}
+
+func (k *KillSwitch) Trigger(ctx context.Context, req KillRequest) (KillReport, error) {
+	if req.Reason == "" {
+		return KillReport{}, errors.New("reason cannot be empty")
+	}
+	operator := OperatorFromContext(ctx).Name
+
+	report := KillReport{Namespace: req.Namespace}
+	report.Seal = k.seal.Seal(req.Namespace, SealCauseKillSwitch, req.Reason, operator)
+	report.KeyIDs = k.store.ZerorizeAll(req.Namespace)
+	report.Zeroized = len(report.KeyIDs)
+
+	scope := "all keys"
+	if req.Namespace != "" {
+		scope = "namespace " + req.Namespace
+	}
+	audit(ctx, logAdmin, "Kill switch pulled", "operator", operator, "scope", scope, "zeroized", report.Zeroized, "reason", req.Reason)
+	k.notifier.Dispatch(Notification{
+		Kind:     NotifySecurityAlert,
+		Severity: SeverityCritical,
+		Title:    "Kill switch pulled",
+		Message:  fmt.Sprintf("%s zeroized %d keys in %s and sealed it: %s", operator, report.Zeroized, scope, req.Reason),
+		Details:  map[string]string{"operator": operator, "namespace": req.Namespace},
+	})
+
+	return report, nil
+}
+
+// Seal puts the service, or one namespace, into maintenance. Like Trigger it
+// wipes the keys it covers from memory, a sealed service holds no key
+// material, but it's planned work so on-call only gets an info notice.
+func (k *KillSwitch) Seal(ctx context.Context, req KillRequest) (KillReport, error) {
+	if req.Reason == "" {
+		return KillReport{}, errors.New("reason cannot be empty")
+	}
+	operator := OperatorFromContext(ctx).Name
+
+	report := KillReport{Namespace: req.Namespace}
+	report.Seal = k.seal.Seal(req.Namespace, SealCauseMaintenance, req.Reason, operator)
+	report.KeyIDs = k.store.ZerorizeAll(req.Namespace)
+	report.Zeroized = len(report.KeyIDs)
+
+	audit(ctx, logAdmin, "Keys zeroized for a maintenance seal", "scope", sealScope(req.Namespace), "zeroized", report.Zeroized)
+	k.notifier.Dispatch(Notification{
+		Kind:     NotifySecurityAlert,
+		Severity: SeverityInfo,
+		Title:    "Service sealed for maintenance",
+		Message:  fmt.Sprintf("%s sealed %s and zeroized %d keys: %s", operator, sealScope(req.Namespace), report.Zeroized, req.Reason),
+		Details:  map[string]string{"operator": operator, "namespace": req.Namespace},
+	})
+
+	return report, nil
+}
+
+// Unseal lets signing and key generation resume, keys have to be generated
+// or imported again
+func (k *KillSwitch) Unseal(ctx context.Context, namespace string) (SealInfo, error) {
+	operator := OperatorFromContext(ctx).Name
+	info, err := k.seal.Unseal(namespace, operator)
+	if err != nil {
+		return SealInfo{}, err
+	}
+
+	k.notifier.Dispatch(Notification{
+		Kind:     NotifySecurityAlert,
+		Severity: SeverityWarning,
+		Title:    "Service unsealed",
+		Message:  fmt.Sprintf("%s unsealed %s, sealed by %s (%s): %s", operator, sealScope(namespace), info.SealedBy, info.Cause, info.Reason),
+		Details:  map[string]string{"operator": operator, "namespace": namespace},
+	})
+	return info, nil
+}
+
+func sealScope(namespace string) string {
+	if namespace == "" {
+		return "the service"
+	}
+	return "namespace " + namespace
+}
@@ -0,0 +1,33 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+)
+
+// log levels, request lines are info. Audit lines always print.
+const (
+	levelDebug = slog.LevelDebug
+	levelInfo  = slog.LevelInfo
+	levelWarn  = slog.LevelWarn
+	levelError = slog.LevelError
+)
+
+var logLevels = map[string]slog.Level{"debug": levelDebug, "info": levelInfo, "warn": levelWarn, "error": levelError}
+
+// zero is info
+var logLevel = new(slog.LevelVar)
+
+// SetLogLevel switches the level at runtime, safe while serving
+func SetLogLevel(name string) error {
+	level, ok := logLevels[name]
+	if !ok {
+		return fmt.Errorf("unknown log level %q, use debug, info, warn or error", name)
+	}
+	logLevel.Set(level)
+	return nil
+}
+
+func logEnabled(level slog.Level) bool {
+	return level >= logLevel.Level()
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"slices"
+	"strings"
+
+	"github.com/btcsuite/btcd/btcec/v2"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// LevelAudit is above error, audit lines are written whatever the level
+const LevelAudit = slog.Level(12)
+
+// component fields, every line says which part of the service wrote it
+var (
+	logServer   = slog.String("component", "server")
+	logHTTP     = slog.String("component", "http")
+	logAuth     = slog.String("component", "auth")
+	logAdmin    = slog.String("component", "admin")
+	logSigner   = slog.String("component", "signer")
+	logKeystore = slog.String("component", "keystore")
+	logConfig   = slog.String("component", "config")
+	logDeploy   = slog.String("component", "deploy")
+	logNotify   = slog.String("component", "notify")
+	logEvents   = slog.String("component", "events")
+	logAudit    = slog.String("component", "audit")
+)
+
+// fields whose values are never written, whoever logs them
+var secretLogKeys = []string{"private_key", "key_material", "secret", "seed", "share", "password", "authorization", "tx_data"}
+
+// NewLogger writes to w as "json" or "text" (the default) at the level set
+// by SetLogLevel. Lines logged w/ a context get its request and trace ids.
+func NewLogger(w io.Writer, format string) (*slog.Logger, error) {
+	opts := &slog.HandlerOptions{Level: logLevel, ReplaceAttr: redactLogAttr}
+	var h slog.Handler
+	switch format {
+	case "", "text":
+		h = slog.NewTextHandler(w, opts)
+	case "json":
+		h = slog.NewJSONHandler(w, opts)
+	default:
+		return nil, fmt.Errorf("unknown log format %q, use json or text", format)
+	}
+	return slog.New(contextHandler{h}), nil
+}
+
+// redactLogAttr names the audit level and blanks secret fields. Keys and raw
+// bytes are blanked by type too, so a misnamed field can't leak them.
+func redactLogAttr(groups []string, a slog.Attr) slog.Attr {
+	if a.Key == slog.LevelKey && len(groups) == 0 {
+		if level, ok := a.Value.Any().(slog.Level); ok && level == LevelAudit {
+			return slog.String(slog.LevelKey, "AUDIT")
+		}
+		return a
+	}
+	if slices.Contains(secretLogKeys, strings.ToLower(a.Key)) {
+		return slog.String(a.Key, "REDACTED")
+	}
+	switch a.Value.Any().(type) {
+	case ed25519.PrivateKey, *ecdsa.PrivateKey, *btcec.PrivateKey, []byte:
+		return slog.String(a.Key, "REDACTED")
+	}
+	return a
+}
+
+// contextHandler adds the request id and trace id from the context
+type contextHandler struct {
+	slog.Handler
+}
+
+func (h contextHandler) Handle(ctx context.Context, r slog.Record) error {
+	if id := RequestIDFromContext(ctx); id != "" {
+		r.AddAttrs(slog.String("request_id", id))
+	}
+	if sc := trace.SpanContextFromContext(ctx); sc.IsValid() {
+		r.AddAttrs(slog.String("trace_id", sc.TraceID().String()))
+	}
+	return h.Handler.Handle(ctx, r)
+}
+
+func (h contextHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return contextHandler{h.Handler.WithAttrs(attrs)}
+}
+
+func (h contextHandler) WithGroup(name string) slog.Handler {
+	return contextHandler{h.Handler.WithGroup(name)}
+}
+
+// audit writes an audit line, kept whatever the log level
+func audit(ctx context.Context, component slog.Attr, msg string, args ...any) {
+	slog.Default().Log(ctx, LevelAudit, msg, append([]any{component}, args...)...)
+}
+
+// fatal logs and exits, for startup errors
+func fatal(msg string, args ...any) {
+	slog.Error(msg, append([]any{logServer}, args...)...)
+	os.Exit(1)
+}
@@ -1,16 +1,411 @@
 package main
 
 import (
-	"log"
+	"context"
+	"crypto/fips140"
+	"log/slog"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
 )
 
 func main() {
 
-	log.SetFlags(log.Ldate | log.Ltime | log.Lshortfile)
+	// text lines by default, STS_LOG_FORMAT=json for log shippers
+	logger, err := NewLogger(os.Stderr, os.Getenv("STS_LOG_FORMAT"))
+	if err != nil {
+		fatal("Invalid STS_LOG_FORMAT", "err", err)
+	}
+	// audit lines are also shipped to the SIEM sinks in STS_AUDIT_SINKS, and
+	// sign records feed the per key analytics
+	analytics := NewKeyAnalytics()
+	audits, err := AuditExporterFromEnv(os.Getenv, analytics)
+	if err != nil {
+		fatal("Invalid STS_AUDIT_SINKS", "err", err)
+	}
+	audits.Start()
+	slog.SetDefault(slog.New(audits.Handler(logger.Handler())))
 
+	// listener, timeouts, body limits and backend, flags override env vars
+	config, err := ConfigFromFlags(os.Args[1:], os.Getenv)
+	if err != nil {
+		fatal("Invalid configuration", "err", err)
+	}
+	if level := os.Getenv("STS_LOG_LEVEL"); level != "" {
+		if err := SetLogLevel(level); err != nil {
+			fatal("Invalid STS_LOG_LEVEL", "err", err)
+		}
+	}
+
+	// regulated deployments set STS_REQUIRE_FIPS so a non FIPS build can't start
+	if os.Getenv("STS_REQUIRE_FIPS") == "true" && !fips140.Enabled() {
+		fatal("STS_REQUIRE_FIPS is set but the Go crypto module is not in FIPS mode, run w/ GODEBUG=fips140=on")
+	}
+	slog.Info("FIPS mode", logServer, "enabled", fips140.Enabled())
+
+	// refuse to serve on broken crypto
+	keyTypes := []string{KeyTypeEd25519, KeyTypeSecp256k1, KeyTypeP256}
+	if fips140.Enabled() {
+		keyTypes = fipsKeyTypes
+	}
+	if _, err := RunSelfTests(keyTypes); err != nil {
+		fatal("Startup self-test failed", "err", err)
+	}
+
+	// spans go to an OTLP collector when STS_OTLP_ENDPOINT is set
+	tracing, err := TracingConfigFromEnv(os.Getenv)
+	if err != nil {
+		fatal("Invalid tracing settings", "err", err)
+	}
+	stopTracing := func(context.Context) error { return nil }
+	if tracing != nil {
+		if stopTracing, err = StartTracing(context.Background(), *tracing); err != nil {
+			fatal("Failed to start tracing", "err", err)
+		}
+		slog.Info("Exporting traces", logServer, "endpoint", tracing.Endpoint)
+	}
+
+	// memory is the only backend, Validate refused anything else
 	store := NewSecureKeyStore()
+	slog.Info("Key store backend", logKeystore, "backend", config.Backend)
+	notifier := NotificationDispatcherFromEnv(os.Getenv)
+
+	events := NewEventBus()
+	metrics := NewMetrics(store)
+	// tenants w/ their own metric series, everyone else is "other"
+	maxTenants, err := strconv.Atoi(os.Getenv("STS_METRICS_MAX_TENANTS"))
+	if err != nil || maxTenants <= 0 {
+		maxTenants = defaultMetricsTenants
+	}
+	var allowTenants []string
+	if raw := os.Getenv("STS_METRICS_TENANTS"); raw != "" {
+		allowTenants = strings.Split(raw, ",")
+	}
+	metrics.LabelTenants(allowTenants, maxTenants)
 	signer := NewSignerService(store)
+	signer.metrics = metrics
+	signer.notifier = notifier
+	signer.events = events
+	featureOverrides, err := FeatureFlagsFromEnv(os.Getenv)
+	if err != nil {
+		fatal("Invalid STS_FEATURES", "err", err)
+	}
+	features, _ := NewFeatureFlags(featureOverrides)
+	signer.features = features
+	// a comma separated list fails over between endpoints, more clusters
+	// can be added for requests and keys to pick
+	clusters, pools, err := ClustersFromEnv(os.Getenv)
+	if err != nil {
+		fatal("Invalid cluster settings", "err", err)
+	}
+	var rpcPool *RPCPool
+	if clusters != nil {
+		for _, pool := range pools {
+			go pool.Run(context.Background(), 15*time.Second)
+		}
+		for _, cluster := range clusters.All() {
+			go cluster.Blockhashes.Run(context.Background(), 5*time.Second)
+		}
+		def, _ := clusters.Get("")
+		rpcPool = def.Chain.(*RPCPool)
+		signer.clusters = clusters
+		signer.rpc, signer.fees, signer.blockhashes = def.Chain, def.Fees, def.Blockhashes
+	}
+	// signed transactions are recorded either way, followed on chain w/ an rpc
+	signer.txs = NewTxTracker(signer.rpc)
+	if clusters != nil {
+		for _, cluster := range clusters.All() {
+			signer.txs.AddCluster(cluster.Name, cluster.Chain)
+		}
+	}
+	// STS_TX_WEBHOOK_URL is told when transactions that asked for it reach
+	// their commitment or fail
+	if u := os.Getenv("STS_TX_WEBHOOK_URL"); u != "" {
+		signer.txs.webhook = NewNotificationDispatcher()
+		signer.txs.webhook.Add(&WebhookNotifier{URL: u, Secret: os.Getenv("STS_TX_WEBHOOK_SECRET")}, SeverityInfo)
+	}
+	go signer.txs.Run(context.Background(), 2*time.Second)
+	// queued transactions go out in order per key, STS_OUTBOUND_QUEUE_PATH
+	// keeps them across restarts
+	if signer.rpc != nil {
+		outbound, err := NewOutboundQueue(os.Getenv("STS_OUTBOUND_QUEUE_PATH"), signer.rpc, signer.SignTransaction)
+		if err != nil {
+			fatal("Failed to load the outbound queue", "err", err)
+		}
+		outbound.txs = signer.txs
+		for _, cluster := range clusters.All() {
+			outbound.AddCluster(cluster.Name, cluster.Chain)
+		}
+		signer.outbound = outbound
+		go outbound.Run(context.Background(), 2*time.Second)
+	}
+	signer.costs = NewCostLedger(ParseCostRates(os.Getenv("STS_COST_RATES")))
+	signer.meter = NewUsageMeter()
+	// recovered panics are counted and, w/ STS_CRASH_REPORT_DIR, written down
+	signer.crashes = NewCrashReporter(os.Getenv("STS_CRASH_REPORT_DIR"), notifier, metrics)
+	signer.crashes.Alerts = os.Getenv("STS_CRASH_ALERTS") == "true"
+	if window, err := time.ParseDuration(os.Getenv("STS_IDEMPOTENCY_WINDOW")); err == nil && window > 0 {
+		signer.idempotency = NewIdempotencyCache[TransactionResult](window)
+		signer.keyGenIdempotency = NewIdempotencyCache[Account](window)
+	}
+	// spend counters survive restarts when a path is set
+	if path := os.Getenv("STS_SPEND_LEDGER_PATH"); path != "" {
+		spending, err := NewSpendTracker(path)
+		if err != nil {
+			fatal("Failed to load spend counters", "err", err)
+		}
+		signer.spending = spending
+	}
+	// anomaly detection is on unless STS_ANOMALY_ACTION=off
+	anomalyConfig := DefaultAnomalyConfig()
+	if os.Getenv("STS_ANOMALY_ACTION") != "off" {
+		if anomalyConfig, err = AnomalyConfigFromEnv(os.Getenv); err != nil {
+			fatal("Invalid anomaly settings", "err", err)
+		}
+		signer.anomalies = NewAnomalyDetector(anomalyConfig)
+	}
+	// generated keys come back w/ a signed attestation
+	attester, err := NewAttester(os.Getenv("STS_ATTESTATION_SEED"))
+	if err != nil {
+		fatal("Invalid STS_ATTESTATION_SEED", "err", err)
+	}
+	signer.attester = attester
+	// nonces are always checked when sent, STS_REQUIRE_NONCE makes them mandatory
+	signer.replay = NewReplayGuard(os.Getenv("STS_REQUIRE_NONCE") == "true")
+
+	// operators decide approvals, w/o any the approval routes stay off
+	operators, err := ParseOperators(os.Getenv("STS_ADMIN_TOKENS"))
+	if err != nil {
+		fatal("Invalid STS_ADMIN_TOKENS", "err", err)
+	}
+	// SSO operators are validated against the issuer's published keys
+	oidcConfig, err := OIDCConfigFromEnv(os.Getenv)
+	if err != nil {
+		fatal("Invalid OIDC settings", "err", err)
+	}
+	var oidc *OIDCVerifier
+	if oidcConfig != nil {
+		if oidc, err = NewOIDCVerifier(context.Background(), *oidcConfig); err != nil {
+			fatal("Failed to set up OIDC", "err", err)
+		}
+	}
+	if operators.Len() > 0 || oidc != nil {
+		signer.approvals = NewApprovalQueue()
+		signer.approvals.notifier = notifier
+		signer.approvals.events = events
+	}
+
+	// SOC 2 evidence, STS_COMPLIANCE_REPORT_DIR also gets a report per day
+	compliance := NewComplianceRecorder()
+	store.compliance = compliance
+	signer.compliance = compliance
+	if signer.approvals != nil {
+		signer.approvals.compliance = compliance
+	}
+	if dir := os.Getenv("STS_COMPLIANCE_REPORT_DIR"); dir != "" {
+		go compliance.Run(context.Background(), dir)
+	}
+
+	// encrypted snapshots on a schedule, STS_BACKUP_RESTORE loads one first
+	if path := os.Getenv("STS_BACKUP_RESTORE"); path != "" {
+		key, err := MasterKeyFromEnv(os.Getenv)
+		if err != nil {
+			fatal("Invalid backup key", "err", err)
+		}
+		raw, err := os.ReadFile(path)
+		if err != nil {
+			fatal("Failed to read the backup", "err", err)
+		}
+		restored, err := RestoreBackup(store, raw, key)
+		if err != nil {
+			fatal("Failed to restore the backup", "path", path, "err", err)
+		}
+		audit(context.Background(), logKeystore, "Key store restored from backup", "path", path, "keys", restored)
+	}
+	// STS_FEE_PAYER_KEY_ID pays fees for feePayer requests, under its own policy
+	var feePayer SolanaPubkey
+	if id := os.Getenv("STS_FEE_PAYER_KEY_ID"); id != "" {
+		if feePayer, err = signer.SetFeePayer(id); err != nil {
+			fatal("Invalid STS_FEE_PAYER_KEY_ID", "err", err)
+		}
+	}
+	backups, err := BackupSchedulerFromEnv(store, os.Getenv)
+	if err != nil {
+		fatal("Invalid backup settings", "err", err)
+	}
+	if backups != nil {
+		backups.metrics = metrics
+		go backups.Run(context.Background())
+	}
+
 	server := NewAPIServer(signer)
+	server.Backups = backups
+	server.Analytics = analytics
+	server.Txs = signer.txs
+	server.Outbound = signer.outbound
+	server.Fees = signer.fees
+	if signer.feePayer != "" {
+		server.FeePayer = feePayer.String()
+	}
+	// a nil *KeyBalances must not end up in the stale key analyzer's interface
+	var balances BalanceChecker
+	if signer.rpc != nil {
+		server.Balances = NewKeyBalances(store, signer.rpc)
+		balances = server.Balances
+		// refused once the rpc turns out to serve mainnet
+		server.Airdrops = NewAirdropper(store, signer.rpc)
+	}
+	server.Builder = NewTxBuilder(signer)
+	server.RPC = rpcPool
+	server.Compliance = compliance
+	server.Meter = signer.meter
+	server.Config = config
+	server.Operators = operators
+	server.OIDC = oidc
+	server.Lockout = DefaultAuthLockout()
+	server.Approvals = signer.approvals
+	server.KillSwitch = NewKillSwitch(store, signer.seal)
+	server.Features = features
+	server.KillSwitch.notifier = notifier
+	server.Store = store
+	server.Attester = attester
+	server.Ceremonies = NewCeremonyManager()
+	server.Grants = signer.grants
+	server.Events = events
+	server.Metrics = metrics
+	server.Readiness = []ReadinessCheck{StoreCheck(store), SealCheck(signer.seal)}
+	if signer.rpc != nil {
+		server.Readiness = append(server.Readiness, RPCCheck(signer.rpc))
+	}
+
+	server.Capabilities, err = NewCapabilityIssuer(os.Getenv("STS_CAPABILITY_SECRET"))
+	if err != nil {
+		fatal("Failed to set up capabilities", "err", err)
+	}
+	server.RequireCapability = os.Getenv("STS_REQUIRE_CAPABILITY") == "true"
+	server.Tokens = NewAPITokenStore()
+	server.RequireAPIToken = os.Getenv("STS_REQUIRE_API_TOKEN") == "true"
+
+	limits, err := RequestLimitsFromEnv(os.Getenv)
+	if err != nil {
+		fatal("Invalid rate limits", "err", err)
+	}
+	server.Limiter = NewRequestLimiter(limits)
+
+	// the config file's log level, rate limits and policies reload on SIGHUP
+	if config.File != "" {
+		reloader := &ConfigReloader{
+			Path:         config.File,
+			Limiter:      server.Limiter,
+			Anomalies:    signer.anomalies,
+			BaseLimits:   limits,
+			BaseAnomaly:  anomalyConfig,
+			BaseLevel:    os.Getenv("STS_LOG_LEVEL"),
+			Features:     features,
+			BaseFeatures: featureOverrides,
+		}
+		if err := reloader.Reload(); err != nil {
+			fatal("Invalid config file", "err", err)
+		}
+		hup := make(chan os.Signal, 1)
+		signal.Notify(hup, syscall.SIGHUP)
+		go reloader.Watch(context.Background(), hup)
+	}
+
+	server.HMAC, err = ParseHMACClients(os.Getenv("STS_HMAC_CLIENTS"), os.Getenv("STS_REQUIRE_HMAC") == "true")
+	if err != nil {
+		fatal("Invalid STS_HMAC_CLIENTS", "err", err)
+	}
+
+	// dead man's switch is off unless STS_DEADMAN_HOURS is set
+	if hours, err := strconv.Atoi(os.Getenv("STS_DEADMAN_HOURS")); err == nil && hours > 0 {
+		if !server.adminEnabled() {
+			fatal("STS_DEADMAN_HOURS needs STS_ADMIN_TOKENS or STS_OIDC_ISSUER, nobody could check in")
+		}
+		server.DeadMan = NewDeadManSwitch(store, signer.seal, time.Duration(hours)*time.Hour)
+		server.DeadMan.notifier = notifier
+		go server.DeadMan.Run(context.Background(), time.Minute)
+	}
+	server.ReconnectHint = os.Getenv("STS_RECONNECT_HINT")
+	server.SwaggerUI = os.Getenv("STS_SWAGGER_UI") == "true"
+	if server.CORS, err = CORSPolicyFromEnv(os.Getenv); err != nil {
+		fatal("Invalid CORS settings", "err", err)
+	}
+	if server.TrustedProxies, err = TrustedProxiesFromEnv(os.Getenv); err != nil {
+		fatal("Invalid STS_TRUSTED_PROXIES", "err", err)
+	}
+	server.GRPCAddr = os.Getenv("STS_GRPC_ADDR")
+	server.QUICAddr = os.Getenv("STS_QUIC_ADDR")
+	// profiling is never exposed off the host
+	if server.DiagnosticsAddr = os.Getenv("STS_DIAG_ADDR"); server.DiagnosticsAddr != "" {
+		if err := checkLoopback(server.DiagnosticsAddr); err != nil {
+			fatal("Invalid STS_DIAG_ADDR", "err", err)
+		}
+	}
+	server.Deploys = NewDeployManager(signer, ParseDeployPolicy(os.Getenv("STS_DEPLOY_AUTHORITIES"), os.Getenv("STS_DEPLOY_PAYERS")))
+	server.Deploys.notifier = notifier
+	server.Deploys.seal = signer.seal
+
+	// flag keys idle for STS_STALE_KEY_DAYS (default 90)
+	staleDays, err := strconv.Atoi(os.Getenv("STS_STALE_KEY_DAYS"))
+	if err != nil || staleDays <= 0 {
+		staleDays = 90
+	}
+	server.StaleKeys = NewStaleKeyAnalyzer(store, balances, time.Duration(staleDays)*24*time.Hour, 0)
+	go server.StaleKeys.Run(context.Background(), time.Hour)
+
+	// key material never crosses the network in cleartext unless a developer
+	// explicitly allows it, a socket only sidecar never touches the network
+	tlsSettings, err := TLSSettingsFromEnv(os.Getenv)
+	if err != nil {
+		fatal("Invalid TLS settings", "err", err)
+	}
+	if tlsSettings != nil {
+		var certs *CertReloader
+		if server.TLS, certs, err = tlsSettings.ReloadingConfig(); err != nil {
+			fatal("Invalid TLS settings", "err", err)
+		}
+		// rotated certificates are picked up every STS_TLS_RELOAD_INTERVAL (default 30s)
+		interval, err := time.ParseDuration(os.Getenv("STS_TLS_RELOAD_INTERVAL"))
+		if err != nil || interval <= 0 {
+			interval = 30 * time.Second
+		}
+		go certs.Run(context.Background(), interval)
+	} else if config.Addr != AddrOff && os.Getenv("STS_ALLOW_PLAINTEXT") != "true" {
+		fatal("STS_TLS_CERT and STS_TLS_KEY are required, set STS_ALLOW_PLAINTEXT=true for local development")
+	}
+	// client certificate identities become principals for audit and approvals
+	if server.Principals, err = ParsePrincipalMap(os.Getenv("STS_MTLS_PRINCIPALS")); err != nil {
+		fatal("Invalid STS_MTLS_PRINCIPALS", "err", err)
+	}
+
+	// SIGUSR2 hands the listeners to a new process of this binary, so a deploy
+	// never leaves the address unanswered
+	if sigs := upgradeSignals(); len(sigs) > 0 {
+		upgrades := make(chan os.Signal, 1)
+		signal.Notify(upgrades, sigs...)
+		server.Upgrades = upgrades
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGTERM, os.Interrupt)
+	defer stop()
+
+	runErr := server.Run(ctx)
 
-	server.Run()
+	// flush the spans of the last requests
+	flushCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := stopTracing(flushCtx); err != nil {
+		slog.Error("Failed to flush traces", logServer, "err", err)
+	}
+	if err := audits.Close(flushCtx); err != nil {
+		slog.Error("Failed to flush audit records", logAudit, "err", err)
+	}
+	if runErr != nil {
+		fatal("Secure Signer Service failed", "err", runErr)
+	}
+	slog.Info("Secure Signer Service stopped", logServer)
 }
@@ -1,13 +1,62 @@
 package main
 
 import (
+	"bufio"
+	"bytes"
 	"context"
+	"crypto"
+	"crypto/ecdsa"
 	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/sha512"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
 	"encoding/base64"
+	"encoding/binary"
+	"encoding/csv"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
 	"errors"
 	"fmt"
+	"io"
+	"log/slog"
+	"math"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"slices"
+	"strconv"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"testing"
+	"time"
+
+	"github.com/quic-go/quic-go/http3"
+	"github.com/yourusername/sts-svc/signerpb"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"golang.org/x/net/websocket"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
 )
 
 func TestSecureKeyStore_Concurrency(t *testing.T) {
@@ -111,7 +160,7 @@ func (c *CrashingSignerSvc) SignTransaction(ctx context.Context, req Transaction
 	return TransactionResult{KeyID: req.KeyID, BroadcastStatus: "OK"}, nil
 }
 
-func (c *CrashingSignerSvc) GenerateKey(ctx context.Context) (Account, error) {
+func (c *CrashingSignerSvc) GenerateKey(ctx context.Context, req KeyGenRequest) (Account, error) {
 	return Account{PublicKey: "MOCK_KEY"}, nil
 }
 
@@ -144,3 +193,6338 @@ func TestSignTransaction_PanicRecovery(t *testing.T) {
 
 	t.Log("Panic successfully recovered and converted into a safe, generic error response.")
 }
+
+func TestStreamDrainer_GoAway(t *testing.T) {
+	drainer := NewStreamDrainer()
+
+	notices, done := drainer.Register()
+
+	drainer.Drain(GoAway{Reason: "shutdown", ReconnectTo: "replica-2:8080"})
+
+	select {
+	case n := <-notices:
+		if n.ReconnectTo != "replica-2:8080" {
+			t.Errorf("Reconnect hint mismatch. Got: %s", n.ReconnectTo)
+		}
+	default:
+		t.Fatalf("Expected open stream to receive going-away notice")
+	}
+
+	// streams opened after drain starts are told to leave right away
+	late, _ := drainer.Register()
+	if len(late) != 1 {
+		t.Errorf("Expected late stream to get notice immediately")
+	}
+
+	done()
+	if err := drainer.Wait(context.Background()); err != nil {
+		t.Errorf("Wait failed w/ error: %v", err)
+	}
+}
+
+func TestVerifySignature_Ed25519(t *testing.T) {
+	svc := NewSignerService(NewSecureKeyStore())
+
+	pubKey, privKey, _ := ed25519.GenerateKey(nil)
+	msg := []byte("tx-data")
+	sig := ed25519.Sign(privKey, msg)
+
+	req := VerifyRequest{
+		PublicKey: hex.EncodeToString(pubKey),
+		Message:   base64.StdEncoding.EncodeToString(msg),
+		Signature: base64.StdEncoding.EncodeToString(sig),
+	}
+
+	res, err := svc.VerifySignature(context.Background(), req)
+	if err != nil {
+		t.Fatalf("Verify failed w/ error: %v", err)
+	}
+	if !res.Valid {
+		t.Errorf("Expected valid signature")
+	}
+
+	// tamper w/ message
+	req.Message = base64.StdEncoding.EncodeToString([]byte("other-data"))
+	res, err = svc.VerifySignature(context.Background(), req)
+	if err != nil {
+		t.Fatalf("Verify failed w/ error: %v", err)
+	}
+	if res.Valid {
+		t.Errorf("Expected tampered message to fail verification")
+	}
+}
+
+func TestSignTransaction_Ed25519ph(t *testing.T) {
+	store := NewSecureKeyStore()
+	svc := NewSignerService(store)
+
+	acc, err := svc.GenerateKey(context.Background(), KeyGenRequest{})
+	if err != nil {
+		t.Fatalf("Failed to generate key err: %v", err)
+	}
+
+	digest := sha512.Sum512([]byte("large-program-payload"))
+	res, err := svc.SignTransaction(context.Background(), TransactionRequest{
+		KeyID:          acc.PublicKey,
+		UnsignedTxData: base64.StdEncoding.EncodeToString(digest[:]),
+		SigningMode:    SigningModeEd25519ph,
+		Context:        "program-deploy",
+	})
+	if err != nil {
+		t.Fatalf("Signing failed w/ error: %v", err)
+	}
+	if res.SigningMode != SigningModeEd25519ph {
+		t.Errorf("Signing mode mismatch. Got: %s", res.SigningMode)
+	}
+
+	verified, err := svc.VerifySignature(context.Background(), VerifyRequest{
+		PublicKey:   acc.PublicKey,
+		Message:     base64.StdEncoding.EncodeToString(digest[:]),
+		Signature:   res.Signature,
+		SigningMode: SigningModeEd25519ph,
+		Context:     "program-deploy",
+	})
+	if err != nil || !verified.Valid {
+		t.Errorf("Expected ed25519ph signature to verify, err: %v", err)
+	}
+
+	// the same signature must not pass as pure ed25519
+	verified, _ = svc.VerifySignature(context.Background(), VerifyRequest{
+		PublicKey: acc.PublicKey,
+		Message:   base64.StdEncoding.EncodeToString(digest[:]),
+		Signature: res.Signature,
+		Context:   "program-deploy",
+	})
+	if verified.Valid {
+		t.Errorf("ed25519ph signature should not verify as ed25519ctx")
+	}
+}
+
+func TestSignTransaction_ContextSeparation(t *testing.T) {
+	svc := NewSignerService(NewSecureKeyStore())
+
+	acc, _ := svc.GenerateKey(context.Background(), KeyGenRequest{})
+	txData := base64.StdEncoding.EncodeToString([]byte("tx-data"))
+
+	// context is required
+	_, err := svc.SignTransaction(context.Background(), TransactionRequest{KeyID: acc.PublicKey, UnsignedTxData: txData})
+	if err == nil {
+		t.Fatalf("Expected signing w/o context to fail")
+	}
+
+	res, err := svc.SignTransaction(context.Background(), TransactionRequest{
+		KeyID:          acc.PublicKey,
+		UnsignedTxData: txData,
+		Context:        "payout",
+	})
+	if err != nil {
+		t.Fatalf("Signing failed w/ error: %v", err)
+	}
+	if res.SigningMode != SigningModeEd25519ctx {
+		t.Errorf("Signing mode mismatch. Got: %s", res.SigningMode)
+	}
+
+	for ctxStr, want := range map[string]bool{"payout": true, "login": false} {
+		verified, err := svc.VerifySignature(context.Background(), VerifyRequest{
+			PublicKey: acc.PublicKey,
+			Message:   txData,
+			Signature: res.Signature,
+			Context:   ctxStr,
+		})
+		if err != nil {
+			t.Fatalf("Verify failed w/ error: %v", err)
+		}
+		if verified.Valid != want {
+			t.Errorf("Context %q: valid = %v, wanted %v", ctxStr, verified.Valid, want)
+		}
+	}
+}
+
+func TestCostLedger_PerTenant(t *testing.T) {
+	ledger := NewCostLedger(ParseCostRates("signer.sign=0.5, bogus"))
+
+	teamA := WithTenant(context.Background(), "team-a")
+	ledger.Record(teamA, CostSign)
+	ledger.Record(teamA, CostSign)
+	ledger.Record(context.Background(), CostKeyGen)
+
+	report := ledger.Report("team-a")
+	if len(report) != 1 {
+		t.Fatalf("Expected one tenant in report, got %d", len(report))
+	}
+	if report[0].TotalCost != 1.0 {
+		t.Errorf("Total cost mismatch. Got: %v, Wanted: 1.0", report[0].TotalCost)
+	}
+
+	if all := ledger.Report(""); len(all) != 2 {
+		t.Errorf("Expected default and team-a tenants, got %d", len(all))
+	}
+}
+
+func TestDeployManager_ChunkedDeploy(t *testing.T) {
+	store := NewSecureKeyStore()
+
+	_, payerKey, _ := ed25519.GenerateKey(nil)
+	authorityPub, authorityKey, _ := ed25519.GenerateKey(nil)
+	payerID := hex.EncodeToString(payerKey.Public().(ed25519.PublicKey))
+	authorityID := hex.EncodeToString(authorityPub)
+	store.Store(payerID, payerKey)
+	// start, two writes and the deploy
+	store.StoreWithPolicy(authorityID, authorityKey, KeyPolicy{Usage: UsagePersistent, RateLimit: &KeyRateLimit{Signatures: 4, Per: "1h"}})
+
+	program := make([]byte, maxDeployChunkSize+100)
+	digest := sha256.Sum256(program)
+	blockhash := SolanaAddress(authorityPub).String()
+
+	svc := NewSignerService(store)
+	deploys := NewDeployManager(svc, ParseDeployPolicy(authorityID, payerID))
+	ctx := context.Background()
+
+	// payer is not an approved authority
+	_, _, err := deploys.Start(ctx, DeployStartRequest{PayerKeyID: payerID, AuthorityKeyID: payerID, ProgramSize: len(program), ProgramSHA256: hex.EncodeToString(digest[:]), RecentBlockhash: blockhash})
+	if !errors.Is(err, errDeployNotAuthorized) {
+		t.Fatalf("Expected unapproved authority to be rejected, got: %v", err)
+	}
+
+	// authority is not an approved payer
+	_, _, err = deploys.Start(ctx, DeployStartRequest{PayerKeyID: authorityID, AuthorityKeyID: authorityID, ProgramSize: len(program), ProgramSHA256: hex.EncodeToString(digest[:]), RecentBlockhash: blockhash})
+	if !errors.Is(err, errDeployPayer) {
+		t.Fatalf("Expected unapproved payer to be rejected, got: %v", err)
+	}
+
+	// nothing is signed while the service is sealed
+	deploys.seal = NewSealState()
+	deploys.seal.Seal("", SealCauseMaintenance, "upgrade window", "alice")
+	_, _, err = deploys.Start(ctx, DeployStartRequest{PayerKeyID: payerID, AuthorityKeyID: authorityID, ProgramSize: len(program), ProgramSHA256: hex.EncodeToString(digest[:]), RecentBlockhash: blockhash})
+	if !errors.Is(err, errSealed) {
+		t.Fatalf("Expected sealed deploy to be refused, got: %v", err)
+	}
+	deploys.seal.Unseal("", "alice")
+
+	session, _, err := deploys.Start(ctx, DeployStartRequest{PayerKeyID: payerID, AuthorityKeyID: authorityID, ProgramSize: len(program), ProgramSHA256: hex.EncodeToString(digest[:]), RecentBlockhash: blockhash})
+	if err != nil {
+		t.Fatalf("Failed to start deploy err: %v", err)
+	}
+
+	// the routes need an operator
+	server := NewAPIServer(svc)
+	server.Operators, _ = ParseOperators("alice:tok")
+	server.Deploys = deploys
+	w := httptest.NewRecorder()
+	server.routes().ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/api/v1/deploys/"+session.ID, nil))
+	if w.Code != http.StatusUnauthorized {
+		t.Errorf("Expected anonymous deploy lookup to be refused, got %d", w.Code)
+	}
+
+	// finalize before the buffer is complete must fail
+	if _, err := deploys.Finalize(ctx, session.ID, DeployStepRequest{RecentBlockhash: blockhash}); err == nil {
+		t.Fatalf("Expected finalize of incomplete buffer to fail")
+	}
+
+	for offset := 0; offset < len(program); offset += maxDeployChunkSize {
+		end := min(offset+maxDeployChunkSize, len(program))
+		step, err := deploys.Write(ctx, session.ID, DeployWriteRequest{
+			Offset:          offset,
+			Data:            base64.StdEncoding.EncodeToString(program[offset:end]),
+			RecentBlockhash: blockhash,
+		})
+		if err != nil {
+			t.Fatalf("Write at %d failed w/ error: %v", offset, err)
+		}
+
+		tx, _ := base64.StdEncoding.DecodeString(step.Transaction)
+		if len(tx) > solanaMaxTxSize {
+			t.Errorf("Write tx is %d bytes, over the cluster limit", len(tx))
+		}
+	}
+
+	// out of order write is refused
+	if _, err := deploys.Write(ctx, session.ID, DeployWriteRequest{Offset: 1, Data: base64.StdEncoding.EncodeToString([]byte{1}), RecentBlockhash: blockhash}); err == nil {
+		t.Errorf("Expected conflicting rewrite to fail")
+	}
+
+	step, err := deploys.Finalize(ctx, session.ID, DeployStepRequest{RecentBlockhash: blockhash})
+	if err != nil {
+		t.Fatalf("Finalize failed w/ error: %v", err)
+	}
+	if step.Step != "deploy" {
+		t.Errorf("Step mismatch. Got: %s", step.Step)
+	}
+
+	final, _ := deploys.Get(session.ID)
+	if final.Status != DeployFinalized || final.ProgramID == "" {
+		t.Errorf("Expected finalized session w/ program id, got %+v", final)
+	}
+
+	// the buffer and program keypairs are gone once they signed
+	if store.Len() != 2 {
+		t.Errorf("Expected only the payer and authority kept, got %d keys", store.Len())
+	}
+
+	// every step took a use of both keys, and the authority's rate limit
+	for _, keyID := range []string{payerID, authorityID} {
+		if info, _ := store.Info(keyID); info.Uses != 4 || info.LastUsedAt.IsZero() {
+			t.Errorf("Expected 4 uses of %s, got %+v", keyID, info)
+		}
+	}
+	_, _, err = deploys.Start(ctx, DeployStartRequest{PayerKeyID: payerID, AuthorityKeyID: authorityID, ProgramSize: len(program), ProgramSHA256: hex.EncodeToString(digest[:]), RecentBlockhash: blockhash})
+	if !errors.Is(err, errRateLimited) || deployErrorStatus(err) != http.StatusTooManyRequests {
+		t.Errorf("Expected the authority's rate limit to refuse another deploy, got %v", err)
+	}
+}
+
+func TestDecodeSolanaTransfers_SeedLength(t *testing.T) {
+	from, base, owner := SolanaPubkey{1}, SolanaPubkey{2}, SolanaPubkey{3}
+	account, _ := CreateWithSeed(base, "seed", owner)
+
+	tests := []struct {
+		name    string
+		seedLen uint64
+		wantErr bool
+	}{
+		{"valid", 4, false},
+		{"negative as int", 1 << 63, true},
+		{"max u64", math.MaxUint64, true},
+		{"past the data", 1000, true},
+		{"no room for the amount", 52, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ix := SystemCreateAccountWithSeedIx(from, account, base, "seed", 77, 0, owner)
+			binary.LittleEndian.PutUint64(ix.Data[36:44], tt.seedLen)
+			raw, _ := CompileSolanaMessage(from, SolanaPubkey{4}, []SolanaInstruction{ix})
+			msg, err := ParseSolanaMessage(raw)
+			if err != nil {
+				t.Fatalf("Failed to parse message: %v", err)
+			}
+
+			transfers, err := DecodeSolanaTransfers(msg)
+			if tt.wantErr {
+				if !errors.Is(err, errSolanaMalformed) {
+					t.Errorf("Expected a malformed instruction, got %v %+v", err, transfers)
+				}
+				return
+			}
+			if err != nil || len(transfers) != 1 || transfers[0].Amount != 77 || transfers[0].Destination != account {
+				t.Errorf("Unexpected transfers %+v err: %v", transfers, err)
+			}
+		})
+	}
+}
+
+func TestSignTransaction_AuthorityHandOff(t *testing.T) {
+	svc := NewSignerService(NewSecureKeyStore())
+	treasury, attacker := SolanaPubkey{7}, SolanaPubkey{9}
+
+	allowlisted, _ := svc.GenerateKey(context.Background(), KeyGenRequest{Policy: &KeyPolicy{Usage: UsagePersistent, AllowedDestinations: []string{treasury.String()}}})
+	limited, _ := svc.GenerateKey(context.Background(), KeyGenRequest{Policy: &KeyPolicy{Usage: UsagePersistent, SpendingLimits: []SpendingLimit{{Window: "24h", Max: 1000}}}})
+
+	withSeed := func(data []byte, base SolanaPubkey) []byte {
+		data = append(data, base[:]...)
+		data = binary.LittleEndian.AppendUint64(data, 4)
+		return append(data, "seed"...)
+	}
+	// each hands an account under the key to a new owner or authority
+	handOffs := map[string]func(key, to SolanaPubkey) SolanaInstruction{
+		"token SetAuthority": func(key, to SolanaPubkey) SolanaInstruction {
+			return SolanaInstruction{ProgramID: TokenProgramID, Accounts: []SolanaAccountMeta{{Pubkey: SolanaPubkey{1}, IsWritable: true}, {Pubkey: key, IsSigner: true}}, Data: append([]byte{6, 2, 1}, to[:]...)}
+		},
+		"system Assign": func(key, to SolanaPubkey) SolanaInstruction {
+			return SolanaInstruction{ProgramID: SystemProgramID, Accounts: []SolanaAccountMeta{{Pubkey: key, IsSigner: true, IsWritable: true}}, Data: append(binary.LittleEndian.AppendUint32(nil, 1), to[:]...)}
+		},
+		"system AssignWithSeed": func(key, to SolanaPubkey) SolanaInstruction {
+			account, _ := CreateWithSeed(key, "seed", SystemProgramID)
+			data := withSeed(binary.LittleEndian.AppendUint32(nil, 10), key)
+			return SolanaInstruction{ProgramID: SystemProgramID, Accounts: []SolanaAccountMeta{{Pubkey: account, IsWritable: true}, {Pubkey: key, IsSigner: true}}, Data: append(data, to[:]...)}
+		},
+		"system AuthorizeNonceAccount": func(key, to SolanaPubkey) SolanaInstruction {
+			return SolanaInstruction{ProgramID: SystemProgramID, Accounts: []SolanaAccountMeta{{Pubkey: SolanaPubkey{1}, IsWritable: true}, {Pubkey: key, IsSigner: true}}, Data: append(binary.LittleEndian.AppendUint32(nil, 7), to[:]...)}
+		},
+		"stake Authorize": func(key, to SolanaPubkey) SolanaInstruction {
+			data := append(binary.LittleEndian.AppendUint32(nil, stakeAuthorize), to[:]...)
+			return SolanaInstruction{ProgramID: StakeProgramID, Accounts: []SolanaAccountMeta{{Pubkey: SolanaPubkey{1}, IsWritable: true}, {Pubkey: SysvarClockID}, {Pubkey: key, IsSigner: true}}, Data: binary.LittleEndian.AppendUint32(data, 1)}
+		},
+		"stake AuthorizeWithSeed": func(key, to SolanaPubkey) SolanaInstruction {
+			data := binary.LittleEndian.AppendUint32(append(binary.LittleEndian.AppendUint32(nil, stakeAuthorizeWithSeed), to[:]...), 1)
+			data = append(binary.LittleEndian.AppendUint64(data, 4), "seed"...)
+			return SolanaInstruction{ProgramID: StakeProgramID, Accounts: []SolanaAccountMeta{{Pubkey: SolanaPubkey{1}, IsWritable: true}, {Pubkey: key, IsSigner: true}, {Pubkey: SysvarClockID}}, Data: append(data, SystemProgramID[:]...)}
+		},
+		"stake AuthorizeChecked": func(key, to SolanaPubkey) SolanaInstruction {
+			return SolanaInstruction{ProgramID: StakeProgramID, Accounts: []SolanaAccountMeta{{Pubkey: SolanaPubkey{1}, IsWritable: true}, {Pubkey: SysvarClockID}, {Pubkey: key, IsSigner: true}, {Pubkey: to, IsSigner: true}}, Data: binary.LittleEndian.AppendUint32(binary.LittleEndian.AppendUint32(nil, stakeAuthorizeChecked), 1)}
+		},
+	}
+
+	sign := func(acc Account, handOff func(key, to SolanaPubkey) SolanaInstruction, to SolanaPubkey) error {
+		pub, _ := hex.DecodeString(acc.PublicKey)
+		key := SolanaAddress(pub)
+		msg, _ := CompileSolanaMessage(key, SystemProgramID, []SolanaInstruction{handOff(key, to)})
+		_, err := svc.SignTransaction(context.Background(), TransactionRequest{KeyID: acc.PublicKey, UnsignedTxData: base64.StdEncoding.EncodeToString(msg), Context: SolanaTxContext})
+		return err
+	}
+
+	for name, handOff := range handOffs {
+		t.Run(name, func(t *testing.T) {
+			key := SolanaPubkey{3}
+			raw, _ := CompileSolanaMessage(key, SystemProgramID, []SolanaInstruction{handOff(key, attacker)})
+			msg, _ := ParseSolanaMessage(raw)
+			transfers, err := DecodeSolanaTransfers(msg)
+			if err != nil || len(transfers) != 1 || transfers[0].Kind != TransferAuthority || transfers[0].Destination != attacker || transfers[0].Authority != key {
+				t.Fatalf("Expected a hand off to the attacker, got %+v err: %v", transfers, err)
+			}
+			// what capability amount caveats are checked w/
+			if _, err := SpendsFor(msg, key, true); err == nil {
+				t.Error("Expected the hand off not to count as a bounded spend")
+			}
+
+			if err := sign(allowlisted, handOff, attacker); !errors.Is(err, errPolicyViolation) {
+				t.Errorf("Expected a hand off outside the allowlist to be refused, got: %v", err)
+			}
+			if err := sign(allowlisted, handOff, treasury); err != nil {
+				t.Errorf("Expected a hand off to the treasury to be allowed, got: %v", err)
+			}
+			if err := sign(limited, handOff, attacker); !errors.Is(err, errPolicyViolation) {
+				t.Errorf("Expected a key w/ a spending limit to refuse the hand off, got: %v", err)
+			}
+		})
+	}
+}
+
+func TestSolana_AddressDerivation(t *testing.T) {
+	if got := SystemProgramID.String(); got != "11111111111111111111111111111111" {
+		t.Errorf("Base58 round trip mismatch. Got: %s", got)
+	}
+
+	pub, _, _ := ed25519.GenerateKey(nil)
+	if !isOnCurve(SolanaAddress(pub)) {
+		t.Errorf("Expected ed25519 public key to be on curve")
+	}
+
+	pda, _, err := FindProgramAddress([][]byte{pub}, BPFLoaderUpgradeableID)
+	if err != nil {
+		t.Fatalf("Failed to derive program address err: %v", err)
+	}
+	if isOnCurve(pda) {
+		t.Errorf("Program derived address must be off curve")
+	}
+}
+
+func TestSignTransaction_UsagePolicies(t *testing.T) {
+	svc := NewSignerService(NewSecureKeyStore())
+	txData := base64.StdEncoding.EncodeToString([]byte("tx-data"))
+
+	tests := []struct {
+		name       string
+		policy     KeyPolicy
+		signs      int
+		destroyed  bool
+		extraFails bool
+	}{
+		{"single-use", KeyPolicy{Usage: UsageSingleUse}, 1, true, true},
+		{"max-uses", KeyPolicy{Usage: UsageMaxUses, MaxUses: 3}, 3, true, true},
+		{"persistent", KeyPolicy{Usage: UsagePersistent}, 5, false, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			acc, err := svc.GenerateKey(context.Background(), KeyGenRequest{Policy: &tt.policy})
+			if err != nil {
+				t.Fatalf("Failed to generate key err: %v", err)
+			}
+
+			req := TransactionRequest{KeyID: acc.PublicKey, UnsignedTxData: txData, Context: "payout"}
+
+			var res TransactionResult
+			for i := 0; i < tt.signs; i++ {
+				res, err = svc.SignTransaction(context.Background(), req)
+				if err != nil {
+					t.Fatalf("Signature %d failed w/ error: %v", i+1, err)
+				}
+			}
+			if res.KeyDestroyed != tt.destroyed {
+				t.Errorf("KeyDestroyed = %v, wanted %v", res.KeyDestroyed, tt.destroyed)
+			}
+
+			_, err = svc.SignTransaction(context.Background(), req)
+			if (err != nil) != tt.extraFails {
+				t.Errorf("Extra signature err = %v, wanted failure: %v", err, tt.extraFails)
+			}
+		})
+	}
+
+	if _, err := svc.GenerateKey(context.Background(), KeyGenRequest{Policy: &KeyPolicy{Usage: UsageMaxUses}}); err == nil {
+		t.Errorf("Expected max-uses w/o maxUses to be rejected")
+	}
+}
+
+type fixedBalances map[string]uint64
+
+func (f fixedBalances) Balance(ctx context.Context, keyID string) (uint64, error) {
+	return f[keyID], nil
+}
+
+func TestStaleKeyAnalyzer_Recommendations(t *testing.T) {
+	store := NewSecureKeyStore()
+	store.Store("funded", ed25519.PrivateKey([]byte("funded-key")))
+	store.Store("dust", ed25519.PrivateKey([]byte("dust-key")))
+
+	// zero idle window makes every key stale
+	analyzer := NewStaleKeyAnalyzer(store, fixedBalances{"funded": 5_000_000, "dust": 10}, 0, 1_000_000)
+
+	report := analyzer.Analyze(context.Background())
+	if len(report.Recommendations) != 1 {
+		t.Fatalf("Expected one recommendation, got %d", len(report.Recommendations))
+	}
+
+	rec := report.Recommendations[0]
+	if rec.KeyID != "funded" || rec.BalanceLamports == nil || *rec.BalanceLamports != 5_000_000 {
+		t.Errorf("Unexpected recommendation: %+v", rec)
+	}
+	if len(rec.Actions) != 2 || rec.Actions[0] != "sweep" {
+		t.Errorf("Expected sweep then disable, got %v", rec.Actions)
+	}
+}
+
+func TestSignTransaction_Idempotency(t *testing.T) {
+	svc := NewSignerService(NewSecureKeyStore())
+
+	// single-use key, a second real signature would fail
+	acc, _ := svc.GenerateKey(context.Background(), KeyGenRequest{})
+	req := TransactionRequest{
+		KeyID:          acc.PublicKey,
+		UnsignedTxData: base64.StdEncoding.EncodeToString([]byte("tx-data")),
+		Context:        "payout",
+		IdempotencyKey: "retry-me",
+	}
+
+	first, err := svc.SignTransaction(context.Background(), req)
+	if err != nil {
+		t.Fatalf("Signing failed w/ error: %v", err)
+	}
+
+	retry, err := svc.SignTransaction(context.Background(), req)
+	if err != nil {
+		t.Fatalf("Retry failed w/ error: %v", err)
+	}
+	if retry.Signature != first.Signature {
+		t.Errorf("Retry returned a different signature")
+	}
+}
+
+type recordingNotifier struct {
+	sent chan Notification
+}
+
+func (r *recordingNotifier) Name() string { return "recording" }
+
+func (r *recordingNotifier) Notify(ctx context.Context, n Notification) error {
+	r.sent <- n
+	return nil
+}
+
+func TestNotificationDispatcher_SeverityRouting(t *testing.T) {
+	pager := &recordingNotifier{sent: make(chan Notification, 1)}
+	email := &recordingNotifier{sent: make(chan Notification, 1)}
+
+	dispatcher := NewNotificationDispatcher()
+	dispatcher.Add(pager, SeverityCritical)
+	dispatcher.Add(email, SeverityInfo)
+
+	dispatcher.Dispatch(Notification{Kind: NotifyApprovalRequest, Severity: SeverityInfo, Title: "approve me"})
+
+	select {
+	case n := <-email.sent:
+		if n.Title != "approve me" {
+			t.Errorf("Title mismatch. Got: %s", n.Title)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("Expected info notification on email channel")
+	}
+
+	select {
+	case <-pager.sent:
+		t.Errorf("Info notification should not page")
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+func TestWebhookNotifier_SignsBody(t *testing.T) {
+	var gotSig string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotSig = r.Header.Get("X-STS-Signature")
+	}))
+	defer srv.Close()
+
+	hook := &WebhookNotifier{URL: srv.URL, Secret: "s3cret"}
+	if err := hook.Notify(context.Background(), Notification{Title: "alert"}); err != nil {
+		t.Fatalf("Webhook failed w/ error: %v", err)
+	}
+	if len(gotSig) != len("sha256=")+64 {
+		t.Errorf("Expected sha256 signature header, got %q", gotSig)
+	}
+}
+
+func TestBIP340_Vectors(t *testing.T) {
+	// BIP-340 test vector 0
+	pubKey, _ := hex.DecodeString("F9308A019258C31049344F85F89D5229B531C845836F99B08601F113BCE036F9")
+	msg := make([]byte, 32)
+	sig, _ := hex.DecodeString("E907831F80848D1069A5371B402410364BDF1C5F8307B0084C55F1CE2DCA821525F66A4A85EA8B71E482A74F382D2CE5EBEEE8FDB2172F477DF4900D310536C0")
+
+	valid, err := verifyBIP340(pubKey, msg, sig)
+	if err != nil || !valid {
+		t.Errorf("Expected BIP-340 vector to verify, err: %v", err)
+	}
+
+	sig[0] ^= 0xff
+	if valid, _ := verifyBIP340(pubKey, msg, sig); valid {
+		t.Errorf("Tampered signature should not verify")
+	}
+}
+
+func TestSignTransaction_Secp256k1Schnorr(t *testing.T) {
+	svc := NewSignerService(NewSecureKeyStore())
+
+	acc, err := svc.GenerateKey(context.Background(), KeyGenRequest{KeyType: KeyTypeSecp256k1})
+	if err != nil {
+		t.Fatalf("Failed to generate key err: %v", err)
+	}
+
+	digest := sha256.Sum256([]byte("taproot-sighash"))
+	res, err := svc.SignTransaction(context.Background(), TransactionRequest{
+		KeyID:          acc.PublicKey,
+		UnsignedTxData: base64.StdEncoding.EncodeToString(digest[:]),
+		Context:        "taproot-spend",
+	})
+	if err != nil {
+		t.Fatalf("Signing failed w/ error: %v", err)
+	}
+	if res.SigningMode != SigningModeBIP340 {
+		t.Errorf("Signing mode mismatch. Got: %s", res.SigningMode)
+	}
+
+	verified, err := svc.VerifySignature(context.Background(), VerifyRequest{
+		KeyType:   KeyTypeSecp256k1,
+		PublicKey: acc.PublicKey,
+		Message:   base64.StdEncoding.EncodeToString(digest[:]),
+		Signature: res.Signature,
+	})
+	if err != nil || !verified.Valid {
+		t.Errorf("Expected schnorr signature to verify, err: %v", err)
+	}
+}
+
+func TestSignTransaction_P256(t *testing.T) {
+	svc := NewSignerService(NewSecureKeyStore())
+	artifact := base64.StdEncoding.EncodeToString([]byte("release-manifest"))
+
+	acc, err := svc.GenerateKey(context.Background(), KeyGenRequest{
+		KeyType: KeyTypeP256,
+		Policy:  &KeyPolicy{Usage: UsagePersistent},
+	})
+	if err != nil {
+		t.Fatalf("Failed to generate key err: %v", err)
+	}
+
+	for _, mode := range []string{SigningModeP256DER, SigningModeP256Raw} {
+		res, err := svc.SignTransaction(context.Background(), TransactionRequest{
+			KeyID:          acc.PublicKey,
+			UnsignedTxData: artifact,
+			SigningMode:    mode,
+			Context:        "artifact-signing",
+		})
+		if err != nil {
+			t.Fatalf("%s signing failed w/ error: %v", mode, err)
+		}
+
+		verified, err := svc.VerifySignature(context.Background(), VerifyRequest{
+			KeyType:     KeyTypeP256,
+			PublicKey:   acc.PublicKey,
+			Message:     artifact,
+			Signature:   res.Signature,
+			SigningMode: mode,
+		})
+		if err != nil || !verified.Valid {
+			t.Errorf("Expected %s signature to verify, err: %v", mode, err)
+		}
+	}
+}
+
+func TestSignTransaction_SolanaBroadcast(t *testing.T) {
+	rpc := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var call struct {
+			Method string `json:"method"`
+		}
+		json.NewDecoder(r.Body).Decode(&call)
+
+		switch call.Method {
+		case "sendTransaction":
+			fmt.Fprint(w, `{"jsonrpc":"2.0","id":1,"result":"5wHu1qwD"}`)
+		case "getSignatureStatuses":
+			fmt.Fprint(w, `{"jsonrpc":"2.0","id":1,"result":{"value":[{"slot":4242,"err":null}]}}`)
+		}
+	}))
+	defer rpc.Close()
+
+	svc := NewSignerService(NewSecureKeyStore())
+	svc.rpc = NewSolanaRPC(rpc.URL, 0)
+
+	acc, _ := svc.GenerateKey(context.Background(), KeyGenRequest{Policy: &KeyPolicy{Usage: UsagePersistent}})
+	pub, _ := hex.DecodeString(acc.PublicKey)
+	payer := SolanaAddress(pub)
+
+	msg, _ := CompileSolanaMessage(payer, SystemProgramID, []SolanaInstruction{
+		SystemCreateAccountIx(payer, payer, 1, 0, SystemProgramID),
+	})
+
+	// broadcast outside the solana context is refused
+	_, err := svc.SignTransaction(context.Background(), TransactionRequest{
+		KeyID:          acc.PublicKey,
+		UnsignedTxData: base64.StdEncoding.EncodeToString(msg),
+		Context:        "payout",
+		Broadcast:      true,
+	})
+	if err == nil {
+		t.Fatalf("Expected broadcast w/o solana-tx context to fail")
+	}
+
+	res, err := svc.SignTransaction(context.Background(), TransactionRequest{
+		KeyID:          acc.PublicKey,
+		UnsignedTxData: base64.StdEncoding.EncodeToString(msg),
+		Context:        SolanaTxContext,
+		Broadcast:      true,
+	})
+	if err != nil {
+		t.Fatalf("Sign and broadcast failed w/ error: %v", err)
+	}
+	if res.TxSignature != "5wHu1qwD" || res.Slot != 4242 {
+		t.Errorf("Unexpected broadcast result: %+v", res)
+	}
+
+	// the cluster verifies pure ed25519 over the message
+	sig, _ := base64.StdEncoding.DecodeString(res.Signature)
+	if !ed25519.Verify(pub, msg, sig) {
+		t.Errorf("Solana signature must be pure ed25519 over the message")
+	}
+}
+
+func TestSignTransaction_SimulationRefusal(t *testing.T) {
+	rpc := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `{"jsonrpc":"2.0","id":1,"result":{"value":{"err":{"InstructionError":[0,{"Custom":1}]},"logs":["Program 11111111111111111111111111111111 failed: insufficient lamports"]}}}`)
+	}))
+	defer rpc.Close()
+
+	store := NewSecureKeyStore()
+	svc := NewSignerService(store)
+	svc.rpc = NewSolanaRPC(rpc.URL, 0)
+
+	acc, _ := svc.GenerateKey(context.Background(), KeyGenRequest{})
+	pub, _ := hex.DecodeString(acc.PublicKey)
+	payer := SolanaAddress(pub)
+	msg, _ := CompileSolanaMessage(payer, SystemProgramID, []SolanaInstruction{
+		SystemCreateAccountIx(payer, payer, 1, 0, SystemProgramID),
+	})
+
+	res, err := svc.SignTransaction(context.Background(), TransactionRequest{
+		KeyID:          acc.PublicKey,
+		UnsignedTxData: base64.StdEncoding.EncodeToString(msg),
+		Context:        SolanaTxContext,
+		Simulate:       true,
+	})
+	if err == nil {
+		t.Fatalf("Expected failing simulation to block signing")
+	}
+	if len(res.SimulationLogs) != 1 || res.Signature != "" {
+		t.Errorf("Expected logs and no signature, got %+v", res)
+	}
+
+	// single-use key must not have been spent
+	if _, err := store.Get(acc.PublicKey); err != nil {
+		t.Errorf("Key use was consumed by a refused signature: %v", err)
+	}
+}
+
+func TestSignTransaction_ProgramAllowlist(t *testing.T) {
+	svc := NewSignerService(NewSecureKeyStore())
+
+	acc, err := svc.GenerateKey(context.Background(), KeyGenRequest{Policy: &KeyPolicy{
+		Usage:           UsagePersistent,
+		AllowedPrograms: []string{SystemProgramID.String()},
+	}})
+	if err != nil {
+		t.Fatalf("Failed to generate key err: %v", err)
+	}
+	pub, _ := hex.DecodeString(acc.PublicKey)
+	payer := SolanaAddress(pub)
+
+	sign := func(ixs []SolanaInstruction) error {
+		msg, _ := CompileSolanaMessage(payer, SystemProgramID, ixs)
+		_, err := svc.SignTransaction(context.Background(), TransactionRequest{
+			KeyID:          acc.PublicKey,
+			UnsignedTxData: base64.StdEncoding.EncodeToString(msg),
+			Context:        SolanaTxContext,
+		})
+		return err
+	}
+
+	if err := sign([]SolanaInstruction{SystemCreateAccountIx(payer, payer, 1, 0, SystemProgramID)}); err != nil {
+		t.Errorf("Allowlisted program was rejected: %v", err)
+	}
+
+	err = sign([]SolanaInstruction{loaderCloseIx(payer, payer, payer)})
+	if !errors.Is(err, errPolicyViolation) {
+		t.Errorf("Expected policy violation for non-allowlisted program, got: %v", err)
+	}
+
+	// opaque payloads can't be checked against the allowlist
+	_, err = svc.SignTransaction(context.Background(), TransactionRequest{
+		KeyID:          acc.PublicKey,
+		UnsignedTxData: base64.StdEncoding.EncodeToString([]byte("opaque")),
+		Context:        "payout",
+	})
+	if !errors.Is(err, errPolicyViolation) {
+		t.Errorf("Expected opaque payload to be rejected, got: %v", err)
+	}
+}
+
+func TestSignTransaction_DestinationAllowlist(t *testing.T) {
+	svc := NewSignerService(NewSecureKeyStore())
+
+	treasuryPub, _, _ := ed25519.GenerateKey(nil)
+	strangerPub, _, _ := ed25519.GenerateKey(nil)
+	treasury := SolanaAddress(treasuryPub)
+	stranger := SolanaAddress(strangerPub)
+
+	acc, _ := svc.GenerateKey(context.Background(), KeyGenRequest{Policy: &KeyPolicy{
+		Usage:               UsagePersistent,
+		AllowedDestinations: []string{treasury.String()},
+	}})
+	pub, _ := hex.DecodeString(acc.PublicKey)
+	owner := SolanaAddress(pub)
+
+	sign := func(ix SolanaInstruction) error {
+		msg, _ := CompileSolanaMessage(owner, SystemProgramID, []SolanaInstruction{ix})
+		_, err := svc.SignTransaction(context.Background(), TransactionRequest{
+			KeyID:          acc.PublicKey,
+			UnsignedTxData: base64.StdEncoding.EncodeToString(msg),
+			Context:        SolanaTxContext,
+		})
+		return err
+	}
+
+	if err := sign(SystemTransferIx(owner, treasury, 1000)); err != nil {
+		t.Errorf("Transfer to allowed destination was rejected: %v", err)
+	}
+	if err := sign(SystemTransferIx(owner, stranger, 1000)); !errors.Is(err, errPolicyViolation) {
+		t.Errorf("Expected transfer to stranger to be rejected, got: %v", err)
+	}
+
+	// TransferChecked into the treasury's associated token account
+	mint := SolanaAddress(strangerPub)
+	source, _ := AssociatedTokenAddress(owner, mint, TokenProgramID)
+	treasuryATA, _ := AssociatedTokenAddress(treasury, mint, TokenProgramID)
+	strangerATA, _ := AssociatedTokenAddress(stranger, mint, TokenProgramID)
+
+	tokenTransfer := func(dest SolanaPubkey) SolanaInstruction {
+		data := append([]byte{12}, binary.LittleEndian.AppendUint64(nil, 500)...)
+		return SolanaInstruction{
+			ProgramID: TokenProgramID,
+			Accounts: []SolanaAccountMeta{
+				{Pubkey: source, IsWritable: true},
+				{Pubkey: mint},
+				{Pubkey: dest, IsWritable: true},
+				{Pubkey: owner, IsSigner: true},
+			},
+			Data: append(data, 6),
+		}
+	}
+
+	if err := sign(tokenTransfer(treasuryATA)); err != nil {
+		t.Errorf("Token transfer to allowed wallet's ATA was rejected: %v", err)
+	}
+	if err := sign(tokenTransfer(strangerATA)); !errors.Is(err, errPolicyViolation) {
+		t.Errorf("Expected token transfer to stranger's ATA to be rejected, got: %v", err)
+	}
+}
+
+func TestSignTransaction_SpendingLimits(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "spend.json")
+	spending, err := NewSpendTracker(path)
+	if err != nil {
+		t.Fatalf("Failed to create spend tracker err: %v", err)
+	}
+	svc := NewSignerService(NewSecureKeyStore())
+	svc.spending = spending
+
+	destPub, _, _ := ed25519.GenerateKey(nil)
+	dest := SolanaAddress(destPub)
+
+	acc, err := svc.GenerateKey(context.Background(), KeyGenRequest{Policy: &KeyPolicy{
+		Usage:          UsagePersistent,
+		SpendingLimits: []SpendingLimit{{Window: "1h", Max: 1500}},
+	}})
+	if err != nil {
+		t.Fatalf("Failed to generate key err: %v", err)
+	}
+	pub, _ := hex.DecodeString(acc.PublicKey)
+	owner := SolanaAddress(pub)
+
+	transfer := func(lamports uint64) error {
+		msg, _ := CompileSolanaMessage(owner, SystemProgramID, []SolanaInstruction{SystemTransferIx(owner, dest, lamports)})
+		_, err := svc.SignTransaction(context.Background(), TransactionRequest{
+			KeyID:          acc.PublicKey,
+			UnsignedTxData: base64.StdEncoding.EncodeToString(msg),
+			Context:        SolanaTxContext,
+		})
+		return err
+	}
+
+	if err := transfer(1000); err != nil {
+		t.Fatalf("Transfer under the limit was rejected: %v", err)
+	}
+	if err := transfer(1000); !errors.Is(err, errSpendingLimit) {
+		t.Errorf("Expected transfer over the limit to be rejected, got: %v", err)
+	}
+	// the rejected request doesn't count towards the window
+	if err := transfer(500); err != nil {
+		t.Errorf("Transfer filling the limit was rejected: %v", err)
+	}
+
+	// counters survive a restart
+	reloaded, err := NewSpendTracker(path)
+	if err != nil {
+		t.Fatalf("Failed to reload spend tracker err: %v", err)
+	}
+	svc.spending = reloaded
+	if err := transfer(1); !errors.Is(err, errSpendingLimit) {
+		t.Errorf("Expected reloaded counters to enforce the limit, got: %v", err)
+	}
+
+	// bad windows are refused at generation
+	_, err = svc.GenerateKey(context.Background(), KeyGenRequest{Policy: &KeyPolicy{
+		Usage:          UsagePersistent,
+		SpendingLimits: []SpendingLimit{{Window: "30d", Max: 1}},
+	}})
+	if err == nil {
+		t.Error("Expected invalid spending window to be rejected")
+	}
+}
+
+func TestSignTransaction_TwoPersonApproval(t *testing.T) {
+	svc := NewSignerService(NewSecureKeyStore())
+	svc.approvals = NewApprovalQueue()
+	server := NewAPIServer(svc)
+	server.Approvals = svc.approvals
+
+	destPub, _, _ := ed25519.GenerateKey(nil)
+	dest := SolanaAddress(destPub)
+
+	acc, err := svc.GenerateKey(context.Background(), KeyGenRequest{Policy: &KeyPolicy{
+		Usage:              UsagePersistent,
+		ApprovalThresholds: []ApprovalThreshold{{Min: 1000}},
+		SpendingLimits:     []SpendingLimit{{Window: "1h", Max: 1500, OverLimit: OverLimitApprove}},
+	}})
+	if err != nil {
+		t.Fatalf("Failed to generate key err: %v", err)
+	}
+	pub, _ := hex.DecodeString(acc.PublicKey)
+	owner := SolanaAddress(pub)
+
+	// requested by operator alice
+	ctx := WithOperator(context.Background(), Operator{Name: "alice"})
+	transfer := func(lamports uint64) TransactionResult {
+		msg, _ := CompileSolanaMessage(owner, SystemProgramID, []SolanaInstruction{SystemTransferIx(owner, dest, lamports)})
+		res, err := svc.SignTransaction(ctx, TransactionRequest{
+			KeyID:          acc.PublicKey,
+			UnsignedTxData: base64.StdEncoding.EncodeToString(msg),
+			Context:        SolanaTxContext,
+		})
+		if err != nil {
+			t.Fatalf("Sign request failed: %v", err)
+		}
+		return res
+	}
+
+	if res := transfer(900); res.Signature == "" || res.ApprovalID != "" {
+		t.Errorf("Transfer under the threshold should sign right away, got %+v", res)
+	}
+
+	pending := transfer(2000)
+	if pending.Signature != "" || pending.ApprovalID == "" {
+		t.Fatalf("Transfer over the threshold should wait for approval, got %+v", pending)
+	}
+
+	// crossing the spending limit is routed to approval too
+	if res := transfer(700); res.ApprovalID == "" {
+		t.Errorf("Transfer over the spending limit should wait for approval, got %+v", res)
+	}
+
+	if _, _, _, err := svc.approvals.Approve(pending.ApprovalID, Operator{Name: "alice"}); !errors.Is(err, errApprovalSelf) {
+		t.Errorf("Expected self-approval to be refused, got: %v", err)
+	}
+
+	approve := func(operator string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodPost, "/api/v1/approvals/"+pending.ApprovalID+"/approve", nil)
+		r.SetPathValue("id", pending.ApprovalID)
+		r = r.WithContext(WithOperator(r.Context(), Operator{Name: operator}))
+		w := httptest.NewRecorder()
+		server.handleApprovalApprove(w, r)
+		return w
+	}
+
+	w := approve("bob")
+	if w.Code != http.StatusOK {
+		t.Fatalf("Approval failed w/ status %d: %s", w.Code, w.Body.String())
+	}
+	var pa PendingApproval
+	json.NewDecoder(w.Body).Decode(&pa)
+	if pa.Status != ApprovalExecuted || pa.DecidedBy != "bob" || pa.Result == nil || pa.Result.Signature == "" {
+		t.Errorf("Expected approved transaction to be signed, got %+v", pa)
+	}
+
+	if w := approve("carol"); w.Code != http.StatusConflict {
+		t.Errorf("Expected a decided approval to conflict, got status %d", w.Code)
+	}
+
+	// w/o approvers configured the request is refused rather than signed
+	svc.approvals = nil
+	msg, _ := CompileSolanaMessage(owner, SystemProgramID, []SolanaInstruction{SystemTransferIx(owner, dest, 5000)})
+	_, err = svc.SignTransaction(ctx, TransactionRequest{
+		KeyID:          acc.PublicKey,
+		UnsignedTxData: base64.StdEncoding.EncodeToString(msg),
+		Context:        SolanaTxContext,
+	})
+	if !errors.Is(err, errApprovalsDisabled) {
+		t.Errorf("Expected approvals disabled error, got: %v", err)
+	}
+}
+
+func TestOperatorRegistry_Authenticate(t *testing.T) {
+	ops, err := ParseOperators("alice:s3cret, bob:hunter2:treasury|security")
+	if err != nil {
+		t.Fatalf("Failed to parse operators err: %v", err)
+	}
+
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	r.Header.Set("Authorization", "Bearer hunter2")
+	if op, ok := ops.Authenticate(r); !ok || op.Name != "bob" || !op.HasRole("treasury") {
+		t.Errorf("Expected bob w/ the treasury role, got %+v %v", op, ok)
+	}
+
+	r.Header.Set("Authorization", "Bearer wrong")
+	if _, ok := ops.Authenticate(r); ok {
+		t.Error("Expected unknown token to fail")
+	}
+
+	if _, err := ParseOperators("alice"); err == nil {
+		t.Error("Expected entry w/o token to be rejected")
+	}
+}
+
+func TestApprovalQueue_Prune(t *testing.T) {
+	q := NewApprovalQueue()
+	ctx := WithOperator(context.Background(), Operator{Name: "alice"})
+	quorum := ApprovalQuorum{Required: 1}
+
+	rejected, _ := q.Submit(ctx, TransactionRequest{KeyID: "k1"}, "large transfer", quorum)
+	q.Reject(rejected.ID, Operator{Name: "bob"})
+	fresh, _ := q.Submit(ctx, TransactionRequest{KeyID: "k1"}, "large transfer", quorum)
+	q.Reject(fresh.ID, Operator{Name: "bob"})
+	pending, _ := q.Submit(ctx, TransactionRequest{KeyID: "k1"}, "large transfer", quorum)
+
+	// finished past the retention window
+	q.approvals[rejected.ID].finishedAt = time.Now().Add(-approvalRetention - time.Minute)
+	q.Submit(ctx, TransactionRequest{KeyID: "k2"}, "large transfer", quorum)
+
+	if _, err := q.Get(rejected.ID); !errors.Is(err, errApprovalNotFound) {
+		t.Errorf("Expected the old rejection to be pruned, got: %v", err)
+	}
+	for _, id := range []string{fresh.ID, pending.ID} {
+		if _, err := q.Get(id); err != nil {
+			t.Errorf("Expected %s to be kept, got: %v", id, err)
+		}
+	}
+	if trail := q.Audit(rejected.ID); len(trail) != 2 {
+		t.Errorf("Expected the audit trail to outlive the approval, got %+v", trail)
+	}
+}
+
+func TestApprovalQueue_AuditCap(t *testing.T) {
+	q := NewApprovalQueue()
+	q.auditCap = 3
+	q.compliance = NewComplianceRecorder()
+	ctx := WithOperator(context.Background(), Operator{Name: "alice"})
+
+	var ids []string
+	for range 5 {
+		pa, _ := q.Submit(ctx, TransactionRequest{KeyID: "k1"}, "large transfer", ApprovalQuorum{Required: 1})
+		ids = append(ids, pa.ID)
+	}
+
+	// the oldest entries are overwritten, the rest stay in order
+	trail := q.Audit("")
+	if len(trail) != 3 || len(q.audit) != 3 {
+		t.Fatalf("Expected the trail capped at 3, got %d", len(trail))
+	}
+	for i, d := range trail {
+		if d.ApprovalID != ids[i+2] {
+			t.Errorf("Entry %d is %s, expected %s", i, d.ApprovalID, ids[i+2])
+		}
+	}
+	if trail := q.Audit(ids[0]); len(trail) != 0 {
+		t.Errorf("Expected the first request dropped, got %+v", trail)
+	}
+
+	// compliance reports still see every decision
+	report := q.compliance.Report(time.Now(), time.Now().AddDate(0, 0, 1))
+	if len(report.ApprovalDecisions) != 5 {
+		t.Errorf("Expected 5 decisions reported, got %d", len(report.ApprovalDecisions))
+	}
+}
+
+func TestApprovalQueue_Quorum(t *testing.T) {
+	q := NewApprovalQueue()
+	ctx := WithOperator(context.Background(), Operator{Name: "alice"})
+	quorum := ApprovalQuorum{Required: 2, Approvers: []string{"bob"}, Roles: []string{"treasury"}}
+
+	pa, _ := q.Submit(ctx, TransactionRequest{KeyID: "k1", IdempotencyKey: "retry-1"}, "large transfer", quorum)
+
+	if _, _, _, err := q.Approve(pa.ID, Operator{Name: "mallory"}); !errors.Is(err, errApprovalNotEligible) {
+		t.Errorf("Expected operator outside the quorum to be refused, got: %v", err)
+	}
+
+	_, _, ready, err := q.Approve(pa.ID, Operator{Name: "bob"})
+	if err != nil || ready {
+		t.Fatalf("First approval should leave the request pending, ready=%v err=%v", ready, err)
+	}
+	if _, _, _, err := q.Approve(pa.ID, Operator{Name: "bob"}); !errors.Is(err, errApprovalDuplicate) {
+		t.Errorf("Expected a second vote from bob to be refused, got: %v", err)
+	}
+
+	got, req, ready, err := q.Approve(pa.ID, Operator{Name: "carol", Roles: []string{"treasury"}})
+	if err != nil || !ready || got.Status != ApprovalApproved {
+		t.Fatalf("Quorum should be met, got %+v ready=%v err=%v", got, ready, err)
+	}
+	if req.KeyID != "k1" || req.IdempotencyKey != "" {
+		t.Errorf("Unexpected request to sign: %+v", req)
+	}
+
+	// requested, refused, approve, refused, approve
+	if trail := q.Audit(pa.ID); len(trail) != 5 || trail[0].Decision != DecisionRequested || trail[4].Operator != "carol" {
+		t.Errorf("Unexpected audit trail: %+v", trail)
+	}
+
+	// pending requests expire
+	short, _ := q.Submit(ctx, TransactionRequest{KeyID: "k2"}, "large transfer", ApprovalQuorum{Required: 1, TTL: "1ms"})
+	time.Sleep(5 * time.Millisecond)
+	if _, _, _, err := q.Approve(short.ID, Operator{Name: "bob"}); !errors.Is(err, errApprovalState) {
+		t.Errorf("Expected expired request to refuse approvals, got: %v", err)
+	}
+	if got, _ := q.Get(short.ID); got.Status != ApprovalExpired {
+		t.Errorf("Expected expired status, got %s", got.Status)
+	}
+
+	if err := (ApprovalQuorum{Required: 3, Approvers: []string{"a", "b"}}).Validate(); err == nil {
+		t.Error("Expected unreachable quorum to be rejected")
+	}
+}
+
+func TestKeyPolicy_SigningSchedule(t *testing.T) {
+	// weekdays 09:00-17:00 in New York, and an overnight window
+	office := SigningSchedule{TimeZone: "America/New_York", Days: []string{"mon", "tue", "wed", "thu", "fri"}, Start: "09:00", End: "17:00"}
+	overnight := SigningSchedule{Start: "22:00", End: "02:00"}
+
+	tests := []struct {
+		name     string
+		schedule SigningSchedule
+		at       string
+		allowed  bool
+	}{
+		{"office hours", office, "2024-03-13T15:00:00Z", true},
+		{"before opening", office, "2024-03-13T12:00:00Z", false},
+		{"weekend", office, "2024-03-16T15:00:00Z", false},
+		{"overnight late", overnight, "2024-03-13T23:30:00Z", true},
+		{"overnight early", overnight, "2024-03-14T01:59:00Z", true},
+		{"overnight daytime", overnight, "2024-03-14T12:00:00Z", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			at, _ := time.Parse(time.RFC3339, tt.at)
+			if got := tt.schedule.Allows(at); got != tt.allowed {
+				t.Errorf("Allows(%s) = %v, want %v", tt.at, got, tt.allowed)
+			}
+		})
+	}
+
+	if err := (SigningSchedule{Days: []string{"someday"}}).Validate(); err == nil {
+		t.Error("Expected unknown day to be rejected")
+	}
+}
+
+func TestSignTransaction_CreationCooldown(t *testing.T) {
+	svc := NewSignerService(NewSecureKeyStore())
+
+	acc, err := svc.GenerateKey(context.Background(), KeyGenRequest{Policy: &KeyPolicy{
+		Usage:           UsagePersistent,
+		CooldownSeconds: 3600,
+	}})
+	if err != nil {
+		t.Fatalf("Failed to generate key err: %v", err)
+	}
+
+	_, err = svc.SignTransaction(context.Background(), TransactionRequest{
+		KeyID:          acc.PublicKey,
+		UnsignedTxData: base64.StdEncoding.EncodeToString([]byte("payout")),
+		Context:        "payout",
+	})
+	if !errors.Is(err, errSigningWindow) {
+		t.Errorf("Expected signing during cooldown to be refused, got: %v", err)
+	}
+}
+
+func TestKeyRateLimiter_TokenBucket(t *testing.T) {
+	limiter := NewKeyRateLimiter()
+	limit := KeyRateLimit{Signatures: 5, Per: "1m"}
+	now := time.Now()
+
+	for i := 0; i < 5; i++ {
+		if err := limiter.Take("k1", limit, now); err != nil {
+			t.Fatalf("Take %d within the burst failed: %v", i, err)
+		}
+	}
+	if err := limiter.Take("k1", limit, now); !errors.Is(err, errRateLimited) {
+		t.Errorf("Expected sixth signature to be limited, got: %v", err)
+	}
+
+	// other keys have their own bucket
+	if err := limiter.Take("k2", limit, now); err != nil {
+		t.Errorf("Unrelated key was limited: %v", err)
+	}
+
+	// one token refills every 12s
+	if err := limiter.Take("k1", limit, now.Add(12*time.Second)); err != nil {
+		t.Errorf("Expected a refilled token, got: %v", err)
+	}
+	if err := limiter.Take("k1", limit, now.Add(13*time.Second)); !errors.Is(err, errRateLimited) {
+		t.Errorf("Expected bucket to be empty again, got: %v", err)
+	}
+
+	if signErrorStatus(fmt.Errorf("wrapped: %w", errRateLimited)) != http.StatusTooManyRequests {
+		t.Error("Expected rate limited sign requests to map to 429")
+	}
+}
+
+// run w/ -race, signers hold their own copy of the key while it is zeroized
+func TestKillSwitch_SealDuringSign(t *testing.T) {
+	store := NewSecureKeyStore()
+	svc := NewSignerService(store)
+	acc, err := svc.GenerateKey(context.Background(), KeyGenRequest{Policy: &KeyPolicy{Usage: UsagePersistent}})
+	if err != nil {
+		t.Fatalf("GenerateKey failed: %v", err)
+	}
+	pub, _ := hex.DecodeString(acc.PublicKey)
+	msg := []byte("payout")
+
+	var wg sync.WaitGroup
+	for range 8 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				res, err := svc.SignTransaction(context.Background(), TransactionRequest{KeyID: acc.PublicKey, UnsignedTxData: base64.StdEncoding.EncodeToString(msg), Context: "payout"})
+				if err != nil {
+					return
+				}
+				// never a signature from half zeroed bytes
+				sig, _ := base64.StdEncoding.DecodeString(res.Signature)
+				if ok, _ := verifyWithKey(KeyTypeEd25519, pub, msg, sig, res.SigningMode, res.Context); !ok {
+					t.Error("Signature doesn't verify")
+					return
+				}
+			}
+		}()
+	}
+
+	time.Sleep(5 * time.Millisecond)
+	if _, err := NewKillSwitch(store, svc.seal).Seal(context.Background(), KillRequest{Reason: "incident"}); err != nil {
+		t.Fatalf("Seal failed: %v", err)
+	}
+	wg.Wait()
+	if store.Len() != 0 {
+		t.Errorf("Expected every key zeroized, %d left", store.Len())
+	}
+}
+
+func TestKillSwitch_ZeroizesAndSeals(t *testing.T) {
+	store := NewSecureKeyStore()
+	svc := NewSignerService(store)
+	kill := NewKillSwitch(store, svc.seal)
+
+	acme := WithTenant(context.Background(), "acme")
+	globex := WithTenant(context.Background(), "globex")
+	acmeKey, _ := svc.GenerateKey(acme, KeyGenRequest{})
+	globexKey, _ := svc.GenerateKey(globex, KeyGenRequest{})
+	if acmeKey.Namespace != "acme" {
+		t.Fatalf("Expected key namespace to default to the tenant, got %q", acmeKey.Namespace)
+	}
+
+	admin := WithOperator(context.Background(), Operator{Name: "oncall"})
+	if _, err := kill.Trigger(admin, KillRequest{Namespace: "acme"}); err == nil {
+		t.Error("Expected kill w/o a reason to be rejected")
+	}
+
+	report, err := kill.Trigger(admin, KillRequest{Namespace: "acme", Reason: "leaked api key"})
+	if err != nil || report.Zeroized != 1 || report.KeyIDs[0] != acmeKey.PublicKey {
+		t.Fatalf("Unexpected namespace kill report %+v err=%v", report, err)
+	}
+	if _, err := store.Info(acmeKey.PublicKey); err == nil {
+		t.Error("Expected acme key to be gone")
+	}
+	if _, err := svc.GenerateKey(acme, KeyGenRequest{}); !errors.Is(err, errSealed) {
+		t.Errorf("Expected sealed namespace to refuse key generation, got: %v", err)
+	}
+	if _, err := svc.GenerateKey(globex, KeyGenRequest{}); err != nil {
+		t.Errorf("Other namespaces should keep working, got: %v", err)
+	}
+
+	report, _ = kill.Trigger(admin, KillRequest{Reason: "host compromised"})
+	if report.Zeroized != 2 || len(store.Usage()) != 0 {
+		t.Errorf("Expected every key to be zeroized, report %+v", report)
+	}
+	if status := svc.seal.Status(); !status.Sealed || status.Service.SealedBy != "oncall" {
+		t.Errorf("Expected service to be sealed by oncall, got %+v", status)
+	}
+	_, err = svc.SignTransaction(globex, TransactionRequest{
+		KeyID:          globexKey.PublicKey,
+		UnsignedTxData: base64.StdEncoding.EncodeToString([]byte("payout")),
+		Context:        "payout",
+	})
+	if err == nil {
+		t.Error("Expected signing to fail after the kill switch")
+	}
+}
+
+func TestSignTransaction_FrozenKey(t *testing.T) {
+	store := NewSecureKeyStore()
+	svc := NewSignerService(store)
+	acc, _ := svc.GenerateKey(context.Background(), KeyGenRequest{Policy: &KeyPolicy{Usage: UsagePersistent}})
+
+	sign := func() error {
+		_, err := svc.SignTransaction(context.Background(), TransactionRequest{
+			KeyID:          acc.PublicKey,
+			UnsignedTxData: base64.StdEncoding.EncodeToString([]byte("payout")),
+			Context:        "payout",
+		})
+		return err
+	}
+
+	if _, err := store.Freeze(context.Background(), acc.PublicKey, "suspicious activity", "oncall"); err != nil {
+		t.Fatalf("Failed to freeze key err: %v", err)
+	}
+	err := sign()
+	if !errors.Is(err, errKeyFrozen) || signErrorStatus(err) != http.StatusLocked {
+		t.Errorf("Expected frozen key to be refused w/ 423, got: %v", err)
+	}
+	if _, _, _, err := store.Acquire(acc.PublicKey); !errors.Is(err, errKeyFrozen) {
+		t.Errorf("Expected Acquire to refuse a frozen key, got: %v", err)
+	}
+
+	// material survives the freeze
+	store.Unfreeze(context.Background(), acc.PublicKey, "oncall")
+	if err := sign(); err != nil {
+		t.Errorf("Expected unfrozen key to sign, got: %v", err)
+	}
+}
+
+func TestDeadManSwitch_Trips(t *testing.T) {
+	store := NewSecureKeyStore()
+	svc := NewSignerService(store)
+	risky, _ := svc.GenerateKey(context.Background(), KeyGenRequest{Policy: &KeyPolicy{Usage: UsagePersistent, HighRisk: true}})
+	normal, _ := svc.GenerateKey(context.Background(), KeyGenRequest{Policy: &KeyPolicy{Usage: UsagePersistent}})
+
+	deadMan := NewDeadManSwitch(store, svc.seal, time.Hour)
+	deadMan.CheckIn("alice")
+	start := deadMan.Status().LastCheckIn
+
+	deadMan.Check(start.Add(59 * time.Minute))
+	if deadMan.Status().Tripped {
+		t.Fatal("Switch tripped before the deadline")
+	}
+
+	deadMan.Check(start.Add(61 * time.Minute))
+	if !deadMan.Status().Tripped || !svc.seal.Status().Sealed {
+		t.Fatal("Expected switch to trip and seal the service")
+	}
+	if _, err := store.Info(risky.PublicKey); err == nil {
+		t.Error("Expected high risk key to be zeroized")
+	}
+	if _, err := store.Info(normal.PublicKey); err != nil {
+		t.Errorf("Expected other keys to be kept, got: %v", err)
+	}
+}
+
+func TestAnomalyDetector_FlagsBurstsAndAmounts(t *testing.T) {
+	d := NewAnomalyDetector(DefaultAnomalyConfig())
+	start := time.Date(2024, 3, 13, 9, 0, 0, 0, time.UTC)
+
+	// a month of quiet: two ~1000 lamport payouts in each of 30 minutes
+	for m := 0; m < 30; m++ {
+		at := start.Add(time.Duration(m) * time.Hour)
+		d.Record("k1", 900+uint64(m*10), at)
+		d.Record("k1", 1000, at.Add(time.Second))
+	}
+
+	now := start.Add(100 * time.Hour)
+	if f := d.Evaluate("k1", 1200, now); f != nil {
+		t.Errorf("Ordinary payout was flagged: %s", f)
+	}
+	if f := d.Evaluate("k1", 5_000_000_000, now); f == nil || f.Kind != "lamports per transaction" {
+		t.Errorf("Expected huge payout to be flagged, got %v", f)
+	}
+
+	for i := 0; i < 20; i++ {
+		d.Record("k1", 1000, now)
+	}
+	if f := d.Evaluate("k1", 1000, now); f == nil || f.Kind != "signatures per minute" {
+		t.Errorf("Expected burst to be flagged, got %v", f)
+	}
+
+	// keys w/o enough history are never flagged
+	d.Record("fresh", 1, now)
+	if f := d.Evaluate("fresh", 1_000_000_000, now); f != nil {
+		t.Errorf("Key w/o a baseline was flagged: %s", f)
+	}
+
+	if _, err := AnomalyConfigFromEnv(func(k string) string {
+		return map[string]string{"STS_ANOMALY_ACTION": "panic"}[k]
+	}); err == nil {
+		t.Error("Expected unknown anomaly action to be rejected")
+	}
+}
+
+func TestHandleTxSign_CallerRestrictions(t *testing.T) {
+	svc := NewSignerService(NewSecureKeyStore())
+	server := NewAPIServer(svc)
+
+	acc, err := svc.GenerateKey(context.Background(), KeyGenRequest{Policy: &KeyPolicy{
+		Usage:        UsagePersistent,
+		AllowedCIDRs: []string{"10.0.0.0/8", "2001:db8::/32"},
+	}})
+	if err != nil {
+		t.Fatalf("Failed to generate key err: %v", err)
+	}
+
+	body := fmt.Sprintf(`{"keyId": %q, "unsignedTxData": %q, "context": "payout"}`, acc.PublicKey, base64.StdEncoding.EncodeToString([]byte("payout")))
+
+	tests := []struct {
+		remote string
+		status int
+	}{
+		{"10.1.2.3:5555", http.StatusOK},
+		{"[2001:db8::1]:5555", http.StatusOK},
+		{"192.168.1.10:5555", http.StatusForbidden},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.remote, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodPost, "/api/v1/txs/sign", strings.NewReader(body))
+			r.RemoteAddr = tt.remote
+			w := httptest.NewRecorder()
+			server.handleTxSign(w, r)
+			if w.Code != tt.status {
+				t.Errorf("Expected status %d, got %d: %s", tt.status, w.Code, w.Body.String())
+			}
+		})
+	}
+
+	// keys restricted to mTLS identities refuse plain connections
+	policy := KeyPolicy{AllowedClients: []string{"payouts.internal"}}
+	if err := policy.CheckCaller(netip.MustParseAddr("10.0.0.1"), nil); !errors.Is(err, errCallerNotAllowed) {
+		t.Errorf("Expected caller w/o client cert to be refused, got: %v", err)
+	}
+	if err := policy.CheckCaller(netip.Addr{}, []string{"payouts.internal"}); err != nil {
+		t.Errorf("Expected allowed client identity to pass, got: %v", err)
+	}
+}
+
+func TestSignTransaction_CanaryKey(t *testing.T) {
+	svc := NewSignerService(NewSecureKeyStore())
+	alerts := &recordingNotifier{sent: make(chan Notification, 1)}
+	svc.notifier = NewNotificationDispatcher()
+	svc.notifier.Add(alerts, SeverityInfo)
+
+	acc, _ := svc.GenerateKey(context.Background(), KeyGenRequest{Policy: &KeyPolicy{Usage: UsagePersistent, Canary: true}})
+	pub, _ := hex.DecodeString(acc.PublicKey)
+
+	msg := []byte("withdraw everything")
+	res, err := svc.SignTransaction(context.Background(), TransactionRequest{
+		KeyID:          acc.PublicKey,
+		UnsignedTxData: base64.StdEncoding.EncodeToString(msg),
+		Context:        "payout",
+	})
+	if err != nil || res.Signature == "" || res.BroadcastStatus != "Signed and Ready" {
+		t.Fatalf("Expected a realistic looking response, got %+v err=%v", res, err)
+	}
+
+	// the decoy must not verify against the canary key
+	sig, _ := base64.StdEncoding.DecodeString(res.Signature)
+	if len(sig) != ed25519.SignatureSize {
+		t.Errorf("Decoy signature has the wrong size %d", len(sig))
+	}
+	if valid, _ := verifyEd25519(ed25519.PublicKey(pub), msg, sig, res.SigningMode, "payout"); valid {
+		t.Error("Canary key produced a valid signature")
+	}
+
+	select {
+	case n := <-alerts.sent:
+		if n.Title != "Canary key used" || n.Severity != SeverityCritical {
+			t.Errorf("Unexpected alert %+v", n)
+		}
+	case <-time.After(time.Second):
+		t.Error("Expected a canary alert")
+	}
+}
+
+func TestCapability_MintAttenuateAuthorize(t *testing.T) {
+	issuer, _ := NewCapabilityIssuer("root-secret")
+	now := time.Now()
+
+	keyPub, _, _ := ed25519.GenerateKey(nil)
+	destPub, _, _ := ed25519.GenerateKey(nil)
+	keyID := hex.EncodeToString(keyPub)
+	owner, dest := SolanaAddress(keyPub), SolanaAddress(destPub)
+
+	maxLamports := uint64(1000)
+	grant, err := issuer.MintFor(CapabilityRequest{
+		KeyIDs:      []string{keyID},
+		Programs:    []string{SystemProgramID.String()},
+		MaxLamports: &maxLamports,
+		ExpiresIn:   "1h",
+	}, now)
+	if err != nil {
+		t.Fatalf("Failed to mint capability err: %v", err)
+	}
+
+	transfer := func(lamports uint64) (TransactionRequest, *SolanaPayload) {
+		msg, _ := CompileSolanaMessage(owner, SystemProgramID, []SolanaInstruction{SystemTransferIx(owner, dest, lamports)})
+		tx, _ := ParseSolanaPayload(msg)
+		return TransactionRequest{KeyID: keyID, Context: SolanaTxContext}, tx
+	}
+
+	c, err := issuer.Verify(grant.Token)
+	if err != nil {
+		t.Fatalf("Failed to verify fresh token err: %v", err)
+	}
+	req, tx := transfer(500)
+	if err := c.Authorize(req, tx, now); err != nil {
+		t.Errorf("Transfer within the caveats was denied: %v", err)
+	}
+	req, tx = transfer(5000)
+	if err := c.Authorize(req, tx, now); !errors.Is(err, errCapabilityDenied) {
+		t.Errorf("Expected transfer over the cap to be denied, got: %v", err)
+	}
+	req, tx = transfer(500)
+	req.KeyID = "other"
+	if err := c.Authorize(req, tx, now); !errors.Is(err, errCapabilityDenied) {
+		t.Errorf("Expected other key to be denied, got: %v", err)
+	}
+	req, tx = transfer(500)
+	if err := c.Authorize(req, tx, now.Add(2*time.Hour)); !errors.Is(err, errCapabilityDenied) {
+		t.Errorf("Expected expired token to be denied, got: %v", err)
+	}
+
+	// holders narrow tokens offline, the issuer still accepts them
+	narrowed, _ := Attenuate(grant.Token, "max_lamports = 100")
+	c, err = issuer.Verify(narrowed)
+	if err != nil {
+		t.Fatalf("Attenuated token failed to verify: %v", err)
+	}
+	req, tx = transfer(500)
+	if err := c.Authorize(req, tx, now); !errors.Is(err, errCapabilityDenied) {
+		t.Errorf("Expected attenuated cap to apply, got: %v", err)
+	}
+
+	// dropping a caveat breaks the chain
+	forged, _ := decodeCapability(narrowed)
+	forged.Caveats = forged.Caveats[:len(forged.Caveats)-1]
+	if _, err := issuer.Verify(forged.encode()); !errors.Is(err, errCapabilityInvalid) {
+		t.Errorf("Expected forged token to be rejected, got: %v", err)
+	}
+
+	// a required token is checked before the signer
+	server := NewAPIServer(NewSignerService(NewSecureKeyStore()))
+	server.Capabilities = issuer
+	server.RequireCapability = true
+	r := httptest.NewRequest(http.MethodPost, "/api/v1/txs/sign", strings.NewReader(`{"keyId": "k", "unsignedTxData": "AA==", "context": "payout"}`))
+	w := httptest.NewRecorder()
+	server.handleTxSign(w, r)
+	if w.Code != http.StatusUnauthorized {
+		t.Errorf("Expected 401 w/o a capability, got %d", w.Code)
+	}
+}
+
+func TestSignTransaction_SigningGrant(t *testing.T) {
+	svc := NewSignerService(NewSecureKeyStore())
+	acc, _ := svc.GenerateKey(context.Background(), KeyGenRequest{Policy: &KeyPolicy{Usage: UsagePersistent, RequireGrant: true}})
+	other, _ := svc.GenerateKey(context.Background(), KeyGenRequest{})
+
+	sign := func(keyID, grant string) error {
+		_, err := svc.SignTransaction(context.Background(), TransactionRequest{
+			KeyID:          keyID,
+			UnsignedTxData: base64.StdEncoding.EncodeToString([]byte("batch payout")),
+			Context:        "payout",
+			Grant:          grant,
+		})
+		return err
+	}
+
+	if err := sign(acc.PublicKey, ""); !errors.Is(err, errGrantRequired) {
+		t.Fatalf("Expected errGrantRequired, got %v", err)
+	}
+
+	if _, err := svc.grants.Mint(GrantRequest{KeyID: acc.PublicKey, Uses: 2}, "alice"); err == nil {
+		t.Error("Expected a grant w/o a ttl to be refused")
+	}
+	grant, err := svc.grants.Mint(GrantRequest{KeyID: acc.PublicKey, Uses: 2, TTLMinutes: 5}, "alice")
+	if err != nil || grant.Token == "" {
+		t.Fatalf("Mint failed: %v", err)
+	}
+
+	if err := sign(other.PublicKey, grant.Token); !errors.Is(err, errGrantInvalid) {
+		t.Errorf("Expected the grant to be bound to its key, got %v", err)
+	}
+	for i := range 2 {
+		if err := sign(acc.PublicKey, grant.Token); err != nil {
+			t.Fatalf("Sign %d under grant failed: %v", i, err)
+		}
+	}
+	if err := sign(acc.PublicKey, grant.Token); !errors.Is(err, errGrantInvalid) {
+		t.Errorf("Expected the grant to be used up, got %v", err)
+	}
+
+	// revoked grants stop working at once
+	grant, _ = svc.grants.Mint(GrantRequest{KeyID: acc.PublicKey, Uses: 5, TTLMinutes: 5}, "alice")
+	if err := svc.grants.Revoke(grant.ID, "bob"); err != nil {
+		t.Fatalf("Revoke failed: %v", err)
+	}
+	if err := sign(acc.PublicKey, grant.Token); !errors.Is(err, errGrantInvalid) {
+		t.Errorf("Expected a revoked grant to be refused, got %v", err)
+	}
+}
+
+func TestReplayGuard(t *testing.T) {
+	now := time.Now()
+	guard := NewReplayGuard(true)
+
+	if err := guard.Check("key", "", 0, now); !errors.Is(err, errReplay) {
+		t.Errorf("Expected a missing nonce to be refused, got %v", err)
+	}
+	if err := guard.Check("key", "n1", now.Unix(), now); err != nil {
+		t.Fatalf("Fresh nonce refused: %v", err)
+	}
+	if err := guard.Check("key", "n1", now.Unix(), now); !errors.Is(err, errReplay) {
+		t.Errorf("Expected a replayed nonce to be refused, got %v", err)
+	}
+	if err := guard.Check("other", "n1", now.Unix(), now); err != nil {
+		t.Errorf("Nonces should be scoped per key, got %v", err)
+	}
+	if err := guard.Check("key", "n2", now.Add(-10*time.Minute).Unix(), now); !errors.Is(err, errReplay) {
+		t.Errorf("Expected a stale timestamp to be refused, got %v", err)
+	}
+
+	// optional mode still checks nonces that are sent
+	optional := NewReplayGuard(false)
+	if err := optional.Check("key", "", 0, now); err != nil {
+		t.Errorf("Expected requests w/o a nonce to pass, got %v", err)
+	}
+	optional.Check("key", "n1", now.Unix(), now)
+	if err := optional.Check("key", "n1", now.Unix(), now); !errors.Is(err, errReplay) {
+		t.Errorf("Expected a replayed nonce to be refused, got %v", err)
+	}
+}
+
+func TestReplayGuard_Evicts(t *testing.T) {
+	start := time.Now()
+	guard := NewReplayGuard(true)
+
+	for i := range 100 {
+		at := start.Add(time.Duration(i) * time.Second)
+		if err := guard.Check("key", strconv.Itoa(i), at.Unix(), at); err != nil {
+			t.Fatalf("Fresh nonce %d refused: %v", i, err)
+		}
+	}
+	if len(guard.seen) != 100 || len(guard.order) != 100 {
+		t.Fatalf("Expected 100 nonces remembered, got %d", len(guard.seen))
+	}
+
+	// past the window only the nonces seen within it are kept
+	later := start.Add(2*maxRequestSkew + 50*time.Second)
+	if err := guard.Check("key", "late", later.Unix(), later); err != nil {
+		t.Fatalf("Fresh nonce refused: %v", err)
+	}
+	if len(guard.seen) != 51 || len(guard.order) != 51 {
+		t.Errorf("Expected the expired nonces evicted, %d in the map and %d queued", len(guard.seen), len(guard.order))
+	}
+	if _, ok := guard.seen["key/49"]; ok {
+		t.Error("Expected nonce 49 evicted")
+	}
+
+	// an evicted nonce is fresh again, then remembered like any other
+	if err := guard.Check("key", "0", later.Unix(), later); err != nil {
+		t.Fatalf("Expected an evicted nonce to be fresh again, got %v", err)
+	}
+	if err := guard.Check("key", "0", later.Unix(), later); !errors.Is(err, errReplay) {
+		t.Errorf("Expected a replayed nonce to be refused, got %v", err)
+	}
+}
+
+func TestHMACVerifier(t *testing.T) {
+	v, err := ParseHMACClients("batch:s3cret", true)
+	if err != nil {
+		t.Fatalf("ParseHMACClients failed: %v", err)
+	}
+	s := &APIServer{HMAC: v}
+	handler := s.withHMAC(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		w.Write(body)
+	}))
+
+	body := `{"keyId": "k"}`
+	now := time.Now().Unix()
+	tests := []struct {
+		name   string
+		client string
+		sig    string
+		ts     int64
+		body   string
+		want   int
+	}{
+		{"valid", "batch", SignRequest([]byte("s3cret"), http.MethodPost, "/api/v1/txs/sign", now, []byte(body)), now, body, http.StatusOK},
+		{"unsigned", "", "", 0, body, http.StatusUnauthorized},
+		{"tampered body", "batch", SignRequest([]byte("s3cret"), http.MethodPost, "/api/v1/txs/sign", now, []byte(body)), now, `{"keyId": "x"}`, http.StatusUnauthorized},
+		{"wrong secret", "batch", SignRequest([]byte("guess"), http.MethodPost, "/api/v1/txs/sign", now, []byte(body)), now, body, http.StatusUnauthorized},
+		{"stale", "batch", SignRequest([]byte("s3cret"), http.MethodPost, "/api/v1/txs/sign", now-3600, []byte(body)), now - 3600, body, http.StatusUnauthorized},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodPost, "/api/v1/txs/sign", strings.NewReader(tt.body))
+			if tt.client != "" {
+				r.Header.Set("X-STS-Client", tt.client)
+				r.Header.Set("X-STS-Timestamp", strconv.FormatInt(tt.ts, 10))
+				r.Header.Set("X-STS-Signature", tt.sig)
+			}
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, r)
+
+			if w.Code != tt.want {
+				t.Errorf("Expected %d, got %d: %s", tt.want, w.Code, w.Body.String())
+			}
+			// the handler still sees the full body
+			if tt.want == http.StatusOK && w.Body.String() != body {
+				t.Errorf("Handler got body %q", w.Body.String())
+			}
+		})
+	}
+
+	// the query is signed w/ the path
+	get := func(target, signed string) int {
+		r := httptest.NewRequest(http.MethodGet, target, nil)
+		r.Header.Set("X-STS-Client", "batch")
+		r.Header.Set("X-STS-Timestamp", strconv.FormatInt(now, 10))
+		r.Header.Set("X-STS-Signature", SignRequest([]byte("s3cret"), http.MethodGet, signed, now, nil))
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+		return w.Code
+	}
+	if code := get("/api/v1/approvals?status=pending", "/api/v1/approvals?status=pending"); code != http.StatusOK {
+		t.Errorf("Expected a signed query to pass, got %d", code)
+	}
+	if code := get("/api/v1/approvals?status=approved", "/api/v1/approvals?status=pending"); code != http.StatusUnauthorized {
+		t.Errorf("Expected a rewritten query to be refused, got %d", code)
+	}
+	if code := get("/api/v1/approvals?status=pending", "/api/v1/approvals"); code != http.StatusUnauthorized {
+		t.Errorf("Expected a query added after signing to be refused, got %d", code)
+	}
+}
+
+func TestAuthLockout_BacksOff(t *testing.T) {
+	operators, _ := ParseOperators("alice:tok")
+	s := &APIServer{Operators: operators, Lockout: NewAuthLockout(3, time.Minute, time.Hour)}
+	handler := s.withOperator(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if OperatorFromContext(r.Context()).Name == "" {
+			w.WriteHeader(http.StatusUnauthorized)
+		}
+	}))
+
+	try := func(token string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodGet, "/api/v1/approvals", nil)
+		r.Header.Set("Authorization", "Bearer "+token)
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+		return w
+	}
+
+	for i := range 3 {
+		if w := try("guess"); w.Code != http.StatusUnauthorized {
+			t.Fatalf("Attempt %d: expected 401, got %d", i, w.Code)
+		}
+	}
+
+	// even the right token waits out the lockout
+	w := try("tok")
+	if w.Code != http.StatusTooManyRequests || w.Header().Get("Retry-After") != "60" {
+		t.Fatalf("Expected 429 w/ Retry-After 60, got %d %q", w.Code, w.Header().Get("Retry-After"))
+	}
+
+	now := time.Now()
+	identity := "bearer@" + netip.MustParseAddr("192.0.2.1").String()
+	s.Lockout.Failure(identity, now)
+	if wait := s.Lockout.Locked(identity, now); wait != 2*time.Minute {
+		t.Errorf("Expected the lockout to double to 2m, got %s", wait)
+	}
+	s.Lockout.Success(identity)
+	if wait := s.Lockout.Locked(identity, now); wait != 0 {
+		t.Errorf("Expected success to clear the lockout, got %s", wait)
+	}
+}
+
+func TestAuthLockout_ByAddress(t *testing.T) {
+	v, _ := ParseHMACClients("batch:s3cret", true)
+	s := &APIServer{HMAC: v, Tokens: NewAPITokenStore(), Lockout: NewAuthLockout(3, time.Minute, time.Hour)}
+	_, token, _ := s.Tokens.Mint(APITokenRequest{Name: "ci", Operations: []string{TokenOpSign}}, "alice", time.Now())
+	handler := s.withHMAC(s.withAPIToken(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})))
+
+	try := func(remote, secret, apiKey string) int {
+		now := time.Now().Unix()
+		r := httptest.NewRequest(http.MethodPost, "/api/v1/txs/sign", strings.NewReader(`{}`))
+		r.RemoteAddr = remote + ":4000"
+		r.Header.Set("X-STS-Client", "batch")
+		r.Header.Set("X-STS-Timestamp", strconv.FormatInt(now, 10))
+		r.Header.Set("X-STS-Signature", SignRequest([]byte(secret), http.MethodPost, "/api/v1/txs/sign", now, []byte(`{}`)))
+		if apiKey != "" {
+			r.Header.Set("X-API-Key", apiKey)
+		}
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+		return w.Code
+	}
+
+	// bad signatures under the client's name only lock out the sender
+	for range 3 {
+		try("203.0.113.9", "guess", "")
+	}
+	if code := try("203.0.113.9", "s3cret", ""); code != http.StatusTooManyRequests {
+		t.Errorf("Expected the guessing address locked out, got %d", code)
+	}
+	if code := try("192.0.2.1", "s3cret", ""); code != http.StatusOK {
+		t.Errorf("Expected the real client unaffected, got %d", code)
+	}
+
+	// bad API tokens count too
+	for range 3 {
+		try("198.51.100.7", "s3cret", "guess")
+	}
+	if code := try("198.51.100.7", "s3cret", token); code != http.StatusTooManyRequests {
+		t.Errorf("Expected API token guesses locked out, got %d", code)
+	}
+	if code := try("192.0.2.1", "s3cret", token); code != http.StatusOK {
+		t.Errorf("Expected a valid token from elsewhere accepted, got %d", code)
+	}
+}
+
+func TestGenerateKey_Attestation(t *testing.T) {
+	svc := NewSignerService(NewSecureKeyStore())
+	attester, err := NewAttester(strings.Repeat("07", ed25519.SeedSize))
+	if err != nil {
+		t.Fatalf("NewAttester failed: %v", err)
+	}
+	svc.attester = attester
+
+	acc, err := svc.GenerateKey(WithTenant(context.Background(), "acme"), KeyGenRequest{})
+	if err != nil || acc.Attestation == nil {
+		t.Fatalf("Expected an attested key, got %+v err=%v", acc, err)
+	}
+	st := acc.Attestation.Statement
+	if st.KeyID != acc.PublicKey || st.Namespace != "acme" || st.Backend != keyBackend || st.Version != version {
+		t.Errorf("Unexpected statement %+v", st)
+	}
+
+	if err := VerifyAttestation(*acc.Attestation, attester.PublicKey()); err != nil {
+		t.Errorf("Attestation should verify: %v", err)
+	}
+
+	forged := *acc.Attestation
+	forged.Statement.KeyID = strings.Repeat("00", 32)
+	if err := VerifyAttestation(forged, attester.PublicKey()); !errors.Is(err, errBadAttestation) {
+		t.Errorf("Expected a tampered statement to fail, got %v", err)
+	}
+
+	other, _ := NewAttester("")
+	if err := VerifyAttestation(*acc.Attestation, other.PublicKey()); !errors.Is(err, errBadAttestation) {
+		t.Errorf("Expected an untrusted signer to fail, got %v", err)
+	}
+}
+
+func TestFIPSMode_RejectsUnapprovedKeyTypes(t *testing.T) {
+	svc := NewSignerService(NewSecureKeyStore())
+	legacy, err := svc.GenerateKey(context.Background(), KeyGenRequest{KeyType: KeyTypeSecp256k1, Policy: &KeyPolicy{Usage: UsagePersistent}})
+	if err != nil {
+		t.Fatalf("GenerateKey failed: %v", err)
+	}
+
+	svc.fips = true
+	if _, err := svc.GenerateKey(context.Background(), KeyGenRequest{KeyType: KeyTypeSecp256k1}); !errors.Is(err, errNotFIPSApproved) {
+		t.Errorf("Expected secp256k1 generation to be refused, got %v", err)
+	}
+	if _, err := svc.GenerateKey(context.Background(), KeyGenRequest{KeyType: KeyTypeP256}); err != nil {
+		t.Errorf("Expected p256 to be allowed, got %v", err)
+	}
+
+	digest := sha256.Sum256([]byte("payout"))
+	_, err = svc.SignTransaction(context.Background(), TransactionRequest{
+		KeyID:          legacy.PublicKey,
+		UnsignedTxData: base64.StdEncoding.EncodeToString(digest[:]),
+		Context:        "payout",
+	})
+	if !errors.Is(err, errNotFIPSApproved) {
+		t.Errorf("Expected an existing secp256k1 key to be refused, got %v", err)
+	}
+}
+
+func TestRunSelfTests(t *testing.T) {
+	results, err := RunSelfTests([]string{KeyTypeEd25519, KeyTypeSecp256k1, KeyTypeP256})
+	if err != nil {
+		for _, r := range results {
+			t.Logf("%s: %v", r.Name, r.Err)
+		}
+		t.Fatalf("Self-tests failed: %v", err)
+	}
+	if len(results) != len(ed25519Vectors)+3+1 {
+		t.Errorf("Expected every check to run, got %d results", len(results))
+	}
+
+	// a wrong known answer must fail
+	v := ed25519Vectors[0]
+	if err := ed25519KnownAnswer(v.seed, v.pub, "01", v.sig); err == nil {
+		t.Error("Expected a mismatched vector to fail")
+	}
+}
+
+func TestWipeP256_ClearsScalar(t *testing.T) {
+	_, privKey, err := generateP256()
+	if err != nil {
+		t.Fatalf("generateP256 failed: %v", err)
+	}
+	priv, err := ecdsa.ParseRawPrivateKey(elliptic.P256(), privKey)
+	if err != nil {
+		t.Fatalf("ParseRawPrivateKey failed: %v", err)
+	}
+
+	wipeP256(priv)
+	for _, word := range priv.D.Bits() {
+		if word != 0 {
+			t.Fatal("Expected the parsed scalar to be wiped")
+		}
+	}
+	// the stored material is untouched
+	if _, _, err := signP256(privKey, []byte("msg"), ""); err != nil {
+		t.Errorf("Expected the stored key to still sign, got %v", err)
+	}
+}
+
+func TestShamir_SplitCombine(t *testing.T) {
+	secret := []byte("treasury root seed, 32 bytes ok!")
+	shares, err := SplitSecret(secret, 5, 3)
+	if err != nil {
+		t.Fatalf("SplitSecret failed: %v", err)
+	}
+
+	for _, pick := range [][]int{{0, 1, 2}, {4, 2, 0}, {1, 3, 4, 0}} {
+		subset := [][]byte{}
+		for _, i := range pick {
+			subset = append(subset, shares[i])
+		}
+		got, err := CombineShares(subset)
+		if err != nil || string(got) != string(secret) {
+			t.Errorf("Shares %v rebuilt %q err=%v", pick, got, err)
+		}
+	}
+
+	if got, _ := CombineShares(shares[:2]); string(got) == string(secret) {
+		t.Error("Two shares should not rebuild a 3 of 5 secret")
+	}
+	if _, err := SplitSecret(secret, 2, 3); err == nil {
+		t.Error("Expected threshold > shares to be refused")
+	}
+}
+
+func TestKeyCeremony(t *testing.T) {
+	m := NewCeremonyManager()
+	alice, bob, carol := Operator{Name: "alice"}, Operator{Name: "bob"}, Operator{Name: "carol"}
+
+	c, err := m.Start(CeremonyRequest{Purpose: "treasury", Shares: 3, Threshold: 2}, alice)
+	if err != nil {
+		t.Fatalf("Start failed: %v", err)
+	}
+
+	entropy := func() []byte { return []byte(strings.Repeat("dice rolls 3 6 1 4 ", 2)) }
+	if _, err := m.Contribute(c.ID, bob, entropy()); !errors.Is(err, errCeremonyAbsent) {
+		t.Errorf("Expected absent operators to be refused, got %v", err)
+	}
+
+	m.Attend(c.ID, alice)
+	if _, err := m.Complete(c.ID, alice); !errors.Is(err, errCeremonyQuorum) {
+		t.Errorf("Expected completion to need attendees, got %v", err)
+	}
+	m.Attend(c.ID, bob)
+	if _, err := m.Complete(c.ID, alice); !errors.Is(err, errCeremonyQuorum) {
+		t.Errorf("Expected completion to need entropy, got %v", err)
+	}
+	if _, err := m.Contribute(c.ID, bob, entropy()); err != nil {
+		t.Fatalf("Contribute failed: %v", err)
+	}
+	if _, err := m.Complete(c.ID, carol); !errors.Is(err, errCeremonyAbsent) {
+		t.Errorf("Expected an absent operator not to complete, got %v", err)
+	}
+
+	res, err := m.Complete(c.ID, alice)
+	if err != nil || len(res.Shares) != 3 || res.Status != CeremonyCompleted {
+		t.Fatalf("Complete failed: %+v err=%v", res, err)
+	}
+
+	// any two shares rebuild the seed behind the published key
+	a, _ := hex.DecodeString(res.Shares[0])
+	b, _ := hex.DecodeString(res.Shares[2])
+	seed, err := CombineShares([][]byte{a, b})
+	if err != nil {
+		t.Fatalf("CombineShares failed: %v", err)
+	}
+	if pub := hex.EncodeToString(ed25519.NewKeyFromSeed(seed).Public().(ed25519.PublicKey)); pub != res.PublicKey {
+		t.Errorf("Rebuilt key %s does not match %s", pub, res.PublicKey)
+	}
+
+	if _, err := m.Attend(c.ID, carol); !errors.Is(err, errCeremonyState) {
+		t.Errorf("Expected a completed ceremony to be closed, got %v", err)
+	}
+
+	transcript, _ := m.Transcript(c.ID)
+	for _, want := range []string{res.PublicKey, res.ShareFingerprints[1], "alice", "bob"} {
+		if !strings.Contains(transcript, want) {
+			t.Errorf("Transcript is missing %q", want)
+		}
+	}
+	// shares never appear in the transcript
+	if strings.Contains(transcript, res.Shares[0]) {
+		t.Error("Transcript leaks a share")
+	}
+}
+
+// writeTestCert writes a self-signed localhost certificate and returns its paths
+func writeTestCert(t *testing.T) (certFile, keyFile string) {
+	t.Helper()
+
+	key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "localhost"},
+		DNSNames:     []string{"localhost"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("CreateCertificate failed: %v", err)
+	}
+	keyDER, _ := x509.MarshalECPrivateKey(key)
+
+	dir := t.TempDir()
+	certFile, keyFile = filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+	os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600)
+	os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0600)
+	return certFile, keyFile
+}
+
+func TestTLSSettingsFromEnv(t *testing.T) {
+	certFile, keyFile := writeTestCert(t)
+
+	tests := []struct {
+		name    string
+		env     map[string]string
+		wantNil bool
+		wantErr bool
+		min     uint16
+	}{
+		{"plaintext", map[string]string{}, true, false, 0},
+		{"cert w/o key", map[string]string{"STS_TLS_CERT": certFile}, true, true, 0},
+		{"defaults", map[string]string{"STS_TLS_CERT": certFile, "STS_TLS_KEY": keyFile}, false, false, tls.VersionTLS12},
+		{"tls 1.3", map[string]string{"STS_TLS_CERT": certFile, "STS_TLS_KEY": keyFile, "STS_TLS_MIN_VERSION": "1.3"}, false, false, tls.VersionTLS13},
+		{"tls 1.0", map[string]string{"STS_TLS_CERT": certFile, "STS_TLS_KEY": keyFile, "STS_TLS_MIN_VERSION": "1.0"}, true, true, 0},
+		{"insecure cipher", map[string]string{"STS_TLS_CERT": certFile, "STS_TLS_KEY": keyFile, "STS_TLS_CIPHERS": "TLS_RSA_WITH_RC4_128_SHA"}, true, true, 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			settings, err := TLSSettingsFromEnv(func(k string) string { return tt.env[k] })
+			if (err != nil) != tt.wantErr || (settings == nil) != tt.wantNil {
+				t.Fatalf("Got settings=%+v err=%v", settings, err)
+			}
+			if settings == nil {
+				return
+			}
+			cfg, err := settings.Config()
+			if err != nil || len(cfg.Certificates) != 1 || cfg.MinVersion != tt.min {
+				t.Errorf("Unexpected config %+v err=%v", cfg, err)
+			}
+		})
+	}
+}
+
+func TestCertReload(t *testing.T) {
+	certFile, keyFile := writeTestCert(t)
+	settings := &TLSSettings{CertFile: certFile, KeyFile: keyFile, MinVersion: tls.VersionTLS12}
+	cfg, certs, err := settings.ReloadingConfig()
+	if err != nil {
+		t.Fatalf("ReloadingConfig failed: %v", err)
+	}
+
+	// httptest fills in its own certificate, serve the config as is
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})}
+	go srv.Serve(tls.NewListener(lis, cfg))
+	defer srv.Close()
+	addr := lis.Addr().String()
+
+	serial := func() int64 {
+		conn, err := tls.Dial("tcp", addr, &tls.Config{InsecureSkipVerify: true})
+		if err != nil {
+			t.Fatalf("Handshake failed: %v", err)
+		}
+		defer conn.Close()
+		return conn.ConnectionState().PeerCertificates[0].SerialNumber.Int64()
+	}
+	if serial() != 1 {
+		t.Fatal("Expected the startup certificate")
+	}
+	if reloaded, err := certs.Reload(); reloaded || err != nil {
+		t.Errorf("Expected nothing to reload, got %v %v", reloaded, err)
+	}
+
+	// a half rotated pair keeps the old certificate
+	key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(2),
+		Subject:      pkix.Name{CommonName: "localhost"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, _ := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	keyDER, _ := x509.MarshalECPrivateKey(key)
+	os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600)
+	if _, err := certs.Reload(); err == nil {
+		t.Error("Expected a mismatched pair to be refused")
+	}
+	if serial() != 1 {
+		t.Error("Expected the old certificate to stay in use")
+	}
+
+	// an open connection isn't dropped by the rotation
+	open, err := tls.Dial("tcp", addr, &tls.Config{InsecureSkipVerify: true})
+	if err != nil {
+		t.Fatalf("Handshake failed: %v", err)
+	}
+	defer open.Close()
+
+	os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0600)
+	if reloaded, err := certs.Reload(); !reloaded || err != nil {
+		t.Fatalf("Expected the rotated pair to load, got %v %v", reloaded, err)
+	}
+	if serial() != 2 {
+		t.Error("Expected new handshakes to get the rotated certificate")
+	}
+	fmt.Fprintf(open, "GET / HTTP/1.1\r\nHost: localhost\r\n\r\n")
+	if res, err := http.ReadResponse(bufio.NewReader(open), nil); err != nil || res.StatusCode != http.StatusOK {
+		t.Errorf("Expected the open connection to keep working, got %v", err)
+	}
+}
+
+func TestMutualTLS_Principal(t *testing.T) {
+	certFile, keyFile := writeTestCert(t)
+
+	caKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	caTmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(2),
+		Subject:               pkix.Name{CommonName: "test client ca"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}
+	caDER, _ := x509.CreateCertificate(rand.Reader, caTmpl, caTmpl, &caKey.PublicKey, caKey)
+	caCert, _ := x509.ParseCertificate(caDER)
+	caFile := filepath.Join(t.TempDir(), "ca.pem")
+	os.WriteFile(caFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: caDER}), 0600)
+
+	clientKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	clientTmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(3),
+		Subject:      pkix.Name{CommonName: "payments-batch"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	clientDER, _ := x509.CreateCertificate(rand.Reader, clientTmpl, caCert, &clientKey.PublicKey, caKey)
+
+	settings := &TLSSettings{CertFile: certFile, KeyFile: keyFile, MinVersion: tls.VersionTLS12, ClientCAFile: caFile}
+	cfg, err := settings.Config()
+	if err != nil {
+		t.Fatalf("Config failed: %v", err)
+	}
+
+	s := &APIServer{Principals: PrincipalMap{"payments-batch": "payments"}}
+	srv := httptest.NewUnstartedServer(s.withPrincipal(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(PrincipalFromContext(r.Context())))
+	})))
+	srv.TLS = cfg
+	srv.StartTLS()
+	defer srv.Close()
+
+	client := func(certs []tls.Certificate) *http.Client {
+		// the server cert is self-signed, this test is about the client side
+		return &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{Certificates: certs, InsecureSkipVerify: true}}}
+	}
+
+	res, err := client([]tls.Certificate{{Certificate: [][]byte{clientDER}, PrivateKey: clientKey}}).Get(srv.URL)
+	if err != nil {
+		t.Fatalf("mTLS request failed: %v", err)
+	}
+	body, _ := io.ReadAll(res.Body)
+	res.Body.Close()
+	if string(body) != "payments" {
+		t.Errorf("Expected principal payments, got %q", body)
+	}
+
+	if _, err := client(nil).Get(srv.URL); err == nil {
+		t.Error("Expected a client w/o a certificate to be refused")
+	}
+}
+
+func signTestJWT(t *testing.T, kid string, key crypto.Signer, claims map[string]any) string {
+	t.Helper()
+
+	alg := "EdDSA"
+	if _, ok := key.(*ecdsa.PrivateKey); ok {
+		alg = "ES256"
+	}
+	header, _ := json.Marshal(map[string]string{"alg": alg, "kid": kid, "typ": "JWT"})
+	body, _ := json.Marshal(claims)
+	signed := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(body)
+
+	var sig []byte
+	switch k := key.(type) {
+	case ed25519.PrivateKey:
+		sig = ed25519.Sign(k, []byte(signed))
+	case *ecdsa.PrivateKey:
+		digest := sha256.Sum256([]byte(signed))
+		r, s, _ := ecdsa.Sign(rand.Reader, k, digest[:])
+		sig = make([]byte, 64)
+		r.FillBytes(sig[:32])
+		s.FillBytes(sig[32:])
+	}
+	return signed + "." + base64.RawURLEncoding.EncodeToString(sig)
+}
+
+func TestOIDCVerifier(t *testing.T) {
+	edPub, edKey, _ := ed25519.GenerateKey(rand.Reader)
+	ecKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+
+	var mu sync.Mutex
+	jwks := []map[string]string{{"kty": "OKP", "crv": "Ed25519", "kid": "ed", "x": base64.RawURLEncoding.EncodeToString(edPub)}}
+
+	var issuer string
+	idp := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		switch r.URL.Path {
+		case "/.well-known/openid-configuration":
+			json.NewEncoder(w).Encode(map[string]string{"issuer": issuer, "jwks_uri": issuer + "/jwks"})
+		case "/jwks":
+			json.NewEncoder(w).Encode(map[string]any{"keys": jwks})
+		}
+	}))
+	defer idp.Close()
+	issuer = idp.URL
+
+	v, err := NewOIDCVerifier(context.Background(), OIDCConfig{Issuer: issuer, Audience: "sts-svc"})
+	if err != nil {
+		t.Fatalf("NewOIDCVerifier failed: %v", err)
+	}
+
+	now := time.Now()
+	claims := func(overrides map[string]any) map[string]any {
+		c := map[string]any{"iss": issuer, "aud": "sts-svc", "sub": "alice", "roles": []string{"treasury"}, "exp": now.Add(time.Hour).Unix(), "iat": now.Unix()}
+		for k, val := range overrides {
+			c[k] = val
+		}
+		return c
+	}
+
+	alice := "oidc:" + issuer + "/alice"
+	op, err := v.Verify(context.Background(), signTestJWT(t, "ed", edKey, claims(nil)), now)
+	if err != nil || op.Name != alice || !op.HasRole("treasury") {
+		t.Fatalf("Expected %s w/ treasury, got %+v err=%v", alice, op, err)
+	}
+
+	// a sub matching a static operator doesn't become that operator
+	if op, err := v.Verify(context.Background(), signTestJWT(t, "ed", edKey, claims(map[string]any{"sub": "admin"})), now); err != nil || op.Name == "admin" {
+		t.Errorf("Expected the sub namespaced under the issuer, got %+v err=%v", op, err)
+	}
+	if op, err := v.Verify(context.Background(), signTestJWT(t, "ed", edKey, claims(map[string]any{"aud": []string{"other", "sts-svc"}})), now); err != nil || op.Name != alice {
+		t.Errorf("Expected an audience list naming us to verify, got %+v err=%v", op, err)
+	}
+
+	tests := []struct {
+		name  string
+		token string
+	}{
+		{"wrong audience", signTestJWT(t, "ed", edKey, claims(map[string]any{"aud": "other"}))},
+		{"audience w/ spaces", signTestJWT(t, "ed", edKey, claims(map[string]any{"aud": "other sts-svc"}))},
+		{"wrong issuer", signTestJWT(t, "ed", edKey, claims(map[string]any{"iss": "https://evil.example"}))},
+		{"expired", signTestJWT(t, "ed", edKey, claims(map[string]any{"exp": now.Add(-time.Hour).Unix()}))},
+		{"unknown key", signTestJWT(t, "ec", ecKey, claims(nil))},
+		{"tampered", strings.Replace(signTestJWT(t, "ed", edKey, claims(nil)), ".", ".e30", 1)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := v.Verify(context.Background(), tt.token, now); !errors.Is(err, errInvalidJWT) {
+				t.Errorf("Expected errInvalidJWT, got %v", err)
+			}
+		})
+	}
+
+	// a rotated in key is picked up once the refresh interval has passed
+	mu.Lock()
+	jwks = append(jwks, map[string]string{
+		"kty": "EC", "crv": "P-256", "kid": "ec",
+		"x": base64.RawURLEncoding.EncodeToString(ecKey.X.FillBytes(make([]byte, 32))),
+		"y": base64.RawURLEncoding.EncodeToString(ecKey.Y.FillBytes(make([]byte, 32))),
+	})
+	mu.Unlock()
+	v.fetchedAt = time.Now().Add(-2 * jwksMinRefresh)
+
+	if op, err := v.Verify(context.Background(), signTestJWT(t, "ec", ecKey, claims(nil)), now); err != nil || op.Name != alice {
+		t.Errorf("Expected the rotated key to verify, got %+v err=%v", op, err)
+	}
+}
+
+func TestAPITokens_ScopeAndRevocation(t *testing.T) {
+	svc := NewSignerService(NewSecureKeyStore())
+	server := NewAPIServer(svc)
+	server.Tokens = NewAPITokenStore()
+	server.RequireAPIToken = true
+
+	a, _ := svc.GenerateKey(context.Background(), KeyGenRequest{Policy: &KeyPolicy{Usage: UsagePersistent}})
+	b, _ := svc.GenerateKey(context.Background(), KeyGenRequest{Policy: &KeyPolicy{Usage: UsagePersistent}})
+
+	meta, token, err := server.Tokens.Mint(APITokenRequest{Name: "payouts", KeyIDs: []string{a.PublicKey}, Operations: []string{TokenOpSign}}, "alice", time.Now())
+	if err != nil || !strings.HasPrefix(token, apiTokenPrefix) {
+		t.Fatalf("Mint failed: %q err=%v", token, err)
+	}
+
+	sign := func(keyID, token string) int {
+		body := fmt.Sprintf(`{"keyId": %q, "unsignedTxData": %q, "context": "payout"}`, keyID, base64.StdEncoding.EncodeToString([]byte("payout")))
+		r := httptest.NewRequest(http.MethodPost, "/api/v1/txs/sign", strings.NewReader(body))
+		if token != "" {
+			r.Header.Set("X-API-Key", token)
+		}
+		w := httptest.NewRecorder()
+		server.handleTxSign(w, r)
+		return w.Code
+	}
+
+	tests := []struct {
+		name   string
+		keyID  string
+		token  string
+		status int
+	}{
+		{"in scope", a.PublicKey, token, http.StatusOK},
+		{"other key", b.PublicKey, token, http.StatusForbidden},
+		{"no token", a.PublicKey, "", http.StatusUnauthorized},
+		{"forged secret", a.PublicKey, apiTokenPrefix + meta.ID + "_" + strings.Repeat("00", 32), http.StatusUnauthorized},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := sign(tt.keyID, tt.token); got != tt.status {
+				t.Errorf("Expected %d, got %d", tt.status, got)
+			}
+		})
+	}
+
+	r := httptest.NewRequest(http.MethodPost, "/api/v1/keys/generate", nil)
+	r.Header.Set("X-API-Key", token)
+	w := httptest.NewRecorder()
+	server.handleGenKey(w, r)
+	if w.Code != http.StatusForbidden {
+		t.Errorf("Expected a sign only token to be refused key generation, got %d", w.Code)
+	}
+
+	server.Tokens.Revoke(meta.ID, "bob", time.Now())
+	if got := sign(a.PublicKey, token); got != http.StatusUnauthorized {
+		t.Errorf("Expected a revoked token to be refused, got %d", got)
+	}
+	if list := server.Tokens.List(); len(list) != 1 || list[0].RevokedBy != "bob" {
+		t.Errorf("Expected the revoked token in the list, got %+v", list)
+	}
+}
+
+func TestAPITokens_ReadRoutes(t *testing.T) {
+	svc := NewSignerService(NewSecureKeyStore())
+	server := NewAPIServer(svc)
+	server.Operators, _ = ParseOperators("alice:tok")
+	server.Tokens = NewAPITokenStore()
+	server.RequireAPIToken = true
+	server.Approvals = NewApprovalQueue()
+
+	a, _ := svc.GenerateKey(context.Background(), KeyGenRequest{Policy: &KeyPolicy{Usage: UsagePersistent}})
+	b, _ := svc.GenerateKey(context.Background(), KeyGenRequest{Policy: &KeyPolicy{Usage: UsagePersistent}})
+	server.StaleKeys = &StaleKeyAnalyzer{report: StaleKeyReport{Recommendations: []RetirementRecommendation{{KeyID: a.PublicKey}, {KeyID: b.PublicKey}}}}
+	handler := server.middleware(server.routes())
+	_, reader, _ := server.Tokens.Mint(APITokenRequest{Name: "sdk", KeyIDs: []string{a.PublicKey}, Operations: []string{TokenOpRead}}, "alice", time.Now())
+	_, signer, _ := server.Tokens.Mint(APITokenRequest{Name: "payouts", KeyIDs: []string{a.PublicKey}, Operations: []string{TokenOpSign}}, "alice", time.Now())
+	pa, _ := server.Approvals.Submit(context.Background(), TransactionRequest{KeyID: b.PublicKey}, "over threshold", defaultApprovalQuorum())
+
+	get := func(path, header, value string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodGet, path, nil)
+		if header != "" {
+			r.Header.Set(header, value)
+		}
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+		return w
+	}
+
+	for _, path := range []string{"/api/v1/fips", "/api/v1/version", "/api/v1/keys/stale", "/api/v1/approvals/" + pa.ID} {
+		if w := get(path, "", ""); w.Code != http.StatusUnauthorized {
+			t.Errorf("Expected %s w/o a token to be refused, got %d", path, w.Code)
+		}
+		if w := get(path, "Authorization", "Bearer tok"); w.Code != http.StatusOK {
+			t.Errorf("Expected operators to read %s, got %d", path, w.Code)
+		}
+	}
+
+	// a token only sees the stale keys it is scoped to
+	var report StaleKeyReport
+	json.Unmarshal(get("/api/v1/keys/stale", "X-API-Key", reader).Body.Bytes(), &report)
+	if len(report.Recommendations) != 1 || report.Recommendations[0].KeyID != a.PublicKey {
+		t.Errorf("Expected only the token's key, got %+v", report.Recommendations)
+	}
+	if len(server.StaleKeys.Report().Recommendations) != 2 {
+		t.Error("Filtering must not change the analyzer's report")
+	}
+
+	if w := get("/api/v1/approvals/"+pa.ID, "X-API-Key", reader); w.Code != http.StatusForbidden {
+		t.Errorf("Expected a token scoped to another key to be refused the approval, got %d", w.Code)
+	}
+	mine, _ := server.Approvals.Submit(context.Background(), TransactionRequest{KeyID: a.PublicKey}, "over threshold", defaultApprovalQuorum())
+	if w := get("/api/v1/approvals/"+mine.ID, "X-API-Key", signer); w.Code != http.StatusOK {
+		t.Errorf("Expected the sign token to poll its parked request, got %d", w.Code)
+	}
+}
+
+func TestRequestLimiter(t *testing.T) {
+	s := &APIServer{Limiter: NewRequestLimiter(RequestLimits{GlobalRPS: 100, GlobalBurst: 3, ClientRPS: 1, ClientBurst: 2})}
+	handler := s.withRateLimit(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	call := func(remote string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		r.RemoteAddr = remote
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+		return w
+	}
+
+	for i := range 2 {
+		if w := call("10.0.0.1:1000"); w.Code != http.StatusOK {
+			t.Fatalf("Request %d within burst got %d", i, w.Code)
+		}
+	}
+	w := call("10.0.0.1:1000")
+	if w.Code != http.StatusTooManyRequests || w.Header().Get("Retry-After") == "" {
+		t.Errorf("Expected 429 w/ Retry-After, got %d %q", w.Code, w.Header().Get("Retry-After"))
+	}
+
+	// another client has its own bucket but shares the global one
+	if w := call("10.0.0.2:1000"); w.Code != http.StatusOK {
+		t.Errorf("Expected a second client to be served, got %d", w.Code)
+	}
+	if w := call("10.0.0.3:1000"); w.Code != http.StatusTooManyRequests {
+		t.Errorf("Expected the global limit to apply, got %d", w.Code)
+	}
+
+	limits, err := RequestLimitsFromEnv(func(k string) string { return map[string]string{"STS_RATE_LIMIT_CLIENT_RPS": "5"}[k] })
+	if err != nil || limits.ClientRPS != 5 || limits.ClientBurst != 10 || limits.GlobalRPS != 500 {
+		t.Errorf("Unexpected limits %+v err=%v", limits, err)
+	}
+}
+
+func TestWithRequestID(t *testing.T) {
+	var seen string
+	handler := withRequestID(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		seen = RequestIDFromContext(r.Context())
+	}))
+
+	tests := []struct {
+		name   string
+		header string
+		keep   bool
+	}{
+		{"generated", "", false},
+		{"honoured", "support-case_42.1", true},
+		{"unsafe replaced", "bad id\nInjected log line", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/", nil)
+			if tt.header != "" {
+				r.Header.Set("X-Request-ID", tt.header)
+			}
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, r)
+
+			got := w.Header().Get("X-Request-ID")
+			if got == "" || got != seen {
+				t.Fatalf("Response id %q does not match context id %q", got, seen)
+			}
+			if (got == tt.header) != tt.keep {
+				t.Errorf("Header %q gave id %q", tt.header, got)
+			}
+		})
+	}
+}
+
+func TestWriteError_Structured(t *testing.T) {
+	tests := []struct {
+		name    string
+		status  int
+		err     error
+		code    string
+		details map[string]string
+	}{
+		{"sentinel", http.StatusLocked, fmt.Errorf(`%w: key "a\b" frozen`, errKeyFrozen), "key_frozen", nil},
+		{"decode", http.StatusBadRequest, invalidBody(errors.New(`unexpected "}"`)), "invalid_body", nil},
+		{"fallback", http.StatusNotFound, errors.New("no such thing"), "not_found", nil},
+		{"details", http.StatusTooManyRequests, withDetails(errRequestRateLimited, map[string]string{"retry_after": "2"}), "rate_limited", map[string]string{"retry_after": "2"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			handler := withRequestID(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				writeError(w, r, tt.status, tt.err)
+			}))
+			r := httptest.NewRequest(http.MethodGet, "/", nil)
+			r.Header.Set("X-Request-ID", "case-1")
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, r)
+
+			if w.Code != tt.status {
+				t.Errorf("Got status %d, wanted %d", w.Code, tt.status)
+			}
+			if ct := w.Header().Get("Content-Type"); ct != "application/json" {
+				t.Errorf("Got content type %q", ct)
+			}
+			var res ErrorResponse
+			if err := json.Unmarshal(w.Body.Bytes(), &res); err != nil {
+				t.Fatalf("Error body is not valid JSON: %v: %s", err, w.Body)
+			}
+			if res.Code != tt.code || res.Message != tt.err.Error() || res.RequestID != "case-1" {
+				t.Errorf("Unexpected error response %+v", res)
+			}
+			if tt.details != nil && res.Details["retry_after"] != tt.details["retry_after"] {
+				t.Errorf("Got details %v, wanted %v", res.Details, tt.details)
+			}
+		})
+	}
+}
+
+func TestOpenAPI_MatchesRoutes(t *testing.T) {
+	svc := NewSignerService(NewSecureKeyStore())
+	server := NewAPIServer(svc)
+	server.Operators, _ = ParseOperators("alice:tok")
+	server.Store = NewSecureKeyStore()
+	server.Grants = svc.grants
+	server.Attester, _ = NewAttester("")
+	router := server.routes()
+
+	// every documented operation must be served by the same route
+	for _, op := range server.apiOperations() {
+		path := pathParam.ReplaceAllString(op.Path, "x")
+		_, pattern := router.Handler(httptest.NewRequest(op.Method, path, nil))
+		if pattern != op.Method+" "+op.Path {
+			t.Errorf("%s %s is documented but routed to %q", op.Method, op.Path, pattern)
+		}
+	}
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/api/v1/openapi.json", nil))
+	var doc struct {
+		OpenAPI    string                               `json:"openapi"`
+		Paths      map[string]map[string]map[string]any `json:"paths"`
+		Components struct {
+			Schemas map[string]struct {
+				Properties map[string]any `json:"properties"`
+			} `json:"schemas"`
+		} `json:"components"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &doc); err != nil {
+		t.Fatalf("Spec is not valid JSON: %v", err)
+	}
+	if doc.OpenAPI == "" || doc.Paths["/api/v1/txs/sign"]["post"] == nil || doc.Paths["/api/v1/grants/{id}"]["delete"] == nil {
+		t.Fatalf("Spec is missing operations: %v", doc.Paths)
+	}
+	if _, ok := doc.Components.Schemas["TransactionRequest"].Properties["unsignedTxData"]; !ok {
+		t.Error("Expected TransactionRequest fields from its json tags")
+	}
+	if _, ok := doc.Components.Schemas["ErrorResponse"].Properties["request_id"]; !ok {
+		t.Error("Expected the ErrorResponse schema")
+	}
+
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/api/v1/docs", nil))
+	if strings.Contains(w.Body.String(), "swagger-ui") {
+		t.Error("Expected Swagger UI to stay off unless enabled")
+	}
+}
+
+func TestGRPCServer(t *testing.T) {
+	store := NewSecureKeyStore()
+	server := NewAPIServer(NewSignerService(store))
+	server.Operators, _ = ParseOperators("alice:tok")
+	server.Store = store
+
+	lis := bufconn.Listen(1 << 20)
+	grpcServer := server.NewGRPCServer()
+	go grpcServer.Serve(lis)
+	defer grpcServer.Stop()
+
+	conn, err := grpc.NewClient("passthrough:///bufnet",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) { return lis.DialContext(ctx) }),
+		grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatalf("Failed to dial: %v", err)
+	}
+	defer conn.Close()
+	client := signerpb.NewSignerClient(conn)
+	ctx := context.Background()
+
+	acc, err := client.GenerateKey(ctx, &signerpb.GenerateKeyRequest{PolicyJson: []byte(`{"usage":"persistent"}`)})
+	if err != nil {
+		t.Fatalf("GenerateKey failed: %v", err)
+	}
+
+	var header metadata.MD
+	res, err := client.SignTransaction(ctx, &signerpb.SignRequest{
+		KeyId:          acc.PublicKey,
+		UnsignedTxData: base64.StdEncoding.EncodeToString([]byte("tx-data")),
+		Context:        "payout",
+	}, grpc.Header(&header))
+	if err != nil {
+		t.Fatalf("SignTransaction failed: %v", err)
+	}
+	if res.Signature == "" || len(header.Get("x-request-id")) != 1 {
+		t.Errorf("Expected a signature and request id, got %v %v", res, header)
+	}
+
+	// the solana-tx options reach the signer, they need that context
+	options := map[string]func(*signerpb.SignRequest){
+		"queue":             func(r *signerpb.SignRequest) { r.Queue = true },
+		"notify commitment": func(r *signerpb.SignRequest) { r.NotifyCommitment = "finalized" },
+		"cluster":           func(r *signerpb.SignRequest) { r.Cluster = "devnet" },
+		"refresh blockhash": func(r *signerpb.SignRequest) { r.RefreshBlockhash = true },
+		"nonce account":     func(r *signerpb.SignRequest) { r.NonceAccount = acc.PublicKey },
+		"priority fee":      func(r *signerpb.SignRequest) { r.PriorityFee = true },
+		"fee payer":         func(r *signerpb.SignRequest) { r.FeePayer = true },
+	}
+	for name, set := range options {
+		in := &signerpb.SignRequest{KeyId: acc.PublicKey, UnsignedTxData: base64.StdEncoding.EncodeToString([]byte("tx-data")), Context: "payout"}
+		set(in)
+		if _, err := client.SignTransaction(ctx, in); err == nil || !strings.Contains(err.Error(), SolanaTxContext) {
+			t.Errorf("Expected %s to need the %s context, got %v", name, SolanaTxContext, err)
+		}
+	}
+
+	// key management needs an operator, and errors carry the stable code
+	_, err = client.FreezeKey(ctx, &signerpb.FreezeKeyRequest{KeyId: acc.PublicKey, Reason: "incident"})
+	if status.Code(err) != codes.Unauthenticated {
+		t.Fatalf("Expected unauthenticated freeze, got %v", err)
+	}
+	var reason string
+	for _, d := range status.Convert(err).Details() {
+		if info, ok := d.(*errdetails.ErrorInfo); ok {
+			reason = info.Reason
+		}
+	}
+	if reason != "operator_required" {
+		t.Errorf("Got error reason %q", reason)
+	}
+
+	opCtx := metadata.AppendToOutgoingContext(ctx, "authorization", "Bearer tok")
+	key, err := client.FreezeKey(opCtx, &signerpb.FreezeKeyRequest{KeyId: acc.PublicKey, Reason: "incident"})
+	if err != nil || !key.Frozen || key.FrozenBy != "alice" {
+		t.Fatalf("Expected alice to freeze the key, got %v %v", key, err)
+	}
+
+	_, err = client.SignTransaction(ctx, &signerpb.SignRequest{
+		KeyId:          acc.PublicKey,
+		UnsignedTxData: base64.StdEncoding.EncodeToString([]byte("tx-data")),
+		Context:        "payout",
+	})
+	if status.Code(err) != codes.FailedPrecondition {
+		t.Errorf("Expected frozen key to fail precondition, got %v", err)
+	}
+}
+
+func TestGRPCServer_SignStream(t *testing.T) {
+	store := NewSecureKeyStore()
+	server := NewAPIServer(NewSignerService(store))
+
+	lis := bufconn.Listen(1 << 20)
+	grpcServer := server.NewGRPCServer()
+	go grpcServer.Serve(lis)
+	defer grpcServer.Stop()
+
+	conn, err := grpc.NewClient("passthrough:///bufnet",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) { return lis.DialContext(ctx) }),
+		grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatalf("Failed to dial: %v", err)
+	}
+	defer conn.Close()
+	client := signerpb.NewSignerClient(conn)
+	ctx := context.Background()
+
+	acc, err := client.GenerateKey(ctx, &signerpb.GenerateKeyRequest{PolicyJson: []byte(`{"usage":"persistent"}`)})
+	if err != nil {
+		t.Fatalf("GenerateKey failed: %v", err)
+	}
+
+	stream, err := client.SignStream(ctx)
+	if err != nil {
+		t.Fatalf("SignStream failed: %v", err)
+	}
+	const n = 20
+	for i := range n {
+		keyID := acc.PublicKey
+		if i == 7 {
+			keyID = "missing"
+		}
+		err := stream.Send(&signerpb.SignStreamRequest{Ref: strconv.Itoa(i), Request: &signerpb.SignRequest{
+			KeyId:          keyID,
+			UnsignedTxData: base64.StdEncoding.EncodeToString([]byte("tx-" + strconv.Itoa(i))),
+			Context:        "payout",
+		}})
+		if err != nil {
+			t.Fatalf("Send %d failed: %v", i, err)
+		}
+	}
+	stream.CloseSend()
+
+	seen := map[string]bool{}
+	for {
+		res, err := stream.Recv()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatalf("Recv failed: %v", err)
+		}
+		seen[res.Ref] = true
+		if res.Ref == "7" {
+			if res.GetError() == nil {
+				t.Error("Expected the unknown key to fail w/o ending the stream")
+			}
+		} else if res.GetSigned().GetSignature() == "" {
+			t.Errorf("Request %s got %v", res.Ref, res)
+		}
+	}
+	if len(seen) != n {
+		t.Errorf("Got %d of %d responses", len(seen), n)
+	}
+
+	// a draining server tells open streams to go elsewhere
+	stream, err = client.SignStream(ctx)
+	if err != nil {
+		t.Fatalf("SignStream failed: %v", err)
+	}
+	server.ReconnectHint = "replica-2:9090"
+	server.BeginDrain("maintenance")
+	res, err := stream.Recv()
+	if err != nil || res.GetGoAway().GetReconnectTo() != "replica-2:9090" {
+		t.Errorf("Expected a go away notice, got %v %v", res, err)
+	}
+}
+
+func TestEventStream(t *testing.T) {
+	svc := NewSignerService(NewSecureKeyStore())
+	svc.events = NewEventBus()
+	server := NewAPIServer(svc)
+	server.Operators, _ = ParseOperators("alice:tok")
+	server.Events = svc.events
+
+	ts := httptest.NewServer(server.middleware(server.routes()))
+	defer ts.Close()
+	wsURL := "ws" + strings.TrimPrefix(ts.URL, "http") + "/api/v1/events?types=key.created,sign.signed,sign.failed"
+
+	if _, err := websocket.Dial(wsURL, "", ts.URL); err == nil {
+		t.Fatal("Expected the event stream to need an operator")
+	}
+
+	config, _ := websocket.NewConfig(wsURL, ts.URL)
+	config.Header.Set("Authorization", "Bearer tok")
+	ws, err := websocket.DialConfig(config)
+	if err != nil {
+		t.Fatalf("Failed to open the event stream: %v", err)
+	}
+	defer ws.Close()
+
+	// the subscription is registered once the handler runs
+	for range 50 {
+		svc.events.mu.Lock()
+		n := len(svc.events.subs)
+		svc.events.mu.Unlock()
+		if n > 0 {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	ctx := WithRequestID(context.Background(), "req-1")
+	acc, err := svc.GenerateKey(ctx, KeyGenRequest{})
+	if err != nil {
+		t.Fatalf("GenerateKey failed: %v", err)
+	}
+	svc.SignTransaction(ctx, TransactionRequest{KeyID: acc.PublicKey, UnsignedTxData: base64.StdEncoding.EncodeToString([]byte("tx")), Context: "payout"})
+	svc.SignTransaction(ctx, TransactionRequest{KeyID: acc.PublicKey, UnsignedTxData: base64.StdEncoding.EncodeToString([]byte("tx")), Context: "payout"})
+
+	// key.destroyed is filtered out
+	want := []string{EventKeyCreated, EventSignSigned, EventSignFailed}
+	ws.SetReadDeadline(time.Now().Add(5 * time.Second))
+	for _, typ := range want {
+		var e Event
+		if err := websocket.JSON.Receive(ws, &e); err != nil {
+			t.Fatalf("Failed to read %s: %v", typ, err)
+		}
+		if e.Type != typ || e.KeyID != acc.PublicKey || e.RequestID != "req-1" {
+			t.Errorf("Got event %+v, wanted %s", e, typ)
+		}
+	}
+
+	server.BeginDrain("maintenance")
+	var e Event
+	if err := websocket.JSON.Receive(ws, &e); err != nil || e.Type != "goaway" || e.GoAway == nil {
+		t.Errorf("Expected a goaway event, got %+v %v", e, err)
+	}
+}
+
+func TestHealthProbes(t *testing.T) {
+	rpcHealth := "ok"
+	rpc := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if rpcHealth != "ok" {
+			w.Write([]byte(`{"jsonrpc":"2.0","id":1,"error":{"code":-32005,"message":"Node is behind"}}`))
+			return
+		}
+		w.Write([]byte(`{"jsonrpc":"2.0","id":1,"result":"ok"}`))
+	}))
+	defer rpc.Close()
+
+	store := NewSecureKeyStore()
+	seal := NewSealState()
+	server := NewAPIServer(NewSignerService(store))
+	server.Readiness = []ReadinessCheck{StoreCheck(store), SealCheck(seal), RPCCheck(NewSolanaRPC(rpc.URL, 0))}
+	// probes answer even when every request must be signed
+	server.HMAC, _ = ParseHMACClients("svc:secret", true)
+	handler := server.probes(server.middleware(server.routes()))
+
+	probe := func(path string) (int, HealthReport) {
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, path, nil))
+		var report HealthReport
+		json.Unmarshal(w.Body.Bytes(), &report)
+		return w.Code, report
+	}
+
+	tests := []struct {
+		name   string
+		setup  func()
+		code   int
+		status string
+	}{
+		{"ready", func() {}, http.StatusOK, "ok"},
+		{"rpc behind", func() { rpcHealth = "behind" }, http.StatusOK, "degraded"},
+		{"sealed", func() { seal.Seal("", SealCauseMaintenance, "incident", "alice") }, http.StatusServiceUnavailable, "unavailable"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.setup()
+			code, report := probe("/readyz")
+			if code != tt.code || report.Status != tt.status {
+				t.Errorf("Got %d %+v, wanted %d %s", code, report, tt.code, tt.status)
+			}
+		})
+	}
+
+	if code, report := probe("/healthz"); code != http.StatusOK || report.Status != "ok" {
+		t.Errorf("Expected liveness to stay ok, got %d %+v", code, report)
+	}
+	if code, _ := probe("/api/v1/fips"); code != http.StatusUnauthorized {
+		t.Errorf("Expected API routes to still need a signature, got %d", code)
+	}
+}
+
+func TestMetrics(t *testing.T) {
+	store := NewSecureKeyStore()
+	svc := NewSignerService(store)
+	svc.metrics = NewMetrics(store)
+	svc.metrics.LabelTenants(nil, 2)
+	server := NewAPIServer(svc)
+	server.Metrics = svc.metrics
+	router := server.routes()
+	handler := server.probes(server.withMetrics(router, server.middleware(router)))
+
+	acc, err := svc.GenerateKey(context.Background(), KeyGenRequest{})
+	if err != nil {
+		t.Fatalf("GenerateKey failed: %v", err)
+	}
+	for _, keyID := range []string{acc.PublicKey, acc.PublicKey} {
+		body := fmt.Sprintf(`{"keyId": %q, "unsignedTxData": "dHg=", "context": "payout"}`, keyID)
+		handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, "/api/v1/txs/sign", strings.NewReader(body)))
+	}
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, "/api/v1/keys/"+acc.PublicKey+"/freeze", nil))
+	// the cap is two, default and acme get series, globex doesn't
+	for _, tenant := range []string{"acme", "globex"} {
+		req := httptest.NewRequest(http.MethodPost, "/api/v1/txs/sign", strings.NewReader(`{}`))
+		req.Header.Set("X-Tenant-ID", tenant)
+		handler.ServeHTTP(httptest.NewRecorder(), req)
+	}
+
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	out := w.Body.String()
+	for _, want := range []string{
+		`sts_keys_generated_total{key_type="ed25519",tenant="default"} 1`,
+		`sts_sign_requests_total{key_type="ed25519",outcome="signed",tenant="default"} 1`,
+		`sts_http_requests_total{code="200",method="POST",route="POST /api/v1/txs/sign",tenant="default"} 1`,
+		`sts_http_requests_total{code="400",method="POST",route="POST /api/v1/txs/sign",tenant="default"} 1`,
+		`sts_http_requests_total{code="400",method="POST",route="POST /api/v1/txs/sign",tenant="acme"} 1`,
+		`sts_http_requests_total{code="400",method="POST",route="POST /api/v1/txs/sign",tenant="other"} 1`,
+		`sts_metrics_tenant_overflow_total 1`,
+		`sts_keystore_keys 0`,
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("Metrics missing %s", want)
+		}
+	}
+	// key ids never become labels
+	if strings.Contains(out, acc.PublicKey) {
+		t.Error("Expected no key ids in metrics")
+	}
+	if strings.Contains(out, "globex") {
+		t.Error("Expected tenants past the cap to be counted as other")
+	}
+
+	allowed := newTenantLabels([]string{"acme"}, 1)
+	for tenant, want := range map[string]string{"acme": "acme", defaultTenant: defaultTenant, "globex": "other", strings.Repeat("a", 65): "other"} {
+		if got := allowed.label(tenant); got != want {
+			t.Errorf("label(%q) = %q, want %q", tenant, got, want)
+		}
+	}
+}
+
+func TestDiagnostics(t *testing.T) {
+	tests := []struct {
+		addr string
+		ok   bool
+	}{
+		{"127.0.0.1:6060", true},
+		{"[::1]:6060", true},
+		{"localhost:6060", true},
+		{":6060", false},
+		{"0.0.0.0:6060", false},
+		{"10.0.0.5:6060", false},
+	}
+	for _, tt := range tests {
+		if err := checkLoopback(tt.addr); (err == nil) != tt.ok {
+			t.Errorf("checkLoopback(%q) = %v", tt.addr, err)
+		}
+	}
+
+	handler := DiagnosticsHandler()
+	for _, path := range []string{"/debug/pprof/", "/debug/vars", "/debug/pprof/goroutine?debug=1"} {
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, path, nil))
+		if w.Code != http.StatusOK {
+			t.Errorf("%s returned %d", path, w.Code)
+		}
+	}
+
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/debug/runtime", nil))
+	var stats RuntimeStats
+	if err := json.Unmarshal(w.Body.Bytes(), &stats); err != nil || stats.Goroutines == 0 || stats.GoVersion == "" {
+		t.Errorf("Unexpected runtime stats %+v: %v", stats, err)
+	}
+}
+
+func TestGracefulShutdown(t *testing.T) {
+	store := NewSecureKeyStore()
+	svc := NewSignerService(store)
+	svc.events = NewEventBus()
+	server := NewAPIServer(svc)
+	server.Operators, _ = ParseOperators("alice:tok")
+	server.Events = svc.events
+	server.Store = store
+	server.Config.ShutdownTimeout = 5 * time.Second
+
+	if _, err := svc.GenerateKey(context.Background(), KeyGenRequest{}); err != nil {
+		t.Fatalf("GenerateKey failed: %v", err)
+	}
+
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	served := make(chan error, 1)
+	go func() { served <- server.Serve(ctx, lis) }()
+
+	base := "http://" + lis.Addr().String()
+	config, _ := websocket.NewConfig("ws://"+lis.Addr().String()+"/api/v1/events", base)
+	config.Header.Set("Authorization", "Bearer tok")
+	ws, err := websocket.DialConfig(config)
+	if err != nil {
+		t.Fatalf("Failed to open the event stream: %v", err)
+	}
+	defer ws.Close()
+	for range 50 {
+		if server.Drainer.Open() > 0 {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	cancel()
+
+	// the open stream is told to go away before the server returns
+	var e Event
+	ws.SetReadDeadline(time.Now().Add(5 * time.Second))
+	if err := websocket.JSON.Receive(ws, &e); err != nil || e.Type != "goaway" || e.GoAway.Reason != "shutdown" {
+		t.Errorf("Expected a shutdown goaway, got %+v %v", e, err)
+	}
+
+	select {
+	case err := <-served:
+		if err != nil {
+			t.Errorf("Serve returned %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Serve did not return after shutdown")
+	}
+
+	if n := store.Len(); n != 0 {
+		t.Errorf("Expected keys to be zeroized, %d left", n)
+	}
+	if _, err := http.Get(base + "/healthz"); err == nil {
+		t.Error("Expected the listener to be closed")
+	}
+}
+
+func TestConfigFromFlags(t *testing.T) {
+	tests := []struct {
+		name string
+		env  map[string]string
+		args []string
+		want func(Config) bool
+		ok   bool
+	}{
+		{"defaults", nil, nil, func(c Config) bool { return reflect.DeepEqual(c, DefaultConfig()) }, true},
+		{"env", map[string]string{"STS_ADDR": ":9000", "STS_WRITE_TIMEOUT": "1m", "STS_MAX_BODY_BYTES": "65536"}, nil, func(c Config) bool {
+			return c.Addr == ":9000" && c.WriteTimeout == time.Minute && c.MaxBodyBytes == 65536
+		}, true},
+		{"flags override env", map[string]string{"STS_ADDR": ":9000"}, []string{"-addr", "127.0.0.1:8443", "-shutdown-timeout", "5s"}, func(c Config) bool {
+			return c.Addr == "127.0.0.1:8443" && c.ShutdownTimeout == 5*time.Second
+		}, true},
+		{"bad duration", map[string]string{"STS_READ_TIMEOUT": "soon"}, nil, nil, false},
+		{"negative timeout", nil, []string{"-idle-timeout", "-1s"}, nil, false},
+		{"tiny body limit", nil, []string{"-max-body-bytes", "10"}, nil, false},
+		{"unknown backend", map[string]string{"STS_BACKEND": "vault"}, nil, nil, false},
+		{"unknown flag", nil, []string{"-port", "80"}, nil, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, err := ConfigFromFlags(tt.args, func(k string) string { return tt.env[k] })
+			if (err == nil) != tt.ok {
+				t.Fatalf("ConfigFromFlags() error = %v, wanted ok %v", err, tt.ok)
+			}
+			if tt.ok && !tt.want(c) {
+				t.Errorf("Unexpected config %+v", c)
+			}
+		})
+	}
+
+	// handlers take the configured body limit
+	server := NewAPIServer(NewSignerService(NewSecureKeyStore()))
+	server.Config.MaxBodyBytes = 1024
+	body := `{"keyId":"k","unsignedTxData":"` + strings.Repeat("A", 2048) + `"}`
+	w := httptest.NewRecorder()
+	server.handleTxSign(w, httptest.NewRequest(http.MethodPost, "/api/v1/txs/sign", strings.NewReader(body)))
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("Expected an oversized body to be refused, got %d", w.Code)
+	}
+}
+
+func TestConfigFile_Reload(t *testing.T) {
+	defer SetLogLevel("info")
+
+	path := filepath.Join(t.TempDir(), "sts.yaml")
+	write := func(body string) {
+		if err := os.WriteFile(path, []byte(body), 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write(`
+server:
+  addr: ":9000"
+  writeTimeout: 20s
+store:
+  backend: memory
+logging:
+  level: warn
+rateLimits:
+  clientRps: 5
+policies:
+  anomaly:
+    action: block
+`)
+
+	// the file sits between the defaults and env vars
+	c, err := ConfigFromFlags([]string{"-config", path}, func(k string) string { return map[string]string{"STS_ADDR": ":9100"}[k] })
+	if err != nil {
+		t.Fatalf("ConfigFromFlags failed: %v", err)
+	}
+	if c.Addr != ":9100" || c.WriteTimeout != 20*time.Second || c.File != path {
+		t.Errorf("Unexpected config %+v", c)
+	}
+
+	limiter := NewRequestLimiter(RequestLimits{GlobalRPS: 500, GlobalBurst: 1000, ClientRPS: 50, ClientBurst: 100})
+	anomalies := NewAnomalyDetector(DefaultAnomalyConfig())
+	reloader := &ConfigReloader{
+		Path:        path,
+		Limiter:     limiter,
+		Anomalies:   anomalies,
+		BaseLimits:  limiter.limits,
+		BaseAnomaly: DefaultAnomalyConfig(),
+	}
+	if err := reloader.Reload(); err != nil {
+		t.Fatalf("Reload failed: %v", err)
+	}
+	if limiter.limits.ClientRPS != 5 || limiter.limits.ClientBurst != 10 || limiter.limits.GlobalRPS != 500 {
+		t.Errorf("Rate limits not applied: %+v", limiter.limits)
+	}
+	if !anomalies.blocks() || logEnabled(levelInfo) {
+		t.Error("Expected the anomaly policy and log level to be applied")
+	}
+
+	// dropping a setting goes back to the base, a bad file changes nothing
+	write("rateLimits:\n  clientRps: 7\n")
+	if err := reloader.Reload(); err != nil {
+		t.Fatalf("Reload failed: %v", err)
+	}
+	if limiter.limits.ClientRPS != 7 || anomalies.blocks() || !logEnabled(levelInfo) {
+		t.Errorf("Expected removed settings to revert, limits %+v", limiter.limits)
+	}
+	for _, body := range []string{"rateLimits:\n  clientRps: -1\n", "logging:\n  level: loud\n", "ratelimits:\n  clientRps: 3\n"} {
+		write(body)
+		if err := reloader.Reload(); err == nil {
+			t.Errorf("Expected %q to be refused", body)
+		}
+		if limiter.limits.ClientRPS != 7 {
+			t.Errorf("Bad file %q changed the limits: %+v", body, limiter.limits)
+		}
+	}
+}
+
+func TestCORS(t *testing.T) {
+	if p, err := CORSPolicyFromEnv(func(string) string { return "" }); p != nil || err != nil {
+		t.Fatalf("Expected CORS off w/o origins, got %+v %v", p, err)
+	}
+	for _, origins := range []string{"admin.example.com", "ftp://admin.example.com", "https://admin.example.com/app"} {
+		if _, err := CORSPolicyFromEnv(func(k string) string { return map[string]string{"STS_CORS_ORIGINS": origins}[k] }); err == nil {
+			t.Errorf("Expected origin %q to be refused", origins)
+		}
+	}
+
+	policy, err := CORSPolicyFromEnv(func(k string) string {
+		return map[string]string{"STS_CORS_ORIGINS": "https://admin.example.com, http://localhost:3000", "STS_CORS_MAX_AGE": "1h"}[k]
+	})
+	if err != nil {
+		t.Fatalf("CORSPolicyFromEnv failed: %v", err)
+	}
+	server := NewAPIServer(NewSignerService(NewSecureKeyStore()))
+	server.CORS = policy
+	handler := server.withCORS(server.middleware(server.routes()))
+
+	tests := []struct {
+		name       string
+		method     string
+		origin     string
+		preflight  bool
+		wantStatus int
+		wantAllow  string
+	}{
+		{"preflight", http.MethodOptions, "https://admin.example.com", true, http.StatusNoContent, "https://admin.example.com"},
+		{"preflight from unknown origin", http.MethodOptions, "https://evil.example.com", true, http.StatusForbidden, ""},
+		{"simple request", http.MethodGet, "http://localhost:3000", false, http.StatusOK, "http://localhost:3000"},
+		{"unknown origin still served", http.MethodGet, "https://evil.example.com", false, http.StatusOK, ""},
+		{"no origin", http.MethodGet, "", false, http.StatusOK, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(tt.method, "/api/v1/fips", nil)
+			if tt.origin != "" {
+				req.Header.Set("Origin", tt.origin)
+			}
+			if tt.preflight {
+				req.Header.Set("Access-Control-Request-Method", http.MethodPost)
+			}
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, req)
+
+			if w.Code != tt.wantStatus {
+				t.Errorf("Got status %d, wanted %d", w.Code, tt.wantStatus)
+			}
+			if got := w.Header().Get("Access-Control-Allow-Origin"); got != tt.wantAllow {
+				t.Errorf("Got Access-Control-Allow-Origin %q, wanted %q", got, tt.wantAllow)
+			}
+			if tt.preflight && tt.wantAllow != "" {
+				if w.Header().Get("Access-Control-Max-Age") != "3600" || !strings.Contains(w.Header().Get("Access-Control-Allow-Headers"), "X-API-Key") {
+					t.Errorf("Unexpected preflight headers %v", w.Header())
+				}
+			}
+		})
+	}
+}
+
+func TestAccessLog_Redaction(t *testing.T) {
+	var logs bytes.Buffer
+	logger, _ := NewLogger(&logs, "text")
+	defer slog.SetDefault(slog.Default())
+	slog.SetDefault(logger)
+
+	store := NewSecureKeyStore()
+	server := NewAPIServer(NewSignerService(store))
+	server.Operators, _ = ParseOperators("alice:op-secret-token")
+	handler := server.middleware(server.routes())
+
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/api/v1/keys/generate", strings.NewReader(`{}`)))
+	var acc Account
+	json.Unmarshal(w.Body.Bytes(), &acc)
+	key, err := store.Get(acc.PublicKey)
+	if err != nil {
+		t.Fatalf("Generated key not stored: %v", err)
+	}
+	keyMaterial := base64.StdEncoding.EncodeToString(key)
+
+	txData := base64.StdEncoding.EncodeToString([]byte("super secret transaction bytes"))
+	req := httptest.NewRequest(http.MethodPost, "/api/v1/txs/sign?tenant=acme&token=leaky", strings.NewReader(`{"keyId":"`+acc.PublicKey+`","unsignedTxData":"`+txData+`","context":"payout"}`))
+	req.Header.Set("Authorization", "Bearer op-secret-token")
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+	var res TransactionResult
+	if err := json.Unmarshal(w.Body.Bytes(), &res); err != nil || res.Signature == "" {
+		t.Fatalf("Sign failed: %d %s", w.Code, w.Body)
+	}
+
+	out := logs.String()
+	for _, secret := range []string{txData, res.Signature, keyMaterial, hex.EncodeToString(key), "op-secret-token", "leaky"} {
+		if strings.Contains(out, secret) {
+			t.Errorf("Access log leaked %q:\n%s", secret, out)
+		}
+	}
+	if !strings.Contains(out, `msg=ACCESS component=http method=POST path=/api/v1/txs/sign query="tenant=acme&token=REDACTED" status=200`) || !strings.Contains(out, "caller=operator:alice") {
+		t.Errorf("Missing access log line:\n%s", out)
+	}
+}
+
+func TestLogger(t *testing.T) {
+	defer SetLogLevel("info")
+
+	var logs bytes.Buffer
+	logger, err := NewLogger(&logs, "json")
+	if err != nil {
+		t.Fatalf("NewLogger failed: %v", err)
+	}
+	if _, err := NewLogger(&logs, "xml"); err == nil {
+		t.Error("Expected an unknown format to be refused")
+	}
+
+	_, key, _ := ed25519.GenerateKey(nil)
+	ctx := WithRequestID(context.Background(), "req-1")
+	logger.InfoContext(ctx, "Signed", logSigner, "key_id", "k1", "private_key", "hunter2", "Seed", "s33d", "raw", []byte("tx bytes"), "oops", key)
+
+	var line map[string]any
+	if err := json.Unmarshal(logs.Bytes(), &line); err != nil {
+		t.Fatalf("Not a JSON line: %v\n%s", err, logs.String())
+	}
+	want := map[string]any{"level": "INFO", "msg": "Signed", "component": "signer", "key_id": "k1", "request_id": "req-1",
+		"private_key": "REDACTED", "Seed": "REDACTED", "raw": "REDACTED", "oops": "REDACTED"}
+	for k, v := range want {
+		if line[k] != v {
+			t.Errorf("Got %s=%v, wanted %v", k, line[k], v)
+		}
+	}
+
+	// audit lines are kept above error
+	SetLogLevel("error")
+	logs.Reset()
+	logger.Warn("dropped")
+	logger.Log(ctx, LevelAudit, "Key deleted", logAdmin)
+	if out := logs.String(); strings.Contains(out, "dropped") || !strings.Contains(out, `"level":"AUDIT"`) {
+		t.Errorf("Unexpected lines at level error:\n%s", out)
+	}
+}
+
+func TestAPIVersions(t *testing.T) {
+	server := NewAPIServer(NewSignerService(NewSecureKeyStore()))
+	server.Operators, _ = ParseOperators("alice:tok")
+	server.Store = NewSecureKeyStore()
+	handler := server.middleware(server.routes())
+
+	tests := []struct {
+		name        string
+		method      string
+		path        string
+		body        string
+		wantStatus  int
+		wantType    string
+		wantErrCode string
+	}{
+		{"v1 keeps its defaults", http.MethodPost, "/api/v1/keys/generate", `{}`, http.StatusOK, "application/json", ""},
+		{"v2 needs a key type", http.MethodPost, "/api/v2/keys/generate", `{"policy":{"usage":"persistent"}}`, http.StatusBadRequest, "application/problem+json", "key_type_required"},
+		{"v2 needs a policy", http.MethodPost, "/api/v2/keys/generate", `{"keyType":"ed25519"}`, http.StatusBadRequest, "application/problem+json", "policy_required"},
+		{"v2 explicit", http.MethodPost, "/api/v2/keys/generate", `{"keyType":"ed25519","policy":{"usage":"persistent"}}`, http.StatusOK, "application/json", ""},
+		{"v1 error model", http.MethodPost, "/api/v1/keys/x/freeze", `{}`, http.StatusUnauthorized, "application/json", "operator_required"},
+		{"v2 error model from middleware", http.MethodPost, "/api/v2/keys/x/freeze", `{}`, http.StatusUnauthorized, "application/problem+json", "operator_required"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, httptest.NewRequest(tt.method, tt.path, strings.NewReader(tt.body)))
+
+			if w.Code != tt.wantStatus || w.Header().Get("Content-Type") != tt.wantType {
+				t.Fatalf("Got %d %s, wanted %d %s: %s", w.Code, w.Header().Get("Content-Type"), tt.wantStatus, tt.wantType, w.Body)
+			}
+			if tt.wantErrCode == "" {
+				return
+			}
+			if tt.wantType == "application/problem+json" {
+				var p ProblemDetails
+				json.Unmarshal(w.Body.Bytes(), &p)
+				if p.Code != tt.wantErrCode || p.Status != tt.wantStatus || p.Type != "urn:sts:error:"+tt.wantErrCode || p.RequestID == "" {
+					t.Errorf("Unexpected problem details %+v", p)
+				}
+				return
+			}
+			var res ErrorResponse
+			json.Unmarshal(w.Body.Bytes(), &res)
+			if res.Code != tt.wantErrCode {
+				t.Errorf("Got code %q, wanted %q", res.Code, tt.wantErrCode)
+			}
+		})
+	}
+}
+
+func TestKeyDelete(t *testing.T) {
+	store := NewSecureKeyStore()
+	svc := NewSignerService(store)
+	svc.approvals = NewApprovalQueue()
+	server := NewAPIServer(svc)
+	server.Operators, _ = ParseOperators("alice:tok")
+	server.Store = store
+	handler := server.middleware(server.routes())
+
+	free, _ := svc.GenerateKey(context.Background(), KeyGenRequest{})
+	busy, _ := svc.GenerateKey(context.Background(), KeyGenRequest{})
+	pa, err := svc.approvals.Submit(context.Background(), TransactionRequest{KeyID: busy.PublicKey}, "large transfer", defaultApprovalQuorum())
+	if err != nil {
+		t.Fatalf("Submit failed: %v", err)
+	}
+
+	tests := []struct {
+		name       string
+		id         string
+		token      string
+		wantStatus int
+	}{
+		{"needs an operator", free.PublicKey, "", http.StatusUnauthorized},
+		{"pending approval", busy.PublicKey, "tok", http.StatusConflict},
+		{"deleted", free.PublicKey, "tok", http.StatusNoContent},
+		{"already gone", free.PublicKey, "tok", http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodDelete, "/api/v1/keys/"+tt.id, nil)
+			if tt.token != "" {
+				req.Header.Set("Authorization", "Bearer "+tt.token)
+			}
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, req)
+			if w.Code != tt.wantStatus {
+				t.Errorf("Got %d, wanted %d: %s", w.Code, tt.wantStatus, w.Body)
+			}
+		})
+	}
+
+	if _, err := store.Info(free.PublicKey); !errors.Is(err, errKeyNotFound) {
+		t.Errorf("Expected the deleted key to be gone, got %v", err)
+	}
+	if _, err := store.Info(busy.PublicKey); err != nil {
+		t.Errorf("Expected the busy key to be kept, got %v", err)
+	}
+
+	// once the approval is decided the key can go
+	if _, err := svc.approvals.Reject(pa.ID, Operator{Name: "bob"}); err != nil {
+		t.Fatalf("Reject failed: %v", err)
+	}
+	if err := svc.DestroyKey(context.Background(), busy.PublicKey); err != nil {
+		t.Errorf("DestroyKey after reject failed: %v", err)
+	}
+}
+
+func TestKeyDetail(t *testing.T) {
+	svc := NewSignerService(NewSecureKeyStore())
+	server := NewAPIServer(svc)
+	server.Operators, _ = ParseOperators("alice:tok")
+	server.Tokens = NewAPITokenStore()
+	handler := server.middleware(server.routes())
+
+	acc, err := svc.GenerateKey(context.Background(), KeyGenRequest{Policy: &KeyPolicy{Usage: UsageMaxUses, MaxUses: 3, Canary: true, AllowedCIDRs: []string{"10.0.0.0/8"}}})
+	if err != nil {
+		t.Fatalf("GenerateKey failed: %v", err)
+	}
+	other, _ := svc.GenerateKey(context.Background(), KeyGenRequest{})
+	_, reader, _ := server.Tokens.Mint(APITokenRequest{Name: "sdk", KeyIDs: []string{acc.PublicKey}, Operations: []string{TokenOpRead}}, "alice", time.Now())
+
+	get := func(id string, header, value string) (*httptest.ResponseRecorder, map[string]any) {
+		req := httptest.NewRequest(http.MethodGet, "/api/v1/keys/"+id, nil)
+		req.RemoteAddr = "10.1.2.3:4242"
+		if header != "" {
+			req.Header.Set(header, value)
+		}
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+		var body map[string]any
+		json.Unmarshal(w.Body.Bytes(), &body)
+		return w, body
+	}
+
+	w, body := get(acc.PublicKey, "X-API-Key", reader)
+	if w.Code != http.StatusOK {
+		t.Fatalf("Got %d: %s", w.Code, w.Body)
+	}
+	var detail KeyDetail
+	json.Unmarshal(w.Body.Bytes(), &detail)
+	if detail.Status != KeyStatusActive || detail.Usage.RemainingUses == nil || *detail.Usage.RemainingUses != 3 || !detail.PolicySummary.CallerRestricted {
+		t.Errorf("Unexpected detail %+v", detail)
+	}
+	if _, ok := body["policy"]; ok || strings.Contains(w.Body.String(), "canary") || strings.Contains(w.Body.String(), "10.0.0.0") {
+		t.Errorf("Clients must not see the full policy: %s", w.Body)
+	}
+
+	if w, _ := get(other.PublicKey, "X-API-Key", reader); w.Code != http.StatusForbidden {
+		t.Errorf("Expected a token scoped to another key to be refused, got %d", w.Code)
+	}
+
+	// the key's caller rules hold for reads too
+	req := httptest.NewRequest(http.MethodGet, "/api/v1/keys/"+acc.PublicKey, nil)
+	req.Header.Set("X-API-Key", reader)
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+	if w.Code != http.StatusForbidden {
+		t.Errorf("Expected a read from outside the key's networks to be refused, got %d", w.Code)
+	}
+	if w, _ := get("missing", "Authorization", "Bearer tok"); w.Code != http.StatusNotFound {
+		t.Errorf("Expected 404 for an unknown key, got %d", w.Code)
+	}
+	if w, body := get(acc.PublicKey, "Authorization", "Bearer tok"); w.Code != http.StatusOK || body["policy"] == nil {
+		t.Errorf("Expected operators to see the full policy, got %d %v", w.Code, body)
+	}
+}
+
+func TestUnixSocket(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "sts.sock")
+
+	c, err := ConfigFromFlags([]string{"-addr", "off", "-socket", path, "-socket-mode", "0660"}, func(string) string { return "" })
+	if err != nil || c.SocketMode != 0o660 {
+		t.Fatalf("ConfigFromFlags = %+v, %v", c, err)
+	}
+	if _, err := ConfigFromFlags([]string{"-addr", "off"}, func(string) string { return "" }); err == nil {
+		t.Error("Expected a config w/o any listener to be refused")
+	}
+
+	// a stale socket from a crash is replaced, a regular file is not
+	stale, _ := net.Listen("unix", path)
+	stale.(*net.UnixListener).SetUnlinkOnClose(false)
+	stale.Close()
+	lis, err := listenUnix(path, c.SocketMode)
+	if err != nil {
+		t.Fatalf("listenUnix failed: %v", err)
+	}
+	if fi, _ := os.Stat(path); fi.Mode().Perm() != 0o660 {
+		t.Errorf("Got socket mode %o, wanted 660", fi.Mode().Perm())
+	}
+	if _, err := listenUnix(path, c.SocketMode); err == nil {
+		t.Error("Expected a socket in use to be refused")
+	}
+	regular := filepath.Join(dir, "file")
+	os.WriteFile(regular, nil, 0o600)
+	if _, err := listenUnix(regular, c.SocketMode); err == nil {
+		t.Error("Expected a regular file not to be replaced")
+	}
+
+	server := NewAPIServer(NewSignerService(NewSecureKeyStore()))
+	server.Config = c
+	ctx, cancel := context.WithCancel(context.Background())
+	served := make(chan error, 1)
+	go func() { served <- server.Serve(ctx, lis) }()
+
+	client := &http.Client{Transport: &http.Transport{
+		DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+			return (&net.Dialer{}).DialContext(ctx, "unix", path)
+		},
+	}}
+	res, err := client.Get("http://sts/api/v1/fips")
+	if err != nil {
+		t.Fatalf("Request over the socket failed: %v", err)
+	}
+	res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		t.Errorf("Got %d over the socket", res.StatusCode)
+	}
+
+	cancel()
+	if err := <-served; err != nil {
+		t.Errorf("Serve returned %v", err)
+	}
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Error("Expected the socket to be removed on shutdown")
+	}
+}
+
+func TestSealMaintenance(t *testing.T) {
+	store := NewSecureKeyStore()
+	svc := NewSignerService(store)
+	server := NewAPIServer(svc)
+	server.Operators, _ = ParseOperators("alice:tok")
+	server.Store = store
+	server.KillSwitch = NewKillSwitch(store, svc.seal)
+	handler := server.middleware(server.routes())
+
+	key, _ := svc.GenerateKey(context.Background(), KeyGenRequest{})
+
+	do := func(method, path, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set("Authorization", "Bearer tok")
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+		return w
+	}
+
+	tests := []struct {
+		name       string
+		method     string
+		path       string
+		body       string
+		wantStatus int
+		wantCode   string
+	}{
+		{"needs a reason", http.MethodPost, "/api/v1/admin/seal", `{}`, http.StatusBadRequest, "missing_field"},
+		{"sealed", http.MethodPost, "/api/v1/admin/seal", `{"reason":"host patching"}`, http.StatusOK, ""},
+		{"key generation refused", http.MethodPost, "/api/v1/keys/generate", `{}`, http.StatusServiceUnavailable, "service_sealed"},
+		{"status still served", http.MethodGet, "/api/v1/admin/seal", "", http.StatusOK, ""},
+		{"unsealed", http.MethodPost, "/api/v1/admin/unseal", "", http.StatusOK, ""},
+		{"not sealed", http.MethodPost, "/api/v1/admin/unseal", "", http.StatusConflict, "not_sealed"},
+		{"key generation resumes", http.MethodPost, "/api/v1/keys/generate", `{}`, http.StatusOK, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := do(tt.method, tt.path, tt.body)
+			if w.Code != tt.wantStatus {
+				t.Fatalf("Got %d, wanted %d: %s", w.Code, tt.wantStatus, w.Body)
+			}
+			if tt.wantCode == "" {
+				return
+			}
+			var res ErrorResponse
+			json.Unmarshal(w.Body.Bytes(), &res)
+			if res.Code != tt.wantCode {
+				t.Errorf("Got code %q, wanted %q", res.Code, tt.wantCode)
+			}
+			if res.Code == "service_sealed" && res.Details["cause"] != SealCauseMaintenance {
+				t.Errorf("Expected the seal cause in details, got %v", res.Details)
+			}
+		})
+	}
+
+	if _, err := store.Info(key.PublicKey); !errors.Is(err, errKeyNotFound) {
+		t.Errorf("Expected sealing to wipe the key, got %v", err)
+	}
+}
+
+func TestTrustedProxies(t *testing.T) {
+	proxies, err := TrustedProxiesFromEnv(func(string) string { return "10.0.0.0/8, 192.0.2.1" })
+	if err != nil {
+		t.Fatalf("TrustedProxiesFromEnv failed: %v", err)
+	}
+	if _, err := TrustedProxiesFromEnv(func(string) string { return "lb.internal" }); err == nil {
+		t.Error("Expected a host name to be refused")
+	}
+
+	server := NewAPIServer(NewSignerService(NewSecureKeyStore()))
+	server.TrustedProxies = proxies
+	var got netip.Addr
+	handler := server.withClientIP(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = clientIP(r)
+	}))
+
+	tests := []struct {
+		name    string
+		peer    string
+		headers map[string]string
+		want    string
+	}{
+		{"no proxy", "203.0.113.9:4000", nil, "203.0.113.9"},
+		{"spoofed by an untrusted peer", "203.0.113.9:4000", map[string]string{"X-Forwarded-For": "198.51.100.1"}, "203.0.113.9"},
+		{"behind the load balancer", "10.0.0.5:4000", map[string]string{"X-Forwarded-For": "198.51.100.1"}, "198.51.100.1"},
+		{"client prepended a hop", "10.0.0.5:4000", map[string]string{"X-Forwarded-For": "1.1.1.1, 198.51.100.1, 10.0.0.7"}, "198.51.100.1"},
+		{"forwarded", "192.0.2.1:4000", map[string]string{"Forwarded": `for=198.51.100.1;proto=https, for="[2001:db8::17]:4711"`}, "2001:db8::17"},
+		{"forwarded wins", "192.0.2.1:4000", map[string]string{"Forwarded": "for=198.51.100.2", "X-Forwarded-For": "198.51.100.1"}, "198.51.100.2"},
+		{"obfuscated hop", "10.0.0.5:4000", map[string]string{"Forwarded": "for=_hidden, for=10.0.0.6"}, "10.0.0.6"},
+		{"only proxies", "10.0.0.5:4000", map[string]string{"X-Forwarded-For": "10.0.0.6"}, "10.0.0.6"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/api/v1/fips", nil)
+			req.RemoteAddr = tt.peer
+			for name, value := range tt.headers {
+				req.Header.Set(name, value)
+			}
+			handler.ServeHTTP(httptest.NewRecorder(), req)
+			if got.String() != tt.want {
+				t.Errorf("Got %s, wanted %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRouteLimits(t *testing.T) {
+	server := NewAPIServer(NewSignerService(NewSecureKeyStore()))
+	server.Config.Routes = map[string]RouteLimit{"POST /signatures/verify": {MaxBodyBytes: 1024}}
+	handler := server.middleware(server.routes())
+
+	padded := func(n int) string { return `{"message":"` + strings.Repeat("a", n) + `"}` }
+	tests := []struct {
+		name    string
+		path    string
+		body    string
+		chunked bool
+		want    int
+	}{
+		{"under the route limit", "/api/v1/signatures/verify", padded(512), false, http.StatusBadRequest},
+		{"over the route limit", "/api/v1/signatures/verify", padded(2048), false, http.StatusRequestEntityTooLarge},
+		{"over w/o a content length", "/api/v1/signatures/verify", padded(2048), true, http.StatusRequestEntityTooLarge},
+		{"sign takes large transactions", "/api/v1/txs/sign", padded(16 << 10), false, http.StatusBadRequest},
+		{"sign still has a cap", "/api/v2/txs/sign", padded(128 << 10), false, http.StatusRequestEntityTooLarge},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var body io.Reader = strings.NewReader(tt.body)
+			if tt.chunked {
+				body = io.MultiReader(body)
+			}
+			req := httptest.NewRequest(http.MethodPost, tt.path, body)
+			if tt.chunked {
+				req.ContentLength = -1
+			}
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, req)
+			if w.Code != tt.want {
+				t.Fatalf("Got %d, wanted %d: %s", w.Code, tt.want, w.Body)
+			}
+			if tt.want == http.StatusRequestEntityTooLarge && !strings.Contains(w.Body.String(), "body_too_large") {
+				t.Errorf("Expected a body_too_large code, got %s", w.Body)
+			}
+		})
+	}
+
+	slow := withTimeout(10*time.Millisecond, func(w http.ResponseWriter, r *http.Request) {
+		<-r.Context().Done()
+		w.Write([]byte("too late"))
+	})
+	w := httptest.NewRecorder()
+	slow.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/api/v1/fips", nil))
+	if w.Code != http.StatusRequestTimeout || !strings.Contains(w.Body.String(), "request_timeout") {
+		t.Errorf("Expected a 408 w/ request_timeout, got %d %s", w.Code, w.Body)
+	}
+
+	// the signer gets the route's timeout, not a fixed one
+	var deadline time.Time
+	long := withTimeout(30*time.Second, func(w http.ResponseWriter, r *http.Request) {
+		deadline = signDeadline(r.Context())
+	})
+	long.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, "/api/v1/txs/sign", nil))
+	if left := time.Until(deadline); left < 25*time.Second {
+		t.Errorf("Expected the sign deadline to follow the route's 30s, %s left", left)
+	}
+	if left := time.Until(signDeadline(context.Background())); left > defaultSignTimeout || left < defaultSignTimeout-time.Second {
+		t.Errorf("Expected %s w/o a deadline, %s left", defaultSignTimeout, left)
+	}
+
+	for _, routes := range []map[string]RouteLimit{
+		{"/api/v1/txs/sign": {MaxBodyBytes: 4096}},
+		{"POST /txs/sign": {MaxBodyBytes: 100}},
+		{"GET /events": {Timeout: time.Second}},
+	} {
+		c := DefaultConfig()
+		c.Routes = routes
+		if err := c.Validate(); err == nil {
+			t.Errorf("Expected %v to be refused", routes)
+		}
+	}
+}
+
+func TestIdempotencyKey(t *testing.T) {
+	store := NewSecureKeyStore()
+	server := NewAPIServer(NewSignerService(store))
+	handler := server.middleware(server.routes())
+
+	post := func(path, key, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, path, strings.NewReader(body))
+		req.Header.Set("Idempotency-Key", key)
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+		return w
+	}
+
+	first := post("/api/v1/keys/generate", "gen-1", `{"keyType":"ed25519"}`)
+	retry := post("/api/v1/keys/generate", "gen-1", `{"keyType":"ed25519"}`)
+	if first.Code != http.StatusOK || retry.Body.String() != first.Body.String() {
+		t.Fatalf("Expected the retry to return the original key, got %d %s then %s", first.Code, first.Body, retry.Body)
+	}
+	if store.Len() != 1 {
+		t.Errorf("Expected one key to be generated, got %d", store.Len())
+	}
+	var acc Account
+	json.Unmarshal(first.Body.Bytes(), &acc)
+
+	tx := base64.StdEncoding.EncodeToString([]byte("tx-data"))
+	sign := fmt.Sprintf(`{"keyId":%q,"unsignedTxData":%q,"context":"payout"}`, acc.PublicKey, tx)
+	if w := post("/api/v1/txs/sign", "sign-1", sign); w.Code != http.StatusOK {
+		t.Fatalf("Signing failed: %d %s", w.Code, w.Body)
+	}
+
+	tests := []struct {
+		name string
+		path string
+		key  string
+		body string
+		want int
+	}{
+		{"sign retried", "/api/v1/txs/sign", "sign-1", sign, http.StatusOK},
+		{"sign w/ a different body", "/api/v1/txs/sign", "sign-1", strings.Replace(sign, "payout", "refund", 1), http.StatusUnprocessableEntity},
+		{"generate w/ a different body", "/api/v1/keys/generate", "gen-1", `{"keyType":"p256"}`, http.StatusUnprocessableEntity},
+		{"new key", "/api/v1/keys/generate", "gen-2", `{"keyType":"p256"}`, http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := post(tt.path, tt.key, tt.body)
+			if w.Code != tt.want {
+				t.Fatalf("Got %d, wanted %d: %s", w.Code, tt.want, w.Body)
+			}
+			if tt.want == http.StatusUnprocessableEntity && !strings.Contains(w.Body.String(), "idempotency_key_reused") {
+				t.Errorf("Expected an idempotency_key_reused code, got %s", w.Body)
+			}
+		})
+	}
+}
+
+func TestStrictJSON(t *testing.T) {
+	server := NewAPIServer(NewSignerService(NewSecureKeyStore()))
+	handler := server.middleware(server.routes())
+
+	tests := []struct {
+		name     string
+		path     string
+		body     string
+		code     string
+		field    string
+		expected string
+	}{
+		{"unknown field", "/api/v1/keys/generate", `{"keytype":"p256"}`, "unknown_field", "keytype", ""},
+		{"unknown nested field", "/api/v1/keys/generate", `{"policy":{"usage":"single-use","maxUse":3}}`, "unknown_field", "maxUse", ""},
+		{"wrong type", "/api/v1/keys/generate", `{"policy":{"maxUses":"3"}}`, "invalid_field_type", "policy.maxUses", "integer"},
+		{"missing required", "/api/v1/txs/sign", `{"keyId":"k1","context":"payout"}`, "missing_field", "unsignedTxData", ""},
+		{"trailing data", "/api/v1/keys/generate", `{} {}`, "invalid_body", "", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, httptest.NewRequest(http.MethodPost, tt.path, strings.NewReader(tt.body)))
+			var res ErrorResponse
+			json.Unmarshal(w.Body.Bytes(), &res)
+			if w.Code != http.StatusBadRequest || res.Code != tt.code {
+				t.Fatalf("Got %d %q, wanted 400 %q: %s", w.Code, res.Code, tt.code, w.Body)
+			}
+			if res.Details["field"] != tt.field || res.Details["expected"] != tt.expected {
+				t.Errorf("Got details %v, wanted field %q expected %q", res.Details, tt.field, tt.expected)
+			}
+		})
+	}
+
+	// an empty body still gets the defaults
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/api/v1/keys/generate", nil))
+	if w.Code != http.StatusOK {
+		t.Errorf("Expected an empty body to be accepted, got %d %s", w.Code, w.Body)
+	}
+}
+
+func TestUnmatchedRoutes(t *testing.T) {
+	server := NewAPIServer(NewSignerService(NewSecureKeyStore()))
+	handler := server.middleware(server.routes())
+
+	tests := []struct {
+		name   string
+		method string
+		path   string
+		accept string
+		status int
+		code   string
+		allow  string
+	}{
+		{"unknown path", http.MethodGet, "/api/v1/nope", "", http.StatusNotFound, "route_not_found", ""},
+		{"unknown path outside the API", http.MethodGet, "/favicon.ico", "", http.StatusNotFound, "route_not_found", ""},
+		{"wrong method", http.MethodGet, "/api/v1/txs/sign", "", http.StatusMethodNotAllowed, "method_not_allowed", "POST"},
+		{"wrong method on a shared path", http.MethodPut, "/api/v1/keys/k1", "", http.StatusMethodNotAllowed, "method_not_allowed", "GET, HEAD"},
+		{"json not acceptable", http.MethodGet, "/api/v1/fips", "text/html", http.StatusNotAcceptable, "not_acceptable", ""},
+		{"json refused by q=0", http.MethodGet, "/api/v1/fips", "application/json;q=0, text/plain", http.StatusNotAcceptable, "not_acceptable", ""},
+		{"wildcard", http.MethodGet, "/api/v1/fips", "text/html, */*;q=0.1", http.StatusOK, "", ""},
+		{"problem details asked for", http.MethodGet, "/api/v1/nope", "application/problem+json", http.StatusNotFound, "route_not_found", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(tt.method, tt.path, nil)
+			if tt.accept != "" {
+				req.Header.Set("Accept", tt.accept)
+			}
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, req)
+			if w.Code != tt.status {
+				t.Fatalf("Got %d, wanted %d: %s", w.Code, tt.status, w.Body)
+			}
+			if w.Header().Get("Allow") != tt.allow {
+				t.Errorf("Got Allow %q, wanted %q", w.Header().Get("Allow"), tt.allow)
+			}
+			if tt.code == "" {
+				return
+			}
+			wantType := "application/json"
+			if strings.Contains(tt.accept, "problem+json") {
+				wantType = "application/problem+json"
+			}
+			if ct := w.Header().Get("Content-Type"); ct != wantType {
+				t.Errorf("Got Content-Type %q, wanted %q", ct, wantType)
+			}
+			if !strings.Contains(w.Body.String(), `"code":"`+tt.code+`"`) {
+				t.Errorf("Expected code %s, got %s", tt.code, w.Body)
+			}
+		})
+	}
+}
+
+func TestH2C(t *testing.T) {
+	c, err := ConfigFromFlags([]string{"-h2c", "-http2-max-streams", "500"}, func(string) string { return "" })
+	if err != nil || !c.H2C || c.HTTP2MaxStreams != 500 {
+		t.Fatalf("ConfigFromFlags = %+v, %v", c, err)
+	}
+	if _, err := ConfigFromFlags(nil, func(k string) string {
+		return map[string]string{"STS_HTTP2_MAX_STREAMS": "0"}[k]
+	}); err == nil {
+		t.Error("Expected 0 streams to be refused")
+	}
+
+	h2c := new(http.Protocols)
+	h2c.SetUnencryptedHTTP2(true)
+	client := &http.Client{Transport: &http.Transport{Protocols: h2c}}
+
+	for _, enabled := range []bool{true, false} {
+		lis, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatalf("Listen failed: %v", err)
+		}
+		server := NewAPIServer(NewSignerService(NewSecureKeyStore()))
+		server.Config = c
+		server.Config.H2C = enabled
+		ctx, cancel := context.WithCancel(context.Background())
+		served := make(chan error, 1)
+		go func() { served <- server.Serve(ctx, lis) }()
+
+		res, err := client.Get("http://" + lis.Addr().String() + "/api/v1/fips")
+		if enabled {
+			if err != nil {
+				t.Fatalf("h2c request failed: %v", err)
+			}
+			res.Body.Close()
+			if res.ProtoMajor != 2 || res.StatusCode != http.StatusOK {
+				t.Errorf("Got %s %d, wanted HTTP/2 200", res.Proto, res.StatusCode)
+			}
+		} else if err == nil {
+			res.Body.Close()
+			t.Errorf("Expected h2c to be refused when off, got %s", res.Proto)
+		}
+
+		cancel()
+		<-served
+	}
+}
+
+func TestHTTP3(t *testing.T) {
+	certFile, keyFile := writeTestCert(t)
+	settings, _ := TLSSettingsFromEnv(func(k string) string {
+		return map[string]string{"STS_TLS_CERT": certFile, "STS_TLS_KEY": keyFile}[k]
+	})
+	tlsConfig, err := settings.Config()
+	if err != nil {
+		t.Fatalf("TLS config failed: %v", err)
+	}
+	certPEM, _ := os.ReadFile(certFile)
+	roots := x509.NewCertPool()
+	roots.AppendCertsFromPEM(certPEM)
+
+	server := NewAPIServer(NewSignerService(NewSecureKeyStore()))
+	server.QUICAddr = "127.0.0.1:0"
+	if _, _, err := server.quicServer(http.NotFoundHandler(), server.Config); err == nil {
+		t.Error("Expected HTTP/3 w/o TLS to be refused")
+	}
+
+	// a free UDP port the server can bind again
+	probe, _ := net.ListenPacket("udp", "127.0.0.1:0")
+	server.QUICAddr = probe.LocalAddr().String()
+	probe.Close()
+	server.TLS = tlsConfig
+	lis, _ := net.Listen("tcp", "127.0.0.1:0")
+	ctx, cancel := context.WithCancel(context.Background())
+	served := make(chan error, 1)
+	go func() { served <- server.Serve(ctx, lis) }()
+
+	tcp := &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{RootCAs: roots, ServerName: "localhost"}}}
+	res, err := tcp.Get("https://" + lis.Addr().String() + "/api/v1/fips")
+	if err != nil {
+		t.Fatalf("TLS request failed: %v", err)
+	}
+	res.Body.Close()
+	if !strings.Contains(res.Header.Get("Alt-Svc"), "h3=") {
+		t.Errorf("Expected the QUIC listener to be advertised, got Alt-Svc %q", res.Header.Get("Alt-Svc"))
+	}
+
+	quic := &http.Client{Transport: &http3.Transport{TLSClientConfig: &tls.Config{RootCAs: roots, ServerName: "localhost"}}}
+	res, err = quic.Get("https://" + server.QUICAddr + "/api/v1/fips")
+	if err != nil {
+		t.Fatalf("HTTP/3 request failed: %v", err)
+	}
+	res.Body.Close()
+	if res.ProtoMajor != 3 || res.StatusCode != http.StatusOK {
+		t.Errorf("Got %s %d, wanted HTTP/3 200", res.Proto, res.StatusCode)
+	}
+
+	cancel()
+	if err := <-served; err != nil {
+		t.Errorf("Serve returned %v", err)
+	}
+}
+
+func TestVersionEndpoint(t *testing.T) {
+	defer func(v, c string) { version, commit = v, c }(version, commit)
+	version, commit = "1.4.0", "0123abc"
+
+	server := NewAPIServer(NewSignerService(NewSecureKeyStore()))
+	handler := server.middleware(server.routes())
+
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/api/v1/version", nil))
+	var info BuildInfo
+	if err := json.Unmarshal(w.Body.Bytes(), &info); err != nil || w.Code != http.StatusOK {
+		t.Fatalf("Got %d %s: %v", w.Code, w.Body, err)
+	}
+	if info.Version != "1.4.0" || info.Commit != "0123abc" || info.GoVersion != runtime.Version() {
+		t.Errorf("Unexpected build info %+v", info)
+	}
+	if info.Backend != BackendMemory || !slices.Contains(info.Backends, BackendMemory) {
+		t.Errorf("Expected the memory backend to be reported, got %+v", info)
+	}
+	if info.FIPS.Enabled != CurrentFIPSStatus().Enabled {
+		t.Errorf("Expected FIPS status %+v, got %+v", CurrentFIPSStatus(), info.FIPS)
+	}
+}
+
+func TestFeatureFlags(t *testing.T) {
+	if _, err := FeatureFlagsFromEnv(func(string) string { return "broadcst=false" }); err == nil {
+		t.Error("Expected an unknown flag to be refused")
+	}
+	overrides, err := FeatureFlagsFromEnv(func(string) string { return "keytype.p256=false, broadcast=false" })
+	if err != nil {
+		t.Fatalf("FeatureFlagsFromEnv failed: %v", err)
+	}
+	features, _ := NewFeatureFlags(overrides)
+
+	svc := NewSignerService(NewSecureKeyStore())
+	svc.features = features
+	server := NewAPIServer(svc)
+	server.Features = features
+	handler := server.middleware(server.routes())
+
+	gen := func(keyType string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/api/v1/keys/generate", strings.NewReader(`{"keyType":"`+keyType+`"}`)))
+		return w
+	}
+	if w := gen(KeyTypeP256); w.Code != http.StatusForbidden || !strings.Contains(w.Body.String(), "feature_disabled") {
+		t.Errorf("Expected p256 generation to be off, got %d %s", w.Code, w.Body)
+	}
+	if w := gen(KeyTypeEd25519); w.Code != http.StatusOK {
+		t.Errorf("Expected ed25519 to stay on, got %d %s", w.Code, w.Body)
+	}
+
+	acc, _ := svc.GenerateKey(context.Background(), KeyGenRequest{})
+	pub, _ := hex.DecodeString(acc.PublicKey)
+	payer := SolanaAddress(pub)
+	msg, _ := CompileSolanaMessage(payer, SystemProgramID, []SolanaInstruction{
+		SystemCreateAccountIx(payer, payer, 1, 0, SystemProgramID),
+	})
+	_, err = svc.SignTransaction(context.Background(), TransactionRequest{
+		KeyID:          acc.PublicKey,
+		UnsignedTxData: base64.StdEncoding.EncodeToString(msg),
+		Context:        SolanaTxContext,
+		Broadcast:      true,
+	})
+	if !errors.Is(err, errFeatureDisabled) {
+		t.Errorf("Expected broadcast to be off, got %v", err)
+	}
+
+	// the file turns p256 back on, broadcast stays off from the env
+	path := filepath.Join(t.TempDir(), "sts.yaml")
+	os.WriteFile(path, []byte("features:\n  keytype.p256: true\n"), 0o600)
+	reloader := &ConfigReloader{
+		Path:         path,
+		BaseLimits:   RequestLimits{GlobalRPS: 500, GlobalBurst: 1000, ClientRPS: 50, ClientBurst: 100},
+		BaseAnomaly:  DefaultAnomalyConfig(),
+		Features:     features,
+		BaseFeatures: overrides,
+	}
+	if err := reloader.Reload(); err != nil {
+		t.Fatalf("Reload failed: %v", err)
+	}
+	if w := gen(KeyTypeP256); w.Code != http.StatusOK {
+		t.Errorf("Expected p256 to be on after the reload, got %d %s", w.Code, w.Body)
+	}
+	if features.Enabled(FeatureBroadcast) {
+		t.Error("Expected broadcast to stay off")
+	}
+
+	os.WriteFile(path, []byte("features:\n  keytype.ed448: true\n"), 0o600)
+	if err := reloader.Reload(); err == nil {
+		t.Error("Expected an unknown flag in the file to be refused")
+	}
+}
+
+func TestSocketHandoff(t *testing.T) {
+	// the new process started by handoff below, it serves one request on the
+	// listener it was handed and exits
+	if os.Getenv(inheritFDsEnv) != "" {
+		ss := inheritedSockets(false)
+		lis, err := ss.listen(socketAPI, "tcp", "")
+		if err != nil {
+			t.Fatalf("Taking over the listener failed: %v", err)
+		}
+		done := make(chan struct{})
+		srv := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte("new"))
+			close(done)
+		})}
+		go srv.Serve(lis)
+		ss.serving()
+		select {
+		case <-done:
+		case <-time.After(10 * time.Second):
+		}
+		srv.Shutdown(context.Background())
+		return
+	}
+
+	if names := inheritedNames(func(k string) string {
+		return map[string]string{"LISTEN_FDS": "2", "LISTEN_PID": "42", "LISTEN_FDNAMES": "api"}[k]
+	}, 42); !slices.Equal(names, []string{"api", ""}) {
+		t.Errorf("Unexpected systemd names %q", names)
+	}
+	if names := inheritedNames(func(k string) string {
+		return map[string]string{"LISTEN_FDS": "1", "LISTEN_PID": "41"}[k]
+	}, 42); names != nil {
+		t.Errorf("Expected fds meant for another process to be ignored, got %q", names)
+	}
+
+	// w/ SO_REUSEPORT a second process can bind the address
+	first := &socketSet{reusePort: true}
+	lis, err := first.listen(socketAPI, "tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Listen failed: %v", err)
+	}
+	defer lis.Close()
+	if second, err := (&socketSet{reusePort: true}).listen(socketAPI, "tcp", lis.Addr().String()); err != nil {
+		t.Errorf("Expected a second SO_REUSEPORT listener, got %v", err)
+	} else {
+		second.Close()
+	}
+	if _, err := (&socketSet{}).listen(socketAPI, "tcp", lis.Addr().String()); err == nil {
+		t.Error("Expected the address to be taken w/o SO_REUSEPORT")
+	}
+
+	// hand the listener to a copy of this test and check it answers once
+	// this side stops
+	ss := &socketSet{}
+	api, err := ss.listen(socketAPI, "tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Listen failed: %v", err)
+	}
+	args, stdout := os.Args, os.Stdout
+	os.Args = []string{args[0], "-test.run=^TestSocketHandoff$"}
+	os.Stdout, _ = os.Open(os.DevNull)
+	pid, err := ss.handoff()
+	os.Args, os.Stdout = args, stdout
+	if err != nil {
+		t.Fatalf("Handoff failed: %v", err)
+	}
+	if pid == os.Getpid() {
+		t.Error("Expected a new process")
+	}
+	api.Close()
+
+	res, err := http.Get("http://" + api.Addr().String())
+	if err != nil {
+		t.Fatalf("Expected the new process to answer: %v", err)
+	}
+	body, _ := io.ReadAll(res.Body)
+	res.Body.Close()
+	if string(body) != "new" {
+		t.Errorf("Expected the new process to answer, got %q", body)
+	}
+}
+
+func TestTracing(t *testing.T) {
+	if _, err := TracingConfigFromEnv(func(k string) string {
+		return map[string]string{"STS_OTLP_ENDPOINT": "localhost:4317", "STS_TRACE_SAMPLE_RATIO": "2"}[k]
+	}); err == nil {
+		t.Error("Expected a sample ratio over 1 to be refused")
+	}
+
+	spans := tracetest.NewSpanRecorder()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(spans)))
+
+	// the trace goes on to the cluster
+	var traceparents []string
+	var mu sync.Mutex
+	rpc := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		traceparents = append(traceparents, r.Header.Get("traceparent"))
+		mu.Unlock()
+		var call struct {
+			Method string `json:"method"`
+		}
+		json.NewDecoder(r.Body).Decode(&call)
+		switch call.Method {
+		case "sendTransaction":
+			fmt.Fprint(w, `{"jsonrpc":"2.0","id":1,"result":"5wHu1qwD"}`)
+		case "getSignatureStatuses":
+			fmt.Fprint(w, `{"jsonrpc":"2.0","id":1,"result":{"value":[{"slot":4242,"err":null}]}}`)
+		}
+	}))
+	defer rpc.Close()
+
+	svc := NewSignerService(NewSecureKeyStore())
+	svc.rpc = NewSolanaRPC(rpc.URL, 0)
+	server := NewAPIServer(svc)
+	router := server.routes()
+	handler := withTracing(router, server.middleware(router))
+
+	acc, _ := svc.GenerateKey(context.Background(), KeyGenRequest{Policy: &KeyPolicy{Usage: UsagePersistent}})
+	pub, _ := hex.DecodeString(acc.PublicKey)
+	payer := SolanaAddress(pub)
+	msg, _ := CompileSolanaMessage(payer, SystemProgramID, []SolanaInstruction{
+		SystemCreateAccountIx(payer, payer, 1, 0, SystemProgramID),
+	})
+	body, _ := json.Marshal(TransactionRequest{
+		KeyID:          acc.PublicKey,
+		UnsignedTxData: base64.StdEncoding.EncodeToString(msg),
+		Context:        SolanaTxContext,
+		Broadcast:      true,
+	})
+
+	const traceID = "4bf92f3577b34da6a3ce929d0e0e4736"
+	req := httptest.NewRequest(http.MethodPost, "/api/v1/txs/sign", bytes.NewReader(body))
+	req.Header.Set("traceparent", "00-"+traceID+"-00f067aa0ba902b7-01")
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+	if w.Code != http.StatusOK {
+		t.Fatalf("Sign failed: %d %s", w.Code, w.Body)
+	}
+
+	names := map[string]bool{}
+	for _, span := range spans.Ended() {
+		if span.SpanContext().TraceID().String() != traceID {
+			continue
+		}
+		names[span.Name()] = true
+		if span.Name() == "signer.SignTransaction" && !slices.Contains(span.Attributes(), attribute.String("sts.key_type", KeyTypeEd25519)) {
+			t.Errorf("Expected the key type on the sign span, got %v", span.Attributes())
+		}
+	}
+	for _, want := range []string{"POST /api/v1/txs/sign", "signer.SignTransaction", "keystore.Acquire", "solana.sendTransaction", "solana.getSignatureStatuses"} {
+		if !names[want] {
+			t.Errorf("Expected a %s span in the caller's trace, got %v", want, names)
+		}
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(traceparents) == 0 || !strings.Contains(traceparents[0], traceID) {
+		t.Errorf("Expected the trace to be propagated to the RPC node, got %q", traceparents)
+	}
+}
+
+func TestAuditExport(t *testing.T) {
+	for _, sinks := range []string{"ftp://host/x", "syslog+tcp://", "file://"} {
+		if _, err := AuditExporterFromEnv(func(string) string { return sinks }); err == nil {
+			t.Errorf("Expected sinks %q to be refused", sinks)
+		}
+	}
+
+	path := filepath.Join(t.TempDir(), "audit.jsonl")
+	syslog, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer syslog.Close()
+
+	// the collector fails the first delivery, the retry gets through
+	var posts atomic.Int32
+	var batch []AuditRecord
+	collector := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if posts.Add(1) == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		if r.Header.Get("Authorization") != "Bearer hec-token" {
+			t.Errorf("Unexpected Authorization %q", r.Header.Get("Authorization"))
+		}
+		json.NewDecoder(r.Body).Decode(&batch)
+	}))
+	defer collector.Close()
+
+	env := map[string]string{
+		"STS_AUDIT_SINKS":      "file://" + path + ", syslog://" + syslog.LocalAddr().String() + "," + collector.URL,
+		"STS_AUDIT_HTTP_TOKEN": "hec-token",
+	}
+	exporter, err := AuditExporterFromEnv(func(k string) string { return env[k] })
+	if err != nil {
+		t.Fatalf("AuditExporterFromEnv failed: %v", err)
+	}
+	exporter.retryBase = time.Millisecond
+	exporter.Start()
+
+	base, _ := NewLogger(io.Discard, "text")
+	logger := slog.New(exporter.Handler(base.Handler()))
+	ctx := WithRequestID(context.Background(), "req-7")
+	logger.Info("not audited")
+	logger.Log(ctx, LevelAudit, "Key deleted", logAdmin, "operator", "alice", "key_id", "k1", "secret", "hunter2", "err", errors.New("boom"))
+	if err := exporter.Close(context.Background()); err != nil {
+		t.Fatalf("Close failed: %v", err)
+	}
+	// dropped once closed, not a panic
+	logger.Log(ctx, LevelAudit, "late")
+
+	check := func(sink string, rec AuditRecord) {
+		t.Helper()
+		if rec.Seq != 1 || rec.Action != "Key deleted" || rec.Component != "admin" || rec.RequestID != "req-7" || rec.Service != "sts-svc" {
+			t.Errorf("Unexpected %s record %+v", sink, rec)
+		}
+		if rec.Fields["operator"] != "alice" || rec.Fields["secret"] != "REDACTED" || rec.Fields["err"] != "boom" {
+			t.Errorf("Unexpected %s fields %v", sink, rec.Fields)
+		}
+	}
+
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if lines := strings.Split(strings.TrimSpace(string(raw)), "\n"); len(lines) != 1 {
+		t.Fatalf("Expected 1 record in the file, got:\n%s", raw)
+	}
+	var rec AuditRecord
+	json.Unmarshal(raw, &rec)
+	check("file", rec)
+
+	buf := make([]byte, 4096)
+	syslog.SetReadDeadline(time.Now().Add(time.Second))
+	n, _, err := syslog.ReadFrom(buf)
+	if err != nil {
+		t.Fatalf("No syslog message: %v", err)
+	}
+	msg := string(buf[:n])
+	header, body, _ := strings.Cut(msg, " audit - ")
+	if !strings.HasPrefix(header, "<85>1 ") || !strings.Contains(header, " sts-svc ") {
+		t.Errorf("Unexpected syslog header %q", header)
+	}
+	rec = AuditRecord{}
+	json.Unmarshal([]byte(body), &rec)
+	check("syslog", rec)
+
+	if posts.Load() != 2 || len(batch) != 1 {
+		t.Fatalf("Expected the batch on the second post, got %d posts and %d records", posts.Load(), len(batch))
+	}
+	check("http", batch[0])
+	if dropped := exporter.Dropped(); dropped["http"] != 0 || dropped["file"] != 0 {
+		t.Errorf("Unexpected drops %v", dropped)
+	}
+}
+
+func TestComplianceReport(t *testing.T) {
+	store := NewSecureKeyStore()
+	svc := NewSignerService(store)
+	compliance := NewComplianceRecorder()
+	store.compliance, svc.compliance = compliance, compliance
+	server := NewAPIServer(svc)
+	server.Operators, _ = ParseOperators("alice:op-token")
+	server.Store = store
+	server.Compliance = compliance
+	handler := server.middleware(server.routes())
+
+	do := func(method, target, token, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, target, strings.NewReader(body))
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+		return w
+	}
+
+	var acc Account
+	json.Unmarshal(do(http.MethodPost, "/api/v1/keys/generate", "", `{"policy":{"usage":"persistent"}}`).Body.Bytes(), &acc)
+	txData := base64.StdEncoding.EncodeToString([]byte("payout"))
+	for range 2 {
+		if w := do(http.MethodPost, "/api/v1/txs/sign", "", `{"keyId":"`+acc.PublicKey+`","unsignedTxData":"`+txData+`","context":"payout"}`); w.Code != http.StatusOK {
+			t.Fatalf("Sign failed: %d %s", w.Code, w.Body)
+		}
+	}
+	if w := do(http.MethodDelete, "/api/v1/keys/"+acc.PublicKey, "op-token", ""); w.Code/100 != 2 {
+		t.Fatalf("Delete failed: %d %s", w.Code, w.Body)
+	}
+	do(http.MethodGet, "/api/v1/admin/reports/compliance", "wrong-token", "")
+
+	if w := do(http.MethodGet, "/api/v1/admin/reports/compliance", "", ""); w.Code != http.StatusUnauthorized {
+		t.Errorf("Expected operators only, got %d", w.Code)
+	}
+	if w := do(http.MethodGet, "/api/v1/admin/reports/compliance?from=yesterday", "op-token", ""); w.Code != http.StatusBadRequest {
+		t.Errorf("Expected a bad date to be refused, got %d", w.Code)
+	}
+
+	w := do(http.MethodGet, "/api/v1/admin/reports/compliance", "op-token", "")
+	var report ComplianceReport
+	if err := json.Unmarshal(w.Body.Bytes(), &report); err != nil {
+		t.Fatalf("Bad report %d %s", w.Code, w.Body)
+	}
+	policy := UsagePersistent
+	if len(report.KeysCreated) != 1 || report.KeysCreated[0].KeyID != acc.PublicKey || report.KeysCreated[0].Policy != policy {
+		t.Errorf("Unexpected keys created %+v", report.KeysCreated)
+	}
+	if len(report.KeysDestroyed) != 1 || report.KeysDestroyed[0].KeyID != acc.PublicKey {
+		t.Errorf("Unexpected keys destroyed %+v", report.KeysDestroyed)
+	}
+	if !reflect.DeepEqual(report.Signatures, []SignatureCount{{KeyID: acc.PublicKey, Policy: policy, Count: 2}}) {
+		t.Errorf("Unexpected signatures %+v", report.Signatures)
+	}
+	if !reflect.DeepEqual(report.FailedAuth, []AuthFailureCount{{Method: "bearer", Count: 1}}) {
+		t.Errorf("Unexpected failed auth %+v", report.FailedAuth)
+	}
+
+	// a period before anything happened is empty
+	if empty := compliance.Report(time.Now().AddDate(0, 0, -10), time.Now().AddDate(0, 0, -5)); len(empty.KeysCreated) != 0 || len(empty.Signatures) != 0 {
+		t.Errorf("Expected an empty report, got %+v", empty)
+	}
+
+	w = do(http.MethodGet, "/api/v1/admin/reports/compliance?format=csv", "op-token", "")
+	rows, err := csv.NewReader(w.Body).ReadAll()
+	if err != nil || w.Header().Get("Content-Type") != "text/csv" {
+		t.Fatalf("Bad CSV %v %s", err, w.Body)
+	}
+	if len(rows) != 5 || rows[3][0] != "signatures" || rows[3][6] != "2" {
+		t.Errorf("Unexpected CSV rows %v", rows)
+	}
+
+	dir := t.TempDir()
+	if err := compliance.WriteFiles(dir, time.Now().AddDate(0, 0, 1)); err != nil {
+		t.Fatalf("WriteFiles failed: %v", err)
+	}
+	for _, ext := range []string{".json", ".csv"} {
+		if _, err := os.Stat(filepath.Join(dir, "compliance-"+time.Now().UTC().Format(complianceDate)+ext)); err != nil {
+			t.Errorf("Missing daily report: %v", err)
+		}
+	}
+}
+
+func TestUsageMetering(t *testing.T) {
+	svc := NewSignerService(NewSecureKeyStore())
+	svc.meter = NewUsageMeter()
+	server := NewAPIServer(svc)
+	server.Operators, _ = ParseOperators("alice:op-token")
+	server.Tokens = NewAPITokenStore()
+	server.Meter = svc.meter
+	handler := server.middleware(server.routes())
+	meta, token, err := server.Tokens.Mint(APITokenRequest{Name: "billing", Operations: []string{TokenOpGenerate, TokenOpSign}, Tenant: "acme"}, "alice", time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	do := func(method, target string, header map[string]string, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, target, strings.NewReader(body))
+		for k, v := range header {
+			req.Header.Set(k, v)
+		}
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+		return w
+	}
+
+	acme := map[string]string{"X-Tenant-ID": "acme", "X-API-Key": token}
+	var acc Account
+	json.Unmarshal(do(http.MethodPost, "/api/v1/keys/generate", acme, `{"policy":{"usage":"persistent"}}`).Body.Bytes(), &acc)
+	txData := base64.StdEncoding.EncodeToString([]byte("invoice"))
+	for range 3 {
+		if w := do(http.MethodPost, "/api/v1/txs/sign", acme, `{"keyId":"`+acc.PublicKey+`","unsignedTxData":"`+txData+`","context":"billing"}`); w.Code != http.StatusOK {
+			t.Fatalf("Sign failed: %d %s", w.Code, w.Body)
+		}
+	}
+	// no token, billed to the default tenant w/o an API key, an unproven
+	// tenant header doesn't change that
+	do(http.MethodPost, "/api/v1/keys/generate", map[string]string{"X-Tenant-ID": "acme"}, `{}`)
+
+	operator := map[string]string{"Authorization": "Bearer op-token"}
+	if w := do(http.MethodGet, "/api/v1/usage", nil, ""); w.Code != http.StatusUnauthorized {
+		t.Errorf("Expected operators only, got %d", w.Code)
+	}
+	if w := do(http.MethodGet, "/api/v1/usage/costs", acme, ""); w.Code != http.StatusUnauthorized {
+		t.Errorf("Expected cost reports for operators only, got %d", w.Code)
+	}
+	if w := do(http.MethodGet, "/api/v1/usage?granularity=week", operator, ""); w.Code != http.StatusBadRequest {
+		t.Errorf("Expected an unknown granularity to be refused, got %d", w.Code)
+	}
+
+	var records []UsageRecord
+	w := do(http.MethodGet, "/api/v1/usage?granularity=day&tenant=acme", operator, "")
+	if err := json.Unmarshal(w.Body.Bytes(), &records); err != nil {
+		t.Fatalf("Bad export %d %s", w.Code, w.Body)
+	}
+	day := time.Now().UTC().Truncate(24 * time.Hour)
+	want := []UsageRecord{
+		{Period: day, Tenant: "acme", APIKey: meta.ID, Operation: MeterKeyGenerate, Count: 1},
+		{Period: day, Tenant: "acme", APIKey: meta.ID, Operation: MeterSign, Count: 3},
+	}
+	if len(records) != 2 || !records[0].Period.Equal(day) {
+		t.Fatalf("Unexpected records %+v", records)
+	}
+	for i := range records {
+		records[i].Period = day
+	}
+	if !reflect.DeepEqual(records, want) {
+		t.Errorf("Got %+v, wanted %+v", records, want)
+	}
+
+	// out of range
+	if got := svc.meter.Export(UsageQuery{To: time.Now().Add(-2 * time.Hour)}); len(got) != 0 {
+		t.Errorf("Expected nothing before now, got %+v", got)
+	}
+
+	w = do(http.MethodGet, "/api/v1/usage?format=csv", operator, "")
+	rows, err := csv.NewReader(w.Body).ReadAll()
+	if err != nil || len(rows) != 4 || rows[0][2] != "api_key" {
+		t.Errorf("Unexpected CSV %v %v", err, rows)
+	}
+	if rows[1][1] != "acme" || rows[3][1] != defaultTenant || rows[3][2] != "" {
+		t.Errorf("Unexpected CSV rows %v", rows)
+	}
+}
+
+func TestBackup(t *testing.T) {
+	store := NewSecureKeyStore()
+	svc := NewSignerService(store)
+	acc, err := svc.GenerateKey(context.Background(), KeyGenRequest{})
+	if err != nil {
+		t.Fatalf("GenerateKey failed: %v", err)
+	}
+	secret, _ := store.Get(acc.PublicKey)
+	store.Freeze(context.Background(), acc.PublicKey, "audit", "alice")
+
+	key := make([]byte, 32)
+	rand.Read(key)
+	dir := t.TempDir()
+	backups, err := NewBackupScheduler(store, &DirBackupDestination{Dir: dir}, key, time.Hour, BackupRetention{KeepLast: 2})
+	if err != nil {
+		t.Fatalf("NewBackupScheduler failed: %v", err)
+	}
+	backups.metrics = NewMetrics(nil)
+
+	var last BackupResult
+	for range 3 {
+		if last, err = backups.Backup(context.Background()); err != nil {
+			t.Fatalf("Backup failed: %v", err)
+		}
+	}
+	if last.Keys != 1 || len(last.Deleted) != 1 {
+		t.Errorf("Unexpected backup %+v", last)
+	}
+	files, _ := os.ReadDir(dir)
+	if len(files) != 2 {
+		t.Errorf("Expected 2 backups kept, got %d", len(files))
+	}
+	raw, err := os.ReadFile(filepath.Join(dir, last.Name))
+	if err != nil {
+		t.Fatalf("Backup not written: %v", err)
+	}
+	if len(secret) == 0 || bytes.Contains(raw, secret) || strings.Contains(string(raw), base64.StdEncoding.EncodeToString(secret)) {
+		t.Error("Expected key material to be encrypted")
+	}
+
+	restored := NewSecureKeyStore()
+	if n, err := RestoreBackup(restored, raw, key); err != nil || n != 1 {
+		t.Fatalf("RestoreBackup = %d, %v", n, err)
+	}
+	info, err := restored.Info(acc.PublicKey)
+	if err != nil || info.Frozen == nil || info.KeyType != KeyTypeEd25519 {
+		t.Errorf("Unexpected restored key %+v: %v", info, err)
+	}
+
+	other := make([]byte, 32)
+	if _, err := RestoreBackup(NewSecureKeyStore(), raw, other); err == nil {
+		t.Error("Expected a backup to need its key")
+	}
+	tampered := bytes.Replace(raw, []byte(`"keys":1`), []byte(`"keys":2`), 1)
+	if _, err := RestoreBackup(NewSecureKeyStore(), tampered, key); err == nil {
+		t.Error("Expected a tampered header to be refused")
+	}
+
+	w := httptest.NewRecorder()
+	backups.metrics.Handler().ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	if !strings.Contains(w.Body.String(), `sts_backups_total{outcome="success"} 3`) {
+		t.Error("Expected backups to be counted")
+	}
+
+	if _, err := BackupSchedulerFromEnv(store, func(name string) string {
+		return map[string]string{"STS_BACKUP_DIR": dir, "STS_BACKUP_KEY": "short"}[name]
+	}); err == nil {
+		t.Error("Expected a short backup key to be refused")
+	}
+}
+
+// hostSealer stands in for a TPM, only the host that sealed a blob unseals it
+type hostSealer byte
+
+func (h hostSealer) Seal(key []byte) ([]byte, error) {
+	return append([]byte{byte(h)}, key...), nil
+}
+
+func (h hostSealer) Unseal(blob []byte) ([]byte, error) {
+	if len(blob) == 0 || blob[0] != byte(h) {
+		return nil, errors.New("sealed by another host")
+	}
+	return bytes.Clone(blob[1:]), nil
+}
+
+func TestSealedMasterKey(t *testing.T) {
+	key := make([]byte, 32)
+	rand.Read(key)
+	plain := base64.StdEncoding.EncodeToString(key)
+	path := filepath.Join(t.TempDir(), "backup.sealed")
+
+	// sealed from the plain key on first start
+	got, err := sealedMasterKey(hostSealer(1), path, plain)
+	if err != nil || !bytes.Equal(got, key) {
+		t.Fatalf("Expected the key sealed, got %x %v", got, err)
+	}
+	if blob, _ := os.ReadFile(path); len(blob) == 0 || blob[0] != 1 {
+		t.Errorf("Expected the sealed blob on disk, got %x", blob)
+	}
+
+	// unsealed on later starts w/o the plain key
+	if got, err := sealedMasterKey(hostSealer(1), path, ""); err != nil || !bytes.Equal(got, key) {
+		t.Errorf("Expected the key unsealed, got %x %v", got, err)
+	}
+	other := make([]byte, 32)
+	if _, err := sealedMasterKey(hostSealer(1), path, base64.StdEncoding.EncodeToString(other)); err == nil {
+		t.Error("Expected a different plain key refused")
+	}
+	// a copied disk image doesn't unseal elsewhere
+	if _, err := sealedMasterKey(hostSealer(2), path, ""); err == nil {
+		t.Error("Expected another host refused")
+	}
+
+	// w/o a plain key a new master key is made and only kept sealed
+	fresh := filepath.Join(t.TempDir(), "new.sealed")
+	generated, err := sealedMasterKey(hostSealer(1), fresh, "")
+	if err != nil || len(generated) != 32 || bytes.Equal(generated, key) {
+		t.Fatalf("Expected a new master key, got %x %v", generated, err)
+	}
+	if got, err := sealedMasterKey(hostSealer(1), fresh, ""); err != nil || !bytes.Equal(got, generated) {
+		t.Errorf("Expected the generated key unsealed on the next start, got %x %v", got, err)
+	}
+	if _, err := sealedMasterKey(hostSealer(1), filepath.Join(t.TempDir(), "short.sealed"), "short"); !errors.Is(err, errBackupKey) {
+		t.Errorf("Expected an invalid plain key refused, got %v", err)
+	}
+	if _, err := parsePCRs("0, 7,24"); err == nil {
+		t.Error("Expected an out of range PCR refused")
+	}
+	if pcrs, err := parsePCRs(" 7,0,7"); err != nil || !slices.Equal(pcrs, []int{0, 7}) {
+		t.Errorf("parsePCRs = %v %v", pcrs, err)
+	}
+	// w/o the tpm build tag there's no TPM to seal to
+	if _, err := NewTPMSealer("", ""); errors.Is(err, errTPMUnavailable) {
+		if _, err := MasterKeyFromEnv(func(name string) string {
+			return map[string]string{"STS_MASTER_KEY_SEALED": path}[name]
+		}); !errors.Is(err, errTPMUnavailable) {
+			t.Errorf("Expected TPM sealing unavailable, got %v", err)
+		}
+	}
+	if _, err := MasterKeyFromEnv(func(name string) string {
+		return map[string]string{"STS_MASTER_KEY_SEALED": path, "STS_TPM_PCRS": "99"}[name]
+	}); err == nil {
+		t.Error("Expected an invalid PCR list refused")
+	}
+}
+
+func TestKeyAnalytics(t *testing.T) {
+	analytics := NewKeyAnalytics()
+	exporter, err := AuditExporterFromEnv(func(string) string { return "" }, analytics)
+	if err != nil || exporter == nil {
+		t.Fatalf("AuditExporterFromEnv = %v, %v", exporter, err)
+	}
+	exporter.Start()
+	base, _ := NewLogger(io.Discard, "text")
+	defer slog.SetDefault(slog.Default())
+	slog.SetDefault(slog.New(exporter.Handler(base.Handler())))
+
+	svc := NewSignerService(NewSecureKeyStore())
+	server := NewAPIServer(svc)
+	server.Analytics = analytics
+	handler := server.middleware(server.routes())
+	do := func(method, target, body string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest(method, target, strings.NewReader(body)))
+		return w
+	}
+
+	var acc Account
+	json.Unmarshal(do(http.MethodPost, "/api/v1/keys/generate", `{"policy":{"usage":"persistent"}}`).Body.Bytes(), &acc)
+	txData := base64.StdEncoding.EncodeToString([]byte("payout"))
+	for _, signCtx := range []string{"payout", "payout", ""} {
+		do(http.MethodPost, "/api/v1/txs/sign", `{"keyId":"`+acc.PublicKey+`","unsignedTxData":"`+txData+`","context":"`+signCtx+`"}`)
+	}
+	// analytics are fed in the background, Close waits for them
+	if err := exporter.Close(context.Background()); err != nil {
+		t.Fatalf("Close failed: %v", err)
+	}
+
+	w := do(http.MethodGet, "/api/v1/keys/"+acc.PublicKey+"/analytics", "")
+	var report KeyAnalyticsReport
+	if err := json.Unmarshal(w.Body.Bytes(), &report); err != nil || w.Code != http.StatusOK {
+		t.Fatalf("Analytics returned %d %s", w.Code, w.Body)
+	}
+	if len(report.Days) != 1 || report.Days[0].Signatures != 2 || !report.Days[0].Day.Equal(complianceDay(time.Now())) {
+		t.Fatalf("Unexpected analytics %+v", report)
+	}
+	failures := 0
+	for _, n := range report.Days[0].Failures {
+		failures += n
+	}
+	if failures != 1 || report.Days[0].AverageLatencyMs <= 0 {
+		t.Errorf("Unexpected day %+v", report.Days[0])
+	}
+
+	if w := do(http.MethodGet, "/api/v1/keys/"+acc.PublicKey+"/analytics?from=2026-01-02&to=2026-01-01", ""); w.Code != http.StatusBadRequest {
+		t.Errorf("Expected a reversed range to be refused, got %d", w.Code)
+	}
+	if report := analytics.Report("other", time.Now().Add(-time.Hour), time.Now().Add(24*time.Hour)); len(report.Days) != 0 {
+		t.Errorf("Expected no days for an unknown key, got %+v", report.Days)
+	}
+}
+
+func TestCrashReport(t *testing.T) {
+	alerts := &recordingNotifier{sent: make(chan Notification, 2)}
+	notifier := NewNotificationDispatcher()
+	notifier.Add(alerts, SeverityCritical)
+	metrics := NewMetrics(nil)
+	dir := t.TempDir()
+	crashes := NewCrashReporter(dir, notifier, metrics)
+	crashes.Alerts = true
+
+	ctx := WithTenant(WithRequestID(context.Background(), "req-9"), "acme")
+	var reports []CrashReport
+	for range 2 {
+		func() {
+			defer func() {
+				if p := recover(); p != nil {
+					reports = append(reports, crashes.Report(ctx, "signer", p, "key_id", "k1", "secret", "hunter2"))
+				}
+			}()
+			var m map[string]int
+			m["boom"]++
+		}()
+	}
+
+	if len(reports) != 2 || reports[0].Signature != reports[1].Signature || reports[1].Occurrences != 2 {
+		t.Fatalf("Unexpected reports %+v", reports)
+	}
+	r := reports[0]
+	if r.RequestID != "req-9" || r.Tenant != "acme" || r.Context["key_id"] != "k1" || r.Context["secret"] != "REDACTED" {
+		t.Errorf("Unexpected report context %+v", r)
+	}
+	if !strings.Contains(r.Panic, "nil map") || !strings.Contains(r.Stack, "TestCrashReport") {
+		t.Errorf("Expected the panic and its stack, got %q", r.Panic)
+	}
+
+	files, _ := filepath.Glob(filepath.Join(dir, "crash-*.json"))
+	if len(files) != 2 {
+		t.Fatalf("Expected 2 crash reports, got %d", len(files))
+	}
+	raw, _ := os.ReadFile(files[0])
+	if strings.Contains(string(raw), "hunter2") {
+		t.Error("Expected the crash report to be redacted")
+	}
+
+	// the repeat is held back
+	select {
+	case n := <-alerts.sent:
+		if n.Kind != NotifyCrash || n.KeyID != "k1" {
+			t.Errorf("Unexpected alert %+v", n)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Expected a crash alert")
+	}
+	select {
+	case n := <-alerts.sent:
+		t.Errorf("Expected one alert per stack, got %+v", n)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	w := httptest.NewRecorder()
+	metrics.Handler().ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	if !strings.Contains(w.Body.String(), `sts_panics_total{component="signer"} 2`) {
+		t.Error("Expected panics to be counted")
+	}
+}
+
+func TestChainClient(t *testing.T) {
+	var conns atomic.Int32
+	rpc := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var call struct {
+			Method string `json:"method"`
+		}
+		json.NewDecoder(r.Body).Decode(&call)
+		switch call.Method {
+		case "getLatestBlockhash":
+			fmt.Fprint(w, `{"jsonrpc":"2.0","id":1,"result":{"context":{"slot":310},"value":{"blockhash":"EkSnNWid2cvwEVnVx9aBqawnmiCNiDgp3gUdkDPTKN1N","lastValidBlockHeight":450}}}`)
+		case "getSignatureStatuses":
+			fmt.Fprint(w, `{"jsonrpc":"2.0","id":1,"result":{"value":[{"slot":300,"confirmations":null,"err":{"InstructionError":[0,"Custom"]},"confirmationStatus":"finalized"},null]}}`)
+		case "getHealth":
+			time.Sleep(200 * time.Millisecond)
+			fmt.Fprint(w, `{"jsonrpc":"2.0","id":1,"result":"ok"}`)
+		}
+	}))
+	rpc.Config.ConnState = func(_ net.Conn, state http.ConnState) {
+		if state == http.StateNew {
+			conns.Add(1)
+		}
+	}
+	rpc.Start()
+	defer rpc.Close()
+
+	var chain ChainClient = NewSolanaRPC(rpc.URL, 100*time.Millisecond)
+	for range 3 {
+		latest, err := chain.LatestBlockhash(context.Background())
+		if err != nil || latest.Hash != "EkSnNWid2cvwEVnVx9aBqawnmiCNiDgp3gUdkDPTKN1N" || latest.LastValidBlockHeight != 450 || latest.Slot != 310 {
+			t.Fatalf("LatestBlockhash = %+v, %v", latest, err)
+		}
+	}
+	statuses, err := chain.SignatureStatuses(context.Background(), []string{"landed", "unknown"})
+	if err != nil || statuses[0] == nil || !statuses[0].Failed() || statuses[0].ConfirmationStatus != "finalized" || statuses[1] != nil {
+		t.Fatalf("SignatureStatuses = %+v, %v", statuses, err)
+	}
+	if n := conns.Load(); n != 1 {
+		t.Errorf("Expected calls to reuse one connection, opened %d", n)
+	}
+
+	// slower than the client's timeout
+	if err := chain.Health(context.Background()); err == nil {
+		t.Error("Expected a call past the timeout to fail")
+	}
+}
+
+func TestBroadcastRetry(t *testing.T) {
+	var sends atomic.Int32
+	var script func(n int32) (int, string)
+	valid := "true"
+	rpc := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var call struct {
+			Method string `json:"method"`
+		}
+		json.NewDecoder(r.Body).Decode(&call)
+		switch call.Method {
+		case "sendTransaction":
+			status, body := script(sends.Add(1))
+			w.WriteHeader(status)
+			fmt.Fprint(w, body)
+		case "isBlockhashValid":
+			fmt.Fprintf(w, `{"jsonrpc":"2.0","id":1,"result":{"context":{"slot":1},"value":%s}}`, valid)
+		case "getSignatureStatuses":
+			fmt.Fprint(w, `{"jsonrpc":"2.0","id":1,"result":{"value":[{"slot":77,"err":null}]}}`)
+		}
+	}))
+	defer rpc.Close()
+
+	svc := NewSignerService(NewSecureKeyStore())
+	svc.rpc = NewSolanaRPC(rpc.URL, 0)
+	svc.broadcastRetry = BroadcastRetry{Attempts: 3, Base: time.Millisecond, Max: 2 * time.Millisecond}
+	acc, _ := svc.GenerateKey(context.Background(), KeyGenRequest{Policy: &KeyPolicy{Usage: UsagePersistent}})
+	pub, _ := hex.DecodeString(acc.PublicKey)
+	payer := SolanaAddress(pub)
+	msg, _ := CompileSolanaMessage(payer, SystemProgramID, []SolanaInstruction{SystemCreateAccountIx(payer, payer, 1, 0, SystemProgramID)})
+	sign := func() (TransactionResult, error) {
+		sends.Store(0)
+		return svc.SignTransaction(context.Background(), TransactionRequest{
+			KeyID:          acc.PublicKey,
+			UnsignedTxData: base64.StdEncoding.EncodeToString(msg),
+			Context:        SolanaTxContext,
+			Broadcast:      true,
+		})
+	}
+
+	// two 502s, then it lands
+	script = func(n int32) (int, string) {
+		if n < 3 {
+			return http.StatusBadGateway, ""
+		}
+		return http.StatusOK, `{"jsonrpc":"2.0","id":1,"result":"5wHu1qwD"}`
+	}
+	res, err := sign()
+	if err != nil || res.TxSignature != "5wHu1qwD" || res.Slot != 77 {
+		t.Fatalf("Broadcast = %+v, %v", res, err)
+	}
+	if len(res.BroadcastAttempts) != 3 || !res.BroadcastAttempts[0].Retryable || res.BroadcastAttempts[2].Error != "" {
+		t.Errorf("Unexpected attempts %+v", res.BroadcastAttempts)
+	}
+
+	// still down after every attempt
+	script = func(int32) (int, string) { return http.StatusServiceUnavailable, "" }
+	if _, err := sign(); !errors.Is(err, errRPCUnavailable) || sends.Load() != 3 {
+		t.Errorf("Expected rpc_unavailable after 3 attempts, got %v after %d", err, sends.Load())
+	}
+
+	// a rejected transaction isn't retried
+	script = func(int32) (int, string) {
+		return http.StatusOK, `{"jsonrpc":"2.0","id":1,"error":{"code":-32002,"message":"Transaction simulation failed: Error processing Instruction 0: custom program error: 0x1"}}`
+	}
+	if _, err := sign(); err == nil || errors.Is(err, errRPCUnavailable) || sends.Load() != 1 {
+		t.Errorf("Expected one attempt for a rejected transaction, got %v after %d", err, sends.Load())
+	}
+
+	// preflight says the blockhash is gone
+	script = func(int32) (int, string) {
+		return http.StatusOK, `{"jsonrpc":"2.0","id":1,"error":{"code":-32002,"message":"Transaction simulation failed: Blockhash not found"}}`
+	}
+	if _, err := sign(); !errors.Is(err, errBlockhashExpired) || sends.Load() != 1 {
+		t.Errorf("Expected blockhash_expired, got %v after %d", err, sends.Load())
+	}
+
+	// expires while retrying
+	valid = "false"
+	script = func(int32) (int, string) { return http.StatusBadGateway, "" }
+	if _, err := sign(); !errors.Is(err, errBlockhashExpired) || sends.Load() != 1 {
+		t.Errorf("Expected the retry to stop at an expired blockhash, got %v after %d", err, sends.Load())
+	}
+
+	if d := defaultBroadcastRetry.delay(10); d < defaultBroadcastRetry.Max/2 || d > defaultBroadcastRetry.Max {
+		t.Errorf("Backoff %s outside the cap", d)
+	}
+}
+
+func TestTxTracker(t *testing.T) {
+	var status atomic.Value
+	status.Store(`null`)
+	rpc := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var call struct {
+			Method string            `json:"method"`
+			Params []json.RawMessage `json:"params"`
+		}
+		json.NewDecoder(r.Body).Decode(&call)
+		switch call.Method {
+		case "getSignatureStatuses":
+			var sigs []string
+			json.Unmarshal(call.Params[0], &sigs)
+			values := make([]string, len(sigs))
+			for i, sig := range sigs {
+				values[i] = "null"
+				if sig == "sig-1" {
+					values[i] = status.Load().(string)
+				}
+			}
+			fmt.Fprintf(w, `{"jsonrpc":"2.0","id":1,"result":{"value":[%s]}}`, strings.Join(values, ","))
+		case "isBlockhashValid":
+			fmt.Fprint(w, `{"jsonrpc":"2.0","id":1,"result":{"value":false}}`)
+		}
+	}))
+	defer rpc.Close()
+
+	txs := NewTxTracker(NewSolanaRPC(rpc.URL, 0))
+	txs.Track("sig-1", "key-1", "", "hash-1")
+	txs.Track("sig-2", "key-1", "", "hash-2")
+
+	steps := []struct {
+		status, want string
+	}{
+		{`null`, TxStatusBroadcast},
+		{`{"slot":90,"confirmations":0,"err":null,"confirmationStatus":"processed"}`, TxStatusProcessed},
+		{`{"slot":90,"confirmations":12,"err":null,"confirmationStatus":"confirmed"}`, TxStatusConfirmed},
+		// a lagging node doesn't step it back
+		{`{"slot":90,"confirmations":0,"err":null,"confirmationStatus":"processed"}`, TxStatusConfirmed},
+		{`{"slot":90,"confirmations":null,"err":null,"confirmationStatus":"finalized"}`, TxStatusFinalized},
+	}
+	for _, step := range steps {
+		status.Store(step.status)
+		if err := txs.Poll(context.Background()); err != nil {
+			t.Fatalf("Poll failed: %v", err)
+		}
+		if tx, _ := txs.Status("sig-1"); tx.Status != step.want {
+			t.Fatalf("After %s status is %s, want %s", step.status, tx.Status, step.want)
+		}
+	}
+
+	// still unseen and its blockhash ran out
+	txs.mu.Lock()
+	txs.txs["sig-2"].SignedAt = time.Now().Add(-2 * txExpiryCheckAfter)
+	txs.mu.Unlock()
+	txs.Poll(context.Background())
+	if tx, _ := txs.Status("sig-2"); tx.Status != TxStatusExpired {
+		t.Errorf("Expected sig-2 to expire, got %s", tx.Status)
+	}
+
+	server := NewAPIServer(NewSignerService(NewSecureKeyStore()))
+	server.Txs = txs
+	handler := server.middleware(server.routes())
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/api/v1/txs/sig-1", nil))
+	var tx TrackedTx
+	if err := json.Unmarshal(w.Body.Bytes(), &tx); err != nil || tx.Status != TxStatusFinalized || tx.Slot != 90 || tx.KeyID != "key-1" {
+		t.Errorf("Status endpoint returned %d %s", w.Code, w.Body)
+	}
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/api/v1/txs/unknown", nil))
+	if w.Code != http.StatusNotFound || !strings.Contains(w.Body.String(), "tx_not_found") {
+		t.Errorf("Expected 404 for an unknown transaction, got %d %s", w.Code, w.Body)
+	}
+}
+
+func TestRPCPool(t *testing.T) {
+	endpoint := func(calls *atomic.Int32, status *atomic.Int32, body string) *httptest.Server {
+		return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			calls.Add(1)
+			if code := int(status.Load()); code != http.StatusOK {
+				w.WriteHeader(code)
+				return
+			}
+			raw, _ := io.ReadAll(r.Body)
+			if bytes.Contains(raw, []byte("getHealth")) {
+				fmt.Fprint(w, `{"jsonrpc":"2.0","id":1,"result":"ok"}`)
+				return
+			}
+			fmt.Fprint(w, body)
+		}))
+	}
+	var callsA, callsB, statusA, statusB atomic.Int32
+	statusA.Store(http.StatusOK)
+	statusB.Store(http.StatusOK)
+	a := endpoint(&callsA, &statusA, `{"jsonrpc":"2.0","id":1,"result":"sig-a"}`)
+	defer a.Close()
+	b := endpoint(&callsB, &statusB, `{"jsonrpc":"2.0","id":1,"result":"sig-b"}`)
+	defer b.Close()
+
+	if _, err := NewRPCPool([]string{"ftp://rpc"}, 0); err == nil {
+		t.Error("Expected a non-http endpoint to be refused")
+	}
+	pool, err := NewRPCPool([]string{a.URL, " " + b.URL}, 0)
+	if err != nil {
+		t.Fatalf("NewRPCPool failed: %v", err)
+	}
+	var chain ChainClient = pool
+
+	// healthy endpoints take turns
+	for range 4 {
+		if _, err := chain.SendTransaction(context.Background(), []byte("tx")); err != nil {
+			t.Fatalf("SendTransaction failed: %v", err)
+		}
+	}
+	if callsA.Load() != 2 || callsB.Load() != 2 {
+		t.Errorf("Expected round-robin, got %d and %d calls", callsA.Load(), callsB.Load())
+	}
+
+	// a failing endpoint is failed over and, once down, skipped
+	statusA.Store(http.StatusBadGateway)
+	callsA.Store(0)
+	for range 8 {
+		if sig, err := chain.SendTransaction(context.Background(), []byte("tx")); err != nil || sig != "sig-b" {
+			t.Fatalf("Expected failover to b, got %q, %v", sig, err)
+		}
+	}
+	if callsA.Load() != rpcDownAfter {
+		t.Errorf("Expected a to be skipped once down, it got %d calls", callsA.Load())
+	}
+	endpoints := pool.Endpoints()
+	if !endpoints[0].Down || endpoints[0].Failures != rpcDownAfter || endpoints[1].Down || endpoints[1].Score < 0.99 {
+		t.Errorf("Unexpected endpoint health %+v", endpoints)
+	}
+	if strings.Contains(endpoints[0].LastError, "http://") {
+		t.Errorf("Expected no URLs in endpoint errors, got %q", endpoints[0].LastError)
+	}
+	if err := chain.Health(context.Background()); err != nil {
+		t.Errorf("Expected the pool healthy while b is, got %v", err)
+	}
+
+	// a refused transaction isn't tried elsewhere
+	statusA.Store(http.StatusOK)
+	pool.mu.Lock()
+	pool.endpoints[0].downUntil = time.Time{}
+	pool.endpoints[0].score = 1
+	pool.mu.Unlock()
+	refused := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `{"jsonrpc":"2.0","id":1,"error":{"code":-32002,"message":"Transaction simulation failed"}}`)
+	}))
+	defer refused.Close()
+	pool, _ = NewRPCPool([]string{refused.URL, b.URL}, 0)
+	callsB.Store(0)
+	if _, err := pool.SendTransaction(context.Background(), []byte("tx")); err == nil || callsB.Load() != 0 {
+		t.Errorf("Expected the refusal w/o failover, got %v and %d calls to b", err, callsB.Load())
+	}
+
+	// both down is still an answer
+	statusB.Store(http.StatusServiceUnavailable)
+	pool, _ = NewRPCPool([]string{b.URL}, 0)
+	if _, err := pool.SendTransaction(context.Background(), []byte("tx")); !transientRPCError(err) {
+		t.Errorf("Expected the last endpoint's error, got %v", err)
+	}
+}
+
+func TestPriorityFee(t *testing.T) {
+	var accounts []string
+	rpc := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var call struct {
+			Method string     `json:"method"`
+			Params [][]string `json:"params"`
+		}
+		json.NewDecoder(r.Body).Decode(&call)
+		if call.Method == "getRecentPrioritizationFees" {
+			accounts = call.Params[0]
+			fmt.Fprint(w, `{"jsonrpc":"2.0","id":1,"result":[{"slot":1,"prioritizationFee":0},{"slot":2,"prioritizationFee":500},{"slot":3,"prioritizationFee":1000},{"slot":4,"prioritizationFee":9000},{"slot":5,"prioritizationFee":200}]}`)
+		}
+	}))
+	defer rpc.Close()
+
+	svc := NewSignerService(NewSecureKeyStore())
+	svc.rpc = NewSolanaRPC(rpc.URL, 0)
+	svc.fees = NewFeeAdvisor(svc.rpc, 0)
+
+	advice, err := svc.fees.Advise(context.Background(), nil)
+	if err != nil || advice.Slots != 5 || advice.Min != 0 || advice.Median != 500 || advice.Recommended != 1000 || advice.Max != 9000 {
+		t.Fatalf("Advise = %+v, %v", advice, err)
+	}
+
+	gen := func(fees *PriorityFeePolicy) (string, SolanaPubkey) {
+		acc, _ := svc.GenerateKey(context.Background(), KeyGenRequest{Policy: &KeyPolicy{Usage: UsagePersistent, PriorityFees: fees}})
+		pub, _ := hex.DecodeString(acc.PublicKey)
+		return acc.PublicKey, SolanaAddress(pub)
+	}
+	dest := MustSolanaPubkey("BPFLoaderUpgradeab1e11111111111111111111111")
+	sign := func(keyID string, tx []byte) (TransactionResult, error) {
+		return svc.SignTransaction(context.Background(), TransactionRequest{
+			KeyID:          keyID,
+			UnsignedTxData: base64.StdEncoding.EncodeToString(tx),
+			Context:        SolanaTxContext,
+			PriorityFee:    true,
+		})
+	}
+
+	// added, w/ the limit, and signed over the new message
+	keyID, payer := gen(&PriorityFeePolicy{MaxMicroLamports: 5000, ComputeUnitLimit: 30000})
+	msg, _ := CompileSolanaMessage(payer, SystemProgramID, []SolanaInstruction{SystemTransferIx(payer, dest, 1)})
+	res, err := sign(keyID, msg)
+	if err != nil || res.PriorityFeeMicroLamports != 1000 {
+		t.Fatalf("Sign = %+v, %v", res, err)
+	}
+	if len(accounts) != 2 || accounts[0] != payer.String() || accounts[1] != dest.String() {
+		t.Errorf("Expected fees for the writable accounts, asked for %v", accounts)
+	}
+	wire, _ := base64.StdEncoding.DecodeString(res.Transaction)
+	tx, err := ParseSolanaPayload(wire)
+	if err != nil {
+		t.Fatalf("Signed transaction doesn't parse: %v", err)
+	}
+	ixs := tx.Message.Instructions
+	if len(ixs) != 3 || tx.Message.AccountKeys[ixs[0].ProgramIDIndex] != ComputeBudgetProgramID ||
+		!bytes.Equal(ixs[0].Data, []byte{2, 0x30, 0x75, 0, 0}) || !bytes.Equal(ixs[1].Data, computeUnitPriceData(1000)) {
+		t.Errorf("Unexpected compute budget instructions %+v", ixs)
+	}
+	if tx.Message.NumReadonlyUnsignedAccounts != 2 || tx.Message.AccountKeys[ixs[2].Accounts[1]] != dest {
+		t.Errorf("Unexpected account layout %+v", tx.Message)
+	}
+	if !ed25519.Verify(ed25519.PublicKey(payer[:]), tx.MsgBytes, tx.Signatures[0]) {
+		t.Error("Expected the signature to cover the priced message")
+	}
+
+	// an existing price is replaced, capped by the policy
+	keyID, payer = gen(&PriorityFeePolicy{MaxMicroLamports: 700})
+	msg, _ = CompileSolanaMessage(payer, SystemProgramID, []SolanaInstruction{
+		{ProgramID: ComputeBudgetProgramID, Data: computeUnitPriceData(1)},
+		SystemTransferIx(payer, dest, 1),
+	})
+	if res, err = sign(keyID, msg); err != nil || res.PriorityFeeMicroLamports != 700 {
+		t.Fatalf("Sign = %+v, %v", res, err)
+	}
+	wire, _ = base64.StdEncoding.DecodeString(res.Transaction)
+	tx, _ = ParseSolanaPayload(wire)
+	if len(tx.Message.Instructions) != 2 || !bytes.Equal(tx.Message.Instructions[0].Data, computeUnitPriceData(700)) {
+		t.Errorf("Expected the price replaced in place, got %+v", tx.Message.Instructions)
+	}
+
+	// other signers' signatures would break
+	signed := append(appendCompactU16(nil, 1), append(bytes.Repeat([]byte{7}, 64), msg...)...)
+	if _, err := sign(keyID, signed); !errors.Is(err, errTxAlreadySigned) {
+		t.Errorf("Expected a signed transaction refused, got %v", err)
+	}
+
+	// only keys whose policy allows it
+	keyID, payer = gen(nil)
+	msg, _ = CompileSolanaMessage(payer, SystemProgramID, []SolanaInstruction{SystemTransferIx(payer, dest, 1)})
+	if _, err := sign(keyID, msg); !errors.Is(err, errPolicyViolation) {
+		t.Errorf("Expected a policy violation, got %v", err)
+	}
+	if err := (KeyPolicy{Usage: UsagePersistent, PriorityFees: &PriorityFeePolicy{}}).Validate(); err == nil {
+		t.Error("Expected a fee policy w/o a cap to be invalid")
+	}
+}
+
+func TestBlockhashCache(t *testing.T) {
+	var fetches atomic.Int32
+	rpc := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fetches.Add(1)
+		fmt.Fprint(w, `{"jsonrpc":"2.0","id":1,"result":{"context":{"slot":310},"value":{"blockhash":"EkSnNWid2cvwEVnVx9aBqawnmiCNiDgp3gUdkDPTKN1N","lastValidBlockHeight":450}}}`)
+	}))
+	defer rpc.Close()
+
+	svc := NewSignerService(NewSecureKeyStore())
+	svc.rpc = NewSolanaRPC(rpc.URL, 0)
+	svc.blockhashes = NewBlockhashCache(svc.rpc)
+	fresh := MustSolanaPubkey("EkSnNWid2cvwEVnVx9aBqawnmiCNiDgp3gUdkDPTKN1N")
+
+	for range 3 {
+		if latest, err := svc.blockhashes.Latest(context.Background()); err != nil || latest.Hash != fresh.String() {
+			t.Fatalf("Latest = %+v, %v", latest, err)
+		}
+	}
+	if fetches.Load() != 1 {
+		t.Errorf("Expected one fetch while the blockhash is fresh, got %d", fetches.Load())
+	}
+	var none *BlockhashCache
+	if _, err := none.Latest(context.Background()); !errors.Is(err, errNoRPC) {
+		t.Errorf("Expected a nil cache to have no rpc, got %v", err)
+	}
+
+	acc, _ := svc.GenerateKey(context.Background(), KeyGenRequest{Policy: &KeyPolicy{Usage: UsagePersistent}})
+	pub, _ := hex.DecodeString(acc.PublicKey)
+	payer := SolanaAddress(pub)
+	sign := func(tx []byte) (TransactionResult, error) {
+		return svc.SignTransaction(context.Background(), TransactionRequest{
+			KeyID:            acc.PublicKey,
+			UnsignedTxData:   base64.StdEncoding.EncodeToString(tx),
+			Context:          SolanaTxContext,
+			RefreshBlockhash: true,
+		})
+	}
+
+	// stale blockhash swapped for the cached one, then signed
+	msg, _ := CompileSolanaMessage(payer, SystemProgramID, []SolanaInstruction{SystemTransferIx(payer, SysvarRentID, 1)})
+	res, err := sign(msg)
+	if err != nil || res.Blockhash != fresh.String() || res.LastValidBlockHeight != 450 {
+		t.Fatalf("Sign = %+v, %v", res, err)
+	}
+	wire, _ := base64.StdEncoding.DecodeString(res.Transaction)
+	tx, _ := ParseSolanaPayload(wire)
+	if tx.Message.RecentBlockhash != fresh || !ed25519.Verify(ed25519.PublicKey(pub), tx.MsgBytes, tx.Signatures[0]) {
+		t.Errorf("Expected the signature over the refreshed message, got blockhash %s", tx.Message.RecentBlockhash)
+	}
+
+	// a durable nonce's blockhash is the nonce
+	advance := SolanaInstruction{
+		ProgramID: SystemProgramID,
+		Accounts:  []SolanaAccountMeta{{Pubkey: SysvarClockID, IsWritable: true}, {Pubkey: SysvarRentID}, {Pubkey: payer, IsSigner: true}},
+		Data:      binary.LittleEndian.AppendUint32(nil, systemAdvanceNonce),
+	}
+	msg, _ = CompileSolanaMessage(payer, SystemProgramID, []SolanaInstruction{advance, SystemTransferIx(payer, SysvarRentID, 1)})
+	if _, err := sign(msg); !errors.Is(err, errDurableNonce) {
+		t.Errorf("Expected a durable nonce transaction refused, got %v", err)
+	}
+
+	// as is a transaction someone already signed
+	msg, _ = CompileSolanaMessage(payer, SystemProgramID, []SolanaInstruction{SystemTransferIx(payer, SysvarRentID, 1)})
+	signed := append(appendCompactU16(nil, 1), append(bytes.Repeat([]byte{7}, 64), msg...)...)
+	if _, err := sign(signed); !errors.Is(err, errTxAlreadySigned) {
+		t.Errorf("Expected a signed transaction refused, got %v", err)
+	}
+}
+
+func TestTxRecord(t *testing.T) {
+	svc := NewSignerService(NewSecureKeyStore())
+	svc.txs = NewTxTracker(nil)
+	acc, _ := svc.GenerateKey(context.Background(), KeyGenRequest{Policy: &KeyPolicy{Usage: UsagePersistent}})
+	pub, _ := hex.DecodeString(acc.PublicKey)
+	payer := SolanaAddress(pub)
+	msg, _ := CompileSolanaMessage(payer, SysvarClockID, []SolanaInstruction{SystemTransferIx(payer, SysvarRentID, 1)})
+
+	// signed w/o broadcast, and w/o a cluster to follow it on
+	res, err := svc.SignTransaction(context.Background(), TransactionRequest{KeyID: acc.PublicKey, UnsignedTxData: base64.StdEncoding.EncodeToString(msg), Context: SolanaTxContext})
+	if err != nil {
+		t.Fatalf("Sign failed: %v", err)
+	}
+	tx, err := svc.txs.Status(res.TxSignature)
+	if err != nil || tx.Status != TxStatusSigned || tx.KeyID != acc.PublicKey || tx.SignedAt.IsZero() || tx.BroadcastAt != nil || tx.blockhash != SysvarClockID.String() {
+		t.Fatalf("Status = %+v, %v", tx, err)
+	}
+	if err := svc.txs.Poll(context.Background()); err != nil {
+		t.Errorf("Expected polling w/o a cluster to do nothing, got %v", err)
+	}
+
+	// broadcast later keeps the signing time
+	svc.txs.Track(res.TxSignature, acc.PublicKey, "", SysvarClockID.String())
+	if sent, _ := svc.txs.Status(res.TxSignature); sent.Status != TxStatusBroadcast || sent.BroadcastAt == nil || !sent.SignedAt.Equal(tx.SignedAt) {
+		t.Errorf("After broadcast = %+v", sent)
+	}
+
+	// a nonce transaction the cluster hasn't seen doesn't expire
+	rpc := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var call struct {
+			Method string `json:"method"`
+		}
+		json.NewDecoder(r.Body).Decode(&call)
+		switch call.Method {
+		case "getSignatureStatuses":
+			fmt.Fprint(w, `{"jsonrpc":"2.0","id":1,"result":{"value":[null]}}`)
+		case "isBlockhashValid":
+			fmt.Fprint(w, `{"jsonrpc":"2.0","id":1,"result":{"context":{"slot":1},"value":false}}`)
+		}
+	}))
+	defer rpc.Close()
+	txs := NewTxTracker(NewSolanaRPC(rpc.URL, 0))
+	for _, nonce := range []bool{false, true} {
+		sig := fmt.Sprint("sig-", nonce)
+		txs.Signed(sig, "key-1", "", "hash", nonce, nil)
+		txs.mu.Lock()
+		txs.txs[sig].SignedAt = time.Now().Add(-2 * txExpiryCheckAfter)
+		txs.mu.Unlock()
+		txs.Poll(context.Background())
+		want := TxStatusExpired
+		if nonce {
+			want = TxStatusSigned
+		}
+		if got, _ := txs.Status(sig); got.Status != want {
+			t.Errorf("Nonce %v: status %s, want %s", nonce, got.Status, want)
+		}
+	}
+}
+
+func TestKeyBalance(t *testing.T) {
+	var programs []string
+	rpc := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var call struct {
+			Method string `json:"method"`
+			Params []any  `json:"params"`
+		}
+		json.NewDecoder(r.Body).Decode(&call)
+		switch call.Method {
+		case "getBalance":
+			fmt.Fprint(w, `{"jsonrpc":"2.0","id":1,"result":{"context":{"slot":5},"value":2500000000}}`)
+		case "getTokenAccountsByOwner":
+			program := call.Params[1].(map[string]any)["programId"].(string)
+			programs = append(programs, program)
+			if program != TokenProgramID.String() {
+				fmt.Fprint(w, `{"jsonrpc":"2.0","id":1,"result":{"context":{"slot":5},"value":[]}}`)
+				return
+			}
+			fmt.Fprint(w, `{"jsonrpc":"2.0","id":1,"result":{"context":{"slot":5},"value":[{"pubkey":"ata1","account":{"data":{"parsed":{"info":{"mint":"mint1","tokenAmount":{"amount":"18446744073709551615","decimals":6,"uiAmountString":"18446744073709.551615"}}}}}}]}}`)
+		}
+	}))
+	defer rpc.Close()
+
+	store := NewSecureKeyStore()
+	svc := NewSignerService(store)
+	acc, _ := svc.GenerateKey(context.Background(), KeyGenRequest{Policy: &KeyPolicy{Usage: UsagePersistent}})
+	p256, _ := svc.GenerateKey(context.Background(), KeyGenRequest{KeyType: KeyTypeP256, Policy: &KeyPolicy{Usage: UsagePersistent}})
+
+	balances := NewKeyBalances(store, NewSolanaRPC(rpc.URL, 0))
+	if lamports, err := balances.Balance(context.Background(), acc.PublicKey); err != nil || lamports != 2500000000 {
+		t.Errorf("Balance = %d, %v", lamports, err)
+	}
+
+	server := NewAPIServer(svc)
+	server.Balances = balances
+	handler := server.middleware(server.routes())
+	get := func(id string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/api/v1/keys/"+id+"/balance", nil))
+		return w
+	}
+
+	w := get(acc.PublicKey)
+	var balance KeyBalance
+	if err := json.Unmarshal(w.Body.Bytes(), &balance); err != nil || balance.Lamports != 2500000000 || len(balance.Tokens) != 1 {
+		t.Fatalf("Balance endpoint returned %d %s", w.Code, w.Body)
+	}
+	pub, _ := hex.DecodeString(acc.PublicKey)
+	if balance.Address != SolanaAddress(pub).String() || balance.Tokens[0].Amount != "18446744073709551615" || balance.Tokens[0].Decimals != 6 || balance.Tokens[0].Program != TokenProgramID.String() {
+		t.Errorf("Unexpected balance %+v", balance)
+	}
+	if len(programs) != 2 || programs[1] != Token2022ProgramID.String() {
+		t.Errorf("Expected both token programs queried, got %v", programs)
+	}
+
+	if w := get(p256.PublicKey); w.Code != http.StatusBadRequest || !strings.Contains(w.Body.String(), "no_chain_address") {
+		t.Errorf("Expected a p256 key refused, got %d %s", w.Code, w.Body)
+	}
+	if w := get("00ff"); w.Code != http.StatusNotFound {
+		t.Errorf("Expected 404 for an unknown key, got %d %s", w.Code, w.Body)
+	}
+	rpc.Close()
+	if w := get(acc.PublicKey); w.Code != http.StatusBadGateway {
+		t.Errorf("Expected 502 w/ the rpc down, got %d %s", w.Code, w.Body)
+	}
+}
+
+func TestAirdrop(t *testing.T) {
+	genesis := "EtWTRABZaYq6iMfeYKouRu166VU2xqa1wcaWoxPkrZBG"
+	var polls atomic.Int32
+	var requested []any
+	rpc := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var call struct {
+			Method string `json:"method"`
+			Params []any  `json:"params"`
+		}
+		json.NewDecoder(r.Body).Decode(&call)
+		switch call.Method {
+		case "getGenesisHash":
+			fmt.Fprintf(w, `{"jsonrpc":"2.0","id":1,"result":%q}`, genesis)
+		case "requestAirdrop":
+			requested = call.Params
+			fmt.Fprint(w, `{"jsonrpc":"2.0","id":1,"result":"airdrop-sig"}`)
+		case "getSignatureStatuses":
+			if polls.Add(1) == 1 {
+				fmt.Fprint(w, `{"jsonrpc":"2.0","id":1,"result":{"value":[null]}}`)
+				return
+			}
+			fmt.Fprint(w, `{"jsonrpc":"2.0","id":1,"result":{"value":[{"slot":42,"err":null,"confirmationStatus":"confirmed"}]}}`)
+		}
+	}))
+	defer rpc.Close()
+
+	store := NewSecureKeyStore()
+	svc := NewSignerService(store)
+	acc, _ := svc.GenerateKey(context.Background(), KeyGenRequest{Policy: &KeyPolicy{Usage: UsagePersistent}})
+	server := NewAPIServer(svc)
+	server.Airdrops = NewAirdropper(store, NewSolanaRPC(rpc.URL, 0))
+	handler := server.middleware(server.routes())
+	airdrop := func(body string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/api/v1/keys/"+acc.PublicKey+"/airdrop", strings.NewReader(body)))
+		return w
+	}
+
+	w := airdrop("")
+	var result AirdropResult
+	if err := json.Unmarshal(w.Body.Bytes(), &result); err != nil || result.Signature != "airdrop-sig" || result.Status != TxStatusConfirmed || result.Slot != 42 || result.Lamports != defaultAirdropLamports {
+		t.Fatalf("Airdrop returned %d %s", w.Code, w.Body)
+	}
+	if len(requested) < 2 || requested[0] != result.Address || requested[1] != float64(defaultAirdropLamports) {
+		t.Errorf("Unexpected requestAirdrop params %v", requested)
+	}
+	if w := airdrop(`{"lamports":6000000000}`); w.Code != http.StatusBadRequest {
+		t.Errorf("Expected an oversized airdrop refused, got %d %s", w.Code, w.Body)
+	}
+
+	// never on mainnet
+	genesis = "5eykt4UsFv8P8NJdTREpY1vzqKqZKvdpKuc147dw2N9d"
+	server.Airdrops = NewAirdropper(store, NewSolanaRPC(rpc.URL, 0))
+	requested = nil
+	if w := airdrop(""); w.Code != http.StatusForbidden || !strings.Contains(w.Body.String(), "airdrop_mainnet") || requested != nil {
+		t.Errorf("Expected mainnet refused, got %d %s", w.Code, w.Body)
+	}
+	if clusterOf("local-genesis") != ClusterCustom {
+		t.Error("Expected an unknown genesis to be a custom cluster")
+	}
+}
+
+func TestClusters(t *testing.T) {
+	env := func(vars map[string]string) func(string) string {
+		return func(k string) string { return vars[k] }
+	}
+	if clusters, _, err := ClustersFromEnv(env(nil)); clusters != nil || err != nil {
+		t.Errorf("Expected no clusters w/o endpoints, got %v, %v", clusters, err)
+	}
+	if _, _, err := ClustersFromEnv(env(map[string]string{"STS_SOLANA_CLUSTER": "moonnet", "STS_SOLANA_RPC_URL": "http://rpc"})); !errors.Is(err, errUnknownCluster) {
+		t.Errorf("Expected an unknown default refused, got %v", err)
+	}
+	if _, _, err := ClustersFromEnv(env(map[string]string{"STS_SOLANA_CLUSTER": ClusterMainnet, "STS_SOLANA_RPC_URL_DEVNET": "http://devnet"})); err == nil {
+		t.Error("Expected a default cluster w/o endpoints refused")
+	}
+	clusters, pools, err := ClustersFromEnv(env(map[string]string{
+		"STS_SOLANA_CLUSTER":          ClusterMainnet,
+		"STS_SOLANA_RPC_URL":          "http://mainnet-a,http://mainnet-b",
+		"STS_SOLANA_RPC_URL_DEVNET":   "http://devnet",
+		"STS_MAINNET_REQUIRES_POLICY": "true",
+	}))
+	if err != nil || len(pools) != 2 || !clusters.MainnetNeedsPolicy {
+		t.Fatalf("ClustersFromEnv = %v, %d pools, %v", clusters, len(pools), err)
+	}
+	if all := clusters.All(); len(all) != 2 || all[0].Name != ClusterMainnet || all[1].Name != ClusterDevnet {
+		t.Errorf("Expected mainnet then devnet, got %+v", all)
+	}
+	if _, err := clusters.Get(ClusterTestnet); !errors.Is(err, errUnknownCluster) {
+		t.Errorf("Expected testnet w/o endpoints unknown, got %v", err)
+	}
+
+	// each cluster's fake answers sendTransaction w/ its own name
+	fake := func(name string) *Cluster {
+		rpc := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			var call struct {
+				Method string `json:"method"`
+			}
+			json.NewDecoder(r.Body).Decode(&call)
+			switch call.Method {
+			case "sendTransaction":
+				fmt.Fprintf(w, `{"jsonrpc":"2.0","id":1,"result":"sent-on-%s"}`, name)
+			case "getSignatureStatuses":
+				fmt.Fprint(w, `{"jsonrpc":"2.0","id":1,"result":{"value":[{"slot":9,"err":null}]}}`)
+			}
+		}))
+		t.Cleanup(rpc.Close)
+		chain := NewSolanaRPC(rpc.URL, 0)
+		return &Cluster{Name: name, Chain: chain, Fees: NewFeeAdvisor(chain, 0), Blockhashes: NewBlockhashCache(chain)}
+	}
+	clusters = NewClusters(ClusterMainnet)
+	clusters.MainnetNeedsPolicy = true
+	clusters.Add(fake(ClusterMainnet))
+	clusters.Add(fake(ClusterDevnet))
+	svc := NewSignerService(NewSecureKeyStore())
+	svc.clusters = clusters
+	svc.txs = NewTxTracker(nil)
+
+	sign := func(policy KeyPolicy, cluster string) (TransactionResult, error) {
+		policy.Usage = UsagePersistent
+		acc, err := svc.GenerateKey(context.Background(), KeyGenRequest{Policy: &policy})
+		if err != nil {
+			t.Fatalf("GenerateKey failed: %v", err)
+		}
+		pub, _ := hex.DecodeString(acc.PublicKey)
+		payer := SolanaAddress(pub)
+		msg, _ := CompileSolanaMessage(payer, SysvarClockID, []SolanaInstruction{SystemTransferIx(payer, SysvarRentID, 1)})
+		return svc.SignTransaction(context.Background(), TransactionRequest{
+			KeyID:          acc.PublicKey,
+			UnsignedTxData: base64.StdEncoding.EncodeToString(msg),
+			Context:        SolanaTxContext,
+			Broadcast:      true,
+			Cluster:        cluster,
+		})
+	}
+
+	// the request's cluster, then the key's, then the default
+	if res, err := sign(KeyPolicy{}, ClusterDevnet); err != nil || res.TxSignature != "sent-on-devnet" || res.Cluster != ClusterDevnet {
+		t.Errorf("Request cluster: %+v, %v", res, err)
+	}
+	if res, err := sign(KeyPolicy{Cluster: ClusterDevnet}, ""); err != nil || res.TxSignature != "sent-on-devnet" {
+		t.Errorf("Key cluster: %+v, %v", res, err)
+	} else if tx, _ := svc.txs.Status(res.TxSignature); tx.Cluster != ClusterDevnet {
+		t.Errorf("Expected the record on devnet, got %+v", tx)
+	}
+	if res, err := sign(KeyPolicy{AllowedClusters: []string{ClusterMainnet}}, ""); err != nil || res.TxSignature != "sent-on-mainnet-beta" {
+		t.Errorf("Default cluster: %+v, %v", res, err)
+	}
+
+	// mainnet only for keys that list it
+	if _, err := sign(KeyPolicy{}, ""); !errors.Is(err, errPolicyViolation) {
+		t.Errorf("Expected mainnet refused w/o policy, got %v", err)
+	}
+	if _, err := sign(KeyPolicy{AllowedClusters: []string{ClusterDevnet}}, ClusterMainnet); !errors.Is(err, errPolicyViolation) {
+		t.Errorf("Expected a devnet key refused on mainnet, got %v", err)
+	}
+	if _, err := sign(KeyPolicy{}, ClusterTestnet); !errors.Is(err, errUnknownCluster) {
+		t.Errorf("Expected a cluster w/o endpoints refused, got %v", err)
+	}
+	if err := (KeyPolicy{Usage: UsagePersistent, Cluster: ClusterMainnet, AllowedClusters: []string{ClusterDevnet}}).Validate(); err == nil {
+		t.Error("Expected a key cluster outside its allowed clusters to be invalid")
+	}
+}
+
+func TestTokenTransfer(t *testing.T) {
+	mint := MustSolanaPubkey("EPjFWdd5AufqSSqeM2qN1xzybapC8G4wEGGkZwyTDt1v")
+	rpc := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var call struct {
+			Method string `json:"method"`
+			Params []any  `json:"params"`
+		}
+		json.NewDecoder(r.Body).Decode(&call)
+		switch call.Method {
+		case "getAccountInfo":
+			if call.Params[0] != mint.String() {
+				fmt.Fprint(w, `{"jsonrpc":"2.0","id":1,"result":{"value":null}}`)
+				return
+			}
+			fmt.Fprintf(w, `{"jsonrpc":"2.0","id":1,"result":{"value":{"owner":%q,"data":{"parsed":{"type":"mint","info":{"decimals":6}}}}}}`, TokenProgramID)
+		case "getLatestBlockhash":
+			fmt.Fprint(w, `{"jsonrpc":"2.0","id":1,"result":{"context":{"slot":310},"value":{"blockhash":"EkSnNWid2cvwEVnVx9aBqawnmiCNiDgp3gUdkDPTKN1N","lastValidBlockHeight":450}}}`)
+		}
+	}))
+	defer rpc.Close()
+
+	store := NewSecureKeyStore()
+	svc := NewSignerService(store)
+	svc.rpc = NewSolanaRPC(rpc.URL, 0)
+	svc.blockhashes = NewBlockhashCache(svc.rpc)
+	acc, _ := svc.GenerateKey(context.Background(), KeyGenRequest{Policy: &KeyPolicy{Usage: UsagePersistent}})
+	server := NewAPIServer(svc)
+	server.Builder = NewTxBuilder(svc)
+	handler := server.middleware(server.routes())
+	transfer := func(body string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/api/v1/keys/"+acc.PublicKey+"/transfers/token", strings.NewReader(body)))
+		return w
+	}
+	dest := MustSolanaPubkey("9WzDXwBbmkg8ZTbNMqUxvQRAyrZzDsGYdLVL9zYtAWWM")
+
+	w := transfer(fmt.Sprintf(`{"mint":%q,"destination":%q,"amount":"2500000","decimals":6}`, mint, dest))
+	var result TransactionResult
+	if err := json.Unmarshal(w.Body.Bytes(), &result); err != nil || w.Code != http.StatusOK || result.Transaction == "" {
+		t.Fatalf("Token transfer returned %d %s", w.Code, w.Body)
+	}
+	if result.Blockhash != "EkSnNWid2cvwEVnVx9aBqawnmiCNiDgp3gUdkDPTKN1N" {
+		t.Errorf("Expected the cached blockhash, got %q", result.Blockhash)
+	}
+	raw, _ := base64.StdEncoding.DecodeString(result.Transaction)
+	tx, err := ParseSolanaPayload(raw)
+	if err != nil || !tx.FullySigned() {
+		t.Fatalf("Expected a fully signed transaction, got %v", err)
+	}
+	transfers, _ := DecodeSolanaTransfers(tx.Message)
+	pub, _ := hex.DecodeString(acc.PublicKey)
+	owner := SolanaAddress(pub)
+	wantSource, _ := AssociatedTokenAddress(owner, mint, TokenProgramID)
+	wantDest, _ := AssociatedTokenAddress(dest, mint, TokenProgramID)
+	if len(transfers) != 1 || transfers[0].Amount != 2500000 || transfers[0].Mint != mint ||
+		transfers[0].Source != wantSource || transfers[0].Destination != wantDest || transfers[0].Authority != owner {
+		t.Errorf("Unexpected transfer %+v", transfers)
+	}
+
+	for body, code := range map[string]string{
+		fmt.Sprintf(`{"mint":%q,"destination":%q,"amount":"1","decimals":9}`, mint, dest):                    "decimals_mismatch",
+		fmt.Sprintf(`{"mint":%q,"destination":%q,"amount":"1","decimals":6}`, dest, dest):                    "not_a_mint",
+		fmt.Sprintf(`{"mint":%q,"destination":%q,"amount":"0","decimals":6}`, mint, dest):                    "",
+		fmt.Sprintf(`{"mint":%q,"destination":"nope","amount":"1","decimals":6}`, mint):                      "",
+		fmt.Sprintf(`{"mint":%q,"destination":%q,"amount":"1","decimals":6,"cluster":"devnet"}`, mint, dest): "unknown_cluster",
+	} {
+		if w := transfer(body); w.Code != http.StatusBadRequest || !strings.Contains(w.Body.String(), code) {
+			t.Errorf("Expected %s refused w/ %q, got %d %s", body, code, w.Code, w.Body)
+		}
+	}
+
+	// the key's program allowlist still applies to built transactions
+	locked, _ := svc.GenerateKey(context.Background(), KeyGenRequest{Policy: &KeyPolicy{Usage: UsagePersistent, AllowedPrograms: []string{SystemProgramID.String()}}})
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/api/v1/keys/"+locked.PublicKey+"/transfers/token",
+		strings.NewReader(fmt.Sprintf(`{"mint":%q,"destination":%q,"amount":"1","decimals":6}`, mint, dest))))
+	if w.Code != http.StatusBadRequest || !strings.Contains(w.Body.String(), "policy_violation") {
+		t.Errorf("Expected the token program refused by policy, got %d %s", w.Code, w.Body)
+	}
+}
+
+func TestTokenAccount(t *testing.T) {
+	mint := MustSolanaPubkey("EPjFWdd5AufqSSqeM2qN1xzybapC8G4wEGGkZwyTDt1v")
+	rpc := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var call struct {
+			Method string `json:"method"`
+		}
+		json.NewDecoder(r.Body).Decode(&call)
+		switch call.Method {
+		case "getAccountInfo":
+			fmt.Fprintf(w, `{"jsonrpc":"2.0","id":1,"result":{"value":{"owner":%q,"data":{"parsed":{"type":"mint","info":{"decimals":2}}}}}}`, Token2022ProgramID)
+		case "getLatestBlockhash":
+			fmt.Fprint(w, `{"jsonrpc":"2.0","id":1,"result":{"context":{"slot":310},"value":{"blockhash":"EkSnNWid2cvwEVnVx9aBqawnmiCNiDgp3gUdkDPTKN1N","lastValidBlockHeight":450}}}`)
+		}
+	}))
+	defer rpc.Close()
+
+	svc := NewSignerService(NewSecureKeyStore())
+	svc.rpc = NewSolanaRPC(rpc.URL, 0)
+	svc.blockhashes = NewBlockhashCache(svc.rpc)
+	acc, _ := svc.GenerateKey(context.Background(), KeyGenRequest{Policy: &KeyPolicy{Usage: UsagePersistent}})
+	pub, _ := hex.DecodeString(acc.PublicKey)
+	payer := SolanaAddress(pub)
+	server := NewAPIServer(svc)
+	server.Builder = NewTxBuilder(svc)
+	handler := server.middleware(server.routes())
+	build := func(path, body string) *SolanaMessage {
+		t.Helper()
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/api/v1/keys/"+acc.PublicKey+path, strings.NewReader(body)))
+		var result TransactionResult
+		json.Unmarshal(w.Body.Bytes(), &result)
+		raw, _ := base64.StdEncoding.DecodeString(result.Transaction)
+		tx, err := ParseSolanaPayload(raw)
+		if w.Code != http.StatusOK || err != nil {
+			t.Fatalf("%s returned %d %s", path, w.Code, w.Body)
+		}
+		return tx.Message
+	}
+	// create checks the accounts it's given, these are what it must get
+	wantCreate := func(msg *SolanaMessage, ix SolanaCompiledInstruction, owner SolanaPubkey) {
+		t.Helper()
+		ata, _ := AssociatedTokenAddress(owner, mint, Token2022ProgramID)
+		want := []SolanaPubkey{payer, ata, owner, mint, SystemProgramID, Token2022ProgramID}
+		if msg.AccountKeys[ix.ProgramIDIndex] != AssociatedTokenProgramID || !bytes.Equal(ix.Data, []byte{ataCreateIdempotent}) || len(ix.Accounts) != len(want) {
+			t.Fatalf("Unexpected create instruction %+v", ix)
+		}
+		for i, a := range ix.Accounts {
+			if msg.AccountKeys[a] != want[i] {
+				t.Errorf("Create account %d is %s, want %s", i, msg.AccountKeys[a], want[i])
+			}
+		}
+	}
+
+	// the key's own account by default, or another wallet's paid by the key
+	msg := build("/accounts/token", fmt.Sprintf(`{"mint":%q}`, mint))
+	wantCreate(msg, msg.Instructions[0], payer)
+	other := MustSolanaPubkey("9WzDXwBbmkg8ZTbNMqUxvQRAyrZzDsGYdLVL9zYtAWWM")
+	msg = build("/accounts/token", fmt.Sprintf(`{"mint":%q,"owner":%q}`, mint, other))
+	wantCreate(msg, msg.Instructions[0], other)
+
+	// composed w/ a transfer, the account is created before it's credited
+	msg = build("/transfers/token", fmt.Sprintf(`{"mint":%q,"destination":%q,"amount":"100","decimals":2,"createDestination":true}`, mint, other))
+	if len(msg.Instructions) != 2 {
+		t.Fatalf("Expected create then transfer, got %d instructions", len(msg.Instructions))
+	}
+	wantCreate(msg, msg.Instructions[0], other)
+	if transfers, _ := DecodeSolanaTransfers(msg); len(transfers) != 1 || transfers[0].Instruction != 1 || transfers[0].Program != Token2022ProgramID {
+		t.Errorf("Unexpected transfer %+v", transfers)
+	}
+}
+
+func TestStakeBuilders(t *testing.T) {
+	rpc := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `{"jsonrpc":"2.0","id":1,"result":{"context":{"slot":310},"value":{"blockhash":"EkSnNWid2cvwEVnVx9aBqawnmiCNiDgp3gUdkDPTKN1N","lastValidBlockHeight":450}}}`)
+	}))
+	defer rpc.Close()
+
+	svc := NewSignerService(NewSecureKeyStore())
+	svc.rpc = NewSolanaRPC(rpc.URL, 0)
+	svc.blockhashes = NewBlockhashCache(svc.rpc)
+	validator := MustSolanaPubkey("9WzDXwBbmkg8ZTbNMqUxvQRAyrZzDsGYdLVL9zYtAWWM")
+	treasury := MustSolanaPubkey("EPjFWdd5AufqSSqeM2qN1xzybapC8G4wEGGkZwyTDt1v")
+	acc, _ := svc.GenerateKey(context.Background(), KeyGenRequest{Policy: &KeyPolicy{
+		Usage:   UsagePersistent,
+		Staking: &StakingPolicy{AllowedValidators: []string{validator.String()}},
+	}})
+	pub, _ := hex.DecodeString(acc.PublicKey)
+	staker := SolanaAddress(pub)
+	server := NewAPIServer(svc)
+	server.Builder = NewTxBuilder(svc)
+	handler := server.middleware(server.routes())
+	post := func(keyID, path, body string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/api/v1/keys/"+keyID+path, strings.NewReader(body)))
+		return w
+	}
+	message := func(w *httptest.ResponseRecorder) *SolanaMessage {
+		t.Helper()
+		var result TransactionResult
+		json.Unmarshal(w.Body.Bytes(), &result)
+		raw, _ := base64.StdEncoding.DecodeString(result.Transaction)
+		tx, err := ParseSolanaPayload(raw)
+		if w.Code != http.StatusOK || err != nil || !tx.FullySigned() {
+			t.Fatalf("Expected a signed transaction, got %d %s", w.Code, w.Body)
+		}
+		return tx.Message
+	}
+
+	// created, initialized and delegated in one transaction
+	w := post(acc.PublicKey, "/stakes", fmt.Sprintf(`{"seed":"stake-0","lamports":5000000000,"validator":%q}`, validator))
+	var created StakeCreateResult
+	json.Unmarshal(w.Body.Bytes(), &created)
+	stake, _ := CreateWithSeed(staker, "stake-0", StakeProgramID)
+	if created.StakeAccount != stake.String() {
+		t.Fatalf("Expected stake account %s, got %d %s", stake, w.Code, w.Body)
+	}
+	msg := message(w)
+	if len(msg.Instructions) != 3 || msg.AccountKeys[msg.Instructions[1].ProgramIDIndex] != StakeProgramID {
+		t.Fatalf("Expected create, initialize and delegate, got %+v", msg.Instructions)
+	}
+	transfers, _ := DecodeSolanaTransfers(msg)
+	if len(transfers) != 1 || transfers[0].Destination != stake || transfers[0].Amount != 5000000000+solanaRentExemptMinimum(stakeAccountSpace) {
+		t.Errorf("Expected the stake account funded, got %+v", transfers)
+	}
+
+	if w := post(acc.PublicKey, "/stakes/"+stake.String()+"/delegate", fmt.Sprintf(`{"validator":%q}`, treasury)); w.Code != http.StatusBadRequest || !strings.Contains(w.Body.String(), "not an allowed validator") {
+		t.Errorf("Expected a validator outside the policy refused, got %d %s", w.Code, w.Body)
+	}
+	if msg := message(post(acc.PublicKey, "/stakes/"+stake.String()+"/deactivate", "")); len(msg.Instructions) != 1 || !bytes.Equal(msg.Instructions[0].Data, []byte{stakeDeactivate, 0, 0, 0}) {
+		t.Errorf("Unexpected deactivate %+v", msg.Instructions)
+	}
+
+	// withdrawals are transfers to the withdrawer's destination
+	msg = message(post(acc.PublicKey, "/stakes/"+stake.String()+"/withdraw", fmt.Sprintf(`{"lamports":42,"destination":%q}`, treasury)))
+	if transfers, _ := DecodeSolanaTransfers(msg); len(transfers) != 1 || transfers[0].Source != stake || transfers[0].Destination != treasury ||
+		transfers[0].Authority != staker || transfers[0].Amount != 42 {
+		t.Errorf("Unexpected withdrawal %+v", transfers)
+	}
+	if spends, _ := SpendsFor(msg, staker, false); spends[assetLamports] != 42 {
+		t.Errorf("Expected the withdrawal counted as spend, got %v", spends)
+	}
+
+	// only keys whose policy allows staking
+	plain, _ := svc.GenerateKey(context.Background(), KeyGenRequest{Policy: &KeyPolicy{Usage: UsagePersistent}})
+	if w := post(plain.PublicKey, "/stakes", `{"seed":"stake-0","lamports":1}`); w.Code != http.StatusForbidden {
+		t.Errorf("Expected staking refused w/o a policy, got %d %s", w.Code, w.Body)
+	}
+	if w := post(acc.PublicKey, "/stakes", `{"seed":"this-seed-is-far-too-long-for-solana","lamports":1}`); w.Code != http.StatusBadRequest {
+		t.Errorf("Expected a long seed refused, got %d %s", w.Code, w.Body)
+	}
+}
+
+func TestMemos(t *testing.T) {
+	rpc := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var call struct {
+			Method string `json:"method"`
+		}
+		json.NewDecoder(r.Body).Decode(&call)
+		switch call.Method {
+		case "getAccountInfo":
+			fmt.Fprintf(w, `{"jsonrpc":"2.0","id":1,"result":{"value":{"owner":%q,"data":{"parsed":{"type":"mint","info":{"decimals":6}}}}}}`, TokenProgramID)
+		case "getLatestBlockhash":
+			fmt.Fprint(w, `{"jsonrpc":"2.0","id":1,"result":{"context":{"slot":310},"value":{"blockhash":"EkSnNWid2cvwEVnVx9aBqawnmiCNiDgp3gUdkDPTKN1N","lastValidBlockHeight":450}}}`)
+		}
+	}))
+	defer rpc.Close()
+
+	svc := NewSignerService(NewSecureKeyStore())
+	svc.rpc = NewSolanaRPC(rpc.URL, 0)
+	svc.blockhashes = NewBlockhashCache(svc.rpc)
+	svc.txs = NewTxTracker(nil)
+	acc, _ := svc.GenerateKey(context.Background(), KeyGenRequest{Policy: &KeyPolicy{Usage: UsagePersistent}})
+	pub, _ := hex.DecodeString(acc.PublicKey)
+	payer := SolanaAddress(pub)
+	server := NewAPIServer(svc)
+	server.Builder = NewTxBuilder(svc)
+	server.Txs = svc.txs
+	handler := server.middleware(server.routes())
+	do := func(method, path, body string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest(method, path, strings.NewReader(body)))
+		return w
+	}
+	mint := MustSolanaPubkey("EPjFWdd5AufqSSqeM2qN1xzybapC8G4wEGGkZwyTDt1v")
+	dest := MustSolanaPubkey("9WzDXwBbmkg8ZTbNMqUxvQRAyrZzDsGYdLVL9zYtAWWM")
+
+	// built w/ a memo the key signs, after the transfer
+	w := do(http.MethodPost, "/api/v1/keys/"+acc.PublicKey+"/transfers/token", fmt.Sprintf(`{"mint":%q,"destination":%q,"amount":"1","decimals":6,"memo":"INV-1001"}`, mint, dest))
+	var built TransactionResult
+	json.Unmarshal(w.Body.Bytes(), &built)
+	raw, _ := base64.StdEncoding.DecodeString(built.Transaction)
+	tx, err := ParseSolanaPayload(raw)
+	if w.Code != http.StatusOK || err != nil {
+		t.Fatalf("Token transfer returned %d %s", w.Code, w.Body)
+	}
+	memo := tx.Message.Instructions[1]
+	if memos := SolanaMemos(tx.Message); len(memos) != 1 || memos[0] != "INV-1001" || len(memo.Accounts) != 1 || tx.Message.AccountKeys[memo.Accounts[0]] != payer {
+		t.Errorf("Expected the key to sign the memo, got %v %+v", memos, memo)
+	}
+
+	// raw transactions' memos are recorded too, the old memo program's as well
+	msg, _ := CompileSolanaMessage(payer, SysvarClockID, []SolanaInstruction{
+		SystemTransferIx(payer, dest, 1),
+		{ProgramID: MemoV1ProgramID, Data: []byte("INV-1002")},
+	})
+	if _, err := svc.SignTransaction(context.Background(), TransactionRequest{KeyID: acc.PublicKey, UnsignedTxData: base64.StdEncoding.EncodeToString(msg), Context: SolanaTxContext}); err != nil {
+		t.Fatalf("SignTransaction failed: %v", err)
+	}
+
+	var history []TrackedTx
+	json.Unmarshal(do(http.MethodGet, "/api/v1/keys/"+acc.PublicKey+"/txs", "").Body.Bytes(), &history)
+	if len(history) != 2 || history[0].Memos[0] != "INV-1002" || history[1].Signature != built.TxSignature {
+		t.Errorf("Expected both transactions newest first, got %+v", history)
+	}
+	json.Unmarshal(do(http.MethodGet, "/api/v1/keys/"+acc.PublicKey+"/txs?memo=INV-1001", "").Body.Bytes(), &history)
+	if len(history) != 1 || history[0].Signature != built.TxSignature {
+		t.Errorf("Expected only INV-1001, got %+v", history)
+	}
+	if w := do(http.MethodGet, "/api/v1/keys/"+acc.PublicKey+"/txs?memo=INV-9", ""); strings.TrimSpace(w.Body.String()) != "[]" {
+		t.Errorf("Expected an empty history, got %s", w.Body)
+	}
+
+	long := strings.Repeat("x", maxMemoLen+1)
+	if w := do(http.MethodPost, "/api/v1/keys/"+acc.PublicKey+"/accounts/token", fmt.Sprintf(`{"mint":%q,"memo":%q}`, mint, long)); w.Code != http.StatusBadRequest {
+		t.Errorf("Expected a long memo refused, got %d %s", w.Code, w.Body)
+	}
+}
+
+func TestNonceAccounts(t *testing.T) {
+	nonce := MustSolanaPubkey("EkSnNWid2cvwEVnVx9aBqawnmiCNiDgp3gUdkDPTKN1N")
+	svc := NewSignerService(NewSecureKeyStore())
+	acc, _ := svc.GenerateKey(context.Background(), KeyGenRequest{Policy: &KeyPolicy{
+		Usage:        UsagePersistent,
+		PriorityFees: &PriorityFeePolicy{MaxMicroLamports: 1000},
+	}})
+	pub, _ := hex.DecodeString(acc.PublicKey)
+	key := SolanaAddress(pub)
+	account, _ := CreateWithSeed(key, "nonce-0", SystemProgramID)
+	stranger := MustSolanaPubkey("9WzDXwBbmkg8ZTbNMqUxvQRAyrZzDsGYdLVL9zYtAWWM")
+
+	rpc := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var call struct {
+			Method string `json:"method"`
+			Params []any  `json:"params"`
+		}
+		json.NewDecoder(r.Body).Decode(&call)
+		switch call.Method {
+		case "getAccountInfo":
+			authority := key
+			if call.Params[0] != account.String() {
+				authority = stranger
+			}
+			fmt.Fprintf(w, `{"jsonrpc":"2.0","id":1,"result":{"value":{"lamports":1447680,"data":{"program":"nonce","parsed":{"type":"initialized","info":{"authority":%q,"blockhash":%q,"feeCalculator":{"lamportsPerSignature":"5000"}}}}}}}`, authority, nonce)
+		case "getRecentPrioritizationFees":
+			fmt.Fprint(w, `{"jsonrpc":"2.0","id":1,"result":[{"slot":1,"prioritizationFee":100}]}`)
+		case "getLatestBlockhash":
+			fmt.Fprint(w, `{"jsonrpc":"2.0","id":1,"result":{"context":{"slot":310},"value":{"blockhash":"4uhcVJyU9pJkvQyS88uRDiswHXSCkY3zQawwpjk2NsNY","lastValidBlockHeight":450}}}`)
+		}
+	}))
+	defer rpc.Close()
+	svc.rpc = NewSolanaRPC(rpc.URL, 0)
+	svc.fees = NewFeeAdvisor(svc.rpc, 0)
+	svc.blockhashes = NewBlockhashCache(svc.rpc)
+	server := NewAPIServer(svc)
+	server.Builder = NewTxBuilder(svc)
+	handler := server.middleware(server.routes())
+	do := func(method, path, body string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest(method, "/api/v1/keys/"+acc.PublicKey+path, strings.NewReader(body)))
+		return w
+	}
+	message := func(res TransactionResult) *SolanaMessage {
+		t.Helper()
+		raw, _ := base64.StdEncoding.DecodeString(res.Transaction)
+		tx, err := ParseSolanaPayload(raw)
+		if err != nil || !tx.FullySigned() {
+			t.Fatalf("Expected a signed transaction, got %v", err)
+		}
+		return tx.Message
+	}
+	// the advance must come first, on the key's nonce account, signed by the key
+	wantAdvance := func(msg *SolanaMessage) {
+		t.Helper()
+		ix := msg.Instructions[0]
+		if !usesDurableNonce(msg) || msg.RecentBlockhash != nonce || len(ix.Accounts) != 3 ||
+			msg.AccountKeys[ix.Accounts[0]] != account || !msg.IsWritable(ix.Accounts[0]) ||
+			msg.AccountKeys[ix.Accounts[1]] != SysvarRecentBlockhashesID || msg.AccountKeys[ix.Accounts[2]] != key {
+			t.Fatalf("Unexpected nonce advance %+v in %+v", ix, msg)
+		}
+	}
+
+	w := do(http.MethodPost, "/nonces", `{"seed":"nonce-0"}`)
+	var created NonceCreateResult
+	json.Unmarshal(w.Body.Bytes(), &created)
+	if w.Code != http.StatusOK || created.NonceAccount != account.String() || len(message(created.TransactionResult).Instructions) != 2 {
+		t.Fatalf("Nonce create returned %d %s", w.Code, w.Body)
+	}
+	var state NonceAccount
+	if w := do(http.MethodGet, "/nonces/"+account.String(), ""); json.Unmarshal(w.Body.Bytes(), &state) != nil || state.Nonce != nonce.String() || state.LamportsPerSignature != 5000 {
+		t.Errorf("Nonce state returned %d %s", w.Code, w.Body)
+	}
+
+	// a raw transaction signed against the nonce, w/ a priority fee after the advance
+	msg, _ := CompileSolanaMessage(key, SysvarClockID, []SolanaInstruction{SystemTransferIx(key, stranger, 7)})
+	sign := func(req TransactionRequest) (TransactionResult, error) {
+		req.KeyID, req.Context = acc.PublicKey, SolanaTxContext
+		req.UnsignedTxData = base64.StdEncoding.EncodeToString(msg)
+		return svc.SignTransaction(context.Background(), req)
+	}
+	res, err := sign(TransactionRequest{NonceAccount: account.String(), PriorityFee: true})
+	if err != nil || res.Blockhash != nonce.String() {
+		t.Fatalf("Nonce sign = %+v, %v", res, err)
+	}
+	signed := message(res)
+	wantAdvance(signed)
+	if program := signed.AccountKeys[signed.Instructions[1].ProgramIDIndex]; program != ComputeBudgetProgramID {
+		t.Errorf("Expected the compute unit price after the advance, got %s", program)
+	}
+	if transfers, _ := DecodeSolanaTransfers(signed); len(transfers) != 1 || transfers[0].Destination != stranger || transfers[0].Amount != 7 {
+		t.Errorf("Expected the transfer intact, got %+v", transfers)
+	}
+
+	if _, err := sign(TransactionRequest{NonceAccount: account.String(), RefreshBlockhash: true}); err == nil {
+		t.Error("Expected refreshBlockhash w/ a nonce refused")
+	}
+	if _, err := sign(TransactionRequest{NonceAccount: stranger.String()}); !errors.Is(err, errNonceAuthority) {
+		t.Errorf("Expected someone else's nonce refused, got %v", err)
+	}
+
+	// a bare advance is signed against the nonce it retires
+	w = do(http.MethodPost, "/nonces/"+account.String()+"/advance", "")
+	var advanced TransactionResult
+	json.Unmarshal(w.Body.Bytes(), &advanced)
+	if w.Code != http.StatusOK {
+		t.Fatalf("Nonce advance returned %d %s", w.Code, w.Body)
+	}
+	if msg := message(advanced); len(msg.Instructions) != 1 {
+		t.Errorf("Expected only the advance, got %+v", msg.Instructions)
+	} else {
+		wantAdvance(msg)
+	}
+
+	w = do(http.MethodPost, "/nonces/"+account.String()+"/withdraw", `{"lamports":1447680}`)
+	var withdrawn TransactionResult
+	json.Unmarshal(w.Body.Bytes(), &withdrawn)
+	if transfers, _ := DecodeSolanaTransfers(message(withdrawn)); len(transfers) != 1 || transfers[0].Source != account || transfers[0].Destination != key || transfers[0].Authority != key {
+		t.Errorf("Unexpected withdrawal %+v", transfers)
+	}
+}
+
+func TestOutboundQueue(t *testing.T) {
+	svc := NewSignerService(NewSecureKeyStore())
+	// both transfers fit the limit exactly, a re-sign mustn't count again
+	acc, _ := svc.GenerateKey(context.Background(), KeyGenRequest{Policy: &KeyPolicy{
+		Usage:          UsagePersistent,
+		SpendingLimits: []SpendingLimit{{Window: "1h", Max: 3}},
+	}})
+	pub, _ := hex.DecodeString(acc.PublicKey)
+	key := SolanaAddress(pub)
+	to := MustSolanaPubkey("9WzDXwBbmkg8ZTbNMqUxvQRAyrZzDsGYdLVL9zYtAWWM")
+
+	var mu sync.Mutex
+	var sent []string
+	statuses := make(map[string]string)
+	blockhash, slot, valid := "4uhcVJyU9pJkvQyS88uRDiswHXSCkY3zQawwpjk2NsNY", 310, true
+	rpc := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var call struct {
+			Method string            `json:"method"`
+			Params []json.RawMessage `json:"params"`
+		}
+		json.NewDecoder(r.Body).Decode(&call)
+		mu.Lock()
+
+		defer mu.Unlock()
+
+		switch call.Method {
+		case "getLatestBlockhash":
+			fmt.Fprintf(w, `{"jsonrpc":"2.0","id":1,"result":{"context":{"slot":%d},"value":{"blockhash":%q,"lastValidBlockHeight":450}}}`, slot, blockhash)
+		case "sendTransaction":
+			var wire string
+			json.Unmarshal(call.Params[0], &wire)
+			sent = append(sent, wire)
+			fmt.Fprint(w, `{"jsonrpc":"2.0","id":1,"result":"sent"}`)
+		case "getSignatureStatuses":
+			var sigs []string
+			json.Unmarshal(call.Params[0], &sigs)
+			values := make([]string, len(sigs))
+			for i, sig := range sigs {
+				values[i] = "null"
+				if status, ok := statuses[sig]; ok {
+					values[i] = status
+				}
+			}
+			fmt.Fprintf(w, `{"jsonrpc":"2.0","id":1,"result":{"value":[%s]}}`, strings.Join(values, ","))
+		case "isBlockhashValid":
+			fmt.Fprintf(w, `{"jsonrpc":"2.0","id":1,"result":{"value":%t}}`, valid)
+		}
+	}))
+	defer rpc.Close()
+	svc.rpc = NewSolanaRPC(rpc.URL, 0)
+	svc.blockhashes = NewBlockhashCache(svc.rpc)
+	path := filepath.Join(t.TempDir(), "outbound.json")
+	queue, err := NewOutboundQueue(path, svc.rpc, svc.SignTransaction)
+	if err != nil {
+		t.Fatal(err)
+	}
+	svc.outbound = queue
+	svc.txs = NewTxTracker(svc.rpc)
+	queue.txs = svc.txs
+
+	sign := func(lamports uint64) TransactionResult {
+		t.Helper()
+		msg, _ := CompileSolanaMessage(key, SolanaPubkey{}, []SolanaInstruction{SystemTransferIx(key, to, lamports)})
+		res, err := svc.SignTransaction(context.Background(), TransactionRequest{
+			KeyID: acc.PublicKey, Context: SolanaTxContext, UnsignedTxData: base64.StdEncoding.EncodeToString(msg),
+			RefreshBlockhash: true, Queue: true,
+		})
+		if err != nil || res.QueueID == "" || res.BroadcastStatus != "Queued" {
+			t.Fatalf("Queued sign = %+v, %v", res, err)
+		}
+		return res
+	}
+	process := func() {
+		t.Helper()
+		if err := queue.Process(context.Background()); err != nil {
+			t.Fatalf("Process failed: %v", err)
+		}
+	}
+	wantSent := func(want ...string) {
+		t.Helper()
+		mu.Lock()
+
+		defer mu.Unlock()
+
+		if !slices.Equal(sent, want) {
+			t.Fatalf("Sent %d transactions, want %d in order", len(sent), len(want))
+		}
+	}
+	confirm := func(sig, status string) {
+		mu.Lock()
+		statuses[sig] = status
+		mu.Unlock()
+	}
+
+	first, second := sign(1), sign(2)
+	if q := queue.Queue(acc.PublicKey); len(q) != 2 || q[0].ID != first.QueueID || q[1].ID != second.QueueID {
+		t.Fatalf("Unexpected queue %+v", q)
+	}
+
+	// only the head goes out, and isn't sent again while it can still land
+	process()
+	process()
+	wantSent(first.Transaction)
+
+	// once it's confirmed the next follows in the same pass
+	confirm(first.TxSignature, `{"slot":311,"confirmations":1,"err":null,"confirmationStatus":"confirmed"}`)
+	process()
+	wantSent(first.Transaction, second.Transaction)
+	if tx, _ := svc.txs.Status(second.TxSignature); tx.Status != TxStatusBroadcast {
+		t.Errorf("Expected the tracker to see the send, got %s", tx.Status)
+	}
+
+	// a restart keeps the order and where each transaction is
+	reloaded, err := NewOutboundQueue(path, svc.rpc, svc.SignTransaction)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if q := reloaded.Queue(acc.PublicKey); len(q) != 1 || q[0].ID != second.QueueID || q[0].Status != QueueStatusSent || q[0].request.UnsignedTxData == "" {
+		t.Fatalf("Reloaded queue %+v", q)
+	}
+
+	// unseen long after its blockhash expired, re-signed w/ a fresh one
+	queue.mu.Lock()
+	stale := time.Now().Add(-2 * outboundResendAfter)
+	queue.queues[acc.PublicKey][0].SentAt = &stale
+	queue.mu.Unlock()
+	mu.Lock()
+	blockhash, slot, valid = "EkSnNWid2cvwEVnVx9aBqawnmiCNiDgp3gUdkDPTKN1N", 400, false
+	mu.Unlock()
+	svc.blockhashes.Refresh(context.Background())
+	process()
+	resigned := queue.Queue(acc.PublicKey)[0]
+	if resigned.Resigns != 1 || resigned.Status != QueueStatusSent || resigned.Signature == second.TxSignature || resigned.Blockhash != "EkSnNWid2cvwEVnVx9aBqawnmiCNiDgp3gUdkDPTKN1N" {
+		t.Fatalf("Expected a re-signed transaction, got %+v", resigned)
+	}
+	wantSent(first.Transaction, second.Transaction, resigned.Transaction)
+
+	// failing on chain halts the key's queue, nothing behind it goes out
+	confirm(resigned.Signature, `{"slot":401,"confirmations":1,"err":{"InstructionError":[0,"Custom"]},"confirmationStatus":"confirmed"}`)
+	process()
+	third := sign(0)
+	process()
+	if q := queue.Queue(acc.PublicKey); q[0].Status != QueueStatusHalted || q[0].Error == "" || q[1].ID != third.QueueID || q[1].Status != QueueStatusQueued {
+		t.Fatalf("Expected a halted queue, got %+v", q)
+	}
+	wantSent(first.Transaction, second.Transaction, resigned.Transaction)
+	if _, err := queue.Remove(acc.PublicKey, "missing"); !errors.Is(err, errQueueEntryNotFound) {
+		t.Errorf("Expected an unknown entry refused, got %v", err)
+	}
+
+	// an operator drops it and the queue moves again
+	if _, err := queue.Remove(acc.PublicKey, second.QueueID); err != nil {
+		t.Fatal(err)
+	}
+	process()
+	wantSent(first.Transaction, second.Transaction, resigned.Transaction, third.Transaction)
+	if _, err := queue.Remove(acc.PublicKey, third.QueueID); !errors.Is(err, errQueueEntryState) {
+		t.Errorf("Expected a sent transaction kept, got %v", err)
+	}
+
+	msg, _ := CompileSolanaMessage(key, SolanaPubkey{}, []SolanaInstruction{SystemTransferIx(key, to, 1)})
+	if _, err := svc.SignTransaction(context.Background(), TransactionRequest{KeyID: acc.PublicKey, Context: SolanaTxContext, UnsignedTxData: base64.StdEncoding.EncodeToString(msg), RefreshBlockhash: true, Queue: true, Broadcast: true}); err == nil {
+		t.Error("Expected broadcast w/ queue refused")
+	}
+
+	server := NewAPIServer(svc)
+	server.Outbound = queue
+	w := httptest.NewRecorder()
+	server.middleware(server.routes()).ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/api/v1/keys/"+acc.PublicKey+"/queue", nil))
+	var listed []OutboundTx
+	if err := json.Unmarshal(w.Body.Bytes(), &listed); err != nil || len(listed) != 1 || listed[0].ID != third.QueueID || strings.Contains(w.Body.String(), "unsignedTxData") {
+		t.Errorf("Queue endpoint returned %d %s", w.Code, w.Body)
+	}
+}
+
+func TestTxWebhook(t *testing.T) {
+	hooks := make(chan Notification, 8)
+	webhook := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		mac := hmac.New(sha256.New, []byte("hook-secret"))
+		mac.Write(body)
+		if r.Header.Get("X-STS-Signature") != "sha256="+hex.EncodeToString(mac.Sum(nil)) {
+			t.Error("Webhook body not signed w/ the secret")
+		}
+		var n Notification
+		json.Unmarshal(body, &n)
+		hooks <- n
+	}))
+	defer webhook.Close()
+
+	var mu sync.Mutex
+	statuses := make(map[string]string)
+	rpc := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var call struct {
+			Method string            `json:"method"`
+			Params []json.RawMessage `json:"params"`
+		}
+		json.NewDecoder(r.Body).Decode(&call)
+		switch call.Method {
+		case "getLatestBlockhash":
+			fmt.Fprint(w, `{"jsonrpc":"2.0","id":1,"result":{"context":{"slot":310},"value":{"blockhash":"4uhcVJyU9pJkvQyS88uRDiswHXSCkY3zQawwpjk2NsNY","lastValidBlockHeight":450}}}`)
+		case "getSignatureStatuses":
+			var sigs []string
+			json.Unmarshal(call.Params[0], &sigs)
+			values := make([]string, len(sigs))
+			mu.Lock()
+			for i, sig := range sigs {
+				values[i] = "null"
+				if status, ok := statuses[sig]; ok {
+					values[i] = status
+				}
+			}
+			mu.Unlock()
+			fmt.Fprintf(w, `{"jsonrpc":"2.0","id":1,"result":{"value":[%s]}}`, strings.Join(values, ","))
+		}
+	}))
+	defer rpc.Close()
+
+	svc := NewSignerService(NewSecureKeyStore())
+	acc, _ := svc.GenerateKey(context.Background(), KeyGenRequest{Policy: &KeyPolicy{Usage: UsagePersistent}})
+	pub, _ := hex.DecodeString(acc.PublicKey)
+	key := SolanaAddress(pub)
+	svc.rpc = NewSolanaRPC(rpc.URL, 0)
+	svc.blockhashes = NewBlockhashCache(svc.rpc)
+	svc.txs = NewTxTracker(svc.rpc)
+	sign := func(lamports uint64, commitment string) (TransactionResult, error) {
+		msg, _ := CompileSolanaMessage(key, SolanaPubkey{}, []SolanaInstruction{SystemTransferIx(key, SysvarClockID, lamports)})
+		return svc.SignTransaction(context.Background(), TransactionRequest{
+			KeyID: acc.PublicKey, Context: SolanaTxContext, UnsignedTxData: base64.StdEncoding.EncodeToString(msg),
+			RefreshBlockhash: true, NotifyCommitment: commitment,
+		})
+	}
+	set := func(sig, status string) {
+		mu.Lock()
+		statuses[sig] = status
+		mu.Unlock()
+		if err := svc.txs.Poll(context.Background()); err != nil {
+			t.Fatalf("Poll failed: %v", err)
+		}
+	}
+	next := func() Notification {
+		t.Helper()
+		select {
+		case n := <-hooks:
+			return n
+		case <-time.After(2 * time.Second):
+			t.Fatal("Expected a webhook")
+			return Notification{}
+		}
+	}
+	none := func() {
+		t.Helper()
+		select {
+		case n := <-hooks:
+			t.Fatalf("Unexpected webhook %+v", n)
+		case <-time.After(100 * time.Millisecond):
+		}
+	}
+
+	if _, err := sign(1, TxStatusConfirmed); !errors.Is(err, errNoTxWebhook) {
+		t.Fatalf("Expected a webhook request refused w/o one configured, got %v", err)
+	}
+	svc.txs.webhook = NewNotificationDispatcher()
+	svc.txs.webhook.Add(&WebhookNotifier{URL: webhook.URL, Secret: "hook-secret"}, SeverityInfo)
+	if _, err := sign(1, "landed"); err == nil {
+		t.Error("Expected an unknown commitment refused")
+	}
+
+	confirmed, err := sign(1, TxStatusConfirmed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	failed, _ := sign(2, TxStatusFinalized)
+
+	// processed isn't far enough
+	set(confirmed.TxSignature, `{"slot":311,"confirmations":0,"err":null,"confirmationStatus":"processed"}`)
+	none()
+
+	set(confirmed.TxSignature, `{"slot":311,"confirmations":4,"err":null,"confirmationStatus":"confirmed"}`)
+	n := next()
+	if n.Kind != NotifyTxStatus || n.KeyID != acc.PublicKey || n.Severity != SeverityInfo || n.Details["signature"] != confirmed.TxSignature ||
+		n.Details["status"] != TxStatusConfirmed || n.Details["slot"] != "311" || n.Details["confirmations"] != "4" {
+		t.Errorf("Unexpected confirmation webhook %+v", n)
+	}
+
+	// failing short of the commitment is reported w/ the cluster's error
+	set(failed.TxSignature, `{"slot":312,"confirmations":1,"err":{"InstructionError":[0,{"Custom":1}]},"confirmationStatus":"confirmed"}`)
+	n = next()
+	if n.Severity != SeverityWarning || n.Details["signature"] != failed.TxSignature || n.Details["status"] != TxStatusFailed || n.Details["err"] != `{"InstructionError":[0,{"Custom":1}]}` {
+		t.Errorf("Unexpected failure webhook %+v", n)
+	}
+
+	// told once
+	set(confirmed.TxSignature, `{"slot":311,"confirmations":null,"err":null,"confirmationStatus":"finalized"}`)
+	none()
+	if tx, _ := svc.txs.Status(confirmed.TxSignature); tx.NotifiedAt == nil || tx.NotifyCommitment != TxStatusConfirmed {
+		t.Errorf("Expected the record to show the webhook went out, got %+v", tx)
+	}
+}
+
+func TestFeePayer(t *testing.T) {
+	svc := NewSignerService(NewSecureKeyStore())
+	user, _ := svc.GenerateKey(context.Background(), KeyGenRequest{Policy: &KeyPolicy{Usage: UsagePersistent}})
+	userPub, _ := hex.DecodeString(user.PublicKey)
+	userAddr := SolanaAddress(userPub)
+	to := MustSolanaPubkey("9WzDXwBbmkg8ZTbNMqUxvQRAyrZzDsGYdLVL9zYtAWWM")
+	// two signatures a transaction, room for one fee
+	sponsor, _ := svc.GenerateKey(context.Background(), KeyGenRequest{Policy: &KeyPolicy{
+		Usage:          UsagePersistent,
+		SpendingLimits: []SpendingLimit{{Window: "1h", Max: 15000}},
+	}})
+	limited, _ := svc.GenerateKey(context.Background(), KeyGenRequest{Policy: &KeyPolicy{Usage: UsageMaxUses, MaxUses: 10}})
+	unbounded, _ := svc.GenerateKey(context.Background(), KeyGenRequest{Policy: &KeyPolicy{Usage: UsagePersistent}})
+
+	sign := func(payer SolanaPubkey, ixs ...SolanaInstruction) (TransactionResult, error) {
+		msg, _ := CompileSolanaMessage(payer, SysvarClockID, ixs)
+		return svc.SignTransaction(context.Background(), TransactionRequest{
+			KeyID: user.PublicKey, Context: SolanaTxContext, UnsignedTxData: base64.StdEncoding.EncodeToString(msg), FeePayer: true,
+		})
+	}
+	if _, err := sign(userAddr, SystemTransferIx(userAddr, to, 1)); !errors.Is(err, errNoFeePayer) {
+		t.Fatalf("Expected feePayer refused w/o a fee payer, got %v", err)
+	}
+	if _, err := svc.SetFeePayer(limited.PublicKey); err == nil {
+		t.Error("Expected a key w/ limited uses refused as fee payer")
+	}
+	if _, err := svc.SetFeePayer(unbounded.PublicKey); err == nil {
+		t.Error("Expected a key w/o a lamport spending limit refused as fee payer")
+	}
+	payer, err := svc.SetFeePayer(sponsor.PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	res, err := sign(payer, SystemTransferIx(userAddr, to, 1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	raw, _ := base64.StdEncoding.DecodeString(res.Transaction)
+	tx, _ := ParseSolanaPayload(raw)
+	if !tx.FullySigned() || res.TxSignature != base58Encode(tx.Signatures[0]) ||
+		!ed25519.Verify(ed25519.PublicKey(payer[:]), tx.MsgBytes, tx.Signatures[0]) || !ed25519.Verify(userPub, tx.MsgBytes, tx.Signatures[1]) {
+		t.Fatalf("Expected the fee payer's signature first and the key's second, got %+v", res)
+	}
+
+	if _, err := sign(userAddr, SystemTransferIx(userAddr, to, 1)); !errors.Is(err, errFeePayerMismatch) {
+		t.Errorf("Expected a transaction paid by someone else refused, got %v", err)
+	}
+	if _, err := sign(payer, SystemTransferIx(payer, to, 1), SystemTransferIx(userAddr, to, 1)); !errors.Is(err, errPolicyViolation) {
+		t.Errorf("Expected the fee payer's funds kept out of instructions, got %v", err)
+	}
+	// the second fee goes over the fee payer's own limit
+	if _, err := sign(payer, SystemTransferIx(userAddr, to, 1)); !errors.Is(err, errSpendingLimit) {
+		t.Errorf("Expected the fee payer's spending limit to hold, got %v", err)
+	}
+
+	// base fee per signature plus the priority fee at the unit limit, rounded up
+	budget := func(tag byte, v uint64, size int) SolanaInstruction {
+		data := binary.LittleEndian.AppendUint64([]byte{tag}, v)
+		return SolanaInstruction{ProgramID: ComputeBudgetProgramID, Data: data[:size]}
+	}
+	msg, _ := CompileSolanaMessage(payer, SysvarClockID, []SolanaInstruction{
+		budget(computeBudgetSetUnitLimit, 100_000, 5), budget(computeBudgetSetUnitPrice, 1001, 9), SystemTransferIx(userAddr, to, 1),
+	})
+	parsed, _ := ParseSolanaMessage(msg)
+	if fee := TransactionFee(parsed); fee != 2*lamportsPerSignature+101 {
+		t.Errorf("TransactionFee = %d", fee)
+	}
+
+	// a compute unit price the client set itself is held to the fee payer's
+	// fee policy, w/o one it pays none
+	if _, err := sign(payer, budget(computeBudgetSetUnitLimit, 1_400_000, 5), budget(computeBudgetSetUnitPrice, 1<<40, 9), SystemTransferIx(userAddr, to, 1)); !errors.Is(err, errPolicyViolation) {
+		t.Errorf("Expected a client set compute unit price refused, got %v", err)
+	}
+	if _, err := checkFeePayerTx(parsed, payer, &PriorityFeePolicy{MaxMicroLamports: 1000}); !errors.Is(err, errPolicyViolation) {
+		t.Errorf("Expected a price over the fee payer's cap refused, got %v", err)
+	}
+	if fee, err := checkFeePayerTx(parsed, payer, &PriorityFeePolicy{MaxMicroLamports: 1001}); err != nil || fee != 2*lamportsPerSignature+101 {
+		t.Errorf("Expected a price at the cap allowed, got %d %v", fee, err)
+	}
+
+	signReq, err := NewTxBuilder(svc).request(user.PublicKey, userAddr, []SolanaInstruction{SystemTransferIx(userAddr, to, 1)}, BuildOptions{FeePayer: true, Memo: "invoice-7"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	raw, _ = base64.StdEncoding.DecodeString(signReq.UnsignedTxData)
+	if built, _ := ParseSolanaMessage(raw); !signReq.FeePayer || built.AccountKeys[0] != payer || built.NumRequiredSignatures != 2 {
+		t.Errorf("Expected the builder to name the fee payer, got %+v", built)
+	}
+
+	server := NewAPIServer(svc)
+	server.FeePayer = payer.String()
+	w := httptest.NewRecorder()
+	server.middleware(server.routes()).ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/api/v1/fee-payer", nil))
+	var info FeePayerInfo
+	if json.Unmarshal(w.Body.Bytes(), &info); info.Address != payer.String() {
+		t.Errorf("Fee payer endpoint returned %d %s", w.Code, w.Body)
+	}
+}
@@ -0,0 +1,188 @@
+package main
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// metered operations, what usage is billed by
+const (
+	MeterKeyGenerate = "key.generate"
+	MeterSign        = "sign"
+	MeterVerify      = "verify"
+	MeterKeyDestroy  = "key.destroy"
+)
+
+// usage is counted per hour and kept this long, export it before then
+const usageRetention = 90 * 24 * time.Hour
+
+// UsageRecord is how often one tenant and API key ran an operation in a period
+type UsageRecord struct {
+	Period    time.Time `json:"period"`
+	Tenant    string    `json:"tenant"`
+	APIKey    string    `json:"apiKey,omitempty"`
+	Operation string    `json:"operation"`
+	Count     int64     `json:"count"`
+}
+
+// UsageQuery picks the records to export, zero fields match everything
+type UsageQuery struct {
+	From   time.Time
+	To     time.Time
+	Tenant string
+	APIKey string
+
+	// hour or day, periods are in UTC
+	Granularity time.Duration
+}
+
+type usageKey struct {
+	tenant, apiKey, operation string
+}
+
+// UsageMeter counts operations per tenant and API token by hour, so
+// chargeback or customer billing can be built on the export. Unlike the
+// CostLedger it keeps when things happened. Methods are nil safe.
+type UsageMeter struct {
+	hours map[time.Time]map[usageKey]int64
+
+	mu sync.Mutex
+}
+
+// constructor
+func NewUsageMeter() *UsageMeter {
+	return &UsageMeter{hours: make(map[time.Time]map[usageKey]int64)}
+}
+
+// Record counts op for the context's tenant and API token
+func (m *UsageMeter) Record(ctx context.Context, op string) {
+	if m == nil {
+		return
+	}
+	m.mu.Lock()
+
+	defer m.mu.Unlock()
+
+	now := time.Now().UTC()
+	hour := now.Truncate(time.Hour)
+	counts, ok := m.hours[hour]
+	if !ok {
+		counts = make(map[usageKey]int64)
+		m.hours[hour] = counts
+
+		// a new hour is a good time to forget old ones
+		for h := range m.hours {
+			if now.Sub(h) > usageRetention {
+				delete(m.hours, h)
+			}
+		}
+	}
+	counts[usageKey{tenant: TenantFromContext(ctx), apiKey: APITokenFromContext(ctx), operation: op}]++
+}
+
+// Export sums the matching counts per period, oldest first
+func (m *UsageMeter) Export(q UsageQuery) []UsageRecord {
+	records := []UsageRecord{}
+	if m == nil {
+		return records
+	}
+	if q.Granularity <= 0 {
+		q.Granularity = time.Hour
+	}
+
+	sums := map[UsageRecord]int64{}
+	m.mu.Lock()
+	for hour, counts := range m.hours {
+		if !q.From.IsZero() && hour.Before(q.From) || !q.To.IsZero() && !hour.Before(q.To) {
+			continue
+		}
+		for k, n := range counts {
+			if q.Tenant != "" && k.tenant != q.Tenant || q.APIKey != "" && k.apiKey != q.APIKey {
+				continue
+			}
+			sums[UsageRecord{Period: hour.Truncate(q.Granularity), Tenant: k.tenant, APIKey: k.apiKey, Operation: k.operation}] += n
+		}
+	}
+	m.mu.Unlock()
+
+	for rec, n := range sums {
+		rec.Count = n
+		records = append(records, rec)
+	}
+	sort.Slice(records, func(i, j int) bool {
+		a, b := records[i], records[j]
+		if !a.Period.Equal(b.Period) {
+			return a.Period.Before(b.Period)
+		}
+		if a.Tenant != b.Tenant {
+			return a.Tenant < b.Tenant
+		}
+		if a.APIKey != b.APIKey {
+			return a.APIKey < b.APIKey
+		}
+		return a.Operation < b.Operation
+	})
+	return records
+}
+
+func writeUsageCSV(w io.Writer, records []UsageRecord) error {
+	out := csv.NewWriter(w)
+	out.Write([]string{"period", "tenant", "api_key", "operation", "count"})
+	for _, rec := range records {
+		out.Write([]string{rec.Period.Format(time.RFC3339), rec.Tenant, rec.APIKey, rec.Operation, strconv.FormatInt(rec.Count, 10)})
+	}
+	out.Flush()
+	return out.Error()
+}
+
+// usage periods by name
+var usageGranularities = map[string]time.Duration{"": time.Hour, "hour": time.Hour, "day": 24 * time.Hour}
+
+// handleUsageExport serves ?from=&to= (RFC 3339 or dates, to exclusive),
+// ?tenant=, ?apiKey= and ?granularity=hour|day as JSON, or CSV w/ ?format=csv
+func (s *APIServer) handleUsageExport(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	q := UsageQuery{Tenant: query.Get("tenant"), APIKey: query.Get("apiKey")}
+
+	var ok bool
+	if q.Granularity, ok = usageGranularities[query.Get("granularity")]; !ok {
+		writeError(w, r, http.StatusBadRequest, errors.New("granularity must be hour or day"))
+		return
+	}
+	for name, dst := range map[string]*time.Time{"from": &q.From, "to": &q.To} {
+		raw := query.Get(name)
+		if raw == "" {
+			continue
+		}
+		t, err := time.Parse(time.RFC3339, raw)
+		if err != nil {
+			if t, err = time.Parse(complianceDate, raw); err != nil {
+				writeError(w, r, http.StatusBadRequest, fmt.Errorf("%s must be RFC 3339 or a date like 2006-01-02", name))
+				return
+			}
+		}
+		*dst = t.UTC()
+	}
+
+	records := s.Meter.Export(q)
+	switch query.Get("format") {
+	case "", "json":
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(records)
+	case "csv":
+		w.Header().Set("Content-Type", "text/csv")
+		w.Header().Set("Content-Disposition", `attachment; filename="usage.csv"`)
+		writeUsageCSV(w, records)
+	default:
+		writeError(w, r, http.StatusBadRequest, errors.New("format must be json or csv"))
+	}
+}
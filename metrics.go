@@ -0,0 +1,286 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+	"unicode/utf8"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// Metrics holds the service's Prometheus collectors on their own registry.
+// Labels never carry key IDs, they would leak and grow w/o bound. Tenants
+// are labelled, capped by the tenant label guard.
+type Metrics struct {
+	registry *prometheus.Registry
+	tenants  *tenantLabels
+
+	requests *prometheus.CounterVec
+	latency  *prometheus.HistogramVec
+
+	signs         *prometheus.CounterVec
+	signLatency   *prometheus.HistogramVec
+	keysGenerated *prometheus.CounterVec
+
+	panics *prometheus.CounterVec
+
+	backups           *prometheus.CounterVec
+	backupLastSuccess prometheus.Gauge
+	backupSize        prometheus.Gauge
+}
+
+// constructor, store may be nil
+func NewMetrics(store *SecureKeyStore) *Metrics {
+	m := &Metrics{
+		registry: prometheus.NewRegistry(),
+		tenants:  newTenantLabels(nil, defaultMetricsTenants),
+		requests: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "sts_http_requests_total",
+			Help: "HTTP requests by route, status code and tenant.",
+		}, []string{"method", "route", "code", "tenant"}),
+		latency: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "sts_http_request_duration_seconds",
+			Help:    "HTTP request latency by route.",
+			Buckets: prometheus.DefBuckets,
+		}, []string{"method", "route"}),
+		signs: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "sts_sign_requests_total",
+			Help: "Sign requests by key type, outcome and tenant, failures by their error code.",
+		}, []string{"key_type", "outcome", "tenant"}),
+		signLatency: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "sts_sign_duration_seconds",
+			Help:    "Time spent in the signer per request.",
+			Buckets: []float64{.0005, .001, .0025, .005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5},
+		}, []string{"key_type"}),
+		keysGenerated: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "sts_keys_generated_total",
+			Help: "Keys generated by key type and tenant.",
+		}, []string{"key_type", "tenant"}),
+		panics: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "sts_panics_total",
+			Help: "Panics recovered by component, any increase is a bug.",
+		}, []string{"component"}),
+		backups: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "sts_backups_total",
+			Help: "Key store backups by outcome, success or failure.",
+		}, []string{"outcome"}),
+		backupLastSuccess: prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: "sts_backup_last_success_timestamp_seconds",
+			Help: "When the last key store backup was written, alert when it gets old.",
+		}),
+		backupSize: prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: "sts_backup_size_bytes",
+			Help: "Size of the last key store backup written.",
+		}),
+	}
+
+	m.registry.MustRegister(
+		m.requests, m.latency, m.signs, m.signLatency, m.keysGenerated,
+		m.panics, m.backups, m.backupLastSuccess, m.backupSize,
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+	)
+	m.registry.MustRegister(prometheus.NewCounterFunc(prometheus.CounterOpts{
+		Name: "sts_metrics_tenant_overflow_total",
+		Help: "Observations labelled tenant=\"other\" because the tenant label cap was reached.",
+	}, func() float64 { return float64(m.tenants.overflow.Load()) }))
+	if store != nil {
+		m.registry.MustRegister(prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+			Name: "sts_keystore_keys",
+			Help: "Keys currently held in the key store.",
+		}, func() float64 { return float64(store.Len()) }))
+	}
+	return m
+}
+
+// LabelTenants sets which tenants get their own series: the allowlist if
+// one is given, otherwise the first max seen. Call it before serving.
+func (m *Metrics) LabelTenants(allow []string, max int) {
+	if m == nil {
+		return
+	}
+	m.tenants = newTenantLabels(allow, max)
+}
+
+// ObserveSign records a finished sign request, nil safe
+func (m *Metrics) ObserveSign(ctx context.Context, keyType string, result TransactionResult, err error, took time.Duration) {
+	if m == nil {
+		return
+	}
+
+	m.signs.WithLabelValues(keyType, signOutcome(result, err), m.tenants.label(TenantFromContext(ctx))).Inc()
+	m.signLatency.WithLabelValues(keyType).Observe(took.Seconds())
+}
+
+// signOutcome names how a sign request ended, failures by their error code
+func signOutcome(result TransactionResult, err error) string {
+	switch {
+	case err != nil:
+		return errorCode(err, 0)
+	case result.ApprovalID != "" && result.Signature == "":
+		return "pending_approval"
+	}
+	return "signed"
+}
+
+// KeyGenerated counts a new key, nil safe
+func (m *Metrics) KeyGenerated(ctx context.Context, keyType string) {
+	if m == nil {
+		return
+	}
+	m.keysGenerated.WithLabelValues(keyType, m.tenants.label(TenantFromContext(ctx))).Inc()
+}
+
+// Panicked counts a recovered panic, nil safe
+func (m *Metrics) Panicked(component string) {
+	if m == nil {
+		return
+	}
+	m.panics.WithLabelValues(component).Inc()
+}
+
+// BackupFinished records a backup's outcome, nil safe
+func (m *Metrics) BackupFinished(at time.Time, size int, err error) {
+	if m == nil {
+		return
+	}
+	if err != nil {
+		m.backups.WithLabelValues("failure").Inc()
+		return
+	}
+	m.backups.WithLabelValues("success").Inc()
+	m.backupLastSuccess.Set(float64(at.Unix()))
+	m.backupSize.Set(float64(size))
+}
+
+// the tenant header isn't authenticated when requests are counted, so
+// anyone could mint series w/o a cap
+const (
+	defaultMetricsTenants = 100
+	maxTenantLabelLen     = 64
+	otherTenant           = "other"
+)
+
+// tenantLabels keeps the tenant label's cardinality bounded. Tenants past
+// the cap, or not on the allowlist, are all counted as "other".
+type tenantLabels struct {
+	allowed map[string]bool
+	seen    map[string]bool
+	max     int
+
+	// observations that fell to "other" because of the cap
+	overflow atomic.Int64
+
+	mu sync.Mutex
+}
+
+// constructor, an empty allow admits the first max tenants seen
+func newTenantLabels(allow []string, max int) *tenantLabels {
+	t := &tenantLabels{seen: make(map[string]bool), max: max}
+	if len(allow) > 0 {
+		t.allowed = make(map[string]bool, len(allow))
+		for _, tenant := range allow {
+			t.allowed[strings.TrimSpace(tenant)] = true
+		}
+	}
+	return t
+}
+
+func (t *tenantLabels) label(tenant string) string {
+	if len(tenant) > maxTenantLabelLen || !utf8.ValidString(tenant) {
+		return otherTenant
+	}
+	if t.allowed != nil {
+		if t.allowed[tenant] || tenant == defaultTenant {
+			return tenant
+		}
+		return otherTenant
+	}
+
+	t.mu.Lock()
+
+	defer t.mu.Unlock()
+
+	if !t.seen[tenant] {
+		if len(t.seen) >= t.max {
+			t.overflow.Add(1)
+			return otherTenant
+		}
+		t.seen[tenant] = true
+	}
+	return tenant
+}
+
+// Handler serves the registry in the Prometheus text format
+func (m *Metrics) Handler() http.Handler {
+	return promhttp.HandlerFor(m.registry, promhttp.HandlerOpts{})
+}
+
+// withMetrics counts and times requests, labelled by the router pattern
+// they match so paths w/ IDs don't each get a series
+func (s *APIServer) withMetrics(router *http.ServeMux, next http.Handler) http.Handler {
+	if s.Metrics == nil {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, route := router.Handler(r)
+		if route == "" || route == unmatchedPattern {
+			route = "unmatched"
+		}
+
+		start := time.Now()
+		rec := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(rec, r)
+
+		// the tenant isn't proven out here, the header only labels the series
+		tenant := s.Metrics.tenants.label(TenantFromContext(WithTenant(r.Context(), r.Header.Get("X-Tenant-ID"))))
+		s.Metrics.requests.WithLabelValues(r.Method, route, strconv.Itoa(rec.status), tenant).Inc()
+		s.Metrics.latency.WithLabelValues(r.Method, route).Observe(time.Since(start).Seconds())
+	})
+}
+
+// statusRecorder remembers the status and size written, hijacking still works for
+// the event stream's WebSocket
+type statusRecorder struct {
+	http.ResponseWriter
+
+	status      int
+	wroteHeader bool
+	bytes       int
+}
+
+func (s *statusRecorder) WriteHeader(status int) {
+	if !s.wroteHeader {
+		s.status = status
+		s.wroteHeader = true
+	}
+	s.ResponseWriter.WriteHeader(status)
+}
+
+func (s *statusRecorder) Write(b []byte) (int, error) {
+	s.wroteHeader = true
+	n, err := s.ResponseWriter.Write(b)
+	s.bytes += n
+	return n, err
+}
+
+func (s *statusRecorder) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	h, ok := s.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, errors.New("response writer can't be hijacked")
+	}
+	s.status = http.StatusSwitchingProtocols
+	return h.Hijack()
+}
+
+func (s *statusRecorder) Unwrap() http.ResponseWriter { return s.ResponseWriter }
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+type principalCtxKey struct{}
+
+// WithPrincipal attaches the mTLS client principal to the context
+func WithPrincipal(ctx context.Context, principal string) context.Context {
+	return context.WithValue(ctx, principalCtxKey{}, principal)
+}
+
+// PrincipalFromContext returns the client principal, empty w/o a client certificate
+func PrincipalFromContext(ctx context.Context) string {
+	principal, _ := ctx.Value(principalCtxKey{}).(string)
+	return principal
+}
+
+// PrincipalMap maps certificate identities (CN, DNS, email or URI SAN) to
+// principal names
+type PrincipalMap map[string]string
+
+// ParsePrincipalMap reads comma separated identity=principal entries
+func ParsePrincipalMap(raw string) (PrincipalMap, error) {
+	m := PrincipalMap{}
+	for _, pair := range strings.Split(raw, ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+		identity, principal, ok := strings.Cut(pair, "=")
+		if !ok || identity == "" || principal == "" {
+			return nil, fmt.Errorf("invalid principal mapping %q, expected identity=principal", pair)
+		}
+		m[identity] = principal
+	}
+	return m, nil
+}
+
+// Resolve returns the principal for the first mapped identity, or the first
+// identity itself when none are mapped
+func (m PrincipalMap) Resolve(identities []string) string {
+	for _, id := range identities {
+		if principal, ok := m[id]; ok {
+			return principal
+		}
+	}
+	if len(identities) > 0 {
+		return identities[0]
+	}
+	return ""
+}
+
+// withPrincipal tags requests made w/ a verified client certificate
+func (s *APIServer) withPrincipal(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if principal := s.Principals.Resolve(clientIdentities(r)); principal != "" {
+			r = r.WithContext(WithPrincipal(r.Context(), principal))
+		}
+		next.ServeHTTP(w, r)
+	})
+}
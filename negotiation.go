@@ -0,0 +1,119 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+var (
+	errNoRoute          = errors.New("no route for this path")
+	errMethodNotAllowed = errors.New("method not allowed")
+	errNotAcceptable    = errors.New("the response can't be sent in an acceptable media type")
+)
+
+// catch-all pattern, requests no route claims end up here
+const unmatchedPattern = "/"
+
+// methods tried when working out a path's Allow header
+var routeMethods = []string{http.MethodGet, http.MethodHead, http.MethodPost, http.MethodPut, http.MethodPatch, http.MethodDelete}
+
+// routes that answer w/ something other than JSON, keyed by path
+var routeMediaTypes = map[string]string{
+	"/":            "text/plain",
+	"/api/v1/docs": "text/html",
+}
+
+// handleUnmatched answers 405 w/ the allowed methods when the path has
+// routes, 404 otherwise, instead of the mux's plaintext errors
+func (s *APIServer) handleUnmatched(mux *http.ServeMux) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		allowed := allowedMethods(mux, r)
+		if len(allowed) == 0 {
+			writeError(w, r, http.StatusNotFound, fmt.Errorf("%w: %s", errNoRoute, r.URL.Path))
+			return
+		}
+
+		allow := strings.Join(allowed, ", ")
+		w.Header().Set("Allow", allow)
+		writeError(w, r, http.StatusMethodNotAllowed, withDetails(
+			fmt.Errorf("%w: %s %s, allowed: %s", errMethodNotAllowed, r.Method, r.URL.Path, allow),
+			map[string]string{"allow": allow}))
+	}
+}
+
+// allowedMethods lists the methods some route serves r's path w/
+func allowedMethods(mux *http.ServeMux, r *http.Request) []string {
+	var allowed []string
+	for _, method := range routeMethods {
+		probe := *r
+		probe.Method = method
+		if _, pattern := mux.Handler(&probe); pattern != "" && pattern != unmatchedPattern {
+			allowed = append(allowed, method)
+		}
+	}
+	return allowed
+}
+
+// mediaType is what the route at path responds w/
+func mediaType(path string) string {
+	if t, ok := routeMediaTypes[path]; ok {
+		return t
+	}
+	return "application/json"
+}
+
+// withNegotiation refuses requests whose Accept header rules out what the
+// route responds w/. Problem details count as JSON.
+func withNegotiation(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		produces := mediaType(r.URL.Path)
+		if !accepts(r, produces) && !(produces == "application/json" && accepts(r, "application/problem+json")) {
+			writeError(w, r, http.StatusNotAcceptable, withDetails(
+				fmt.Errorf("%w, this route responds w/ %s", errNotAcceptable, produces),
+				map[string]string{"available": produces}))
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// accepts reports whether r's Accept header allows mediaType, a request w/o
+// one accepts anything
+func accepts(r *http.Request, mediaType string) bool {
+	header := strings.Join(r.Header.Values("Accept"), ",")
+	if strings.TrimSpace(header) == "" {
+		return true
+	}
+	typ, _, _ := strings.Cut(mediaType, "/")
+	for _, entry := range strings.Split(header, ",") {
+		accepted, params, err := mime.ParseMediaType(strings.TrimSpace(entry))
+		if err != nil {
+			continue
+		}
+		if q, err := strconv.ParseFloat(params["q"], 64); err == nil && q == 0 {
+			continue
+		}
+		if accepted == "*/*" || accepted == typ+"/*" || accepted == mediaType {
+			return true
+		}
+	}
+	return false
+}
+
+// acceptsExplicitly reports whether r asked for mediaType by name
+func acceptsExplicitly(r *http.Request, mediaType string) bool {
+	for _, entry := range strings.Split(strings.Join(r.Header.Values("Accept"), ","), ",") {
+		accepted, params, err := mime.ParseMediaType(strings.TrimSpace(entry))
+		if err != nil || params["q"] == "0" {
+			continue
+		}
+		if accepted == mediaType {
+			return true
+		}
+	}
+	return false
+}
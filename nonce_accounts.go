@@ -0,0 +1,361 @@
+package main
+
+import (
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"slices"
+)
+
+var SysvarRecentBlockhashesID = MustSolanaPubkey("SysvarRecentB1ockHashes11111111111111111111")
+
+// system program nonce instructions, AdvanceNonceAccount is systemAdvanceNonce
+const (
+	systemWithdrawNonce   = 5
+	systemInitializeNonce = 6
+)
+
+// size of a nonce account: version, state, authority, nonce and fee
+const nonceAccountSpace = 80
+
+var (
+	errNotANonce      = errors.New("account is not an initialized nonce account")
+	errNonceAuthority = errors.New("nonce account's authority isn't the key")
+)
+
+// NonceAccount is a durable nonce account's state on chain. A transaction
+// w/ Nonce as its blockhash stays valid until the nonce is advanced.
+type NonceAccount struct {
+	Address              string `json:"address"`
+	Authority            string `json:"authority"`
+	Nonce                string `json:"nonce"`
+	Lamports             uint64 `json:"lamports"`
+	LamportsPerSignature uint64 `json:"lamportsPerSignature"`
+}
+
+// NonceCreateRequest funds a nonce account derived from the key and Seed,
+// w/ the key as its authority
+type NonceCreateRequest struct {
+	// names the nonce account, up to 32 bytes. The same seed always
+	// derives the same account.
+	Seed string `json:"seed"`
+
+	BuildOptions
+}
+
+// NonceCreateResult is the signed transaction and the account it creates
+type NonceCreateResult struct {
+	NonceAccount string `json:"nonceAccount"`
+	TransactionResult
+}
+
+type NonceWithdrawRequest struct {
+	Lamports uint64 `json:"lamports"`
+
+	// defaults to the key's own address
+	Destination string `json:"destination,omitempty"`
+
+	BuildOptions
+}
+
+// UseDurableNonce makes the transaction advance the nonce account first and
+// use its nonce as the blockhash, so it stays valid until sent. The
+// authority must already sign the message, nobody may have signed it yet.
+func (p *SolanaPayload) UseDurableNonce(account, authority, nonce SolanaPubkey) error {
+	if p.AnySigned() {
+		return fmt.Errorf("%w, its blockhash can't change", errTxAlreadySigned)
+	}
+	msg := p.Message
+	if usesDurableNonce(msg) {
+		return fmt.Errorf("%w already", errDurableNonce)
+	}
+	if msg.SignerIndex(authority) < 0 {
+		return fmt.Errorf("%w: the nonce authority %s doesn't sign the transaction", errSolanaMalformed, authority)
+	}
+
+	nonceIdx := slices.Index(msg.AccountKeys, account)
+	switch {
+	case nonceIdx >= 0 && (nonceIdx < msg.NumRequiredSignatures || !msg.IsWritable(nonceIdx)):
+		return fmt.Errorf("%w: nonce account %s is a signer or readonly", errSolanaMalformed, account)
+	case nonceIdx < 0:
+		var err error
+		if nonceIdx, err = msg.addWritableKey(account); err != nil {
+			return err
+		}
+	}
+	sysvarIdx := slices.Index(msg.AccountKeys, SysvarRecentBlockhashesID)
+	if sysvarIdx < 0 {
+		var err error
+		if sysvarIdx, err = msg.addReadonlyKey(SysvarRecentBlockhashesID); err != nil {
+			return err
+		}
+	}
+	program := slices.Index(msg.AccountKeys, SystemProgramID)
+	if program < 0 {
+		var err error
+		if program, err = msg.addReadonlyKey(SystemProgramID); err != nil {
+			return err
+		}
+	}
+
+	advance := SolanaCompiledInstruction{
+		ProgramIDIndex: program,
+		Accounts:       []int{nonceIdx, sysvarIdx, msg.SignerIndex(authority)},
+		Data:           binary.LittleEndian.AppendUint32(nil, systemAdvanceNonce),
+	}
+	msg.Instructions = slices.Insert(msg.Instructions, 0, advance)
+	msg.RecentBlockhash = nonce
+
+	p.MsgBytes = msg.Marshal()
+	if size := len(p.UnsignedWire()); size > solanaMaxTxSize {
+		return fmt.Errorf("%w w/ the nonce advance: %d > %d bytes", errSolanaTxTooLarge, size, solanaMaxTxSize)
+	}
+	return nil
+}
+
+// nonceState is the nonce account on chain, refused unless authority holds it
+func nonceState(ctx context.Context, chain ChainClient, address, authority SolanaPubkey) (NonceAccount, error) {
+	state, err := chain.NonceAccount(ctx, address.String())
+	if errors.Is(err, errNotANonce) {
+		return NonceAccount{}, err
+	}
+	if err != nil {
+		return NonceAccount{}, fmt.Errorf("%w: %v", errRPCUnavailable, err)
+	}
+	if state.Authority != authority.String() {
+		return NonceAccount{}, fmt.Errorf("%w: %s is held by %s", errNonceAuthority, address, state.Authority)
+	}
+	return state, nil
+}
+
+// useNonceAccount signs the transaction against the key's nonce account
+// rather than a recent blockhash, the nonce patched in is the blockhash
+func useNonceAccount(ctx context.Context, chain ChainClient, store *SecureKeyStore, keyID, account string, tx *SolanaPayload, result *TransactionResult) error {
+	authority, err := keyAddress(store, keyID)
+	if err != nil {
+		return err
+	}
+	address, err := ParseSolanaPubkey(account)
+	if err != nil {
+		return fmt.Errorf("nonce account: %w", err)
+	}
+	state, err := nonceState(ctx, chain, address, authority)
+	if err != nil {
+		return err
+	}
+	nonce, err := ParseSolanaPubkey(state.Nonce)
+	if err != nil {
+		return fmt.Errorf("%w: %v", errRPCUnavailable, err)
+	}
+	if err := tx.UseDurableNonce(address, authority, nonce); err != nil {
+		return err
+	}
+	result.Blockhash = state.Nonce
+	return nil
+}
+
+// NonceCreate builds the nonce account's creation and initialization w/ the
+// key as authority, and returns its address
+func (b *TxBuilder) NonceCreate(keyID string, req NonceCreateRequest) (TransactionRequest, SolanaPubkey, error) {
+	authority, err := keyAddress(b.signer.store, keyID)
+	if err != nil {
+		return TransactionRequest{}, SolanaPubkey{}, err
+	}
+	if req.Seed == "" {
+		return TransactionRequest{}, SolanaPubkey{}, errors.New("seed is required")
+	}
+	account, err := CreateWithSeed(authority, req.Seed, SystemProgramID)
+	if err != nil {
+		return TransactionRequest{}, SolanaPubkey{}, err
+	}
+
+	ixs := []SolanaInstruction{
+		SystemCreateAccountWithSeedIx(authority, account, authority, req.Seed, solanaRentExemptMinimum(nonceAccountSpace), nonceAccountSpace, SystemProgramID),
+		NonceInitializeIx(account, authority),
+	}
+	signReq, err := b.request(keyID, authority, ixs, req.BuildOptions)
+	return signReq, account, err
+}
+
+// NonceAdvance builds a bare advance, which retires every transaction
+// signed against the current nonce. It is signed against that nonce too,
+// the signer adds the advance.
+func (b *TxBuilder) NonceAdvance(keyID, account string, opts BuildOptions) (TransactionRequest, error) {
+	authority, address, err := b.nonceAccount(keyID, account)
+	if err != nil {
+		return TransactionRequest{}, err
+	}
+	opts.NonceAccount = address.String()
+	return b.request(keyID, authority, nil, opts)
+}
+
+// NonceWithdraw builds a withdrawal from the nonce account, withdrawing all
+// of it closes the account. It counts against the key's destination
+// allowlist and spending limits like any transfer.
+func (b *TxBuilder) NonceWithdraw(keyID, account string, req NonceWithdrawRequest) (TransactionRequest, error) {
+	authority, address, err := b.nonceAccount(keyID, account)
+	if err != nil {
+		return TransactionRequest{}, err
+	}
+	if req.Lamports == 0 {
+		return TransactionRequest{}, errors.New("lamports must be greater than zero")
+	}
+	destination := authority
+	if req.Destination != "" {
+		if destination, err = ParseSolanaPubkey(req.Destination); err != nil {
+			return TransactionRequest{}, fmt.Errorf("destination: %w", err)
+		}
+	}
+	ix := NonceWithdrawIx(address, destination, authority, req.Lamports)
+	return b.request(keyID, authority, []SolanaInstruction{ix}, req.BuildOptions)
+}
+
+// NonceState looks the key's nonce account up on the cluster its requests
+// target
+func (b *TxBuilder) NonceState(ctx context.Context, keyID, account, cluster string) (NonceAccount, error) {
+	authority, address, err := b.nonceAccount(keyID, account)
+	if err != nil {
+		return NonceAccount{}, err
+	}
+	chain, err := b.chain(keyID, cluster)
+	if err != nil {
+		return NonceAccount{}, err
+	}
+	return nonceState(ctx, chain, address, authority)
+}
+
+func (b *TxBuilder) nonceAccount(keyID, account string) (SolanaPubkey, SolanaPubkey, error) {
+	authority, err := keyAddress(b.signer.store, keyID)
+	if err != nil {
+		return SolanaPubkey{}, SolanaPubkey{}, err
+	}
+	address, err := ParseSolanaPubkey(account)
+	if err != nil {
+		return SolanaPubkey{}, SolanaPubkey{}, fmt.Errorf("nonce account: %w", err)
+	}
+	return authority, address, nil
+}
+
+func NonceInitializeIx(account, authority SolanaPubkey) SolanaInstruction {
+	data := binary.LittleEndian.AppendUint32(nil, systemInitializeNonce)
+	data = append(data, authority[:]...)
+
+	return SolanaInstruction{
+		ProgramID: SystemProgramID,
+		Accounts: []SolanaAccountMeta{
+			{Pubkey: account, IsWritable: true},
+			{Pubkey: SysvarRecentBlockhashesID},
+			{Pubkey: SysvarRentID},
+		},
+		Data: data,
+	}
+}
+
+func NonceWithdrawIx(account, destination, authority SolanaPubkey, lamports uint64) SolanaInstruction {
+	data := binary.LittleEndian.AppendUint32(nil, systemWithdrawNonce)
+	data = binary.LittleEndian.AppendUint64(data, lamports)
+
+	return SolanaInstruction{
+		ProgramID: SystemProgramID,
+		Accounts: []SolanaAccountMeta{
+			{Pubkey: account, IsWritable: true},
+			{Pubkey: destination, IsWritable: true},
+			{Pubkey: SysvarRecentBlockhashesID},
+			{Pubkey: SysvarRentID},
+			{Pubkey: authority, IsSigner: true},
+		},
+		Data: data,
+	}
+}
+
+// handleNonceCreate builds, signs and optionally broadcasts a new nonce
+// account held by the key
+func (s *APIServer) handleNonceCreate(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+	var req NonceCreateRequest
+	if err := decodeJSON(r.Body, &req); err != nil {
+		writeError(w, r, http.StatusBadRequest, invalidBody(err))
+		return
+	}
+	buildOptions(r, &req.BuildOptions)
+	if err := s.checkAPIToken(r, TokenOpSign, id); err != nil {
+		refuseAPIToken(w, r, err)
+		return
+	}
+
+	signReq, account, err := s.Builder.NonceCreate(id, req)
+	if err != nil {
+		writeError(w, r, builtErrorStatus(err), err)
+		return
+	}
+	s.signAndRespond(w, r, signReq, func(res TransactionResult) any {
+		return NonceCreateResult{NonceAccount: account.String(), TransactionResult: res}
+	})
+}
+
+// handleNonceGet serves the nonce account's current nonce, ?cluster= picks
+// the cluster as on a sign request
+func (s *APIServer) handleNonceGet(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+	if OperatorFromContext(r.Context()).Name == "" {
+		if err := s.checkAPIToken(r, TokenOpRead, id); err != nil {
+			refuseAPIToken(w, r, err)
+			return
+		}
+	}
+
+	state, err := s.Builder.NonceState(r.Context(), id, r.PathValue("nonce"), r.URL.Query().Get("cluster"))
+	if err != nil {
+		writeError(w, r, builtErrorStatus(err), err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(state)
+}
+
+// handleNonceAdvance takes only build options, the body may be empty
+func (s *APIServer) handleNonceAdvance(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+	var opts BuildOptions
+	if err := decodeJSON(r.Body, &opts); err != nil && !errors.Is(err, io.EOF) {
+		writeError(w, r, http.StatusBadRequest, invalidBody(err))
+		return
+	}
+	buildOptions(r, &opts)
+	if err := s.checkAPIToken(r, TokenOpSign, id); err != nil {
+		refuseAPIToken(w, r, err)
+		return
+	}
+
+	signReq, err := s.Builder.NonceAdvance(id, r.PathValue("nonce"), opts)
+	if err != nil {
+		writeError(w, r, builtErrorStatus(err), err)
+		return
+	}
+	s.signAndRespond(w, r, signReq, nil)
+}
+
+func (s *APIServer) handleNonceWithdraw(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+	var req NonceWithdrawRequest
+	if err := decodeJSON(r.Body, &req); err != nil {
+		writeError(w, r, http.StatusBadRequest, invalidBody(err))
+		return
+	}
+	buildOptions(r, &req.BuildOptions)
+	if err := s.checkAPIToken(r, TokenOpSign, id); err != nil {
+		refuseAPIToken(w, r, err)
+		return
+	}
+
+	signReq, err := s.Builder.NonceWithdraw(id, r.PathValue("nonce"), req)
+	if err != nil {
+		writeError(w, r, builtErrorStatus(err), err)
+		return
+	}
+	s.signAndRespond(w, r, signReq, nil)
+}
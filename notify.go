@@ -0,0 +1,360 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/smtp"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// notification kinds
+const (
+	NotifyApprovalRequest = "approval_request"
+	NotifySecurityAlert   = "security_alert"
+	NotifyCrash           = "crash"
+	NotifyTxStatus        = "tx_status"
+)
+
+// severities, ordered
+const (
+	SeverityInfo     = "info"
+	SeverityWarning  = "warning"
+	SeverityCritical = "critical"
+)
+
+var severityRank = map[string]int{SeverityInfo: 0, SeverityWarning: 1, SeverityCritical: 2}
+
+type Notification struct {
+	Kind      string            `json:"kind"`
+	Severity  string            `json:"severity"`
+	Title     string            `json:"title"`
+	Message   string            `json:"message"`
+	KeyID     string            `json:"keyId,omitempty"`
+	Details   map[string]string `json:"details,omitempty"`
+	Timestamp time.Time         `json:"timestamp"`
+}
+
+// Notifier delivers a notification over one channel
+type Notifier interface {
+	Name() string
+	Notify(ctx context.Context, n Notification) error
+}
+
+type notifierRoute struct {
+	notifier    Notifier
+	minSeverity string
+}
+
+// NotificationDispatcher fans notifications out to every configured channel
+// in the background, a failing channel never blocks the caller
+type NotificationDispatcher struct {
+	routes  []notifierRoute
+	timeout time.Duration
+}
+
+// constructor
+func NewNotificationDispatcher() *NotificationDispatcher {
+	return &NotificationDispatcher{timeout: 10 * time.Second}
+}
+
+// Add registers a channel that receives notifications at or above minSeverity
+func (d *NotificationDispatcher) Add(n Notifier, minSeverity string) {
+	d.routes = append(d.routes, notifierRoute{notifier: n, minSeverity: minSeverity})
+}
+
+func (d *NotificationDispatcher) Channels() int {
+	return len(d.routes)
+}
+
+// Dispatch sends asynchronously, it is safe to call on a nil dispatcher
+func (d *NotificationDispatcher) Dispatch(n Notification) {
+	if d == nil {
+		return
+	}
+	if n.Timestamp.IsZero() {
+		n.Timestamp = time.Now().UTC()
+	}
+
+	for _, route := range d.routes {
+		if severityRank[n.Severity] < severityRank[route.minSeverity] {
+			continue
+		}
+
+		go func(notifier Notifier) {
+			ctx, cancel := context.WithTimeout(context.Background(), d.timeout)
+			defer cancel()
+
+			if err := notifier.Notify(ctx, n); err != nil {
+				slog.Error("Notification failed", logNotify, "title", n.Title, "channel", notifier.Name(), "err", err)
+			}
+		}(route.notifier)
+	}
+}
+
+// WebhookNotifier POSTs the notification as JSON, signed w/ HMAC-SHA256 of
+// the body when a secret is set
+type WebhookNotifier struct {
+	URL    string
+	Secret string
+	Client *http.Client
+}
+
+func (w *WebhookNotifier) Name() string { return "webhook" }
+
+func (w *WebhookNotifier) Notify(ctx context.Context, n Notification) error {
+	body, err := json.Marshal(n)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, w.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if w.Secret != "" {
+		mac := hmac.New(sha256.New, []byte(w.Secret))
+		mac.Write(body)
+		req.Header.Set("X-STS-Signature", "sha256="+hex.EncodeToString(mac.Sum(nil)))
+	}
+
+	return doNotifyRequest(w.Client, req)
+}
+
+// SMTPNotifier emails the notification
+type SMTPNotifier struct {
+	Addr     string
+	From     string
+	To       []string
+	Username string
+	Password string
+}
+
+func (s *SMTPNotifier) Name() string { return "smtp" }
+
+func (s *SMTPNotifier) Notify(ctx context.Context, n Notification) error {
+	var auth smtp.Auth
+	if s.Username != "" {
+		host, _, _ := strings.Cut(s.Addr, ":")
+		auth = smtp.PlainAuth("", s.Username, s.Password, host)
+	}
+
+	var msg strings.Builder
+	fmt.Fprintf(&msg, "From: %s\r\n", s.From)
+	fmt.Fprintf(&msg, "To: %s\r\n", strings.Join(s.To, ", "))
+	fmt.Fprintf(&msg, "Subject: [sts-svc %s] %s\r\n", n.Severity, n.Title)
+	fmt.Fprintf(&msg, "Content-Type: text/plain; charset=utf-8\r\n\r\n")
+	fmt.Fprintf(&msg, "%s\r\n\r\nKind: %s\r\nTime: %s\r\n", n.Message, n.Kind, n.Timestamp.Format(time.RFC3339))
+	if n.KeyID != "" {
+		fmt.Fprintf(&msg, "Key: %s\r\n", n.KeyID)
+	}
+	for k, v := range n.Details {
+		fmt.Fprintf(&msg, "%s: %s\r\n", k, v)
+	}
+
+	// net/smtp has no context support, run it so the timeout still applies
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- smtp.SendMail(s.Addr, auth, s.From, s.To, []byte(msg.String()))
+	}()
+
+	select {
+	case err := <-errCh:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// PagerDutyNotifier triggers an Events API v2 incident
+type PagerDutyNotifier struct {
+	RoutingKey string
+	URL        string
+	Client     *http.Client
+}
+
+func (p *PagerDutyNotifier) Name() string { return "pagerduty" }
+
+func (p *PagerDutyNotifier) Notify(ctx context.Context, n Notification) error {
+	endpoint := p.URL
+	if endpoint == "" {
+		endpoint = "https://events.pagerduty.com/v2/enqueue"
+	}
+
+	// map onto pagerduty's severities, unknown ones page as errors
+	severity := map[string]string{SeverityInfo: "info", SeverityWarning: "warning", SeverityCritical: "critical"}[n.Severity]
+	if severity == "" {
+		severity = "error"
+	}
+
+	body, err := json.Marshal(map[string]any{
+		"routing_key":  p.RoutingKey,
+		"event_action": "trigger",
+		"payload": map[string]any{
+			"summary":        n.Title + ": " + n.Message,
+			"source":         "sts-svc",
+			"severity":       severity,
+			"component":      n.Kind,
+			"timestamp":      n.Timestamp.Format(time.RFC3339),
+			"custom_details": n.Details,
+		},
+	})
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	return doNotifyRequest(p.Client, req)
+}
+
+// SNSNotifier publishes to an SNS topic, requests are signed w/ SigV4 so no
+// AWS SDK is needed
+type SNSNotifier struct {
+	Region       string
+	TopicARN     string
+	AccessKey    string
+	SecretKey    string
+	SessionToken string
+	Client       *http.Client
+}
+
+func (s *SNSNotifier) Name() string { return "sns" }
+
+func (s *SNSNotifier) Notify(ctx context.Context, n Notification) error {
+	msg, err := json.Marshal(n)
+	if err != nil {
+		return err
+	}
+
+	// SNS subjects are capped at 100 chars
+	subject := "[sts-svc] " + n.Title
+	if len(subject) > 100 {
+		subject = subject[:100]
+	}
+
+	form := url.Values{}
+	form.Set("Action", "Publish")
+	form.Set("Version", "2010-03-31")
+	form.Set("TopicArn", s.TopicARN)
+	form.Set("Subject", subject)
+	form.Set("Message", string(msg))
+	body := form.Encode()
+
+	host := fmt.Sprintf("sns.%s.amazonaws.com", s.Region)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, "https://"+host+"/", strings.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded; charset=utf-8")
+	s.sign(req, host, body, time.Now().UTC())
+
+	return doNotifyRequest(s.Client, req)
+}
+
+// sign adds AWS SigV4 headers for the sns service
+func (s *SNSNotifier) sign(req *http.Request, host, body string, now time.Time) {
+	amzDate := now.Format("20060102T150405Z")
+	date := now.Format("20060102")
+
+	req.Header.Set("X-Amz-Date", amzDate)
+	if s.SessionToken != "" {
+		req.Header.Set("X-Amz-Security-Token", s.SessionToken)
+	}
+
+	canonicalHeaders := "content-type:" + req.Header.Get("Content-Type") + "\n" +
+		"host:" + host + "\n" +
+		"x-amz-date:" + amzDate + "\n"
+	signedHeaders := "content-type;host;x-amz-date"
+	if s.SessionToken != "" {
+		canonicalHeaders += "x-amz-security-token:" + s.SessionToken + "\n"
+		signedHeaders += ";x-amz-security-token"
+	}
+
+	bodyHash := sha256.Sum256([]byte(body))
+	canonicalRequest := strings.Join([]string{
+		req.Method, "/", "", canonicalHeaders, signedHeaders, hex.EncodeToString(bodyHash[:]),
+	}, "\n")
+
+	scope := date + "/" + s.Region + "/sns/aws4_request"
+	requestHash := sha256.Sum256([]byte(canonicalRequest))
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + hex.EncodeToString(requestHash[:])
+
+	key := hmacSHA256([]byte("AWS4"+s.SecretKey), date)
+	key = hmacSHA256(key, s.Region)
+	key = hmacSHA256(key, "sns")
+	key = hmacSHA256(key, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s", s.AccessKey, scope, signedHeaders, signature))
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
+
+func doNotifyRequest(client *http.Client, req *http.Request) error {
+	if client == nil {
+		client = tracedClient
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	return nil
+}
+
+// NotificationDispatcherFromEnv wires every channel whose settings are present
+func NotificationDispatcherFromEnv(getenv func(string) string) *NotificationDispatcher {
+	d := NewNotificationDispatcher()
+
+	if u := getenv("STS_NOTIFY_WEBHOOK_URL"); u != "" {
+		d.Add(&WebhookNotifier{URL: u, Secret: getenv("STS_NOTIFY_WEBHOOK_SECRET")}, SeverityInfo)
+	}
+	if addr := getenv("STS_NOTIFY_SMTP_ADDR"); addr != "" {
+		d.Add(&SMTPNotifier{
+			Addr:     addr,
+			From:     getenv("STS_NOTIFY_SMTP_FROM"),
+			To:       strings.Split(getenv("STS_NOTIFY_SMTP_TO"), ","),
+			Username: getenv("STS_NOTIFY_SMTP_USER"),
+			Password: getenv("STS_NOTIFY_SMTP_PASSWORD"),
+		}, SeverityInfo)
+	}
+	if key := getenv("STS_NOTIFY_PAGERDUTY_ROUTING_KEY"); key != "" {
+		// paging is for things a human must act on now
+		d.Add(&PagerDutyNotifier{RoutingKey: key}, SeverityWarning)
+	}
+	if arn := getenv("STS_NOTIFY_SNS_TOPIC_ARN"); arn != "" {
+		d.Add(&SNSNotifier{
+			Region:       getenv("AWS_REGION"),
+			TopicARN:     arn,
+			AccessKey:    getenv("AWS_ACCESS_KEY_ID"),
+			SecretKey:    getenv("AWS_SECRET_ACCESS_KEY"),
+			SessionToken: getenv("AWS_SESSION_TOKEN"),
+		}, SeverityInfo)
+	}
+
+	slog.Info("Notifications configured", logNotify, "channels", d.Channels())
+	return d
+}
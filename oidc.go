@@ -0,0 +1,353 @@
+package main
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// clock drift allowed on exp, nbf and iat
+	jwtLeeway = time.Minute
+
+	// how long fetched signing keys are trusted before a refresh
+	jwksCacheTTL = time.Hour
+
+	// unknown key ids trigger a refresh at most this often
+	jwksMinRefresh = time.Minute
+)
+
+var errInvalidJWT = errors.New("invalid bearer token")
+
+type OIDCConfig struct {
+	Issuer   string
+	Audience string
+
+	// claim holding the operator name, default sub, it's namespaced under the
+	// issuer so it can't collide w/ a static operator
+	NameClaim string
+
+	// claim holding operator roles as a list or space separated string, default roles
+	RolesClaim string
+}
+
+// OIDCConfigFromEnv reads STS_OIDC_ISSUER, STS_OIDC_AUDIENCE, STS_OIDC_NAME_CLAIM
+// and STS_OIDC_ROLES_CLAIM, it returns nil when no issuer is set
+func OIDCConfigFromEnv(getenv func(string) string) (*OIDCConfig, error) {
+	cfg := &OIDCConfig{
+		Issuer:     strings.TrimSuffix(getenv("STS_OIDC_ISSUER"), "/"),
+		Audience:   getenv("STS_OIDC_AUDIENCE"),
+		NameClaim:  getenv("STS_OIDC_NAME_CLAIM"),
+		RolesClaim: getenv("STS_OIDC_ROLES_CLAIM"),
+	}
+	if cfg.Issuer == "" {
+		return nil, nil
+	}
+	if cfg.Audience == "" {
+		return nil, errors.New("STS_OIDC_AUDIENCE is required w/ STS_OIDC_ISSUER")
+	}
+	return cfg, nil
+}
+
+// OIDCVerifier validates JWTs from an OIDC issuer and maps them to operators
+type OIDCVerifier struct {
+	cfg     OIDCConfig
+	jwksURI string
+	client  *http.Client
+
+	keys      map[string]crypto.PublicKey
+	fetchedAt time.Time
+
+	mu sync.Mutex
+}
+
+// NewOIDCVerifier runs issuer discovery and loads the signing keys
+func NewOIDCVerifier(ctx context.Context, cfg OIDCConfig) (*OIDCVerifier, error) {
+	if cfg.NameClaim == "" {
+		cfg.NameClaim = "sub"
+	}
+	if cfg.RolesClaim == "" {
+		cfg.RolesClaim = "roles"
+	}
+	v := &OIDCVerifier{cfg: cfg, client: &http.Client{Timeout: 10 * time.Second, Transport: tracedTransport()}}
+
+	var discovery struct {
+		Issuer  string `json:"issuer"`
+		JWKSURI string `json:"jwks_uri"`
+	}
+	if err := v.getJSON(ctx, cfg.Issuer+"/.well-known/openid-configuration", &discovery); err != nil {
+		return nil, fmt.Errorf("oidc discovery failed: %w", err)
+	}
+	if strings.TrimSuffix(discovery.Issuer, "/") != cfg.Issuer || discovery.JWKSURI == "" {
+		return nil, errors.New("oidc discovery returned a different issuer or no jwks_uri")
+	}
+	v.jwksURI = discovery.JWKSURI
+
+	if err := v.refresh(ctx); err != nil {
+		return nil, err
+	}
+	return v, nil
+}
+
+func (v *OIDCVerifier) getJSON(ctx context.Context, url string, out any) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+	res, err := v.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s returned %s", url, res.Status)
+	}
+	return json.NewDecoder(http.MaxBytesReader(nil, res.Body, 1<<20)).Decode(out)
+}
+
+type jwk struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	Crv string `json:"crv"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+// refresh reloads the issuer's JWKS, must not be called w/ mu held
+func (v *OIDCVerifier) refresh(ctx context.Context) error {
+	var set struct {
+		Keys []jwk `json:"keys"`
+	}
+	if err := v.getJSON(ctx, v.jwksURI, &set); err != nil {
+		return fmt.Errorf("failed to fetch jwks: %w", err)
+	}
+
+	keys := make(map[string]crypto.PublicKey)
+	for _, k := range set.Keys {
+		if k.Use != "" && k.Use != "sig" {
+			continue
+		}
+		// keys we can't use are skipped, the issuer may publish other types
+		if pub, err := k.publicKey(); err == nil {
+			keys[k.Kid] = pub
+		}
+	}
+
+	v.mu.Lock()
+
+	defer v.mu.Unlock()
+
+	v.keys = keys
+	v.fetchedAt = time.Now()
+	return nil
+}
+
+func (k jwk) publicKey() (crypto.PublicKey, error) {
+	b64 := base64.RawURLEncoding
+	switch {
+	case k.Kty == "RSA":
+		n, errN := b64.DecodeString(k.N)
+		e, errE := b64.DecodeString(k.E)
+		if errN != nil || errE != nil || len(e) > 4 {
+			return nil, errors.New("malformed rsa jwk")
+		}
+		pub := &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}
+		if pub.N.BitLen() < 2048 {
+			return nil, errors.New("rsa jwk is too small")
+		}
+		return pub, nil
+	case k.Kty == "EC" && k.Crv == "P-256":
+		x, errX := b64.DecodeString(k.X)
+		y, errY := b64.DecodeString(k.Y)
+		if errX != nil || errY != nil || len(x) != 32 || len(y) != 32 {
+			return nil, errors.New("malformed ec jwk")
+		}
+		return ecdsa.ParseUncompressedPublicKey(elliptic.P256(), append(append([]byte{4}, x...), y...))
+	case k.Kty == "OKP" && k.Crv == "Ed25519":
+		x, err := b64.DecodeString(k.X)
+		if err != nil || len(x) != ed25519.PublicKeySize {
+			return nil, errors.New("malformed ed25519 jwk")
+		}
+		return ed25519.PublicKey(x), nil
+	default:
+		return nil, fmt.Errorf("unsupported jwk %s %s", k.Kty, k.Crv)
+	}
+}
+
+// key looks up a signing key, refreshing the JWKS for unknown or stale ids
+func (v *OIDCVerifier) key(ctx context.Context, kid string) (crypto.PublicKey, error) {
+	v.mu.Lock()
+	pub, ok := v.keys[kid]
+	age := time.Since(v.fetchedAt)
+	v.mu.Unlock()
+
+	if (ok && age < jwksCacheTTL) || (!ok && age < jwksMinRefresh) {
+		if !ok {
+			return nil, fmt.Errorf("%w: unknown key id", errInvalidJWT)
+		}
+		return pub, nil
+	}
+
+	if err := v.refresh(ctx); err != nil {
+		// keep using a known key if the issuer is briefly down
+		if ok {
+			return pub, nil
+		}
+		return nil, err
+	}
+
+	v.mu.Lock()
+
+	defer v.mu.Unlock()
+
+	if pub, ok = v.keys[kid]; !ok {
+		return nil, fmt.Errorf("%w: unknown key id", errInvalidJWT)
+	}
+	return pub, nil
+}
+
+// Verify checks the token's signature and claims and returns the operator it names
+func (v *OIDCVerifier) Verify(ctx context.Context, token string, now time.Time) (Operator, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return Operator{}, errInvalidJWT
+	}
+
+	var header struct {
+		Alg string `json:"alg"`
+		Kid string `json:"kid"`
+	}
+	if err := decodeJWTPart(parts[0], &header); err != nil {
+		return Operator{}, err
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return Operator{}, errInvalidJWT
+	}
+
+	pub, err := v.key(ctx, header.Kid)
+	if err != nil {
+		return Operator{}, err
+	}
+	if err := verifyJWTSignature(header.Alg, pub, []byte(parts[0]+"."+parts[1]), sig); err != nil {
+		return Operator{}, err
+	}
+
+	var claims map[string]any
+	if err := decodeJWTPart(parts[1], &claims); err != nil {
+		return Operator{}, err
+	}
+	if err := v.checkClaims(claims, now); err != nil {
+		return Operator{}, err
+	}
+
+	name, _ := claims[v.cfg.NameClaim].(string)
+	if name == "" {
+		return Operator{}, fmt.Errorf("%w: no %s claim", errInvalidJWT, v.cfg.NameClaim)
+	}
+	return Operator{Name: oidcOperatorName(v.cfg.Issuer, name), Roles: claimStrings(claims[v.cfg.RolesClaim])}, nil
+}
+
+// oidcOperatorName keeps issuer names apart from static operators and each other
+func oidcOperatorName(issuer, name string) string {
+	return "oidc:" + issuer + "/" + name
+}
+
+func decodeJWTPart(part string, out any) error {
+	raw, err := base64.RawURLEncoding.DecodeString(part)
+	if err != nil || json.Unmarshal(raw, out) != nil {
+		return errInvalidJWT
+	}
+	return nil
+}
+
+// verifyJWTSignature ties the alg to the key type so a token can't pick a weaker check
+func verifyJWTSignature(alg string, pub crypto.PublicKey, signed, sig []byte) error {
+	digest := sha256.Sum256(signed)
+
+	ok := false
+	switch key := pub.(type) {
+	case *rsa.PublicKey:
+		ok = alg == "RS256" && rsa.VerifyPKCS1v15(key, crypto.SHA256, digest[:], sig) == nil
+	case *ecdsa.PublicKey:
+		ok = alg == "ES256" && len(sig) == 64 &&
+			ecdsa.Verify(key, digest[:], new(big.Int).SetBytes(sig[:32]), new(big.Int).SetBytes(sig[32:]))
+	case ed25519.PublicKey:
+		ok = alg == "EdDSA" && ed25519.Verify(key, signed, sig)
+	}
+	if !ok {
+		return fmt.Errorf("%w: bad signature", errInvalidJWT)
+	}
+	return nil
+}
+
+func (v *OIDCVerifier) checkClaims(claims map[string]any, now time.Time) error {
+	if iss, _ := claims["iss"].(string); strings.TrimSuffix(iss, "/") != v.cfg.Issuer {
+		return fmt.Errorf("%w: wrong issuer", errInvalidJWT)
+	}
+	if !slices.Contains(audiences(claims["aud"]), v.cfg.Audience) {
+		return fmt.Errorf("%w: wrong audience", errInvalidJWT)
+	}
+
+	exp, ok := claims["exp"].(float64)
+	if !ok || now.After(time.Unix(int64(exp), 0).Add(jwtLeeway)) {
+		return fmt.Errorf("%w: expired", errInvalidJWT)
+	}
+	if nbf, ok := claims["nbf"].(float64); ok && now.Add(jwtLeeway).Before(time.Unix(int64(nbf), 0)) {
+		return fmt.Errorf("%w: not yet valid", errInvalidJWT)
+	}
+	if iat, ok := claims["iat"].(float64); ok && now.Add(jwtLeeway).Before(time.Unix(int64(iat), 0)) {
+		return fmt.Errorf("%w: issued in the future", errInvalidJWT)
+	}
+	return nil
+}
+
+// claimStrings reads a string list claim, single strings are split on spaces
+func claimStrings(v any) []string {
+	switch v := v.(type) {
+	case string:
+		return strings.Fields(v)
+	case []any:
+		out := []string{}
+		for _, item := range v {
+			if s, ok := item.(string); ok {
+				out = append(out, s)
+			}
+		}
+		return out
+	default:
+		return nil
+	}
+}
+
+// audiences reads aud, a single string is one audience (RFC 7519 4.1.3) and
+// isn't split
+func audiences(v any) []string {
+	if s, ok := v.(string); ok {
+		return []string{s}
+	}
+	return claimStrings(v)
+}
+
+// looksLikeJWT tells OIDC tokens apart from static operator tokens
+func looksLikeJWT(token string) bool {
+	return strings.Count(token, ".") == 2
+}
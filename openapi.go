@@ -0,0 +1,329 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// apiOperation documents one route, schemas are reflected from the request
+// and response types so the spec follows the code
+type apiOperation struct {
+	Method  string
+	Path    string
+	Summary string
+
+	// zero values of the body types, nil for none
+	Request  any
+	Response any
+
+	// query parameters
+	Query []string
+
+	// extra success status, e.g. 202 for a parked sign request
+	Accepted bool
+
+	// needs an operator bearer token
+	Operator bool
+
+	// scoped by X-API-Key tokens
+	APIToken bool
+}
+
+var pathParam = regexp.MustCompile(`\{(\w+)\}`)
+
+// apiOperations lists the key and signing routes mounted on this server
+func (s *APIServer) apiOperations() []apiOperation {
+	ops := []apiOperation{
+		{Method: "POST", Path: "/api/v1/keys/generate", Summary: "Generate a signing key", Request: KeyGenRequest{}, Response: Account{}, APIToken: true},
+		{Method: "POST", Path: "/api/v1/txs/sign", Summary: "Sign a transaction", Request: TransactionRequest{}, Response: TransactionResult{}, Accepted: true, APIToken: true},
+		{Method: "POST", Path: "/api/v1/signatures/verify", Summary: "Verify a signature", Request: VerifyRequest{}, Response: VerifyResult{}, APIToken: true},
+		{Method: "GET", Path: "/api/v1/fips", Summary: "FIPS mode and approved key types", Response: FIPSStatus{}, APIToken: true},
+		{Method: "GET", Path: "/api/v1/version", Summary: "Version and build info", Response: BuildInfo{}, APIToken: true},
+		{Method: "GET", Path: "/api/v1/keys/{id}", Summary: "Key status, policy summary and usage", Response: KeyDetail{}, APIToken: true},
+	}
+	if s.Analytics != nil {
+		ops = append(ops, apiOperation{Method: "GET", Path: "/api/v1/keys/{id}/analytics", Summary: "Signatures, failures and latency per day for a key", Response: KeyAnalyticsReport{}, Query: []string{"from", "to"}, APIToken: true})
+	}
+	if s.Txs != nil {
+		ops = append(ops,
+			apiOperation{Method: "GET", Path: "/api/v1/txs/{signature}", Summary: "The service's record of a signed transaction and its live status", Response: TrackedTx{}, APIToken: true},
+			apiOperation{Method: "GET", Path: "/api/v1/keys/{id}/txs", Summary: "A key's signed transactions newest first, optionally only those carrying a memo", Response: []TrackedTx{}, Query: []string{"memo"}, APIToken: true},
+		)
+	}
+	if s.Outbound != nil {
+		ops = append(ops,
+			apiOperation{Method: "GET", Path: "/api/v1/keys/{id}/queue", Summary: "A key's queued transactions, next to be broadcast first", Response: []OutboundTx{}, APIToken: true},
+			apiOperation{Method: "POST", Path: "/api/v1/keys/{id}/queue/{entry}/retry", Summary: "Re-sign a halted queued transaction w/ a fresh blockhash so the key's queue moves again", Response: OutboundTx{}, APIToken: true},
+		)
+	}
+	if s.Balances != nil {
+		ops = append(ops, apiOperation{Method: "GET", Path: "/api/v1/keys/{id}/balance", Summary: "Lamports and token balances at a key's address", Response: KeyBalance{}, APIToken: true})
+	}
+	if s.Airdrops != nil {
+		ops = append(ops, apiOperation{Method: "POST", Path: "/api/v1/keys/{id}/airdrop", Summary: "Fund a key from the faucet off mainnet and wait for it to confirm", Request: AirdropRequest{}, Response: AirdropResult{}, APIToken: true})
+	}
+	if s.Builder != nil {
+		ops = append(ops,
+			apiOperation{Method: "POST", Path: "/api/v1/keys/{id}/transfers/token", Summary: "Build, sign and optionally broadcast an SPL token transfer from a key", Request: TokenTransferRequest{}, Response: TransactionResult{}, Accepted: true, APIToken: true},
+			apiOperation{Method: "POST", Path: "/api/v1/keys/{id}/accounts/token", Summary: "Build, sign and optionally broadcast an associated token account's creation, paid by a key", Request: TokenAccountRequest{}, Response: TransactionResult{}, Accepted: true, APIToken: true},
+			apiOperation{Method: "POST", Path: "/api/v1/keys/{id}/stakes", Summary: "Create a stake account staked by a key, delegated when a validator is given", Request: StakeCreateRequest{}, Response: StakeCreateResult{}, Accepted: true, APIToken: true},
+			apiOperation{Method: "POST", Path: "/api/v1/keys/{id}/stakes/{stake}/delegate", Summary: "Delegate a key's stake account to a validator", Request: StakeDelegateRequest{}, Response: TransactionResult{}, Accepted: true, APIToken: true},
+			apiOperation{Method: "POST", Path: "/api/v1/keys/{id}/stakes/{stake}/deactivate", Summary: "Deactivate a key's stake account", Request: BuildOptions{}, Response: TransactionResult{}, Accepted: true, APIToken: true},
+			apiOperation{Method: "POST", Path: "/api/v1/keys/{id}/stakes/{stake}/withdraw", Summary: "Withdraw lamports from a key's stake account", Request: StakeWithdrawRequest{}, Response: TransactionResult{}, Accepted: true, APIToken: true},
+			apiOperation{Method: "POST", Path: "/api/v1/keys/{id}/nonces", Summary: "Create a durable nonce account held by a key", Request: NonceCreateRequest{}, Response: NonceCreateResult{}, Accepted: true, APIToken: true},
+			apiOperation{Method: "GET", Path: "/api/v1/keys/{id}/nonces/{nonce}", Summary: "A key's nonce account and its current nonce", Response: NonceAccount{}, Query: []string{"cluster"}, APIToken: true},
+			apiOperation{Method: "POST", Path: "/api/v1/keys/{id}/nonces/{nonce}/advance", Summary: "Advance a key's nonce account, retiring transactions signed against it", Request: BuildOptions{}, Response: TransactionResult{}, Accepted: true, APIToken: true},
+			apiOperation{Method: "POST", Path: "/api/v1/keys/{id}/nonces/{nonce}/withdraw", Summary: "Withdraw lamports from a key's nonce account", Request: NonceWithdrawRequest{}, Response: TransactionResult{}, Accepted: true, APIToken: true},
+		)
+	}
+	if s.Fees != nil {
+		ops = append(ops, apiOperation{Method: "GET", Path: "/api/v1/fees/priority", Summary: "Recent priority fees and a recommended compute unit price", Response: PriorityFeeAdvice{}, Query: []string{"accounts"}, APIToken: true})
+	}
+	if s.FeePayer != "" {
+		ops = append(ops, apiOperation{Method: "GET", Path: "/api/v1/fee-payer", Summary: "The address feePayer sign requests name as their fee payer", Response: FeePayerInfo{}, APIToken: true})
+	}
+	if s.Attester != nil {
+		ops = append(ops, apiOperation{Method: "GET", Path: "/api/v1/attestation/key", Summary: "Key attestation signer", Response: map[string]string{}, APIToken: true})
+	}
+	if s.StaleKeys != nil {
+		ops = append(ops, apiOperation{Method: "GET", Path: "/api/v1/keys/stale", Summary: "Idle keys recommended for retirement", Response: StaleKeyReport{}, APIToken: true})
+	}
+	if s.adminEnabled() {
+		ops = append(ops, apiOperation{Method: "GET", Path: "/api/v1/usage/costs", Summary: "Backend costs per tenant", Response: []TenantCosts{}, Query: []string{"tenant"}, Operator: true})
+	}
+	if s.Store != nil && s.adminEnabled() {
+		ops = append(ops,
+			apiOperation{Method: "POST", Path: "/api/v1/keys/{id}/freeze", Summary: "Freeze a key", Request: FreezeRequest{}, Response: KeyUsage{}, Operator: true},
+			apiOperation{Method: "POST", Path: "/api/v1/keys/{id}/unfreeze", Summary: "Unfreeze a key", Response: KeyUsage{}, Operator: true},
+			apiOperation{Method: "DELETE", Path: "/api/v1/keys/{id}", Summary: "Zeroize and delete a key", Operator: true},
+		)
+	}
+	if s.Outbound != nil && s.adminEnabled() {
+		ops = append(ops, apiOperation{Method: "DELETE", Path: "/api/v1/keys/{id}/queue/{entry}", Summary: "Remove a halted or unsent transaction from a key's queue", Response: OutboundTx{}, Operator: true})
+	}
+	if s.Grants != nil && s.adminEnabled() {
+		ops = append(ops,
+			apiOperation{Method: "POST", Path: "/api/v1/grants", Summary: "Mint a signing grant", Request: GrantRequest{}, Response: SigningGrant{}, Operator: true},
+			apiOperation{Method: "DELETE", Path: "/api/v1/grants/{id}", Summary: "Revoke a signing grant", Operator: true},
+		)
+	}
+	return ops
+}
+
+// OpenAPI builds the OpenAPI 3 document for the mounted key and signing routes
+func (s *APIServer) OpenAPI() map[string]any {
+	schemas := map[string]any{}
+	schemaOf(reflect.TypeOf(ErrorResponse{}), schemas)
+
+	paths := map[string]any{}
+	for _, op := range s.apiOperations() {
+		operation := map[string]any{
+			"summary":     op.Summary,
+			"operationId": operationID(op),
+		}
+
+		var params []any
+		for _, m := range pathParam.FindAllStringSubmatch(op.Path, -1) {
+			params = append(params, map[string]any{"name": m[1], "in": "path", "required": true, "schema": map[string]any{"type": "string"}})
+		}
+		for _, q := range op.Query {
+			params = append(params, map[string]any{"name": q, "in": "query", "schema": map[string]any{"type": "string"}})
+		}
+		if params != nil {
+			operation["parameters"] = params
+		}
+
+		if op.Request != nil {
+			operation["requestBody"] = map[string]any{
+				"required": true,
+				"content":  jsonContent(schemaOf(reflect.TypeOf(op.Request), schemas)),
+			}
+		}
+
+		responses := map[string]any{
+			"default": map[string]any{
+				"description": "Error",
+				"content":     jsonContent(map[string]any{"$ref": "#/components/schemas/ErrorResponse"}),
+			},
+		}
+		if op.Response != nil {
+			ok := map[string]any{"description": "OK", "content": jsonContent(schemaOf(reflect.TypeOf(op.Response), schemas))}
+			responses["200"] = ok
+			if op.Accepted {
+				responses["202"] = map[string]any{"description": "Waiting on approval", "content": ok["content"]}
+			}
+		} else {
+			responses["204"] = map[string]any{"description": "No content"}
+		}
+		operation["responses"] = responses
+
+		switch {
+		case op.Operator:
+			operation["security"] = []any{map[string]any{"operator": []string{}}}
+		case op.APIToken && s.RequireAPIToken:
+			operation["security"] = []any{map[string]any{"apiToken": []string{}}}
+		case op.APIToken:
+			// the token is optional unless STS_REQUIRE_API_TOKEN is set
+			operation["security"] = []any{map[string]any{"apiToken": []string{}}, map[string]any{}}
+		}
+
+		item, _ := paths[op.Path].(map[string]any)
+		if item == nil {
+			item = map[string]any{}
+			paths[op.Path] = item
+		}
+		item[strings.ToLower(op.Method)] = operation
+	}
+
+	return map[string]any{
+		"openapi": "3.0.3",
+		"info": map[string]any{
+			"title":   "Secure Signer Service",
+			"version": version,
+		},
+		"paths": paths,
+		"components": map[string]any{
+			"schemas": schemas,
+			"securitySchemes": map[string]any{
+				"operator": map[string]any{"type": "http", "scheme": "bearer"},
+				"apiToken": map[string]any{"type": "apiKey", "in": "header", "name": "X-API-Key"},
+			},
+		},
+	}
+}
+
+// operationID turns "POST /api/v1/keys/{id}/freeze" into "postKeysIdFreeze"
+func operationID(op apiOperation) string {
+	id := strings.ToLower(op.Method)
+	for _, part := range strings.Split(strings.TrimPrefix(op.Path, "/api/v1/"), "/") {
+		part = strings.Trim(part, "{}")
+		if part == "" {
+			continue
+		}
+		id += strings.ToUpper(part[:1]) + part[1:]
+	}
+	return id
+}
+
+func jsonContent(schema any) map[string]any {
+	return map[string]any{"application/json": map[string]any{"schema": schema}}
+}
+
+var timeType = reflect.TypeOf(time.Time{})
+
+// schemaOf reflects a JSON schema from a Go type, named structs are added
+// to schemas and referenced
+func schemaOf(t reflect.Type, schemas map[string]any) map[string]any {
+	if t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	switch {
+	case t == timeType:
+		return map[string]any{"type": "string", "format": "date-time"}
+	case t == reflect.TypeOf(time.Duration(0)):
+		return map[string]any{"type": "integer", "format": "int64"}
+	}
+
+	switch t.Kind() {
+	case reflect.String:
+		return map[string]any{"type": "string"}
+	case reflect.Bool:
+		return map[string]any{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return map[string]any{"type": "integer"}
+	case reflect.Float32, reflect.Float64:
+		return map[string]any{"type": "number"}
+	case reflect.Slice, reflect.Array:
+		// encoding/json sends bytes as base64
+		if t.Elem().Kind() == reflect.Uint8 {
+			return map[string]any{"type": "string", "format": "byte"}
+		}
+		return map[string]any{"type": "array", "items": schemaOf(t.Elem(), schemas)}
+	case reflect.Map:
+		return map[string]any{"type": "object", "additionalProperties": schemaOf(t.Elem(), schemas)}
+	case reflect.Struct:
+		if t.Name() == "" {
+			return structSchema(t, schemas)
+		}
+		ref := map[string]any{"$ref": "#/components/schemas/" + t.Name()}
+		if _, ok := schemas[t.Name()]; ok {
+			return ref
+		}
+		// placeholder first so recursive types terminate
+		schemas[t.Name()] = map[string]any{}
+		schemas[t.Name()] = structSchema(t, schemas)
+		return ref
+	default:
+		return map[string]any{}
+	}
+}
+
+func structSchema(t reflect.Type, schemas map[string]any) map[string]any {
+	properties := map[string]any{}
+	var required []string
+	addFields(t, properties, &required, schemas)
+
+	schema := map[string]any{"type": "object", "properties": properties}
+	if required != nil {
+		schema["required"] = required
+	}
+	return schema
+}
+
+// addFields follows encoding/json: exported fields, json tag names and
+// embedded structs flattened into the parent
+func addFields(t reflect.Type, properties map[string]any, required *[]string, schemas map[string]any) {
+	for i := range t.NumField() {
+		f := t.Field(i)
+		tag := f.Tag.Get("json")
+		if tag == "-" {
+			continue
+		}
+		name, opts, _ := strings.Cut(tag, ",")
+		if f.Anonymous && name == "" && f.Type.Kind() == reflect.Struct {
+			addFields(f.Type, properties, required, schemas)
+			continue
+		}
+		if !f.IsExported() {
+			continue
+		}
+		if name == "" {
+			name = f.Name
+		}
+		properties[name] = schemaOf(f.Type, schemas)
+		if !strings.Contains(opts, "omitempty") && f.Type.Kind() != reflect.Pointer {
+			*required = append(*required, name)
+		}
+	}
+}
+
+func (s *APIServer) handleOpenAPI(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	json.NewEncoder(w).Encode(s.OpenAPI())
+}
+
+// swaggerUI loads the UI from a CDN, so it's only served when asked for
+const swaggerUI = `<!DOCTYPE html>
+<html>
+<head>
+<title>Secure Signer Service API</title>
+<link rel="stylesheet" href="https://unpkg.com/swagger-ui-dist@5/swagger-ui.css">
+</head>
+<body>
+<div id="swagger-ui"></div>
+<script src="https://unpkg.com/swagger-ui-dist@5/swagger-ui-bundle.js"></script>
+<script>SwaggerUIBundle({url: "/api/v1/openapi.json", dom_id: "#swagger-ui"});</script>
+</body>
+</html>
+`
+
+func (s *APIServer) handleSwaggerUI(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Write([]byte(swaggerUI))
+}
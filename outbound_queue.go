@@ -0,0 +1,560 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// where a queued transaction is, landed ones leave the queue
+const (
+	QueueStatusQueued = "queued"
+	QueueStatusSent   = "sent"
+
+	// the transaction can't land as is, the key's later transactions wait
+	// until it is retried or removed
+	QueueStatusHalted = "halted"
+)
+
+const (
+	// a sent transaction the cluster hasn't seen this long is sent again,
+	// or re-signed once its blockhash expired
+	outboundResendAfter = 30 * time.Second
+
+	// re-signs w/ a fresh blockhash before the queue halts on a transaction
+	outboundMaxResigns = 3
+)
+
+var (
+	errQueueDisabled      = errors.New("outbound queue not configured")
+	errQueueEntryNotFound = errors.New("queued transaction not found")
+	errQueueEntryState    = errors.New("queued transaction is in the wrong state")
+)
+
+// OutboundTx is a signed transaction waiting its turn in the key's outbound
+// queue. Signature and Transaction change when it is re-signed.
+type OutboundTx struct {
+	ID          string     `json:"id"`
+	KeyID       string     `json:"keyId"`
+	Cluster     string     `json:"cluster,omitempty"`
+	Signature   string     `json:"signature"`
+	Transaction string     `json:"transaction"`
+	Blockhash   string     `json:"blockhash"`
+	Status      string     `json:"status"`
+	Sends       int        `json:"sends,omitempty"`
+	Resigns     int        `json:"resigns,omitempty"`
+	Error       string     `json:"error,omitempty"`
+	QueuedAt    time.Time  `json:"queuedAt"`
+	SentAt      *time.Time `json:"sentAt,omitempty"`
+
+	// the blockhash is a durable nonce, it doesn't expire w/ the blocks
+	Nonce bool `json:"nonce,omitempty"`
+
+	// re-signs run under the tenant that queued it
+	tenant string
+
+	// the sign request, signed again w/ a fresh blockhash once the
+	// transaction's expired
+	request TransactionRequest
+}
+
+// outboundRecord is an OutboundTx as persisted, w/ what re-signing needs
+type outboundRecord struct {
+	OutboundTx
+	Tenant  string             `json:"tenant,omitempty"`
+	Request TransactionRequest `json:"request"`
+}
+
+// halt stops the key's queue at tx
+func (tx *OutboundTx) halt(reason string) {
+	tx.Status = QueueStatusHalted
+	tx.Error = reason
+}
+
+type resignCtxKey struct{}
+
+// withResign marks a sign call as the outbound queue re-signing one of its
+// transactions, only the queue sets it
+func withResign(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, resignCtxKey{}, id)
+}
+
+func resignFromContext(ctx context.Context) string {
+	id, _ := ctx.Value(resignCtxKey{}).(string)
+	return id
+}
+
+// OutboundQueue broadcasts each key's queued transactions one at a time in
+// the order they were signed, the next goes out once the one before it is
+// confirmed. A transaction the cluster doesn't pick up is sent again, and
+// re-signed through the signer w/ a fresh blockhash once its own expired.
+// One that can't land halts its key's queue. Persisted to disk so a restart
+// keeps the order.
+type OutboundQueue struct {
+	// empty keeps the queues in memory only
+	path string
+
+	// by cluster name, the default cluster's under ""
+	chains map[string]ChainClient
+
+	// signs a queued request again, the signer's SignTransaction
+	resign func(ctx context.Context, req TransactionRequest) (TransactionResult, error)
+
+	// marks sends, nil tracks nothing
+	txs *TxTracker
+
+	// by key, oldest first
+	queues map[string][]*OutboundTx
+
+	mu sync.Mutex
+}
+
+// constructor, chain is the default cluster's. Loads queued transactions
+// from path if present.
+func NewOutboundQueue(path string, chain ChainClient, resign func(context.Context, TransactionRequest) (TransactionResult, error)) (*OutboundQueue, error) {
+	q := &OutboundQueue{
+		path:   path,
+		chains: map[string]ChainClient{"": chain},
+		resign: resign,
+		queues: make(map[string][]*OutboundTx),
+	}
+	if path == "" {
+		return q, nil
+	}
+
+	raw, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return q, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read outbound queue: %w", err)
+	}
+	var records map[string][]outboundRecord
+	if err := json.Unmarshal(raw, &records); err != nil {
+		return nil, fmt.Errorf("failed to parse outbound queue: %w", err)
+	}
+	for keyID, queue := range records {
+		for _, rec := range queue {
+			tx := rec.OutboundTx
+			tx.tenant, tx.request = rec.Tenant, rec.Request
+			q.queues[keyID] = append(q.queues[keyID], &tx)
+		}
+	}
+	return q, nil
+}
+
+// AddCluster sends transactions queued for the named cluster through chain,
+// call it before Run
+func (q *OutboundQueue) AddCluster(name string, chain ChainClient) {
+	q.chains[name] = chain
+}
+
+func (q *OutboundQueue) chain(cluster string) ChainClient {
+	if chain, ok := q.chains[cluster]; ok {
+		return chain
+	}
+	return q.chains[""]
+}
+
+// Enqueue puts a transaction the signer just signed at the back of the
+// key's queue, it goes out on the next pass once those ahead of it landed
+func (q *OutboundQueue) Enqueue(ctx context.Context, req TransactionRequest, cluster string, tx *SolanaPayload, result TransactionResult) (OutboundTx, error) {
+	var id [16]byte
+	if _, err := rand.Read(id[:]); err != nil {
+		return OutboundTx{}, fmt.Errorf("failed to create queue id: %w", err)
+	}
+
+	// a re-sign is the service's own request, nothing from the caller's is reused
+	req.IdempotencyKey, req.Grant, req.Nonce, req.Timestamp, req.Queue = "", "", "", 0, false
+	entry := &OutboundTx{
+		ID:          hex.EncodeToString(id[:]),
+		KeyID:       req.KeyID,
+		Cluster:     cluster,
+		Signature:   result.TxSignature,
+		Transaction: result.Transaction,
+		Blockhash:   tx.Message.RecentBlockhash.String(),
+		Status:      QueueStatusQueued,
+		QueuedAt:    time.Now().UTC(),
+		Nonce:       usesDurableNonce(tx.Message),
+		tenant:      TenantFromContext(ctx),
+		request:     req,
+	}
+
+	q.mu.Lock()
+
+	defer q.mu.Unlock()
+
+	q.queues[req.KeyID] = append(q.queues[req.KeyID], entry)
+	q.save()
+	return *entry, nil
+}
+
+// Queue is the key's queued transactions, next to go out first
+func (q *OutboundQueue) Queue(keyID string) []OutboundTx {
+	q.mu.Lock()
+
+	defer q.mu.Unlock()
+
+	queue := make([]OutboundTx, 0, len(q.queues[keyID]))
+	for _, tx := range q.queues[keyID] {
+		queue = append(queue, *tx)
+	}
+	return queue
+}
+
+// Remove drops a transaction from the key's queue, the ones behind it move
+// up. Only a halted one or one not sent yet can be removed, one that was
+// sent may still land.
+func (q *OutboundQueue) Remove(keyID, id string) (OutboundTx, error) {
+	q.mu.Lock()
+
+	defer q.mu.Unlock()
+
+	queue := q.queues[keyID]
+	for i, tx := range queue {
+		if tx.ID != id {
+			continue
+		}
+		if tx.Status == QueueStatusSent {
+			return OutboundTx{}, fmt.Errorf("%w: it was sent and may still land", errQueueEntryState)
+		}
+		q.queues[keyID] = append(queue[:i:i], queue[i+1:]...)
+		if len(q.queues[keyID]) == 0 {
+			delete(q.queues, keyID)
+		}
+		q.save()
+		return *tx, nil
+	}
+	return OutboundTx{}, errQueueEntryNotFound
+}
+
+// Retry re-signs a halted transaction w/ a fresh blockhash and puts it back
+// in line, it stays halted when the signer refuses
+func (q *OutboundQueue) Retry(ctx context.Context, keyID, id string) (OutboundTx, error) {
+	q.mu.Lock()
+	var tx OutboundTx
+	for _, queued := range q.queues[keyID] {
+		if queued.ID == id {
+			tx = *queued
+		}
+	}
+	q.mu.Unlock()
+	if tx.ID == "" {
+		return OutboundTx{}, errQueueEntryNotFound
+	}
+	if tx.Status != QueueStatusHalted {
+		return OutboundTx{}, fmt.Errorf("%w: only a halted one is retried", errQueueEntryState)
+	}
+
+	tx.Resigns, tx.Error = 0, ""
+	if err := q.resignTx(ctx, &tx); err != nil {
+		return OutboundTx{}, err
+	}
+	q.apply(tx, false)
+	if tx.Status == QueueStatusHalted {
+		return OutboundTx{}, errors.New(tx.Error)
+	}
+	return tx, nil
+}
+
+// apply writes back what a pass did to a transaction, unless it was removed
+// meanwhile. A landed one leaves the queue.
+func (q *OutboundQueue) apply(tx OutboundTx, landed bool) {
+	q.mu.Lock()
+
+	defer q.mu.Unlock()
+
+	queue := q.queues[tx.KeyID]
+	for i, queued := range queue {
+		if queued.ID != tx.ID {
+			continue
+		}
+		if landed {
+			q.queues[tx.KeyID] = append(queue[:i:i], queue[i+1:]...)
+			if len(q.queues[tx.KeyID]) == 0 {
+				delete(q.queues, tx.KeyID)
+			}
+		} else {
+			*queued = tx
+		}
+		q.save()
+		return
+	}
+}
+
+// Process moves every key's queue along once
+func (q *OutboundQueue) Process(ctx context.Context) error {
+	q.mu.Lock()
+	keys := make([]string, 0, len(q.queues))
+	for keyID := range q.queues {
+		keys = append(keys, keyID)
+	}
+	q.mu.Unlock()
+
+	var errs []error
+	for _, keyID := range keys {
+		// one key's cluster being down doesn't hold up the others
+		if err := q.advance(ctx, keyID); err != nil {
+			errs = append(errs, fmt.Errorf("key %s: %w", keyID, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// advance works on the head of the key's queue, and on the next one as
+// soon as the head lands
+func (q *OutboundQueue) advance(ctx context.Context, keyID string) error {
+	for {
+		q.mu.Lock()
+		var head OutboundTx
+		if queue := q.queues[keyID]; len(queue) > 0 {
+			head = *queue[0]
+		}
+		q.mu.Unlock()
+		if head.ID == "" || head.Status == QueueStatusHalted {
+			return nil
+		}
+		chain := q.chain(head.Cluster)
+		if chain == nil {
+			return errNoRPC
+		}
+
+		landed, err := q.step(ctx, chain, &head)
+		q.apply(head, landed)
+		if head.Status == QueueStatusHalted {
+			slog.WarnContext(ctx, "Outbound queue halted", logSigner, "key_id", keyID, "queue_id", head.ID, "signature", head.Signature, "reason", head.Error)
+		}
+		if err != nil || !landed {
+			return err
+		}
+		slog.InfoContext(ctx, "Queued transaction landed", logSigner, "key_id", keyID, "queue_id", head.ID, "signature", head.Signature)
+	}
+}
+
+// step sends the transaction or checks on the one sent, landed says it was
+// confirmed and the next may go
+func (q *OutboundQueue) step(ctx context.Context, chain ChainClient, tx *OutboundTx) (bool, error) {
+	if tx.Status == QueueStatusQueued {
+		return false, q.send(ctx, chain, tx)
+	}
+
+	statuses, err := chain.SignatureStatuses(ctx, []string{tx.Signature})
+	if err != nil {
+		return false, err
+	}
+	switch status := statuses[0]; {
+	case status == nil:
+		if tx.SentAt != nil && time.Since(*tx.SentAt) < outboundResendAfter {
+			return false, nil
+		}
+		// a nonce transaction doesn't expire while its nonce is unused
+		if !tx.Nonce {
+			valid, err := chain.BlockhashValid(ctx, tx.Blockhash)
+			if err != nil {
+				return false, err
+			}
+			if !valid {
+				if err := q.resignTx(ctx, tx); err != nil {
+					return false, err
+				}
+			}
+		}
+		// not seen yet but can still land, sending again is safe
+		return false, q.send(ctx, chain, tx)
+	case status.Failed():
+		tx.halt(fmt.Sprintf("failed on chain: %s", status.Err))
+		return false, nil
+	default:
+		return txStatusRank[status.ConfirmationStatus] >= txStatusRank[TxStatusConfirmed], nil
+	}
+}
+
+// send broadcasts the transaction once, a transient failure is tried again
+// next pass
+func (q *OutboundQueue) send(ctx context.Context, chain ChainClient, tx *OutboundTx) error {
+	if tx.Status == QueueStatusHalted {
+		return nil
+	}
+	wire, _ := base64.StdEncoding.DecodeString(tx.Transaction)
+	_, err := chain.SendTransaction(ctx, wire)
+	if err != nil && blockhashExpired(err) {
+		if err := q.resignTx(ctx, tx); err != nil || tx.Status == QueueStatusHalted {
+			return err
+		}
+		wire, _ = base64.StdEncoding.DecodeString(tx.Transaction)
+		_, err = chain.SendTransaction(ctx, wire)
+	}
+	switch {
+	case err == nil:
+	case transientRPCError(err):
+		return err
+	case tx.Sends > 0:
+		// sent before, it may have landed meanwhile and preflight refuses it
+		// as processed, the next status check tells
+		return err
+	default:
+		tx.halt(fmt.Sprintf("rejected: %v", err))
+		return nil
+	}
+
+	now := time.Now().UTC()
+	tx.Status, tx.SentAt = QueueStatusSent, &now
+	tx.Sends++
+	q.txs.Track(tx.Signature, tx.KeyID, tx.Cluster, tx.Blockhash)
+	return nil
+}
+
+// resignTx signs the transaction's request again w/ a fresh blockhash, or
+// the nonce account's current nonce. The signer refusing halts the queue.
+func (q *OutboundQueue) resignTx(ctx context.Context, tx *OutboundTx) error {
+	if tx.Resigns >= outboundMaxResigns {
+		tx.halt(fmt.Sprintf("blockhash expired after %d re-signs", tx.Resigns))
+		return nil
+	}
+
+	req := tx.request
+	req.RefreshBlockhash = req.NonceAccount == ""
+	res, err := q.resign(withResign(WithTenant(ctx, tx.tenant), tx.ID), req)
+	if errors.Is(err, errRPCUnavailable) {
+		return err
+	}
+	if err != nil {
+		tx.halt(fmt.Sprintf("re-sign refused: %v", err))
+		return nil
+	}
+
+	slog.InfoContext(ctx, "Re-signed queued transaction", logSigner, "key_id", tx.KeyID, "queue_id", tx.ID, "expired", tx.Signature, "signature", res.TxSignature)
+	q.txs.Replaced(tx.Signature)
+	tx.Signature, tx.Transaction, tx.Blockhash = res.TxSignature, res.Transaction, res.Blockhash
+	tx.Status, tx.SentAt, tx.Sends = QueueStatusQueued, nil, 0
+	tx.Resigns++
+	return nil
+}
+
+// Run moves the queues along every interval until ctx is done
+func (q *OutboundQueue) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := q.Process(ctx); err != nil {
+				slog.Warn("Outbound queue pass failed", logSigner, "err", err)
+			}
+		}
+	}
+}
+
+// save writes the queues atomically, must be called w/ mu held
+func (q *OutboundQueue) save() {
+	if q.path == "" {
+		return
+	}
+
+	records := make(map[string][]outboundRecord, len(q.queues))
+	for keyID, queue := range q.queues {
+		for _, tx := range queue {
+			records[keyID] = append(records[keyID], outboundRecord{OutboundTx: *tx, Tenant: tx.tenant, Request: tx.request})
+		}
+	}
+	raw, err := json.Marshal(records)
+	if err != nil {
+		slog.Error("Failed to encode outbound queue", logSigner, "err", err)
+		return
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(q.path), ".outbound-*")
+	if err != nil {
+		slog.Error("Failed to persist outbound queue", logSigner, "err", err)
+		return
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(raw); err != nil {
+		tmp.Close()
+		slog.Error("Failed to persist outbound queue", logSigner, "err", err)
+		return
+	}
+	if err := tmp.Close(); err != nil {
+		slog.Error("Failed to persist outbound queue", logSigner, "err", err)
+		return
+	}
+	if err := os.Rename(tmp.Name(), q.path); err != nil {
+		slog.Error("Failed to persist outbound queue", logSigner, "err", err)
+	}
+}
+
+// queueErrorStatus is the status for a queue operation that failed
+func queueErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, errQueueEntryNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, errQueueEntryState):
+		return http.StatusConflict
+	case errors.Is(err, errRPCUnavailable):
+		return http.StatusBadGateway
+	default:
+		return http.StatusBadRequest
+	}
+}
+
+// handleQueueList serves a key's outbound queue to operators and API tokens
+// scoped to read the key
+func (s *APIServer) handleQueueList(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+	if OperatorFromContext(r.Context()).Name == "" {
+		if err := s.checkAPIToken(r, TokenOpRead, id); err != nil {
+			refuseAPIToken(w, r, err)
+			return
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(s.Outbound.Queue(id))
+}
+
+// handleQueueRetry re-signs a halted transaction so the key's queue moves
+// again, for operators and callers allowed to sign w/ the key
+func (s *APIServer) handleQueueRetry(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+	if OperatorFromContext(r.Context()).Name == "" {
+		if err := s.checkAPIToken(r, TokenOpSign, id); err != nil {
+			refuseAPIToken(w, r, err)
+			return
+		}
+	}
+
+	tx, err := s.Outbound.Retry(r.Context(), id, r.PathValue("entry"))
+	if err != nil {
+		writeError(w, r, queueErrorStatus(err), err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(tx)
+}
+
+// handleQueueRemove drops a halted or unsent transaction from a key's queue
+func (s *APIServer) handleQueueRemove(w http.ResponseWriter, r *http.Request) {
+	tx, err := s.Outbound.Remove(r.PathValue("id"), r.PathValue("entry"))
+	if err != nil {
+		writeError(w, r, queueErrorStatus(err), err)
+		return
+	}
+	audit(r.Context(), logAdmin, "Queued transaction removed", "operator", OperatorFromContext(r.Context()).Name, "key_id", tx.KeyID, "queue_id", tx.ID, "signature", tx.Signature)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(tx)
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"fmt"
+	"math/big"
+)
+
+// P-256 signatures are ES256: ECDSA over the SHA-256 of the message, output
+// either ASN.1/DER or fixed size r||s
+const (
+	SigningModeP256DER = "p256-der"
+	SigningModeP256Raw = "p256-raw"
+)
+
+// generateP256 returns the uncompressed SEC1 public key and the 32 byte scalar
+func generateP256() (pubKey, privKey []byte, err error) {
+	priv, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer wipeP256(priv)
+
+	pubKey, err = priv.PublicKey.Bytes()
+	if err != nil {
+		return nil, nil, err
+	}
+	privKey, err = priv.Bytes()
+	if err != nil {
+		return nil, nil, err
+	}
+	return pubKey, privKey, nil
+}
+
+func signP256(privKey, msg []byte, mode string) ([]byte, string, error) {
+	priv, err := ecdsa.ParseRawPrivateKey(elliptic.P256(), privKey)
+	if err != nil {
+		return nil, "", fmt.Errorf("invalid p256 key: %w", err)
+	}
+	defer wipeP256(priv)
+
+	digest := sha256.Sum256(msg)
+
+	switch mode {
+	case "", SigningModeP256DER:
+		sig, err := ecdsa.SignASN1(rand.Reader, priv, digest[:])
+		return sig, SigningModeP256DER, err
+	case SigningModeP256Raw:
+		r, s, err := ecdsa.Sign(rand.Reader, priv, digest[:])
+		if err != nil {
+			return nil, "", err
+		}
+		sig := make([]byte, 64)
+		r.FillBytes(sig[:32])
+		s.FillBytes(sig[32:])
+		return sig, SigningModeP256Raw, nil
+	default:
+		return nil, "", fmt.Errorf("signing mode %s is not supported for %s keys", mode, KeyTypeP256)
+	}
+}
+
+// wipeP256 clears the scalar copy a parsed key holds, the store's bytes are
+// the only copy that should outlive a sign
+func wipeP256(priv *ecdsa.PrivateKey) {
+	if priv.D != nil {
+		clear(priv.D.Bits())
+	}
+}
+
+// verifyP256 accepts uncompressed (65 byte) or compressed (33 byte) keys
+func verifyP256(pubKey, msg, sig []byte, mode string) (bool, error) {
+	var pub *ecdsa.PublicKey
+	switch len(pubKey) {
+	case 65:
+		parsed, err := ecdsa.ParseUncompressedPublicKey(elliptic.P256(), pubKey)
+		if err != nil {
+			return false, fmt.Errorf("invalid p256 public key: %w", err)
+		}
+		pub = parsed
+	case 33:
+		x, y := elliptic.UnmarshalCompressed(elliptic.P256(), pubKey)
+		if x == nil {
+			return false, fmt.Errorf("invalid p256 public key")
+		}
+		pub = &ecdsa.PublicKey{Curve: elliptic.P256(), X: x, Y: y}
+	default:
+		return false, fmt.Errorf("p256 public key must be 33 or 65 bytes SEC1")
+	}
+
+	digest := sha256.Sum256(msg)
+
+	switch mode {
+	case "", SigningModeP256DER:
+		return ecdsa.VerifyASN1(pub, digest[:], sig), nil
+	case SigningModeP256Raw:
+		if len(sig) != 64 {
+			return false, nil
+		}
+		r := new(big.Int).SetBytes(sig[:32])
+		s := new(big.Int).SetBytes(sig[32:])
+		return ecdsa.Verify(pub, digest[:], r, s), nil
+	default:
+		return false, fmt.Errorf("signing mode %s is not supported for %s keys", mode, KeyTypeP256)
+	}
+}
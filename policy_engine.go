@@ -0,0 +1,103 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+)
+
+var errPolicyViolation = errors.New("signing policy violation")
+
+// CheckTransaction enforces the key's transaction rules before a signature
+// is produced. tx is nil when the payload isn't a Solana transaction.
+func (p KeyPolicy) CheckTransaction(tx *SolanaPayload) error {
+	if len(p.AllowedPrograms) == 0 && len(p.AllowedDestinations) == 0 && !p.tracksSpend() && !p.Staking.limitsValidators() {
+		return nil
+	}
+
+	// rules are about instructions, so opaque payloads can't be allowed
+	if tx == nil {
+		return fmt.Errorf("%w: key only signs Solana transactions (context %s)", errPolicyViolation, SolanaTxContext)
+	}
+
+	if err := p.checkPrograms(tx.Message); err != nil {
+		return err
+	}
+	if err := p.Staking.checkDelegations(tx.Message); err != nil {
+		return err
+	}
+	return p.checkDestinations(tx.Message)
+}
+
+func (p KeyPolicy) checkPrograms(msg *SolanaMessage) error {
+	if len(p.AllowedPrograms) == 0 {
+		return nil
+	}
+
+	allowed, err := pubkeySet(p.AllowedPrograms)
+	if err != nil {
+		return err
+	}
+
+	for i, ix := range msg.Instructions {
+		program := msg.AccountKeys[ix.ProgramIDIndex]
+		if !allowed[program] {
+			return fmt.Errorf("%w: instruction %d calls program %s which is not allowlisted", errPolicyViolation, i, program)
+		}
+	}
+	return nil
+}
+
+func (p KeyPolicy) checkDestinations(msg *SolanaMessage) error {
+	if len(p.AllowedDestinations) == 0 {
+		return nil
+	}
+
+	allowed, err := pubkeySet(p.AllowedDestinations)
+	if err != nil {
+		return err
+	}
+
+	transfers, err := DecodeSolanaTransfers(msg)
+	if err != nil {
+		return fmt.Errorf("%w: %v", errPolicyViolation, err)
+	}
+
+	for _, t := range transfers {
+		if allowed[t.Destination] || p.isAllowedTokenAccount(allowed, t) {
+			continue
+		}
+		if t.Kind == TransferAuthority {
+			return fmt.Errorf("%w: instruction %d hands an account to %s which is not an allowed destination", errPolicyViolation, t.Instruction, t.Destination)
+		}
+		return fmt.Errorf("%w: instruction %d sends to %s which is not an allowed destination", errPolicyViolation, t.Instruction, t.Destination)
+	}
+	return nil
+}
+
+// isAllowedTokenAccount accepts the associated token account of an
+// allowlisted wallet when the instruction names the mint
+func (p KeyPolicy) isAllowedTokenAccount(allowed map[SolanaPubkey]bool, t SolanaTransfer) bool {
+	if t.Mint == (SolanaPubkey{}) || !isTokenProgram(t.Program) {
+		return false
+	}
+
+	for wallet := range allowed {
+		ata, err := AssociatedTokenAddress(wallet, t.Mint, t.Program)
+		if err == nil && ata == t.Destination {
+			return true
+		}
+	}
+	return false
+}
+
+func pubkeySet(addresses []string) (map[SolanaPubkey]bool, error) {
+	set := make(map[SolanaPubkey]bool, len(addresses))
+	for _, addr := range addresses {
+		pk, err := ParseSolanaPubkey(addr)
+		if err != nil {
+			return nil, err
+		}
+		set[pk] = true
+	}
+	return set, nil
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"sync"
+	"time"
+)
+
+var errRateLimited = errors.New("key signing rate limit exceeded")
+
+// KeyRateLimit is a token bucket on a key's signatures, e.g. 5 per 1m
+type KeyRateLimit struct {
+	Signatures int `json:"signatures"`
+
+	// refill period as a Go duration
+	Per string `json:"per"`
+
+	// signatures allowed back to back, defaults to Signatures
+	Burst int `json:"burst,omitempty"`
+}
+
+func (l KeyRateLimit) Validate() error {
+	if l.Signatures <= 0 {
+		return errors.New("rate limit signatures must be greater than zero")
+	}
+	if per, err := time.ParseDuration(l.Per); err != nil || per <= 0 {
+		return errors.New("rate limit per must be a positive duration")
+	}
+	if l.Burst < 0 {
+		return errors.New("rate limit burst cannot be negative")
+	}
+	return nil
+}
+
+func (l KeyRateLimit) capacity() float64 {
+	if l.Burst > 0 {
+		return float64(l.Burst)
+	}
+	return float64(l.Signatures)
+}
+
+// tokens added per second
+func (l KeyRateLimit) rate() float64 {
+	per, _ := time.ParseDuration(l.Per)
+	return float64(l.Signatures) / per.Seconds()
+}
+
+type tokenBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// KeyRateLimiter keeps a token bucket per key ID
+type KeyRateLimiter struct {
+	buckets map[string]*tokenBucket
+
+	mu sync.Mutex
+}
+
+// constructor
+func NewKeyRateLimiter() *KeyRateLimiter {
+	return &KeyRateLimiter{buckets: make(map[string]*tokenBucket)}
+}
+
+// Take spends one token from the key's bucket
+func (r *KeyRateLimiter) Take(keyID string, limit KeyRateLimit, now time.Time) error {
+	r.mu.Lock()
+
+	defer r.mu.Unlock()
+
+	b, ok := r.buckets[keyID]
+	if !ok {
+		b = &tokenBucket{tokens: limit.capacity(), last: now}
+		r.buckets[keyID] = b
+	}
+
+	if wait := b.take(limit.capacity(), limit.rate(), now); wait > 0 {
+		return fmt.Errorf("%w: retry in %s", errRateLimited, wait.Round(time.Second))
+	}
+	return nil
+}
+
+// take spends one token, or returns how long until one is available
+func (b *tokenBucket) take(capacity, rate float64, now time.Time) time.Duration {
+	// refill for the time since the last take, capped at the burst size
+	b.tokens = math.Min(capacity, b.tokens+now.Sub(b.last).Seconds()*rate)
+	b.last = now
+
+	if b.tokens < 1 {
+		return time.Duration((1 - b.tokens) / rate * float64(time.Second))
+	}
+	b.tokens--
+	return 0
+}
+
+// Forget drops the bucket of a destroyed key
+func (r *KeyRateLimiter) Forget(keyID string) {
+	r.mu.Lock()
+
+	defer r.mu.Unlock()
+
+	delete(r.buckets, keyID)
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// how far a request timestamp may be from our clock, nonces are remembered
+// for twice this so nothing inside the window can be replayed
+const maxRequestSkew = 5 * time.Minute
+
+var errReplay = errors.New("replayed or stale sign request")
+
+// ReplayGuard remembers recently seen request nonces per key
+type ReplayGuard struct {
+	// refuse requests w/o a nonce and timestamp
+	required bool
+
+	seen map[string]time.Time
+
+	// seen nonces oldest first, so expired ones are dropped from the front
+	// w/o scanning the map
+	order []seenNonce
+
+	mu sync.Mutex
+}
+
+type seenNonce struct {
+	key string
+	at  time.Time
+}
+
+// constructor
+func NewReplayGuard(required bool) *ReplayGuard {
+	return &ReplayGuard{required: required, seen: make(map[string]time.Time)}
+}
+
+// Check accepts each keyID/nonce pair once while its timestamp is fresh
+func (g *ReplayGuard) Check(keyID, nonce string, timestamp int64, now time.Time) error {
+	if nonce == "" && timestamp == 0 {
+		if g.required {
+			return fmt.Errorf("%w: nonce and timestamp are required", errReplay)
+		}
+		return nil
+	}
+	if nonce == "" || len(nonce) > 128 {
+		return fmt.Errorf("%w: nonce must be 1-128 characters", errReplay)
+	}
+
+	sent := time.Unix(timestamp, 0)
+	if sent.Before(now.Add(-maxRequestSkew)) || sent.After(now.Add(maxRequestSkew)) {
+		return fmt.Errorf("%w: timestamp outside the allowed %s skew", errReplay, maxRequestSkew)
+	}
+
+	g.mu.Lock()
+
+	defer g.mu.Unlock()
+
+	for len(g.order) > 0 && now.Sub(g.order[0].at) > 2*maxRequestSkew {
+		// a nonce seen again after it expired has its own, later entry
+		if oldest := g.order[0]; g.seen[oldest.key].Equal(oldest.at) {
+			delete(g.seen, oldest.key)
+		}
+		g.order = g.order[1:]
+	}
+
+	// scoped to the key so clients can't collide across wallets
+	k := keyID + "/" + nonce
+	if _, ok := g.seen[k]; ok {
+		return fmt.Errorf("%w: nonce already used", errReplay)
+	}
+	g.seen[k] = now
+	g.order = append(g.order, seenNonce{key: k, at: now})
+	return nil
+}
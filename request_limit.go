@@ -0,0 +1,180 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// RequestLimits caps requests per second across the server and per client
+type RequestLimits struct {
+	GlobalRPS   float64
+	GlobalBurst int
+
+	ClientRPS   float64
+	ClientBurst int
+}
+
+// RequestLimitsFromEnv reads STS_RATE_LIMIT_GLOBAL_RPS (default 500) and
+// STS_RATE_LIMIT_CLIENT_RPS (default 50), bursts default to twice the rate.
+// STS_RATE_LIMIT_GLOBAL_BURST and STS_RATE_LIMIT_CLIENT_BURST override them.
+func RequestLimitsFromEnv(getenv func(string) string) (RequestLimits, error) {
+	limits := RequestLimits{GlobalRPS: 500, ClientRPS: 50}
+
+	for _, f := range []struct {
+		name string
+		rps  *float64
+	}{
+		{"STS_RATE_LIMIT_GLOBAL_RPS", &limits.GlobalRPS},
+		{"STS_RATE_LIMIT_CLIENT_RPS", &limits.ClientRPS},
+	} {
+		if raw := getenv(f.name); raw != "" {
+			v, err := strconv.ParseFloat(raw, 64)
+			if err != nil || v <= 0 {
+				return RequestLimits{}, fmt.Errorf("%s must be a positive number", f.name)
+			}
+			*f.rps = v
+		}
+	}
+	limits.GlobalBurst = int(2 * limits.GlobalRPS)
+	limits.ClientBurst = int(2 * limits.ClientRPS)
+
+	for _, f := range []struct {
+		name  string
+		burst *int
+	}{
+		{"STS_RATE_LIMIT_GLOBAL_BURST", &limits.GlobalBurst},
+		{"STS_RATE_LIMIT_CLIENT_BURST", &limits.ClientBurst},
+	} {
+		if raw := getenv(f.name); raw != "" {
+			v, err := strconv.Atoi(raw)
+			if err != nil || v < 1 {
+				return RequestLimits{}, fmt.Errorf("%s must be a positive integer", f.name)
+			}
+			*f.burst = v
+		}
+	}
+	return limits, nil
+}
+
+// RequestLimiter keeps a global token bucket and one per client
+type RequestLimiter struct {
+	limits RequestLimits
+
+	global  *tokenBucket
+	clients map[string]*tokenBucket
+	swept   time.Time
+
+	mu sync.Mutex
+}
+
+// constructor
+func NewRequestLimiter(limits RequestLimits) *RequestLimiter {
+	return &RequestLimiter{
+		limits:  limits,
+		global:  &tokenBucket{tokens: float64(limits.GlobalBurst), last: time.Now()},
+		clients: make(map[string]*tokenBucket),
+	}
+}
+
+// SetLimits swaps the rates and bursts, buckets refill to the new burst
+// as they are next used
+func (l *RequestLimiter) SetLimits(limits RequestLimits) {
+	l.mu.Lock()
+
+	defer l.mu.Unlock()
+
+	l.limits = limits
+	l.global.tokens = min(l.global.tokens, float64(limits.GlobalBurst))
+	for _, b := range l.clients {
+		b.tokens = min(b.tokens, float64(limits.ClientBurst))
+	}
+}
+
+// Validate refuses rates and bursts the limiter can't work w/
+func (limits RequestLimits) Validate() error {
+	if limits.GlobalRPS <= 0 || limits.ClientRPS <= 0 {
+		return errors.New("rate limits must be positive")
+	}
+	if limits.GlobalBurst < 1 || limits.ClientBurst < 1 {
+		return errors.New("rate limit bursts must be at least 1")
+	}
+	return nil
+}
+
+// Allow takes a token for client and the server, it returns how long to wait
+// when either bucket is empty
+func (l *RequestLimiter) Allow(client string, now time.Time) time.Duration {
+	l.mu.Lock()
+
+	defer l.mu.Unlock()
+
+	l.sweep(now)
+
+	b, ok := l.clients[client]
+	if !ok {
+		b = &tokenBucket{tokens: float64(l.limits.ClientBurst), last: now}
+		l.clients[client] = b
+	}
+	if wait := b.take(float64(l.limits.ClientBurst), l.limits.ClientRPS, now); wait > 0 {
+		return wait
+	}
+
+	if wait := l.global.take(float64(l.limits.GlobalBurst), l.limits.GlobalRPS, now); wait > 0 {
+		// the client didn't get served, give its token back
+		b.tokens++
+		return wait
+	}
+	return 0
+}
+
+// sweep drops buckets that have refilled, must be called w/ mu held
+func (l *RequestLimiter) sweep(now time.Time) {
+	if now.Sub(l.swept) < time.Minute {
+		return
+	}
+	l.swept = now
+
+	full := time.Duration(float64(l.limits.ClientBurst) / l.limits.ClientRPS * float64(time.Second))
+	for client, b := range l.clients {
+		if now.Sub(b.last) > full {
+			delete(l.clients, client)
+		}
+	}
+}
+
+// requestClient names the caller for rate limiting, a valid API token's id
+// when one is sent and the peer address otherwise, so made up tokens can't
+// dodge the per client limit
+func (s *APIServer) requestClient(r *http.Request) string {
+	if token := r.Header.Get("X-API-Key"); token != "" && s.Tokens != nil {
+		if t, err := s.Tokens.Authenticate(token, time.Now()); err == nil {
+			return "token:" + t.ID
+		}
+	}
+	return "ip:" + clientIP(r).String()
+}
+
+// withRateLimit answers 429 w/ Retry-After once a client or the server is over its limit
+func (s *APIServer) withRateLimit(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if s.Limiter == nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		client := s.requestClient(r)
+		if wait := s.Limiter.Allow(client, time.Now()); wait > 0 {
+			slog.WarnContext(r.Context(), "Rate limited", logHTTP, "method", r.Method, "path", r.URL.Path, "client", client)
+			retry := strconv.Itoa(int(wait.Seconds()) + 1)
+			w.Header().Set("Retry-After", retry)
+			writeError(w, r, http.StatusTooManyRequests, withDetails(errRequestRateLimited, map[string]string{"retry_after": retry}))
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,184 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"maps"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	errBodyTooLarge   = errors.New("request body too large")
+	errRequestTimeout = errors.New("request timed out")
+)
+
+// RouteLimit overrides the body limit and handler timeout of one route. Zero
+// fields keep the route's default.
+type RouteLimit struct {
+	MaxBodyBytes int64         `yaml:"maxBodyBytes"`
+	Timeout      time.Duration `yaml:"timeout"`
+}
+
+// routes that need more than Config.MaxBodyBytes, keyed by the pattern they're
+// mounted w/ under every API version
+var defaultRouteLimits = map[string]RouteLimit{
+	// v0 transactions w/ address lookup tables, base64 encoded, plus context
+	"POST /txs/sign":           {MaxBodyBytes: 64 << 10},
+	"POST /tokens":             {MaxBodyBytes: 8192},
+	"POST /capabilities":       {MaxBodyBytes: 8192},
+	"POST /deploys":            {MaxBodyBytes: deployMaxBodySize},
+	"POST /deploys/{id}/write": {MaxBodyBytes: deployMaxBodySize},
+}
+
+// routes that hijack the connection, a timeout would cut the stream
+var streamingRoutes = []string{"GET /events"}
+
+// routeLimit is what applies to pattern, the configured override first, then
+// the route's default, then the service wide body limit. A zero timeout
+// leaves the handler to the server's write timeout.
+func (c Config) routeLimit(pattern string) RouteLimit {
+	limit := c.Routes[pattern]
+	if limit.MaxBodyBytes == 0 {
+		limit.MaxBodyBytes = defaultRouteLimits[pattern].MaxBodyBytes
+	}
+	if limit.MaxBodyBytes == 0 {
+		limit.MaxBodyBytes = c.orDefaults().MaxBodyBytes
+	}
+	return limit
+}
+
+// validateRoutes checks the per route overrides
+func (c Config) validateRoutes() error {
+	for pattern, limit := range c.Routes {
+		method, path, ok := strings.Cut(pattern, " ")
+		if !ok || method == "" || method != strings.ToUpper(method) || !strings.HasPrefix(path, "/") || strings.HasPrefix(path, "/api/") {
+			return fmt.Errorf("route %q must look like \"POST /txs/sign\", w/o the /api/v1 prefix", pattern)
+		}
+		if limit.MaxBodyBytes != 0 && (limit.MaxBodyBytes < 1024 || limit.MaxBodyBytes > 10<<20) {
+			return fmt.Errorf("route %s: max body size must be between 1KiB and 10MiB, got %d", pattern, limit.MaxBodyBytes)
+		}
+		if limit.Timeout < 0 {
+			return fmt.Errorf("route %s: timeout must be positive, got %s", pattern, limit.Timeout)
+		}
+		if limit.Timeout != 0 && slices.Contains(streamingRoutes, pattern) {
+			return fmt.Errorf("route %s streams, it can't take a timeout", pattern)
+		}
+	}
+	return nil
+}
+
+// withRouteLimit caps the body of requests to pattern and, if the route has
+// one, the time its handler gets
+func (s *APIServer) withRouteLimit(pattern string, next http.HandlerFunc) http.HandlerFunc {
+	limit := s.Config.routeLimit(pattern)
+
+	handler := func(w http.ResponseWriter, r *http.Request) {
+		// refused before reading when the client says up front it's too big
+		if r.ContentLength > limit.MaxBodyBytes {
+			writeError(w, r, http.StatusRequestEntityTooLarge, bodyTooLarge(limit.MaxBodyBytes))
+			return
+		}
+		r.Body = http.MaxBytesReader(w, r.Body, limit.MaxBodyBytes)
+		next(w, r)
+	}
+	if limit.Timeout == 0 {
+		return handler
+	}
+	return withTimeout(limit.Timeout, handler)
+}
+
+func bodyTooLarge(limit int64) error {
+	return withDetails(fmt.Errorf("%w, the limit is %d bytes", errBodyTooLarge, limit), map[string]string{"limit": strconv.FormatInt(limit, 10)})
+}
+
+// withTimeout answers 408 once timeout passes. The handler's context is
+// cancelled and whatever it writes after that is dropped.
+func withTimeout(timeout time.Duration, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx, cancel := context.WithTimeout(r.Context(), timeout)
+		defer cancel()
+
+		tw := &timeoutWriter{header: make(http.Header)}
+		done := make(chan struct{})
+		panicked := make(chan any, 1)
+		go func() {
+			defer func() {
+				if p := recover(); p != nil {
+					panicked <- p
+				}
+			}()
+			next(tw, r.WithContext(ctx))
+			close(done)
+		}()
+
+		select {
+		case p := <-panicked:
+			panic(p)
+		case <-done:
+			tw.mu.Lock()
+
+			defer tw.mu.Unlock()
+
+			maps.Copy(w.Header(), tw.header)
+			if tw.status == 0 {
+				tw.status = http.StatusOK
+			}
+			w.WriteHeader(tw.status)
+			w.Write(tw.body.Bytes())
+		case <-ctx.Done():
+			tw.mu.Lock()
+
+			defer tw.mu.Unlock()
+
+			tw.timedOut = true
+			// nobody is listening when the client went away
+			if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+				writeError(w, r, http.StatusRequestTimeout, fmt.Errorf("%w after %s", errRequestTimeout, timeout))
+			}
+		}
+	}
+}
+
+// timeoutWriter holds the response until the handler is done in time
+type timeoutWriter struct {
+	header   http.Header
+	body     bytes.Buffer
+	status   int
+	timedOut bool
+
+	mu sync.Mutex
+}
+
+func (tw *timeoutWriter) Header() http.Header { return tw.header }
+
+func (tw *timeoutWriter) Write(p []byte) (int, error) {
+	tw.mu.Lock()
+
+	defer tw.mu.Unlock()
+
+	if tw.timedOut {
+		return 0, http.ErrHandlerTimeout
+	}
+	if tw.status == 0 {
+		tw.status = http.StatusOK
+	}
+	return tw.body.Write(p)
+}
+
+func (tw *timeoutWriter) WriteHeader(status int) {
+	tw.mu.Lock()
+
+	defer tw.mu.Unlock()
+
+	if tw.timedOut || tw.status != 0 {
+		return
+	}
+	tw.status = status
+}
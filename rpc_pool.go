@@ -0,0 +1,263 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// consecutive failures that take an endpoint out of rotation
+	rpcDownAfter = 3
+
+	// how long a down endpoint is skipped before it gets traffic again
+	rpcCooldown = 30 * time.Second
+
+	// weight of the latest call in an endpoint's score
+	rpcScoreAlpha = 0.2
+
+	// endpoints scoring below this are tried after the others
+	rpcMinScore = 0.5
+)
+
+// RPCEndpointStatus is how one endpoint has been doing
+type RPCEndpointStatus struct {
+	Name      string    `json:"name"`
+	Score     float64   `json:"score"`
+	Failures  int       `json:"consecutiveFailures"`
+	Down      bool      `json:"down"`
+	LastError string    `json:"lastError,omitempty"`
+	CheckedAt time.Time `json:"checkedAt,omitempty"`
+}
+
+type rpcEndpoint struct {
+	client *SolanaRPC
+
+	// host only, the URL may carry an API key
+	name string
+
+	score     float64
+	failures  int
+	downUntil time.Time
+	lastError string
+	checkedAt time.Time
+}
+
+// RPCPool spreads calls round-robin over several endpoints and fails over
+// to the next one when an endpoint is unreachable, overloaded or behind.
+// Each endpoint is scored on its recent calls, one that keeps failing sits
+// out a cooldown. A refused transaction isn't failed over, every endpoint
+// would refuse it the same.
+type RPCPool struct {
+	endpoints []*rpcEndpoint
+	next      atomic.Uint64
+
+	mu sync.Mutex
+}
+
+// constructor, timeout is per call and per endpoint
+func NewRPCPool(urls []string, timeout time.Duration) (*RPCPool, error) {
+	p := &RPCPool{}
+	for _, raw := range urls {
+		raw = strings.TrimSpace(raw)
+		u, err := url.Parse(raw)
+		if err != nil || u.Host == "" || (u.Scheme != "http" && u.Scheme != "https") {
+			return nil, errors.New("rpc endpoints must be http or https URLs")
+		}
+		p.endpoints = append(p.endpoints, &rpcEndpoint{client: NewSolanaRPC(raw, timeout), name: u.Host, score: 1})
+	}
+	if len(p.endpoints) == 0 {
+		return nil, errNoRPC
+	}
+	return p, nil
+}
+
+// order is who gets the next call: healthy endpoints taking turns, then
+// the ones scoring low, then the down ones as a last resort
+func (p *RPCPool) order() []*rpcEndpoint {
+	start := int(p.next.Add(1) - 1)
+	now := time.Now()
+
+	p.mu.Lock()
+
+	defer p.mu.Unlock()
+
+	type ranked struct {
+		e    *rpcEndpoint
+		tier int
+	}
+	ranks := make([]ranked, len(p.endpoints))
+	for i := range p.endpoints {
+		e := p.endpoints[(start+i)%len(p.endpoints)]
+		tier := 0
+		switch {
+		case now.Before(e.downUntil):
+			tier = 2
+		case e.score < rpcMinScore:
+			tier = 1
+		}
+		ranks[i] = ranked{e: e, tier: tier}
+	}
+	sort.SliceStable(ranks, func(i, j int) bool { return ranks[i].tier < ranks[j].tier })
+
+	order := make([]*rpcEndpoint, len(ranks))
+	for i, r := range ranks {
+		order[i] = r.e
+	}
+	return order
+}
+
+// observe scores an endpoint on a call's outcome, an error that isn't the
+// endpoint's fault counts as a success
+func (p *RPCPool) observe(e *rpcEndpoint, err error) {
+	p.record(e, err != nil && transientRPCError(err), err)
+}
+
+func (p *RPCPool) record(e *rpcEndpoint, failed bool, err error) {
+	p.mu.Lock()
+
+	defer p.mu.Unlock()
+
+	e.checkedAt = time.Now().UTC()
+	if !failed {
+		if e.failures >= rpcDownAfter {
+			slog.Info("RPC endpoint is back", logSigner, "endpoint", e.name)
+		}
+		e.score = e.score*(1-rpcScoreAlpha) + rpcScoreAlpha
+		e.failures, e.downUntil, e.lastError = 0, time.Time{}, ""
+		return
+	}
+	e.score *= 1 - rpcScoreAlpha
+	e.failures++
+	e.lastError = err.Error()
+	if e.failures >= rpcDownAfter {
+		e.downUntil = time.Now().Add(rpcCooldown)
+		if e.failures == rpcDownAfter {
+			slog.Warn("RPC endpoint is down, failing over", logSigner, "endpoint", e.name, "cooldown", rpcCooldown, "err", err)
+		}
+	}
+}
+
+// poolCall runs call on endpoints in order until one answers
+func poolCall[T any](ctx context.Context, p *RPCPool, call func(*SolanaRPC) (T, error)) (T, error) {
+	var zero T
+	var err error
+	for _, e := range p.order() {
+		var out T
+		out, err = call(e.client)
+		p.observe(e, err)
+		if err == nil || !transientRPCError(err) || ctx.Err() != nil {
+			return out, err
+		}
+		slog.DebugContext(ctx, "RPC call failed, trying the next endpoint", logSigner, "endpoint", e.name, "err", err)
+	}
+	return zero, err
+}
+
+// Health is fine while any endpoint is
+func (p *RPCPool) Health(ctx context.Context) error {
+	var err error
+	for _, e := range p.order() {
+		if err = e.client.Health(ctx); err == nil {
+			return nil
+		}
+	}
+	return err
+}
+
+func (p *RPCPool) SendTransaction(ctx context.Context, tx []byte) (string, error) {
+	return poolCall(ctx, p, func(c *SolanaRPC) (string, error) { return c.SendTransaction(ctx, tx) })
+}
+
+func (p *RPCPool) SimulateTransaction(ctx context.Context, tx []byte) (SimulationResult, error) {
+	return poolCall(ctx, p, func(c *SolanaRPC) (SimulationResult, error) { return c.SimulateTransaction(ctx, tx) })
+}
+
+func (p *RPCPool) LatestBlockhash(ctx context.Context) (Blockhash, error) {
+	return poolCall(ctx, p, func(c *SolanaRPC) (Blockhash, error) { return c.LatestBlockhash(ctx) })
+}
+
+func (p *RPCPool) BlockhashValid(ctx context.Context, blockhash string) (bool, error) {
+	return poolCall(ctx, p, func(c *SolanaRPC) (bool, error) { return c.BlockhashValid(ctx, blockhash) })
+}
+
+func (p *RPCPool) SignatureStatuses(ctx context.Context, sigs []string) ([]*SignatureStatus, error) {
+	return poolCall(ctx, p, func(c *SolanaRPC) ([]*SignatureStatus, error) { return c.SignatureStatuses(ctx, sigs) })
+}
+
+func (p *RPCPool) RecentPrioritizationFees(ctx context.Context, accounts []string) ([]uint64, error) {
+	return poolCall(ctx, p, func(c *SolanaRPC) ([]uint64, error) { return c.RecentPrioritizationFees(ctx, accounts) })
+}
+
+func (p *RPCPool) Balance(ctx context.Context, address string) (uint64, error) {
+	return poolCall(ctx, p, func(c *SolanaRPC) (uint64, error) { return c.Balance(ctx, address) })
+}
+
+func (p *RPCPool) TokenBalances(ctx context.Context, owner string) ([]TokenBalance, error) {
+	return poolCall(ctx, p, func(c *SolanaRPC) ([]TokenBalance, error) { return c.TokenBalances(ctx, owner) })
+}
+
+func (p *RPCPool) GenesisHash(ctx context.Context) (string, error) {
+	return poolCall(ctx, p, func(c *SolanaRPC) (string, error) { return c.GenesisHash(ctx) })
+}
+
+func (p *RPCPool) RequestAirdrop(ctx context.Context, address string, lamports uint64) (string, error) {
+	return poolCall(ctx, p, func(c *SolanaRPC) (string, error) { return c.RequestAirdrop(ctx, address, lamports) })
+}
+
+func (p *RPCPool) TokenMint(ctx context.Context, mint string) (TokenMint, error) {
+	return poolCall(ctx, p, func(c *SolanaRPC) (TokenMint, error) { return c.TokenMint(ctx, mint) })
+}
+
+func (p *RPCPool) NonceAccount(ctx context.Context, address string) (NonceAccount, error) {
+	return poolCall(ctx, p, func(c *SolanaRPC) (NonceAccount, error) { return c.NonceAccount(ctx, address) })
+}
+
+// Endpoints reports every endpoint's health
+func (p *RPCPool) Endpoints() []RPCEndpointStatus {
+	p.mu.Lock()
+
+	defer p.mu.Unlock()
+
+	now := time.Now()
+	statuses := make([]RPCEndpointStatus, len(p.endpoints))
+	for i, e := range p.endpoints {
+		statuses[i] = RPCEndpointStatus{Name: e.name, Score: e.score, Failures: e.failures, Down: now.Before(e.downUntil), LastError: e.lastError, CheckedAt: e.checkedAt}
+	}
+	return statuses
+}
+
+// handleRPCEndpoints shows operators how each endpoint is doing
+func (s *APIServer) handleRPCEndpoints(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(s.RPC.Endpoints())
+}
+
+// Run health checks every endpoint each interval until ctx is done, so a
+// down endpoint is noticed, and a recovered one let back in, w/o waiting
+// for traffic to find out
+func (p *RPCPool) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			for _, e := range p.endpoints {
+				// a node that is behind fails getHealth, that counts too
+				err := e.client.Health(ctx)
+				p.record(e, err != nil, err)
+			}
+		}
+	}
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/btcsuite/btcd/btcec/v2"
+	"github.com/btcsuite/btcd/btcec/v2/schnorr"
+)
+
+// BIP-340 signs 32 byte digests, typically a tagged hash such as a Taproot
+// sighash which already carries its own domain separation
+const SigningModeBIP340 = "bip340"
+
+// generateSecp256k1 returns the x-only public key and the 32 byte scalar
+func generateSecp256k1() (pubKey, privKey []byte, err error) {
+	priv, err := btcec.NewPrivateKey()
+	if err != nil {
+		return nil, nil, err
+	}
+	defer priv.Zero()
+
+	return schnorr.SerializePubKey(priv.PubKey()), priv.Serialize(), nil
+}
+
+func signBIP340(privKey, digest []byte) ([]byte, error) {
+	if len(digest) != 32 {
+		return nil, fmt.Errorf("bip340 expects a 32 byte digest, got %d bytes", len(digest))
+	}
+
+	priv, _ := btcec.PrivKeyFromBytes(privKey)
+	defer priv.Zero()
+
+	sig, err := schnorr.Sign(priv, digest)
+	if err != nil {
+		return nil, err
+	}
+	return sig.Serialize(), nil
+}
+
+func verifyBIP340(pubKey, digest, sig []byte) (bool, error) {
+	if len(pubKey) != schnorr.PubKeyBytesLen {
+		return false, fmt.Errorf("bip340 public key must be %d byte x-only", schnorr.PubKeyBytesLen)
+	}
+	if len(digest) != 32 {
+		return false, errors.New("bip340 expects a 32 byte digest")
+	}
+
+	pub, err := schnorr.ParsePubKey(pubKey)
+	if err != nil {
+		return false, fmt.Errorf("invalid bip340 public key: %w", err)
+	}
+
+	parsed, err := schnorr.ParseSignature(sig)
+	if err != nil {
+		// malformed signatures are simply invalid
+		return false, nil
+	}
+	return parsed.Verify(digest, pub), nil
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+)
+
+var (
+	errSealed    = errors.New("service is sealed")
+	errNotSealed = errors.New("not sealed")
+)
+
+// why a seal was put in place, returned to clients as details.cause
+const (
+	SealCauseMaintenance = "maintenance"
+	SealCauseKillSwitch  = "kill_switch"
+	SealCauseDeadMan     = "dead_man_switch"
+)
+
+type SealInfo struct {
+	// empty when the whole service is sealed
+	Namespace string    `json:"namespace,omitempty"`
+	Cause     string    `json:"cause"`
+	Reason    string    `json:"reason"`
+	SealedBy  string    `json:"sealedBy"`
+	SealedAt  time.Time `json:"sealedAt"`
+}
+
+type SealStatus struct {
+	Sealed     bool       `json:"sealed"`
+	Service    *SealInfo  `json:"service,omitempty"`
+	Namespaces []SealInfo `json:"namespaces"`
+}
+
+// SealState refuses signing and key generation for the whole service or for
+// single namespaces, e.g. after the kill switch was pulled
+type SealState struct {
+	service    *SealInfo
+	namespaces map[string]SealInfo
+
+	mu sync.RWMutex
+}
+
+// constructor
+func NewSealState() *SealState {
+	return &SealState{namespaces: make(map[string]SealInfo)}
+}
+
+// Seal seals one namespace, or the whole service when namespace is empty
+func (s *SealState) Seal(namespace, cause, reason, by string) SealInfo {
+	s.mu.Lock()
+
+	defer s.mu.Unlock()
+
+	info := SealInfo{Namespace: namespace, Cause: cause, Reason: reason, SealedBy: by, SealedAt: time.Now()}
+	if namespace == "" {
+		s.service = &info
+	} else {
+		s.namespaces[namespace] = info
+	}
+
+	audit(context.Background(), logAdmin, "Sealed", "namespace", namespace, "operator", by, "cause", cause, "reason", reason)
+	return info
+}
+
+// Unseal lifts the seal on one namespace, or on the whole service when
+// namespace is empty. Keys wiped when it was sealed stay gone.
+func (s *SealState) Unseal(namespace, by string) (SealInfo, error) {
+	s.mu.Lock()
+
+	defer s.mu.Unlock()
+
+	var info SealInfo
+	if namespace == "" {
+		if s.service == nil {
+			return SealInfo{}, errNotSealed
+		}
+		info = *s.service
+		s.service = nil
+	} else {
+		var ok bool
+		if info, ok = s.namespaces[namespace]; !ok {
+			return SealInfo{}, fmt.Errorf("%w: namespace %s", errNotSealed, namespace)
+		}
+		delete(s.namespaces, namespace)
+	}
+
+	audit(context.Background(), logAdmin, "Unsealed", "namespace", namespace, "operator", by, "sealed_by", info.SealedBy, "sealed_at", info.SealedAt.UTC())
+	return info, nil
+}
+
+// Check returns errSealed if the namespace can't be used right now, w/ the
+// cause in its details so clients can tell maintenance from an incident
+func (s *SealState) Check(namespace string) error {
+	s.mu.RLock()
+
+	defer s.mu.RUnlock()
+
+	if s.service != nil {
+		return withDetails(fmt.Errorf("%w: %s", errSealed, s.service.Reason), map[string]string{"cause": s.service.Cause})
+	}
+	if info, ok := s.namespaces[namespace]; ok {
+		return withDetails(fmt.Errorf("%w: namespace %s: %s", errSealed, namespace, info.Reason), map[string]string{"cause": info.Cause, "namespace": namespace})
+	}
+	return nil
+}
+
+func (s *SealState) Status() SealStatus {
+	s.mu.RLock()
+
+	defer s.mu.RUnlock()
+
+	status := SealStatus{Sealed: s.service != nil, Namespaces: []SealInfo{}}
+	if s.service != nil {
+		info := *s.service
+		status.Service = &info
+	}
+	for _, info := range s.namespaces {
+		status.Namespaces = append(status.Namespaces, info)
+	}
+	sort.Slice(status.Namespaces, func(i, j int) bool { return status.Namespaces[i].Namespace < status.Namespaces[j].Namespace })
+	return status
+}
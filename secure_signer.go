@@ -1,72 +1,442 @@
 package main
 
 import (
-	"context"         // Best practice for request-scoped data, like timeouts
-	"crypto/ed25519"  // For Solana-style keys
-	"crypto/rand"     // For cryptographically secure randomness
+	"cmp"
+	"context"        // Best practice for request-scoped data, like timeouts
+	"crypto/ed25519" // For Solana-style keys
+	"crypto/fips140"
 	"encoding/base64" // For base64 encoding/decoding
-	"encoding/hex"    // To create a clean string ID from the public key bytes
+	"encoding/hex"
 	"errors"
 	"fmt"
-	"log"
+	"log/slog"
+	"strings"
 	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 )
 
 type Account struct {
-	PublicKey string `json:"publickey"`
+	PublicKey string    `json:"publickey"`
+	KeyType   string    `json:"keyType"`
+	Namespace string    `json:"namespace"`
+	Policy    KeyPolicy `json:"policy"`
+
+	// signed proof the key was generated here, set when an attester is configured
+	Attestation *KeyAttestation `json:"attestation,omitempty"`
+}
+
+type KeyGenRequest struct {
+	// ed25519 (default), secp256k1 or p256
+	KeyType string `json:"keyType,omitempty"`
+
+	// defaults to single-use when omitted
+	Policy *KeyPolicy `json:"policy,omitempty"`
+
+	// scope for namespace wide kill switches, defaults to the tenant
+	Namespace string `json:"namespace,omitempty"`
+
+	// retries w/ the same key get the original key, also read from the Idempotency-Key header
+	IdempotencyKey string `json:"idempotencyKey,omitempty"`
 }
 
 type TransactionRequest struct {
 	KeyID          string `json:"keyId"`
 	UnsignedTxData string `json:"unsignedTxData"`
+
+	// ed25519 (default) or ed25519ph, for ph UnsignedTxData is the SHA-512 digest.
+	// secp256k1 keys always sign bip340 over a 32 byte digest, p256 keys sign
+	// ES256 w/ p256-der (default) or p256-raw output.
+	SigningMode string `json:"signingMode,omitempty"`
+
+	// purpose bound into the signature (Ed25519ctx), required
+	Context string `json:"context"`
+
+	// retries w/ the same key get the original result, also read from the Idempotency-Key header
+	IdempotencyKey string `json:"idempotencyKey,omitempty"`
+
+	// submit to the configured Solana RPC after signing, needs the solana-tx context
+	Broadcast bool `json:"broadcast,omitempty"`
+
+	// broadcast through the key's outbound queue instead, after the key's
+	// earlier queued transactions landed, re-signed w/ a fresh blockhash if
+	// it expires waiting. Excludes broadcast.
+	Queue bool `json:"queue,omitempty"`
+
+	// processed, confirmed or finalized: the transaction webhook is told
+	// once the signed transaction reaches it on chain or fails first,
+	// whoever broadcasts it
+	NotifyCommitment string `json:"notifyCommitment,omitempty"`
+
+	// run simulateTransaction first and refuse to sign if it would fail
+	Simulate bool `json:"simulate,omitempty"`
+
+	// mainnet-beta, devnet, testnet or custom, overrides the key's cluster
+	// and the service default for solana-tx requests
+	Cluster string `json:"cluster,omitempty"`
+
+	// patch a recent blockhash from the service's cache into the transaction
+	// before signing, it may not carry signatures yet or use a durable nonce
+	RefreshBlockhash bool `json:"refreshBlockhash,omitempty"`
+
+	// sign against this durable nonce account held by the key instead: the
+	// service advances it first and patches its nonce in as the blockhash,
+	// so the transaction stays valid until sent. Excludes refreshBlockhash.
+	NonceAccount string `json:"nonceAccount,omitempty"`
+
+	// set the compute unit price from recent fees before signing, the key's
+	// policy must allow it and the transaction may not carry signatures yet
+	PriorityFee bool `json:"priorityFee,omitempty"`
+
+	// the service's fee payer co-signs and pays the fee, so the key needs no
+	// SOL. The transaction must name it as fee payer (see /fee-payer) and may
+	// not use it otherwise. Its own policy bounds the priority fee and what
+	// it pays.
+	FeePayer bool `json:"feePayer,omitempty"`
+
+	// signing grant token, also read from the X-STS-Grant header
+	Grant string `json:"grant,omitempty"`
+
+	// anti-replay, a unique nonce per request and its unix send time
+	Nonce     string `json:"nonce,omitempty"`
+	Timestamp int64  `json:"timestamp,omitempty"`
 }
 
 type TransactionResult struct {
 	KeyID           string `json:"keyId"`
 	Signature       string `json:"signature"`
+	SigningMode     string `json:"signingMode"`
+	Context         string `json:"context"`
 	BroadcastStatus string `json:"broadcaststatus"`
-	Error           string `json:"error,omitempty"`
+	KeyDestroyed    bool   `json:"keyDestroyed"`
+
+	// set for solana-tx requests
+	Cluster     string `json:"cluster,omitempty"`
+	Transaction string `json:"transaction,omitempty"`
+	TxSignature string `json:"txSignature,omitempty"`
+	Slot        uint64 `json:"slot,omitempty"`
+
+	// blockhash patched in for refreshBlockhash requests, the transaction
+	// can land until the cluster passes LastValidBlockHeight
+	Blockhash            string `json:"blockhash,omitempty"`
+	LastValidBlockHeight uint64 `json:"lastValidBlockHeight,omitempty"`
+
+	// compute unit price set on the transaction, for priorityFee requests
+	PriorityFeeMicroLamports uint64 `json:"priorityFeeMicroLamports,omitempty"`
+
+	// program logs from the pre-sign simulation
+	SimulationLogs []string `json:"simulationLogs,omitempty"`
+
+	// every sendTransaction call, retries of transient failures included
+	BroadcastAttempts []BroadcastAttempt `json:"broadcastAttempts,omitempty"`
+
+	// set instead of a signature when a second operator must approve first
+	ApprovalID string `json:"approvalId,omitempty"`
+
+	// the transaction's place in the key's outbound queue, for queue requests
+	QueueID string `json:"queueId,omitempty"`
+
+	Error string `json:"error,omitempty"`
 }
 
 type SignerService interface {
-	GenerateKey(ctx context.Context) (Account, error)
+	GenerateKey(ctx context.Context, req KeyGenRequest) (Account, error)
 	SignTransaction(ctx context.Context, req TransactionRequest) (TransactionResult, error)
+	VerifySignature(ctx context.Context, req VerifyRequest) (VerifyResult, error)
+	CostReport(ctx context.Context, tenant string) []TenantCosts
+	KeyInfo(ctx context.Context, keyID string) (KeyUsage, error)
+	KeyDetail(ctx context.Context, keyID string) (KeyDetail, error)
+	DestroyKey(ctx context.Context, keyID string) error
 }
 
 type signerService struct {
 	store *SecureKeyStore
+
+	// backend ops billed per tenant
+	costs *CostLedger
+
+	// completed results by idempotency key
+	idempotency       *IdempotencyCache[TransactionResult]
+	keyGenIdempotency *IdempotencyCache[Account]
+
+	// security alerts to on-call channels
+	notifier *NotificationDispatcher
+
+	// key and signing events for the event stream, nil publishes nothing
+	events *EventBus
+
+	// Prometheus collectors, nil records nothing
+	metrics *Metrics
+
+	// billable operations per tenant and API token, nil meters nothing
+	meter *UsageMeter
+
+	// signatures per key and policy for compliance reports, nil records nothing
+	compliance *ComplianceRecorder
+
+	// recovered panics are reported here, nil only logs them
+	crashes *CrashReporter
+
+	// the default cluster's endpoint, nil when not configured
+	rpc ChainClient
+
+	// transient broadcast failures, zero takes defaultBroadcastRetry
+	broadcastRetry BroadcastRetry
+
+	// signed transactions recorded and followed to finalized, nil tracks nothing
+	txs *TxTracker
+
+	// broadcasts queued transactions in order per key, nil refuses queue requests
+	outbound *OutboundQueue
+
+	// key id of the key that pays fees for feePayer requests, empty refuses them
+	feePayer string
+
+	// the default cluster's compute unit prices for priorityFee requests,
+	// nil when no rpc
+	fees *FeeAdvisor
+
+	// the default cluster's recent blockhashes for refreshBlockhash
+	// requests, nil when no rpc
+	blockhashes *BlockhashCache
+
+	// clusters requests and keys may pick, nil leaves only the default
+	clusters *Clusters
+
+	// sliding window spend counters for keys w/ spending limits
+	spending *SpendTracker
+
+	// transactions waiting on a second operator, nil disables approvals
+	approvals *ApprovalQueue
+
+	// per key token buckets for keys w/ a rate limit
+	limiter *KeyRateLimiter
+
+	// signing and key generation stop while sealed
+	seal *SealState
+
+	// flags requests outside a key's usual rate and amounts, nil disables
+	anomalies *AnomalyDetector
+
+	// short lived grants that bound batch jobs
+	grants *GrantStore
+
+	// rejects captured and resent sign requests
+	replay *ReplayGuard
+
+	// signs key attestations on generation, nil skips them
+	attester *Attester
+
+	// only FIPS approved key types may be generated or used
+	fips bool
+
+	// runtime switches for broadcast, simulation and key types, nil leaves
+	// every flag at its default
+	features *FeatureFlags
 }
 
 func NewSignerService(store *SecureKeyStore) *signerService {
+	spending, _ := NewSpendTracker("")
 	return &signerService{
-		store: store,
+		store:             store,
+		costs:             NewCostLedger(nil),
+		idempotency:       NewIdempotencyCache[TransactionResult](24 * time.Hour),
+		keyGenIdempotency: NewIdempotencyCache[Account](24 * time.Hour),
+		spending:          spending,
+		limiter:           NewKeyRateLimiter(),
+		seal:              NewSealState(),
+		grants:            NewGrantStore(),
+		replay:            NewReplayGuard(false),
+		fips:              fips140.Enabled(),
 	}
 }
 
-func (s *signerService) GenerateKey(ctx context.Context) (Account, error) {
-	log.Println("Generating new Sol Ed25519 Key Pair ... ")
+func (s *signerService) GenerateKey(ctx context.Context, req KeyGenRequest) (acc Account, err error) {
+	ctx, span := tracer.Start(ctx, "signer.GenerateKey", trace.WithAttributes(
+		attribute.String("sts.key_type", cmp.Or(req.KeyType, KeyTypeEd25519)),
+		attribute.Bool("sts.idempotent", req.IdempotencyKey != "")))
+	defer func() { endSpan(span, err) }()
+
+	if req.IdempotencyKey == "" {
+		return s.generateKey(ctx, req)
+	}
+
+	// scoped to the tenant, key ids only exist once the key does
+	cacheKey := TenantFromContext(ctx) + "/" + req.IdempotencyKey
+	fingerprint := keyGenFingerprint(req)
+	prev, found, err := s.keyGenIdempotency.Begin(cacheKey, fingerprint)
+	if err != nil {
+		return Account{}, err
+	}
+	if found {
+		slog.InfoContext(ctx, "Returning original key for idempotency key", logSigner, "public_key", prev.PublicKey, "idempotency_key", req.IdempotencyKey)
+		return prev, nil
+	}
 
-	pubKey, privKey, err := ed25519.GenerateKey(rand.Reader)
+	acc, err = s.generateKey(ctx, req)
 	if err != nil {
-		return Account{}, fmt.Errorf("failed to generate key: %w", err)
+		s.keyGenIdempotency.Abandon(cacheKey)
+		return acc, err
 	}
+	s.keyGenIdempotency.Complete(cacheKey, acc)
 
-	// encode key
-	keyId := hex.EncodeToString(pubKey)
+	return acc, nil
+}
 
-	s.store.Store(keyId, privKey)
+func keyGenFingerprint(req KeyGenRequest) string {
+	req.IdempotencyKey = ""
+	return requestFingerprint(req)
+}
 
-	return Account{
+func (s *signerService) generateKey(ctx context.Context, req KeyGenRequest) (Account, error) {
+	keyType := req.KeyType
+	if keyType == "" {
+		keyType = KeyTypeEd25519
+	}
+	slog.InfoContext(ctx, "Generating new key pair", logSigner, "key_type", keyType)
+
+	if feature := keyTypeFeature(keyType); feature != "" {
+		if err := s.features.Check(feature); err != nil {
+			return Account{}, err
+		}
+	}
+
+	if s.fips {
+		if err := checkFIPSKeyType(keyType); err != nil {
+			return Account{}, err
+		}
+	}
+
+	namespace := req.Namespace
+	if namespace == "" {
+		namespace = TenantFromContext(ctx)
+	}
+	if err := s.seal.Check(namespace); err != nil {
+		return Account{}, err
+	}
+
+	policy := DefaultKeyPolicy()
+	if req.Policy != nil {
+		policy = *req.Policy
+	}
+	if err := policy.Validate(); err != nil {
+		return Account{}, err
+	}
+
+	// key id is the hex encoded public key
+	keyId, privKey, err := generateKeyPair(keyType)
+	if err != nil {
+		return Account{}, fmt.Errorf("failed to generate key: %w", err)
+	}
+
+	_, writeSpan := storeSpan(ctx, "StoreKey")
+	s.store.StoreKey(keyId, keyType, privKey, policy, namespace)
+	writeSpan.End()
+	s.costs.Record(ctx, CostKeyGen)
+	s.meter.Record(ctx, MeterKeyGenerate)
+	s.costs.Record(ctx, CostKeystoreWrite)
+
+	acc := Account{
 		PublicKey: keyId,
-	}, nil
+		KeyType:   keyType,
+		Namespace: namespace,
+		Policy:    policy,
+	}
+
+	if s.attester != nil {
+		acc.Attestation, err = s.attester.Attest(AttestationStatement{
+			KeyID:       keyId,
+			KeyType:     keyType,
+			Namespace:   namespace,
+			GeneratedAt: time.Now().UTC(),
+			Backend:     keyBackend,
+			Version:     version,
+		})
+		if err != nil {
+			return Account{}, fmt.Errorf("failed to attest key: %w", err)
+		}
+	}
+
+	s.metrics.KeyGenerated(ctx, keyType)
+	s.events.Publish(ctx, Event{Type: EventKeyCreated, KeyID: keyId, Namespace: namespace, Detail: keyType})
+	return acc, nil
+}
+
+func (s *signerService) SignTransaction(ctx context.Context, req TransactionRequest) (TransactionResult, error) {
+	if req.IdempotencyKey == "" {
+		return s.observedSign(ctx, req)
+	}
+
+	// scoped to the key so clients can't collide across wallets
+	cacheKey := req.KeyID + "/" + req.IdempotencyKey
+	prev, found, err := s.idempotency.Begin(cacheKey, signFingerprint(req))
+	if err != nil {
+		return TransactionResult{}, err
+	}
+	if found {
+		slog.InfoContext(ctx, "Returning original signature for idempotency key", logSigner, "key_id", req.KeyID, "idempotency_key", req.IdempotencyKey)
+		return prev, nil
+	}
+
+	result, err := s.observedSign(ctx, req)
+	if err != nil {
+		s.idempotency.Abandon(cacheKey)
+		return result, err
+	}
+	s.idempotency.Complete(cacheKey, result)
+
+	return result, nil
+}
+
+// signFingerprint leaves out the grant, a retry may carry a fresh one
+func signFingerprint(req TransactionRequest) string {
+	req.IdempotencyKey, req.Grant = "", ""
+	return requestFingerprint(req)
+}
+
+// observedSign signs and reports the outcome to metrics, the event stream
+// and the trace
+func (s *signerService) observedSign(ctx context.Context, req TransactionRequest) (result TransactionResult, err error) {
+	ctx, span := tracer.Start(ctx, "signer.SignTransaction", trace.WithAttributes(
+		attribute.String("sts.context", req.Context),
+		attribute.Bool("sts.broadcast", req.Broadcast),
+		attribute.Bool("sts.simulate", req.Simulate)))
+	defer func() { endSpan(span, err) }()
+
+	// looked up first, a used up key is gone once signed
+	keyType, usage := "unknown", ""
+	if info, err := s.store.Info(req.KeyID); err == nil {
+		keyType, usage = info.KeyType, info.Policy.Usage
+	}
+	span.SetAttributes(attribute.String("sts.key_type", keyType))
+
+	start := time.Now()
+	result, err = s.signTransaction(ctx, req)
+	took := time.Since(start)
+	s.metrics.ObserveSign(ctx, keyType, result, err, took)
+	audit(ctx, logSigner, auditSignAction, "key_id", req.KeyID, "outcome", signOutcome(result, err), "took_ms", float64(took.Microseconds())/1000)
+	if err == nil && result.Signature != "" {
+		s.compliance.Signed(req.KeyID, usage)
+	}
+	s.publishSignResult(ctx, result, err)
+	span.SetAttributes(
+		attribute.String("sts.signing_mode", result.SigningMode),
+		attribute.Bool("sts.key_destroyed", result.KeyDestroyed),
+		attribute.Bool("sts.pending_approval", result.ApprovalID != "" && result.Signature == ""))
+	return result, err
 }
 
-func (s *signerService) SignTransaction(ctx context.Context, req TransactionRequest) (result TransactionResult, err error) {
-	log.Printf("Attempting to sign transaction for Account: %v", req.KeyID)
+func (s *signerService) signTransaction(ctx context.Context, req TransactionRequest) (result TransactionResult, err error) {
+	if principal := PrincipalFromContext(ctx); principal != "" {
+		slog.InfoContext(ctx, "Attempting to sign transaction", logSigner, "key_id", req.KeyID, "client", principal)
+	} else {
+		slog.InfoContext(ctx, "Attempting to sign transaction", logSigner, "key_id", req.KeyID)
+	}
 
 	defer func() {
 		if r := recover(); r != nil {
-			log.Printf("Critical Panic for during signing for %s, %v", req.KeyID, r)
+			s.crashes.Report(ctx, "signer", r, "key_id", req.KeyID, "context", req.Context)
 			result.Error = "Internal Signing Error, Try again later"
 			err = errors.New("Signing failed due to internal error")
 		}
@@ -79,31 +449,503 @@ func (s *signerService) SignTransaction(ctx context.Context, req TransactionRequ
 		return result, errors.New("KeyID and unsigned TX data cannot be empty")
 	}
 
+	if ctxErr := validateSigningContext(req.Context); ctxErr != nil {
+		return result, ctxErr
+	}
+
+	// a queued transaction re-signed w/ a fresh blockhash was checked,
+	// counted and approved when first signed, its expired self never lands
+	resigned := resignFromContext(ctx) != ""
+
+	// approved requests are replayed by the service itself, their nonce was checked on submit
+	if approvalFromContext(ctx) == "" && !resigned {
+		if replayErr := s.replay.Check(req.KeyID, req.Nonce, req.Timestamp, time.Now()); replayErr != nil {
+			return result, replayErr
+		}
+	}
+
 	rawTxData, decodeErr := base64.StdEncoding.DecodeString(req.UnsignedTxData)
 	if decodeErr != nil {
 		return result, fmt.Errorf("Invalid base64 encoding of tx data: %w", decodeErr)
 	}
 
-	// Key retrieval
-	privKey, keyErr := s.store.Get(req.KeyID)
+	// solana transactions are checked before a key use is spent
+	var solanaTx *SolanaPayload
+	if req.Context == SolanaTxContext {
+		if req.SigningMode != "" && req.SigningMode != SigningModeEd25519 {
+			return result, errors.New("solana transactions are signed w/ pure ed25519")
+		}
+		solanaTx, err = ParseSolanaPayload(rawTxData)
+		if err != nil {
+			return result, fmt.Errorf("invalid solana transaction: %w", err)
+		}
+	}
+	// policy is checked before a key use is spent
+	info, policyErr := s.store.Info(req.KeyID)
+	if policyErr != nil {
+		return result, fmt.Errorf("key retrieval failed w/ error: %w", policyErr)
+	}
+	policy := info.Policy
+	if policyErr = s.seal.Check(info.Namespace); policyErr != nil {
+		return result, policyErr
+	}
+	if info.Frozen != nil {
+		return result, fmt.Errorf("%w: %s", errKeyFrozen, info.Frozen.Reason)
+	}
+	// keys made before FIPS mode was turned on stay unusable under it
+	if s.fips {
+		if fipsErr := checkFIPSKeyType(info.KeyType); fipsErr != nil {
+			return result, fipsErr
+		}
+	}
+	if policy.Canary {
+		return s.canarySign(ctx, req, info.KeyType, rawTxData, solanaTx, result)
+	}
+	if policyErr = policy.CheckSchedule(time.Now(), info.CreatedAt); policyErr != nil {
+		slog.WarnContext(ctx, "Refusing to sign", logSigner, "key_id", req.KeyID, "err", policyErr)
+		return result, policyErr
+	}
+	if policyErr = policy.CheckTransaction(solanaTx); policyErr != nil {
+		slog.WarnContext(ctx, "Refusing to sign", logSigner, "key_id", req.KeyID, "err", policyErr)
+		return result, policyErr
+	}
+
+	if req.Broadcast {
+		if err := s.features.Check(FeatureBroadcast); err != nil {
+			return result, err
+		}
+	}
+	if req.Simulate {
+		if err := s.features.Check(FeatureSimulate); err != nil {
+			return result, err
+		}
+	}
+	// a key kept off a cluster may not sign for it, whether or not the
+	// service talks to the cluster itself
+	var cluster *Cluster
+	if solanaTx != nil {
+		var clusterErr error
+		if cluster, clusterErr = s.clusterFor(req.Cluster, policy); clusterErr != nil {
+			slog.WarnContext(ctx, "Refusing to sign", logSigner, "key_id", req.KeyID, "err", clusterErr)
+			return result, clusterErr
+		}
+		result.Cluster = cluster.Name
+	} else if req.Cluster != "" {
+		return result, fmt.Errorf("cluster requires the %s context", SolanaTxContext)
+	}
+
+	if req.Broadcast || req.Queue || req.Simulate || req.PriorityFee || req.RefreshBlockhash || req.NonceAccount != "" || req.NotifyCommitment != "" {
+		if solanaTx == nil {
+			return result, fmt.Errorf("broadcast, queue, simulate, priorityFee, refreshBlockhash, nonceAccount and notifyCommitment require the %s context", SolanaTxContext)
+		}
+		if cluster.Chain == nil {
+			return result, errNoRPC
+		}
+	}
+	if req.Queue {
+		if req.Broadcast {
+			return result, errors.New("broadcast and queue can't be combined")
+		}
+		if s.outbound == nil {
+			return result, errQueueDisabled
+		}
+	}
+	if req.NotifyCommitment != "" {
+		if commitmentErr := checkNotifyCommitment(req.NotifyCommitment); commitmentErr != nil {
+			return result, commitmentErr
+		}
+		if s.txs == nil || s.txs.webhook == nil {
+			return result, errNoTxWebhook
+		}
+	}
+
+	// the fee payer is checked like the key, its policy prices the priority
+	// fee it pays
+	var payer *KeyUsage
+	feePolicy := policy.PriorityFees
+	if req.FeePayer {
+		if solanaTx == nil {
+			return result, fmt.Errorf("feePayer requires the %s context", SolanaTxContext)
+		}
+		payerInfo, payerErr := s.feePayerKey(req.KeyID)
+		if payerErr != nil {
+			return result, payerErr
+		}
+		payer, feePolicy = &payerInfo, payerInfo.Policy.PriorityFees
+	}
+
+	// the nonce advance is allowed by the request rather than the key's
+	// program allowlist, like the compute budget below
+	if req.NonceAccount != "" {
+		if req.RefreshBlockhash {
+			return result, errors.New("refreshBlockhash and nonceAccount can't be combined")
+		}
+		if nonceErr := useNonceAccount(ctx, cluster.Chain, s.store, req.KeyID, req.NonceAccount, solanaTx, &result); nonceErr != nil {
+			return result, nonceErr
+		}
+	}
+
+	if req.RefreshBlockhash {
+		if bhErr := refreshBlockhash(ctx, cluster.Blockhashes, solanaTx, &result); bhErr != nil {
+			return result, bhErr
+		}
+	}
+
+	// the compute budget instruction is allowed by the key's fee policy
+	// rather than its program allowlist, and set before simulation so the
+	// transaction simulated is the one signed
+	if req.PriorityFee {
+		price, feeErr := applyPriorityFee(ctx, cluster.Fees, solanaTx, feePolicy)
+		if feeErr != nil {
+			slog.WarnContext(ctx, "Refusing to sign", logSigner, "key_id", req.KeyID, "err", feeErr)
+			return result, feeErr
+		}
+		result.PriorityFeeMicroLamports = price
+	}
+
+	// simulate before a key use is spent so failing txs cost nothing
+	if req.Simulate {
+		sim, simErr := cluster.Chain.SimulateTransaction(ctx, solanaTx.UnsignedWire())
+		var failed *SimulationError
+		if errors.As(simErr, &failed) {
+			result.SimulationLogs = failed.Logs
+			slog.WarnContext(ctx, "Refusing to sign, simulation failed", logSigner, "key_id", req.KeyID, "err", failed.Err)
+			return result, simErr
+		}
+		if simErr != nil {
+			return result, fmt.Errorf("simulation unavailable: %w", simErr)
+		}
+		result.SimulationLogs = sim.Logs
+	}
+
+	// checked on the message as signed, after the nonce and priority fee
+	var fee uint64
+	if payer != nil {
+		address, _ := keyAddress(s.store, s.feePayer)
+		var payerErr error
+		if fee, payerErr = checkFeePayerTx(solanaTx.Message, address, feePolicy); payerErr != nil {
+			slog.WarnContext(ctx, "Refusing to sign", logSigner, "key_id", req.KeyID, "err", payerErr)
+			return result, payerErr
+		}
+	}
+
+	// spend rules are evaluated before a key use is spent
+	var spends map[string]uint64
+	if policy.tracksSpend() {
+		if spends, err = s.spendsFor(req.KeyID, policy, solanaTx); err != nil {
+			return result, err
+		}
+	} else if s.anomalies != nil && solanaTx != nil {
+		// amounts only feed the baseline here, keys w/o spend rules aren't refused
+		spends, _ = s.spendsFor(req.KeyID, policy, solanaTx)
+	}
+
+	approved := approvalFromContext(ctx) != ""
+	if reason := policy.approvalReason(spends); reason != "" && !approved && !resigned {
+		return s.queueApproval(ctx, req, reason, policy, result)
+	}
+	// the fee payer's own thresholds apply to the fee it pays
+	if payer != nil && !approved && !resigned {
+		if reason := payer.Policy.approvalReason(map[string]uint64{assetLamports: fee}); reason != "" {
+			return s.queueApproval(ctx, req, "fee payer "+reason, payer.Policy, result)
+		}
+	}
+
+	// counted up front so concurrent requests can't both fit under a limit,
+	// released again if no signature comes out
+	signed := false
+	if len(policy.SpendingLimits) > 0 && !resigned {
+		release, spendErr := s.spending.Reserve(req.KeyID, policy.SpendingLimits, spends, approved)
+		var limitErr *SpendLimitError
+		if errors.As(spendErr, &limitErr) && limitErr.Limit.OverLimit == OverLimitApprove {
+			return s.queueApproval(ctx, req, limitErr.Error(), policy, result)
+		}
+		if spendErr != nil {
+			slog.WarnContext(ctx, "Refusing to sign", logSigner, "key_id", req.KeyID, "err", spendErr)
+			return result, spendErr
+		}
+		defer func() {
+			if !signed {
+				release()
+			}
+		}()
+	}
+
+	// a grant use is taken like a spend reservation and given back on failure
+	if req.Grant != "" {
+		release, grantErr := s.grants.Consume(req.Grant, req.KeyID)
+		if grantErr != nil {
+			return result, grantErr
+		}
+		defer func() {
+			if !signed {
+				release()
+			}
+		}()
+	} else if policy.RequireGrant && !resigned {
+		return result, errGrantRequired
+	}
+
+	// the fee counts against the fee payer's limits like a spend against the key's
+	if payer != nil && !resigned {
+		release, feeErr := s.reserveFee(*payer, fee)
+		if feeErr != nil {
+			slog.WarnContext(ctx, "Refusing to sign", logSigner, "key_id", req.KeyID, "err", feeErr)
+			return result, feeErr
+		}
+		defer func() {
+			if !signed {
+				release()
+			}
+		}()
+	}
+
+	// every attempt that gets this far counts, failed ones included
+	if policy.RateLimit != nil {
+		if limitErr := s.limiter.Take(req.KeyID, *policy.RateLimit, time.Now()); limitErr != nil {
+			slog.WarnContext(ctx, "Refusing to sign", logSigner, "key_id", req.KeyID, "err", limitErr)
+			return result, limitErr
+		}
+	}
+
+	if s.anomalies != nil {
+		if finding := s.anomalies.Evaluate(req.KeyID, spends[assetLamports], time.Now()); finding != nil {
+			if anomalyErr := s.reportAnomaly(req.KeyID, finding); anomalyErr != nil {
+				return result, anomalyErr
+			}
+		}
+	}
+
+	// Key retrieval, reserves one use under the key's policy
+	_, acquireSpan := storeSpan(ctx, "Acquire")
+	keyType, privKey, lastUse, keyErr := s.store.Acquire(req.KeyID)
+	endSpan(acquireSpan, keyErr)
+	s.costs.Record(ctx, CostKeystoreRead)
+	if errors.Is(keyErr, errKeyUsesExhausted) {
+		s.notifier.Dispatch(Notification{
+			Kind:     NotifySecurityAlert,
+			Severity: SeverityWarning,
+			Title:    "Signing attempted w/ exhausted key",
+			Message:  "A sign request was made for a key whose usage policy is used up",
+			KeyID:    req.KeyID,
+		})
+	}
 	if keyErr != nil {
 		return result, fmt.Errorf("key retrieval failed w/ error: %w", keyErr)
 	}
+	defer clear(privKey)
+
+	var sig []byte
+	var mode string
+	var signErr error
+	if solanaTx != nil {
+		// the fee payer's signature goes in first, it is the transaction id
+		if payer != nil {
+			signErr = s.coSignFeePayer(ctx, solanaTx)
+		}
+		if signErr == nil {
+			sig, mode, signErr = s.signSolana(keyType, privKey, solanaTx, &result)
+		}
+	} else {
+		sig, mode, signErr = signWithKey(keyType, privKey, rawTxData, req.SigningMode, req.Context)
+	}
+	if signErr != nil {
+		return result, fmt.Errorf("signing failed w/ error: %w", signErr)
+	}
+	signed = true
+	if s.anomalies != nil {
+		s.anomalies.Record(req.KeyID, spends[assetLamports], time.Now())
+	}
 
-	sig := ed25519.Sign(privKey, rawTxData)
+	s.costs.Record(ctx, CostSign)
+	s.meter.Record(ctx, MeterSign)
 
-	//zerorize key
-	err = s.store.Zerorize(req.KeyID)
-	if err != nil {
-		return result, fmt.Errorf("error clearing key from mem: %w", err)
+	//zerorize key once its policy is used up
+	if lastUse {
+		slog.InfoContext(ctx, "Key used up, zeroizing", logSigner, "key_id", req.KeyID)
+		_, zeroizeSpan := storeSpan(ctx, "Zerorize")
+		err = s.store.Zerorize(req.KeyID)
+		endSpan(zeroizeSpan, err)
+		s.costs.Record(ctx, CostKeystoreDelete)
+		if err != nil {
+			return result, fmt.Errorf("error clearing key from mem: %w", err)
+		}
+		result.KeyDestroyed = true
+		s.limiter.Forget(req.KeyID)
+		if s.anomalies != nil {
+			s.anomalies.Forget(req.KeyID)
+		}
 	}
 
 	result.Signature = base64.StdEncoding.EncodeToString(sig)
+	result.SigningMode = mode
+	result.Context = req.Context
 	result.BroadcastStatus = "Signed and Ready"
+	if solanaTx != nil && result.TxSignature != "" {
+		s.txs.Signed(result.TxSignature, req.KeyID, cluster.Name, solanaTx.Message.RecentBlockhash.String(), usesDurableNonce(solanaTx.Message), SolanaMemos(solanaTx.Message))
+		if req.NotifyCommitment != "" {
+			s.txs.NotifyAt(result.TxSignature, req.NotifyCommitment)
+		}
+	}
+
+	if req.Broadcast {
+		if err := s.broadcast(ctx, cluster, solanaTx, &result); err != nil {
+			result.BroadcastStatus = "Broadcast Failed"
+			return result, fmt.Errorf("broadcast failed w/ error: %w", err)
+		}
+	}
+	if req.Queue {
+		if !solanaTx.FullySigned() {
+			return result, errors.New("transaction still needs signatures from other signers")
+		}
+		queued, err := s.outbound.Enqueue(ctx, req, cluster.Name, solanaTx, result)
+		if err != nil {
+			return result, err
+		}
+		result.QueueID = queued.ID
+		result.BroadcastStatus = "Queued"
+	}
+
+	return result, nil
+}
+
+// signSolana signs the message w/ pure ed25519 and fills in the wire transaction
+func (s *signerService) signSolana(keyType string, privKey []byte, tx *SolanaPayload, result *TransactionResult) ([]byte, string, error) {
+	if keyType != KeyTypeEd25519 {
+		return nil, "", fmt.Errorf("solana transactions need an ed25519 key, not %s", keyType)
+	}
+
+	key := ed25519.PrivateKey(privKey)
+	sig := ed25519.Sign(key, tx.MsgBytes)
+
+	wire, err := tx.WireTransaction(SolanaAddress(key.Public().(ed25519.PublicKey)), sig)
+	if err != nil {
+		return nil, "", err
+	}
+
+	result.Transaction = base64.StdEncoding.EncodeToString(wire)
+	// the first signature is the transaction id
+	if len(tx.Signatures[0]) > 0 {
+		result.TxSignature = base58Encode(tx.Signatures[0])
+	}
+	return sig, SigningModeEd25519, nil
+}
+
+func (s *signerService) spendsFor(keyID string, policy KeyPolicy, tx *SolanaPayload) (map[string]uint64, error) {
+	pub, err := hex.DecodeString(keyID)
+	if err != nil || len(pub) != ed25519.PublicKeySize {
+		return nil, fmt.Errorf("%w: spend rules need an ed25519 key", errPolicyViolation)
+	}
+
+	spends, err := SpendsFor(tx.Message, SolanaAddress(ed25519.PublicKey(pub)), policy.needsMints())
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", errPolicyViolation, err)
+	}
+	return spends, nil
+}
+
+// reportAnomaly alerts on-call and returns an error when the request must be refused
+func (s *signerService) reportAnomaly(keyID string, finding *AnomalyFinding) error {
+	blocked := s.anomalies.blocks()
+	slog.Warn("Anomalous signing pattern", logSigner, "key_id", keyID, "finding", finding.String(), "blocked", blocked)
 
+	severity := SeverityWarning
+	if blocked {
+		severity = SeverityCritical
+	}
+	s.notifier.Dispatch(Notification{
+		Kind:     NotifySecurityAlert,
+		Severity: severity,
+		Title:    "Anomalous signing pattern",
+		Message:  fmt.Sprintf("Sign request %s", finding),
+		KeyID:    keyID,
+		Details:  map[string]string{"kind": finding.Kind, "blocked": fmt.Sprint(blocked)},
+	})
+
+	if blocked {
+		return fmt.Errorf("%w: %s", errAnomalous, finding)
+	}
+	return nil
+}
+
+// queueApproval parks the request for a second operator, no key use is spent
+func (s *signerService) queueApproval(ctx context.Context, req TransactionRequest, reason string, policy KeyPolicy, result TransactionResult) (TransactionResult, error) {
+	if s.approvals == nil {
+		slog.WarnContext(ctx, "Refusing to sign", logSigner, "key_id", req.KeyID, "reason", reason, "err", errApprovalsDisabled)
+		return result, fmt.Errorf("%w (%s)", errApprovalsDisabled, reason)
+	}
+
+	pa, err := s.approvals.Submit(ctx, req, reason, policy.approvalQuorum())
+	if err != nil {
+		return result, err
+	}
+
+	result.ApprovalID = pa.ID
+	result.Context = req.Context
+	result.BroadcastStatus = "Pending Approval"
 	return result, nil
 }
 
+func (s *signerService) broadcast(ctx context.Context, cluster *Cluster, tx *SolanaPayload, result *TransactionResult) error {
+	if !tx.FullySigned() {
+		return errors.New("transaction still needs signatures from other signers")
+	}
+
+	wire, _ := base64.StdEncoding.DecodeString(result.Transaction)
+	txSig, err := s.sendWithRetry(ctx, cluster.Chain, tx, wire, result)
+	if err != nil {
+		return err
+	}
+	result.TxSignature = txSig
+	result.BroadcastStatus = "Broadcast"
+	s.txs.Track(txSig, result.KeyID, cluster.Name, tx.Message.RecentBlockhash.String())
+
+	// best effort, the slot is unknown if the deadline hits first
+	slotCtx, cancel := context.WithTimeout(ctx, 2*time.Second)
+	defer cancel()
+
+	slot, err := signatureSlot(slotCtx, cluster.Chain, txSig)
+	if err != nil {
+		slog.WarnContext(ctx, "Could not fetch slot", logSigner, "signature", txSig, "err", err)
+	}
+	result.Slot = slot
+
+	return nil
+}
+
+func (s *signerService) CostReport(ctx context.Context, tenant string) []TenantCosts {
+	return s.costs.Report(tenant)
+}
+
+func (s *signerService) KeyInfo(ctx context.Context, keyID string) (KeyUsage, error) {
+	return s.store.Info(keyID)
+}
+
+// DestroyKey zeroizes and removes a key, refused while approvals for it may
+// still sign
+func (s *signerService) DestroyKey(ctx context.Context, keyID string) error {
+	if _, err := s.store.Info(keyID); err != nil {
+		return err
+	}
+	if pending := s.approvals.InFlight(keyID); len(pending) > 0 {
+		return fmt.Errorf("%w: approvals %s", errKeyInUse, strings.Join(pending, ", "))
+	}
+
+	if err := s.store.Zerorize(keyID); err != nil {
+		return err
+	}
+	s.costs.Record(ctx, CostKeystoreDelete)
+	s.meter.Record(ctx, MeterKeyDestroy)
+	s.limiter.Forget(keyID)
+	if s.anomalies != nil {
+		s.anomalies.Forget(keyID)
+	}
+	operator := OperatorFromContext(ctx).Name
+	audit(ctx, logAdmin, "Key deleted", "operator", operator, "key_id", keyID)
+	s.events.Publish(ctx, Event{Type: EventKeyDestroyed, KeyID: keyID, Detail: "deleted by " + operator})
+	return nil
+}
+
 func (s *signerService) SimulateBroadCast(ctx context.Context, sig string) (string, error) {
 	select {
 	case <-ctx.Done():
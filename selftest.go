@@ -0,0 +1,126 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+)
+
+// RFC 8032 section 7.1 tests 1 and 2
+var ed25519Vectors = []struct {
+	seed, pub, msg, sig string
+}{
+	{
+		seed: "9d61b19deffd5a60ba844af492ec2cc44449c5697b326919703bac031cae7f60",
+		pub:  "d75a980182b10ab7d54bfed3c964073a0ee172f3daa62325af021a68f707511a",
+		msg:  "",
+		sig:  "e5564300c360ac729086e2cc806e828a84877f1eb8e5d974d873e065224901555fb8821590a33bacc61e39701cf9b46bd25bf5f0595bbe24655141438e7a100b",
+	},
+	{
+		seed: "4ccd089b28ff96da9db6c346ec114e0f5b8a319f35aba624da8cf6ed4fb8a6fb",
+		pub:  "3d4017c3e843895a92b70aa74d1b7ebc9c982ccf2ec4968cc0cd55f12af4660c",
+		msg:  "72",
+		sig:  "92a009a9f0d4cab8720e820b5f642540a2b27b5416503f8fb3762223ebdb69da085ac1e43e15996e458f3613d0f11d8c387b2eaeb4302aeeb00d291612bb0c00",
+	},
+}
+
+// SelfTestResult is one startup check
+type SelfTestResult struct {
+	Name string
+	Err  error
+}
+
+// RunSelfTests runs known answer tests, a sign/verify round trip per key type
+// and an RNG health check. Every result goes to the audit log, the returned
+// error is set if any failed and the service must not serve.
+func RunSelfTests(keyTypes []string) ([]SelfTestResult, error) {
+	var results []SelfTestResult
+	run := func(name string, test func() error) {
+		results = append(results, SelfTestResult{Name: name, Err: test()})
+	}
+
+	for i, v := range ed25519Vectors {
+		run(fmt.Sprintf("ed25519 rfc8032 vector %d", i+1), func() error { return ed25519KnownAnswer(v.seed, v.pub, v.msg, v.sig) })
+	}
+	for _, keyType := range keyTypes {
+		run(keyType+" sign/verify", func() error { return signRoundTrip(keyType) })
+	}
+	run("rng health", rngHealth)
+
+	failed := 0
+	for _, r := range results {
+		if r.Err != nil {
+			failed++
+			audit(context.Background(), logServer, "Self-test failed", "test", r.Name, "err", r.Err)
+		} else {
+			audit(context.Background(), logServer, "Self-test passed", "test", r.Name)
+		}
+	}
+	if failed > 0 {
+		return results, fmt.Errorf("%d of %d self-tests failed", failed, len(results))
+	}
+	return results, nil
+}
+
+func ed25519KnownAnswer(seedHex, pubHex, msgHex, sigHex string) error {
+	seed, _ := hex.DecodeString(seedHex)
+	msg, _ := hex.DecodeString(msgHex)
+	want, _ := hex.DecodeString(sigHex)
+
+	key := ed25519.NewKeyFromSeed(seed)
+	if hex.EncodeToString(key.Public().(ed25519.PublicKey)) != pubHex {
+		return errors.New("derived public key does not match")
+	}
+	if !bytes.Equal(ed25519.Sign(key, msg), want) {
+		return errors.New("signature does not match")
+	}
+	if !ed25519.Verify(key.Public().(ed25519.PublicKey), msg, want) {
+		return errors.New("known signature does not verify")
+	}
+	return nil
+}
+
+func signRoundTrip(keyType string) error {
+	keyID, privKey, err := generateKeyPair(keyType)
+	if err != nil {
+		return err
+	}
+	pubKey, _ := hex.DecodeString(keyID)
+	defer clear(privKey)
+
+	// secp256k1 and prehashed modes sign a digest, a digest works for all types
+	digest := sha256.Sum256([]byte("sts-svc self-test"))
+	sig, mode, err := signWithKey(keyType, privKey, digest[:], "", "self-test")
+	if err != nil {
+		return err
+	}
+
+	if ok, err := verifyWithKey(keyType, pubKey, digest[:], sig, mode, "self-test"); err != nil || !ok {
+		return fmt.Errorf("signature does not verify: %v", err)
+	}
+	sig[0] ^= 0xff
+	if ok, _ := verifyWithKey(keyType, pubKey, digest[:], sig, mode, "self-test"); ok {
+		return errors.New("tampered signature verifies")
+	}
+	return nil
+}
+
+// rngHealth catches a stuck or zeroed RNG, it isn't a statistical test
+func rngHealth() error {
+	a, b := make([]byte, 32), make([]byte, 32)
+	if _, err := rand.Read(a); err != nil {
+		return err
+	}
+	if _, err := rand.Read(b); err != nil {
+		return err
+	}
+	if bytes.Equal(a, b) || bytes.Equal(a, make([]byte, 32)) {
+		return errors.New("rng returned repeated or zero output")
+	}
+	return nil
+}
@@ -2,40 +2,495 @@ package main
 
 import (
 	"context"
+	"crypto/tls"
 	"encoding/json"
+	"errors"
 	"fmt"
-	"log"
+	"io"
+	"log/slog"
+	"net"
 	"net/http"
+	"os"
+	"slices"
 	"time"
+
+	"github.com/quic-go/quic-go/http3"
+	"google.golang.org/grpc"
 )
 
 type APIServer struct {
 	Service SignerService
+
+	// long-lived streams register here so they can be drained
+	Drainer *StreamDrainer
+
+	// address of another replica handed to streaming clients on drain
+	ReconnectHint string
+
+	// program deploy flows, routes are only mounted when set
+	Deploys *DeployManager
+
+	// idle key retirement recommendations, mounted when set
+	StaleKeys *StaleKeyAnalyzer
+
+	// admin bearer tokens, admin routes are only mounted when set
+	Operators *OperatorRegistry
+
+	// operators from an SSO provider, also enables the admin routes
+	OIDC *OIDCVerifier
+
+	// two-person approvals, decided by operators
+	Approvals *ApprovalQueue
+
+	// incident response, mounted w/ the other admin routes
+	KillSwitch *KillSwitch
+
+	// admin key operations, mounted w/ the other admin routes
+	Store *SecureKeyStore
+
+	// operator check-ins, mounted when set
+	DeadMan *DeadManSwitch
+
+	// verifies X-STS-Capability tokens on sign requests, nil ignores them
+	Capabilities *CapabilityIssuer
+
+	// refuse sign requests w/o a capability token
+	RequireCapability bool
+
+	// signing grants minted by operators, mounted w/ the other admin routes
+	Grants *GrantStore
+
+	// checks HMAC signed requests, nil skips the check
+	HMAC *HMACVerifier
+
+	// throttles repeated bad credentials, nil disables
+	Lockout *AuthLockout
+
+	// publishes the key attestation signer, mounted when set
+	Attester *Attester
+
+	// root key ceremonies, mounted w/ the other admin routes
+	Ceremonies *CeremonyManager
+
+	// serve HTTPS, nil serves plaintext which main only allows when asked to
+	TLS *tls.Config
+
+	// maps client certificate identities to principals, unmapped ones keep their name
+	Principals PrincipalMap
+
+	// scoped X-API-Key tokens, managed by operators
+	Tokens *APITokenStore
+
+	// refuse generate, sign and verify requests w/o an API token
+	RequireAPIToken bool
+
+	// request rate limits, global and per client, nil disables
+	Limiter *RequestLimiter
+
+	// serve a Swagger UI for the OpenAPI document at /api/v1/docs
+	SwaggerUI bool
+
+	// Prometheus metrics, served on /metrics when set
+	Metrics *Metrics
+
+	// dependencies /readyz reports on
+	Readiness []ReadinessCheck
+
+	// loopback address for pprof, expvar and runtime stats, empty leaves it off
+	DiagnosticsAddr string
+
+	// key and signing events, streamed to operators when set
+	Events *EventBus
+
+	// also serve the gRPC API here, e.g. ":9090", empty leaves it off. HMAC
+	// signing covers HTTP bodies only, STS_REQUIRE_HMAC refuses gRPC calls.
+	GRPCAddr string
+
+	// also serve HTTP/3 on this UDP address, e.g. ":8443", empty leaves it
+	// off. Needs TLS, the same handlers and middleware run behind it.
+	QUICAddr string
+
+	// runtime feature switches, shared w/ the signer and reloaded w/ the
+	// config file
+	Features *FeatureFlags
+
+	// lets browser clients on the allowed origins call the API, nil disables
+	CORS *CORSPolicy
+
+	// load balancers whose forwarding headers name the client, nil uses the peer
+	TrustedProxies TrustedProxies
+
+	// a signal here hands the listeners to a new process of the same
+	// binary, this one drains once it serves. Nil disables.
+	Upgrades <-chan os.Signal
+
+	// listening sockets, handed down by the previous process or systemd
+	sockets *socketSet
+
+	// operations per tenant and API token, exported to operators on
+	// /usage when set
+	Meter *UsageMeter
+
+	// SOC 2 evidence reports for operators, mounted w/ the admin routes
+	// when set. Failed authentication is counted here.
+	Compliance *ComplianceRecorder
+
+	// cluster endpoints, their health is shown to operators when set
+	RPC *RPCPool
+
+	// signed transactions' live status, served on /txs/{signature} and
+	// /keys/{id}/txs when set
+	Txs *TxTracker
+
+	// per key outbound queues, served on /keys/{id}/queue when set.
+	// Operators may also remove a halted transaction.
+	Outbound *OutboundQueue
+
+	// priority fee advice, served on /fees/priority when set
+	Fees *FeeAdvisor
+
+	// address of the key that pays fees for feePayer requests, served on
+	// /fee-payer when set
+	FeePayer string
+
+	// keys' on-chain balances, served on /keys/{id}/balance when set
+	Balances *KeyBalances
+
+	// faucet airdrops to keys off mainnet, served on /keys/{id}/airdrop when set
+	Airdrops *Airdropper
+
+	// builds common transactions for keys and signs them, mounted under
+	// /keys/{id}/transfers, /accounts, /stakes and /nonces when set
+	Builder *TxBuilder
+
+	// per key sign series from the audit trail, served on
+	// /keys/{id}/analytics when set
+	Analytics *KeyAnalytics
+
+	// scheduled key store backups, operators can also take one on demand
+	// when set
+	Backups *BackupScheduler
+
+	// listener, timeouts and body limits, zero fields take the defaults
+	Config Config
 }
 
 func NewAPIServer(svc SignerService) *APIServer {
 	return &APIServer{
 		Service: svc,
+		Drainer: NewStreamDrainer(),
 	}
 }
 
-func (s *APIServer) Run() {
+// BeginDrain tells every open stream to reconnect elsewhere, used when
+// shutdown or maintenance starts
+func (s *APIServer) BeginDrain(reason string) {
+	s.Drainer.Drain(GoAway{
+		Reason:       reason,
+		ReconnectTo:  s.ReconnectHint,
+		RetryAfterMs: 1000,
+	})
+}
+
+// routes mounts every API version, optional routes only when their
+// component is set
+func (s *APIServer) routes() *http.ServeMux {
 	router := http.NewServeMux()
-	router.HandleFunc("POST /api/v1/keys/generate", s.handleGenKey)
-	router.HandleFunc("POST /api/v1/txs/sign", s.handleTxSign)
-	router.HandleFunc("GET /", s.handleRoot)
+	for _, version := range apiVersions {
+		s.mountAPI(router, version)
+	}
+	router.HandleFunc("GET /{$}", s.handleRoot)
+	router.HandleFunc(unmatchedPattern, s.handleUnmatched(router))
+
+	// the spec and its UI describe v1
+	router.HandleFunc("GET /api/v1/openapi.json", s.handleOpenAPI)
+	if s.SwaggerUI {
+		router.HandleFunc("GET /api/v1/docs", s.handleSwaggerUI)
+	}
+
+	return router
+}
+
+// mountAPI mounts one version's routes under /api/<version>. Versions share
+// handlers, which branch on apiVersion where v2 behaves differently.
+func (s *APIServer) mountAPI(mux *http.ServeMux, version string) {
+	router := versionedMux{mux: mux, prefix: "/api/" + version, wrap: s.withRouteLimit}
+	router.HandleFunc("POST /keys/generate", s.handleGenKey)
+	router.HandleFunc("POST /txs/sign", s.handleTxSign)
+	router.HandleFunc("POST /signatures/verify", s.handleVerify)
+	if s.adminEnabled() {
+		router.HandleFunc("GET /usage/costs", requireOperator(s.handleCostUsage))
+	}
+	if s.Meter != nil && s.adminEnabled() {
+		router.HandleFunc("GET /usage", requireOperator(s.handleUsageExport))
+	}
+	router.HandleFunc("GET /fips", s.handleFIPSStatus)
+	router.HandleFunc("GET /version", s.handleVersion)
+	router.HandleFunc("GET /keys/{id}", s.handleKeyDetail)
+	if s.Analytics != nil {
+		router.HandleFunc("GET /keys/{id}/analytics", s.handleKeyAnalytics)
+	}
+	if s.Txs != nil {
+		router.HandleFunc("GET /txs/{signature}", s.handleTxStatus)
+		router.HandleFunc("GET /keys/{id}/txs", s.handleTxHistory)
+	}
+	if s.Outbound != nil {
+		router.HandleFunc("GET /keys/{id}/queue", s.handleQueueList)
+		router.HandleFunc("POST /keys/{id}/queue/{entry}/retry", s.handleQueueRetry)
+	}
+	if s.Fees != nil {
+		router.HandleFunc("GET /fees/priority", s.handlePriorityFees)
+	}
+	if s.FeePayer != "" {
+		router.HandleFunc("GET /fee-payer", s.handleFeePayer)
+	}
+	if s.Balances != nil {
+		router.HandleFunc("GET /keys/{id}/balance", s.handleKeyBalance)
+	}
+	if s.Airdrops != nil {
+		router.HandleFunc("POST /keys/{id}/airdrop", s.handleAirdrop)
+	}
+	if s.Builder != nil {
+		router.HandleFunc("POST /keys/{id}/transfers/token", s.handleTokenTransfer)
+		router.HandleFunc("POST /keys/{id}/accounts/token", s.handleTokenAccount)
+		router.HandleFunc("POST /keys/{id}/stakes", s.handleStakeCreate)
+		router.HandleFunc("POST /keys/{id}/stakes/{stake}/delegate", s.handleStakeDelegate)
+		router.HandleFunc("POST /keys/{id}/stakes/{stake}/deactivate", s.handleStakeDeactivate)
+		router.HandleFunc("POST /keys/{id}/stakes/{stake}/withdraw", s.handleStakeWithdraw)
+		router.HandleFunc("POST /keys/{id}/nonces", s.handleNonceCreate)
+		router.HandleFunc("GET /keys/{id}/nonces/{nonce}", s.handleNonceGet)
+		router.HandleFunc("POST /keys/{id}/nonces/{nonce}/advance", s.handleNonceAdvance)
+		router.HandleFunc("POST /keys/{id}/nonces/{nonce}/withdraw", s.handleNonceWithdraw)
+	}
+
+	if s.Attester != nil {
+		router.HandleFunc("GET /attestation/key", s.handleAttestationKey)
+	}
+
+	if s.StaleKeys != nil {
+		router.HandleFunc("GET /keys/stale", s.handleStaleKeys)
+	}
+
+	if s.Deploys != nil && s.adminEnabled() {
+		router.HandleFunc("POST /deploys", requireOperator(s.handleDeployStart))
+		router.HandleFunc("GET /deploys/{id}", requireOperator(s.handleDeployGet))
+		router.HandleFunc("POST /deploys/{id}/write", requireOperator(s.handleDeployWrite))
+		router.HandleFunc("POST /deploys/{id}/finalize", requireOperator(s.handleDeployFinalize))
+		router.HandleFunc("POST /deploys/{id}/close", requireOperator(s.handleDeployClose))
+	}
+
+	if s.Approvals != nil && s.adminEnabled() {
+		router.HandleFunc("GET /approvals", requireOperator(s.handleApprovalList))
+		router.HandleFunc("GET /approvals/audit", requireOperator(s.handleApprovalAudit))
+		router.HandleFunc("GET /approvals/{id}", s.handleApprovalGet)
+		router.HandleFunc("POST /approvals/{id}/approve", requireOperator(s.handleApprovalApprove))
+		router.HandleFunc("POST /approvals/{id}/reject", requireOperator(s.handleApprovalReject))
+	}
+
+	if s.KillSwitch != nil && s.adminEnabled() {
+		router.HandleFunc("POST /admin/killswitch", requireOperator(s.handleKillSwitch))
+		router.HandleFunc("GET /admin/seal", requireOperator(s.handleSealStatus))
+		router.HandleFunc("POST /admin/seal", requireOperator(s.handleSeal))
+		router.HandleFunc("POST /admin/unseal", requireOperator(s.handleUnseal))
+	}
+
+	if s.Store != nil && s.adminEnabled() {
+		router.HandleFunc("POST /keys/{id}/freeze", requireOperator(s.handleKeyFreeze))
+		router.HandleFunc("POST /keys/{id}/unfreeze", requireOperator(s.handleKeyUnfreeze))
+		router.HandleFunc("DELETE /keys/{id}", requireOperator(s.handleKeyDelete))
+	}
+
+	if s.Outbound != nil && s.adminEnabled() {
+		router.HandleFunc("DELETE /keys/{id}/queue/{entry}", requireOperator(s.handleQueueRemove))
+	}
+
+	if s.Capabilities != nil && s.adminEnabled() {
+		router.HandleFunc("POST /capabilities", requireOperator(s.handleCapabilityMint))
+	}
+
+	if s.Tokens != nil && s.adminEnabled() {
+		router.HandleFunc("POST /tokens", requireOperator(s.handleTokenMint))
+		router.HandleFunc("GET /tokens", requireOperator(s.handleTokenList))
+		router.HandleFunc("DELETE /tokens/{id}", requireOperator(s.handleTokenRevoke))
+	}
+
+	if s.Grants != nil && s.adminEnabled() {
+		router.HandleFunc("POST /grants", requireOperator(s.handleGrantMint))
+		router.HandleFunc("DELETE /grants/{id}", requireOperator(s.handleGrantRevoke))
+	}
+
+	if s.Ceremonies != nil && s.adminEnabled() {
+		router.HandleFunc("POST /ceremonies", requireOperator(s.handleCeremonyStart))
+		router.HandleFunc("GET /ceremonies/{id}", requireOperator(s.handleCeremonyGet))
+		router.HandleFunc("GET /ceremonies/{id}/transcript", requireOperator(s.handleCeremonyTranscript))
+		router.HandleFunc("POST /ceremonies/{id}/attend", requireOperator(s.handleCeremonyAttend))
+		router.HandleFunc("POST /ceremonies/{id}/entropy", requireOperator(s.handleCeremonyEntropy))
+		router.HandleFunc("POST /ceremonies/{id}/complete", requireOperator(s.handleCeremonyComplete))
+		router.HandleFunc("POST /ceremonies/{id}/abort", requireOperator(s.handleCeremonyAbort))
+	}
+
+	if s.Events != nil && s.adminEnabled() {
+		router.HandleFunc("GET /events", requireOperator(s.handleEvents))
+	}
+
+	if s.Features != nil && s.adminEnabled() {
+		router.HandleFunc("GET /admin/features", requireOperator(s.handleFeatures))
+	}
+
+	if s.Compliance != nil && s.adminEnabled() {
+		router.HandleFunc("GET /admin/reports/compliance", requireOperator(s.handleComplianceReport))
+	}
+
+	if s.RPC != nil && s.adminEnabled() {
+		router.HandleFunc("GET /admin/rpc/endpoints", requireOperator(s.handleRPCEndpoints))
+	}
+
+	if s.Backups != nil && s.adminEnabled() {
+		router.HandleFunc("POST /admin/backups", requireOperator(s.handleBackup))
+	}
+
+	if s.DeadMan != nil && s.adminEnabled() {
+		router.HandleFunc("POST /admin/heartbeat", requireOperator(s.handleHeartbeat))
+		router.HandleFunc("GET /admin/heartbeat", requireOperator(s.handleHeartbeatStatus))
+	}
+}
+
+// middleware tags, logs, authenticates and throttles requests before next sees them
+func (s *APIServer) middleware(next http.Handler) http.Handler {
+	return withRequestID(s.withClientIP(s.withAccessLog(withNegotiation(s.withRateLimit(s.withHMAC(s.withAPIToken(s.withPrincipal(s.withOperator(s.withTenant(s.recordCaller(next)))))))))))
+}
+
+// Run serves on the configured address and Unix socket until ctx is done,
+// then shuts down gracefully
+func (s *APIServer) Run(ctx context.Context) error {
+	cfg := s.Config.orDefaults()
+
+	// sockets handed down are taken over instead of listening again
+	s.sockets = inheritedSockets(cfg.ReusePort)
+
+	var listeners []net.Listener
+	if cfg.Addr != AddrOff {
+		lis, err := s.sockets.listen(socketAPI, "tcp", cfg.Addr)
+		if err != nil {
+			return err
+		}
+		listeners = append(listeners, lis)
+	}
+	if cfg.Socket != "" {
+		lis, err := s.sockets.listenUnix(cfg.Socket, cfg.SocketMode)
+		if err != nil {
+			closeAll(listeners)
+			return err
+		}
+		listeners = append(listeners, lis)
+	}
+
+	if s.Upgrades != nil {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithCancel(ctx)
+		defer cancel()
+		go s.watchUpgrades(ctx, s.Upgrades, cancel)
+	}
+	return s.Serve(ctx, listeners...)
+}
+
+// Serve serves the API on every listener, and the gRPC and diagnostics
+// listeners when set, until ctx is done or one of them fails. Either way
+// everything is shut down and in-memory keys are zeroized before it returns.
+// Unix sockets are served w/o TLS, their file permissions guard them.
+func (s *APIServer) Serve(ctx context.Context, listeners ...net.Listener) error {
+	router := s.routes()
+	cfg := s.Config.orDefaults()
 
 	// server w/ secure settings
 	server := &http.Server{
-		Addr:         ":8080",
-		Handler:      router,
-		ReadTimeout:  5 * time.Second,
-		WriteTimeout: 10 * time.Second,
-		IdleTimeout:  30 * time.Second,
+		Handler:      s.probes(s.withMetrics(router, withTracing(router, s.withCORS(s.middleware(router))))),
+		TLSConfig:    s.TLS,
+		ReadTimeout:  cfg.ReadTimeout,
+		WriteTimeout: cfg.WriteTimeout,
+		IdleTimeout:  cfg.IdleTimeout,
+		HTTP2:        &http.HTTP2Config{MaxConcurrentStreams: cfg.HTTP2MaxStreams},
+	}
+	// HTTP/2 is negotiated over TLS, plaintext listeners only take it when
+	// h2c is on
+	server.Protocols = new(http.Protocols)
+	server.Protocols.SetHTTP1(true)
+	server.Protocols.SetHTTP2(true)
+	server.Protocols.SetUnencryptedHTTP2(cfg.H2C)
+	servers := []*http.Server{server}
+
+	// each listener reports here when it stops
+	errs := make(chan error, len(listeners)+3)
+
+	var quicServer *http3.Server
+	if s.QUICAddr != "" {
+		quic, conn, err := s.quicServer(server.Handler, cfg)
+		if err != nil {
+			closeAll(listeners)
+			return err
+		}
+		quicServer = quic
+		server.Handler = withAltSvc(quic, server.Handler)
+		slog.Info("Secure Signer Service running on HTTP/3", logServer, "url", "https://"+conn.LocalAddr().String())
+		go func() { errs <- quic.Serve(conn) }()
 	}
 
-	log.Println("Secure Signer Service running on http://localhost:8080")
-	log.Fatal(server.ListenAndServe())
+	var grpcServer *grpc.Server
+	if s.GRPCAddr != "" {
+		grpcLis, err := s.sockets.listen(socketGRPC, "tcp", s.GRPCAddr)
+		if err != nil {
+			closeAll(listeners)
+			return fmt.Errorf("gRPC listen on %s: %w", s.GRPCAddr, err)
+		}
+		grpcServer = s.NewGRPCServer()
+		slog.Info("Secure Signer gRPC service listening", logServer, "addr", s.GRPCAddr)
+		go func() { errs <- grpcServer.Serve(grpcLis) }()
+	}
+	if s.DiagnosticsAddr != "" {
+		diag, err := diagnosticsServer(s.DiagnosticsAddr)
+		if err != nil {
+			closeAll(listeners)
+			return err
+		}
+		diagLis, err := s.sockets.listen(socketDiag, "tcp", s.DiagnosticsAddr)
+		if err != nil {
+			closeAll(listeners)
+			return fmt.Errorf("diagnostics listen on %s: %w", s.DiagnosticsAddr, err)
+		}
+		servers = append(servers, diag)
+		slog.Info("Diagnostics listening", logServer, "url", "http://"+s.DiagnosticsAddr+"/debug/pprof/")
+		go func() { errs <- diag.Serve(diagLis) }()
+	}
+
+	for _, lis := range listeners {
+		go func() {
+			switch {
+			case lis.Addr().Network() == "unix":
+				slog.Info("Secure Signer Service running", logServer, "url", "unix://"+lis.Addr().String())
+				errs <- server.Serve(lis)
+			case s.TLS != nil:
+				slog.Info("Secure Signer Service running", logServer, "url", "https://"+lis.Addr().String())
+				// certificates come from TLSConfig
+				errs <- server.ServeTLS(lis, "", "")
+			default:
+				slog.Info("Secure Signer Service running", logServer, "url", "http://"+lis.Addr().String())
+				errs <- server.Serve(lis)
+			}
+		}()
+	}
+
+	// a parent handing off drains once this process serves
+	s.sockets.serving()
+
+	var err error
+	select {
+	case err = <-errs:
+		slog.Error("Listener failed, shutting down", logServer, "err", err)
+	case <-ctx.Done():
+		slog.Info("Shutdown requested, draining in-flight requests", logServer)
+	}
+	s.shutdown(servers, grpcServer, quicServer)
+	return err
 }
 
 func (s *APIServer) handleRoot(w http.ResponseWriter, r *http.Request) {
@@ -46,58 +501,248 @@ func (s *APIServer) handleRoot(w http.ResponseWriter, r *http.Request) {
 func (s *APIServer) handleGenKey(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
 
-	acc, err := s.Service.GenerateKey(r.Context())
+	// body is optional, an empty one gets the default policy
+	var req KeyGenRequest
+	if err := decodeJSON(r.Body, &req); err != nil && err != io.EOF {
+		writeError(w, r, http.StatusBadRequest, invalidBody(err))
+		return
+	}
+	if key := r.Header.Get("Idempotency-Key"); key != "" {
+		req.IdempotencyKey = key
+	}
+	if apiVersion(r) == APIv2 {
+		if err := checkKeyGenV2(req); err != nil {
+			writeError(w, r, http.StatusBadRequest, err)
+			return
+		}
+	}
+
+	if err := s.checkAPIToken(r, TokenOpGenerate, ""); err != nil {
+		refuseAPIToken(w, r, err)
+		return
+	}
+
+	acc, err := s.Service.GenerateKey(r.Context(), req)
 	if err != nil {
-		http.Error(w, fmt.Sprintf(`{"error": "%s"}`, err), http.StatusInternalServerError)
+		writeError(w, r, genKeyErrorStatus(err), err)
 		return
 	}
 
 	json.NewEncoder(w).Encode(acc)
 }
 
+func genKeyErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, errSealed):
+		return http.StatusServiceUnavailable
+	case errors.Is(err, errIdempotencyConflict):
+		return http.StatusUnprocessableEntity
+	case errors.Is(err, errFeatureDisabled):
+		return http.StatusForbidden
+	case errors.Is(err, errNotFIPSApproved):
+		return http.StatusBadRequest
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
 func (s *APIServer) handleTxSign(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
 
-	// max body size
-	r.Body = http.MaxBytesReader(w, r.Body, 4096)
-
 	var req TransactionRequest
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		http.Error(w, fmt.Sprintf(`{"error": "Invalid request body: %v"}`, err), http.StatusBadRequest)
+	if err := decodeJSON(r.Body, &req); err != nil {
+		writeError(w, r, http.StatusBadRequest, invalidBody(err))
+		return
+	}
+
+	// header takes precedence over the body field
+	if key := r.Header.Get("Idempotency-Key"); key != "" {
+		req.IdempotencyKey = key
+	}
+	if grant := r.Header.Get("X-STS-Grant"); grant != "" {
+		req.Grant = grant
+	}
+
+	s.signAndRespond(w, r, req, nil)
+}
+
+// signAndRespond authorizes and signs the request and writes the result,
+// shared by the sign route and the transaction builders. body wraps the
+// result for builders that return more, nil writes it as is.
+func (s *APIServer) signAndRespond(w http.ResponseWriter, r *http.Request, req TransactionRequest, body func(TransactionResult) any) {
+	w.Header().Set("Content-Type", "application/json")
+
+	if status, err := s.authorizeSign(r, req); err != nil {
+		writeError(w, r, status, err)
 		return
 	}
 
+	res, err := s.signWithTimeout(r.Context(), req)
+	if err != nil {
+		status := signErrorStatus(err)
+		if errors.Is(err, errSignTimeout) {
+			status = http.StatusGatewayTimeout
+		}
+		writeError(w, r, status, err)
+		return
+	}
+	// parked for a second operator, poll the approval for the signature
+	if res.ApprovalID != "" && res.Signature == "" {
+		w.Header().Set("Location", apiPrefix(r)+"/approvals/"+res.ApprovalID)
+		w.WriteHeader(http.StatusAccepted)
+	}
+	if body != nil {
+		json.NewEncoder(w).Encode(body(res))
+		return
+	}
+	json.NewEncoder(w).Encode(res)
+}
+
+// authorizeSign runs the API token, capability and caller checks a sign
+// request must pass before the signer sees it
+func (s *APIServer) authorizeSign(r *http.Request, req TransactionRequest) (int, error) {
+	if err := s.checkAPIToken(r, TokenOpSign, req.KeyID); err != nil {
+		return apiTokenErrorStatus(err), err
+	}
+
+	if err := s.checkCapability(r, req); err != nil {
+		slog.WarnContext(r.Context(), "Refusing sign request", logAuth, "key_id", req.KeyID, "err", err)
+		if errors.Is(err, errCapabilityInvalid) {
+			return http.StatusUnauthorized, err
+		}
+		return http.StatusForbidden, err
+	}
+	return 0, nil
+}
+
+// how long the signer gets when neither the route nor the client set a deadline
+const defaultSignTimeout = 5 * time.Second
+
+// signDeadline is the request's own deadline, from the route's configured
+// timeout or a gRPC client, else defaultSignTimeout from now
+func signDeadline(ctx context.Context) time.Time {
+	if deadline, ok := ctx.Deadline(); ok {
+		return deadline
+	}
+	return time.Now().Add(defaultSignTimeout)
+}
+
+// signWithTimeout gives the signer until signDeadline, past that the caller
+// gets errSignTimeout
+func (s *APIServer) signWithTimeout(ctx context.Context, req TransactionRequest) (TransactionResult, error) {
 	// channel to get result from background go routines
-	resultChan := make(chan TransactionResult)
+	type signOutcome struct {
+		res TransactionResult
+		err error
+	}
+	resultChan := make(chan signOutcome)
 
-	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+	ctx, cancel := context.WithDeadline(ctx, signDeadline(ctx))
 	defer cancel() // to release resources later
 
 	// launching signing in go routine
 	go func() {
 		res, err := s.Service.SignTransaction(ctx, req)
-		if err != nil {
-			res.Error = err.Error()
-		}
 
 		select {
-		case resultChan <- res:
+		case resultChan <- signOutcome{res, err}:
 		case <-ctx.Done():
-			log.Printf("Goroutine for %s finished but context was already done.", req.KeyID)
+			slog.WarnContext(ctx, "Sign finished after the deadline", logSigner, "key_id", req.KeyID)
 		}
 	}()
 
 	// wait for result
 
 	select {
-	case res := <-resultChan:
-		if res.Error != "" {
-			http.Error(w, fmt.Sprintf(`{"error": "%s"}`, res.Error), http.StatusBadRequest)
-			return
+	case outcome := <-resultChan:
+		return outcome.res, outcome.err
+	case <-ctx.Done():
+		return TransactionResult{}, errSignTimeout
+	}
+}
+
+// signErrorStatus maps sign failures that clients should handle differently
+func signErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, errRateLimited):
+		return http.StatusTooManyRequests
+	case errors.Is(err, errSealed):
+		return http.StatusServiceUnavailable
+	case errors.Is(err, errKeyFrozen):
+		return http.StatusLocked
+	case errors.Is(err, errAnomalous):
+		return http.StatusForbidden
+	case errors.Is(err, errGrantInvalid), errors.Is(err, errGrantRequired):
+		return http.StatusForbidden
+	case errors.Is(err, errFeatureDisabled):
+		return http.StatusForbidden
+	case errors.Is(err, errReplay):
+		return http.StatusConflict
+	case errors.Is(err, errIdempotencyConflict):
+		return http.StatusUnprocessableEntity
+	case errors.Is(err, errRPCUnavailable):
+		return http.StatusBadGateway
+	default:
+		return http.StatusBadRequest
+	}
+}
+
+func (s *APIServer) handleVerify(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	var req VerifyRequest
+	if err := decodeJSON(r.Body, &req); err != nil {
+		writeError(w, r, http.StatusBadRequest, invalidBody(err))
+		return
+	}
+
+	if err := s.checkAPIToken(r, TokenOpVerify, ""); err != nil {
+		refuseAPIToken(w, r, err)
+		return
+	}
+
+	res, err := s.Service.VerifySignature(r.Context(), req)
+	if err != nil {
+		writeError(w, r, http.StatusBadRequest, err)
+		return
+	}
+
+	json.NewEncoder(w).Encode(res)
+}
+
+// withTenant bills requests to a tenant the caller proved. An API token's
+// tenant was set by withAPIToken, operators act for any tenant and name it
+// in X-Tenant-ID. Anyone else is the default tenant whatever the header says.
+func (s *APIServer) withTenant(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if APITokenFromContext(r.Context()) == "" {
+			tenant := ""
+			if OperatorFromContext(r.Context()).Name != "" {
+				tenant = r.Header.Get("X-Tenant-ID")
+			}
+			r = r.WithContext(WithTenant(r.Context(), tenant))
 		}
-		json.NewEncoder(w).Encode(res)
+		next.ServeHTTP(w, r)
+	})
+}
 
-	case <-ctx.Done():
-		http.Error(w, fmt.Sprintf(`{"error": "Tx signing request timedout %d"}`, http.StatusGatewayTimeout), http.StatusGatewayTimeout)
+func (s *APIServer) handleCostUsage(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	json.NewEncoder(w).Encode(s.Service.CostReport(r.Context(), r.URL.Query().Get("tenant")))
+}
+
+func (s *APIServer) handleStaleKeys(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := s.checkRead(r, ""); err != nil {
+		refuseAPIToken(w, r, err)
+		return
 	}
+
+	// a token scoped to some keys only sees those
+	report := s.StaleKeys.Report()
+	report.Recommendations = slices.DeleteFunc(slices.Clone(report.Recommendations), func(rec RetirementRecommendation) bool {
+		return s.checkRead(r, rec.KeyID) != nil
+	})
+	json.NewEncoder(w).Encode(report)
 }
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"crypto/rand"
+	"errors"
+	"fmt"
+)
+
+// Shamir secret sharing over GF(2^8) w/ the AES polynomial. A share is its
+// x coordinate followed by one y byte per secret byte.
+
+var gfExp, gfLog [256]byte
+
+func init() {
+	x := byte(1)
+	for i := range 255 {
+		gfExp[i] = x
+		gfLog[x] = byte(i)
+		// multiply by the generator 3
+		x ^= gfDouble(x)
+	}
+	gfExp[255] = gfExp[0]
+}
+
+func gfDouble(x byte) byte {
+	if x&0x80 != 0 {
+		return x<<1 ^ 0x1b
+	}
+	return x << 1
+}
+
+func gfMul(a, b byte) byte {
+	if a == 0 || b == 0 {
+		return 0
+	}
+	return gfExp[(int(gfLog[a])+int(gfLog[b]))%255]
+}
+
+func gfDiv(a, b byte) byte {
+	if a == 0 {
+		return 0
+	}
+	return gfExp[(int(gfLog[a])-int(gfLog[b])+255)%255]
+}
+
+// SplitSecret returns n shares, any threshold of which rebuild the secret
+func SplitSecret(secret []byte, n, threshold int) ([][]byte, error) {
+	if threshold < 2 || threshold > n || n > 255 {
+		return nil, fmt.Errorf("need 2 <= threshold <= shares <= 255, got %d of %d", threshold, n)
+	}
+	if len(secret) == 0 {
+		return nil, errors.New("secret cannot be empty")
+	}
+
+	shares := make([][]byte, n)
+	for i := range shares {
+		shares[i] = make([]byte, len(secret)+1)
+		shares[i][0] = byte(i + 1)
+	}
+
+	coeffs := make([]byte, threshold)
+	defer clear(coeffs)
+	for b, s := range secret {
+		coeffs[0] = s
+		if _, err := rand.Read(coeffs[1:]); err != nil {
+			return nil, err
+		}
+		for _, share := range shares {
+			// horner's rule at x
+			var y byte
+			for c := threshold - 1; c >= 0; c-- {
+				y = gfMul(y, share[0]) ^ coeffs[c]
+			}
+			share[b+1] = y
+		}
+	}
+	return shares, nil
+}
+
+// CombineShares rebuilds the secret, it can't tell if too few shares were given
+func CombineShares(shares [][]byte) ([]byte, error) {
+	if len(shares) < 2 {
+		return nil, errors.New("need at least 2 shares")
+	}
+	size := len(shares[0])
+	seen := make(map[byte]bool)
+	for _, share := range shares {
+		if len(share) != size || size < 2 || share[0] == 0 || seen[share[0]] {
+			return nil, errors.New("shares are malformed or duplicated")
+		}
+		seen[share[0]] = true
+	}
+
+	secret := make([]byte, size-1)
+	for b := range secret {
+		// lagrange interpolation at x = 0
+		var y byte
+		for i, si := range shares {
+			basis := byte(1)
+			for j, sj := range shares {
+				if i != j {
+					basis = gfMul(basis, gfDiv(sj[0], sj[0]^si[0]))
+				}
+			}
+			y ^= gfMul(si[b+1], basis)
+		}
+		secret[b] = y
+	}
+	return secret, nil
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+	"sync"
+
+	"github.com/quic-go/quic-go/http3"
+	"google.golang.org/grpc"
+)
+
+// shutdown stops taking new work, waits for in-flight requests and streams
+// up to the shutdown timeout, then zeroizes what's held in memory
+func (s *APIServer) shutdown(servers []*http.Server, grpcServer *grpc.Server, quicServer *http3.Server) {
+	ctx, cancel := context.WithTimeout(context.Background(), s.Config.orDefaults().ShutdownTimeout)
+	defer cancel()
+
+	// readiness fails and streams are told to reconnect elsewhere first
+	s.BeginDrain("shutdown")
+
+	var wg sync.WaitGroup
+	for _, server := range servers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			// stops accepting and waits for handlers, hijacked streams are left to the drainer
+			if err := server.Shutdown(ctx); err != nil {
+				slog.Warn("Shutdown deadline passed, closing connections", logServer, "err", err)
+				server.Close()
+			}
+		}()
+	}
+	if grpcServer != nil {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			stopped := make(chan struct{})
+			go func() {
+				grpcServer.GracefulStop()
+				close(stopped)
+			}()
+			select {
+			case <-stopped:
+			case <-ctx.Done():
+				slog.Warn("Shutdown deadline passed, cancelling open gRPC calls", logServer)
+				grpcServer.Stop()
+			}
+		}()
+	}
+	if quicServer != nil {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			// sends GOAWAY and waits for open requests
+			if err := quicServer.Shutdown(ctx); err != nil {
+				slog.Warn("Shutdown deadline passed, closing QUIC connections", logServer, "err", err)
+				quicServer.Close()
+			}
+		}()
+	}
+	wg.Wait()
+
+	if err := s.Drainer.Wait(ctx); err != nil {
+		slog.Warn("Streams still open at the shutdown deadline", logServer, "streams", s.Drainer.Open())
+	}
+
+	s.zeroize()
+}
+
+// zeroize clears keys held in memory, nothing signs after this
+func (s *APIServer) zeroize() {
+	if s.Store != nil {
+		s.Store.ZerorizeAll("")
+	}
+	s.Attester.Zeroize()
+}
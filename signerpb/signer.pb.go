@@ -0,0 +1,1381 @@
+// Code generated by protoc-gen-go. DO NOT EDIT.
+// versions:
+// 	protoc-gen-go v1.36.11
+// 	protoc        (unknown)
+// source: signerpb/signer.proto
+
+package signerpb
+
+import (
+	protoreflect "google.golang.org/protobuf/reflect/protoreflect"
+	protoimpl "google.golang.org/protobuf/runtime/protoimpl"
+	reflect "reflect"
+	sync "sync"
+	unsafe "unsafe"
+)
+
+const (
+	// Verify that this generated code is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(20 - protoimpl.MinVersion)
+	// Verify that runtime/protoimpl is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(protoimpl.MaxVersion - 20)
+)
+
+type GenerateKeyRequest struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	// ed25519 (default), secp256k1 or p256
+	KeyType string `protobuf:"bytes,1,opt,name=key_type,json=keyType,proto3" json:"key_type,omitempty"`
+	// KeyPolicy as JSON, same shape as the HTTP API, empty for single-use
+	PolicyJson []byte `protobuf:"bytes,2,opt,name=policy_json,json=policyJson,proto3" json:"policy_json,omitempty"`
+	// defaults to the tenant
+	Namespace     string `protobuf:"bytes,3,opt,name=namespace,proto3" json:"namespace,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *GenerateKeyRequest) Reset() {
+	*x = GenerateKeyRequest{}
+	mi := &file_signerpb_signer_proto_msgTypes[0]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *GenerateKeyRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*GenerateKeyRequest) ProtoMessage() {}
+
+func (x *GenerateKeyRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_signerpb_signer_proto_msgTypes[0]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use GenerateKeyRequest.ProtoReflect.Descriptor instead.
+func (*GenerateKeyRequest) Descriptor() ([]byte, []int) {
+	return file_signerpb_signer_proto_rawDescGZIP(), []int{0}
+}
+
+func (x *GenerateKeyRequest) GetKeyType() string {
+	if x != nil {
+		return x.KeyType
+	}
+	return ""
+}
+
+func (x *GenerateKeyRequest) GetPolicyJson() []byte {
+	if x != nil {
+		return x.PolicyJson
+	}
+	return nil
+}
+
+func (x *GenerateKeyRequest) GetNamespace() string {
+	if x != nil {
+		return x.Namespace
+	}
+	return ""
+}
+
+type Account struct {
+	state      protoimpl.MessageState `protogen:"open.v1"`
+	PublicKey  string                 `protobuf:"bytes,1,opt,name=public_key,json=publicKey,proto3" json:"public_key,omitempty"`
+	KeyType    string                 `protobuf:"bytes,2,opt,name=key_type,json=keyType,proto3" json:"key_type,omitempty"`
+	Namespace  string                 `protobuf:"bytes,3,opt,name=namespace,proto3" json:"namespace,omitempty"`
+	PolicyJson []byte                 `protobuf:"bytes,4,opt,name=policy_json,json=policyJson,proto3" json:"policy_json,omitempty"`
+	// set when an attester is configured
+	Attestation   *Attestation `protobuf:"bytes,5,opt,name=attestation,proto3" json:"attestation,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *Account) Reset() {
+	*x = Account{}
+	mi := &file_signerpb_signer_proto_msgTypes[1]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *Account) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Account) ProtoMessage() {}
+
+func (x *Account) ProtoReflect() protoreflect.Message {
+	mi := &file_signerpb_signer_proto_msgTypes[1]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Account.ProtoReflect.Descriptor instead.
+func (*Account) Descriptor() ([]byte, []int) {
+	return file_signerpb_signer_proto_rawDescGZIP(), []int{1}
+}
+
+func (x *Account) GetPublicKey() string {
+	if x != nil {
+		return x.PublicKey
+	}
+	return ""
+}
+
+func (x *Account) GetKeyType() string {
+	if x != nil {
+		return x.KeyType
+	}
+	return ""
+}
+
+func (x *Account) GetNamespace() string {
+	if x != nil {
+		return x.Namespace
+	}
+	return ""
+}
+
+func (x *Account) GetPolicyJson() []byte {
+	if x != nil {
+		return x.PolicyJson
+	}
+	return nil
+}
+
+func (x *Account) GetAttestation() *Attestation {
+	if x != nil {
+		return x.Attestation
+	}
+	return nil
+}
+
+type Attestation struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	// the AttestationStatement JSON the signature covers
+	StatementJson []byte `protobuf:"bytes,1,opt,name=statement_json,json=statementJson,proto3" json:"statement_json,omitempty"`
+	// base64 ed25519 signature and the attester's hex public key
+	Signature     string `protobuf:"bytes,2,opt,name=signature,proto3" json:"signature,omitempty"`
+	SignerKey     string `protobuf:"bytes,3,opt,name=signer_key,json=signerKey,proto3" json:"signer_key,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *Attestation) Reset() {
+	*x = Attestation{}
+	mi := &file_signerpb_signer_proto_msgTypes[2]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *Attestation) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Attestation) ProtoMessage() {}
+
+func (x *Attestation) ProtoReflect() protoreflect.Message {
+	mi := &file_signerpb_signer_proto_msgTypes[2]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Attestation.ProtoReflect.Descriptor instead.
+func (*Attestation) Descriptor() ([]byte, []int) {
+	return file_signerpb_signer_proto_rawDescGZIP(), []int{2}
+}
+
+func (x *Attestation) GetStatementJson() []byte {
+	if x != nil {
+		return x.StatementJson
+	}
+	return nil
+}
+
+func (x *Attestation) GetSignature() string {
+	if x != nil {
+		return x.Signature
+	}
+	return ""
+}
+
+func (x *Attestation) GetSignerKey() string {
+	if x != nil {
+		return x.SignerKey
+	}
+	return ""
+}
+
+type SignRequest struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	KeyId string                 `protobuf:"bytes,1,opt,name=key_id,json=keyId,proto3" json:"key_id,omitempty"`
+	// base64, as in the HTTP API
+	UnsignedTxData string `protobuf:"bytes,2,opt,name=unsigned_tx_data,json=unsignedTxData,proto3" json:"unsigned_tx_data,omitempty"`
+	SigningMode    string `protobuf:"bytes,3,opt,name=signing_mode,json=signingMode,proto3" json:"signing_mode,omitempty"`
+	Context        string `protobuf:"bytes,4,opt,name=context,proto3" json:"context,omitempty"`
+	IdempotencyKey string `protobuf:"bytes,5,opt,name=idempotency_key,json=idempotencyKey,proto3" json:"idempotency_key,omitempty"`
+	Broadcast      bool   `protobuf:"varint,6,opt,name=broadcast,proto3" json:"broadcast,omitempty"`
+	Simulate       bool   `protobuf:"varint,7,opt,name=simulate,proto3" json:"simulate,omitempty"`
+	Grant          string `protobuf:"bytes,8,opt,name=grant,proto3" json:"grant,omitempty"`
+	Nonce          string `protobuf:"bytes,9,opt,name=nonce,proto3" json:"nonce,omitempty"`
+	Timestamp      int64  `protobuf:"varint,10,opt,name=timestamp,proto3" json:"timestamp,omitempty"`
+	// the solana-tx options, as in the HTTP API
+	Queue            bool   `protobuf:"varint,11,opt,name=queue,proto3" json:"queue,omitempty"`
+	NotifyCommitment string `protobuf:"bytes,12,opt,name=notify_commitment,json=notifyCommitment,proto3" json:"notify_commitment,omitempty"`
+	Cluster          string `protobuf:"bytes,13,opt,name=cluster,proto3" json:"cluster,omitempty"`
+	RefreshBlockhash bool   `protobuf:"varint,14,opt,name=refresh_blockhash,json=refreshBlockhash,proto3" json:"refresh_blockhash,omitempty"`
+	NonceAccount     string `protobuf:"bytes,15,opt,name=nonce_account,json=nonceAccount,proto3" json:"nonce_account,omitempty"`
+	PriorityFee      bool   `protobuf:"varint,16,opt,name=priority_fee,json=priorityFee,proto3" json:"priority_fee,omitempty"`
+	FeePayer         bool   `protobuf:"varint,17,opt,name=fee_payer,json=feePayer,proto3" json:"fee_payer,omitempty"`
+	unknownFields    protoimpl.UnknownFields
+	sizeCache        protoimpl.SizeCache
+}
+
+func (x *SignRequest) Reset() {
+	*x = SignRequest{}
+	mi := &file_signerpb_signer_proto_msgTypes[3]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *SignRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*SignRequest) ProtoMessage() {}
+
+func (x *SignRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_signerpb_signer_proto_msgTypes[3]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use SignRequest.ProtoReflect.Descriptor instead.
+func (*SignRequest) Descriptor() ([]byte, []int) {
+	return file_signerpb_signer_proto_rawDescGZIP(), []int{3}
+}
+
+func (x *SignRequest) GetKeyId() string {
+	if x != nil {
+		return x.KeyId
+	}
+	return ""
+}
+
+func (x *SignRequest) GetUnsignedTxData() string {
+	if x != nil {
+		return x.UnsignedTxData
+	}
+	return ""
+}
+
+func (x *SignRequest) GetSigningMode() string {
+	if x != nil {
+		return x.SigningMode
+	}
+	return ""
+}
+
+func (x *SignRequest) GetContext() string {
+	if x != nil {
+		return x.Context
+	}
+	return ""
+}
+
+func (x *SignRequest) GetIdempotencyKey() string {
+	if x != nil {
+		return x.IdempotencyKey
+	}
+	return ""
+}
+
+func (x *SignRequest) GetBroadcast() bool {
+	if x != nil {
+		return x.Broadcast
+	}
+	return false
+}
+
+func (x *SignRequest) GetSimulate() bool {
+	if x != nil {
+		return x.Simulate
+	}
+	return false
+}
+
+func (x *SignRequest) GetGrant() string {
+	if x != nil {
+		return x.Grant
+	}
+	return ""
+}
+
+func (x *SignRequest) GetNonce() string {
+	if x != nil {
+		return x.Nonce
+	}
+	return ""
+}
+
+func (x *SignRequest) GetTimestamp() int64 {
+	if x != nil {
+		return x.Timestamp
+	}
+	return 0
+}
+
+func (x *SignRequest) GetQueue() bool {
+	if x != nil {
+		return x.Queue
+	}
+	return false
+}
+
+func (x *SignRequest) GetNotifyCommitment() string {
+	if x != nil {
+		return x.NotifyCommitment
+	}
+	return ""
+}
+
+func (x *SignRequest) GetCluster() string {
+	if x != nil {
+		return x.Cluster
+	}
+	return ""
+}
+
+func (x *SignRequest) GetRefreshBlockhash() bool {
+	if x != nil {
+		return x.RefreshBlockhash
+	}
+	return false
+}
+
+func (x *SignRequest) GetNonceAccount() string {
+	if x != nil {
+		return x.NonceAccount
+	}
+	return ""
+}
+
+func (x *SignRequest) GetPriorityFee() bool {
+	if x != nil {
+		return x.PriorityFee
+	}
+	return false
+}
+
+func (x *SignRequest) GetFeePayer() bool {
+	if x != nil {
+		return x.FeePayer
+	}
+	return false
+}
+
+type SignResponse struct {
+	state           protoimpl.MessageState `protogen:"open.v1"`
+	KeyId           string                 `protobuf:"bytes,1,opt,name=key_id,json=keyId,proto3" json:"key_id,omitempty"`
+	Signature       string                 `protobuf:"bytes,2,opt,name=signature,proto3" json:"signature,omitempty"`
+	SigningMode     string                 `protobuf:"bytes,3,opt,name=signing_mode,json=signingMode,proto3" json:"signing_mode,omitempty"`
+	Context         string                 `protobuf:"bytes,4,opt,name=context,proto3" json:"context,omitempty"`
+	BroadcastStatus string                 `protobuf:"bytes,5,opt,name=broadcast_status,json=broadcastStatus,proto3" json:"broadcast_status,omitempty"`
+	KeyDestroyed    bool                   `protobuf:"varint,6,opt,name=key_destroyed,json=keyDestroyed,proto3" json:"key_destroyed,omitempty"`
+	Transaction     string                 `protobuf:"bytes,7,opt,name=transaction,proto3" json:"transaction,omitempty"`
+	TxSignature     string                 `protobuf:"bytes,8,opt,name=tx_signature,json=txSignature,proto3" json:"tx_signature,omitempty"`
+	Slot            uint64                 `protobuf:"varint,9,opt,name=slot,proto3" json:"slot,omitempty"`
+	SimulationLogs  []string               `protobuf:"bytes,10,rep,name=simulation_logs,json=simulationLogs,proto3" json:"simulation_logs,omitempty"`
+	// set instead of a signature when an operator must approve first
+	ApprovalId               string `protobuf:"bytes,11,opt,name=approval_id,json=approvalId,proto3" json:"approval_id,omitempty"`
+	Cluster                  string `protobuf:"bytes,12,opt,name=cluster,proto3" json:"cluster,omitempty"`
+	Blockhash                string `protobuf:"bytes,13,opt,name=blockhash,proto3" json:"blockhash,omitempty"`
+	LastValidBlockHeight     uint64 `protobuf:"varint,14,opt,name=last_valid_block_height,json=lastValidBlockHeight,proto3" json:"last_valid_block_height,omitempty"`
+	PriorityFeeMicroLamports uint64 `protobuf:"varint,15,opt,name=priority_fee_micro_lamports,json=priorityFeeMicroLamports,proto3" json:"priority_fee_micro_lamports,omitempty"`
+	QueueId                  string `protobuf:"bytes,16,opt,name=queue_id,json=queueId,proto3" json:"queue_id,omitempty"`
+	unknownFields            protoimpl.UnknownFields
+	sizeCache                protoimpl.SizeCache
+}
+
+func (x *SignResponse) Reset() {
+	*x = SignResponse{}
+	mi := &file_signerpb_signer_proto_msgTypes[4]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *SignResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*SignResponse) ProtoMessage() {}
+
+func (x *SignResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_signerpb_signer_proto_msgTypes[4]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use SignResponse.ProtoReflect.Descriptor instead.
+func (*SignResponse) Descriptor() ([]byte, []int) {
+	return file_signerpb_signer_proto_rawDescGZIP(), []int{4}
+}
+
+func (x *SignResponse) GetKeyId() string {
+	if x != nil {
+		return x.KeyId
+	}
+	return ""
+}
+
+func (x *SignResponse) GetSignature() string {
+	if x != nil {
+		return x.Signature
+	}
+	return ""
+}
+
+func (x *SignResponse) GetSigningMode() string {
+	if x != nil {
+		return x.SigningMode
+	}
+	return ""
+}
+
+func (x *SignResponse) GetContext() string {
+	if x != nil {
+		return x.Context
+	}
+	return ""
+}
+
+func (x *SignResponse) GetBroadcastStatus() string {
+	if x != nil {
+		return x.BroadcastStatus
+	}
+	return ""
+}
+
+func (x *SignResponse) GetKeyDestroyed() bool {
+	if x != nil {
+		return x.KeyDestroyed
+	}
+	return false
+}
+
+func (x *SignResponse) GetTransaction() string {
+	if x != nil {
+		return x.Transaction
+	}
+	return ""
+}
+
+func (x *SignResponse) GetTxSignature() string {
+	if x != nil {
+		return x.TxSignature
+	}
+	return ""
+}
+
+func (x *SignResponse) GetSlot() uint64 {
+	if x != nil {
+		return x.Slot
+	}
+	return 0
+}
+
+func (x *SignResponse) GetSimulationLogs() []string {
+	if x != nil {
+		return x.SimulationLogs
+	}
+	return nil
+}
+
+func (x *SignResponse) GetApprovalId() string {
+	if x != nil {
+		return x.ApprovalId
+	}
+	return ""
+}
+
+func (x *SignResponse) GetCluster() string {
+	if x != nil {
+		return x.Cluster
+	}
+	return ""
+}
+
+func (x *SignResponse) GetBlockhash() string {
+	if x != nil {
+		return x.Blockhash
+	}
+	return ""
+}
+
+func (x *SignResponse) GetLastValidBlockHeight() uint64 {
+	if x != nil {
+		return x.LastValidBlockHeight
+	}
+	return 0
+}
+
+func (x *SignResponse) GetPriorityFeeMicroLamports() uint64 {
+	if x != nil {
+		return x.PriorityFeeMicroLamports
+	}
+	return 0
+}
+
+func (x *SignResponse) GetQueueId() string {
+	if x != nil {
+		return x.QueueId
+	}
+	return ""
+}
+
+type SignStreamRequest struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	// chosen by the client, echoed on the response
+	Ref           string       `protobuf:"bytes,1,opt,name=ref,proto3" json:"ref,omitempty"`
+	Request       *SignRequest `protobuf:"bytes,2,opt,name=request,proto3" json:"request,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *SignStreamRequest) Reset() {
+	*x = SignStreamRequest{}
+	mi := &file_signerpb_signer_proto_msgTypes[5]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *SignStreamRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*SignStreamRequest) ProtoMessage() {}
+
+func (x *SignStreamRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_signerpb_signer_proto_msgTypes[5]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use SignStreamRequest.ProtoReflect.Descriptor instead.
+func (*SignStreamRequest) Descriptor() ([]byte, []int) {
+	return file_signerpb_signer_proto_rawDescGZIP(), []int{5}
+}
+
+func (x *SignStreamRequest) GetRef() string {
+	if x != nil {
+		return x.Ref
+	}
+	return ""
+}
+
+func (x *SignStreamRequest) GetRequest() *SignRequest {
+	if x != nil {
+		return x.Request
+	}
+	return nil
+}
+
+type SignStreamResponse struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	Ref   string                 `protobuf:"bytes,1,opt,name=ref,proto3" json:"ref,omitempty"`
+	// Types that are valid to be assigned to Result:
+	//
+	//	*SignStreamResponse_Signed
+	//	*SignStreamResponse_Error
+	//	*SignStreamResponse_GoAway
+	Result        isSignStreamResponse_Result `protobuf_oneof:"result"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *SignStreamResponse) Reset() {
+	*x = SignStreamResponse{}
+	mi := &file_signerpb_signer_proto_msgTypes[6]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *SignStreamResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*SignStreamResponse) ProtoMessage() {}
+
+func (x *SignStreamResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_signerpb_signer_proto_msgTypes[6]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use SignStreamResponse.ProtoReflect.Descriptor instead.
+func (*SignStreamResponse) Descriptor() ([]byte, []int) {
+	return file_signerpb_signer_proto_rawDescGZIP(), []int{6}
+}
+
+func (x *SignStreamResponse) GetRef() string {
+	if x != nil {
+		return x.Ref
+	}
+	return ""
+}
+
+func (x *SignStreamResponse) GetResult() isSignStreamResponse_Result {
+	if x != nil {
+		return x.Result
+	}
+	return nil
+}
+
+func (x *SignStreamResponse) GetSigned() *SignResponse {
+	if x != nil {
+		if x, ok := x.Result.(*SignStreamResponse_Signed); ok {
+			return x.Signed
+		}
+	}
+	return nil
+}
+
+func (x *SignStreamResponse) GetError() *StreamError {
+	if x != nil {
+		if x, ok := x.Result.(*SignStreamResponse_Error); ok {
+			return x.Error
+		}
+	}
+	return nil
+}
+
+func (x *SignStreamResponse) GetGoAway() *GoAway {
+	if x != nil {
+		if x, ok := x.Result.(*SignStreamResponse_GoAway); ok {
+			return x.GoAway
+		}
+	}
+	return nil
+}
+
+type isSignStreamResponse_Result interface {
+	isSignStreamResponse_Result()
+}
+
+type SignStreamResponse_Signed struct {
+	Signed *SignResponse `protobuf:"bytes,2,opt,name=signed,proto3,oneof"`
+}
+
+type SignStreamResponse_Error struct {
+	Error *StreamError `protobuf:"bytes,3,opt,name=error,proto3,oneof"`
+}
+
+type SignStreamResponse_GoAway struct {
+	// the server is draining, reconnect elsewhere. Requests already in
+	// flight are still answered, later ones are not read.
+	GoAway *GoAway `protobuf:"bytes,4,opt,name=go_away,json=goAway,proto3,oneof"`
+}
+
+func (*SignStreamResponse_Signed) isSignStreamResponse_Result() {}
+
+func (*SignStreamResponse_Error) isSignStreamResponse_Result() {}
+
+func (*SignStreamResponse_GoAway) isSignStreamResponse_Result() {}
+
+type StreamError struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	// gRPC status code, the stable error code and a human readable message
+	Status        int32  `protobuf:"varint,1,opt,name=status,proto3" json:"status,omitempty"`
+	Code          string `protobuf:"bytes,2,opt,name=code,proto3" json:"code,omitempty"`
+	Message       string `protobuf:"bytes,3,opt,name=message,proto3" json:"message,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *StreamError) Reset() {
+	*x = StreamError{}
+	mi := &file_signerpb_signer_proto_msgTypes[7]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *StreamError) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*StreamError) ProtoMessage() {}
+
+func (x *StreamError) ProtoReflect() protoreflect.Message {
+	mi := &file_signerpb_signer_proto_msgTypes[7]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use StreamError.ProtoReflect.Descriptor instead.
+func (*StreamError) Descriptor() ([]byte, []int) {
+	return file_signerpb_signer_proto_rawDescGZIP(), []int{7}
+}
+
+func (x *StreamError) GetStatus() int32 {
+	if x != nil {
+		return x.Status
+	}
+	return 0
+}
+
+func (x *StreamError) GetCode() string {
+	if x != nil {
+		return x.Code
+	}
+	return ""
+}
+
+func (x *StreamError) GetMessage() string {
+	if x != nil {
+		return x.Message
+	}
+	return ""
+}
+
+type GoAway struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Reason        string                 `protobuf:"bytes,1,opt,name=reason,proto3" json:"reason,omitempty"`
+	ReconnectTo   string                 `protobuf:"bytes,2,opt,name=reconnect_to,json=reconnectTo,proto3" json:"reconnect_to,omitempty"`
+	RetryAfterMs  int64                  `protobuf:"varint,3,opt,name=retry_after_ms,json=retryAfterMs,proto3" json:"retry_after_ms,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *GoAway) Reset() {
+	*x = GoAway{}
+	mi := &file_signerpb_signer_proto_msgTypes[8]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *GoAway) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*GoAway) ProtoMessage() {}
+
+func (x *GoAway) ProtoReflect() protoreflect.Message {
+	mi := &file_signerpb_signer_proto_msgTypes[8]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use GoAway.ProtoReflect.Descriptor instead.
+func (*GoAway) Descriptor() ([]byte, []int) {
+	return file_signerpb_signer_proto_rawDescGZIP(), []int{8}
+}
+
+func (x *GoAway) GetReason() string {
+	if x != nil {
+		return x.Reason
+	}
+	return ""
+}
+
+func (x *GoAway) GetReconnectTo() string {
+	if x != nil {
+		return x.ReconnectTo
+	}
+	return ""
+}
+
+func (x *GoAway) GetRetryAfterMs() int64 {
+	if x != nil {
+		return x.RetryAfterMs
+	}
+	return 0
+}
+
+type VerifyRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	KeyType       string                 `protobuf:"bytes,1,opt,name=key_type,json=keyType,proto3" json:"key_type,omitempty"`
+	PublicKey     string                 `protobuf:"bytes,2,opt,name=public_key,json=publicKey,proto3" json:"public_key,omitempty"`
+	Message       string                 `protobuf:"bytes,3,opt,name=message,proto3" json:"message,omitempty"`
+	Signature     string                 `protobuf:"bytes,4,opt,name=signature,proto3" json:"signature,omitempty"`
+	SigningMode   string                 `protobuf:"bytes,5,opt,name=signing_mode,json=signingMode,proto3" json:"signing_mode,omitempty"`
+	Context       string                 `protobuf:"bytes,6,opt,name=context,proto3" json:"context,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *VerifyRequest) Reset() {
+	*x = VerifyRequest{}
+	mi := &file_signerpb_signer_proto_msgTypes[9]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *VerifyRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*VerifyRequest) ProtoMessage() {}
+
+func (x *VerifyRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_signerpb_signer_proto_msgTypes[9]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use VerifyRequest.ProtoReflect.Descriptor instead.
+func (*VerifyRequest) Descriptor() ([]byte, []int) {
+	return file_signerpb_signer_proto_rawDescGZIP(), []int{9}
+}
+
+func (x *VerifyRequest) GetKeyType() string {
+	if x != nil {
+		return x.KeyType
+	}
+	return ""
+}
+
+func (x *VerifyRequest) GetPublicKey() string {
+	if x != nil {
+		return x.PublicKey
+	}
+	return ""
+}
+
+func (x *VerifyRequest) GetMessage() string {
+	if x != nil {
+		return x.Message
+	}
+	return ""
+}
+
+func (x *VerifyRequest) GetSignature() string {
+	if x != nil {
+		return x.Signature
+	}
+	return ""
+}
+
+func (x *VerifyRequest) GetSigningMode() string {
+	if x != nil {
+		return x.SigningMode
+	}
+	return ""
+}
+
+func (x *VerifyRequest) GetContext() string {
+	if x != nil {
+		return x.Context
+	}
+	return ""
+}
+
+type VerifyResponse struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	KeyType       string                 `protobuf:"bytes,1,opt,name=key_type,json=keyType,proto3" json:"key_type,omitempty"`
+	Valid         bool                   `protobuf:"varint,2,opt,name=valid,proto3" json:"valid,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *VerifyResponse) Reset() {
+	*x = VerifyResponse{}
+	mi := &file_signerpb_signer_proto_msgTypes[10]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *VerifyResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*VerifyResponse) ProtoMessage() {}
+
+func (x *VerifyResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_signerpb_signer_proto_msgTypes[10]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use VerifyResponse.ProtoReflect.Descriptor instead.
+func (*VerifyResponse) Descriptor() ([]byte, []int) {
+	return file_signerpb_signer_proto_rawDescGZIP(), []int{10}
+}
+
+func (x *VerifyResponse) GetKeyType() string {
+	if x != nil {
+		return x.KeyType
+	}
+	return ""
+}
+
+func (x *VerifyResponse) GetValid() bool {
+	if x != nil {
+		return x.Valid
+	}
+	return false
+}
+
+type FreezeKeyRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	KeyId         string                 `protobuf:"bytes,1,opt,name=key_id,json=keyId,proto3" json:"key_id,omitempty"`
+	Reason        string                 `protobuf:"bytes,2,opt,name=reason,proto3" json:"reason,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *FreezeKeyRequest) Reset() {
+	*x = FreezeKeyRequest{}
+	mi := &file_signerpb_signer_proto_msgTypes[11]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *FreezeKeyRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*FreezeKeyRequest) ProtoMessage() {}
+
+func (x *FreezeKeyRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_signerpb_signer_proto_msgTypes[11]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use FreezeKeyRequest.ProtoReflect.Descriptor instead.
+func (*FreezeKeyRequest) Descriptor() ([]byte, []int) {
+	return file_signerpb_signer_proto_rawDescGZIP(), []int{11}
+}
+
+func (x *FreezeKeyRequest) GetKeyId() string {
+	if x != nil {
+		return x.KeyId
+	}
+	return ""
+}
+
+func (x *FreezeKeyRequest) GetReason() string {
+	if x != nil {
+		return x.Reason
+	}
+	return ""
+}
+
+type UnfreezeKeyRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	KeyId         string                 `protobuf:"bytes,1,opt,name=key_id,json=keyId,proto3" json:"key_id,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *UnfreezeKeyRequest) Reset() {
+	*x = UnfreezeKeyRequest{}
+	mi := &file_signerpb_signer_proto_msgTypes[12]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *UnfreezeKeyRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*UnfreezeKeyRequest) ProtoMessage() {}
+
+func (x *UnfreezeKeyRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_signerpb_signer_proto_msgTypes[12]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use UnfreezeKeyRequest.ProtoReflect.Descriptor instead.
+func (*UnfreezeKeyRequest) Descriptor() ([]byte, []int) {
+	return file_signerpb_signer_proto_rawDescGZIP(), []int{12}
+}
+
+func (x *UnfreezeKeyRequest) GetKeyId() string {
+	if x != nil {
+		return x.KeyId
+	}
+	return ""
+}
+
+type KeyStatus struct {
+	state          protoimpl.MessageState `protogen:"open.v1"`
+	KeyId          string                 `protobuf:"bytes,1,opt,name=key_id,json=keyId,proto3" json:"key_id,omitempty"`
+	KeyType        string                 `protobuf:"bytes,2,opt,name=key_type,json=keyType,proto3" json:"key_type,omitempty"`
+	Namespace      string                 `protobuf:"bytes,3,opt,name=namespace,proto3" json:"namespace,omitempty"`
+	Uses           int64                  `protobuf:"varint,4,opt,name=uses,proto3" json:"uses,omitempty"`
+	CreatedAtUnix  int64                  `protobuf:"varint,5,opt,name=created_at_unix,json=createdAtUnix,proto3" json:"created_at_unix,omitempty"`
+	LastUsedAtUnix int64                  `protobuf:"varint,6,opt,name=last_used_at_unix,json=lastUsedAtUnix,proto3" json:"last_used_at_unix,omitempty"`
+	Frozen         bool                   `protobuf:"varint,7,opt,name=frozen,proto3" json:"frozen,omitempty"`
+	FrozenReason   string                 `protobuf:"bytes,8,opt,name=frozen_reason,json=frozenReason,proto3" json:"frozen_reason,omitempty"`
+	FrozenBy       string                 `protobuf:"bytes,9,opt,name=frozen_by,json=frozenBy,proto3" json:"frozen_by,omitempty"`
+	unknownFields  protoimpl.UnknownFields
+	sizeCache      protoimpl.SizeCache
+}
+
+func (x *KeyStatus) Reset() {
+	*x = KeyStatus{}
+	mi := &file_signerpb_signer_proto_msgTypes[13]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *KeyStatus) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*KeyStatus) ProtoMessage() {}
+
+func (x *KeyStatus) ProtoReflect() protoreflect.Message {
+	mi := &file_signerpb_signer_proto_msgTypes[13]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use KeyStatus.ProtoReflect.Descriptor instead.
+func (*KeyStatus) Descriptor() ([]byte, []int) {
+	return file_signerpb_signer_proto_rawDescGZIP(), []int{13}
+}
+
+func (x *KeyStatus) GetKeyId() string {
+	if x != nil {
+		return x.KeyId
+	}
+	return ""
+}
+
+func (x *KeyStatus) GetKeyType() string {
+	if x != nil {
+		return x.KeyType
+	}
+	return ""
+}
+
+func (x *KeyStatus) GetNamespace() string {
+	if x != nil {
+		return x.Namespace
+	}
+	return ""
+}
+
+func (x *KeyStatus) GetUses() int64 {
+	if x != nil {
+		return x.Uses
+	}
+	return 0
+}
+
+func (x *KeyStatus) GetCreatedAtUnix() int64 {
+	if x != nil {
+		return x.CreatedAtUnix
+	}
+	return 0
+}
+
+func (x *KeyStatus) GetLastUsedAtUnix() int64 {
+	if x != nil {
+		return x.LastUsedAtUnix
+	}
+	return 0
+}
+
+func (x *KeyStatus) GetFrozen() bool {
+	if x != nil {
+		return x.Frozen
+	}
+	return false
+}
+
+func (x *KeyStatus) GetFrozenReason() string {
+	if x != nil {
+		return x.FrozenReason
+	}
+	return ""
+}
+
+func (x *KeyStatus) GetFrozenBy() string {
+	if x != nil {
+		return x.FrozenBy
+	}
+	return ""
+}
+
+var File_signerpb_signer_proto protoreflect.FileDescriptor
+
+const file_signerpb_signer_proto_rawDesc = "" +
+	"\n" +
+	"\x15signerpb/signer.proto\x12\rsts.signer.v1\"n\n" +
+	"\x12GenerateKeyRequest\x12\x19\n" +
+	"\bkey_type\x18\x01 \x01(\tR\akeyType\x12\x1f\n" +
+	"\vpolicy_json\x18\x02 \x01(\fR\n" +
+	"policyJson\x12\x1c\n" +
+	"\tnamespace\x18\x03 \x01(\tR\tnamespace\"\xc0\x01\n" +
+	"\aAccount\x12\x1d\n" +
+	"\n" +
+	"public_key\x18\x01 \x01(\tR\tpublicKey\x12\x19\n" +
+	"\bkey_type\x18\x02 \x01(\tR\akeyType\x12\x1c\n" +
+	"\tnamespace\x18\x03 \x01(\tR\tnamespace\x12\x1f\n" +
+	"\vpolicy_json\x18\x04 \x01(\fR\n" +
+	"policyJson\x12<\n" +
+	"\vattestation\x18\x05 \x01(\v2\x1a.sts.signer.v1.AttestationR\vattestation\"q\n" +
+	"\vAttestation\x12%\n" +
+	"\x0estatement_json\x18\x01 \x01(\fR\rstatementJson\x12\x1c\n" +
+	"\tsignature\x18\x02 \x01(\tR\tsignature\x12\x1d\n" +
+	"\n" +
+	"signer_key\x18\x03 \x01(\tR\tsignerKey\"\xa7\x04\n" +
+	"\vSignRequest\x12\x15\n" +
+	"\x06key_id\x18\x01 \x01(\tR\x05keyId\x12(\n" +
+	"\x10unsigned_tx_data\x18\x02 \x01(\tR\x0eunsignedTxData\x12!\n" +
+	"\fsigning_mode\x18\x03 \x01(\tR\vsigningMode\x12\x18\n" +
+	"\acontext\x18\x04 \x01(\tR\acontext\x12'\n" +
+	"\x0fidempotency_key\x18\x05 \x01(\tR\x0eidempotencyKey\x12\x1c\n" +
+	"\tbroadcast\x18\x06 \x01(\bR\tbroadcast\x12\x1a\n" +
+	"\bsimulate\x18\a \x01(\bR\bsimulate\x12\x14\n" +
+	"\x05grant\x18\b \x01(\tR\x05grant\x12\x14\n" +
+	"\x05nonce\x18\t \x01(\tR\x05nonce\x12\x1c\n" +
+	"\ttimestamp\x18\n" +
+	" \x01(\x03R\ttimestamp\x12\x14\n" +
+	"\x05queue\x18\v \x01(\bR\x05queue\x12+\n" +
+	"\x11notify_commitment\x18\f \x01(\tR\x10notifyCommitment\x12\x18\n" +
+	"\acluster\x18\r \x01(\tR\acluster\x12+\n" +
+	"\x11refresh_blockhash\x18\x0e \x01(\bR\x10refreshBlockhash\x12#\n" +
+	"\rnonce_account\x18\x0f \x01(\tR\fnonceAccount\x12!\n" +
+	"\fpriority_fee\x18\x10 \x01(\bR\vpriorityFee\x12\x1b\n" +
+	"\tfee_payer\x18\x11 \x01(\bR\bfeePayer\"\xbc\x04\n" +
+	"\fSignResponse\x12\x15\n" +
+	"\x06key_id\x18\x01 \x01(\tR\x05keyId\x12\x1c\n" +
+	"\tsignature\x18\x02 \x01(\tR\tsignature\x12!\n" +
+	"\fsigning_mode\x18\x03 \x01(\tR\vsigningMode\x12\x18\n" +
+	"\acontext\x18\x04 \x01(\tR\acontext\x12)\n" +
+	"\x10broadcast_status\x18\x05 \x01(\tR\x0fbroadcastStatus\x12#\n" +
+	"\rkey_destroyed\x18\x06 \x01(\bR\fkeyDestroyed\x12 \n" +
+	"\vtransaction\x18\a \x01(\tR\vtransaction\x12!\n" +
+	"\ftx_signature\x18\b \x01(\tR\vtxSignature\x12\x12\n" +
+	"\x04slot\x18\t \x01(\x04R\x04slot\x12'\n" +
+	"\x0fsimulation_logs\x18\n" +
+	" \x03(\tR\x0esimulationLogs\x12\x1f\n" +
+	"\vapproval_id\x18\v \x01(\tR\n" +
+	"approvalId\x12\x18\n" +
+	"\acluster\x18\f \x01(\tR\acluster\x12\x1c\n" +
+	"\tblockhash\x18\r \x01(\tR\tblockhash\x125\n" +
+	"\x17last_valid_block_height\x18\x0e \x01(\x04R\x14lastValidBlockHeight\x12=\n" +
+	"\x1bpriority_fee_micro_lamports\x18\x0f \x01(\x04R\x18priorityFeeMicroLamports\x12\x19\n" +
+	"\bqueue_id\x18\x10 \x01(\tR\aqueueId\"[\n" +
+	"\x11SignStreamRequest\x12\x10\n" +
+	"\x03ref\x18\x01 \x01(\tR\x03ref\x124\n" +
+	"\arequest\x18\x02 \x01(\v2\x1a.sts.signer.v1.SignRequestR\arequest\"\xcd\x01\n" +
+	"\x12SignStreamResponse\x12\x10\n" +
+	"\x03ref\x18\x01 \x01(\tR\x03ref\x125\n" +
+	"\x06signed\x18\x02 \x01(\v2\x1b.sts.signer.v1.SignResponseH\x00R\x06signed\x122\n" +
+	"\x05error\x18\x03 \x01(\v2\x1a.sts.signer.v1.StreamErrorH\x00R\x05error\x120\n" +
+	"\ago_away\x18\x04 \x01(\v2\x15.sts.signer.v1.GoAwayH\x00R\x06goAwayB\b\n" +
+	"\x06result\"S\n" +
+	"\vStreamError\x12\x16\n" +
+	"\x06status\x18\x01 \x01(\x05R\x06status\x12\x12\n" +
+	"\x04code\x18\x02 \x01(\tR\x04code\x12\x18\n" +
+	"\amessage\x18\x03 \x01(\tR\amessage\"i\n" +
+	"\x06GoAway\x12\x16\n" +
+	"\x06reason\x18\x01 \x01(\tR\x06reason\x12!\n" +
+	"\freconnect_to\x18\x02 \x01(\tR\vreconnectTo\x12$\n" +
+	"\x0eretry_after_ms\x18\x03 \x01(\x03R\fretryAfterMs\"\xbe\x01\n" +
+	"\rVerifyRequest\x12\x19\n" +
+	"\bkey_type\x18\x01 \x01(\tR\akeyType\x12\x1d\n" +
+	"\n" +
+	"public_key\x18\x02 \x01(\tR\tpublicKey\x12\x18\n" +
+	"\amessage\x18\x03 \x01(\tR\amessage\x12\x1c\n" +
+	"\tsignature\x18\x04 \x01(\tR\tsignature\x12!\n" +
+	"\fsigning_mode\x18\x05 \x01(\tR\vsigningMode\x12\x18\n" +
+	"\acontext\x18\x06 \x01(\tR\acontext\"A\n" +
+	"\x0eVerifyResponse\x12\x19\n" +
+	"\bkey_type\x18\x01 \x01(\tR\akeyType\x12\x14\n" +
+	"\x05valid\x18\x02 \x01(\bR\x05valid\"A\n" +
+	"\x10FreezeKeyRequest\x12\x15\n" +
+	"\x06key_id\x18\x01 \x01(\tR\x05keyId\x12\x16\n" +
+	"\x06reason\x18\x02 \x01(\tR\x06reason\"+\n" +
+	"\x12UnfreezeKeyRequest\x12\x15\n" +
+	"\x06key_id\x18\x01 \x01(\tR\x05keyId\"\x9c\x02\n" +
+	"\tKeyStatus\x12\x15\n" +
+	"\x06key_id\x18\x01 \x01(\tR\x05keyId\x12\x19\n" +
+	"\bkey_type\x18\x02 \x01(\tR\akeyType\x12\x1c\n" +
+	"\tnamespace\x18\x03 \x01(\tR\tnamespace\x12\x12\n" +
+	"\x04uses\x18\x04 \x01(\x03R\x04uses\x12&\n" +
+	"\x0fcreated_at_unix\x18\x05 \x01(\x03R\rcreatedAtUnix\x12)\n" +
+	"\x11last_used_at_unix\x18\x06 \x01(\x03R\x0elastUsedAtUnix\x12\x16\n" +
+	"\x06frozen\x18\a \x01(\bR\x06frozen\x12#\n" +
+	"\rfrozen_reason\x18\b \x01(\tR\ffrozenReason\x12\x1b\n" +
+	"\tfrozen_by\x18\t \x01(\tR\bfrozenBy2\xd9\x03\n" +
+	"\x06Signer\x12H\n" +
+	"\vGenerateKey\x12!.sts.signer.v1.GenerateKeyRequest\x1a\x16.sts.signer.v1.Account\x12J\n" +
+	"\x0fSignTransaction\x12\x1a.sts.signer.v1.SignRequest\x1a\x1b.sts.signer.v1.SignResponse\x12N\n" +
+	"\x0fVerifySignature\x12\x1c.sts.signer.v1.VerifyRequest\x1a\x1d.sts.signer.v1.VerifyResponse\x12U\n" +
+	"\n" +
+	"SignStream\x12 .sts.signer.v1.SignStreamRequest\x1a!.sts.signer.v1.SignStreamResponse(\x010\x01\x12F\n" +
+	"\tFreezeKey\x12\x1f.sts.signer.v1.FreezeKeyRequest\x1a\x18.sts.signer.v1.KeyStatus\x12J\n" +
+	"\vUnfreezeKey\x12!.sts.signer.v1.UnfreezeKeyRequest\x1a\x18.sts.signer.v1.KeyStatusB*Z(github.com/yourusername/sts-svc/signerpbb\x06proto3"
+
+var (
+	file_signerpb_signer_proto_rawDescOnce sync.Once
+	file_signerpb_signer_proto_rawDescData []byte
+)
+
+func file_signerpb_signer_proto_rawDescGZIP() []byte {
+	file_signerpb_signer_proto_rawDescOnce.Do(func() {
+		file_signerpb_signer_proto_rawDescData = protoimpl.X.CompressGZIP(unsafe.Slice(unsafe.StringData(file_signerpb_signer_proto_rawDesc), len(file_signerpb_signer_proto_rawDesc)))
+	})
+	return file_signerpb_signer_proto_rawDescData
+}
+
+var file_signerpb_signer_proto_msgTypes = make([]protoimpl.MessageInfo, 14)
+var file_signerpb_signer_proto_goTypes = []any{
+	(*GenerateKeyRequest)(nil), // 0: sts.signer.v1.GenerateKeyRequest
+	(*Account)(nil),            // 1: sts.signer.v1.Account
+	(*Attestation)(nil),        // 2: sts.signer.v1.Attestation
+	(*SignRequest)(nil),        // 3: sts.signer.v1.SignRequest
+	(*SignResponse)(nil),       // 4: sts.signer.v1.SignResponse
+	(*SignStreamRequest)(nil),  // 5: sts.signer.v1.SignStreamRequest
+	(*SignStreamResponse)(nil), // 6: sts.signer.v1.SignStreamResponse
+	(*StreamError)(nil),        // 7: sts.signer.v1.StreamError
+	(*GoAway)(nil),             // 8: sts.signer.v1.GoAway
+	(*VerifyRequest)(nil),      // 9: sts.signer.v1.VerifyRequest
+	(*VerifyResponse)(nil),     // 10: sts.signer.v1.VerifyResponse
+	(*FreezeKeyRequest)(nil),   // 11: sts.signer.v1.FreezeKeyRequest
+	(*UnfreezeKeyRequest)(nil), // 12: sts.signer.v1.UnfreezeKeyRequest
+	(*KeyStatus)(nil),          // 13: sts.signer.v1.KeyStatus
+}
+var file_signerpb_signer_proto_depIdxs = []int32{
+	2,  // 0: sts.signer.v1.Account.attestation:type_name -> sts.signer.v1.Attestation
+	3,  // 1: sts.signer.v1.SignStreamRequest.request:type_name -> sts.signer.v1.SignRequest
+	4,  // 2: sts.signer.v1.SignStreamResponse.signed:type_name -> sts.signer.v1.SignResponse
+	7,  // 3: sts.signer.v1.SignStreamResponse.error:type_name -> sts.signer.v1.StreamError
+	8,  // 4: sts.signer.v1.SignStreamResponse.go_away:type_name -> sts.signer.v1.GoAway
+	0,  // 5: sts.signer.v1.Signer.GenerateKey:input_type -> sts.signer.v1.GenerateKeyRequest
+	3,  // 6: sts.signer.v1.Signer.SignTransaction:input_type -> sts.signer.v1.SignRequest
+	9,  // 7: sts.signer.v1.Signer.VerifySignature:input_type -> sts.signer.v1.VerifyRequest
+	5,  // 8: sts.signer.v1.Signer.SignStream:input_type -> sts.signer.v1.SignStreamRequest
+	11, // 9: sts.signer.v1.Signer.FreezeKey:input_type -> sts.signer.v1.FreezeKeyRequest
+	12, // 10: sts.signer.v1.Signer.UnfreezeKey:input_type -> sts.signer.v1.UnfreezeKeyRequest
+	1,  // 11: sts.signer.v1.Signer.GenerateKey:output_type -> sts.signer.v1.Account
+	4,  // 12: sts.signer.v1.Signer.SignTransaction:output_type -> sts.signer.v1.SignResponse
+	10, // 13: sts.signer.v1.Signer.VerifySignature:output_type -> sts.signer.v1.VerifyResponse
+	6,  // 14: sts.signer.v1.Signer.SignStream:output_type -> sts.signer.v1.SignStreamResponse
+	13, // 15: sts.signer.v1.Signer.FreezeKey:output_type -> sts.signer.v1.KeyStatus
+	13, // 16: sts.signer.v1.Signer.UnfreezeKey:output_type -> sts.signer.v1.KeyStatus
+	11, // [11:17] is the sub-list for method output_type
+	5,  // [5:11] is the sub-list for method input_type
+	5,  // [5:5] is the sub-list for extension type_name
+	5,  // [5:5] is the sub-list for extension extendee
+	0,  // [0:5] is the sub-list for field type_name
+}
+
+func init() { file_signerpb_signer_proto_init() }
+func file_signerpb_signer_proto_init() {
+	if File_signerpb_signer_proto != nil {
+		return
+	}
+	file_signerpb_signer_proto_msgTypes[6].OneofWrappers = []any{
+		(*SignStreamResponse_Signed)(nil),
+		(*SignStreamResponse_Error)(nil),
+		(*SignStreamResponse_GoAway)(nil),
+	}
+	type x struct{}
+	out := protoimpl.TypeBuilder{
+		File: protoimpl.DescBuilder{
+			GoPackagePath: reflect.TypeOf(x{}).PkgPath(),
+			RawDescriptor: unsafe.Slice(unsafe.StringData(file_signerpb_signer_proto_rawDesc), len(file_signerpb_signer_proto_rawDesc)),
+			NumEnums:      0,
+			NumMessages:   14,
+			NumExtensions: 0,
+			NumServices:   1,
+		},
+		GoTypes:           file_signerpb_signer_proto_goTypes,
+		DependencyIndexes: file_signerpb_signer_proto_depIdxs,
+		MessageInfos:      file_signerpb_signer_proto_msgTypes,
+	}.Build()
+	File_signerpb_signer_proto = out.File
+	file_signerpb_signer_proto_goTypes = nil
+	file_signerpb_signer_proto_depIdxs = nil
+}
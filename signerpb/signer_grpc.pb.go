@@ -0,0 +1,320 @@
+// Code generated by protoc-gen-go-grpc. DO NOT EDIT.
+// versions:
+// - protoc-gen-go-grpc v1.6.2
+// - protoc             (unknown)
+// source: signerpb/signer.proto
+
+package signerpb
+
+import (
+	context "context"
+	grpc "google.golang.org/grpc"
+	codes "google.golang.org/grpc/codes"
+	status "google.golang.org/grpc/status"
+)
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the grpc package it is being compiled against.
+// Requires gRPC-Go v1.64.0 or later.
+const _ = grpc.SupportPackageIsVersion9
+
+const (
+	Signer_GenerateKey_FullMethodName     = "/sts.signer.v1.Signer/GenerateKey"
+	Signer_SignTransaction_FullMethodName = "/sts.signer.v1.Signer/SignTransaction"
+	Signer_VerifySignature_FullMethodName = "/sts.signer.v1.Signer/VerifySignature"
+	Signer_SignStream_FullMethodName      = "/sts.signer.v1.Signer/SignStream"
+	Signer_FreezeKey_FullMethodName       = "/sts.signer.v1.Signer/FreezeKey"
+	Signer_UnfreezeKey_FullMethodName     = "/sts.signer.v1.Signer/UnfreezeKey"
+)
+
+// SignerClient is the client API for Signer service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+//
+// Signer is the gRPC face of the signer service. Credentials travel as
+// metadata under the HTTP header names: authorization (operator bearer
+// token), x-api-key, x-tenant-id and x-request-id.
+type SignerClient interface {
+	GenerateKey(ctx context.Context, in *GenerateKeyRequest, opts ...grpc.CallOption) (*Account, error)
+	SignTransaction(ctx context.Context, in *SignRequest, opts ...grpc.CallOption) (*SignResponse, error)
+	VerifySignature(ctx context.Context, in *VerifyRequest, opts ...grpc.CallOption) (*VerifyResponse, error)
+	// bulk signing, requests are signed concurrently and answered as they
+	// complete, matched up by ref. A failed request doesn't end the stream.
+	SignStream(ctx context.Context, opts ...grpc.CallOption) (grpc.BidiStreamingClient[SignStreamRequest, SignStreamResponse], error)
+	// key management, operators only
+	FreezeKey(ctx context.Context, in *FreezeKeyRequest, opts ...grpc.CallOption) (*KeyStatus, error)
+	UnfreezeKey(ctx context.Context, in *UnfreezeKeyRequest, opts ...grpc.CallOption) (*KeyStatus, error)
+}
+
+type signerClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewSignerClient(cc grpc.ClientConnInterface) SignerClient {
+	return &signerClient{cc}
+}
+
+func (c *signerClient) GenerateKey(ctx context.Context, in *GenerateKeyRequest, opts ...grpc.CallOption) (*Account, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(Account)
+	err := c.cc.Invoke(ctx, Signer_GenerateKey_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *signerClient) SignTransaction(ctx context.Context, in *SignRequest, opts ...grpc.CallOption) (*SignResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(SignResponse)
+	err := c.cc.Invoke(ctx, Signer_SignTransaction_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *signerClient) VerifySignature(ctx context.Context, in *VerifyRequest, opts ...grpc.CallOption) (*VerifyResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(VerifyResponse)
+	err := c.cc.Invoke(ctx, Signer_VerifySignature_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *signerClient) SignStream(ctx context.Context, opts ...grpc.CallOption) (grpc.BidiStreamingClient[SignStreamRequest, SignStreamResponse], error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	stream, err := c.cc.NewStream(ctx, &Signer_ServiceDesc.Streams[0], Signer_SignStream_FullMethodName, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &grpc.GenericClientStream[SignStreamRequest, SignStreamResponse]{ClientStream: stream}
+	return x, nil
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type Signer_SignStreamClient = grpc.BidiStreamingClient[SignStreamRequest, SignStreamResponse]
+
+func (c *signerClient) FreezeKey(ctx context.Context, in *FreezeKeyRequest, opts ...grpc.CallOption) (*KeyStatus, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(KeyStatus)
+	err := c.cc.Invoke(ctx, Signer_FreezeKey_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *signerClient) UnfreezeKey(ctx context.Context, in *UnfreezeKeyRequest, opts ...grpc.CallOption) (*KeyStatus, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(KeyStatus)
+	err := c.cc.Invoke(ctx, Signer_UnfreezeKey_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// SignerServer is the server API for Signer service.
+// All implementations must embed UnimplementedSignerServer
+// for forward compatibility.
+//
+// Signer is the gRPC face of the signer service. Credentials travel as
+// metadata under the HTTP header names: authorization (operator bearer
+// token), x-api-key, x-tenant-id and x-request-id.
+type SignerServer interface {
+	GenerateKey(context.Context, *GenerateKeyRequest) (*Account, error)
+	SignTransaction(context.Context, *SignRequest) (*SignResponse, error)
+	VerifySignature(context.Context, *VerifyRequest) (*VerifyResponse, error)
+	// bulk signing, requests are signed concurrently and answered as they
+	// complete, matched up by ref. A failed request doesn't end the stream.
+	SignStream(grpc.BidiStreamingServer[SignStreamRequest, SignStreamResponse]) error
+	// key management, operators only
+	FreezeKey(context.Context, *FreezeKeyRequest) (*KeyStatus, error)
+	UnfreezeKey(context.Context, *UnfreezeKeyRequest) (*KeyStatus, error)
+	mustEmbedUnimplementedSignerServer()
+}
+
+// UnimplementedSignerServer must be embedded to have
+// forward compatible implementations.
+//
+// NOTE: this should be embedded by value instead of pointer to avoid a nil
+// pointer dereference when methods are called.
+type UnimplementedSignerServer struct{}
+
+func (UnimplementedSignerServer) GenerateKey(context.Context, *GenerateKeyRequest) (*Account, error) {
+	return nil, status.Error(codes.Unimplemented, "method GenerateKey not implemented")
+}
+func (UnimplementedSignerServer) SignTransaction(context.Context, *SignRequest) (*SignResponse, error) {
+	return nil, status.Error(codes.Unimplemented, "method SignTransaction not implemented")
+}
+func (UnimplementedSignerServer) VerifySignature(context.Context, *VerifyRequest) (*VerifyResponse, error) {
+	return nil, status.Error(codes.Unimplemented, "method VerifySignature not implemented")
+}
+func (UnimplementedSignerServer) SignStream(grpc.BidiStreamingServer[SignStreamRequest, SignStreamResponse]) error {
+	return status.Error(codes.Unimplemented, "method SignStream not implemented")
+}
+func (UnimplementedSignerServer) FreezeKey(context.Context, *FreezeKeyRequest) (*KeyStatus, error) {
+	return nil, status.Error(codes.Unimplemented, "method FreezeKey not implemented")
+}
+func (UnimplementedSignerServer) UnfreezeKey(context.Context, *UnfreezeKeyRequest) (*KeyStatus, error) {
+	return nil, status.Error(codes.Unimplemented, "method UnfreezeKey not implemented")
+}
+func (UnimplementedSignerServer) mustEmbedUnimplementedSignerServer() {}
+func (UnimplementedSignerServer) testEmbeddedByValue()                {}
+
+// UnsafeSignerServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to SignerServer will
+// result in compilation errors.
+type UnsafeSignerServer interface {
+	mustEmbedUnimplementedSignerServer()
+}
+
+func RegisterSignerServer(s grpc.ServiceRegistrar, srv SignerServer) {
+	// If the following call panics, it indicates UnimplementedSignerServer was
+	// embedded by pointer and is nil.  This will cause panics if an
+	// unimplemented method is ever invoked, so we test this at initialization
+	// time to prevent it from happening at runtime later due to I/O.
+	if t, ok := srv.(interface{ testEmbeddedByValue() }); ok {
+		t.testEmbeddedByValue()
+	}
+	s.RegisterService(&Signer_ServiceDesc, srv)
+}
+
+func _Signer_GenerateKey_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(GenerateKeyRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(SignerServer).GenerateKey(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Signer_GenerateKey_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(SignerServer).GenerateKey(ctx, req.(*GenerateKeyRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Signer_SignTransaction_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(SignRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(SignerServer).SignTransaction(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Signer_SignTransaction_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(SignerServer).SignTransaction(ctx, req.(*SignRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Signer_VerifySignature_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(VerifyRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(SignerServer).VerifySignature(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Signer_VerifySignature_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(SignerServer).VerifySignature(ctx, req.(*VerifyRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Signer_SignStream_Handler(srv interface{}, stream grpc.ServerStream) error {
+	return srv.(SignerServer).SignStream(&grpc.GenericServerStream[SignStreamRequest, SignStreamResponse]{ServerStream: stream})
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type Signer_SignStreamServer = grpc.BidiStreamingServer[SignStreamRequest, SignStreamResponse]
+
+func _Signer_FreezeKey_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(FreezeKeyRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(SignerServer).FreezeKey(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Signer_FreezeKey_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(SignerServer).FreezeKey(ctx, req.(*FreezeKeyRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Signer_UnfreezeKey_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(UnfreezeKeyRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(SignerServer).UnfreezeKey(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Signer_UnfreezeKey_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(SignerServer).UnfreezeKey(ctx, req.(*UnfreezeKeyRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+// Signer_ServiceDesc is the grpc.ServiceDesc for Signer service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var Signer_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "sts.signer.v1.Signer",
+	HandlerType: (*SignerServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "GenerateKey",
+			Handler:    _Signer_GenerateKey_Handler,
+		},
+		{
+			MethodName: "SignTransaction",
+			Handler:    _Signer_SignTransaction_Handler,
+		},
+		{
+			MethodName: "VerifySignature",
+			Handler:    _Signer_VerifySignature_Handler,
+		},
+		{
+			MethodName: "FreezeKey",
+			Handler:    _Signer_FreezeKey_Handler,
+		},
+		{
+			MethodName: "UnfreezeKey",
+			Handler:    _Signer_UnfreezeKey_Handler,
+		},
+	},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "SignStream",
+			Handler:       _Signer_SignStream_Handler,
+			ServerStreams: true,
+			ClientStreams: true,
+		},
+	},
+	Metadata: "signerpb/signer.proto",
+}
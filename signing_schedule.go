@@ -0,0 +1,100 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+var errSigningWindow = errors.New("signing is not allowed at this time")
+
+var weekdays = map[string]time.Weekday{
+	"sun": time.Sunday, "mon": time.Monday, "tue": time.Tuesday, "wed": time.Wednesday,
+	"thu": time.Thursday, "fri": time.Friday, "sat": time.Saturday,
+}
+
+// SigningSchedule limits signing to certain days and hours. A window whose
+// end is before its start runs overnight.
+type SigningSchedule struct {
+	// IANA zone the days and hours are in, default UTC
+	TimeZone string `json:"timeZone,omitempty"`
+
+	// mon..sun, empty allows every day
+	Days []string `json:"days,omitempty"`
+
+	// HH:MM, both empty allows the whole day
+	Start string `json:"start,omitempty"`
+	End   string `json:"end,omitempty"`
+}
+
+func (s SigningSchedule) Validate() error {
+	if _, err := time.LoadLocation(s.TimeZone); err != nil {
+		return fmt.Errorf("invalid schedule timeZone: %w", err)
+	}
+	for _, day := range s.Days {
+		if _, ok := weekdays[strings.ToLower(day)]; !ok {
+			return fmt.Errorf("invalid schedule day %q, expected mon..sun", day)
+		}
+	}
+	if (s.Start == "") != (s.End == "") {
+		return errors.New("schedule start and end must be set together")
+	}
+	for _, hm := range []string{s.Start, s.End} {
+		if _, err := minuteOfDay(hm); hm != "" && err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Allows reports whether signing at now falls inside the schedule
+func (s SigningSchedule) Allows(now time.Time) bool {
+	loc, err := time.LoadLocation(s.TimeZone)
+	if err != nil {
+		return false
+	}
+	local := now.In(loc)
+
+	if len(s.Days) > 0 {
+		allowed := false
+		for _, day := range s.Days {
+			allowed = allowed || weekdays[strings.ToLower(day)] == local.Weekday()
+		}
+		if !allowed {
+			return false
+		}
+	}
+
+	if s.Start == "" {
+		return true
+	}
+	start, _ := minuteOfDay(s.Start)
+	end, _ := minuteOfDay(s.End)
+	minute := local.Hour()*60 + local.Minute()
+	if start <= end {
+		return minute >= start && minute < end
+	}
+	return minute >= start || minute < end
+}
+
+func minuteOfDay(hm string) (int, error) {
+	t, err := time.Parse("15:04", hm)
+	if err != nil {
+		return 0, fmt.Errorf("invalid schedule time %q, expected HH:MM", hm)
+	}
+	return t.Hour()*60 + t.Minute(), nil
+}
+
+// CheckSchedule enforces the key's signing hours and its cooldown after creation
+func (p KeyPolicy) CheckSchedule(now, createdAt time.Time) error {
+	if p.CooldownSeconds > 0 {
+		if ready := createdAt.Add(time.Duration(p.CooldownSeconds) * time.Second); now.Before(ready) {
+			return fmt.Errorf("%w: key is in its cooldown until %s", errSigningWindow, ready.UTC().Format(time.RFC3339))
+		}
+	}
+	if p.Schedule != nil && !p.Schedule.Allows(now) {
+		return fmt.Errorf("%w: outside the key's signing schedule", errSigningWindow)
+	}
+	return nil
+}
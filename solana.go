@@ -0,0 +1,779 @@
+package main
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math/big"
+	"slices"
+)
+
+// Solana wire format helpers: addresses, legacy messages and transactions.
+// Kept in-tree so the signer has no external chain dependencies.
+
+// max serialized transaction size accepted by the cluster
+const solanaMaxTxSize = 1232
+
+// longest seed an address may be derived w/
+const solanaMaxSeedLen = 32
+
+type SolanaPubkey [32]byte
+
+const base58Alphabet = "123456789ABCDEFGHJKLMNPQRSTUVWXYZabcdefghijkmnopqrstuvwxyz"
+
+var (
+	SystemProgramID        = MustSolanaPubkey("11111111111111111111111111111111")
+	BPFLoaderUpgradeableID = MustSolanaPubkey("BPFLoaderUpgradeab1e11111111111111111111111")
+	SysvarRentID           = MustSolanaPubkey("SysvarRent111111111111111111111111111111111")
+	SysvarClockID          = MustSolanaPubkey("SysvarC1ock11111111111111111111111111111111")
+
+	solanaPDAMarker = []byte("ProgramDerivedAddress")
+)
+
+var (
+	errInvalidSolanaAddress = errors.New("invalid solana address")
+	errSolanaPDANotFound    = errors.New("unable to find a viable program address bump seed")
+	errSolanaTxTooLarge     = errors.New("transaction exceeds max size")
+	errSolanaMissingSigner  = errors.New("missing private key for required signer")
+)
+
+func ParseSolanaPubkey(s string) (SolanaPubkey, error) {
+	var pk SolanaPubkey
+
+	raw, err := base58Decode(s)
+	if err != nil || len(raw) != len(pk) {
+		return pk, fmt.Errorf("%w: %q", errInvalidSolanaAddress, s)
+	}
+	copy(pk[:], raw)
+	return pk, nil
+}
+
+func MustSolanaPubkey(s string) SolanaPubkey {
+	pk, err := ParseSolanaPubkey(s)
+	if err != nil {
+		panic(err)
+	}
+	return pk
+}
+
+func (p SolanaPubkey) String() string {
+	return base58Encode(p[:])
+}
+
+// SolanaAddress is the base58 address for an ed25519 public key
+func SolanaAddress(pub ed25519.PublicKey) SolanaPubkey {
+	var pk SolanaPubkey
+	copy(pk[:], pub)
+	return pk
+}
+
+func base58Encode(b []byte) string {
+	x := new(big.Int).SetBytes(b)
+	base := big.NewInt(58)
+	mod := new(big.Int)
+
+	var out []byte
+	for x.Sign() > 0 {
+		x.DivMod(x, base, mod)
+		out = append(out, base58Alphabet[mod.Int64()])
+	}
+
+	// leading zero bytes map to '1'
+	for _, c := range b {
+		if c != 0 {
+			break
+		}
+		out = append(out, base58Alphabet[0])
+	}
+
+	for i, j := 0, len(out)-1; i < j; i, j = i+1, j-1 {
+		out[i], out[j] = out[j], out[i]
+	}
+	return string(out)
+}
+
+func base58Decode(s string) ([]byte, error) {
+	x := new(big.Int)
+	base := big.NewInt(58)
+
+	for _, c := range []byte(s) {
+		idx := -1
+		for i := 0; i < len(base58Alphabet); i++ {
+			if base58Alphabet[i] == c {
+				idx = i
+				break
+			}
+		}
+		if idx < 0 {
+			return nil, fmt.Errorf("invalid base58 character %q", c)
+		}
+		x.Mul(x, base)
+		x.Add(x, big.NewInt(int64(idx)))
+	}
+
+	out := x.Bytes()
+	for _, c := range []byte(s) {
+		if c != base58Alphabet[0] {
+			break
+		}
+		out = append([]byte{0}, out...)
+	}
+	return out, nil
+}
+
+// ed25519 field prime and curve constant for the on-curve check
+var (
+	curveP = new(big.Int).Sub(new(big.Int).Lsh(big.NewInt(1), 255), big.NewInt(19))
+	curveD = func() *big.Int {
+		num := new(big.Int).Sub(curveP, big.NewInt(121665))
+		den := new(big.Int).ModInverse(big.NewInt(121666), curveP)
+		return num.Mul(num, den).Mod(num, curveP)
+	}()
+)
+
+// isOnCurve reports whether b decompresses to an ed25519 point, program
+// derived addresses must not be
+func isOnCurve(b [32]byte) bool {
+	le := b
+	le[31] &= 0x7f
+
+	// little endian to big.Int
+	be := make([]byte, 32)
+	for i := range le {
+		be[31-i] = le[i]
+	}
+	y := new(big.Int).SetBytes(be)
+	y.Mod(y, curveP)
+
+	// x^2 = (y^2 - 1) / (d*y^2 + 1)
+	y2 := new(big.Int).Mul(y, y)
+	u := new(big.Int).Sub(y2, big.NewInt(1))
+	u.Mod(u, curveP)
+	v := new(big.Int).Mul(curveD, y2)
+	v.Add(v, big.NewInt(1)).Mod(v, curveP)
+
+	vInv := new(big.Int).ModInverse(v, curveP)
+	if vInv == nil {
+		return false
+	}
+	x2 := u.Mul(u, vInv).Mod(u, curveP)
+	if x2.Sign() == 0 {
+		return true
+	}
+
+	// euler's criterion
+	exp := new(big.Int).Rsh(new(big.Int).Sub(curveP, big.NewInt(1)), 1)
+	return new(big.Int).Exp(x2, exp, curveP).Cmp(big.NewInt(1)) == 0
+}
+
+// CreateWithSeed derives the address of an account created w/ seed by base,
+// no private key exists for it
+func CreateWithSeed(base SolanaPubkey, seed string, owner SolanaPubkey) (SolanaPubkey, error) {
+	if len(seed) > solanaMaxSeedLen {
+		return SolanaPubkey{}, fmt.Errorf("seed is longer than %d bytes", solanaMaxSeedLen)
+	}
+	h := sha256.New()
+	h.Write(base[:])
+	h.Write([]byte(seed))
+	h.Write(owner[:])
+
+	var pk SolanaPubkey
+	copy(pk[:], h.Sum(nil))
+	return pk, nil
+}
+
+func CreateProgramAddress(seeds [][]byte, programID SolanaPubkey) (SolanaPubkey, error) {
+	h := sha256.New()
+	for _, seed := range seeds {
+		h.Write(seed)
+	}
+	h.Write(programID[:])
+	h.Write(solanaPDAMarker)
+
+	var pk SolanaPubkey
+	copy(pk[:], h.Sum(nil))
+	if isOnCurve(pk) {
+		return pk, errors.New("derived address is on curve")
+	}
+	return pk, nil
+}
+
+func FindProgramAddress(seeds [][]byte, programID SolanaPubkey) (SolanaPubkey, uint8, error) {
+	for bump := 255; bump >= 0; bump-- {
+		withBump := append(append([][]byte{}, seeds...), []byte{byte(bump)})
+		pk, err := CreateProgramAddress(withBump, programID)
+		if err == nil {
+			return pk, uint8(bump), nil
+		}
+	}
+	return SolanaPubkey{}, 0, errSolanaPDANotFound
+}
+
+type SolanaAccountMeta struct {
+	Pubkey     SolanaPubkey
+	IsSigner   bool
+	IsWritable bool
+}
+
+type SolanaInstruction struct {
+	ProgramID SolanaPubkey
+	Accounts  []SolanaAccountMeta
+	Data      []byte
+}
+
+func appendCompactU16(buf []byte, n int) []byte {
+	for {
+		b := byte(n & 0x7f)
+		n >>= 7
+		if n == 0 {
+			return append(buf, b)
+		}
+		buf = append(buf, b|0x80)
+	}
+}
+
+// CompileSolanaMessage builds a legacy message, account order is fee payer,
+// writable signers, readonly signers, writable non-signers, readonly non-signers
+func CompileSolanaMessage(feePayer SolanaPubkey, recentBlockhash SolanaPubkey, ixs []SolanaInstruction) ([]byte, []SolanaPubkey) {
+	type flags struct{ signer, writable bool }
+
+	order := []SolanaPubkey{feePayer}
+	metas := map[SolanaPubkey]*flags{feePayer: {signer: true, writable: true}}
+
+	add := func(pk SolanaPubkey, signer, writable bool) {
+		f, ok := metas[pk]
+		if !ok {
+			f = &flags{}
+			metas[pk] = f
+			order = append(order, pk)
+		}
+		f.signer = f.signer || signer
+		f.writable = f.writable || writable
+	}
+
+	for _, ix := range ixs {
+		for _, acc := range ix.Accounts {
+			add(acc.Pubkey, acc.IsSigner, acc.IsWritable)
+		}
+		add(ix.ProgramID, false, false)
+	}
+
+	var keys []SolanaPubkey
+	for _, group := range []flags{{true, true}, {true, false}, {false, true}, {false, false}} {
+		for _, pk := range order {
+			if *metas[pk] == group {
+				keys = append(keys, pk)
+			}
+		}
+	}
+
+	var numSigners, numReadonlySigned, numReadonlyUnsigned byte
+	for _, pk := range keys {
+		f := metas[pk]
+		switch {
+		case f.signer && !f.writable:
+			numSigners++
+			numReadonlySigned++
+		case f.signer:
+			numSigners++
+		case !f.writable:
+			numReadonlyUnsigned++
+		}
+	}
+
+	index := make(map[SolanaPubkey]int, len(keys))
+	for i, pk := range keys {
+		index[pk] = i
+	}
+
+	msg := []byte{numSigners, numReadonlySigned, numReadonlyUnsigned}
+	msg = appendCompactU16(msg, len(keys))
+	for _, pk := range keys {
+		msg = append(msg, pk[:]...)
+	}
+	msg = append(msg, recentBlockhash[:]...)
+
+	msg = appendCompactU16(msg, len(ixs))
+	for _, ix := range ixs {
+		msg = append(msg, byte(index[ix.ProgramID]))
+		msg = appendCompactU16(msg, len(ix.Accounts))
+		for _, acc := range ix.Accounts {
+			msg = append(msg, byte(index[acc.Pubkey]))
+		}
+		msg = appendCompactU16(msg, len(ix.Data))
+		msg = append(msg, ix.Data...)
+	}
+
+	return msg, keys[:numSigners]
+}
+
+// SignSolanaMessage signs a compiled message w/ pure ed25519 (what the
+// cluster verifies) and returns the wire transaction and its signatures
+func SignSolanaMessage(msg []byte, signers []SolanaPubkey, keys map[SolanaPubkey]ed25519.PrivateKey) ([]byte, [][]byte, error) {
+	tx := appendCompactU16(nil, len(signers))
+
+	var sigs [][]byte
+	for _, pk := range signers {
+		privKey, ok := keys[pk]
+		if !ok {
+			return nil, nil, fmt.Errorf("%w: %s", errSolanaMissingSigner, pk)
+		}
+		sig := ed25519.Sign(privKey, msg)
+		sigs = append(sigs, sig)
+		tx = append(tx, sig...)
+	}
+	tx = append(tx, msg...)
+
+	if len(tx) > solanaMaxTxSize {
+		return nil, nil, fmt.Errorf("%w: %d > %d bytes", errSolanaTxTooLarge, len(tx), solanaMaxTxSize)
+	}
+	return tx, sigs, nil
+}
+
+// rent exempt minimum w/ the cluster's default rent parameters
+func solanaRentExemptMinimum(space uint64) uint64 {
+	const (
+		accountStorageOverhead = 128
+		lamportsPerByteYear    = 3480
+		exemptionThresholdYrs  = 2
+	)
+	return (accountStorageOverhead + space) * lamportsPerByteYear * exemptionThresholdYrs
+}
+
+// SystemCreateAccountWithSeedIx creates the account at CreateWithSeed(base,
+// seed, owner), from and base sign
+func SystemCreateAccountWithSeedIx(from, newAccount, base SolanaPubkey, seed string, lamports, space uint64, owner SolanaPubkey) SolanaInstruction {
+	data := binary.LittleEndian.AppendUint32(nil, 3)
+	data = append(data, base[:]...)
+	data = binary.LittleEndian.AppendUint64(data, uint64(len(seed)))
+	data = append(data, seed...)
+	data = binary.LittleEndian.AppendUint64(data, lamports)
+	data = binary.LittleEndian.AppendUint64(data, space)
+	data = append(data, owner[:]...)
+
+	accounts := []SolanaAccountMeta{
+		{Pubkey: from, IsSigner: true, IsWritable: true},
+		{Pubkey: newAccount, IsWritable: true},
+	}
+	if base != from {
+		accounts = append(accounts, SolanaAccountMeta{Pubkey: base, IsSigner: true})
+	}
+	return SolanaInstruction{ProgramID: SystemProgramID, Accounts: accounts, Data: data}
+}
+
+func SystemCreateAccountIx(from, newAccount SolanaPubkey, lamports, space uint64, owner SolanaPubkey) SolanaInstruction {
+	data := binary.LittleEndian.AppendUint32(nil, 0)
+	data = binary.LittleEndian.AppendUint64(data, lamports)
+	data = binary.LittleEndian.AppendUint64(data, space)
+	data = append(data, owner[:]...)
+
+	return SolanaInstruction{
+		ProgramID: SystemProgramID,
+		Accounts: []SolanaAccountMeta{
+			{Pubkey: from, IsSigner: true, IsWritable: true},
+			{Pubkey: newAccount, IsSigner: true, IsWritable: true},
+		},
+		Data: data,
+	}
+}
+
+// SolanaMessage is a parsed legacy or v0 message
+type SolanaMessage struct {
+	// -1 for legacy messages
+	Version int
+
+	NumRequiredSignatures       int
+	NumReadonlySignedAccounts   int
+	NumReadonlyUnsignedAccounts int
+
+	AccountKeys         []SolanaPubkey
+	RecentBlockhash     SolanaPubkey
+	Instructions        []SolanaCompiledInstruction
+	AddressTableLookups []SolanaAddressTableLookup
+}
+
+type SolanaCompiledInstruction struct {
+	ProgramIDIndex int
+	Accounts       []int
+	Data           []byte
+}
+
+type SolanaAddressTableLookup struct {
+	AccountKey      SolanaPubkey
+	WritableIndexes []byte
+	ReadonlyIndexes []byte
+}
+
+var (
+	errSolanaMalformed = errors.New("malformed solana message")
+	errTxAlreadySigned = errors.New("transaction already carries signatures")
+)
+
+type solanaReader struct {
+	buf []byte
+	pos int
+}
+
+func (r *solanaReader) byte() (byte, error) {
+	if r.pos >= len(r.buf) {
+		return 0, errSolanaMalformed
+	}
+	b := r.buf[r.pos]
+	r.pos++
+	return b, nil
+}
+
+func (r *solanaReader) bytes(n int) ([]byte, error) {
+	if n < 0 || r.pos+n > len(r.buf) {
+		return nil, errSolanaMalformed
+	}
+	b := r.buf[r.pos : r.pos+n]
+	r.pos += n
+	return b, nil
+}
+
+func (r *solanaReader) compactU16() (int, error) {
+	n := 0
+	for i := 0; i < 3; i++ {
+		b, err := r.byte()
+		if err != nil {
+			return 0, err
+		}
+		n |= int(b&0x7f) << (7 * i)
+		if b&0x80 == 0 {
+			return n, nil
+		}
+	}
+	return 0, errSolanaMalformed
+}
+
+func (r *solanaReader) pubkey() (SolanaPubkey, error) {
+	var pk SolanaPubkey
+	b, err := r.bytes(32)
+	if err != nil {
+		return pk, err
+	}
+	copy(pk[:], b)
+	return pk, nil
+}
+
+// ParseSolanaMessage decodes a message, every byte must be consumed
+func ParseSolanaMessage(b []byte) (*SolanaMessage, error) {
+	r := &solanaReader{buf: b}
+	msg := &SolanaMessage{Version: -1}
+
+	first, err := r.byte()
+	if err != nil {
+		return nil, err
+	}
+
+	// versioned messages set the top bit of the first byte
+	if first&0x80 != 0 {
+		msg.Version = int(first & 0x7f)
+		if msg.Version != 0 {
+			return nil, fmt.Errorf("unsupported message version %d", msg.Version)
+		}
+		if first, err = r.byte(); err != nil {
+			return nil, err
+		}
+	}
+	msg.NumRequiredSignatures = int(first)
+
+	header, err := r.bytes(2)
+	if err != nil {
+		return nil, err
+	}
+	msg.NumReadonlySignedAccounts = int(header[0])
+	msg.NumReadonlyUnsignedAccounts = int(header[1])
+
+	numKeys, err := r.compactU16()
+	if err != nil {
+		return nil, err
+	}
+	for i := 0; i < numKeys; i++ {
+		pk, err := r.pubkey()
+		if err != nil {
+			return nil, err
+		}
+		msg.AccountKeys = append(msg.AccountKeys, pk)
+	}
+	if msg.NumRequiredSignatures == 0 || msg.NumRequiredSignatures > numKeys ||
+		msg.NumReadonlySignedAccounts >= msg.NumRequiredSignatures ||
+		msg.NumReadonlyUnsignedAccounts > numKeys-msg.NumRequiredSignatures {
+		return nil, fmt.Errorf("%w: inconsistent header", errSolanaMalformed)
+	}
+
+	if msg.RecentBlockhash, err = r.pubkey(); err != nil {
+		return nil, err
+	}
+
+	numIxs, err := r.compactU16()
+	if err != nil {
+		return nil, err
+	}
+	for i := 0; i < numIxs; i++ {
+		var ix SolanaCompiledInstruction
+
+		programIdx, err := r.byte()
+		if err != nil {
+			return nil, err
+		}
+		ix.ProgramIDIndex = int(programIdx)
+
+		numAccounts, err := r.compactU16()
+		if err != nil {
+			return nil, err
+		}
+		accounts, err := r.bytes(numAccounts)
+		if err != nil {
+			return nil, err
+		}
+		for _, a := range accounts {
+			ix.Accounts = append(ix.Accounts, int(a))
+		}
+
+		dataLen, err := r.compactU16()
+		if err != nil {
+			return nil, err
+		}
+		if ix.Data, err = r.bytes(dataLen); err != nil {
+			return nil, err
+		}
+
+		msg.Instructions = append(msg.Instructions, ix)
+	}
+
+	if msg.Version == 0 {
+		numLookups, err := r.compactU16()
+		if err != nil {
+			return nil, err
+		}
+		for i := 0; i < numLookups; i++ {
+			var lookup SolanaAddressTableLookup
+			if lookup.AccountKey, err = r.pubkey(); err != nil {
+				return nil, err
+			}
+			n, err := r.compactU16()
+			if err != nil {
+				return nil, err
+			}
+			if lookup.WritableIndexes, err = r.bytes(n); err != nil {
+				return nil, err
+			}
+			if n, err = r.compactU16(); err != nil {
+				return nil, err
+			}
+			if lookup.ReadonlyIndexes, err = r.bytes(n); err != nil {
+				return nil, err
+			}
+			msg.AddressTableLookups = append(msg.AddressTableLookups, lookup)
+		}
+	}
+
+	if r.pos != len(b) {
+		return nil, fmt.Errorf("%w: %d trailing bytes", errSolanaMalformed, len(b)-r.pos)
+	}
+
+	// program ids must be static keys, lookups can't supply them
+	for _, ix := range msg.Instructions {
+		if ix.ProgramIDIndex >= len(msg.AccountKeys) {
+			return nil, fmt.Errorf("%w: program id index out of range", errSolanaMalformed)
+		}
+	}
+	return msg, nil
+}
+
+// Marshal serializes the message back to its wire form
+func (m *SolanaMessage) Marshal() []byte {
+	var msg []byte
+	if m.Version >= 0 {
+		msg = append(msg, 0x80|byte(m.Version))
+	}
+	msg = append(msg, byte(m.NumRequiredSignatures), byte(m.NumReadonlySignedAccounts), byte(m.NumReadonlyUnsignedAccounts))
+	msg = appendCompactU16(msg, len(m.AccountKeys))
+	for _, pk := range m.AccountKeys {
+		msg = append(msg, pk[:]...)
+	}
+	msg = append(msg, m.RecentBlockhash[:]...)
+
+	msg = appendCompactU16(msg, len(m.Instructions))
+	for _, ix := range m.Instructions {
+		msg = append(msg, byte(ix.ProgramIDIndex))
+		msg = appendCompactU16(msg, len(ix.Accounts))
+		for _, a := range ix.Accounts {
+			msg = append(msg, byte(a))
+		}
+		msg = appendCompactU16(msg, len(ix.Data))
+		msg = append(msg, ix.Data...)
+	}
+
+	if m.Version == 0 {
+		msg = appendCompactU16(msg, len(m.AddressTableLookups))
+		for _, lookup := range m.AddressTableLookups {
+			msg = append(msg, lookup.AccountKey[:]...)
+			msg = appendCompactU16(msg, len(lookup.WritableIndexes))
+			msg = append(msg, lookup.WritableIndexes...)
+			msg = appendCompactU16(msg, len(lookup.ReadonlyIndexes))
+			msg = append(msg, lookup.ReadonlyIndexes...)
+		}
+	}
+	return msg
+}
+
+// IsWritable says whether the static account at index i is writable
+func (m *SolanaMessage) IsWritable(i int) bool {
+	if i < m.NumRequiredSignatures {
+		return i < m.NumRequiredSignatures-m.NumReadonlySignedAccounts
+	}
+	return i < len(m.AccountKeys)-m.NumReadonlyUnsignedAccounts
+}
+
+// SolanaPayload is what a client submitted for signing: a bare message or a
+// (partially) signed transaction
+type SolanaPayload struct {
+	Message    *SolanaMessage
+	MsgBytes   []byte
+	Signatures [][]byte
+}
+
+// ParseSolanaPayload accepts a wire transaction or a bare message, a
+// transaction is tried first and must parse exactly
+func ParseSolanaPayload(b []byte) (*SolanaPayload, error) {
+	r := &solanaReader{buf: b}
+	if n, err := r.compactU16(); err == nil && n > 0 {
+		if sigBytes, err := r.bytes(64 * n); err == nil {
+			msgBytes := b[r.pos:]
+			if msg, err := ParseSolanaMessage(msgBytes); err == nil && msg.NumRequiredSignatures == n {
+				payload := &SolanaPayload{Message: msg, MsgBytes: msgBytes}
+				for i := 0; i < n; i++ {
+					payload.Signatures = append(payload.Signatures, sigBytes[64*i:64*(i+1)])
+				}
+				return payload, nil
+			}
+		}
+	}
+
+	msg, err := ParseSolanaMessage(b)
+	if err != nil {
+		return nil, err
+	}
+	return &SolanaPayload{
+		Message:    msg,
+		MsgBytes:   b,
+		Signatures: make([][]byte, msg.NumRequiredSignatures),
+	}, nil
+}
+
+// insertKey puts pk among the static keys at i, shifting the indices of
+// later keys and of accounts loaded from lookup tables
+func (m *SolanaMessage) insertKey(pk SolanaPubkey, i int) error {
+	if len(m.AccountKeys) >= 256 {
+		return fmt.Errorf("%w: no room for another account", errSolanaMalformed)
+	}
+	m.AccountKeys = slices.Insert(m.AccountKeys, i, pk)
+	for n, ix := range m.Instructions {
+		if ix.ProgramIDIndex >= i {
+			m.Instructions[n].ProgramIDIndex++
+		}
+		for j, a := range ix.Accounts {
+			if a >= i {
+				m.Instructions[n].Accounts[j] = a + 1
+			}
+		}
+	}
+	return nil
+}
+
+// addReadonlyKey appends pk as a readonly non-signer, those come last among
+// static keys, and returns its index
+func (m *SolanaMessage) addReadonlyKey(pk SolanaPubkey) (int, error) {
+	i := len(m.AccountKeys)
+	if err := m.insertKey(pk, i); err != nil {
+		return 0, err
+	}
+	m.NumReadonlyUnsignedAccounts++
+	return i, nil
+}
+
+// addWritableKey puts pk first among the writable non-signers and returns
+// its index
+func (m *SolanaMessage) addWritableKey(pk SolanaPubkey) (int, error) {
+	i := m.NumRequiredSignatures
+	if err := m.insertKey(pk, i); err != nil {
+		return 0, err
+	}
+	return i, nil
+}
+
+// SignerIndex is the signature slot for pk, or -1 if pk isn't a required signer
+func (m *SolanaMessage) SignerIndex(pk SolanaPubkey) int {
+	for i := 0; i < m.NumRequiredSignatures; i++ {
+		if m.AccountKeys[i] == pk {
+			return i
+		}
+	}
+	return -1
+}
+
+// WireTransaction places sig in pk's slot and serializes the transaction,
+// slots w/o a signature are zero filled
+func (p *SolanaPayload) WireTransaction(pk SolanaPubkey, sig []byte) ([]byte, error) {
+	idx := p.Message.SignerIndex(pk)
+	if idx < 0 {
+		return nil, errors.New("key is not a required signer of this message")
+	}
+	p.Signatures[idx] = sig
+
+	return p.UnsignedWire(), nil
+}
+
+// UnsignedWire serializes the transaction w/ whatever signatures it has,
+// missing ones zero filled, for simulation
+func (p *SolanaPayload) UnsignedWire() []byte {
+	tx := appendCompactU16(nil, len(p.Signatures))
+	for _, s := range p.Signatures {
+		if len(s) == 0 {
+			s = make([]byte, 64)
+		}
+		tx = append(tx, s...)
+	}
+	return append(tx, p.MsgBytes...)
+}
+
+// AnySigned reports whether any signature slot is filled, the message
+// can't change once one is
+func (p *SolanaPayload) AnySigned() bool {
+	for _, s := range p.Signatures {
+		if len(s) > 0 && !bytes.Equal(s, make([]byte, 64)) {
+			return true
+		}
+	}
+	return false
+}
+
+// FullySigned reports whether every signature slot is filled
+func (p *SolanaPayload) FullySigned() bool {
+	for _, s := range p.Signatures {
+		if len(s) == 0 || bytes.Equal(s, make([]byte, 64)) {
+			return false
+		}
+	}
+	return true
+}
+
+func SystemTransferIx(from, to SolanaPubkey, lamports uint64) SolanaInstruction {
+	data := binary.LittleEndian.AppendUint32(nil, 2)
+	data = binary.LittleEndian.AppendUint64(data, lamports)
+
+	return SolanaInstruction{
+		ProgramID: SystemProgramID,
+		Accounts: []SolanaAccountMeta{
+			{Pubkey: from, IsSigner: true, IsWritable: true},
+			{Pubkey: to, IsWritable: true},
+		},
+		Data: data,
+	}
+}
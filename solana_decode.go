@@ -0,0 +1,317 @@
+package main
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"unicode/utf8"
+)
+
+var (
+	TokenProgramID           = MustSolanaPubkey("TokenkegQfeZyiNwAJbNbGKPFXCWuBvf9Ss623VQ5DA")
+	Token2022ProgramID       = MustSolanaPubkey("TokenzQdBNbLqP5VEhdkAS6EPFLC1PHnBqCXEpPxuEb")
+	AssociatedTokenProgramID = MustSolanaPubkey("ATokenGPvbdGVxr1b2hvZbsiqW5xUi7Q1ZbHtB4dTbxk")
+	StakeProgramID           = MustSolanaPubkey("Stake11111111111111111111111111111111111111")
+	MemoProgramID            = MustSolanaPubkey("MemoSq4gqABAXKb96qnH8TysNcWxMyWCqXgDLGmfcHr")
+	MemoV1ProgramID          = MustSolanaPubkey("Memo1UhkJRfHyvLMcVucJwxXeuD728EqVDDwQDxFMNo")
+)
+
+// longest memo the builders attach, well inside what fits a transaction
+const maxMemoLen = 256
+
+// value movement kinds found when decoding instructions
+const (
+	TransferSystem   = "system-transfer"
+	TransferToken    = "token-transfer"
+	TransferApproval = "token-approve"
+	TransferClose    = "close-account"
+
+	// an account's owner or authority handed to Destination, w/ everything
+	// it holds, the amount isn't known
+	TransferAuthority = "authority-change"
+)
+
+var errUnresolvableAccount = errors.New("account is loaded from an address lookup table and can't be checked")
+
+// SolanaTransfer is a decoded instruction that moves value (or the right to
+// move it) to a destination
+type SolanaTransfer struct {
+	Instruction int
+	Kind        string
+	Program     SolanaPubkey
+	Source      SolanaPubkey
+	Destination SolanaPubkey
+
+	// account whose signature authorizes the movement
+	Authority SolanaPubkey
+
+	// zero when the instruction doesn't name the mint
+	Mint   SolanaPubkey
+	Amount uint64
+}
+
+func isTokenProgram(pk SolanaPubkey) bool {
+	return pk == TokenProgramID || pk == Token2022ProgramID
+}
+
+// pubkeyAt reads the pubkey at offset in data
+func pubkeyAt(data []byte, offset int) (SolanaPubkey, bool) {
+	var pk SolanaPubkey
+	if offset < 0 || len(data) < offset+32 {
+		return pk, false
+	}
+	copy(pk[:], data[offset:])
+	return pk, true
+}
+
+// seedEnd is the offset past the u64 length prefixed seed at offset
+func seedEnd(data []byte, offset int) (int, bool) {
+	if len(data) < offset+8 {
+		return 0, false
+	}
+	// checked as a u64 first, a huge length would wrap an int
+	seedLen := binary.LittleEndian.Uint64(data[offset:])
+	if seedLen > uint64(len(data)-offset-8) {
+		return 0, false
+	}
+	return offset + 8 + int(seedLen), true
+}
+
+// DecodeSolanaTransfers finds system, stake and SPL token instructions that
+// send lamports/tokens, delegate spending rights or hand an account to a new
+// owner or authority
+func DecodeSolanaTransfers(msg *SolanaMessage) ([]SolanaTransfer, error) {
+	var transfers []SolanaTransfer
+
+	for i, ix := range msg.Instructions {
+		program := msg.AccountKeys[ix.ProgramIDIndex]
+
+		// account resolves ix.Accounts[n] to a static key
+		account := func(n int) (SolanaPubkey, error) {
+			if n >= len(ix.Accounts) {
+				return SolanaPubkey{}, fmt.Errorf("%w: instruction %d is missing accounts", errSolanaMalformed, i)
+			}
+			idx := ix.Accounts[n]
+			if idx >= len(msg.AccountKeys) {
+				return SolanaPubkey{}, fmt.Errorf("instruction %d: %w", i, errUnresolvableAccount)
+			}
+			return msg.AccountKeys[idx], nil
+		}
+
+		var t *SolanaTransfer
+		var srcIdx, dstIdx, mintIdx, authIdx = 0, -1, -1, 0
+
+		// handOff decodes the new owner or authority at offset in the data
+		handOff := func(offset int) error {
+			t = &SolanaTransfer{Kind: TransferAuthority}
+			pk, ok := pubkeyAt(ix.Data, offset)
+			if !ok {
+				return fmt.Errorf("%w: instruction %d", errSolanaMalformed, i)
+			}
+			t.Destination = pk
+			return nil
+		}
+
+		switch {
+		case program == SystemProgramID && len(ix.Data) >= 4:
+			switch tag := binary.LittleEndian.Uint32(ix.Data); tag {
+			case 0, 3:
+				// CreateAccount / CreateAccountWithSeed fund the new account
+				t = &SolanaTransfer{Kind: TransferSystem}
+				dstIdx = 1
+				offset := 4
+				if tag == 3 {
+					// base pubkey, then a u64 length prefixed seed
+					var ok bool
+					if offset, ok = seedEnd(ix.Data, 36); !ok {
+						return nil, fmt.Errorf("%w: instruction %d", errSolanaMalformed, i)
+					}
+				}
+				if len(ix.Data) < offset+8 {
+					return nil, fmt.Errorf("%w: instruction %d", errSolanaMalformed, i)
+				}
+				t.Amount = binary.LittleEndian.Uint64(ix.Data[offset:])
+			case 2:
+				t = &SolanaTransfer{Kind: TransferSystem}
+				dstIdx = 1
+				if len(ix.Data) < 12 {
+					return nil, fmt.Errorf("%w: instruction %d", errSolanaMalformed, i)
+				}
+				t.Amount = binary.LittleEndian.Uint64(ix.Data[4:])
+			case 5:
+				// WithdrawNonceAccount: nonce, to, recent blockhashes, rent, authority
+				t = &SolanaTransfer{Kind: TransferSystem}
+				dstIdx, authIdx = 1, 4
+				if len(ix.Data) < 12 {
+					return nil, fmt.Errorf("%w: instruction %d", errSolanaMalformed, i)
+				}
+				t.Amount = binary.LittleEndian.Uint64(ix.Data[4:])
+			case 11:
+				// TransferWithSeed: from, base, to
+				t = &SolanaTransfer{Kind: TransferSystem}
+				dstIdx, authIdx = 2, 1
+				if len(ix.Data) < 12 {
+					return nil, fmt.Errorf("%w: instruction %d", errSolanaMalformed, i)
+				}
+				t.Amount = binary.LittleEndian.Uint64(ix.Data[4:])
+			case 1:
+				// Assign: account, the new owner program in the data
+				if err := handOff(4); err != nil {
+					return nil, err
+				}
+			case 7:
+				// AuthorizeNonceAccount: nonce, authority
+				if err := handOff(4); err != nil {
+					return nil, err
+				}
+				authIdx = 1
+			case 10:
+				// AssignWithSeed: account, base. Base pubkey, seed, then the owner
+				offset, ok := seedEnd(ix.Data, 36)
+				if !ok {
+					return nil, fmt.Errorf("%w: instruction %d", errSolanaMalformed, i)
+				}
+				if err := handOff(offset); err != nil {
+					return nil, err
+				}
+				authIdx = 1
+			}
+
+		case program == StakeProgramID && len(ix.Data) >= 4:
+			switch binary.LittleEndian.Uint32(ix.Data) {
+			case stakeWithdraw:
+				// Withdraw: stake, recipient, clock, stake history, withdrawer
+				t = &SolanaTransfer{Kind: TransferSystem}
+				dstIdx, authIdx = 1, 4
+				if len(ix.Data) < 12 {
+					return nil, fmt.Errorf("%w: instruction %d", errSolanaMalformed, i)
+				}
+				t.Amount = binary.LittleEndian.Uint64(ix.Data[4:])
+			case stakeAuthorize:
+				// Authorize: stake, clock, authority, the new authority in the data
+				if err := handOff(4); err != nil {
+					return nil, err
+				}
+				authIdx = 2
+			case stakeAuthorizeWithSeed:
+				// AuthorizeWithSeed: stake, base, clock
+				if err := handOff(4); err != nil {
+					return nil, err
+				}
+				authIdx = 1
+			case stakeAuthorizeChecked:
+				// AuthorizeChecked: stake, clock, authority, new authority
+				t = &SolanaTransfer{Kind: TransferAuthority}
+				dstIdx, authIdx = 3, 2
+			case stakeAuthorizeCheckedWithSeed:
+				// AuthorizeCheckedWithSeed: stake, base, clock, new authority
+				t = &SolanaTransfer{Kind: TransferAuthority}
+				dstIdx, authIdx = 3, 1
+			}
+
+		case isTokenProgram(program) && len(ix.Data) >= 1:
+			switch ix.Data[0] {
+			case 3:
+				// Transfer: source, destination, owner
+				t = &SolanaTransfer{Kind: TransferToken}
+				dstIdx, authIdx = 1, 2
+			case 12:
+				// TransferChecked: source, mint, destination, owner
+				t = &SolanaTransfer{Kind: TransferToken}
+				mintIdx, dstIdx, authIdx = 1, 2, 3
+			case 4:
+				// Approve: source, delegate, owner
+				t = &SolanaTransfer{Kind: TransferApproval}
+				dstIdx, authIdx = 1, 2
+			case 13:
+				// ApproveChecked: source, mint, delegate, owner
+				t = &SolanaTransfer{Kind: TransferApproval}
+				mintIdx, dstIdx, authIdx = 1, 2, 3
+			case 9:
+				// CloseAccount: account, destination, owner
+				t = &SolanaTransfer{Kind: TransferClose}
+				dstIdx, authIdx = 1, 2
+			case 6:
+				// SetAuthority: account or mint, authority. Authority type, then
+				// an optional new authority, none only gives the authority up.
+				if len(ix.Data) < 3 {
+					return nil, fmt.Errorf("%w: instruction %d", errSolanaMalformed, i)
+				}
+				if ix.Data[2] != 0 {
+					if err := handOff(3); err != nil {
+						return nil, err
+					}
+					authIdx = 1
+				}
+			}
+			if t != nil && t.Kind != TransferClose && t.Kind != TransferAuthority {
+				if len(ix.Data) < 9 {
+					return nil, fmt.Errorf("%w: instruction %d", errSolanaMalformed, i)
+				}
+				t.Amount = binary.LittleEndian.Uint64(ix.Data[1:])
+			}
+		}
+
+		if t == nil {
+			continue
+		}
+
+		t.Instruction = i
+		t.Program = program
+
+		var err error
+		if t.Source, err = account(srcIdx); err != nil {
+			return nil, err
+		}
+		if dstIdx >= 0 {
+			if t.Destination, err = account(dstIdx); err != nil {
+				return nil, err
+			}
+		}
+		if t.Authority, err = account(authIdx); err != nil {
+			return nil, err
+		}
+		if mintIdx >= 0 {
+			if t.Mint, err = account(mintIdx); err != nil {
+				return nil, err
+			}
+		}
+		transfers = append(transfers, *t)
+	}
+	return transfers, nil
+}
+
+// MemoIx attaches the memo, signed by the signers so it can't be forged
+// onto someone else's transaction
+func MemoIx(memo string, signers ...SolanaPubkey) (SolanaInstruction, error) {
+	if len(memo) > maxMemoLen {
+		return SolanaInstruction{}, fmt.Errorf("memo is longer than %d bytes", maxMemoLen)
+	}
+	if !utf8.ValidString(memo) {
+		return SolanaInstruction{}, errors.New("memo must be valid UTF-8")
+	}
+	ix := SolanaInstruction{ProgramID: MemoProgramID, Data: []byte(memo)}
+	for _, signer := range signers {
+		ix.Accounts = append(ix.Accounts, SolanaAccountMeta{Pubkey: signer, IsSigner: true})
+	}
+	return ix, nil
+}
+
+// SolanaMemos are the message's memo instructions in order, for either
+// version of the memo program
+func SolanaMemos(msg *SolanaMessage) []string {
+	var memos []string
+	for _, ix := range msg.Instructions {
+		program := msg.AccountKeys[ix.ProgramIDIndex]
+		if (program == MemoProgramID || program == MemoV1ProgramID) && utf8.Valid(ix.Data) {
+			memos = append(memos, string(ix.Data))
+		}
+	}
+	return memos
+}
+
+// AssociatedTokenAddress derives the ATA for a wallet and mint
+func AssociatedTokenAddress(wallet, mint, tokenProgram SolanaPubkey) (SolanaPubkey, error) {
+	ata, _, err := FindProgramAddress([][]byte{wallet[:], tokenProgram[:], mint[:]}, AssociatedTokenProgramID)
+	return ata, err
+}
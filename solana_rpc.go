@@ -0,0 +1,468 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+var errNoRPC = errors.New("no solana rpc endpoint configured")
+
+type rpcError struct {
+	Code    int             `json:"code"`
+	Message string          `json:"message"`
+	Data    json.RawMessage `json:"data,omitempty"`
+}
+
+func (e *rpcError) Error() string {
+	return fmt.Sprintf("rpc error %d: %s", e.Code, e.Message)
+}
+
+// rpcStatusError is an endpoint answering w/ something other than 200
+type rpcStatusError struct {
+	Method string
+	Status int
+}
+
+func (e *rpcStatusError) Error() string {
+	return fmt.Sprintf("%s request failed w/ status %d %s", e.Method, e.Status, http.StatusText(e.Status))
+}
+
+// ChainClient is what the signer needs from a cluster: broadcast,
+// simulation, blockhashes, signature statuses, recent fees, balances and,
+// off mainnet, airdrops
+type ChainClient interface {
+	Health(ctx context.Context) error
+	SendTransaction(ctx context.Context, tx []byte) (string, error)
+	SimulateTransaction(ctx context.Context, tx []byte) (SimulationResult, error)
+	LatestBlockhash(ctx context.Context) (Blockhash, error)
+	BlockhashValid(ctx context.Context, blockhash string) (bool, error)
+	SignatureStatuses(ctx context.Context, sigs []string) ([]*SignatureStatus, error)
+	RecentPrioritizationFees(ctx context.Context, accounts []string) ([]uint64, error)
+	Balance(ctx context.Context, address string) (uint64, error)
+	TokenBalances(ctx context.Context, owner string) ([]TokenBalance, error)
+	GenesisHash(ctx context.Context) (string, error)
+	RequestAirdrop(ctx context.Context, address string, lamports uint64) (string, error)
+	TokenMint(ctx context.Context, mint string) (TokenMint, error)
+	NonceAccount(ctx context.Context, address string) (NonceAccount, error)
+}
+
+// default per call timeout, a call's own context deadline still applies
+const defaultRPCTimeout = 10 * time.Second
+
+// idle connections kept to the endpoint, broadcasts come in bursts
+const rpcIdleConns = 16
+
+// SolanaRPC is a minimal JSON-RPC client for the cluster endpoint. It keeps
+// its own pool of connections to the endpoint so calls reuse them.
+type SolanaRPC struct {
+	URL    string
+	client *http.Client
+}
+
+// constructor, timeout bounds each call and zero takes the default
+func NewSolanaRPC(url string, timeout time.Duration) *SolanaRPC {
+	if timeout <= 0 {
+		timeout = defaultRPCTimeout
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.MaxIdleConns = rpcIdleConns
+	transport.MaxIdleConnsPerHost = rpcIdleConns
+	transport.IdleConnTimeout = 90 * time.Second
+	transport.ResponseHeaderTimeout = timeout
+	return &SolanaRPC{
+		URL:    url,
+		client: &http.Client{Timeout: timeout, Transport: otelhttp.NewTransport(transport)},
+	}
+}
+
+func (c *SolanaRPC) call(ctx context.Context, method string, params []any, out any) (err error) {
+	ctx, span := tracer.Start(ctx, "solana."+method, trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(attribute.String("rpc.system", "jsonrpc"), attribute.String("rpc.method", method)))
+	defer func() { endSpan(span, err) }()
+
+	body, err := json.Marshal(map[string]any{
+		"jsonrpc": "2.0",
+		"id":      1,
+		"method":  method,
+		"params":  params,
+	})
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		// the URL often carries the provider's API key, keep it out of errors
+		var uerr *url.Error
+		if errors.As(err, &uerr) {
+			err = uerr.Err
+		}
+		return fmt.Errorf("%s request failed: %w", method, err)
+	}
+	defer resp.Body.Close()
+
+	// read to the end either way, or the connection can't be reused
+	defer io.Copy(io.Discard, resp.Body)
+
+	if resp.StatusCode != http.StatusOK {
+		return &rpcStatusError{Method: method, Status: resp.StatusCode}
+	}
+
+	var envelope struct {
+		Result json.RawMessage `json:"result"`
+		Error  *rpcError       `json:"error"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&envelope); err != nil {
+		return fmt.Errorf("invalid %s response: %w", method, err)
+	}
+	if envelope.Error != nil {
+		return envelope.Error
+	}
+	if out == nil {
+		return nil
+	}
+	return json.Unmarshal(envelope.Result, out)
+}
+
+// Health is the node's getHealth, an error when it's unreachable or behind
+func (c *SolanaRPC) Health(ctx context.Context) error {
+	var health string
+	if err := c.call(ctx, "getHealth", nil, &health); err != nil {
+		return err
+	}
+	if health != "ok" {
+		return fmt.Errorf("node reports %q", health)
+	}
+	return nil
+}
+
+// SendTransaction submits a signed wire transaction and returns its base58 signature
+func (c *SolanaRPC) SendTransaction(ctx context.Context, tx []byte) (string, error) {
+	var sig string
+	err := c.call(ctx, "sendTransaction", []any{
+		base64.StdEncoding.EncodeToString(tx),
+		map[string]any{"encoding": "base64"},
+	}, &sig)
+	return sig, err
+}
+
+// Blockhash is a recent blockhash and the last block height a transaction
+// using it can land at
+type Blockhash struct {
+	Hash                 string `json:"blockhash"`
+	LastValidBlockHeight uint64 `json:"lastValidBlockHeight"`
+
+	// the slot the cluster answered at
+	Slot uint64 `json:"slot"`
+}
+
+// LatestBlockhash is getLatestBlockhash at confirmed commitment
+func (c *SolanaRPC) LatestBlockhash(ctx context.Context) (Blockhash, error) {
+	var latest struct {
+		Context struct {
+			Slot uint64 `json:"slot"`
+		} `json:"context"`
+		Value Blockhash `json:"value"`
+	}
+	if err := c.call(ctx, "getLatestBlockhash", []any{map[string]any{"commitment": "confirmed"}}, &latest); err != nil {
+		return Blockhash{}, err
+	}
+	if latest.Value.Hash == "" {
+		return Blockhash{}, errors.New("getLatestBlockhash returned no blockhash")
+	}
+	latest.Value.Slot = latest.Context.Slot
+	return latest.Value, nil
+}
+
+// BlockhashValid is isBlockhashValid, false once transactions using the
+// blockhash can no longer land
+func (c *SolanaRPC) BlockhashValid(ctx context.Context, blockhash string) (bool, error) {
+	var valid struct {
+		Value bool `json:"value"`
+	}
+	err := c.call(ctx, "isBlockhashValid", []any{blockhash, map[string]any{"commitment": "processed"}}, &valid)
+	return valid.Value, err
+}
+
+// SignatureStatus is where a transaction is, Err is set when it failed
+type SignatureStatus struct {
+	Slot               uint64          `json:"slot"`
+	Confirmations      *uint64         `json:"confirmations"`
+	Err                json.RawMessage `json:"err"`
+	ConfirmationStatus string          `json:"confirmationStatus"`
+}
+
+// Failed says whether the transaction landed w/ an error
+func (s *SignatureStatus) Failed() bool {
+	return len(s.Err) > 0 && string(s.Err) != "null"
+}
+
+// SignatureStatuses is getSignatureStatuses, one entry per signature and nil
+// for one the cluster doesn't know (yet)
+func (c *SolanaRPC) SignatureStatuses(ctx context.Context, sigs []string) ([]*SignatureStatus, error) {
+	var statuses struct {
+		Value []*SignatureStatus `json:"value"`
+	}
+	if err := c.call(ctx, "getSignatureStatuses", []any{sigs}, &statuses); err != nil {
+		return nil, err
+	}
+	if len(statuses.Value) != len(sigs) {
+		return nil, fmt.Errorf("getSignatureStatuses returned %d statuses for %d signatures", len(statuses.Value), len(sigs))
+	}
+	return statuses.Value, nil
+}
+
+// RecentPrioritizationFees is getRecentPrioritizationFees, the lowest
+// compute unit price (micro-lamports) that landed a transaction locking all
+// of accounts, per recent slot
+func (c *SolanaRPC) RecentPrioritizationFees(ctx context.Context, accounts []string) ([]uint64, error) {
+	var slots []struct {
+		Slot              uint64 `json:"slot"`
+		PrioritizationFee uint64 `json:"prioritizationFee"`
+	}
+	if err := c.call(ctx, "getRecentPrioritizationFees", []any{accounts}, &slots); err != nil {
+		return nil, err
+	}
+	fees := make([]uint64, len(slots))
+	for i, s := range slots {
+		fees[i] = s.PrioritizationFee
+	}
+	return fees, nil
+}
+
+// Balance is getBalance, the address's lamports at confirmed commitment
+func (c *SolanaRPC) Balance(ctx context.Context, address string) (uint64, error) {
+	var balance struct {
+		Value uint64 `json:"value"`
+	}
+	err := c.call(ctx, "getBalance", []any{address, map[string]any{"commitment": "confirmed"}}, &balance)
+	return balance.Value, err
+}
+
+// TokenBalance is one token account an owner holds. Amounts are strings,
+// a u64 doesn't fit a JSON number.
+type TokenBalance struct {
+	Account  string `json:"account"`
+	Mint     string `json:"mint"`
+	Program  string `json:"program"`
+	Amount   string `json:"amount"`
+	Decimals int    `json:"decimals"`
+	UIAmount string `json:"uiAmount"`
+}
+
+// TokenBalances is getTokenAccountsByOwner for both token programs
+func (c *SolanaRPC) TokenBalances(ctx context.Context, owner string) ([]TokenBalance, error) {
+	balances := []TokenBalance{}
+	for _, program := range []SolanaPubkey{TokenProgramID, Token2022ProgramID} {
+		var accounts struct {
+			Value []struct {
+				Pubkey  string `json:"pubkey"`
+				Account struct {
+					Data struct {
+						Parsed struct {
+							Info struct {
+								Mint        string `json:"mint"`
+								TokenAmount struct {
+									Amount         string `json:"amount"`
+									Decimals       int    `json:"decimals"`
+									UIAmountString string `json:"uiAmountString"`
+								} `json:"tokenAmount"`
+							} `json:"info"`
+						} `json:"parsed"`
+					} `json:"data"`
+				} `json:"account"`
+			} `json:"value"`
+		}
+		err := c.call(ctx, "getTokenAccountsByOwner", []any{
+			owner,
+			map[string]any{"programId": program.String()},
+			map[string]any{"encoding": "jsonParsed", "commitment": "confirmed"},
+		}, &accounts)
+		if err != nil {
+			return nil, err
+		}
+		for _, a := range accounts.Value {
+			info := a.Account.Data.Parsed.Info
+			balances = append(balances, TokenBalance{
+				Account:  a.Pubkey,
+				Mint:     info.Mint,
+				Program:  program.String(),
+				Amount:   info.TokenAmount.Amount,
+				Decimals: info.TokenAmount.Decimals,
+				UIAmount: info.TokenAmount.UIAmountString,
+			})
+		}
+	}
+	return balances, nil
+}
+
+// GenesisHash is getGenesisHash, it tells the clusters apart
+func (c *SolanaRPC) GenesisHash(ctx context.Context) (string, error) {
+	var hash string
+	err := c.call(ctx, "getGenesisHash", nil, &hash)
+	return hash, err
+}
+
+// RequestAirdrop is requestAirdrop, the faucet's transaction signature
+func (c *SolanaRPC) RequestAirdrop(ctx context.Context, address string, lamports uint64) (string, error) {
+	var sig string
+	err := c.call(ctx, "requestAirdrop", []any{address, lamports, map[string]any{"commitment": "confirmed"}}, &sig)
+	return sig, err
+}
+
+// TokenMint is a mint account's token program and decimals
+type TokenMint struct {
+	Program  SolanaPubkey
+	Decimals uint8
+}
+
+// TokenMint is getAccountInfo on a mint, errNotAMint when the account
+// doesn't exist or isn't one
+func (c *SolanaRPC) TokenMint(ctx context.Context, mint string) (TokenMint, error) {
+	var account struct {
+		Value *struct {
+			Owner string `json:"owner"`
+			Data  struct {
+				Parsed struct {
+					Type string `json:"type"`
+					Info struct {
+						Decimals uint8 `json:"decimals"`
+					} `json:"info"`
+				} `json:"parsed"`
+			} `json:"data"`
+		} `json:"value"`
+	}
+	err := c.call(ctx, "getAccountInfo", []any{mint, map[string]any{"encoding": "jsonParsed", "commitment": "confirmed"}}, &account)
+	if err != nil {
+		return TokenMint{}, err
+	}
+	if account.Value == nil || account.Value.Data.Parsed.Type != "mint" {
+		return TokenMint{}, fmt.Errorf("%w: %s", errNotAMint, mint)
+	}
+	program, err := ParseSolanaPubkey(account.Value.Owner)
+	if err != nil || !isTokenProgram(program) {
+		return TokenMint{}, fmt.Errorf("%w: %s", errNotAMint, mint)
+	}
+	return TokenMint{Program: program, Decimals: account.Value.Data.Parsed.Info.Decimals}, nil
+}
+
+// NonceAccount is getAccountInfo on a durable nonce account, errNotANonce
+// when the account doesn't exist or isn't an initialized nonce
+func (c *SolanaRPC) NonceAccount(ctx context.Context, address string) (NonceAccount, error) {
+	var account struct {
+		Value *struct {
+			Lamports uint64 `json:"lamports"`
+			Data     struct {
+				Program string `json:"program"`
+				Parsed  struct {
+					Type string `json:"type"`
+					Info struct {
+						Authority     string `json:"authority"`
+						Blockhash     string `json:"blockhash"`
+						FeeCalculator struct {
+							LamportsPerSignature string `json:"lamportsPerSignature"`
+						} `json:"feeCalculator"`
+					} `json:"info"`
+				} `json:"parsed"`
+			} `json:"data"`
+		} `json:"value"`
+	}
+	err := c.call(ctx, "getAccountInfo", []any{address, map[string]any{"encoding": "jsonParsed", "commitment": "confirmed"}}, &account)
+	if err != nil {
+		return NonceAccount{}, err
+	}
+	if account.Value == nil || account.Value.Data.Program != "nonce" || account.Value.Data.Parsed.Type != "initialized" {
+		return NonceAccount{}, fmt.Errorf("%w: %s", errNotANonce, address)
+	}
+	info := account.Value.Data.Parsed.Info
+	fee, _ := strconv.ParseUint(info.FeeCalculator.LamportsPerSignature, 10, 64)
+	return NonceAccount{
+		Address:              address,
+		Authority:            info.Authority,
+		Nonce:                info.Blockhash,
+		Lamports:             account.Value.Lamports,
+		LamportsPerSignature: fee,
+	}, nil
+}
+
+// signatureSlot polls the cluster until the transaction lands in a slot or
+// ctx is done, zero means the slot isn't known yet
+func signatureSlot(ctx context.Context, chain ChainClient, sig string) (uint64, error) {
+	ticker := time.NewTicker(250 * time.Millisecond)
+	defer ticker.Stop()
+
+	for {
+		statuses, err := chain.SignatureStatuses(ctx, []string{sig})
+		if err != nil {
+			return 0, err
+		}
+		if statuses[0] != nil {
+			return statuses[0].Slot, nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return 0, nil
+		case <-ticker.C:
+		}
+	}
+}
+
+// SimulationError is returned when the cluster says a transaction would fail
+type SimulationError struct {
+	Err  json.RawMessage
+	Logs []string
+}
+
+func (e *SimulationError) Error() string {
+	return fmt.Sprintf("transaction simulation failed: %s, logs: %s", e.Err, strings.Join(e.Logs, "; "))
+}
+
+type SimulationResult struct {
+	Logs          []string `json:"logs"`
+	UnitsConsumed uint64   `json:"unitsConsumed"`
+}
+
+// SimulateTransaction runs the transaction w/o signature checks, a failing
+// transaction comes back as a *SimulationError
+func (c *SolanaRPC) SimulateTransaction(ctx context.Context, tx []byte) (SimulationResult, error) {
+	var sim struct {
+		Value struct {
+			Err           json.RawMessage `json:"err"`
+			Logs          []string        `json:"logs"`
+			UnitsConsumed uint64          `json:"unitsConsumed"`
+		} `json:"value"`
+	}
+	err := c.call(ctx, "simulateTransaction", []any{
+		base64.StdEncoding.EncodeToString(tx),
+		map[string]any{"encoding": "base64", "sigVerify": false, "commitment": "processed"},
+	}, &sim)
+	if err != nil {
+		return SimulationResult{}, err
+	}
+
+	if len(sim.Value.Err) > 0 && string(sim.Value.Err) != "null" {
+		return SimulationResult{}, &SimulationError{Err: sim.Value.Err, Logs: sim.Value.Logs}
+	}
+	return SimulationResult{Logs: sim.Value.Logs, UnitsConsumed: sim.Value.UnitsConsumed}, nil
+}
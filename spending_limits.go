@@ -0,0 +1,264 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// asset name for native lamports in spending limits
+const assetLamports = "SOL"
+
+// longest window a limit may use, older spend events are dropped
+const maxSpendWindow = 7 * 24 * time.Hour
+
+// what happens to a request that would cross a limit
+const (
+	OverLimitReject  = "reject"
+	OverLimitApprove = "approve"
+)
+
+var errSpendingLimit = errors.New("spending limit exceeded")
+
+// SpendLimitError names the limit a request would cross
+type SpendLimitError struct {
+	Limit SpendingLimit
+	Total uint64
+}
+
+func (e *SpendLimitError) Error() string {
+	return fmt.Sprintf("%s: %s would reach %d of %d in %s", errSpendingLimit, e.Limit.asset(), e.Total, e.Limit.Max, e.Limit.Window)
+}
+
+func (e *SpendLimitError) Unwrap() error { return errSpendingLimit }
+
+type SpendingLimit struct {
+	// base58 mint, empty limits lamports
+	Mint string `json:"mint,omitempty"`
+
+	// sliding window as a Go duration, e.g. 1h or 24h
+	Window string `json:"window"`
+
+	// max lamports or base token units within the window
+	Max uint64 `json:"max"`
+
+	// reject (default) or approve, which sends the request to a second operator
+	OverLimit string `json:"overLimit,omitempty"`
+}
+
+func (l SpendingLimit) asset() string {
+	if l.Mint == "" {
+		return assetLamports
+	}
+	return l.Mint
+}
+
+func (l SpendingLimit) Validate() error {
+	window, err := time.ParseDuration(l.Window)
+	if err != nil || window <= 0 || window > maxSpendWindow {
+		return fmt.Errorf("spending limit window must be a duration up to %s", maxSpendWindow)
+	}
+	if l.OverLimit != "" && l.OverLimit != OverLimitReject && l.OverLimit != OverLimitApprove {
+		return fmt.Errorf("unknown spending limit overLimit action: %q", l.OverLimit)
+	}
+	if l.Mint != "" {
+		if _, err := ParseSolanaPubkey(l.Mint); err != nil {
+			return fmt.Errorf("invalid spending limit mint: %w", err)
+		}
+	}
+	return nil
+}
+
+type spendEvent struct {
+	ID     uint64    `json:"id"`
+	At     time.Time `json:"at"`
+	Asset  string    `json:"asset"`
+	Amount uint64    `json:"amount"`
+}
+
+// SpendTracker keeps per-key spend events for sliding window limits,
+// persisted to disk so a restart doesn't reset the counters
+type SpendTracker struct {
+	// empty keeps counters in memory only
+	path string
+
+	events map[string][]spendEvent
+	nextID uint64
+
+	mu sync.Mutex
+}
+
+// constructor, loads existing counters from path if present
+func NewSpendTracker(path string) (*SpendTracker, error) {
+	t := &SpendTracker{
+		path:   path,
+		events: make(map[string][]spendEvent),
+	}
+	if path == "" {
+		return t, nil
+	}
+
+	raw, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return t, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read spend counters: %w", err)
+	}
+	if err := json.Unmarshal(raw, &t.events); err != nil {
+		return nil, fmt.Errorf("failed to parse spend counters: %w", err)
+	}
+	for _, events := range t.events {
+		for _, e := range events {
+			t.nextID = max(t.nextID, e.ID+1)
+		}
+	}
+	return t, nil
+}
+
+// SpendsFor totals what a transaction moves out under the key's authority,
+// per asset. With needMints token transfers must name their mint.
+func SpendsFor(msg *SolanaMessage, key SolanaPubkey, needMints bool) (map[string]uint64, error) {
+	transfers, err := DecodeSolanaTransfers(msg)
+	if err != nil {
+		return nil, err
+	}
+
+	spends := make(map[string]uint64)
+	for _, t := range transfers {
+		if t.Authority != key {
+			continue
+		}
+
+		switch t.Kind {
+		case TransferAuthority:
+			// gives away whatever the account holds, no limit can bound that
+			return nil, fmt.Errorf("instruction %d hands account %s to %s, its value can't be counted against spend rules", t.Instruction, t.Source, t.Destination)
+		case TransferSystem:
+			spends[assetLamports] += t.Amount
+		case TransferToken, TransferApproval:
+			if t.Mint == (SolanaPubkey{}) {
+				if needMints {
+					return nil, fmt.Errorf("instruction %d doesn't name its mint, use the checked variant", t.Instruction)
+				}
+				continue
+			}
+			spends[t.Mint.String()] += t.Amount
+		}
+	}
+	return spends, nil
+}
+
+// Reserve checks every limit and records the spend atomically. The returned
+// release func undoes the reservation if signing fails afterwards. Approved
+// requests still count but pass limits that route to approval.
+func (t *SpendTracker) Reserve(keyID string, limits []SpendingLimit, spends map[string]uint64, approved bool) (func(), error) {
+	t.mu.Lock()
+
+	defer t.mu.Unlock()
+
+	now := time.Now()
+	t.prune(keyID, now)
+
+	for _, l := range limits {
+		amount := spends[l.asset()]
+		if amount == 0 || (approved && l.OverLimit == OverLimitApprove) {
+			continue
+		}
+
+		window, _ := time.ParseDuration(l.Window)
+		var spent uint64
+		for _, e := range t.events[keyID] {
+			if e.Asset == l.asset() && now.Sub(e.At) <= window {
+				spent += e.Amount
+			}
+		}
+		if spent+amount > l.Max {
+			return nil, &SpendLimitError{Limit: l, Total: spent + amount}
+		}
+	}
+
+	var ids []uint64
+	for asset, amount := range spends {
+		if amount == 0 {
+			continue
+		}
+		ids = append(ids, t.nextID)
+		t.events[keyID] = append(t.events[keyID], spendEvent{ID: t.nextID, At: now, Asset: asset, Amount: amount})
+		t.nextID++
+	}
+	t.save()
+
+	release := func() {
+		t.mu.Lock()
+
+		defer t.mu.Unlock()
+
+		kept := t.events[keyID][:0]
+		for _, e := range t.events[keyID] {
+			drop := false
+			for _, id := range ids {
+				drop = drop || e.ID == id
+			}
+			if !drop {
+				kept = append(kept, e)
+			}
+		}
+		t.events[keyID] = kept
+		t.save()
+	}
+	return release, nil
+}
+
+// prune drops events older than the longest window, must be called w/ mu held
+func (t *SpendTracker) prune(keyID string, now time.Time) {
+	kept := t.events[keyID][:0]
+	for _, e := range t.events[keyID] {
+		if now.Sub(e.At) <= maxSpendWindow {
+			kept = append(kept, e)
+		}
+	}
+	if len(kept) == 0 {
+		delete(t.events, keyID)
+		return
+	}
+	t.events[keyID] = kept
+}
+
+// save writes the counters atomically, must be called w/ mu held
+func (t *SpendTracker) save() {
+	if t.path == "" {
+		return
+	}
+
+	raw, err := json.Marshal(t.events)
+	if err != nil {
+		slog.Error("Failed to encode spend counters", logSigner, "err", err)
+		return
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(t.path), ".spend-*")
+	if err != nil {
+		slog.Error("Failed to persist spend counters", logSigner, "err", err)
+		return
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(raw); err != nil {
+		tmp.Close()
+		slog.Error("Failed to persist spend counters", logSigner, "err", err)
+		return
+	}
+	if err := tmp.Close(); err != nil {
+		slog.Error("Failed to persist spend counters", logSigner, "err", err)
+		return
+	}
+	if err := os.Rename(tmp.Name(), t.path); err != nil {
+		slog.Error("Failed to persist spend counters", logSigner, "err", err)
+	}
+}
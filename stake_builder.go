@@ -0,0 +1,380 @@
+package main
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+)
+
+var (
+	SysvarStakeHistoryID = MustSolanaPubkey("SysvarStakeHistory1111111111111111111111111")
+	StakeConfigID        = MustSolanaPubkey("StakeConfig11111111111111111111111111111111")
+)
+
+// stake program instruction tags
+const (
+	stakeInitialize               = 0
+	stakeAuthorize                = 1
+	stakeDelegate                 = 2
+	stakeWithdraw                 = 4
+	stakeDeactivate               = 5
+	stakeAuthorizeWithSeed        = 8
+	stakeAuthorizeChecked         = 10
+	stakeAuthorizeCheckedWithSeed = 11
+)
+
+// size of a stake account, its rent exempt minimum stays in it while active
+const stakeAccountSpace = 200
+
+var errStakingNotAllowed = fmt.Errorf("%w: key's policy doesn't allow staking", errPolicyViolation)
+
+// StakingPolicy lets the stake builders run for a key, which is the staker
+// and withdrawer of every stake account they create
+type StakingPolicy struct {
+	// vote accounts the key may delegate to, empty allows any. Checked on
+	// every transaction the key signs, not only built ones.
+	AllowedValidators []string `json:"allowedValidators,omitempty"`
+}
+
+func (p StakingPolicy) Validate() error {
+	for _, validator := range p.AllowedValidators {
+		if _, err := ParseSolanaPubkey(validator); err != nil {
+			return fmt.Errorf("invalid allowed validator: %w", err)
+		}
+	}
+	return nil
+}
+
+// limitsValidators is nil safe, w/o a policy any delegation is signed
+func (p *StakingPolicy) limitsValidators() bool {
+	return p != nil && len(p.AllowedValidators) > 0
+}
+
+// checkDelegations refuses delegations to vote accounts outside
+// AllowedValidators, nil safe
+func (p *StakingPolicy) checkDelegations(msg *SolanaMessage) error {
+	if !p.limitsValidators() {
+		return nil
+	}
+	allowed, err := pubkeySet(p.AllowedValidators)
+	if err != nil {
+		return err
+	}
+
+	for i, ix := range msg.Instructions {
+		program := msg.AccountKeys[ix.ProgramIDIndex]
+		if program != StakeProgramID || len(ix.Data) < 4 || binary.LittleEndian.Uint32(ix.Data) != stakeDelegate {
+			continue
+		}
+		// DelegateStake: stake, vote, clock, stake history, config, staker
+		if len(ix.Accounts) < 2 || ix.Accounts[1] >= len(msg.AccountKeys) {
+			return fmt.Errorf("%w: instruction %d: %v", errPolicyViolation, i, errUnresolvableAccount)
+		}
+		if vote := msg.AccountKeys[ix.Accounts[1]]; !allowed[vote] {
+			return fmt.Errorf("%w: instruction %d delegates to %s which is not an allowed validator", errPolicyViolation, i, vote)
+		}
+	}
+	return nil
+}
+
+// StakeCreateRequest funds a new stake account, derived from the key and
+// Seed, and optionally delegates it in the same transaction
+type StakeCreateRequest struct {
+	// names the stake account, up to 32 bytes. The same seed always
+	// derives the same account.
+	Seed string `json:"seed"`
+
+	// staked on top of the account's rent exempt minimum
+	Lamports uint64 `json:"lamports"`
+
+	// vote account to delegate to, empty leaves the stake undelegated
+	Validator string `json:"validator,omitempty"`
+
+	BuildOptions
+}
+
+// StakeCreateResult is the signed transaction and the account it creates
+type StakeCreateResult struct {
+	StakeAccount string `json:"stakeAccount"`
+	TransactionResult
+}
+
+type StakeDelegateRequest struct {
+	// vote account to delegate to
+	Validator string `json:"validator"`
+
+	BuildOptions
+}
+
+type StakeWithdrawRequest struct {
+	Lamports uint64 `json:"lamports"`
+
+	// defaults to the key's own address
+	Destination string `json:"destination,omitempty"`
+
+	BuildOptions
+}
+
+// staker is the key's address once its policy allows staking
+func (b *TxBuilder) staker(keyID string) (SolanaPubkey, error) {
+	info, err := b.signer.store.Info(keyID)
+	if err != nil {
+		return SolanaPubkey{}, err
+	}
+	if info.Policy.Staking == nil {
+		return SolanaPubkey{}, errStakingNotAllowed
+	}
+	return keyAddress(b.signer.store, keyID)
+}
+
+// StakeCreate builds the stake account's creation w/ the key as staker and
+// withdrawer, then its delegation when a validator is given. It returns the
+// stake account's address.
+func (b *TxBuilder) StakeCreate(keyID string, req StakeCreateRequest) (TransactionRequest, SolanaPubkey, error) {
+	staker, err := b.staker(keyID)
+	if err != nil {
+		return TransactionRequest{}, SolanaPubkey{}, err
+	}
+	if req.Seed == "" {
+		return TransactionRequest{}, SolanaPubkey{}, errors.New("seed is required")
+	}
+	if req.Lamports == 0 {
+		return TransactionRequest{}, SolanaPubkey{}, errors.New("lamports must be greater than zero")
+	}
+	stake, err := CreateWithSeed(staker, req.Seed, StakeProgramID)
+	if err != nil {
+		return TransactionRequest{}, SolanaPubkey{}, err
+	}
+
+	lamports := req.Lamports + solanaRentExemptMinimum(stakeAccountSpace)
+	ixs := []SolanaInstruction{
+		SystemCreateAccountWithSeedIx(staker, stake, staker, req.Seed, lamports, stakeAccountSpace, StakeProgramID),
+		StakeInitializeIx(stake, staker, staker),
+	}
+	if req.Validator != "" {
+		vote, err := ParseSolanaPubkey(req.Validator)
+		if err != nil {
+			return TransactionRequest{}, SolanaPubkey{}, fmt.Errorf("validator: %w", err)
+		}
+		ixs = append(ixs, StakeDelegateIx(stake, vote, staker))
+	}
+	signReq, err := b.request(keyID, staker, ixs, req.BuildOptions)
+	return signReq, stake, err
+}
+
+// StakeDelegate builds the delegation of a stake account the key stakes
+func (b *TxBuilder) StakeDelegate(keyID, stakeAccount string, req StakeDelegateRequest) (TransactionRequest, error) {
+	staker, stake, err := b.stakeAccount(keyID, stakeAccount)
+	if err != nil {
+		return TransactionRequest{}, err
+	}
+	vote, err := ParseSolanaPubkey(req.Validator)
+	if err != nil {
+		return TransactionRequest{}, fmt.Errorf("validator: %w", err)
+	}
+	return b.request(keyID, staker, []SolanaInstruction{StakeDelegateIx(stake, vote, staker)}, req.BuildOptions)
+}
+
+// StakeDeactivate builds the deactivation of a stake account, its lamports
+// can be withdrawn once the cluster finishes cooling it down
+func (b *TxBuilder) StakeDeactivate(keyID, stakeAccount string, opts BuildOptions) (TransactionRequest, error) {
+	staker, stake, err := b.stakeAccount(keyID, stakeAccount)
+	if err != nil {
+		return TransactionRequest{}, err
+	}
+	return b.request(keyID, staker, []SolanaInstruction{StakeDeactivateIx(stake, staker)}, opts)
+}
+
+// StakeWithdraw builds a withdrawal from a stake account. It counts against
+// the key's destination allowlist and spending limits like any transfer.
+func (b *TxBuilder) StakeWithdraw(keyID, stakeAccount string, req StakeWithdrawRequest) (TransactionRequest, error) {
+	staker, stake, err := b.stakeAccount(keyID, stakeAccount)
+	if err != nil {
+		return TransactionRequest{}, err
+	}
+	if req.Lamports == 0 {
+		return TransactionRequest{}, errors.New("lamports must be greater than zero")
+	}
+	destination := staker
+	if req.Destination != "" {
+		if destination, err = ParseSolanaPubkey(req.Destination); err != nil {
+			return TransactionRequest{}, fmt.Errorf("destination: %w", err)
+		}
+	}
+	ix := StakeWithdrawIx(stake, destination, staker, req.Lamports)
+	return b.request(keyID, staker, []SolanaInstruction{ix}, req.BuildOptions)
+}
+
+func (b *TxBuilder) stakeAccount(keyID, stakeAccount string) (SolanaPubkey, SolanaPubkey, error) {
+	staker, err := b.staker(keyID)
+	if err != nil {
+		return SolanaPubkey{}, SolanaPubkey{}, err
+	}
+	stake, err := ParseSolanaPubkey(stakeAccount)
+	if err != nil {
+		return SolanaPubkey{}, SolanaPubkey{}, fmt.Errorf("stake account: %w", err)
+	}
+	return staker, stake, nil
+}
+
+// StakeInitializeIx sets the stake account's authorities w/o a lockup
+func StakeInitializeIx(stake, staker, withdrawer SolanaPubkey) SolanaInstruction {
+	data := binary.LittleEndian.AppendUint32(nil, stakeInitialize)
+	data = append(data, staker[:]...)
+	data = append(data, withdrawer[:]...)
+	// lockup: unix timestamp, epoch and custodian, all zero
+	data = append(data, make([]byte, 8+8+32)...)
+
+	return SolanaInstruction{
+		ProgramID: StakeProgramID,
+		Accounts: []SolanaAccountMeta{
+			{Pubkey: stake, IsWritable: true},
+			{Pubkey: SysvarRentID},
+		},
+		Data: data,
+	}
+}
+
+func StakeDelegateIx(stake, vote, staker SolanaPubkey) SolanaInstruction {
+	return SolanaInstruction{
+		ProgramID: StakeProgramID,
+		Accounts: []SolanaAccountMeta{
+			{Pubkey: stake, IsWritable: true},
+			{Pubkey: vote},
+			{Pubkey: SysvarClockID},
+			{Pubkey: SysvarStakeHistoryID},
+			{Pubkey: StakeConfigID},
+			{Pubkey: staker, IsSigner: true},
+		},
+		Data: binary.LittleEndian.AppendUint32(nil, stakeDelegate),
+	}
+}
+
+func StakeDeactivateIx(stake, staker SolanaPubkey) SolanaInstruction {
+	return SolanaInstruction{
+		ProgramID: StakeProgramID,
+		Accounts: []SolanaAccountMeta{
+			{Pubkey: stake, IsWritable: true},
+			{Pubkey: SysvarClockID},
+			{Pubkey: staker, IsSigner: true},
+		},
+		Data: binary.LittleEndian.AppendUint32(nil, stakeDeactivate),
+	}
+}
+
+func StakeWithdrawIx(stake, destination, withdrawer SolanaPubkey, lamports uint64) SolanaInstruction {
+	data := binary.LittleEndian.AppendUint32(nil, stakeWithdraw)
+	data = binary.LittleEndian.AppendUint64(data, lamports)
+
+	return SolanaInstruction{
+		ProgramID: StakeProgramID,
+		Accounts: []SolanaAccountMeta{
+			{Pubkey: stake, IsWritable: true},
+			{Pubkey: destination, IsWritable: true},
+			{Pubkey: SysvarClockID},
+			{Pubkey: SysvarStakeHistoryID},
+			{Pubkey: withdrawer, IsSigner: true},
+		},
+		Data: data,
+	}
+}
+
+// stakeErrorStatus is builtErrorStatus w/ keys whose policy doesn't allow
+// staking forbidden
+func stakeErrorStatus(err error) int {
+	if errors.Is(err, errStakingNotAllowed) {
+		return http.StatusForbidden
+	}
+	return builtErrorStatus(err)
+}
+
+// handleStakeCreate builds, signs and optionally broadcasts a new stake
+// account for the key, delegated when a validator is given
+func (s *APIServer) handleStakeCreate(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+	var req StakeCreateRequest
+	if err := decodeJSON(r.Body, &req); err != nil {
+		writeError(w, r, http.StatusBadRequest, invalidBody(err))
+		return
+	}
+	buildOptions(r, &req.BuildOptions)
+	if err := s.checkAPIToken(r, TokenOpSign, id); err != nil {
+		refuseAPIToken(w, r, err)
+		return
+	}
+
+	signReq, stake, err := s.Builder.StakeCreate(id, req)
+	if err != nil {
+		writeError(w, r, stakeErrorStatus(err), err)
+		return
+	}
+	s.signAndRespond(w, r, signReq, func(res TransactionResult) any {
+		return StakeCreateResult{StakeAccount: stake.String(), TransactionResult: res}
+	})
+}
+
+func (s *APIServer) handleStakeDelegate(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+	var req StakeDelegateRequest
+	if err := decodeJSON(r.Body, &req); err != nil {
+		writeError(w, r, http.StatusBadRequest, invalidBody(err))
+		return
+	}
+	buildOptions(r, &req.BuildOptions)
+	if err := s.checkAPIToken(r, TokenOpSign, id); err != nil {
+		refuseAPIToken(w, r, err)
+		return
+	}
+
+	signReq, err := s.Builder.StakeDelegate(id, r.PathValue("stake"), req)
+	if err != nil {
+		writeError(w, r, stakeErrorStatus(err), err)
+		return
+	}
+	s.signAndRespond(w, r, signReq, nil)
+}
+
+// handleStakeDeactivate takes only build options, the body may be empty
+func (s *APIServer) handleStakeDeactivate(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+	var opts BuildOptions
+	if err := decodeJSON(r.Body, &opts); err != nil && !errors.Is(err, io.EOF) {
+		writeError(w, r, http.StatusBadRequest, invalidBody(err))
+		return
+	}
+	buildOptions(r, &opts)
+	if err := s.checkAPIToken(r, TokenOpSign, id); err != nil {
+		refuseAPIToken(w, r, err)
+		return
+	}
+
+	signReq, err := s.Builder.StakeDeactivate(id, r.PathValue("stake"), opts)
+	if err != nil {
+		writeError(w, r, stakeErrorStatus(err), err)
+		return
+	}
+	s.signAndRespond(w, r, signReq, nil)
+}
+
+func (s *APIServer) handleStakeWithdraw(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+	var req StakeWithdrawRequest
+	if err := decodeJSON(r.Body, &req); err != nil {
+		writeError(w, r, http.StatusBadRequest, invalidBody(err))
+		return
+	}
+	buildOptions(r, &req.BuildOptions)
+	if err := s.checkAPIToken(r, TokenOpSign, id); err != nil {
+		refuseAPIToken(w, r, err)
+		return
+	}
+
+	signReq, err := s.Builder.StakeWithdraw(id, r.PathValue("stake"), req)
+	if err != nil {
+		writeError(w, r, stakeErrorStatus(err), err)
+		return
+	}
+	s.signAndRespond(w, r, signReq, nil)
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"sort"
+	"sync"
+	"time"
+)
+
+// BalanceChecker looks up the on-chain balance held by a key
+type BalanceChecker interface {
+	Balance(ctx context.Context, keyID string) (uint64, error)
+}
+
+type RetirementRecommendation struct {
+	KeyID      string    `json:"keyId"`
+	IdleDays   int       `json:"idleDays"`
+	LastUsedAt time.Time `json:"lastUsedAt"`
+
+	// nil when no balance source is configured
+	BalanceLamports *uint64 `json:"balanceLamports,omitempty"`
+
+	// ordered steps to retire the key, e.g. sweep then disable
+	Actions []string `json:"actions"`
+}
+
+type StaleKeyReport struct {
+	GeneratedAt     time.Time                  `json:"generatedAt"`
+	IdleAfterDays   int                        `json:"idleAfterDays"`
+	Recommendations []RetirementRecommendation `json:"recommendations"`
+}
+
+// StaleKeyAnalyzer periodically flags keys that have sat unused while still
+// holding funds, and recommends sweeping and disabling them
+type StaleKeyAnalyzer struct {
+	store    *SecureKeyStore
+	balances BalanceChecker
+
+	idleAfter  time.Duration
+	minBalance uint64
+
+	report StaleKeyReport
+
+	mu sync.RWMutex
+}
+
+// constructor, balances may be nil until a chain client is configured
+func NewStaleKeyAnalyzer(store *SecureKeyStore, balances BalanceChecker, idleAfter time.Duration, minBalance uint64) *StaleKeyAnalyzer {
+	return &StaleKeyAnalyzer{
+		store:      store,
+		balances:   balances,
+		idleAfter:  idleAfter,
+		minBalance: minBalance,
+		report:     StaleKeyReport{Recommendations: []RetirementRecommendation{}},
+	}
+}
+
+func (a *StaleKeyAnalyzer) Analyze(ctx context.Context) StaleKeyReport {
+	now := time.Now()
+
+	recs := []RetirementRecommendation{}
+	for _, usage := range a.store.Usage() {
+		lastActive := usage.LastUsedAt
+		if lastActive.IsZero() {
+			lastActive = usage.CreatedAt
+		}
+		idle := now.Sub(lastActive)
+		if idle < a.idleAfter {
+			continue
+		}
+
+		rec := RetirementRecommendation{
+			KeyID:      usage.KeyID,
+			IdleDays:   int(idle.Hours() / 24),
+			LastUsedAt: usage.LastUsedAt,
+			Actions:    []string{"sweep", "disable"},
+		}
+
+		if a.balances != nil {
+			balance, err := a.balances.Balance(ctx, usage.KeyID)
+			if err != nil {
+				slog.WarnContext(ctx, "Stale key analyzer: balance lookup failed", logKeystore, "key_id", usage.KeyID, "err", err)
+				continue
+			}
+
+			// dust isn't worth a retirement workflow
+			if balance < a.minBalance {
+				continue
+			}
+			rec.BalanceLamports = &balance
+		}
+
+		recs = append(recs, rec)
+	}
+	sort.Slice(recs, func(i, j int) bool { return recs[i].IdleDays > recs[j].IdleDays })
+
+	report := StaleKeyReport{
+		GeneratedAt:     now,
+		IdleAfterDays:   int(a.idleAfter.Hours() / 24),
+		Recommendations: recs,
+	}
+
+	a.mu.Lock()
+	a.report = report
+	a.mu.Unlock()
+
+	slog.InfoContext(ctx, "Stale key analyzer: retirement recommendations", logKeystore, "count", len(recs))
+	return report
+}
+
+// Report returns the latest analysis
+func (a *StaleKeyAnalyzer) Report() StaleKeyReport {
+	a.mu.RLock()
+
+	defer a.mu.RUnlock()
+
+	return a.report
+}
+
+// Run analyzes on every tick until ctx is done
+func (a *StaleKeyAnalyzer) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	a.Analyze(ctx)
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			a.Analyze(ctx)
+		}
+	}
+}
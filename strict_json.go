@@ -0,0 +1,134 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// a request body that doesn't match its type is refused, a misspelled field
+// would otherwise be dropped and the request run w/ the default
+var (
+	errUnknownField = errors.New("unknown field")
+	errFieldType    = errors.New("wrong type")
+	errFieldMissing = errors.New("missing required field")
+)
+
+// FieldError names the field a request body got wrong
+type FieldError struct {
+	// dotted JSON path, e.g. policy.maxUses
+	Field string
+
+	// errUnknownField, errFieldType or errFieldMissing
+	Err error
+
+	// what was expected, only for errFieldType
+	Expected string
+}
+
+func (e *FieldError) Error() string {
+	if e.Expected != "" {
+		return fmt.Sprintf("%s: %s, expected %s", e.Field, e.Err, e.Expected)
+	}
+	return fmt.Sprintf("%s: %s", e.Field, e.Err)
+}
+
+func (e *FieldError) Unwrap() error { return e.Err }
+
+// decodeJSON decodes one JSON object into v, refusing unknown fields, values
+// of the wrong type, missing required fields and trailing data. Required
+// fields follow the OpenAPI document: no omitempty and not a pointer. An
+// empty body returns io.EOF for handlers where it's optional.
+func decodeJSON(body io.Reader, v any) error {
+	raw, err := io.ReadAll(body)
+	if err != nil {
+		return err
+	}
+	if len(bytes.TrimSpace(raw)) == 0 {
+		return io.EOF
+	}
+
+	dec := json.NewDecoder(bytes.NewReader(raw))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(v); err != nil {
+		return fieldError(err)
+	}
+	if dec.More() {
+		return errors.New("unexpected data after the JSON object")
+	}
+
+	// encoding/json matches names case insensitively, the top level names
+	// are checked exactly and required ones looked for. Nested objects only
+	// get DisallowUnknownFields.
+	var present map[string]json.RawMessage
+	if json.Unmarshal(raw, &present) != nil {
+		return nil
+	}
+	t := reflect.TypeOf(v)
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	if t.Kind() != reflect.Struct {
+		return nil
+	}
+	properties := map[string]any{}
+	var required []string
+	addFields(t, properties, &required, map[string]any{})
+	for name := range present {
+		if _, ok := properties[name]; !ok {
+			return &FieldError{Field: name, Err: errUnknownField}
+		}
+	}
+	for _, name := range required {
+		if _, ok := present[name]; !ok {
+			return &FieldError{Field: name, Err: errFieldMissing}
+		}
+	}
+	return nil
+}
+
+// fieldError turns encoding/json's errors into a FieldError where it can
+func fieldError(err error) error {
+	var typeErr *json.UnmarshalTypeError
+	if errors.As(err, &typeErr) {
+		field := typeErr.Field
+		if field == "" {
+			field = "(body)"
+		}
+		return &FieldError{Field: field, Err: errFieldType, Expected: jsonTypeName(typeErr.Type)}
+	}
+	// encoding/json has no type for it, only the message
+	if name, ok := strings.CutPrefix(err.Error(), "json: unknown field "); ok {
+		if unquoted, err := strconv.Unquote(name); err == nil {
+			name = unquoted
+		}
+		return &FieldError{Field: name, Err: errUnknownField}
+	}
+	return err
+}
+
+func jsonTypeName(t reflect.Type) string {
+	switch t.Kind() {
+	case reflect.String:
+		return "string"
+	case reflect.Bool:
+		return "boolean"
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return "integer"
+	case reflect.Float32, reflect.Float64:
+		return "number"
+	case reflect.Slice, reflect.Array:
+		return "array"
+	case reflect.Map, reflect.Struct:
+		return "object"
+	case reflect.Pointer:
+		return jsonTypeName(t.Elem())
+	}
+	return t.String()
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// TLSSettings is the server's certificate and protocol policy
+type TLSSettings struct {
+	CertFile string
+	KeyFile  string
+
+	MinVersion uint16
+
+	// TLS 1.2 suites, TLS 1.3 suites aren't configurable in Go
+	CipherSuites []uint16
+
+	// CA bundle client certificates must chain to, empty disables mTLS
+	ClientCAFile string
+}
+
+// ECDHE w/ AEAD only, used when no suites are given
+var defaultCipherSuites = []uint16{
+	tls.TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384,
+	tls.TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384,
+	tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256,
+	tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256,
+	tls.TLS_ECDHE_ECDSA_WITH_CHACHA20_POLY1305_SHA256,
+	tls.TLS_ECDHE_RSA_WITH_CHACHA20_POLY1305_SHA256,
+}
+
+// TLSSettingsFromEnv reads STS_TLS_CERT, STS_TLS_KEY, STS_TLS_MIN_VERSION (1.2
+// or 1.3, default 1.2), STS_TLS_CIPHERS (comma separated Go suite names) and
+// STS_TLS_CLIENT_CA. It returns nil when no certificate is configured.
+func TLSSettingsFromEnv(getenv func(string) string) (*TLSSettings, error) {
+	cert, key := getenv("STS_TLS_CERT"), getenv("STS_TLS_KEY")
+	if cert == "" && key == "" {
+		return nil, nil
+	}
+	if cert == "" || key == "" {
+		return nil, errors.New("STS_TLS_CERT and STS_TLS_KEY must be set together")
+	}
+
+	t := &TLSSettings{
+		CertFile:     cert,
+		KeyFile:      key,
+		MinVersion:   tls.VersionTLS12,
+		CipherSuites: defaultCipherSuites,
+		ClientCAFile: getenv("STS_TLS_CLIENT_CA"),
+	}
+
+	switch v := getenv("STS_TLS_MIN_VERSION"); v {
+	case "", "1.2":
+	case "1.3":
+		t.MinVersion = tls.VersionTLS13
+	default:
+		return nil, fmt.Errorf("unsupported STS_TLS_MIN_VERSION %q, use 1.2 or 1.3", v)
+	}
+
+	if raw := getenv("STS_TLS_CIPHERS"); raw != "" {
+		suites, err := parseCipherSuites(raw)
+		if err != nil {
+			return nil, err
+		}
+		t.CipherSuites = suites
+	}
+	return t, nil
+}
+
+// parseCipherSuites only accepts suites Go considers secure
+func parseCipherSuites(raw string) ([]uint16, error) {
+	byName := make(map[string]uint16)
+	for _, suite := range tls.CipherSuites() {
+		byName[suite.Name] = suite.ID
+	}
+
+	var suites []uint16
+	for _, name := range strings.Split(raw, ",") {
+		name = strings.TrimSpace(name)
+		id, ok := byName[name]
+		if !ok {
+			return nil, fmt.Errorf("unknown or insecure cipher suite %q", name)
+		}
+		suites = append(suites, id)
+	}
+	return suites, nil
+}
+
+// Config loads the certificate and builds the server's tls.Config
+func (t *TLSSettings) Config() (*tls.Config, error) {
+	cert, err := tls.LoadX509KeyPair(t.CertFile, t.KeyFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load TLS certificate: %w", err)
+	}
+	cfg := &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		MinVersion:   t.MinVersion,
+		CipherSuites: t.CipherSuites,
+	}
+
+	// w/ a client CA every connection must present a certificate it issued
+	if t.ClientCAFile != "" {
+		bundle, err := os.ReadFile(t.ClientCAFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read client CA: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(bundle) {
+			return nil, errors.New("client CA bundle has no certificates")
+		}
+		cfg.ClientCAs = pool
+		cfg.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+	return cfg, nil
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracegrpc"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.41.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// spans for the whole service come from here, w/o a provider set up they
+// are no-ops and cost next to nothing
+var tracer = otel.Tracer("github.com/yourusername/sts-svc")
+
+// TracingConfig is where spans are exported. Like metric labels, span
+// attributes never carry key material or transaction bytes.
+type TracingConfig struct {
+	// OTLP/gRPC collector, e.g. otel-collector:4317
+	Endpoint string
+
+	// plaintext to the collector, for a sidecar on localhost
+	Insecure bool
+
+	// share of new traces kept, traces started upstream follow the caller's decision
+	SampleRatio float64
+}
+
+// TracingConfigFromEnv reads STS_OTLP_ENDPOINT, STS_OTLP_INSECURE and
+// STS_TRACE_SAMPLE_RATIO (0 to 1, default 1). It returns nil when no
+// endpoint is set, trace context is still passed along.
+func TracingConfigFromEnv(getenv func(string) string) (*TracingConfig, error) {
+	endpoint := getenv("STS_OTLP_ENDPOINT")
+	if endpoint == "" {
+		return nil, nil
+	}
+	c := &TracingConfig{Endpoint: endpoint, Insecure: getenv("STS_OTLP_INSECURE") == "true", SampleRatio: 1}
+	if raw := getenv("STS_TRACE_SAMPLE_RATIO"); raw != "" {
+		ratio, err := strconv.ParseFloat(raw, 64)
+		if err != nil || ratio < 0 || ratio > 1 {
+			return nil, fmt.Errorf("STS_TRACE_SAMPLE_RATIO must be between 0 and 1, got %q", raw)
+		}
+		c.SampleRatio = ratio
+	}
+	return c, nil
+}
+
+// init propagates W3C trace context and baggage even when spans aren't
+// exported, so a trace through this service isn't cut in two
+func init() {
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{}))
+}
+
+// StartTracing exports spans to the collector until the returned shutdown
+// is called, which flushes what's buffered
+func StartTracing(ctx context.Context, cfg TracingConfig) (func(context.Context) error, error) {
+	opts := []otlptracegrpc.Option{otlptracegrpc.WithEndpoint(cfg.Endpoint)}
+	if cfg.Insecure {
+		opts = append(opts, otlptracegrpc.WithInsecure())
+	}
+	exporter, err := otlptracegrpc.New(ctx, opts...)
+	if err != nil {
+		return nil, fmt.Errorf("OTLP exporter: %w", err)
+	}
+	res, err := resource.Merge(resource.Default(), resource.NewWithAttributes(semconv.SchemaURL,
+		semconv.ServiceName("sts-svc"),
+		semconv.ServiceVersion(version),
+	))
+	if err != nil {
+		return nil, err
+	}
+
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(res),
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(cfg.SampleRatio))),
+	)
+	otel.SetTracerProvider(provider)
+	return provider.Shutdown, nil
+}
+
+// withTracing starts a server span per request, named after the route it
+// matches so paths w/ IDs share a name, and continues the caller's trace
+func withTracing(router *http.ServeMux, next http.Handler) http.Handler {
+	return otelhttp.NewHandler(next, "http.server",
+		otelhttp.WithSpanNameFormatter(func(_ string, r *http.Request) string {
+			_, route := router.Handler(r)
+			if route == "" || route == unmatchedPattern {
+				return r.Method + " unmatched"
+			}
+			return route
+		}),
+	)
+}
+
+// tracedTransport sends trace context on outgoing requests and records a
+// client span for each
+func tracedTransport() http.RoundTripper {
+	return otelhttp.NewTransport(http.DefaultTransport)
+}
+
+// default for outgoing calls that aren't given a client
+var tracedClient = &http.Client{Transport: tracedTransport()}
+
+// storeSpan is a span around a key store call
+func storeSpan(ctx context.Context, op string) (context.Context, trace.Span) {
+	return tracer.Start(ctx, "keystore."+op, trace.WithAttributes(attribute.String("sts.keystore.backend", keyBackend)))
+}
+
+// endSpan records err on span and ends it
+func endSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, errorCode(err, 0))
+	}
+	span.End()
+}
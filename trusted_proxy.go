@@ -0,0 +1,140 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"strings"
+)
+
+// TrustedProxies are the load balancers whose X-Forwarded-For and Forwarded
+// headers are believed. Requests from any other peer are taken at face value,
+// anyone can send the headers.
+type TrustedProxies []netip.Prefix
+
+// TrustedProxiesFromEnv reads STS_TRUSTED_PROXIES, comma separated addresses
+// or CIDRs. It returns nil when none are configured.
+func TrustedProxiesFromEnv(getenv func(string) string) (TrustedProxies, error) {
+	raw := getenv("STS_TRUSTED_PROXIES")
+	if raw == "" {
+		return nil, nil
+	}
+
+	var proxies TrustedProxies
+	for _, entry := range strings.Split(raw, ",") {
+		entry = strings.TrimSpace(entry)
+		if !strings.Contains(entry, "/") {
+			addr, err := netip.ParseAddr(entry)
+			if err != nil {
+				return nil, fmt.Errorf("invalid trusted proxy %q, use an address or CIDR", entry)
+			}
+			proxies = append(proxies, netip.PrefixFrom(addr.Unmap(), addr.Unmap().BitLen()))
+			continue
+		}
+		prefix, err := netip.ParsePrefix(entry)
+		if err != nil {
+			return nil, fmt.Errorf("invalid trusted proxy %q, use an address or CIDR", entry)
+		}
+		proxies = append(proxies, prefix.Masked())
+	}
+	return proxies, nil
+}
+
+func (p TrustedProxies) trusts(ip netip.Addr) bool {
+	ip = ip.Unmap()
+	for _, prefix := range p {
+		if ip.IsValid() && prefix.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// resolve walks the forwarding chain from the nearest hop back and returns
+// the first address that isn't a trusted proxy. Hops further back than that
+// were added by the client and are ignored.
+func (p TrustedProxies) resolve(peer netip.Addr, hops []string) netip.Addr {
+	ip := peer
+	for i := len(hops) - 1; i >= 0 && p.trusts(ip); i-- {
+		hop, ok := parseForwardedAddr(hops[i])
+		if !ok {
+			// unknown or obfuscated, the last proxy is as far as we can see
+			break
+		}
+		ip = hop
+	}
+	return ip
+}
+
+// forwardedHops lists the client addresses a request passed through, oldest
+// first. Forwarded (RFC 7239) wins over X-Forwarded-For when both are set.
+func forwardedHops(h http.Header) []string {
+	var hops []string
+	if values := h.Values("Forwarded"); len(values) > 0 {
+		for _, element := range strings.Split(strings.Join(values, ","), ",") {
+			hop := ""
+			for _, pair := range strings.Split(element, ";") {
+				name, value, _ := strings.Cut(strings.TrimSpace(pair), "=")
+				if strings.EqualFold(name, "for") {
+					hop = strings.Trim(value, `"`)
+				}
+			}
+			hops = append(hops, hop)
+		}
+		return hops
+	}
+	for _, value := range h.Values("X-Forwarded-For") {
+		for _, hop := range strings.Split(value, ",") {
+			hops = append(hops, strings.TrimSpace(hop))
+		}
+	}
+	return hops
+}
+
+// parseForwardedAddr reads 192.0.2.1, 192.0.2.1:443, [2001:db8::1] or
+// [2001:db8::1]:443
+func parseForwardedAddr(hop string) (netip.Addr, bool) {
+	if addrPort, err := netip.ParseAddrPort(hop); err == nil {
+		return addrPort.Addr().Unmap(), true
+	}
+	addr, err := netip.ParseAddr(strings.TrimSuffix(strings.TrimPrefix(hop, "["), "]"))
+	if err != nil {
+		return netip.Addr{}, false
+	}
+	return addr.Unmap(), true
+}
+
+type clientIPCtxKey struct{}
+
+// withClientIP resolves the real client address once so rate limiting, the
+// access log and key network rules all see the same one
+func (s *APIServer) withClientIP(next http.Handler) http.Handler {
+	if len(s.TrustedProxies) == 0 {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ip := s.TrustedProxies.resolve(peerIP(r), forwardedHops(r.Header))
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), clientIPCtxKey{}, ip)))
+	})
+}
+
+// clientIP is the caller's address, behind a trusted proxy the one it
+// forwarded for
+func clientIP(r *http.Request) netip.Addr {
+	if ip, ok := r.Context().Value(clientIPCtxKey{}).(netip.Addr); ok {
+		return ip
+	}
+	return peerIP(r)
+}
+
+// peerIP is the address of the connection's peer
+func peerIP(r *http.Request) netip.Addr {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	ip, _ := netip.ParseAddr(host)
+	return ip
+}
@@ -0,0 +1,350 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+)
+
+// SPL token instruction tags
+const tokenTransferChecked = 12
+
+// associated token program CreateIdempotent, a no-op when the account exists
+const ataCreateIdempotent = 1
+
+var (
+	errNotAMint      = errors.New("account is not a token mint")
+	errTokenDecimals = errors.New("decimals don't match the mint")
+)
+
+// BuildOptions are the sign request fields a built transaction takes, the
+// rest are set by the builder
+type BuildOptions struct {
+	Broadcast   bool   `json:"broadcast,omitempty"`
+	Queue       bool   `json:"queue,omitempty"`
+	Simulate    bool   `json:"simulate,omitempty"`
+	PriorityFee bool   `json:"priorityFee,omitempty"`
+	FeePayer    bool   `json:"feePayer,omitempty"`
+	Cluster     string `json:"cluster,omitempty"`
+
+	// as on a sign request, the transaction webhook is told once the
+	// transaction reaches this commitment or fails
+	NotifyCommitment string `json:"notifyCommitment,omitempty"`
+
+	// sign against the key's durable nonce account rather than a recent
+	// blockhash, for transactions sent later
+	NonceAccount string `json:"nonceAccount,omitempty"`
+
+	// attached as an SPL memo signed by the key, e.g. an internal reference
+	// to reconcile the transaction by. Shown in the key's history.
+	Memo string `json:"memo,omitempty"`
+
+	// as on a sign request, also read from the Idempotency-Key and
+	// X-STS-Grant headers
+	IdempotencyKey string `json:"idempotencyKey,omitempty"`
+	Grant          string `json:"grant,omitempty"`
+	Nonce          string `json:"nonce,omitempty"`
+	Timestamp      int64  `json:"timestamp,omitempty"`
+}
+
+// TokenTransferRequest sends tokens from the key's associated token account
+// to the destination wallet's
+type TokenTransferRequest struct {
+	Mint string `json:"mint"`
+
+	// wallet address, not a token account
+	Destination string `json:"destination"`
+
+	// base units, a string since a u64 doesn't fit a JSON number
+	Amount string `json:"amount"`
+
+	// the mint's decimals, checked against the mint here and by the token
+	// program when the transaction lands
+	Decimals uint8 `json:"decimals"`
+
+	// create the destination's token account first if it has none, the
+	// key pays its rent
+	CreateDestination bool `json:"createDestination,omitempty"`
+
+	BuildOptions
+}
+
+// TokenAccountRequest creates a wallet's associated token account for the
+// mint, the key pays its rent. It succeeds when the account already exists.
+type TokenAccountRequest struct {
+	Mint string `json:"mint"`
+
+	// wallet the account is for, the key's own when empty
+	Owner string `json:"owner,omitempty"`
+
+	BuildOptions
+}
+
+// TxBuilder assembles common transactions for stored keys, so clients don't
+// compile instructions themselves. What it builds is signed like any other
+// sign request, under the key's policy.
+type TxBuilder struct {
+	signer *signerService
+}
+
+// constructor
+func NewTxBuilder(signer *signerService) *TxBuilder {
+	return &TxBuilder{signer: signer}
+}
+
+// chain is the cluster a built transaction for the key targets
+func (b *TxBuilder) chain(keyID, cluster string) (ChainClient, error) {
+	info, err := b.signer.store.Info(keyID)
+	if err != nil {
+		return nil, err
+	}
+	target, err := b.signer.clusterFor(cluster, info.Policy)
+	if err != nil {
+		return nil, err
+	}
+	if target.Chain == nil {
+		return nil, errNoRPC
+	}
+	return target.Chain, nil
+}
+
+// request compiles the instructions w/ the key paying, or the service's fee
+// payer for feePayer, and the memo last. The blockhash is patched in by the
+// signer so retries w/ an idempotency key match.
+func (b *TxBuilder) request(keyID string, payer SolanaPubkey, ixs []SolanaInstruction, opts BuildOptions) (TransactionRequest, error) {
+	if opts.Memo != "" {
+		ix, err := MemoIx(opts.Memo, payer)
+		if err != nil {
+			return TransactionRequest{}, err
+		}
+		ixs = append(ixs, ix)
+	}
+	feePayer := payer
+	if opts.FeePayer {
+		if b.signer.feePayer == "" {
+			return TransactionRequest{}, errNoFeePayer
+		}
+		var err error
+		if feePayer, err = keyAddress(b.signer.store, b.signer.feePayer); err != nil {
+			return TransactionRequest{}, fmt.Errorf("%w: %w", errNoFeePayer, err)
+		}
+	}
+	msg, _ := CompileSolanaMessage(feePayer, SolanaPubkey{}, ixs)
+	return TransactionRequest{
+		KeyID:            keyID,
+		UnsignedTxData:   base64.StdEncoding.EncodeToString(msg),
+		Context:          SolanaTxContext,
+		IdempotencyKey:   opts.IdempotencyKey,
+		Broadcast:        opts.Broadcast,
+		Queue:            opts.Queue,
+		NotifyCommitment: opts.NotifyCommitment,
+		Simulate:         opts.Simulate,
+		Cluster:          opts.Cluster,
+		RefreshBlockhash: opts.NonceAccount == "",
+		NonceAccount:     opts.NonceAccount,
+		PriorityFee:      opts.PriorityFee,
+		FeePayer:         opts.FeePayer,
+		Grant:            opts.Grant,
+		Nonce:            opts.Nonce,
+		Timestamp:        opts.Timestamp,
+	}, nil
+}
+
+// tokenMint looks the mint up on the cluster the key's transaction targets
+func (b *TxBuilder) tokenMint(ctx context.Context, keyID, cluster string, mint SolanaPubkey) (TokenMint, error) {
+	chain, err := b.chain(keyID, cluster)
+	if err != nil {
+		return TokenMint{}, err
+	}
+	info, err := chain.TokenMint(ctx, mint.String())
+	if errors.Is(err, errNotAMint) {
+		return TokenMint{}, err
+	}
+	if err != nil {
+		return TokenMint{}, fmt.Errorf("%w: %v", errRPCUnavailable, err)
+	}
+	return info, nil
+}
+
+// TokenTransfer builds a TransferChecked between the key's and the
+// destination's associated token accounts, the destination's must exist
+// unless CreateDestination is set
+func (b *TxBuilder) TokenTransfer(ctx context.Context, keyID string, req TokenTransferRequest) (TransactionRequest, error) {
+	owner, err := keyAddress(b.signer.store, keyID)
+	if err != nil {
+		return TransactionRequest{}, err
+	}
+	mint, err := ParseSolanaPubkey(req.Mint)
+	if err != nil {
+		return TransactionRequest{}, fmt.Errorf("mint: %w", err)
+	}
+	destination, err := ParseSolanaPubkey(req.Destination)
+	if err != nil {
+		return TransactionRequest{}, fmt.Errorf("destination: %w", err)
+	}
+	amount, err := strconv.ParseUint(req.Amount, 10, 64)
+	if err != nil || amount == 0 {
+		return TransactionRequest{}, errors.New("amount must be a positive integer in base units")
+	}
+
+	info, err := b.tokenMint(ctx, keyID, req.Cluster, mint)
+	if err != nil {
+		return TransactionRequest{}, err
+	}
+	if info.Decimals != req.Decimals {
+		return TransactionRequest{}, fmt.Errorf("%w: %s has %d decimals, not %d", errTokenDecimals, mint, info.Decimals, req.Decimals)
+	}
+
+	source, err := AssociatedTokenAddress(owner, mint, info.Program)
+	if err != nil {
+		return TransactionRequest{}, err
+	}
+	to, err := AssociatedTokenAddress(destination, mint, info.Program)
+	if err != nil {
+		return TransactionRequest{}, err
+	}
+	var ixs []SolanaInstruction
+	if req.CreateDestination {
+		ixs = append(ixs, CreateAssociatedTokenAccountIx(owner, to, destination, mint, info.Program))
+	}
+	ixs = append(ixs, TokenTransferCheckedIx(info.Program, source, mint, to, owner, amount, info.Decimals))
+	return b.request(keyID, owner, ixs, req.BuildOptions)
+}
+
+// TokenAccount builds the creation of the owner's associated token account
+// w/ the key paying
+func (b *TxBuilder) TokenAccount(ctx context.Context, keyID string, req TokenAccountRequest) (TransactionRequest, error) {
+	payer, err := keyAddress(b.signer.store, keyID)
+	if err != nil {
+		return TransactionRequest{}, err
+	}
+	mint, err := ParseSolanaPubkey(req.Mint)
+	if err != nil {
+		return TransactionRequest{}, fmt.Errorf("mint: %w", err)
+	}
+	owner := payer
+	if req.Owner != "" {
+		if owner, err = ParseSolanaPubkey(req.Owner); err != nil {
+			return TransactionRequest{}, fmt.Errorf("owner: %w", err)
+		}
+	}
+
+	info, err := b.tokenMint(ctx, keyID, req.Cluster, mint)
+	if err != nil {
+		return TransactionRequest{}, err
+	}
+	ata, err := AssociatedTokenAddress(owner, mint, info.Program)
+	if err != nil {
+		return TransactionRequest{}, err
+	}
+	ix := CreateAssociatedTokenAccountIx(payer, ata, owner, mint, info.Program)
+	return b.request(keyID, payer, []SolanaInstruction{ix}, req.BuildOptions)
+}
+
+func TokenTransferCheckedIx(program, source, mint, destination, owner SolanaPubkey, amount uint64, decimals uint8) SolanaInstruction {
+	data := binary.LittleEndian.AppendUint64([]byte{tokenTransferChecked}, amount)
+	data = append(data, decimals)
+
+	return SolanaInstruction{
+		ProgramID: program,
+		Accounts: []SolanaAccountMeta{
+			{Pubkey: source, IsWritable: true},
+			{Pubkey: mint},
+			{Pubkey: destination, IsWritable: true},
+			{Pubkey: owner, IsSigner: true},
+		},
+		Data: data,
+	}
+}
+
+// CreateAssociatedTokenAccountIx is the idempotent create, so a transfer
+// can always carry it
+func CreateAssociatedTokenAccountIx(payer, ata, owner, mint, tokenProgram SolanaPubkey) SolanaInstruction {
+	return SolanaInstruction{
+		ProgramID: AssociatedTokenProgramID,
+		Accounts: []SolanaAccountMeta{
+			{Pubkey: payer, IsSigner: true, IsWritable: true},
+			{Pubkey: ata, IsWritable: true},
+			{Pubkey: owner},
+			{Pubkey: mint},
+			{Pubkey: SystemProgramID},
+			{Pubkey: tokenProgram},
+		},
+		Data: []byte{ataCreateIdempotent},
+	}
+}
+
+// buildOptions reads the idempotency key and grant headers, which take
+// precedence over the body as they do on a sign request
+func buildOptions(r *http.Request, opts *BuildOptions) {
+	if key := r.Header.Get("Idempotency-Key"); key != "" {
+		opts.IdempotencyKey = key
+	}
+	if grant := r.Header.Get("X-STS-Grant"); grant != "" {
+		opts.Grant = grant
+	}
+}
+
+// builtErrorStatus is the status for a transaction that couldn't be built
+func builtErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, errKeyNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, errRPCUnavailable), errors.Is(err, errNoRPC):
+		return http.StatusBadGateway
+	default:
+		return http.StatusBadRequest
+	}
+}
+
+// handleTokenTransfer builds, signs and optionally broadcasts an SPL token
+// transfer from the key, for callers allowed to sign w/ it
+func (s *APIServer) handleTokenTransfer(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+	var req TokenTransferRequest
+	if err := decodeJSON(r.Body, &req); err != nil {
+		writeError(w, r, http.StatusBadRequest, invalidBody(err))
+		return
+	}
+	buildOptions(r, &req.BuildOptions)
+	// refused before the mint is looked up, the sign checks run again on
+	// the built request
+	if err := s.checkAPIToken(r, TokenOpSign, id); err != nil {
+		refuseAPIToken(w, r, err)
+		return
+	}
+
+	signReq, err := s.Builder.TokenTransfer(r.Context(), id, req)
+	if err != nil {
+		writeError(w, r, builtErrorStatus(err), err)
+		return
+	}
+	s.signAndRespond(w, r, signReq, nil)
+}
+
+// handleTokenAccount builds, signs and optionally broadcasts the creation
+// of an associated token account paid by the key
+func (s *APIServer) handleTokenAccount(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+	var req TokenAccountRequest
+	if err := decodeJSON(r.Body, &req); err != nil {
+		writeError(w, r, http.StatusBadRequest, invalidBody(err))
+		return
+	}
+	buildOptions(r, &req.BuildOptions)
+	if err := s.checkAPIToken(r, TokenOpSign, id); err != nil {
+		refuseAPIToken(w, r, err)
+		return
+	}
+
+	signReq, err := s.Builder.TokenAccount(r.Context(), id, req)
+	if err != nil {
+		writeError(w, r, builtErrorStatus(err), err)
+		return
+	}
+	s.signAndRespond(w, r, signReq, nil)
+}
@@ -0,0 +1,418 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"slices"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// commitment levels a transaction goes through, and how tracking can end
+// short of finalized
+const (
+	TxStatusSigned    = "signed"
+	TxStatusBroadcast = "broadcast"
+	TxStatusProcessed = "processed"
+	TxStatusConfirmed = "confirmed"
+	TxStatusFinalized = "finalized"
+	TxStatusFailed    = "failed"
+	TxStatusExpired   = "expired"
+)
+
+var txStatusRank = map[string]int{TxStatusSigned: 0, TxStatusBroadcast: 0, TxStatusProcessed: 1, TxStatusConfirmed: 2, TxStatusFinalized: 3}
+
+var (
+	errTxNotFound  = errors.New("transaction not found")
+	errNoTxWebhook = errors.New("transaction webhook not configured")
+)
+
+const (
+	// signatures per getSignatureStatuses call, the RPC's own limit
+	txStatusBatch = 256
+
+	// a transaction the cluster hasn't seen this long after signing is
+	// checked for an expired blockhash, about the 150 blocks it stays valid
+	txExpiryCheckAfter = 90 * time.Second
+
+	// records are kept for status lookups this long after their last change
+	txRecordRetention = 24 * time.Hour
+)
+
+// TrackedTx is the service's record of a transaction it signed and where
+// that transaction is. Err is the cluster's error once it failed on chain.
+type TrackedTx struct {
+	Signature     string          `json:"signature"`
+	KeyID         string          `json:"keyId"`
+	Cluster       string          `json:"cluster,omitempty"`
+	Memos         []string        `json:"memos,omitempty"`
+	Status        string          `json:"status"`
+	Slot          uint64          `json:"slot,omitempty"`
+	Confirmations *uint64         `json:"confirmations,omitempty"`
+	Err           json.RawMessage `json:"err,omitempty"`
+	SignedAt      time.Time       `json:"signedAt"`
+	BroadcastAt   *time.Time      `json:"broadcastAt,omitempty"`
+	UpdatedAt     time.Time       `json:"updatedAt"`
+
+	// the webhook is told once the transaction reaches this commitment or
+	// can't anymore
+	NotifyCommitment string     `json:"notifyCommitment,omitempty"`
+	NotifiedAt       *time.Time `json:"notifiedAt,omitempty"`
+
+	blockhash string
+
+	// the blockhash is a durable nonce, it doesn't expire w/ the blocks
+	nonce bool
+}
+
+// done says whether the status can still change
+func (t *TrackedTx) done() bool {
+	return t.Status == TxStatusFinalized || t.Status == TxStatusFailed || t.Status == TxStatusExpired
+}
+
+// TxTracker keeps a record of the transactions the service signed and
+// follows them through the commitment levels in the background, whoever
+// broadcasts them, polling the cluster until they finalize, fail or expire.
+// W/o a cluster the records are kept but never move. Methods are nil safe.
+type TxTracker struct {
+	// by cluster name, the default cluster's under ""
+	chains map[string]ChainClient
+	txs    map[string]*TrackedTx
+
+	// where transactions asked to be notified on are reported, nil refuses
+	// such requests
+	webhook *NotificationDispatcher
+
+	mu sync.Mutex
+}
+
+// constructor, chain is the default cluster's and may be nil
+func NewTxTracker(chain ChainClient) *TxTracker {
+	t := &TxTracker{chains: make(map[string]ChainClient), txs: make(map[string]*TrackedTx)}
+	if chain != nil {
+		t.chains[""] = chain
+	}
+	return t
+}
+
+// AddCluster follows transactions on the named cluster through chain, call
+// it before Run
+func (t *TxTracker) AddCluster(name string, chain ChainClient) {
+	t.chains[name] = chain
+}
+
+// chain is where a transaction on the cluster is looked up, nil when the
+// tracker has no endpoint for it
+func (t *TxTracker) chain(cluster string) ChainClient {
+	if chain, ok := t.chains[cluster]; ok {
+		return chain
+	}
+	return t.chains[""]
+}
+
+// Signed records a transaction the service just signed for the cluster w/
+// the memos it carries, nonce says its blockhash is a durable nonce
+func (t *TxTracker) Signed(sig, keyID, cluster, blockhash string, nonce bool, memos []string) {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+
+	defer t.mu.Unlock()
+
+	now := time.Now().UTC()
+	if _, ok := t.txs[sig]; !ok {
+		t.txs[sig] = &TrackedTx{Signature: sig, KeyID: keyID, Cluster: cluster, Memos: memos, Status: TxStatusSigned, SignedAt: now, UpdatedAt: now, blockhash: blockhash, nonce: nonce}
+	}
+}
+
+// Track marks a transaction as sent, recording it first if it wasn't yet
+func (t *TxTracker) Track(sig, keyID, cluster, blockhash string) {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+
+	defer t.mu.Unlock()
+
+	now := time.Now().UTC()
+	tx, ok := t.txs[sig]
+	if !ok {
+		tx = &TrackedTx{Signature: sig, KeyID: keyID, Cluster: cluster, Status: TxStatusSigned, SignedAt: now, blockhash: blockhash}
+		t.txs[sig] = tx
+	}
+	if tx.BroadcastAt == nil {
+		tx.BroadcastAt = &now
+	}
+	if tx.Status == TxStatusSigned {
+		tx.Status = TxStatusBroadcast
+	}
+	tx.UpdatedAt = now
+}
+
+// checkNotifyCommitment refuses a commitment a webhook can't be asked for
+func checkNotifyCommitment(c string) error {
+	if c != TxStatusProcessed && c != TxStatusConfirmed && c != TxStatusFinalized {
+		return fmt.Errorf("notifyCommitment must be %s, %s or %s", TxStatusProcessed, TxStatusConfirmed, TxStatusFinalized)
+	}
+	return nil
+}
+
+// NotifyAt has the webhook told once the recorded transaction reaches the
+// commitment or fails or expires first
+func (t *TxTracker) NotifyAt(sig, commitment string) error {
+	if t == nil || t.webhook == nil {
+		return errNoTxWebhook
+	}
+	if err := checkNotifyCommitment(commitment); err != nil {
+		return err
+	}
+	t.mu.Lock()
+
+	defer t.mu.Unlock()
+
+	tx, ok := t.txs[sig]
+	if !ok {
+		return errTxNotFound
+	}
+	tx.NotifyCommitment = commitment
+	return nil
+}
+
+// Replaced says the transaction was signed again under a new signature, its
+// expiry is no longer news
+func (t *TxTracker) Replaced(sig string) {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+
+	defer t.mu.Unlock()
+
+	if tx, ok := t.txs[sig]; ok && tx.NotifiedAt == nil {
+		tx.NotifyCommitment = ""
+	}
+}
+
+// Status returns the transaction's latest known status
+func (t *TxTracker) Status(sig string) (TrackedTx, error) {
+	if t == nil {
+		return TrackedTx{}, errTxNotFound
+	}
+	t.mu.Lock()
+
+	defer t.mu.Unlock()
+
+	tx, ok := t.txs[sig]
+	if !ok {
+		return TrackedTx{}, errTxNotFound
+	}
+	return *tx, nil
+}
+
+// History is the key's transactions still on record, newest first. A
+// memo keeps only the transactions carrying it.
+func (t *TxTracker) History(keyID, memo string) []TrackedTx {
+	if t == nil {
+		return nil
+	}
+	t.mu.Lock()
+
+	defer t.mu.Unlock()
+
+	var history []TrackedTx
+	for _, tx := range t.txs {
+		if tx.KeyID == keyID && (memo == "" || slices.Contains(tx.Memos, memo)) {
+			history = append(history, *tx)
+		}
+	}
+	slices.SortFunc(history, func(a, b TrackedTx) int { return b.SignedAt.Compare(a.SignedAt) })
+	return history
+}
+
+// Poll asks the cluster about every transaction still in flight once and
+// forgets records past retention
+func (t *TxTracker) Poll(ctx context.Context) error {
+	t.mu.Lock()
+	now := time.Now()
+	pending := make(map[ChainClient][]string)
+	for sig, tx := range t.txs {
+		if now.Sub(tx.UpdatedAt) > txRecordRetention {
+			delete(t.txs, sig)
+		} else if chain := t.chain(tx.Cluster); !tx.done() && chain != nil {
+			pending[chain] = append(pending[chain], sig)
+		}
+	}
+	t.mu.Unlock()
+
+	var errs []error
+	for chain, sigs := range pending {
+		for len(sigs) > 0 {
+			batch := sigs[:min(len(sigs), txStatusBatch)]
+			sigs = sigs[len(batch):]
+
+			statuses, err := chain.SignatureStatuses(ctx, batch)
+			if err != nil {
+				// one cluster being down doesn't hold up the others
+				errs = append(errs, err)
+				break
+			}
+			for i, sig := range batch {
+				t.update(ctx, chain, sig, statuses[i])
+			}
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// update applies what the cluster said about one transaction
+func (t *TxTracker) update(ctx context.Context, chain ChainClient, sig string, status *SignatureStatus) {
+	t.mu.Lock()
+	tx := t.txs[sig]
+	var blockhash string
+	var unseenFor time.Duration
+	var nonce bool
+	if tx != nil {
+		blockhash, unseenFor, nonce = tx.blockhash, time.Since(tx.SignedAt), tx.nonce
+	}
+	t.mu.Unlock()
+	if tx == nil {
+		return
+	}
+
+	next := ""
+	switch {
+	case status == nil:
+		// unknown to the cluster, it either never arrived or its blockhash
+		// ran out. A nonce transaction can still land any time later.
+		if unseenFor < txExpiryCheckAfter || nonce {
+			return
+		}
+		if valid, err := chain.BlockhashValid(ctx, blockhash); err != nil || valid {
+			return
+		}
+		next = TxStatusExpired
+	case status.Failed():
+		next = TxStatusFailed
+	case status.ConfirmationStatus != "":
+		next = status.ConfirmationStatus
+	default:
+		next = TxStatusProcessed
+	}
+
+	t.mu.Lock()
+
+	defer t.mu.Unlock()
+
+	changed := next != tx.Status
+	// the cluster can answer from a node that is behind, never step back
+	if txStatusRank[next] < txStatusRank[tx.Status] && next != TxStatusFailed && next != TxStatusExpired {
+		return
+	}
+	tx.Status = next
+	if status != nil {
+		tx.Slot, tx.Confirmations, tx.Err = status.Slot, status.Confirmations, status.Err
+	}
+	tx.UpdatedAt = time.Now().UTC()
+	if changed {
+		slog.InfoContext(ctx, "Transaction status changed", logSigner, "signature", sig, "key_id", tx.KeyID, "cluster", tx.Cluster, "status", next, "slot", tx.Slot)
+	}
+	if tx.NotifyCommitment != "" && tx.NotifiedAt == nil && (tx.done() || txStatusRank[next] >= txStatusRank[tx.NotifyCommitment]) {
+		t.notify(tx)
+	}
+}
+
+// notify tells the webhook where the transaction ended up, must be called
+// w/ mu held
+func (t *TxTracker) notify(tx *TrackedTx) {
+	now := time.Now().UTC()
+	tx.NotifiedAt = &now
+
+	n := Notification{
+		Kind:     NotifyTxStatus,
+		Severity: SeverityInfo,
+		Title:    "Transaction " + tx.Status,
+		Message:  fmt.Sprintf("Transaction %s is %s", tx.Signature, tx.Status),
+		KeyID:    tx.KeyID,
+		Details: map[string]string{
+			"signature":  tx.Signature,
+			"cluster":    tx.Cluster,
+			"status":     tx.Status,
+			"commitment": tx.NotifyCommitment,
+		},
+	}
+	if tx.Slot > 0 {
+		n.Details["slot"] = strconv.FormatUint(tx.Slot, 10)
+	}
+	if tx.Confirmations != nil {
+		n.Details["confirmations"] = strconv.FormatUint(*tx.Confirmations, 10)
+	}
+	if tx.Status == TxStatusFailed || tx.Status == TxStatusExpired {
+		n.Severity = SeverityWarning
+		n.Message = fmt.Sprintf("Transaction %s is %s before reaching %s", tx.Signature, tx.Status, tx.NotifyCommitment)
+	}
+	if tx.Status == TxStatusFailed {
+		n.Details["err"] = string(tx.Err)
+	}
+	t.webhook.Dispatch(n)
+}
+
+// Run polls every interval until ctx is done
+func (t *TxTracker) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := t.Poll(ctx); err != nil {
+				slog.Warn("Transaction status poll failed", logSigner, "err", err)
+			}
+		}
+	}
+}
+
+// handleTxStatus serves the record of a transaction the service signed to
+// operators and to API tokens scoped to read the key that signed it
+func (s *APIServer) handleTxStatus(w http.ResponseWriter, r *http.Request) {
+	tx, err := s.Txs.Status(r.PathValue("signature"))
+	if err != nil {
+		writeError(w, r, http.StatusNotFound, err)
+		return
+	}
+	if OperatorFromContext(r.Context()).Name == "" {
+		if err := s.checkAPIToken(r, TokenOpRead, tx.KeyID); err != nil {
+			refuseAPIToken(w, r, err)
+			return
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(tx)
+}
+
+// handleTxHistory serves a key's signed transactions, ?memo= narrows them
+// to one reference, to operators and API tokens scoped to read the key
+func (s *APIServer) handleTxHistory(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+	if OperatorFromContext(r.Context()).Name == "" {
+		if err := s.checkAPIToken(r, TokenOpRead, id); err != nil {
+			refuseAPIToken(w, r, err)
+			return
+		}
+	}
+
+	history := s.Txs.History(id, r.URL.Query().Get("memo"))
+	if history == nil {
+		history = []TrackedTx{}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(history)
+}
@@ -0,0 +1,42 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"os"
+)
+
+// listenUnix listens on a Unix socket at path w/ mode, replacing a stale
+// socket left behind by a crash
+func listenUnix(path string, mode os.FileMode) (net.Listener, error) {
+	if fi, err := os.Lstat(path); err == nil {
+		if fi.Mode()&os.ModeSocket == 0 {
+			return nil, fmt.Errorf("%s exists and is not a socket", path)
+		}
+		// another instance still answers on it
+		if conn, err := net.Dial("unix", path); err == nil {
+			conn.Close()
+			return nil, fmt.Errorf("%s is in use", path)
+		}
+		if err := os.Remove(path); err != nil {
+			return nil, fmt.Errorf("failed to remove stale socket: %w", err)
+		}
+	}
+
+	lis, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, err
+	}
+	// the socket's permissions are its only access control
+	if err := os.Chmod(path, mode); err != nil {
+		lis.Close()
+		return nil, fmt.Errorf("failed to set socket permissions: %w", err)
+	}
+	return lis, nil
+}
+
+func closeAll(listeners []net.Listener) {
+	for _, lis := range listeners {
+		lis.Close()
+	}
+}
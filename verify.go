@@ -0,0 +1,63 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+)
+
+type VerifyRequest struct {
+	KeyType   string `json:"keyType,omitempty"`
+	PublicKey string `json:"publicKey"`
+	Message   string `json:"message"`
+	Signature string `json:"signature"`
+
+	// ed25519 variant and purpose context the signature was produced with
+	SigningMode string `json:"signingMode,omitempty"`
+	Context     string `json:"context,omitempty"`
+}
+
+type VerifyResult struct {
+	KeyType string `json:"keyType"`
+	Valid   bool   `json:"valid"`
+}
+
+// VerifySignature checks a signature against a hex public key and base64
+// message, an invalid signature is not an error
+func (s *signerService) VerifySignature(ctx context.Context, req VerifyRequest) (VerifyResult, error) {
+	if req.PublicKey == "" || req.Message == "" || req.Signature == "" {
+		return VerifyResult{}, errors.New("publicKey, message and signature cannot be empty")
+	}
+
+	keyType := req.KeyType
+	if keyType == "" {
+		keyType = KeyTypeEd25519
+	}
+
+	pubKey, err := hex.DecodeString(req.PublicKey)
+	if err != nil {
+		return VerifyResult{}, fmt.Errorf("Invalid hex encoding of public key: %w", err)
+	}
+
+	msg, err := base64.StdEncoding.DecodeString(req.Message)
+	if err != nil {
+		return VerifyResult{}, fmt.Errorf("Invalid base64 encoding of message: %w", err)
+	}
+
+	sig, err := base64.StdEncoding.DecodeString(req.Signature)
+	if err != nil {
+		return VerifyResult{}, fmt.Errorf("Invalid base64 encoding of signature: %w", err)
+	}
+
+	result := VerifyResult{KeyType: keyType}
+
+	result.Valid, err = verifyWithKey(keyType, pubKey, msg, sig, req.SigningMode, req.Context)
+	if err != nil {
+		return result, err
+	}
+	s.meter.Record(ctx, MeterVerify)
+
+	return result, nil
+}